@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+
+	"github.com/yaat-app/sidecar/internal/config"
+	"github.com/yaat-app/sidecar/internal/paths"
+	"gopkg.in/yaml.v3"
+)
+
+// dumpConfig writes cfg as the daemon runs with it, in format (yaml or
+// json). It is called after defaults, environment templates and detected
+// tags were applied. The instance's data paths head the YAML output as
+// comments. Keys that were ignored are listed on warnOut afterwards, so
+// the dump itself stays parseable.
+func dumpConfig(out, warnOut io.Writer, cfg *config.Config, layout paths.Layout, format string, redact bool) error {
+	effective := cfg
+	if redact {
+		effective = cfg.Redacted()
+	}
+	data, err := yaml.Marshal(effective)
+	if err != nil {
+		return fmt.Errorf("encode config: %w", err)
+	}
+
+	switch format {
+	case "", "yaml":
+		fmt.Fprintf(out, "# Effective configuration loaded from %s\n", cfg.SourcePath)
+		fmt.Fprintf(out, "# Data directory: %s (queue: %s, state: %s)\n", layout.DataDir, resolveQueueDir(layout), layout.StateFile)
+		out.Write(data)
+	case "json":
+		// Through YAML, so keys match the file
+		var doc interface{}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("encode config: %w", err)
+		}
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(doc); err != nil {
+			return fmt.Errorf("encode config: %w", err)
+		}
+	default:
+		return fmt.Errorf("unknown format %q (use yaml or json)", format)
+	}
+
+	for _, key := range cfg.UnknownKeys {
+		fmt.Fprintf(warnOut, "⚠ %s: not a known option, ignored\n", key)
+	}
+	return nil
+}
+
+// ownedByCurrentUser reports whether path belongs to the user running the
+// sidecar, who may then see the secrets in it unmasked.
+func ownedByCurrentUser(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return ok && int(stat.Uid) == os.Getuid()
+}
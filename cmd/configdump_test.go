@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yaat-app/sidecar/internal/config"
+	"github.com/yaat-app/sidecar/internal/paths"
+)
+
+func TestDumpConfig(t *testing.T) {
+	t.Setenv("YAAT_TEST_REGION", "eu-west-1")
+	path := filepath.Join(t.TempDir(), "yaat.yaml")
+	content := `api_key: yaat_0123456789abcdef
+organization_id: org
+api_endpoint: https://example.test/ingest
+service_name: svc
+tags:
+  region: ${YAAT_TEST_REGION}
+buffer_sise: 10
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	layout := paths.Layout{DataDir: "/data", QueueDir: "/data/queue", StateFile: "/data/state.json"}
+
+	var out, warnings bytes.Buffer
+	if err := dumpConfig(&out, &warnings, cfg, layout, "yaml", true); err != nil {
+		t.Fatalf("Failed to dump config: %v", err)
+	}
+	dump := out.String()
+	for _, want := range []string{"flush_interval: 10s", "environment: production", "region: eu-west-1", "api_key: yaat_01***", "/data/queue"} {
+		if !strings.Contains(dump, want) {
+			t.Errorf("Expected %q in the dump, got:\n%s", want, dump)
+		}
+	}
+	if strings.Contains(dump, "0123456789abcdef") {
+		t.Error("Expected api_key to be masked by default")
+	}
+	if !strings.Contains(warnings.String(), "buffer_sise") {
+		t.Errorf("Expected a warning for buffer_sise, got %q", warnings.String())
+	}
+
+	out.Reset()
+	if err := dumpConfig(&out, &bytes.Buffer{}, cfg, layout, "json", false); err != nil {
+		t.Fatalf("Failed to dump config as JSON: %v", err)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &doc); err != nil {
+		t.Fatalf("Expected valid JSON, got %v:\n%s", err, out.String())
+	}
+	if doc["api_key"] != "yaat_0123456789abcdef" || doc["buffer_size"] != float64(1000) {
+		t.Errorf("Expected the unmasked key and default buffer_size, got %v and %v", doc["api_key"], doc["buffer_size"])
+	}
+
+	if err := dumpConfig(&out, &bytes.Buffer{}, cfg, layout, "toml", true); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+	if !ownedByCurrentUser(path) {
+		t.Error("Expected the test's own config file to be owned by the current user")
+	}
+}
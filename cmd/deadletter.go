@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/queue"
+)
+
+// runListDeadLetter implements --list-dlq: one line per dead-lettered batch
+// with the reason its delivery failed.
+func runListDeadLetter(dir string, out io.Writer) error {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		fmt.Fprintf(out, "ℹ️ No queue directory at %s\n", dir)
+		return nil
+	}
+	// Read-only: batches a running sidecar is sending stay where they are
+	store, err := queue.Open(dir)
+	if err != nil {
+		return fmt.Errorf("open queue: %w", err)
+	}
+	batches, err := store.DeadLetterBatches()
+	if err != nil {
+		return err
+	}
+	if len(batches) == 0 {
+		fmt.Fprintln(out, "✓ The dead-letter queue is empty")
+		return nil
+	}
+
+	fmt.Fprintf(out, "%d dead-letter batches in %s:\n", len(batches), store.DeadLetterDir())
+	for _, b := range batches {
+		fmt.Fprintf(out, "\n%s (%d events, created %s)\n", filepath.Base(b.Path), b.Events, b.CreatedAt.Local().Format(time.DateTime))
+		if b.Failure == nil {
+			fmt.Fprintln(out, "  Reason: not recorded")
+			continue
+		}
+		reason := b.Failure.Error
+		if b.Failure.StatusCode != 0 {
+			reason = fmt.Sprintf("HTTP %d: %s", b.Failure.StatusCode, reason)
+		}
+		fmt.Fprintf(out, "  Reason:   %s\n", reason)
+		fmt.Fprintf(out, "  Attempts: %d, first failed %s, last failed %s\n", b.Failure.Attempts,
+			b.Failure.FirstFailure.Local().Format(time.DateTime), b.Failure.LastFailure.Local().Format(time.DateTime))
+	}
+	return nil
+}
+
+// runReplayDeadLetter implements --replay-dlq. The batches go back to the
+// pending queue, which the sidecar drains when it starts. The caller makes
+// sure the sidecar is stopped: its drain moves the same files.
+func runReplayDeadLetter(dir string, out io.Writer) error {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		fmt.Fprintf(out, "ℹ️ No queue directory at %s, nothing to replay\n", dir)
+		return nil
+	}
+	store, err := queue.New(dir)
+	if err != nil {
+		return fmt.Errorf("open queue: %w", err)
+	}
+	replayed, err := store.ReplayDeadLetter()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "✓ Moved %d dead-letter batches (%d events) back to the queue\n", replayed.Batches, replayed.Events)
+	return nil
+}
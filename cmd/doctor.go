@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io"
+
+	"github.com/yaat-app/sidecar/internal/config"
+	"github.com/yaat-app/sidecar/internal/daemon"
+	"github.com/yaat-app/sidecar/internal/doctor"
+	"github.com/yaat-app/sidecar/internal/paths"
+)
+
+// runDoctor checks the configuration at configPath and the host, prints the
+// report to w and reports whether every check passed. Port checks are left
+// out while the sidecar itself holds the ports.
+func runDoctor(w io.Writer, configPath string, healthPort int, layout paths.Layout) bool {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		doctor.Print(w, []doctor.Result{{
+			Name:   "Config",
+			Status: doctor.Fail,
+			Detail: err.Error(),
+			Hint:   "Fix the file, or run yaat-sidecar --setup to generate one",
+		}})
+		return false
+	}
+
+	results := doctor.Run(cfg, doctor.Options{
+		HealthAddr: cfg.Health.Addr(healthPort),
+		QueueDir:   resolveQueueDir(layout),
+		SkipPorts:  daemon.IsRunning(layout),
+	})
+	doctor.Print(w, results)
+	return !doctor.Failed(results)
+}
+
+// logStartupChecks logs the --doctor checks that do not pass. Ports are
+// skipped since the sidecar binds them itself and reports its own errors.
+func logStartupChecks(cfg *config.Config, layout paths.Layout) {
+	results := doctor.Run(cfg, doctor.Options{QueueDir: resolveQueueDir(layout), SkipPorts: true})
+	for _, r := range results {
+		if r.Status != doctor.Warn && r.Status != doctor.Fail {
+			continue
+		}
+		if r.Hint != "" {
+			logger.Warnf("Self-check %s: %s (%s)", r.Name, r.Detail, r.Hint)
+		} else {
+			logger.Warnf("Self-check %s: %s", r.Name, r.Detail)
+		}
+	}
+}
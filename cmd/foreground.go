@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/diag"
+)
+
+// statusLineInterval is how often --foreground redraws the status line.
+const statusLineInterval = 2 * time.Second
+
+// clearLine returns the cursor to the start of the line and erases it.
+const clearLine = "\r\033[K"
+
+// statusLine keeps a one-line delivery summary at the bottom of the
+// terminal. Log output is written through it: the line is erased before each
+// entry and redrawn after, so the two never share a line.
+type statusLine struct {
+	mu   sync.Mutex
+	out  io.Writer
+	text string
+}
+
+func newStatusLine(out io.Writer) *statusLine {
+	return &statusLine{out: out}
+}
+
+func (s *statusLine) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.text != "" {
+		io.WriteString(s.out, clearLine)
+	}
+	n, err := s.out.Write(p)
+	if s.text != "" {
+		io.WriteString(s.out, s.text)
+	}
+	return n, err
+}
+
+// Set replaces the status line.
+func (s *statusLine) Set(text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.text = text
+	io.WriteString(s.out, clearLine+text)
+}
+
+// Clear erases the status line and stops redrawing it.
+func (s *statusLine) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.text != "" {
+		io.WriteString(s.out, clearLine)
+	}
+	s.text = ""
+}
+
+// Start redraws the line from diag every interval. The returned func stops
+// redrawing and erases the line.
+func (s *statusLine) Start(interval time.Duration) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		s.Set(formatStatusLine(diag.Global().Snapshot(), time.Now()))
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				s.Set(formatStatusLine(diag.Global().Snapshot(), now))
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+		s.Clear()
+	}
+}
+
+// formatStatusLine summarizes delivery in one line, like the dashboard's
+// Delivery section.
+func formatStatusLine(snap diag.Snapshot, now time.Time) string {
+	line := fmt.Sprintf("▸ %d sent", snap.TotalEventsSent)
+	if snap.TotalEventsFailed > 0 {
+		line += fmt.Sprintf(", %d failed", snap.TotalEventsFailed)
+	}
+	line += fmt.Sprintf(" │ queue %d", snap.QueueLength)
+	if snap.PersistedEvents > 0 {
+		line += fmt.Sprintf(" (%d on disk)", snap.PersistedEvents)
+	}
+	if snap.LastSuccessAt.IsZero() {
+		line += " │ no delivery yet"
+	} else {
+		line += " │ last success " + formatAgo(now.Sub(snap.LastSuccessAt))
+	}
+	if snap.LastFailureAt.After(snap.LastSuccessAt) && snap.LastError != "" {
+		line += " │ last error " + formatAgo(now.Sub(snap.LastFailureAt))
+	}
+	return line
+}
+
+func formatAgo(d time.Duration) string {
+	switch {
+	case d < time.Second:
+		return "just now"
+	case d < time.Minute:
+		return fmt.Sprintf("%ds ago", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d.Minutes()))
+	default:
+		return fmt.Sprintf("%dh ago", int(d.Hours()))
+	}
+}
+
+// isTerminal reports whether f is a character device such as a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/diag"
+)
+
+func TestFormatStatusLine(t *testing.T) {
+	now := time.Now()
+	line := formatStatusLine(diag.Snapshot{}, now)
+	if line != "▸ 0 sent │ queue 0 │ no delivery yet" {
+		t.Errorf("Unexpected idle status line: %q", line)
+	}
+
+	line = formatStatusLine(diag.Snapshot{
+		TotalEventsSent:   1200,
+		TotalEventsFailed: 3,
+		QueueLength:       40,
+		PersistedEvents:   25,
+		LastSuccessAt:     now.Add(-5 * time.Second),
+		LastFailureAt:     now.Add(-2 * time.Second),
+		LastError:         "503",
+	}, now)
+	for _, want := range []string{"1200 sent", "3 failed", "queue 40 (25 on disk)", "last success 5s ago", "last error 2s ago"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("Expected %q in status line %q", want, line)
+		}
+	}
+}
+
+func TestStatusLineRedrawsAfterLogOutput(t *testing.T) {
+	var out bytes.Buffer
+	status := newStatusLine(&out)
+
+	status.Write([]byte("before\n"))
+	status.Set("▸ 1 sent")
+	status.Write([]byte("log entry\n"))
+	status.Clear()
+	status.Write([]byte("after\n"))
+
+	want := "before\n" + clearLine + "▸ 1 sent" + clearLine + "log entry\n▸ 1 sent" + clearLine + "after\n"
+	if out.String() != want {
+		t.Errorf("Expected %q, got %q", want, out.String())
+	}
+}
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"flag"
 	"fmt"
-	"log"
+	"io"
+	"math"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -13,21 +17,38 @@ import (
 	"github.com/yaat-app/sidecar/internal/analytics"
 	"github.com/yaat-app/sidecar/internal/buffer"
 	"github.com/yaat-app/sidecar/internal/config"
+	"github.com/yaat-app/sidecar/internal/control"
+	"github.com/yaat-app/sidecar/internal/correlate"
 	"github.com/yaat-app/sidecar/internal/daemon"
 	"github.com/yaat-app/sidecar/internal/detection"
 	"github.com/yaat-app/sidecar/internal/diag"
+	"github.com/yaat-app/sidecar/internal/embedding"
 	"github.com/yaat-app/sidecar/internal/forwarder"
 	"github.com/yaat-app/sidecar/internal/health"
+	"github.com/yaat-app/sidecar/internal/heartbeat"
+	"github.com/yaat-app/sidecar/internal/localapi"
+	"github.com/yaat-app/sidecar/internal/logging"
 	"github.com/yaat-app/sidecar/internal/logs"
 	"github.com/yaat-app/sidecar/internal/metrics"
+	"github.com/yaat-app/sidecar/internal/otlp"
+	"github.com/yaat-app/sidecar/internal/paths"
+	"github.com/yaat-app/sidecar/internal/pipeline"
 	"github.com/yaat-app/sidecar/internal/proxy"
 	"github.com/yaat-app/sidecar/internal/queue"
+	"github.com/yaat-app/sidecar/internal/routing"
 	"github.com/yaat-app/sidecar/internal/scrubber"
 	"github.com/yaat-app/sidecar/internal/selfupdate"
 	"github.com/yaat-app/sidecar/internal/setup"
+	"github.com/yaat-app/sidecar/internal/spansample"
 	"github.com/yaat-app/sidecar/internal/state"
 	"github.com/yaat-app/sidecar/internal/statsd"
 	"github.com/yaat-app/sidecar/internal/tui"
+	"github.com/yaat-app/sidecar/pkg/sidecar"
+)
+
+var (
+	logger          = logging.New("Sidecar")
+	analyticsLogger = logging.New("Analytics")
 )
 
 const version = "0.0.11-alpha"
@@ -39,27 +60,74 @@ func main() {
 		showVersion    = flag.Bool("version", false, "Show version and exit")
 		daemonMode     = flag.Bool("daemon", false, "Run in background (daemon mode)")
 		daemonShort    = flag.Bool("d", false, "Run in background (short flag)")
+		foreground     = flag.Bool("foreground", false, "When running attached to a terminal, keep a live status line with events sent, queue depth and last success")
 		logFile        = flag.String("log-file", "", "Write logs to file instead of stderr")
+		logFormat      = flag.String("log-format", "", "Sidecar log format: text or json (overrides log_format)")
+		logLevel       = flag.String("log-level", "", "Minimum sidecar log level: debug, info, warn or error (overrides log_level)")
 		verbose        = flag.Bool("verbose", false, "Enable verbose/debug logging")
 		verboseShort   = flag.Bool("v", false, "Enable verbose/debug logging (short flag)")
 		initConfig     = flag.Bool("init", false, "Create sample configuration file")
 		validateCfg    = flag.Bool("validate", false, "Validate configuration and exit")
+		printConfig    = flag.Bool("print-config", false, "Print the effective configuration as YAML (alias of --config-dump)")
+		configDump     = flag.Bool("config-dump", false, "Print the effective configuration (defaults, environment and detected tags applied, secrets masked) and exit")
+		redactSecrets  = flag.Bool("redact", true, "With --config-dump, mask secrets; --redact=false shows them when you own the config file")
 		testAPIFlag    = flag.Bool("test", false, "Test API connection and exit")
+		runChecks      = flag.Bool("doctor", false, "Check the configuration and host for common problems and exit")
+		rotateKey      = flag.Bool("rotate-key", false, "Check a new API key (given as the next argument, or prompted for), write it to the config and switch the running sidecar to it")
+		sendFile       = flag.String("send-file", "", "Parse this log file (plain or .gz), send it once to YAAT and exit")
+		readStdin      = flag.Bool("stdin", false, "Parse log lines from stdin until EOF, send them once to YAAT and exit")
+		sendFormat     = flag.String("format", "", "With --send-file or --stdin, the log format (django, nginx, apache, json, ...); with --config-dump, yaml or json")
+		sendService    = flag.String("service-name", "", "With --send-file or --stdin, override service_name")
+		sendEnv        = flag.String("environment", "", "With --send-file or --stdin, override environment")
+		runOnceFlag    = flag.Bool("once", false, "Send log lines written since the last --once run and the persistent queue, then exit (non-zero if anything is left undelivered); for cron")
 		uninstall      = flag.Bool("uninstall", false, "Uninstall sidecar and cleanup")
 		uninstallAlias = flag.Bool("uninsatll", false, "Uninstall sidecar (alias)")
+		dryRun         = flag.Bool("dry-run", false, "With --uninstall, list what would be removed without removing anything")
+		keepData       = flag.Bool("keep-data", false, "With --uninstall, keep state, queue and analytics data")
+		keepConfig     = flag.Bool("keep-config", false, "With --uninstall, keep configuration files")
 		setupWizard    = flag.Bool("setup", false, "Launch interactive setup wizard")
 		updateBinary   = flag.Bool("update", false, "Update sidecar to the latest release")
 		startService   = flag.Bool("start", false, "Start sidecar as background service")
 		stopService    = flag.Bool("stop", false, "Stop background sidecar service")
 		restartService = flag.Bool("restart", false, "Restart background sidecar service")
 		statusService  = flag.Bool("status", false, "Show background service status")
-		healthPort     = flag.Int("health-port", 0, "Enable health check endpoint on this port")
+		tailLog        = flag.Bool("tail", false, "Print the end of the sidecar's own log and follow it")
+		tailLines      = flag.Int("lines", 50, "With --tail, number of existing lines to print first")
+		tailGrep       = flag.String("grep", "", "With --tail, only show lines matching this regex or substring")
+		healthPort     = flag.Int("health-port", 0, "Enable health check endpoint on this port (overrides health.port)")
+		healthUI       = flag.Bool("health-ui", false, "Also serve a browser status page at /ui on the health endpoint")
 		dashboardUI    = flag.Bool("dashboard", false, "Launch interactive dashboard (TUI)")
 		uiAlias        = flag.Bool("ui", false, "Launch interactive dashboard (alias)")
+		flushNow       = flag.Bool("flush-now", false, "Ask the running sidecar to flush its buffer and drain the queue")
+		drainAlias     = flag.Bool("drain", false, "Ask the running sidecar to flush and drain (alias)")
+		eventsTail     = flag.Bool("events-tail", false, "Print the events the running sidecar buffers, one JSON object per line, until interrupted (e.g. | jq)")
+		purgeDLQ       = flag.Bool("purge-dlq", false, "Delete every dead-lettered batch in the queue directory")
+		listDLQ        = flag.Bool("list-dlq", false, "List dead-lettered batches with the reason their delivery failed")
+		replayDLQ      = flag.Bool("replay-dlq", false, "Move every dead-lettered batch back to the queue for another delivery attempt")
+		resetQueue     = flag.Bool("reset-queue", false, "Delete every pending and dead-lettered batch in the queue directory")
+		assumeYes      = flag.Bool("yes", false, "With --purge-dlq or --reset-queue, skip the confirmation prompt")
+		testScrub      = flag.Bool("test-scrub", false, "Apply one scrub rule to --input (or each line of stdin), print the result and exit")
+		scrubPattern   = flag.String("pattern", "", "With --test-scrub, the rule's regular expression")
+		scrubInput     = flag.String("input", "", "With --test-scrub, the sample value; stdin is read when empty")
+		scrubField     = flag.String("field", "message", "With --test-scrub, the field the input goes in (message, tags.<key>, ...)")
+		scrubReplace   = flag.String("replacement", "[REDACTED]", "With --test-scrub, the rule's replacement")
+		scrubDrop      = flag.Bool("drop", false, "With --test-scrub, drop matching events instead of replacing")
+		stress         = flag.Bool("stress", false, "Generate synthetic events and report ingest throughput")
+		stressRate     = flag.Int("rate", 1000, "With --stress, events generated per second")
+		stressDuration = flag.Duration("duration", 30*time.Second, "With --stress, how long to generate events")
+		stressReal     = flag.Bool("stress-real", false, "With --stress, deliver to the configured API instead of a no-op endpoint")
 	)
+	flag.Usage = usage
 	flag.Parse()
 
 	isVerbose := *verbose || *verboseShort
+
+	// Where this instance keeps its PID file, log, queue, state and analytics
+	layout := paths.Detect().Resolve(*instanceName)
+	if *logFile != "" {
+		layout = layout.WithLogFile(*logFile)
+	}
+	state.SetPath(layout.StateFile)
 	isDaemon := *daemonMode || *daemonShort || *startService
 
 	// Check if no flags were provided - if so, launch dashboard
@@ -122,11 +190,15 @@ func main() {
 
 	// Handle uninstall flag
 	if *uninstall || *uninstallAlias {
-		warnings, err := daemon.Uninstall()
+		opts := daemon.UninstallOptions{DryRun: *dryRun, KeepData: *keepData, KeepConfig: *keepConfig}
+		warnings, err := daemon.Uninstall(opts)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Uninstall failed: %v\n", err)
 			os.Exit(1)
 		}
+		if opts.DryRun {
+			os.Exit(0)
+		}
 		if len(warnings) > 0 {
 			fmt.Println("✓ YAAT Sidecar uninstalled with warnings")
 		} else {
@@ -137,8 +209,7 @@ func main() {
 
 	// Handle stop flag
 	if *stopService {
-		pidPath := getInstancePIDPath(*instanceName)
-		if err := daemon.Stop(pidPath); err != nil {
+		if err := daemon.Stop(layout, stopTimeout(*configPath)); err != nil {
 			if isNotRunningError(err) {
 				fmt.Println("ℹ️ Sidecar is not running")
 				os.Exit(0)
@@ -152,55 +223,157 @@ func main() {
 
 	// Handle status flag
 	if *statusService {
-		pidPath := getInstancePIDPath(*instanceName)
-		logPath := getInstanceLogPath(*instanceName)
-		if daemon.IsRunning(pidPath) {
+		if daemon.IsRunning(layout) {
 			pid := "unknown"
-			if data, err := os.ReadFile(daemon.GetPidPath(pidPath)); err == nil {
+			if data, err := os.ReadFile(daemon.GetPidPath(layout)); err == nil {
 				if trimmed := strings.TrimSpace(string(data)); trimmed != "" {
 					pid = trimmed
 				}
 			}
 			fmt.Printf("✓ YAAT Sidecar is running (PID %s)\n", pid)
-			fmt.Printf("  Logs: %s\n", daemon.GetLogPath(logPath))
+			fmt.Printf("  Logs: %s\n", resolveTailLogPath(layout))
+			fmt.Println("  Follow them with: yaat-sidecar --tail")
 		} else {
 			fmt.Println("✗ YAAT Sidecar is not running")
 		}
 		os.Exit(0)
 	}
 
+	// Handle tail flag
+	if *tailLog {
+		if err := runTail(resolveTailLogPath(layout), *tailLines, *tailGrep); err != nil {
+			fmt.Fprintf(os.Stderr, "✗ %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// Handle flush-now flag
+	if *flushNow || *drainAlias {
+		socketPath := control.SocketPath(layout)
+		result, err := control.RequestFlush(socketPath, 2*time.Minute)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "✗ Flush failed: %v\n", err)
+			if result.Flushed > 0 || result.Drained > 0 {
+				fmt.Fprintf(os.Stderr, "  Flushed %d buffered events, drained %d queued events before the failure\n", result.Flushed, result.Drained)
+			}
+			os.Exit(1)
+		}
+		fmt.Printf("✓ Flushed %d buffered events, drained %d queued events\n", result.Flushed, result.Drained)
+		os.Exit(0)
+	}
+
+	if *eventsTail {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := control.StreamEvents(ctx, control.SocketPath(layout), os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "✗ %v\n", err)
+			fmt.Fprintln(os.Stderr, "  Is the sidecar running? Start it with `yaat-sidecar --start`.")
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// Handle dead-letter inspection flags
+	if *listDLQ || *replayDLQ {
+		run := runListDeadLetter
+		if *replayDLQ {
+			if daemon.IsRunning(layout) {
+				fmt.Fprintln(os.Stderr, "✗ The sidecar is running and delivers from this queue; stop it first (yaat-sidecar --stop)")
+				os.Exit(1)
+			}
+			run = runReplayDeadLetter
+		}
+		if err := run(resolveQueueDir(layout), os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "✗ %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// Handle queue maintenance flags
+	if *purgeDLQ || *resetQueue {
+		if err := runQueueMaintenance(*resetQueue, *assumeYes, layout); err != nil {
+			fmt.Fprintf(os.Stderr, "✗ %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// Handle test-scrub flag
+	if *testScrub {
+		var in io.Reader = os.Stdin
+		if *scrubInput != "" {
+			in = strings.NewReader(*scrubInput)
+		}
+		err := runTestScrub(testScrubOptions{
+			Pattern:     *scrubPattern,
+			Replacement: *scrubReplace,
+			Field:       *scrubField,
+			Drop:        *scrubDrop,
+		}, in, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "✗ %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// Handle doctor flag
+	if *runChecks {
+		if !runDoctor(os.Stdout, *configPath, *healthPort, layout) {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// Handle rotate-key flag
+	if *rotateKey {
+		newKey := flag.Arg(0)
+		if newKey == "" {
+			var err error
+			if newKey, err = promptAPIKey(); err != nil {
+				fmt.Fprintf(os.Stderr, "✗ %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if err := runRotateKey(*configPath, newKey, control.SocketPath(layout), os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "✗ %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	// Handle restart flag
 	if *restartService {
-		pidPath := getInstancePIDPath(*instanceName)
-		logPath := getInstanceLogPath(*instanceName)
 		cfg, err := config.LoadConfig(*configPath)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
 			os.Exit(1)
 		}
-		if daemon.IsRunning(pidPath) {
-			if err := daemon.Stop(pidPath); err != nil && !isNotRunningError(err) {
+		if daemon.IsRunning(layout) {
+			if err := daemon.Stop(layout, cfg.ShutdownTimeoutDuration+stopGrace); err != nil && !isNotRunningError(err) {
 				fmt.Fprintf(os.Stderr, "Failed to stop running sidecar: %v\n", err)
 				os.Exit(1)
 			}
 			fmt.Println("✓ Stopped existing sidecar")
 		}
-		if err := daemon.Start(cfg.SourcePath, *logFile, pidPath, isVerbose); err != nil {
+		if err := daemon.Start(cfg.SourcePath, layout, isVerbose, daemonArgs(*logFormat, *logLevel)...); err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to start sidecar: %v\n", err)
 			os.Exit(1)
 		}
 		fmt.Println("✓ Sidecar restarted in background")
-		fmt.Printf("  Logs: %s\n", daemon.GetLogPath(logPath))
+		fmt.Printf("  Logs: %s\n", layout.LogFile)
 		os.Exit(0)
 	}
 
 	// Setup logging
-	setupLogging(*logFile, isVerbose)
+	setupLogging(*logFile, *logFormat, *logLevel, isVerbose)
 
 	// Recover from any panics
 	defer func() {
 		if r := recover(); r != nil {
-			log.Printf("[PANIC] Sidecar crashed: %v", r)
+			logger.Errorf("Sidecar crashed: %v", r)
 			os.Exit(1)
 		}
 	}()
@@ -208,39 +381,89 @@ func main() {
 	// Load configuration
 	cfg, err := config.LoadConfig(*configPath)
 	if err != nil {
-		log.Fatalf("[Sidecar] Failed to load config: %v\nRun `yaat-sidecar --setup` to generate one.", err)
+		logger.Fatalf("Failed to load config: %v\nRun `yaat-sidecar --setup` to generate one.", err)
 	}
 	if err := scrubber.Configure(cfg.Scrubbing); err != nil {
-		log.Fatalf("[Sidecar] Failed to configure scrubbing: %v", err)
+		logger.Fatalf("Failed to configure scrubbing: %v", err)
+	}
+	if err := routing.Configure(cfg.Routing); err != nil {
+		logger.Fatalf("Failed to configure routing: %v", err)
+	}
+	if *logFormat == "" && cfg.LogFormat != "" {
+		format, err := logging.ParseFormat(cfg.LogFormat)
+		if err != nil {
+			logger.Fatalf("Invalid log_format: %v", err)
+		}
+		logging.SetFormat(format)
+	}
+	if *logLevel == "" && !isVerbose && cfg.LogLevel != "" {
+		level, err := logging.ParseLevel(cfg.LogLevel)
+		if err != nil {
+			logger.Fatalf("Invalid log_level: %v", err)
+		}
+		logging.SetLevel(level)
 	}
 	resolvedConfigPath := cfg.SourcePath
+	if cfg.Analytics.DatabasePath == config.DefaultAnalyticsPath() {
+		cfg.Analytics.DatabasePath = layout.AnalyticsDB
+	}
 
 	// Detect cloud provider and Kubernetes metadata at runtime
-	cloudMetadata := detection.DetectCloudProvider()
-	k8sMetadata := detection.DetectKubernetesMetadata()
-
-	// Merge detected tags into config (config tags take priority)
-	if cfg.Tags == nil {
-		cfg.Tags = make(map[string]string)
+	cloudMetadata := detection.DetectCloud(cfg.Detection.Cloud, cfg.Detection.TimeoutDuration, nil)
+	var k8sMetadata *detection.KubernetesMetadata
+	if cfg.Detection.Kubernetes != "off" {
+		labels := cfg.Detection.PodLabels
+		if labels == nil {
+			labels = detection.DefaultPodLabels
+		}
+		k8sMetadata = detection.DetectKubernetes(detection.PodInfoOptions{
+			Dir:         cfg.Detection.PodInfoPath,
+			Labels:      labels,
+			Annotations: cfg.Detection.PodAnnotations,
+		})
 	}
-	if cloudMetadata != nil {
-		for k, v := range cloudMetadata.Tags {
-			if _, exists := cfg.Tags[k]; !exists {
-				cfg.Tags[k] = v
-			}
+
+	mergeDetectedTags(cfg, cloudMetadata, k8sMetadata)
+
+	if *printConfig || *configDump {
+		format := ""
+		if *configDump {
+			format = *sendFormat
+		}
+		redact := *redactSecrets
+		if !redact && !ownedByCurrentUser(resolvedConfigPath) {
+			fmt.Fprintf(os.Stderr, "⚠ %s is not owned by you; secrets stay masked\n", resolvedConfigPath)
+			redact = true
+		}
+		if err := dumpConfig(os.Stdout, os.Stderr, cfg, layout, format, redact); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to dump config: %v\n", err)
+			os.Exit(1)
 		}
+		os.Exit(0)
 	}
-	if k8sMetadata != nil {
-		for k, v := range k8sMetadata.Tags {
-			if _, exists := cfg.Tags[k]; !exists {
-				cfg.Tags[k] = v
-			}
+
+	// Handle stress flag
+	if *stress {
+		target := "a no-op endpoint"
+		if *stressReal {
+			target = cfg.APIEndpoint
+		}
+		fmt.Printf("Generating %d events/s for %v against %s...\n", *stressRate, *stressDuration, target)
+		report, err := runStress(cfg, *stressRate, *stressDuration, *stressReal)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "✗ Stress run failed: %v\n", err)
+			os.Exit(1)
 		}
+		report.print(os.Stdout, *stressRate)
+		os.Exit(0)
 	}
 
 	// Handle validate flag
 	if *validateCfg {
 		fmt.Println("✓ Configuration is valid")
+		for _, w := range cfg.Warnings() {
+			fmt.Printf("  ⚠ %s\n", w)
+		}
 		fmt.Printf("  Service: %s\n", cfg.ServiceName)
 		fmt.Printf("  Environment: %s\n", cfg.Environment)
 		fmt.Printf("  API Endpoint: %s\n", cfg.APIEndpoint)
@@ -255,6 +478,11 @@ func main() {
 		fmt.Printf("  Host metrics interval: %s\n", cfg.Metrics.Interval)
 		fmt.Printf("  StatsD enabled: %t\n", cfg.Metrics.StatsD.Enabled)
 		fmt.Printf("  StatsD listen addr: %s\n", cfg.Metrics.StatsD.ListenAddr)
+		fmt.Printf("  Detection: cloud=%s kubernetes=%s", cfg.Detection.Cloud, cfg.Detection.Kubernetes)
+		if len(cfg.Detection.Overrides) > 0 {
+			fmt.Printf(" (%d overrides)", len(cfg.Detection.Overrides))
+		}
+		fmt.Println()
 
 		// Display detected cloud provider and Kubernetes metadata
 		if cloudMetadata != nil && cloudMetadata.Provider != "unknown" {
@@ -270,6 +498,8 @@ func main() {
 			if cloudMetadata.InstanceID != "" {
 				fmt.Printf("    Instance ID: %s\n", cloudMetadata.InstanceID)
 			}
+		} else if cfg.Detection.Cloud == "off" {
+			fmt.Printf("\n  Cloud Provider: Detection off\n")
 		} else {
 			fmt.Printf("\n  Cloud Provider: Not detected\n")
 		}
@@ -284,6 +514,14 @@ func main() {
 			if k8sMetadata.PodIP != "" {
 				fmt.Printf("    Pod IP: %s\n", k8sMetadata.PodIP)
 			}
+			if k8sMetadata.OwnerKind != "" {
+				fmt.Printf("    Owner: %s\n", k8sMetadata.OwnerKind)
+			}
+			if n := len(k8sMetadata.Labels) + len(k8sMetadata.Annotations); n > 0 {
+				fmt.Printf("    Labels/annotations tagged: %d\n", n)
+			}
+		} else if cfg.Detection.Kubernetes == "off" {
+			fmt.Printf("\n  Kubernetes: Detection off\n")
 		} else {
 			fmt.Printf("\n  Kubernetes: Not detected\n")
 		}
@@ -298,6 +536,72 @@ func main() {
 		os.Exit(0)
 	}
 
+	// Handle send-file and stdin flags
+	if *sendFile != "" || *readStdin {
+		opts := sendFileOptions{
+			Path:        *sendFile,
+			Format:      *sendFormat,
+			ServiceName: *sendService,
+			Environment: *sendEnv,
+		}
+		source := "--send-file"
+		if *readStdin {
+			opts.Path, opts.Input, source = "stdin", os.Stdin, "--stdin"
+		}
+		if cfg.APIKey == "" {
+			fmt.Fprintf(os.Stderr, "✗ %s needs api_key in the config\n", source)
+			os.Exit(1)
+		}
+		fmt.Printf("Sending %s (format: %s)...\n", opts.Path, *sendFormat)
+		fwd := forwarder.NewWithOptions(cfg.APIEndpoint, cfg.APIKey, forwarderOptionsFromConfig(cfg))
+		report, err := runSendFile(cfg, fwd, opts, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "✗ %v\n", err)
+		}
+		report.print(os.Stdout, opts.Path)
+		if err != nil || report.Failed > 0 {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// Handle once flag - cron-style batch shipping
+	if *runOnceFlag {
+		if cfg.APIKey == "" {
+			fmt.Fprintln(os.Stderr, "✗ --once needs api_key in the config")
+			os.Exit(1)
+		}
+		if daemon.IsRunning(layout) {
+			fmt.Fprintln(os.Stderr, "✗ The sidecar is running and already ships these logs; stop it first (yaat-sidecar --stop)")
+			os.Exit(1)
+		}
+		var store *queue.Storage
+		if s, err := queue.New(resolveQueueDir(layout)); err != nil {
+			fmt.Fprintf(os.Stderr, "⚠ Persistent queue unavailable, failed sends will not be kept: %v\n", err)
+		} else {
+			store = s
+		}
+		if store != nil && cfg.Delivery.QueueEncryptionKeyFile != "" {
+			key, err := queue.LoadKeyFile(cfg.Delivery.QueueEncryptionKeyFile)
+			if err == nil {
+				err = store.SetEncryptionKey(key)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "✗ Invalid delivery.queue_encryption_key_file: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		fmt.Println("Shipping new log lines and queued batches...")
+		started := time.Now()
+		fwd := forwarder.NewWithOptions(cfg.APIEndpoint, cfg.APIKey, forwarderOptionsFromConfig(cfg))
+		report := runOnce(cfg, fwd, store, os.Stdout)
+		report.print(os.Stdout, time.Since(started))
+		if report.Failed() {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	// Handle test flag - test API connection
 	if *testAPIFlag {
 		fmt.Println("Sending connectivity test events...")
@@ -322,7 +626,14 @@ func main() {
 			fmt.Printf("✓ API test succeeded in %v (sent %d events)\n", latency.Truncate(time.Millisecond), len(events))
 		}
 
-		if recordErr := state.RecordTestOutcome(cfg.APIEndpoint, cfg.ServiceName, cfg.Environment, events, latency, err); recordErr != nil {
+		result := state.NewTestResult(cfg.APIEndpoint, cfg.ServiceName, cfg.Environment, events, latency, err)
+		if report != nil && report.ClockSkewMeasured {
+			result.SetClockSkew(report.ClockSkew)
+			if skew := report.ClockSkew; skew > cfg.Delivery.ClockSkewWarnDuration || -skew > cfg.Delivery.ClockSkewWarnDuration {
+				fmt.Fprintf(os.Stderr, "⚠️  Local clock differs from the API server by %v\n", skew)
+			}
+		}
+		if recordErr := state.RecordTest(result); recordErr != nil {
 			fmt.Fprintf(os.Stderr, "⚠️  Could not update local state: %v\n", recordErr)
 		}
 
@@ -334,48 +645,67 @@ func main() {
 
 	// Handle daemon mode
 	if isDaemon {
-		pidPath := getInstancePIDPath(*instanceName)
-		logPath := getInstanceLogPath(*instanceName)
-		if err := daemon.Start(resolvedConfigPath, *logFile, pidPath, isVerbose); err != nil {
-			log.Fatalf("[Sidecar] Failed to start daemon: %v", err)
+		if err := daemon.Start(resolvedConfigPath, layout, isVerbose, daemonArgs(*logFormat, *logLevel)...); err != nil {
+			logger.Fatalf("Failed to start daemon: %v", err)
 		}
 		fmt.Println("✓ Sidecar started in background")
-		fmt.Println("  Check logs with: tail -f", daemon.GetLogPath(logPath))
+		fmt.Printf("  Logs: %s\n", layout.LogFile)
+		fmt.Println("  Follow them with: yaat-sidecar --tail")
 		fmt.Println("  Manage with: yaat-sidecar --status | --stop | --restart")
 		os.Exit(0)
 	}
 
-	log.Printf("[Sidecar] YAAT Sidecar v%s starting...", version)
-	log.Printf("[Sidecar] Config file: %s", resolvedConfigPath)
+	logger.Infof("YAAT Sidecar v%s starting...", version)
+	logger.Infof("Config file: %s", resolvedConfigPath)
+	logger.Infof("Data directory: %s (queue: %s)", layout.DataDir, resolveQueueDir(layout))
+
+	logger.Infof("Service: %s (environment: %s)", cfg.ServiceName, cfg.Environment)
+	logger.Infof("API endpoint: %s", cfg.APIEndpoint)
+	logger.Infof("Buffer size: %d events", cfg.BufferSize)
+	logger.Infof("Flush interval: %v", cfg.FlushIntervalDuration)
+	for _, w := range cfg.Warnings() {
+		logger.Warnf("Config: %s", w)
+	}
 
-	log.Printf("[Sidecar] Service: %s (environment: %s)", cfg.ServiceName, cfg.Environment)
-	log.Printf("[Sidecar] API endpoint: %s", cfg.APIEndpoint)
-	log.Printf("[Sidecar] Buffer size: %d events", cfg.BufferSize)
-	log.Printf("[Sidecar] Flush interval: %v", cfg.FlushIntervalDuration)
+	// Surface permission, disk and connectivity problems without delaying startup
+	go logStartupChecks(cfg, layout)
 
 	// Log detected cloud provider and Kubernetes metadata
 	if cloudMetadata != nil && cloudMetadata.Provider != "unknown" {
-		log.Printf("[Sidecar] Cloud provider: %s (region: %s, instance: %s)",
+		logger.Infof("Cloud provider: %s (region: %s, instance: %s)",
 			cloudMetadata.Provider, cloudMetadata.Region, cloudMetadata.InstanceID)
 	}
 	if k8sMetadata != nil && k8sMetadata.InCluster {
-		log.Printf("[Sidecar] Kubernetes: pod=%s, namespace=%s, node=%s",
+		logger.Infof("Kubernetes: pod=%s, namespace=%s, node=%s",
 			k8sMetadata.PodName, k8sMetadata.Namespace, k8sMetadata.NodeName)
 	}
 	if len(cfg.Tags) > 0 {
-		log.Printf("[Sidecar] Global tags: %d configured", len(cfg.Tags))
+		logger.Infof("Global tags: %d configured", len(cfg.Tags))
+	}
+	if len(cfg.Routing) > 0 {
+		logger.Infof("Routing rules: %d configured", len(cfg.Routing))
+	}
+
+	// At-rest encryption for the persistent queue and the analytics spill
+	var queueKey []byte
+	if cfg.Delivery.QueueEncryptionKeyFile != "" {
+		if queueKey, err = queue.LoadKeyFile(cfg.Delivery.QueueEncryptionKeyFile); err != nil {
+			logger.Fatalf("Invalid delivery.queue_encryption_key_file: %v", err)
+		}
+		logger.Infof("Persisted batches are encrypted with %s", cfg.Delivery.QueueEncryptionKeyFile)
 	}
 
 	// Initialize analytics writer
 	var analyticsWriter *analytics.Writer
-	if cfg.Analytics.Enabled {
+	var failureRecorder *analytics.FailureRecorder
+	if cfg.Analytics.Enabled || cfg.Analytics.RecordFailuresOnly {
 		// Determine organization ID for local storage
 		orgID := cfg.OrganizationID
 		if orgID == "" {
 			orgID = "local" // Local-only mode
 		}
 
-		aw, err := analytics.NewWriter(analytics.Config{
+		analyticsCfg := analytics.Config{
 			DatabasePath:   cfg.Analytics.DatabasePath,
 			OrganizationID: orgID,
 			ServiceName:    cfg.ServiceName,
@@ -384,9 +714,23 @@ func main() {
 			MaxSizeGB:      cfg.Analytics.MaxSizeGB,
 			BatchSize:      cfg.Analytics.BatchSize,
 			WriteTimeout:   cfg.Analytics.TimeoutDuration,
-		})
-		if err != nil {
-			log.Printf("[Analytics] Failed to initialize: %v. Continuing without local analytics.", err)
+
+			MaxMessageBytes:    *cfg.Analytics.MaxMessageBytes,
+			MaxStacktraceBytes: *cfg.Analytics.MaxStacktraceBytes,
+
+			SpillOverflow:      cfg.Analytics.SpillOverflow,
+			MaxSpillBytes:      int64(cfg.Analytics.SpillMaxMB) << 20,
+			SpillEncryptionKey: queueKey,
+
+			IndexedTags: cfg.Analytics.IndexedTags,
+		}
+		if cfg.Analytics.RecordFailuresOnly {
+			// The database is opened on the first undelivered batch
+			failureRecorder = analytics.NewFailureRecorder(analyticsCfg)
+			defer failureRecorder.Close()
+			analyticsLogger.Infof("Recording undelivered events only: %s", cfg.Analytics.DatabasePath)
+		} else if aw, err := analytics.NewWriter(analyticsCfg); err != nil {
+			analyticsLogger.Warnf("Failed to initialize: %v. Continuing without local analytics.", err)
 		} else {
 			analyticsWriter = aw
 			defer analyticsWriter.Close()
@@ -399,34 +743,89 @@ func main() {
 			if cfg.APIKey == "" {
 				mode = "local-only"
 			}
-			log.Printf("[Analytics] Enabled (%s): %s", mode, cfg.Analytics.DatabasePath)
+			analyticsLogger.Infof("Enabled (%s): %s", mode, cfg.Analytics.DatabasePath)
 		}
 	}
 
-	// Create event buffer
-	buf := buffer.New(cfg.BufferSize)
+	// Optional OpenTelemetry export, delivered alongside the YAAT API
+	var otlpExporter *otlp.Exporter
+	if cfg.OTLP.Enabled {
+		otlpExporter = otlp.New(cfg.OTLP.Endpoint, cfg.OTLP.Headers, cfg.OTLP.TimeoutDuration)
+		logger.Infof("OTLP export enabled: %s", cfg.OTLP.Endpoint)
+	}
 
-	// Persistent queue
-	queueDir := queue.DefaultDir()
-	if envQueue := os.Getenv("YAAT_QUEUE_DIR"); envQueue != "" {
-		queueDir = envQueue
+	// Buffer, persistent queue, forwarder and flusher, as pkg/sidecar embeds
+	// them. Inputs scrub with the rules installed by scrubber.Configure, so
+	// the emitter gets no scrubbing config of its own.
+	var filter func(buffer.Event) bool
+	if limits := limitsFromConfig(cfg); limits.Active() {
+		filter = pipeline.NewLimiter(limits).Allow
+		logger.Infof("Limits: min_level=%q max_events_per_minute=%d drop_event_types=%v", limits.MinLevel, limits.MaxEventsPerMinute, limits.DropEventTypes)
 	}
-	queueStore, err := queue.New(queueDir)
-	if err != nil {
-		log.Printf("[Sidecar] Warning: failed to initialize persistent queue: %v", err)
+	fwdOpts := forwarderOptionsFromConfig(cfg)
+	var parts embedding.Parts
+	hooks := embedding.Hooks{
+		Forwarder:           &fwdOpts,
+		Filter:              filter,
+		HighWatermark:       int(math.Ceil(float64(cfg.BufferSize) * cfg.FlushHighWatermark)),
+		QueueRetention:      cfg.Delivery.QueueRetentionDuration,
+		DeadLetterRetention: cfg.Delivery.DeadLetterRetentionDuration,
+
+		DeadLetterMaxRetries:    cfg.Delivery.DLQMaxRetries,
+		DeadLetterRetryInterval: cfg.Delivery.DLQRetryIntervalDuration,
+		Built: func(p embedding.Parts) error {
+			parts = p
+			if cfg.StartupProbe.Enabled && cfg.APIKey != "" {
+				stop := make(chan os.Signal, 1)
+				signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+				defer signal.Stop(stop)
+				if err := runStartupProbe(p.Forwarder.Probe, cfg.StartupProbe, stop); err != nil {
+					return fmt.Errorf("startup probe failed: %w", err)
+				}
+			}
+			return nil
+		},
+	}
+	// A nil pointer in an interface field would look set
+	if analyticsWriter != nil {
+		hooks.Analytics = analyticsWriter
+	}
+	if failureRecorder != nil {
+		hooks.Failures = failureRecorder
+	}
+	if otlpExporter != nil {
+		hooks.OTLP = otlpExporter
 	}
 
-	updateQueueMetrics(buf, queueStore)
+	emitter, err := sidecar.New(sidecar.Config{
+		APIEndpoint:    cfg.APIEndpoint,
+		APIKey:         cfg.APIKey,
+		OrganizationID: cfg.OrganizationID,
+		ServiceName:    cfg.ServiceName,
+		Environment:    cfg.Environment,
+		Tags:           cfg.Tags,
+		BufferSize:     cfg.BufferSize,
+		FlushInterval:  cfg.FlushIntervalDuration,
+		QueueDir:       resolveQueueDir(layout),
+
+		QueueEncryptionKey: queueKey,
+	}, embedding.WithHooks(hooks))
+	if err != nil {
+		logger.Fatalf("Failed to start delivery: %v", err)
+	}
+	buf, fwd, pipe := parts.Buffer, parts.Forwarder, parts.Pipeline
 
 	var stopMetrics func()
 	var stopStatsd func()
+	var stopHeartbeat func()
+	var stopLocalAPI func()
 	if cfg.Metrics.Enabled {
 		collector, err := metrics.NewCollector(cfg.OrganizationID, cfg.ServiceName, cfg.Environment, cfg.Tags, cfg.Metrics, buf)
 		if err != nil {
-			log.Printf("[Sidecar] Host metrics disabled: %v", err)
+			logger.Warnf("Host metrics disabled: %v", err)
 		} else {
 			stopMetrics = collector.Start()
-			log.Printf("[Sidecar] Host metrics collector running (interval %v)", cfg.Metrics.IntervalDuration)
+			logger.Infof("Host metrics collector running (interval %v)", cfg.Metrics.IntervalDuration)
 		}
 		if cfg.Metrics.StatsD.Enabled {
 			statsdCfg := cfg.Metrics.StatsD
@@ -441,52 +840,92 @@ func main() {
 				}
 			}
 			statsdServer := statsd.New(statsdCfg, cfg.OrganizationID, cfg.ServiceName, cfg.Environment, cfg.Tags, buf)
+			statsdServer.SetMaxTagCardinality(cfg.Metrics.MaxTagCardinality)
 			stop, err := statsdServer.Start()
 			if err != nil {
-				log.Printf("[Sidecar] StatsD listener disabled: %v", err)
+				logger.Warnf("StatsD listener disabled: %v", err)
 			} else {
 				stopStatsd = stop
-				log.Printf("[Sidecar] StatsD listener running on %s", cfg.Metrics.StatsD.ListenAddr)
+				logger.Infof("StatsD listener running on %s", cfg.Metrics.StatsD.ListenAddr)
 			}
 		}
 	}
+	if cfg.Heartbeat.Enabled {
+		beat := heartbeat.New(cfg.OrganizationID, cfg.ServiceName, cfg.Environment, version, cfg.Tags, cfg.Heartbeat.IntervalDuration, buf, func() diag.Snapshot {
+			return diag.Global().Snapshot()
+		})
+		stopHeartbeat = beat.Start()
+		logger.Infof("Heartbeat every %s", cfg.Heartbeat.IntervalDuration)
+	}
 
-	// Create forwarder
-	fwd := forwarder.NewWithOptions(cfg.APIEndpoint, cfg.APIKey, forwarderOptionsFromConfig(cfg))
+	// Local control socket for --flush-now, --rotate-key and --events-tail
+	controlSvc := control.New(control.SocketPath(layout), pipe.FlushNow)
+	controlSvc.SetReloadKey(func() error {
+		return reloadAPIKey(resolvedConfigPath, cfg.APIKey, fwd)
+	})
+	eventStream := control.NewEventStream()
+	buf.SetObserver(eventStream.Publish)
+	controlSvc.SetEventStream(eventStream)
+	if err := controlSvc.Start(); err != nil {
+		logger.Warnf("Control socket disabled: %v", err)
+		controlSvc = nil
+	}
 
-	// Start periodic flusher
-	stopFlusher := make(chan struct{})
-	go periodicFlusher(buf, fwd, cfg.FlushIntervalDuration, stopFlusher, queueStore, cfg.Delivery.QueueRetentionDuration, cfg.Delivery.DeadLetterRetentionDuration, analyticsWriter, cfg.APIKey)
+	// Request ID -> proxy span lookup shared by the proxy and the tailers
+	var correlator *correlate.Cache
+	if cfg.Correlation.Enabled {
+		correlator = correlate.New(cfg.Correlation.MaxEntries, cfg.Correlation.TTLDuration)
+		logger.Infof("Correlating log lines to proxy spans by request ID")
+	}
 
 	// Start log tailers
+	logs.SetJSONDepth(cfg.JSONDepth)
 	var journaldTailers []*logs.JournaldTailer
 	if len(cfg.Logs) > 0 {
-		log.Printf("[Sidecar] Starting %d log tailers...", len(cfg.Logs))
+		logger.Infof("Starting %d log tailers...", len(cfg.Logs))
 		for _, logCfg := range cfg.Logs {
 			format := strings.ToLower(logCfg.Format)
 			if format == "journald" {
 				tailer := logs.NewJournaldTailer(cfg.OrganizationID, cfg.ServiceName, cfg.Environment, cfg.Tags, buf)
+				tailer.SetBackpressure(logs.DefaultBackpressure)
+				tailer.SetIncludeFields(logCfg.Journald.IncludeFields)
+				tailer.SetScope(logCfg.Journald.Boot == "current", logCfg.Journald.Namespace)
 				if err := tailer.Start(logCfg.Path); err != nil {
-					log.Printf("[Sidecar] Failed to start journald tailer (%s): %v", logCfg.Path, err)
+					logger.Errorf("Failed to start journald tailer (%s): %v", logCfg.Path, err)
 				} else {
 					journaldTailers = append(journaldTailers, tailer)
-					log.Printf("[Sidecar] Streaming journald entries (match: %s)", logCfg.Path)
+					logger.Infof("Streaming journald entries via %s (match: %s)", logs.JournaldBackend, logCfg.Path)
 				}
 				continue
 			}
 
 			tailer := logs.New(logCfg.Path, logCfg.Format, cfg.OrganizationID, cfg.ServiceName, cfg.Environment, cfg.Tags, buf)
+			tailer.SetLocation(logCfg.Location)
+			tailer.SetExtractKV(logCfg.ExtractKV)
+			tailer.SetDedupeWindow(logCfg.DedupeWindowDuration)
+			tailer.SetSampleRates(logCfg.SampleRates)
+			tailer.SetCorrelator(correlator)
+			tailer.SetSpanSampler(spanSamplerFromConfig(logCfg.Path, logCfg.SpanSampling, cfg.FlushIntervalDuration))
+			tailer.SetBackpressure(logs.DefaultBackpressure)
+			tailer.SetMaxEntryBytes(maxEventBytes(cfg))
+			if logCfg.Backfill.Enabled {
+				tailer.SetBackfill(logs.BackfillOptions{
+					MaxFiles:       logCfg.Backfill.MaxFiles,
+					MaxAge:         logCfg.Backfill.MaxAgeDuration,
+					LinesPerSecond: logCfg.Backfill.LinesPerSecond,
+				})
+			}
 			if err := tailer.Start(); err != nil {
-				log.Printf("[Sidecar] Failed to start tailer for %s: %v", logCfg.Path, err)
+				logger.Errorf("Failed to start tailer for %s: %v", logCfg.Path, err)
 			} else {
-				log.Printf("[Sidecar] Tailing %s (format: %s)", logCfg.Path, logCfg.Format)
+				logger.Infof("Tailing %s (format: %s)", logCfg.Path, logCfg.Format)
 			}
 		}
 	}
 
 	// Start HTTP proxy if enabled
 	if cfg.Proxy.Enabled {
-		log.Printf("[Sidecar] Starting HTTP proxy on port %d -> %s",
+		logger.Infof("Starting HTTP proxy on port %d -> %s",
 			cfg.Proxy.ListenPort, cfg.Proxy.UpstreamURL)
 
 		proxy, err := proxy.New(
@@ -499,229 +938,285 @@ func main() {
 			buf,
 		)
 		if err != nil {
-			log.Fatalf("[Sidecar] Failed to create proxy: %v", err)
+			logger.Fatalf("Failed to create proxy: %v", err)
+		}
+		if cfg.Proxy.LatencyMetrics {
+			proxy.EnableLatencyMetrics(cfg.FlushIntervalDuration)
 		}
+		proxy.SetCorrelator(correlator)
+		proxy.SetSpanSampler(spanSamplerFromConfig("proxy", cfg.Proxy.SpanSampling, cfg.FlushIntervalDuration))
+		proxy.SetMaxPendingEvents(cfg.Proxy.MaxPendingEvents)
 
 		go func() {
 			if err := proxy.Start(); err != nil {
-				log.Fatalf("[Sidecar] Proxy error: %v", err)
+				logger.Fatalf("Proxy error: %v", err)
 			}
 		}()
 	}
 
 	// Start health check endpoint if configured
-	if *healthPort > 0 {
-		healthSvc := health.New(*healthPort, version, cfg.ServiceName, func() diag.Snapshot {
+	if addr := cfg.Health.Addr(*healthPort); addr != "" {
+		healthSvc := health.New(addr, version, cfg.ServiceName, func() diag.Snapshot {
 			return diag.Global().Snapshot()
 		})
-		go func() {
-			log.Printf("[Sidecar] Health endpoint running on :%d", *healthPort)
-			if err := healthSvc.Start(); err != nil {
-				log.Printf("[Sidecar] Health endpoint error: %v", err)
+		healthSvc.SetBearerToken(cfg.Health.BearerToken)
+		if *healthUI {
+			healthSvc.SetUI(healthUIConfig(cfg))
+		}
+		if ln, err := healthSvc.Listen(); err != nil {
+			logger.Errorf("Health endpoint error: %v", err)
+		} else {
+			logger.Infof("Health endpoint running on %s", ln.Addr())
+			if *healthUI {
+				logger.Infof("Status page at http://%s/ui", ln.Addr())
 			}
-		}()
+			go func() {
+				if err := healthSvc.Serve(ln); err != nil {
+					logger.Errorf("Health endpoint error: %v", err)
+				}
+			}()
+		}
+	}
+
+	// Loopback endpoint for custom events
+	if cfg.LocalAPI.Enabled {
+		localAPI := localapi.New(cfg.LocalAPI, cfg.OrganizationID, cfg.ServiceName, cfg.Environment, cfg.Tags, buf)
+		if stop, err := localAPI.Start(); err != nil {
+			logger.Errorf("Local API disabled: %v", err)
+		} else {
+			stopLocalAPI = stop
+			logger.Infof("Local API accepting events on http://%s/ingest", localAPI.Addr())
+		}
 	}
 
-	log.Printf("[Sidecar] ✓ Sidecar running. Press Ctrl+C to stop.")
+	logger.Infof("✓ Sidecar running. Press Ctrl+C to stop.")
+
+	var stopStatusLine func()
+	if *foreground {
+		effectiveFormat := *logFormat
+		if effectiveFormat == "" {
+			effectiveFormat = cfg.LogFormat
+		}
+		switch {
+		case *logFile != "":
+			logger.Infof("--foreground status line disabled: logging to %s", *logFile)
+		case effectiveFormat == string(logging.FormatJSON):
+			logger.Infof("--foreground status line disabled: log format is json")
+		case !isTerminal(os.Stdout) || !isTerminal(os.Stderr):
+			logger.Infof("--foreground status line disabled: not a terminal")
+		default:
+			status := newStatusLine(os.Stderr)
+			logging.SetOutput(status)
+			stopStatusLine = status.Start(statusLineInterval)
+		}
+	}
 
 	// Wait for interrupt signal
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 	<-sigChan
 
-	log.Printf("[Sidecar] Shutting down gracefully...")
+	if stopStatusLine != nil {
+		stopStatusLine()
+	}
+	logger.Infof("Shutting down gracefully...")
 
-	// Stop flusher
-	close(stopFlusher)
+	if controlSvc != nil {
+		controlSvc.Stop()
+	}
 
 	if stopMetrics != nil {
 		stopMetrics()
 	}
+	if stopLocalAPI != nil {
+		stopLocalAPI()
+	}
 	if stopStatsd != nil {
 		stopStatsd()
 	}
+	if stopHeartbeat != nil {
+		stopHeartbeat()
+	}
 	for _, tailer := range journaldTailers {
 		tailer.Stop()
 	}
 
-	// Flush remaining events
-	updateQueueMetrics(buf, queueStore)
-	events := buf.Flush()
-	updateQueueMetrics(buf, queueStore)
-	if len(events) > 0 {
-		log.Printf("[Sidecar] Flushing %d remaining events...", len(events))
+	// Flush remaining events and drain the persistent queue, bounded by shutdown_timeout
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), cfg.ShutdownTimeoutDuration)
+	if err := emitter.Close(drainCtx); err != nil {
+		logger.Warnf("Shutdown timeout (%s) reached before delivery finished", cfg.ShutdownTimeout)
+	}
+	cancelDrain()
+	if remaining := pipe.Stats().Persisted.Events; remaining > 0 {
+		logger.Infof("%d events remain queued and will be sent on the next start", remaining)
+	}
 
-		// Write to local analytics
-		if analyticsWriter != nil {
-			if err := analyticsWriter.Write(events); err != nil {
-				log.Printf("[Analytics] Shutdown write failed: %v", err)
-			}
-		}
+	logger.Infof("Shutdown complete.")
+}
 
-		// Forward to cloud (only if api_key is set)
-		if cfg.APIKey != "" {
-			if err := fwd.Send(events); err != nil {
-				log.Printf("[Sidecar] Failed to flush events: %v", err)
-				diag.Global().RecordSendFailure(err, len(events))
-				if queueStore != nil {
-					if enqueueErr := queueStore.Enqueue(events); enqueueErr != nil {
-						log.Printf("[Sidecar] Failed to enqueue events to persistent queue: %v", enqueueErr)
-					}
-				}
-			} else {
-				diag.Global().RecordSendSuccess(len(events))
+// mergeDetectedTags adds detection overrides and detected cloud and
+// Kubernetes tags to the global tags. Tags set in the config take priority,
+// then overrides, then detected values.
+func mergeDetectedTags(cfg *config.Config, cloudMetadata *detection.CloudProvider, k8sMetadata *detection.KubernetesMetadata) {
+	if cfg.Tags == nil {
+		cfg.Tags = make(map[string]string)
+	}
+	sources := []map[string]string{cfg.Detection.Overrides}
+	if cloudMetadata != nil {
+		sources = append(sources, cloudMetadata.Tags)
+	}
+	if k8sMetadata != nil {
+		sources = append(sources, k8sMetadata.Tags)
+	}
+	for _, tags := range sources {
+		for k, v := range tags {
+			if _, exists := cfg.Tags[k]; !exists {
+				cfg.Tags[k] = v
 			}
 		}
 	}
-	updateQueueMetrics(buf, queueStore)
-
-	log.Printf("[Sidecar] Shutdown complete.")
 }
 
-// periodicFlusher flushes the buffer periodically
-func periodicFlusher(buf *buffer.Buffer, fwd *forwarder.Forwarder, interval time.Duration, stop chan struct{}, store *queue.Storage, queueRetention, dlqRetention time.Duration, analyticsWriter *analytics.Writer, apiKey string) {
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
-
-	drainPersistentQueue(store, fwd)
-	updateQueueMetrics(buf, store)
-	cleanupQueues(store, queueRetention, dlqRetention)
-
-	for {
+// runStartupProbe checks the API key before any input starts. With
+// on_failure: retry it tries again every retry_interval until the probe
+// passes or stop receives a signal.
+func runStartupProbe(probe func() error, cfg config.StartupProbeConfig, stop <-chan os.Signal) error {
+	for attempt := 1; ; attempt++ {
+		err := probe()
+		if err == nil {
+			logger.Infof("Startup probe passed: API key accepted")
+			return nil
+		}
+		if cfg.OnFailure != "retry" {
+			return err
+		}
+		logger.Warnf("Startup probe attempt %d failed: %v (retrying in %v)", attempt, err, cfg.RetryIntervalDuration)
 		select {
-		case <-ticker.C:
-			drainPersistentQueue(store, fwd)
-			updateQueueMetrics(buf, store)
-			events := buf.Flush()
-			updateQueueMetrics(buf, store)
-			cleanupQueues(store, queueRetention, dlqRetention)
-			if len(events) == 0 {
-				continue
-			}
-
-			log.Printf("[Flusher] Flushing %d events...", len(events))
-
-			// Write to local analytics (async, non-blocking)
-			if analyticsWriter != nil {
-				if err := analyticsWriter.Write(events); err != nil {
-					log.Printf("[Analytics] Write failed: %v", err)
-				}
-			}
-
-			// Forward to cloud API (only if api_key is set)
-			if apiKey != "" {
-				if err := fwd.Send(events); err != nil {
-					log.Printf("[Flusher] Failed to send events: %v", err)
-					diag.Global().RecordSendFailure(err, len(events))
-					if store != nil {
-						if enqueueErr := store.Enqueue(events); enqueueErr != nil {
-							log.Printf("[Flusher] Failed to enqueue events to persistent queue: %v", enqueueErr)
-						}
-						updateQueueMetrics(buf, store)
-					}
-				} else {
-					diag.Global().RecordSendSuccess(len(events))
-				}
-			} else {
-				// Local-only mode - no cloud forwarding
-				log.Printf("[Flusher] Local-only mode: %d events stored locally", len(events))
-			}
-
-		case <-stop:
-			log.Printf("[Flusher] Stopped")
-			return
+		case <-time.After(cfg.RetryIntervalDuration):
+		case sig := <-stop:
+			return fmt.Errorf("interrupted by %v after %d attempts: %w", sig, attempt, err)
 		}
 	}
 }
 
-func updateQueueMetrics(buf *buffer.Buffer, store *queue.Storage) {
-	inMemory := 0
-	if buf != nil {
-		inMemory = buf.Len()
+// resolveQueueDir returns the persistent queue directory, honouring YAAT_QUEUE_DIR.
+func resolveQueueDir(layout paths.Layout) string {
+	if envQueue := os.Getenv("YAAT_QUEUE_DIR"); envQueue != "" {
+		return envQueue
 	}
+	return layout.QueueDir
+}
 
-	if store == nil {
-		diag.Global().SetQueueState(inMemory, 0, 0)
-		return
+// runQueueMaintenance implements --purge-dlq and, with reset, --reset-queue.
+// Pending batches belong to the running sidecar, so a reset requires it to
+// be stopped first.
+func runQueueMaintenance(reset, assumeYes bool, layout paths.Layout) error {
+	dir := resolveQueueDir(layout)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		fmt.Printf("ℹ️ No queue directory at %s, nothing to remove\n", dir)
+		return nil
+	}
+	if reset && daemon.IsRunning(layout) {
+		return fmt.Errorf("sidecar is running; stop it first (yaat-sidecar --stop)")
 	}
-	pending, err := store.Pending()
+
+	// Batches a running sidecar is sending stay where they are
+	store, err := queue.Open(dir)
 	if err != nil {
-		log.Printf("[Sidecar] Failed to inspect persistent queue: %v", err)
-		pending = 0
+		return fmt.Errorf("open queue: %w", err)
 	}
-	deadLetter, err := store.DeadLetterPending()
+	pending, err := store.PendingSummary()
 	if err != nil {
-		log.Printf("[Sidecar] Failed to inspect deadletter queue: %v", err)
-		deadLetter = 0
+		return fmt.Errorf("inspect queue: %w", err)
 	}
-	diag.Global().SetQueueState(inMemory, pending, deadLetter)
-}
-
-func cleanupQueues(store *queue.Storage, queueRetention, dlqRetention time.Duration) {
-	if store == nil {
-		return
+	deadLetter, err := store.DeadLetterSummary()
+	if err != nil {
+		return fmt.Errorf("inspect deadletter queue: %w", err)
 	}
-	if queueRetention <= 0 && dlqRetention <= 0 {
-		return
+
+	prompt := fmt.Sprintf("Delete %d dead-letter batches (%d events) in %s?", deadLetter.Batches, deadLetter.Events, store.DeadLetterDir())
+	if reset {
+		prompt = fmt.Sprintf("Delete %d pending batches (%d events) and %d dead-letter batches (%d events) in %s?",
+			pending.Batches, pending.Events, deadLetter.Batches, deadLetter.Events, dir)
 	}
-	if err := store.Cleanup(queueRetention, dlqRetention); err != nil {
-		log.Printf("[Sidecar] Failed to cleanup queue storage: %v", err)
+	ok, err := confirm(prompt, assumeYes)
+	if err != nil {
+		return err
 	}
-}
-
-func drainPersistentQueue(store *queue.Storage, fwd *forwarder.Forwarder) {
-	if store == nil {
-		return
+	if !ok {
+		fmt.Println("Aborted, nothing removed")
+		return nil
 	}
 
-	for {
-		token, events, err := store.Dequeue()
+	if reset {
+		pending, deadLetter, err = store.Reset()
 		if err != nil {
-			log.Printf("[Flusher] Failed to dequeue persistent batch: %v", err)
-			return
-		}
-		if events == nil {
-			return
-		}
-
-		if err := fwd.Send(events); err != nil {
-			log.Printf("[Flusher] Failed to send persisted batch: %v", err)
-			diag.Global().RecordSendFailure(err, len(events))
-			if moveErr := store.MoveToDLQ(token); moveErr != nil {
-				log.Printf("[Flusher] Failed to move batch to DLQ: %v", moveErr)
-			}
-			updateQueueMetrics(nil, store)
-			return
+			return err
 		}
+		fmt.Printf("✓ Removed %d pending batches (%d events)\n", pending.Batches, pending.Events)
+	} else if deadLetter, err = store.PurgeDeadLetter(); err != nil {
+		return err
+	}
+	fmt.Printf("✓ Removed %d dead-letter batches (%d events)\n", deadLetter.Batches, deadLetter.Events)
+	return nil
+}
 
-		diag.Global().RecordSendSuccess(len(events))
-		if ackErr := store.Ack(token); ackErr != nil {
-			log.Printf("[Flusher] Failed to ack batch: %v", ackErr)
-		}
-		updateQueueMetrics(nil, store)
+// confirm asks a yes/no question on the terminal. assumeYes answers it;
+// without a terminal to ask on, it is an error.
+func confirm(prompt string, assumeYes bool) (bool, error) {
+	if assumeYes {
+		return true, nil
+	}
+	info, err := os.Stdin.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false, fmt.Errorf("not a terminal; pass --yes to confirm")
 	}
+
+	fmt.Printf("%s [y/N]: ", prompt)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
 }
 
 // setupLogging configures logging based on flags
-func setupLogging(logFilePath string, verbose bool) {
-	// Set log format
-	if verbose {
-		log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds | log.Lshortfile)
-	} else {
-		log.SetFlags(log.Ldate | log.Ltime)
+func setupLogging(logFilePath, logFormat, logLevel string, verbose bool) {
+	format, err := logging.ParseFormat(logFormat)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid --log-format: %v\n", err)
+		os.Exit(1)
+	}
+	level, err := logging.ParseLevel(logLevel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid --log-level: %v\n", err)
+		os.Exit(1)
 	}
 
 	// Setup output destination
+	out := io.Writer(os.Stderr)
 	if logFilePath != "" {
 		f, err := os.OpenFile(logFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to open log file: %v\n", err)
 			os.Exit(1)
 		}
-		log.SetOutput(f)
-	} else {
-		log.SetOutput(os.Stderr)
+		out = f
+	}
+	logging.Configure(out, format, verbose)
+	if logLevel != "" {
+		logging.SetLevel(level)
+	}
+}
+
+// daemonArgs returns flags the background process must inherit.
+func daemonArgs(logFormat, logLevel string) []string {
+	var args []string
+	if logFormat != "" {
+		args = append(args, "--log-format", logFormat)
 	}
+	if logLevel != "" {
+		args = append(args, "--log-level", logLevel)
+	}
+	return args
 }
 
 // getOS returns the current operating system
@@ -746,6 +1241,20 @@ func preferredConfigPath(provided string) string {
 	return config.DefaultConfigPath()
 }
 
+// stopGrace is added to shutdown_timeout when waiting for the daemon to
+// exit, covering the work after the final drain.
+const stopGrace = 5 * time.Second
+
+// stopTimeout returns how long --stop waits for the daemon: its
+// shutdown_timeout plus stopGrace, or the default when the config cannot
+// be loaded.
+func stopTimeout(configPath string) time.Duration {
+	if cfg, err := config.LoadConfig(configPath); err == nil {
+		return cfg.ShutdownTimeoutDuration + stopGrace
+	}
+	return config.DefaultShutdownTimeout + stopGrace
+}
+
 func isNotRunningError(err error) bool {
 	if err == nil {
 		return false
@@ -753,28 +1262,89 @@ func isNotRunningError(err error) bool {
 	return strings.Contains(strings.ToLower(err.Error()), "not running")
 }
 
+// spanSamplerFromConfig builds the span sampler for one source and the
+// interval its sampled-out counts are emitted at, zero when they are not.
+// It returns nil when sampling keeps every span.
+func spanSamplerFromConfig(source string, sc config.SpanSamplingConfig, flushInterval time.Duration) (*spansample.Sampler, time.Duration) {
+	if !sc.Enabled() {
+		return nil, 0
+	}
+	errorsAlways := sc.ErrorsAlways == nil || *sc.ErrorsAlways
+	var countInterval time.Duration
+	if sc.SampledOutMetric {
+		countInterval = flushInterval
+	}
+	return spansample.New(source, *sc.SpanSampleRate, errorsAlways), countInterval
+}
+
+// maxEventBytes returns delivery.max_event_bytes, 0 when unset.
+func maxEventBytes(cfg *config.Config) int {
+	if cfg.Delivery.MaxEventBytes != nil {
+		return *cfg.Delivery.MaxEventBytes
+	}
+	return 0
+}
+
 func forwarderOptionsFromConfig(cfg *config.Config) forwarder.Options {
+	var globalTags map[string]string
+	if cfg.Delivery.PinGlobalTags == nil || *cfg.Delivery.PinGlobalTags {
+		// Pinning runs after scrubbing; pin only what scrubbing would keep
+		globalTags = scrubber.Tags(cfg.Tags)
+	}
 	return forwarder.Options{
-		BatchSize:     cfg.Delivery.BatchSize,
-		Compress:      cfg.Delivery.Compress,
-		MaxBatchBytes: cfg.Delivery.MaxBatchBytes,
+		BatchSize:        cfg.Delivery.BatchSize,
+		Compress:         cfg.Delivery.Compress,
+		MaxBatchBytes:    cfg.Delivery.MaxBatchBytes,
+		MaxEventBytes:    maxEventBytes(cfg),
+		MaxConcurrency:   cfg.Delivery.MaxConcurrency,
+		ClockSkewWarn:    cfg.Delivery.ClockSkewWarnDuration,
+		ClockSkewCorrect: cfg.Delivery.ClockSkewCorrect,
+		GroupByService:   cfg.Delivery.GroupByService,
+		ServiceKeys:      cfg.Delivery.ServiceKeys,
+		Version:          version,
+		GlobalTags:       globalTags,
 	}
 }
 
-// getInstancePIDPath returns the instance-specific PID file path
-func getInstancePIDPath(instance string) string {
-	if instance == "default" {
-		return "/var/run/yaat-sidecar.pid"
+// healthUIConfig summarizes cfg, secrets masked, for the --health-ui page.
+func healthUIConfig(cfg *config.Config) *health.UIConfig {
+	redacted := cfg.Redacted()
+	onOff := func(enabled bool) string {
+		if enabled {
+			return "enabled"
+		}
+		return "disabled"
+	}
+	ui := &health.UIConfig{
+		Settings: []health.Setting{
+			{Name: "Config file", Value: redacted.SourcePath},
+			{Name: "api_endpoint", Value: redacted.APIEndpoint},
+			{Name: "api_key", Value: redacted.APIKey},
+			{Name: "organization_id", Value: redacted.OrganizationID},
+			{Name: "service_name", Value: redacted.ServiceName},
+			{Name: "environment", Value: redacted.Environment},
+			{Name: "buffer_size", Value: strconv.Itoa(redacted.BufferSize)},
+			{Name: "flush_interval", Value: redacted.FlushInterval},
+			{Name: "delivery.batch_size", Value: strconv.Itoa(redacted.Delivery.BatchSize)},
+			{Name: "proxy", Value: onOff(redacted.Proxy.Enabled)},
+			{Name: "metrics", Value: onOff(redacted.Metrics.Enabled)},
+			{Name: "metrics.statsd", Value: onOff(redacted.Metrics.StatsD.Enabled)},
+			{Name: "scrubbing", Value: onOff(redacted.Scrubbing.Enabled)},
+		},
 	}
-	return fmt.Sprintf("/var/run/yaat-%s.pid", instance)
+	for _, logCfg := range redacted.Logs {
+		ui.Sources = append(ui.Sources, health.Source{Path: logCfg.Path, Format: logCfg.Format})
+	}
+	return ui
 }
 
-// getInstanceLogPath returns the instance-specific log file path
-func getInstanceLogPath(instance string) string {
-	if instance == "default" {
-		return "/var/log/yaat-sidecar.log"
+func limitsFromConfig(cfg *config.Config) pipeline.Limits {
+	return pipeline.Limits{
+		MinLevel:           cfg.Limits.MinLevel,
+		MaxEventsPerMinute: cfg.Limits.MaxEventsPerMinute,
+		DropEventTypes:     cfg.Limits.DropEventTypes,
+		AlwaysKeepErrors:   cfg.Limits.AlwaysKeepErrors == nil || *cfg.Limits.AlwaysKeepErrors,
 	}
-	return fmt.Sprintf("/var/log/yaat-%s.log", instance)
 }
 
 // getInstanceConfigPath returns the instance-specific config path
@@ -790,19 +1360,3 @@ func getInstanceConfigPath(instance, configPath string) string {
 	}
 	return fmt.Sprintf("%s.yaml", instance)
 }
-
-// getInstanceQueueDir returns the instance-specific queue directory
-func getInstanceQueueDir(instance string) string {
-	if instance == "default" {
-		return "/var/lib/yaat/queue"
-	}
-	return fmt.Sprintf("/var/lib/yaat/%s/queue", instance)
-}
-
-// getInstanceStateDir returns the instance-specific state directory
-func getInstanceStateDir(instance string) string {
-	if instance == "default" {
-		return "/var/lib/yaat/state"
-	}
-	return fmt.Sprintf("/var/lib/yaat/%s/state", instance)
-}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+	"github.com/yaat-app/sidecar/internal/config"
+	"github.com/yaat-app/sidecar/internal/detection"
+	"github.com/yaat-app/sidecar/internal/forwarder"
+	"github.com/yaat-app/sidecar/internal/scrubber"
+)
+
+func TestMergeDetectedTagsPrecedence(t *testing.T) {
+	cfg := &config.Config{
+		Tags: map[string]string{"cloud.zone": "from-config"},
+		Detection: config.DetectionConfig{
+			Overrides: map[string]string{"cloud.region": "from-override", "cloud.zone": "override-zone"},
+		},
+	}
+	cloud := &detection.CloudProvider{Provider: "aws", Tags: map[string]string{
+		"cloud.provider": "aws",
+		"cloud.region":   "detected-region",
+		"cloud.zone":     "detected-zone",
+	}}
+
+	mergeDetectedTags(cfg, cloud, nil)
+
+	if cfg.Tags["cloud.zone"] != "from-config" {
+		t.Errorf("Expected config tags to win, got %q", cfg.Tags["cloud.zone"])
+	}
+	if cfg.Tags["cloud.region"] != "from-override" {
+		t.Errorf("Expected override to replace the detected value, got %q", cfg.Tags["cloud.region"])
+	}
+	if cfg.Tags["cloud.provider"] != "aws" {
+		t.Errorf("Expected detected tags to fill the rest, got %q", cfg.Tags["cloud.provider"])
+	}
+}
+
+func TestRunStartupProbe(t *testing.T) {
+	errBadKey := errors.New("authentication failed: invalid API key")
+	calls := 0
+	failTwice := func() error {
+		calls++
+		if calls <= 2 {
+			return errBadKey
+		}
+		return nil
+	}
+
+	cfg := config.StartupProbeConfig{OnFailure: "exit", RetryIntervalDuration: time.Millisecond}
+	if err := runStartupProbe(failTwice, cfg, nil); !errors.Is(err, errBadKey) || calls != 1 {
+		t.Errorf("Expected exit after 1 failed attempt, got %v after %d calls", err, calls)
+	}
+
+	calls = 0
+	cfg.OnFailure = "retry"
+	if err := runStartupProbe(failTwice, cfg, nil); err != nil || calls != 3 {
+		t.Errorf("Expected success on the 3rd attempt, got %v after %d calls", err, calls)
+	}
+
+	stop := make(chan os.Signal, 1)
+	stop <- os.Interrupt
+	cfg.RetryIntervalDuration = time.Hour
+	if err := runStartupProbe(func() error { return errBadKey }, cfg, stop); !errors.Is(err, errBadKey) {
+		t.Errorf("Expected a signal to end the retry loop, got %v", err)
+	}
+}
+
+func TestPinnedGlobalTagsFollowAllowlist(t *testing.T) {
+	if err := scrubber.Configure(config.ScrubbingConfig{
+		Enabled:      true,
+		TagAllowlist: []string{"region", "team"},
+		Rules:        []config.ScrubRule{{Name: "mask-team", Pattern: "payments", Replacement: "[TEAM]", Fields: []string{"tags.team"}}},
+	}); err != nil {
+		t.Fatalf("Configure failed: %v", err)
+	}
+	defer scrubber.Configure(config.ScrubbingConfig{})
+
+	cfg := sendFileConfig()
+	cfg.Tags = map[string]string{"region": "eu", "team": "payments", "k8s.annotation.token": "secret"}
+	transport := &sendFileTransport{status: http.StatusOK}
+	fwd := forwarder.NewWithOptions("https://example.test/ingest", "key", forwarderOptionsFromConfig(cfg))
+	fwd.SetHTTPClient(&http.Client{Transport: transport})
+
+	// An input that left out the global tags; pinning adds them back
+	err := fwd.Send([]buffer.Event{{"event_type": "log", "message": "hello", "service_name": "svc", "tags": map[string]string{}}})
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if len(transport.events) != 1 {
+		t.Fatalf("Expected 1 event sent, got %d", len(transport.events))
+	}
+	tags, _ := transport.events[0]["tags"].(map[string]interface{})
+	if _, ok := tags["k8s.annotation.token"]; ok {
+		t.Errorf("Expected the unlisted global tag not pinned, got %v", tags)
+	}
+	if tags["region"] != "eu" || tags["team"] != "[TEAM]" {
+		t.Errorf("Expected pinned tags as scrubbing leaves them, got %v", tags)
+	}
+}
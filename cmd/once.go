@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+	"github.com/yaat-app/sidecar/internal/config"
+	"github.com/yaat-app/sidecar/internal/forwarder"
+	"github.com/yaat-app/sidecar/internal/logs"
+	"github.com/yaat-app/sidecar/internal/pipeline"
+	"github.com/yaat-app/sidecar/internal/queue"
+	"github.com/yaat-app/sidecar/internal/routing"
+	"github.com/yaat-app/sidecar/internal/state"
+)
+
+// onceReport summarizes a --once run.
+type onceReport struct {
+	Files      int // Log files read
+	Started    int // Log files seen for the first time; reading starts on the next run
+	Lines      int
+	Flushed    int // Events taken from the buffer
+	Queued     int // Batches left in the persistent queue
+	DeadLetter int // Batches dead-lettered during the run
+	Errors     []string
+}
+
+// Failed reports whether anything was left undelivered.
+func (r onceReport) Failed() bool {
+	return len(r.Errors) > 0 || r.Queued > 0 || r.DeadLetter > 0
+}
+
+// runOnce reads what each configured log file gained since the previous
+// --once run, delivers it together with whatever is in the persistent
+// queue, and records where reading stopped. Offsets advance once events are
+// sent or persisted to the queue, so the next run neither repeats nor loses
+// lines; when the queue cannot take them, offsets are not saved at all. A
+// failed send is not retried within the run: the rest is queued for the
+// next one. journald sources and named pipes are skipped; they have nothing
+// to resume from.
+func runOnce(cfg *config.Config, fwd *forwarder.Forwarder, store *queue.Storage, progress io.Writer) onceReport {
+	var report onceReport
+	batchSize := cfg.Delivery.BatchSize
+	if batchSize <= 0 {
+		batchSize = 500
+	}
+	deadLetterBefore := 0
+	if store != nil {
+		deadLetterBefore, _ = store.DeadLetterPending()
+	}
+
+	logs.SetJSONDepth(cfg.JSONDepth)
+	buf := buffer.New(batchSize)
+	pipe := pipeline.New(pipeline.Options{
+		Buffer:              buf,
+		Forwarder:           fwd,
+		Queue:               store,
+		APIKey:              cfg.APIKey,
+		FlushInterval:       cfg.FlushIntervalDuration,
+		QueueRetention:      cfg.Delivery.QueueRetentionDuration,
+		DeadLetterRetention: cfg.Delivery.DeadLetterRetentionDuration,
+	})
+
+	sendFailed := false
+	persistFailed := false // Some events reached neither the API nor the queue
+	flush := func() {
+		n, err := pipe.Flush()
+		report.Flushed += n
+		if errors.Is(err, pipeline.ErrNotQueued) {
+			persistFailed = true
+		}
+		if err != nil && !sendFailed {
+			sendFailed = true
+			report.Errors = append(report.Errors, err.Error())
+		}
+	}
+
+	offsets := make(map[string]state.LogOffset)
+	for _, logCfg := range cfg.Logs {
+		if strings.EqualFold(logCfg.Format, "journald") {
+			continue
+		}
+		if info, err := os.Stat(logCfg.Path); err == nil && info.Mode()&os.ModeNamedPipe != 0 {
+			continue
+		}
+
+		tailer := logs.New(logCfg.Path, logCfg.Format, cfg.OrganizationID, cfg.ServiceName, cfg.Environment, cfg.Tags, buf)
+		tailer.SetLocation(logCfg.Location)
+		tailer.SetExtractKV(logCfg.ExtractKV)
+		tailer.SetDedupeWindow(logCfg.DedupeWindowDuration)
+		tailer.SetSampleRates(logCfg.SampleRates)
+		tailer.SetMaxEntryBytes(maxEventBytes(cfg))
+		sampler, _ := spanSamplerFromConfig(logCfg.Path, logCfg.SpanSampling, 0)
+		tailer.SetSpanSampler(sampler, 0)
+
+		var from *state.LogOffset
+		if offset, ok := state.LogOffsetFor(logCfg.Path); ok {
+			from = &offset
+		}
+		next, lines, err := tailer.ReadSince(from, func(int) {
+			// Sent or queued batches keep memory flat on a large backlog;
+			// after a failed send everything goes to the queue
+			if buf.Len() >= batchSize {
+				if sendFailed {
+					persistFailed = !persist(store, buf, &report) || persistFailed
+				} else {
+					flush()
+				}
+			}
+		})
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("read %s: %v", logCfg.Path, err))
+			continue
+		}
+		report.Files++
+		report.Lines += lines
+		if from == nil {
+			report.Started++
+			fmt.Fprintf(progress, "  %s: first run, reading starts at the current end\n", logCfg.Path)
+		} else {
+			fmt.Fprintf(progress, "  %s: %d new lines\n", logCfg.Path, lines)
+		}
+		offsets[logCfg.Path] = next
+	}
+
+	if !sendFailed {
+		flush()
+	}
+	if sendFailed {
+		persistFailed = !persist(store, buf, &report) || persistFailed
+	} else {
+		// Also sends what earlier runs or the daemon left in the queue,
+		// within shutdown_timeout
+		ctx := context.Background()
+		if cfg.ShutdownTimeoutDuration > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, cfg.ShutdownTimeoutDuration)
+			defer cancel()
+		}
+		if err := pipe.Drain(ctx); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("drain queue: %v", err))
+		}
+	}
+
+	if store != nil {
+		report.Queued, _ = store.Pending()
+		if after, err := store.DeadLetterPending(); err == nil && after > deadLetterBefore {
+			report.DeadLetter = after - deadLetterBefore
+		}
+	}
+
+	// Without a queue, or when writing to it failed, events of a failed
+	// send are gone; reading the same lines again next run is the better
+	// outcome
+	if (store == nil && sendFailed) || persistFailed {
+		return report
+	}
+	if err := state.RecordLogOffsets(offsets); err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("save log offsets: %v", err))
+	}
+	return report
+}
+
+// persist writes the buffered events to the queue for the next run, routed
+// as a flush would have. It reports false when the queue did not take them.
+func persist(store *queue.Storage, buf *buffer.Buffer, report *onceReport) bool {
+	events := buf.Flush()
+	report.Flushed += len(events)
+	if store == nil || len(events) == 0 {
+		return true
+	}
+	routing.ApplyBatch(events)
+	if err := store.Enqueue(events); err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("persist %d events: %v", len(events), err))
+		return false
+	}
+	return true
+}
+
+// print writes the summary in the style of the other CLI summaries.
+func (r onceReport) print(w io.Writer, elapsed time.Duration) {
+	symbol := "✓"
+	if r.Failed() {
+		symbol = "✗"
+	}
+	fmt.Fprintf(w, "%s Shipped once in %v\n", symbol, elapsed.Truncate(time.Millisecond))
+	fmt.Fprintf(w, "  Log files read: %d\n", r.Files)
+	if r.Started > 0 {
+		fmt.Fprintf(w, "  Log files seen for the first time: %d (read from the next run on)\n", r.Started)
+	}
+	fmt.Fprintf(w, "  Lines read: %d\n", r.Lines)
+	fmt.Fprintf(w, "  Events flushed: %d\n", r.Flushed)
+	fmt.Fprintf(w, "  Batches still queued: %d\n", r.Queued)
+	if r.DeadLetter > 0 {
+		fmt.Fprintf(w, "  Batches dead-lettered: %d\n", r.DeadLetter)
+	}
+	for _, msg := range r.Errors {
+		fmt.Fprintf(w, "  Error: %s\n", msg)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yaat-app/sidecar/internal/config"
+	"github.com/yaat-app/sidecar/internal/forwarder"
+	"github.com/yaat-app/sidecar/internal/queue"
+)
+
+func TestRunOnce(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+	writeLines := func(lines string) {
+		f, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			t.Fatalf("Failed to open log: %v", err)
+		}
+		f.WriteString(lines)
+		f.Close()
+	}
+	writeLines(`{"level":"info","msg":"before the first run"}` + "\n")
+
+	store, err := queue.New(filepath.Join(dir, "queue"))
+	if err != nil {
+		t.Fatalf("Failed to create queue: %v", err)
+	}
+	cfg := sendFileConfig()
+	cfg.APIKey = "key"
+	cfg.Logs = []config.LogConfig{{Path: logPath, Format: "json"}}
+	transport := &sendFileTransport{status: http.StatusOK}
+	fwd := forwarder.NewWithOptions("https://example.test/ingest", "key", forwarder.Options{BatchSize: 2})
+	fwd.SetHTTPClient(&http.Client{Transport: transport})
+
+	var progress bytes.Buffer
+	report := runOnce(cfg, fwd, store, &progress)
+	if report.Failed() || report.Started != 1 || transport.requests != 0 {
+		t.Fatalf("Expected the first run to only record the offset, got %+v and %d requests", report, transport.requests)
+	}
+
+	writeLines(`{"level":"info","msg":"one"}` + "\n" + `{"level":"info","msg":"two"}` + "\n" + `{"level":"info","msg":"three"}` + "\n")
+	report = runOnce(cfg, fwd, store, &progress)
+	if report.Failed() || report.Lines != 3 || len(transport.events) != 3 {
+		t.Fatalf("Expected 3 lines shipped, got %+v and %d events", report, len(transport.events))
+	}
+
+	// After a failed send new lines are queued and the run fails
+	transport.status = http.StatusBadRequest
+	writeLines(`{"level":"error","msg":"four"}` + "\n")
+	report = runOnce(cfg, fwd, store, &progress)
+	if !report.Failed() || report.Queued == 0 || report.DeadLetter != 0 {
+		t.Fatalf("Expected the failed send queued for the next run, got %+v", report)
+	}
+
+	// The next run delivers the queue and reads nothing twice
+	transport.status = http.StatusOK
+	transport.events = nil
+	report = runOnce(cfg, fwd, store, &progress)
+	if report.Failed() || report.Lines != 0 || len(transport.events) != 1 || transport.events[0]["message"] != "four" {
+		t.Errorf("Expected only the queued event delivered, got %+v and %v", report, transport.events)
+	}
+}
+
+func TestRunOnceKeepsOffsetsWhenQueueFails(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+	if err := os.WriteFile(logPath, []byte(`{"level":"info","msg":"before the first run"}`+"\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write log: %v", err)
+	}
+
+	queueDir := filepath.Join(dir, "queue")
+	store, err := queue.New(queueDir)
+	if err != nil {
+		t.Fatalf("Failed to create queue: %v", err)
+	}
+	cfg := sendFileConfig()
+	cfg.APIKey = "key"
+	cfg.Logs = []config.LogConfig{{Path: logPath, Format: "json"}}
+	transport := &sendFileTransport{status: http.StatusOK}
+	fwd := forwarder.NewWithOptions("https://example.test/ingest", "key", forwarder.Options{BatchSize: 2})
+	fwd.SetHTTPClient(&http.Client{Transport: transport})
+
+	var progress bytes.Buffer
+	runOnce(cfg, fwd, store, &progress)
+
+	// The send fails and the queue directory is gone, so the line is lost
+	// unless the next run reads it again
+	f, err := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatalf("Failed to open log: %v", err)
+	}
+	f.WriteString(`{"level":"error","msg":"unqueued"}` + "\n")
+	f.Close()
+	transport.status = http.StatusBadRequest
+	if err := os.RemoveAll(queueDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(queueDir, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	report := runOnce(cfg, fwd, store, &progress)
+	if !report.Failed() || report.Lines != 1 {
+		t.Fatalf("Expected the failed run to read one line, got %+v", report)
+	}
+
+	if err := os.Remove(queueDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(queueDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	transport.status = http.StatusOK
+	transport.events = nil
+	report = runOnce(cfg, fwd, store, &progress)
+	if report.Failed() || report.Lines != 1 || len(transport.events) != 1 || transport.events[0]["message"] != "unqueued" {
+		t.Errorf("Expected the unqueued line read and sent again, got %+v and %v", report, transport.events)
+	}
+}
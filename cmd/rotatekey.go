@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/charmbracelet/x/term"
+
+	"github.com/yaat-app/sidecar/internal/config"
+	"github.com/yaat-app/sidecar/internal/control"
+	"github.com/yaat-app/sidecar/internal/forwarder"
+	"github.com/yaat-app/sidecar/internal/state"
+)
+
+// keyReloadTimeout bounds the wait for a running sidecar to switch keys.
+const keyReloadTimeout = 10 * time.Second
+
+// runRotateKey checks newKey against the configured endpoint, writes it to
+// api_key and asks a running sidecar to switch to it. The config file is
+// only written once the API has accepted the key.
+func runRotateKey(configPath, newKey, socketPath string, out io.Writer) error {
+	newKey = strings.TrimSpace(newKey)
+	if newKey == "" {
+		return fmt.Errorf("the new API key is empty")
+	}
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	if cfg.APIKeyFromFile {
+		return fmt.Errorf("api_key is read from %s; write the new key to that file and restart the sidecar", cfg.APIKeyFile)
+	}
+	if newKey == cfg.APIKey {
+		return fmt.Errorf("the new API key is the one already configured")
+	}
+
+	fmt.Fprintf(out, "Checking the new key against %s...\n", cfg.APIEndpoint)
+	if err := forwarder.NewWithOptions(cfg.APIEndpoint, newKey, forwarderOptionsFromConfig(cfg)).Probe(); err != nil {
+		return fmt.Errorf("new key not accepted, %s left unchanged: %w", cfg.SourcePath, err)
+	}
+
+	cfg.APIKey = newKey
+	if err := config.SaveConfig(cfg.SourcePath, cfg); err != nil {
+		return fmt.Errorf("save config: %w", err)
+	}
+	fmt.Fprintf(out, "✓ New key accepted and written to %s\n", cfg.SourcePath)
+	if err := state.RecordKeyRotation(); err != nil {
+		fmt.Fprintf(out, "! Could not record the rotation in state.json: %v\n", err)
+	}
+
+	err = control.RequestKeyReload(socketPath, keyReloadTimeout)
+	switch {
+	case err == nil:
+		fmt.Fprintln(out, "✓ The running sidecar switched to the new key; buffered events are kept")
+	case errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.ECONNREFUSED):
+		fmt.Fprintln(out, "  No sidecar is running; it uses the new key from its next start")
+	default:
+		fmt.Fprintf(out, "! The running sidecar is still using the old key: %v\n", err)
+		fmt.Fprintln(out, "  Restart it with: yaat-sidecar --restart")
+	}
+	return nil
+}
+
+// promptAPIKey reads the new key from the terminal without echoing it, or
+// a line from stdin when it is not a terminal.
+func promptAPIKey() (string, error) {
+	if !isTerminal(os.Stdin) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("read API key from stdin: %w", err)
+		}
+		return strings.TrimSpace(line), nil
+	}
+	fmt.Fprint(os.Stderr, "New API key: ")
+	key, err := term.ReadPassword(os.Stdin.Fd())
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("read API key: %w", err)
+	}
+	return strings.TrimSpace(string(key)), nil
+}
+
+// reloadAPIKey rereads api_key from configPath and switches fwd to it. A
+// sidecar started without a key runs local-only and needs a restart instead.
+func reloadAPIKey(configPath, startupKey string, fwd *forwarder.Forwarder) error {
+	if startupKey == "" {
+		return fmt.Errorf("the sidecar started without api_key (local-only); restart it to start delivering")
+	}
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return fmt.Errorf("reload config: %w", err)
+	}
+	if cfg.APIKey == "" {
+		return fmt.Errorf("%s has no api_key", configPath)
+	}
+	fwd.SetAPIKey(cfg.APIKey)
+	logger.Infof("API key reloaded from %s", configPath)
+	return nil
+}
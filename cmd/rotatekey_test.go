@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yaat-app/sidecar/internal/config"
+	"github.com/yaat-app/sidecar/internal/forwarder"
+	"github.com/yaat-app/sidecar/internal/state"
+)
+
+// keyServer accepts requests authorized with validKey and answers 401 otherwise.
+func keyServer(validKey string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+validKey {
+			http.Error(w, "invalid api key", http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+func writeRotateConfig(t *testing.T, endpoint string) string {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	path := filepath.Join(t.TempDir(), "yaat.yaml")
+	content := `# Production sidecar
+service_name: api
+organization_id: org_1
+api_key: yaat_old # rotated quarterly
+api_endpoint: ` + endpoint + `
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	return path
+}
+
+func TestRotateKeyRejectedLeavesConfigUntouched(t *testing.T) {
+	server := keyServer("yaat_valid")
+	defer server.Close()
+	path := writeRotateConfig(t, server.URL)
+	before, _ := os.ReadFile(path)
+
+	var out bytes.Buffer
+	err := runRotateKey(path, "yaat_wrong", filepath.Join(t.TempDir(), "control.sock"), &out)
+	if err == nil {
+		t.Fatal("Expected a rejected key to fail")
+	}
+	if !strings.Contains(err.Error(), "left unchanged") {
+		t.Errorf("Expected the error to say the config is unchanged, got %v", err)
+	}
+	after, _ := os.ReadFile(path)
+	if !bytes.Equal(before, after) {
+		t.Errorf("Expected config file unchanged, got:\n%s", after)
+	}
+	if st, err := state.Load(); err == nil && !st.KeyRotatedAt.IsZero() {
+		t.Errorf("Expected no rotation recorded, got %v", st.KeyRotatedAt)
+	}
+}
+
+func TestRotateKeyWritesConfigAndState(t *testing.T) {
+	server := keyServer("yaat_new")
+	defer server.Close()
+	path := writeRotateConfig(t, server.URL)
+
+	var out bytes.Buffer
+	if err := runRotateKey(path, " yaat_new\n", filepath.Join(t.TempDir(), "control.sock"), &out); err != nil {
+		t.Fatalf("Expected rotation to succeed, got %v", err)
+	}
+	if !strings.Contains(out.String(), "No sidecar is running") {
+		t.Errorf("Expected a note that no sidecar is running, got:\n%s", out.String())
+	}
+
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "# Production sidecar") || !strings.Contains(string(data), "# rotated quarterly") {
+		t.Errorf("Expected comments to survive, got:\n%s", data)
+	}
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Rotated config does not load: %v", err)
+	}
+	if cfg.APIKey != "yaat_new" {
+		t.Errorf("Expected api_key yaat_new, got %q", cfg.APIKey)
+	}
+	st, err := state.Load()
+	if err != nil || st.KeyRotatedAt.IsZero() {
+		t.Errorf("Expected the rotation time in state, got %v (err %v)", st, err)
+	}
+
+	// A running sidecar rereads the key without restarting
+	fwd := forwarder.New(server.URL, "yaat_old")
+	if err := reloadAPIKey(path, "yaat_old", fwd); err != nil {
+		t.Fatalf("reloadAPIKey failed: %v", err)
+	}
+	if err := fwd.Probe(); err != nil {
+		t.Errorf("Expected the forwarder to use the new key, got %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+	"github.com/yaat-app/sidecar/internal/config"
+	"github.com/yaat-app/sidecar/internal/forwarder"
+	"github.com/yaat-app/sidecar/internal/logs"
+	"github.com/yaat-app/sidecar/internal/routing"
+)
+
+// sendFileProgressEvery is how many events pass between progress lines.
+const sendFileProgressEvery = 1000
+
+// sendFileOptions describes a --send-file run.
+type sendFileOptions struct {
+	Path        string
+	Input       io.Reader // Read instead of Path when set (--stdin); Path labels it
+	Format      string
+	ServiceName string // Overrides service_name when set
+	Environment string // Overrides environment when set
+}
+
+// sendFileReport summarizes a --send-file run.
+type sendFileReport struct {
+	Lines  int
+	Parsed int
+	Sent   int
+	Failed int
+}
+
+// Skipped counts lines that produced no event of their own: blank or
+// unparseable lines, scrubbed events and continuation lines of multi-line
+// entries.
+func (r sendFileReport) Skipped() int {
+	if skipped := r.Lines - r.Parsed; skipped > 0 {
+		return skipped
+	}
+	return 0
+}
+
+// runSendFile parses the file (or opts.Input, read to EOF) once with the tailer's parsers, scrubbing and
+// global tags, and delivers it through fwd in batches of delivery.batch_size.
+// Nothing is written to the persistent queue: failed events are counted and
+// reported, not retried later.
+func runSendFile(cfg *config.Config, fwd *forwarder.Forwarder, opts sendFileOptions, progress io.Writer) (sendFileReport, error) {
+	var report sendFileReport
+	flag := "--send-file"
+	if opts.Input != nil {
+		flag = "--stdin"
+	}
+	if opts.Format == "" {
+		return report, fmt.Errorf("%s needs --format", flag)
+	}
+	if opts.Format == "journald" {
+		return report, fmt.Errorf("%s reads lines; journald is not a line format", flag)
+	}
+	service, environment := cfg.ServiceName, cfg.Environment
+	if opts.ServiceName != "" {
+		service = opts.ServiceName
+	}
+	if opts.Environment != "" {
+		environment = opts.Environment
+	}
+
+	batchSize := cfg.Delivery.BatchSize
+	if batchSize <= 0 {
+		batchSize = 500
+	}
+	logs.SetJSONDepth(cfg.JSONDepth)
+	buf := buffer.New(batchSize)
+	tailer := logs.New(opts.Path, opts.Format, cfg.OrganizationID, service, environment, cfg.Tags, buf)
+	tailer.SetMaxEntryBytes(maxEventBytes(cfg))
+
+	nextProgress := sendFileProgressEvery
+	deliver := func(events []buffer.Event) {
+		if len(events) == 0 {
+			return
+		}
+		report.Parsed += len(events)
+		routing.ApplyBatch(events)
+		failed := forwarder.Undelivered(fwd.Send(events), events)
+		report.Sent += len(events) - len(failed)
+		report.Failed += len(failed)
+		if report.Parsed >= nextProgress {
+			fmt.Fprintf(progress, "  %d events parsed, %d sent, %d failed\n", report.Parsed, report.Sent, report.Failed)
+			for nextProgress <= report.Parsed {
+				nextProgress += sendFileProgressEvery
+			}
+		}
+	}
+
+	afterLine := func(int) {
+		// Hold the newest event back: a traceback that follows is still
+		// attached to it after the line is read
+		if buf.Len() > batchSize {
+			events := buf.Flush()
+			newest := events[len(events)-1]
+			deliver(events[:len(events)-1])
+			buf.Add(newest)
+		}
+	}
+	var lines int
+	var err error
+	if opts.Input != nil {
+		lines, err = tailer.ReadLines(opts.Input, afterLine)
+	} else {
+		lines, err = tailer.ReadFile(opts.Path, afterLine)
+	}
+	report.Lines = lines
+	deliver(buf.Flush())
+	if err != nil {
+		return report, fmt.Errorf("read %s: %w", opts.Path, err)
+	}
+	return report, nil
+}
+
+// print writes the summary in the style of the other CLI summaries.
+func (r sendFileReport) print(w io.Writer, path string) {
+	symbol := "✓"
+	if r.Failed > 0 {
+		symbol = "✗"
+	}
+	fmt.Fprintf(w, "%s Sent %s\n", symbol, path)
+	fmt.Fprintf(w, "  Lines read: %d\n", r.Lines)
+	fmt.Fprintf(w, "  Events parsed: %d\n", r.Parsed)
+	fmt.Fprintf(w, "  Sent: %d\n", r.Sent)
+	fmt.Fprintf(w, "  Failed: %d\n", r.Failed)
+	fmt.Fprintf(w, "  Skipped lines: %d\n", r.Skipped())
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yaat-app/sidecar/internal/config"
+	"github.com/yaat-app/sidecar/internal/forwarder"
+)
+
+type sendFileTransport struct {
+	status   int
+	requests int
+	events   []map[string]interface{}
+}
+
+func (tr *sendFileTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tr.requests++
+	var payload struct {
+		Events []map[string]interface{} `json:"events"`
+	}
+	json.NewDecoder(req.Body).Decode(&payload)
+	req.Body.Close()
+	tr.events = append(tr.events, payload.Events...)
+	return &http.Response{
+		StatusCode: tr.status,
+		Header:     make(http.Header),
+		Body:       io.NopCloser(strings.NewReader("")),
+	}, nil
+}
+
+func sendFileConfig() *config.Config {
+	cfg := &config.Config{
+		OrganizationID: "org_test",
+		ServiceName:    "from-config",
+		Environment:    "production",
+		Tags:           map[string]string{"region": "eu"},
+	}
+	cfg.Delivery.BatchSize = 2
+	return cfg
+}
+
+func TestRunSendFile(t *testing.T) {
+	transport := &sendFileTransport{status: http.StatusOK}
+	fwd := forwarder.NewWithOptions("https://example.test/ingest", "key", forwarder.Options{BatchSize: 2})
+	fwd.SetHTTPClient(&http.Client{Transport: transport})
+
+	var progress bytes.Buffer
+	report, err := runSendFile(sendFileConfig(), fwd, sendFileOptions{
+		Path:        filepath.Join("testdata", "django.log"),
+		Format:      "django",
+		ServiceName: "support-upload",
+	}, &progress)
+	if err != nil {
+		t.Fatalf("runSendFile failed: %v", err)
+	}
+
+	// The traceback joins the ERROR event rather than becoming events of its own
+	if report.Lines != 9 || report.Parsed != 5 || report.Sent != 5 || report.Failed != 0 {
+		t.Errorf("Expected 9 lines, 5 parsed and sent, got %+v", report)
+	}
+	if report.Skipped() != 4 {
+		t.Errorf("Expected 4 skipped lines, got %d", report.Skipped())
+	}
+	if transport.requests < 3 {
+		t.Errorf("Expected batches of delivery.batch_size, got %d requests", transport.requests)
+	}
+	for _, event := range transport.events {
+		if event["service_name"] != "support-upload" {
+			t.Errorf("Expected --service-name override, got %v", event["service_name"])
+		}
+		if tags, _ := event["tags"].(map[string]interface{}); tags["region"] != "eu" {
+			t.Errorf("Expected global tags, got %v", event["tags"])
+		}
+		if event["level"] == "error" && !strings.Contains(event["stacktrace"].(string), "ZeroDivisionError") {
+			t.Errorf("Expected the traceback attached to the error event, got %v", event["stacktrace"])
+		}
+	}
+}
+
+func TestRunSendFileGzipAndFailures(t *testing.T) {
+	raw, err := os.ReadFile(filepath.Join("testdata", "django.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "django.log.gz")
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write(raw)
+	gz.Close()
+	if err := os.WriteFile(path, compressed.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	transport := &sendFileTransport{status: http.StatusBadRequest}
+	fwd := forwarder.NewWithOptions("https://example.test/ingest", "key", forwarder.Options{BatchSize: 2})
+	fwd.SetHTTPClient(&http.Client{Transport: transport})
+
+	report, err := runSendFile(sendFileConfig(), fwd, sendFileOptions{Path: path, Format: "django"}, io.Discard)
+	if err != nil {
+		t.Fatalf("runSendFile failed: %v", err)
+	}
+	if report.Parsed != 5 || report.Failed != 5 || report.Sent != 0 {
+		t.Errorf("Expected all 5 gzip events to fail, got %+v", report)
+	}
+
+	if _, err := runSendFile(sendFileConfig(), fwd, sendFileOptions{Path: path}, io.Discard); err == nil {
+		t.Error("Expected an error without --format")
+	}
+}
+
+func TestRunSendFileFromStdin(t *testing.T) {
+	transport := &sendFileTransport{status: http.StatusOK}
+	fwd := forwarder.NewWithOptions("https://example.test/ingest", "key", forwarder.Options{BatchSize: 2})
+	fwd.SetHTTPClient(&http.Client{Transport: transport})
+
+	input := strings.NewReader(`{"level":"info","message":"one"}` + "\n" + `{"level":"warning","message":"two"}` + "\n")
+	report, err := runSendFile(sendFileConfig(), fwd, sendFileOptions{Path: "stdin", Input: input, Format: "json"}, io.Discard)
+	if err != nil {
+		t.Fatalf("runSendFile failed: %v", err)
+	}
+	if report.Lines != 2 || report.Sent != 2 {
+		t.Errorf("Expected 2 lines read and sent, got %+v", report)
+	}
+	for _, event := range transport.events {
+		if tags, _ := event["tags"].(map[string]interface{}); tags["region"] != "eu" {
+			t.Errorf("Expected global tags, got %v", event["tags"])
+		}
+	}
+
+	_, err = runSendFile(sendFileConfig(), fwd, sendFileOptions{Path: "stdin", Input: strings.NewReader("")}, io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "--stdin needs --format") {
+		t.Errorf("Expected a --stdin format error, got %v", err)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+	"github.com/yaat-app/sidecar/internal/config"
+	"github.com/yaat-app/sidecar/internal/diag"
+	"github.com/yaat-app/sidecar/internal/forwarder"
+	"github.com/yaat-app/sidecar/internal/pipeline"
+)
+
+// hiddenFlags are accepted but left out of --help.
+var hiddenFlags = map[string]bool{
+	"stress":      true,
+	"rate":        true,
+	"duration":    true,
+	"stress-real": true,
+}
+
+// usage prints the flag defaults without the hidden flags.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage of %s:\n", os.Args[0])
+	visible := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	visible.SetOutput(out)
+	flag.VisitAll(func(f *flag.Flag) {
+		if hiddenFlags[f.Name] {
+			return
+		}
+		visible.Var(f.Value, f.Name, f.Usage)
+		visible.Lookup(f.Name).DefValue = f.DefValue
+	})
+	visible.PrintDefaults()
+}
+
+// stressReport summarizes a --stress run.
+type stressReport struct {
+	Elapsed    time.Duration
+	Generated  int64
+	Sent       int64
+	Failed     int64
+	Flushes    int
+	FlushP50   time.Duration
+	FlushP95   time.Duration
+	FlushMax   time.Duration
+	PeakQueue  int
+	FinalQueue int
+}
+
+// noopTransport accepts every request without sending it anywhere, so a
+// stress run measures the sidecar rather than the network.
+type noopTransport struct{}
+
+func (noopTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	io.Copy(io.Discard, req.Body)
+	req.Body.Close()
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(`{"accepted":true}`)),
+		Header:     make(http.Header),
+		Request:    req,
+	}, nil
+}
+
+// runStress generates synthetic log events into a buffer at rate events per
+// second for duration, flushing them through the delivery path with the
+// configured batching. Unless real is set, requests go to a no-op transport.
+func runStress(cfg *config.Config, rate int, duration time.Duration, real bool) (stressReport, error) {
+	var report stressReport
+	if rate <= 0 {
+		return report, fmt.Errorf("--rate must be positive")
+	}
+	if duration <= 0 {
+		return report, fmt.Errorf("--duration must be positive")
+	}
+
+	apiKey := "stress"
+	fwd := forwarder.NewWithOptions("http://stress.invalid/ingest", apiKey, forwarderOptionsFromConfig(cfg))
+	if real {
+		if cfg.APIKey == "" {
+			return report, fmt.Errorf("--stress-real needs api_key in the config")
+		}
+		apiKey = cfg.APIKey
+		fwd = forwarder.NewWithOptions(cfg.APIEndpoint, apiKey, forwarderOptionsFromConfig(cfg))
+	} else {
+		fwd.SetHTTPClient(&http.Client{Transport: noopTransport{}})
+	}
+
+	buf := buffer.New(cfg.BufferSize)
+	buf.SetHighWatermark(int(math.Ceil(float64(cfg.BufferSize) * cfg.FlushHighWatermark)))
+	pipe := pipeline.New(pipeline.Options{Buffer: buf, Forwarder: fwd, APIKey: apiKey})
+	before := diag.Global().Snapshot()
+
+	var (
+		mu        sync.Mutex
+		latencies []time.Duration
+	)
+	flush := func() {
+		start := time.Now()
+		if n, _ := pipe.Flush(); n == 0 {
+			return
+		}
+		mu.Lock()
+		latencies = append(latencies, time.Since(start))
+		mu.Unlock()
+	}
+
+	done := make(chan struct{})
+	flusherDone := make(chan struct{})
+	go func() {
+		defer close(flusherDone)
+		ticker := time.NewTicker(cfg.FlushIntervalDuration)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				flush()
+			case <-buf.FlushSignal():
+				flush()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	// Top up to rate × elapsed on every tick so slow ticks catch up
+	start := time.Now()
+	ticker := time.NewTicker(10 * time.Millisecond)
+	for now := range ticker.C {
+		elapsed := now.Sub(start)
+		if elapsed > duration {
+			elapsed = duration
+		}
+		target := int64(float64(rate) * elapsed.Seconds())
+		for ; report.Generated < target; report.Generated++ {
+			buf.Add(stressEvent(cfg, report.Generated))
+		}
+		if queued := buf.Len(); queued > report.PeakQueue {
+			report.PeakQueue = queued
+		}
+		if elapsed >= duration {
+			break
+		}
+	}
+	ticker.Stop()
+	close(done)
+	<-flusherDone
+
+	report.FinalQueue = buf.Len()
+	flush()
+	report.Elapsed = time.Since(start)
+
+	after := diag.Global().Snapshot()
+	report.Sent = after.TotalEventsSent - before.TotalEventsSent
+	report.Failed = after.TotalEventsFailed - before.TotalEventsFailed
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	report.Flushes = len(latencies)
+	if n := len(latencies); n > 0 {
+		report.FlushP50 = latencies[(n-1)/2]
+		report.FlushP95 = latencies[int(math.Ceil(0.95*float64(n)))-1]
+		report.FlushMax = latencies[n-1]
+	}
+	return report, nil
+}
+
+func stressEvent(cfg *config.Config, seq int64) buffer.Event {
+	return buffer.Event{
+		"organization_id": cfg.OrganizationID,
+		"service_name":    cfg.ServiceName,
+		"environment":     cfg.Environment,
+		"event_id":        uuid.NewString(),
+		"event_type":      "log",
+		"timestamp":       time.Now().UTC().Format(time.RFC3339Nano),
+		"level":           "info",
+		"message":         fmt.Sprintf("stress test event %d", seq),
+		"tags":            map[string]string{"yaat.stress": "true"},
+	}
+}
+
+// print writes the report in the style of the other CLI summaries.
+func (r stressReport) print(w io.Writer, rate int) {
+	seconds := r.Elapsed.Seconds()
+	fmt.Fprintf(w, "✓ Stress run finished in %v\n", r.Elapsed.Truncate(time.Millisecond))
+	fmt.Fprintf(w, "  Generated: %d events (%.0f/s, target %d/s)\n", r.Generated, float64(r.Generated)/seconds, rate)
+	fmt.Fprintf(w, "  Delivered: %d events (%.0f/s)\n", r.Sent, float64(r.Sent)/seconds)
+	if r.Failed > 0 {
+		fmt.Fprintf(w, "  Failed: %d events\n", r.Failed)
+	}
+	fmt.Fprintf(w, "  Flushes: %d (p50 %v, p95 %v, max %v)\n", r.Flushes, r.FlushP50.Truncate(time.Microsecond), r.FlushP95.Truncate(time.Microsecond), r.FlushMax.Truncate(time.Microsecond))
+	fmt.Fprintf(w, "  Buffer: peak %d events, %d left at the end\n", r.PeakQueue, r.FinalQueue)
+	if d := diag.Global().Snapshot().Delivery; d != nil && d.Requests > 0 {
+		fmt.Fprintf(w, "  Requests: p50 %dms, p95 %dms, avg payload %d bytes\n", d.P50LatencyMillis, d.P95LatencyMillis, d.AvgPayloadBytes)
+	}
+}
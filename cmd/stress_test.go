@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/config"
+)
+
+func TestRunStressNoop(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	cfg := &config.Config{
+		ServiceName:           "stress-svc",
+		Environment:           "test",
+		BufferSize:            200,
+		FlushHighWatermark:    0.8,
+		FlushIntervalDuration: 50 * time.Millisecond,
+	}
+
+	report, err := runStress(cfg, 2000, 300*time.Millisecond, false)
+	if err != nil {
+		t.Fatalf("runStress failed: %v", err)
+	}
+	if report.Generated != 600 {
+		t.Errorf("Expected 600 generated events, got %d", report.Generated)
+	}
+	if report.Sent != report.Generated || report.Failed != 0 {
+		t.Errorf("Expected every event delivered, got sent=%d failed=%d", report.Sent, report.Failed)
+	}
+	if report.Flushes == 0 || report.FlushMax < report.FlushP50 {
+		t.Errorf("Expected flush latencies, got %+v", report)
+	}
+
+	if _, err := runStress(cfg, 0, time.Second, false); err == nil {
+		t.Error("Expected an error for a zero rate")
+	}
+}
+
+func TestUsageHidesStressFlags(t *testing.T) {
+	saved := flag.CommandLine
+	defer func() { flag.CommandLine = saved }()
+	flag.CommandLine = flag.NewFlagSet("yaat-sidecar", flag.ContinueOnError)
+
+	var out bytes.Buffer
+	flag.CommandLine.SetOutput(&out)
+	flag.String("visible-test-flag", "", "shown")
+	flag.Bool("stress", false, "hidden")
+
+	usage()
+	if !strings.Contains(out.String(), "visible-test-flag") {
+		t.Errorf("Expected visible flags in usage, got %q", out.String())
+	}
+	if strings.Contains(out.String(), "-stress") {
+		t.Errorf("Expected --stress to be hidden, got %q", out.String())
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"syscall"
+
+	"github.com/hpcloud/tail"
+
+	"github.com/yaat-app/sidecar/internal/daemon"
+	"github.com/yaat-app/sidecar/internal/paths"
+)
+
+const (
+	ansiRed    = "\033[31m"
+	ansiYellow = "\033[33m"
+	ansiReset  = "\033[0m"
+)
+
+// resolveTailLogPath returns the log the daemon writes to: the layout's log
+// (or --log-file override), the other candidate location when only that
+// one exists, and otherwise where the next start will write.
+func resolveTailLogPath(layout paths.Layout) string {
+	if path := daemon.GetLogPath(layout); path != "" {
+		return path
+	}
+	return layout.LogFile
+}
+
+// newLineFilter matches lines against pattern as a regular expression, or as
+// a plain substring when it is not a valid one. An empty pattern matches all.
+func newLineFilter(pattern string) func(string) bool {
+	if pattern == "" {
+		return func(string) bool { return true }
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return func(line string) bool { return strings.Contains(line, pattern) }
+	}
+	return re.MatchString
+}
+
+// colorizeLine highlights warnings in yellow and errors in red, for both text
+// and JSON log formats. Text logs only mark warnings, so errors are
+// recognised by the words the sidecar uses for them.
+func colorizeLine(line string) string {
+	lower := strings.ToLower(line)
+	switch {
+	case strings.Contains(lower, `"level":"error"`),
+		!strings.HasPrefix(lower, "{") && (strings.Contains(lower, "error") || strings.Contains(lower, "failed") || strings.Contains(lower, "crashed")):
+		return ansiRed + line + ansiReset
+	case strings.Contains(lower, `"level":"warn`), strings.Contains(line, "Warning: "):
+		return ansiYellow + line + ansiReset
+	}
+	return line
+}
+
+// lastLines returns up to n complete lines from the end of path and the
+// file size they were read at.
+func lastLines(path string, n int) ([]string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	size := info.Size()
+	if n <= 0 {
+		return nil, size, nil
+	}
+
+	const chunk = 64 * 1024
+	var data []byte
+	offset := size
+	for offset > 0 && bytes.Count(data, []byte{'\n'}) <= n {
+		read := int64(chunk)
+		if read > offset {
+			read = offset
+		}
+		offset -= read
+		buf := make([]byte, read)
+		if _, err := f.ReadAt(buf, offset); err != nil && err != io.EOF {
+			return nil, 0, err
+		}
+		data = append(buf, data...)
+	}
+
+	text := strings.TrimRight(string(data), "\n")
+	if text == "" {
+		return nil, size, nil
+	}
+	lines := strings.Split(text, "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines, size, nil
+}
+
+// runTail prints the last lines of the daemon log and follows it until
+// interrupted, reopening it across rotations and waiting if it is missing.
+func runTail(path string, lines int, pattern string) error {
+	match := newLineFilter(pattern)
+	color := false
+	if info, err := os.Stdout.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		color = true
+	}
+	print := func(line string) {
+		if !match(line) {
+			return
+		}
+		if color {
+			line = colorizeLine(line)
+		}
+		fmt.Println(line)
+	}
+
+	recent, offset, err := lastLines(path, lines)
+	switch {
+	case os.IsNotExist(err):
+		fmt.Fprintf(os.Stderr, "Waiting for %s to be created...\n", path)
+	case err != nil:
+		return fmt.Errorf("read %s: %w", path, err)
+	default:
+		fmt.Fprintf(os.Stderr, "==> %s <==\n", path)
+	}
+	for _, line := range recent {
+		print(line)
+	}
+
+	follower, err := tail.TailFile(path, tail.Config{
+		Follow:    true,
+		ReOpen:    true,
+		MustExist: false,
+		Poll:      true,
+		Location:  &tail.SeekInfo{Offset: offset, Whence: io.SeekStart},
+		Logger:    tail.DiscardingLogger,
+	})
+	if err != nil {
+		return fmt.Errorf("follow %s: %w", path, err)
+	}
+	defer follower.Cleanup()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+
+	for {
+		select {
+		case line, ok := <-follower.Lines:
+			if !ok {
+				return follower.Err()
+			}
+			if line.Err != nil {
+				continue
+			}
+			print(line.Text)
+		case <-sigCh:
+			return follower.Stop()
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yaat-app/sidecar/internal/paths"
+)
+
+func TestResolveTailLogPath(t *testing.T) {
+	home := t.TempDir()
+	layout := paths.Resolver{Home: home, SystemRoot: t.TempDir()}.Resolve("tail-test-instance")
+
+	if got := resolveTailLogPath(layout.WithLogFile("/tmp/custom.log")); got != "/tmp/custom.log" {
+		t.Errorf("Expected --log-file to win, got %q", got)
+	}
+
+	// Nothing written yet: tail the resolved location
+	if got := resolveTailLogPath(layout); got != layout.LogFile {
+		t.Errorf("Expected %s, got %q", layout.LogFile, got)
+	}
+
+	// Only the system log exists, written by an earlier privileged run
+	system := layout.LogFiles()[1]
+	if err := os.MkdirAll(filepath.Dir(system), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(system, []byte("line\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := resolveTailLogPath(layout); got != system {
+		t.Errorf("Expected fallback %s, got %q", system, got)
+	}
+}
+
+func TestNewLineFilter(t *testing.T) {
+	cases := []struct {
+		pattern string
+		line    string
+		want    bool
+	}{
+		{"", "anything", true},
+		{"Forwarder", "[Forwarder] Sent 10 events", true},
+		{"Forwarder", "[Tailer] Started", false},
+		{`Sent \d+ events`, "[Forwarder] Sent 10 events", true},
+		{"(unclosed", "match (unclosed paren", true}, // Invalid regex falls back to a substring
+		{"(unclosed", "no paren here", false},
+	}
+	for _, c := range cases {
+		if got := newLineFilter(c.pattern)(c.line); got != c.want {
+			t.Errorf("Filter %q on %q = %v, expected %v", c.pattern, c.line, got, c.want)
+		}
+	}
+}
+
+func TestColorizeLine(t *testing.T) {
+	if got := colorizeLine("[Flusher] Failed to send events: timeout"); !strings.HasPrefix(got, ansiRed) {
+		t.Errorf("Expected error line in red, got %q", got)
+	}
+	if got := colorizeLine("[Tailer] Warning: file truncated"); !strings.HasPrefix(got, ansiYellow) {
+		t.Errorf("Expected warning line in yellow, got %q", got)
+	}
+	if got := colorizeLine(`{"level":"warn","message":"error budget low"}`); !strings.HasPrefix(got, ansiYellow) {
+		t.Errorf("Expected JSON warning in yellow, got %q", got)
+	}
+	if got := colorizeLine("[Tailer] Started tailing app.log"); got != "[Tailer] Started tailing app.log" {
+		t.Errorf("Expected info line unchanged, got %q", got)
+	}
+}
+
+func TestLastLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sidecar.log")
+	var content strings.Builder
+	for i := 1; i <= 100; i++ {
+		fmt.Fprintf(&content, "line %d\n", i)
+	}
+	if err := os.WriteFile(path, []byte(content.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	lines, offset, err := lastLines(path, 3)
+	if err != nil {
+		t.Fatalf("lastLines failed: %v", err)
+	}
+	if len(lines) != 3 || lines[0] != "line 98" || lines[2] != "line 100" {
+		t.Errorf("Expected the last 3 lines, got %v", lines)
+	}
+	if offset != int64(content.Len()) {
+		t.Errorf("Expected offset %d, got %d", content.Len(), offset)
+	}
+
+	if lines, _, _ := lastLines(path, 500); len(lines) != 100 {
+		t.Errorf("Expected all 100 lines, got %d", len(lines))
+	}
+}
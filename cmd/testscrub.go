@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+	"github.com/yaat-app/sidecar/internal/config"
+	"github.com/yaat-app/sidecar/internal/scrubber"
+)
+
+// testScrubOptions describes a --test-scrub run.
+type testScrubOptions struct {
+	Pattern     string
+	Replacement string
+	Field       string // As in a rule's fields: message, tags.user_id, tags.*, *
+	Drop        bool
+}
+
+// runTestScrub applies one rule, compiled as the daemon compiles it, to
+// each line of in and prints the value before and after. Go's regexp runs in
+// time linear in the input, so a slow pattern shows up in the timing rather
+// than hanging; Perl-only syntax fails to compile instead.
+func runTestScrub(opts testScrubOptions, in io.Reader, out io.Writer) error {
+	field := strings.TrimSpace(opts.Field)
+	if field == "" {
+		field = "message"
+	}
+	rule := config.ScrubRule{
+		Name:        "test",
+		Pattern:     opts.Pattern,
+		Replacement: opts.Replacement,
+		Fields:      []string{field},
+		Drop:        opts.Drop,
+	}
+	// Compile once up front so a bad pattern fails before any input is read
+	if _, err := scrubber.ApplyRule(rule, buffer.Event{}); err != nil {
+		if msg := err.Error(); strings.Contains(msg, "unsupported Perl syntax") || strings.Contains(msg, "invalid escape sequence") {
+			return fmt.Errorf("%w (lookarounds and backreferences are not supported)", err)
+		}
+		return err
+	}
+
+	action := fmt.Sprintf("replace with %q", opts.Replacement)
+	if opts.Drop {
+		action = "drop the event"
+	}
+	fmt.Fprintf(out, "Rule: /%s/ on %s, %s\n", strings.TrimSpace(opts.Pattern), field, action)
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		input := scanner.Text()
+		evt := sampleEvent(field, input)
+
+		start := time.Now()
+		kept, err := scrubber.ApplyRule(rule, evt)
+		elapsed := time.Since(start)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(out, "\nBefore: %s\n", input)
+		switch after := sampleValue(evt, field); {
+		case !kept:
+			fmt.Fprintln(out, "After:  ✗ event dropped")
+		case after == input:
+			fmt.Fprintf(out, "After:  %s (no match)\n", after)
+		default:
+			fmt.Fprintf(out, "After:  %s\n", after)
+		}
+		fmt.Fprintf(out, "Took:   %v\n", elapsed.Round(time.Microsecond))
+	}
+	return scanner.Err()
+}
+
+// sampleEvent builds an event holding input in field.
+func sampleEvent(field, input string) buffer.Event {
+	evt := buffer.Event{}
+	if key, ok := strings.CutPrefix(field, "tags."); ok {
+		if key == "*" || key == "" {
+			key = "value"
+		}
+		evt["tags"] = map[string]string{key: input}
+		return evt
+	}
+	if field == "*" {
+		field = "message"
+	}
+	evt[field] = input
+	return evt
+}
+
+// sampleValue reads back the value sampleEvent stored.
+func sampleValue(evt buffer.Event, field string) string {
+	if key, ok := strings.CutPrefix(field, "tags."); ok {
+		for k, v := range evt["tags"].(map[string]string) {
+			if k == key || key == "*" || key == "" {
+				return v
+			}
+		}
+		return ""
+	}
+	if field == "*" {
+		field = "message"
+	}
+	value, _ := evt[field].(string)
+	return value
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRunTestScrub(t *testing.T) {
+	var out bytes.Buffer
+	err := runTestScrub(testScrubOptions{
+		Pattern:     `(?i)[A-Z0-9._%+-]+@[A-Z0-9.-]+\.[A-Z]{2,}`,
+		Replacement: "[EMAIL]",
+	}, strings.NewReader("login by jane@example.com\nno address here\n"), &out)
+	if err != nil {
+		t.Fatalf("runTestScrub failed: %v", err)
+	}
+	for _, want := range []string{"After:  login by [EMAIL]", "After:  no address here (no match)"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestRunTestScrubDropAndTags(t *testing.T) {
+	var out bytes.Buffer
+	err := runTestScrub(testScrubOptions{Pattern: "^internal-", Field: "tags.user_id", Drop: true},
+		strings.NewReader("internal-42\n"), &out)
+	if err != nil {
+		t.Fatalf("runTestScrub failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "event dropped") {
+		t.Errorf("Expected the event dropped, got:\n%s", out.String())
+	}
+}
+
+func TestRunTestScrubInvalidPattern(t *testing.T) {
+	err := runTestScrub(testScrubOptions{Pattern: `(\w+)\s\1`}, strings.NewReader("a a"), &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "backreferences") {
+		t.Errorf("Expected an unsupported syntax error, got %v", err)
+	}
+}
@@ -6,7 +6,9 @@ require (
 	github.com/charmbracelet/bubbles v0.21.0
 	github.com/charmbracelet/bubbletea v1.3.10
 	github.com/charmbracelet/lipgloss v1.1.0
+	github.com/charmbracelet/x/term v0.2.1
 	github.com/coreos/go-systemd/v22 v22.6.0
+	github.com/duckdb/duckdb-go/v2 v2.5.1
 	github.com/google/uuid v1.6.0
 	github.com/hpcloud/tail v1.0.0
 	golang.org/x/sys v0.36.0
@@ -20,7 +22,6 @@ require (
 	github.com/charmbracelet/colorprofile v0.2.3-0.20250311203215-f60798e515dc // indirect
 	github.com/charmbracelet/x/ansi v0.10.1 // indirect
 	github.com/charmbracelet/x/cellbuf v0.0.13-0.20250311204145-2c3ea96c31dd // indirect
-	github.com/charmbracelet/x/term v0.2.1 // indirect
 	github.com/duckdb/duckdb-go-bindings v0.1.22 // indirect
 	github.com/duckdb/duckdb-go-bindings/darwin-amd64 v0.1.22 // indirect
 	github.com/duckdb/duckdb-go-bindings/darwin-arm64 v0.1.22 // indirect
@@ -29,7 +30,6 @@ require (
 	github.com/duckdb/duckdb-go-bindings/windows-amd64 v0.1.22 // indirect
 	github.com/duckdb/duckdb-go/arrowmapping v0.0.24 // indirect
 	github.com/duckdb/duckdb-go/mapping v0.0.24 // indirect
-	github.com/duckdb/duckdb-go/v2 v2.5.1 // indirect
 	github.com/erikgeiser/coninput v0.0.0-20211004153227-1c3628e74d0f // indirect
 	github.com/fsnotify/fsnotify v1.7.0 // indirect
 	github.com/go-viper/mapstructure/v2 v2.4.0 // indirect
@@ -0,0 +1,106 @@
+package analytics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+)
+
+// maxFailureErrorBytes bounds the delivery_error tag; response bodies can be long.
+const maxFailureErrorBytes = 512
+
+// FailureRecorder stores batches whose cloud delivery failed for good, for
+// analytics.record_failures_only. The database is opened on the first
+// failure, so hosts that never lose events never create it.
+type FailureRecorder struct {
+	config Config
+
+	mu     sync.Mutex
+	writer *Writer
+	failed bool // Opening the database failed; recording stays off
+}
+
+// NewFailureRecorder returns a recorder that opens the database described
+// by cfg once there is something to record.
+func NewFailureRecorder(cfg Config) *FailureRecorder {
+	return &FailureRecorder{config: cfg}
+}
+
+// Record writes events tagged delivery_failed=true with reason (one of the
+// pipeline.Failure constants) and, when not empty, the delivery error. The
+// events themselves are not modified.
+func (r *FailureRecorder) Record(events []buffer.Event, reason, deliveryErr string) {
+	if r == nil || len(events) == 0 {
+		return
+	}
+	w := r.open()
+	if w == nil {
+		return
+	}
+	if len(deliveryErr) > maxFailureErrorBytes {
+		deliveryErr = deliveryErr[:maxFailureErrorBytes]
+	}
+
+	tagged := make([]buffer.Event, 0, len(events))
+	for _, event := range events {
+		copied := make(buffer.Event, len(event))
+		for k, v := range event {
+			copied[k] = v
+		}
+		existing := buffer.StringTags(event["tags"])
+		tags := make(map[string]string, len(existing)+3)
+		for k, v := range existing {
+			tags[k] = v
+		}
+		tags["delivery_failed"] = "true"
+		tags["delivery_failure_reason"] = reason
+		if deliveryErr != "" {
+			tags["delivery_error"] = deliveryErr
+		}
+		copied["tags"] = tags
+		tagged = append(tagged, copied)
+	}
+	if err := w.Write(tagged); err != nil {
+		logger.Errorf("Failed to record %d undelivered events: %v", len(events), err)
+	}
+}
+
+// open returns the writer, opening the database on first use.
+func (r *FailureRecorder) open() *Writer {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.writer != nil || r.failed {
+		return r.writer
+	}
+	w, err := NewWriter(r.config)
+	if err != nil {
+		r.failed = true
+		logger.Errorf("Failed to open database for undelivered events: %v", err)
+		return nil
+	}
+	w.StartRetentionCleanup(24 * time.Hour)
+	logger.Infof("Recording undelivered events in %s", r.config.DatabasePath)
+	r.writer = w
+	return w
+}
+
+// Writer returns the writer once the first failure opened it, else nil.
+func (r *FailureRecorder) Writer() *Writer {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.writer
+}
+
+// Close writes out pending events and closes the database, if it was opened.
+func (r *FailureRecorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.writer == nil {
+		return nil
+	}
+	err := r.writer.Close()
+	r.writer = nil
+	r.failed = true // Late failures are not recorded after shutdown
+	return err
+}
@@ -0,0 +1,163 @@
+package analytics
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// indexedTag is a tag promoted to its own indexed column.
+type indexedTag struct {
+	Tag    string
+	Column string
+}
+
+// tagColumn names the column holding tag: "tag_" followed by the tag with
+// every character other than ASCII letters and digits replaced by "_", so
+// k8s.namespace is stored in tag_k8s_namespace.
+func tagColumn(tag string) string {
+	var b strings.Builder
+	b.WriteString("tag_")
+	for _, r := range strings.ToLower(tag) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// tagPath is the JSON path of tag in the tags column.
+func tagPath(tag string) string {
+	return `$."` + tag + `"`
+}
+
+// loadIndexedTags returns the promoted tags in column order.
+func loadIndexedTags(db *sql.DB) ([]indexedTag, error) {
+	rows, err := db.Query("SELECT tag, column_name FROM indexed_tags ORDER BY position")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list indexed tags: %w", err)
+	}
+	defer rows.Close()
+
+	var out []indexedTag
+	for rows.Next() {
+		var it indexedTag
+		if err := rows.Scan(&it.Tag, &it.Column); err != nil {
+			return nil, fmt.Errorf("failed to read indexed tags: %w", err)
+		}
+		out = append(out, it)
+	}
+	return out, rows.Err()
+}
+
+// promoteTags gives every tag in tags that is not yet promoted a column,
+// fills it from the JSON tags of existing rows and indexes it, then returns
+// all promoted tags in column order. Tags dropped from the configuration keep
+// their column; it goes on being filled so queries stay fast.
+func promoteTags(db *sql.DB, tags []string) ([]indexedTag, error) {
+	existing, err := loadIndexedTags(db)
+	if err != nil {
+		return nil, err
+	}
+	byColumn := make(map[string]string, len(existing)+len(tags))
+	for _, it := range existing {
+		byColumn[it.Column] = it.Tag
+	}
+
+	for _, tag := range tags {
+		if tag == "" || strings.ContainsRune(tag, '"') {
+			return nil, fmt.Errorf("invalid indexed tag %q", tag)
+		}
+		column := tagColumn(tag)
+		if other, ok := byColumn[column]; ok {
+			if other != tag {
+				return nil, fmt.Errorf("indexed tags %q and %q would share column %s", other, tag, column)
+			}
+			continue
+		}
+		if err := addTagColumn(db, tag, column, len(byColumn)); err != nil {
+			return nil, err
+		}
+		byColumn[column] = tag
+		existing = append(existing, indexedTag{Tag: tag, Column: column})
+	}
+
+	// DuckDB cannot index a column in the transaction that filled it, so
+	// indexes are created here, and recreated if a previous start was cut short
+	for _, it := range existing {
+		if _, err := db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_events_%s ON events(%s)", it.Column, it.Column)); err != nil {
+			return nil, fmt.Errorf("failed to index tag %q: %w", it.Tag, err)
+		}
+	}
+	return existing, nil
+}
+
+// addTagColumn adds and backfills the column for one tag.
+func addTagColumn(db *sql.DB, tag, column string, position int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // No-op if committed
+
+	if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE events ADD COLUMN %s VARCHAR", column)); err != nil {
+		return fmt.Errorf("failed to add column for tag %q: %w", tag, err)
+	}
+	result, err := tx.Exec(fmt.Sprintf("UPDATE events SET %s = json_extract_string(tags, ?) WHERE tags <> '{}'", column), tagPath(tag))
+	if err != nil {
+		return fmt.Errorf("failed to backfill tag %q: %w", tag, err)
+	}
+	if _, err := tx.Exec("INSERT INTO indexed_tags (tag, column_name, position) VALUES (?, ?, ?)", tag, column, position); err != nil {
+		return fmt.Errorf("failed to record indexed tag %q: %w", tag, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit indexed tag %q: %w", tag, err)
+	}
+
+	backfilled, _ := result.RowsAffected()
+	logger.Infof("Indexed tag %q as column %s (%d existing rows backfilled)", tag, column, backfilled)
+	return nil
+}
+
+// tagExpr returns the SQL expression reading tag and its argument: the
+// promoted column when there is one, otherwise a lookup in the JSON tags.
+func (w *Writer) tagExpr(tag string) (string, []any) {
+	for _, it := range w.indexed {
+		if it.Tag == tag {
+			return it.Column, nil
+		}
+	}
+	return "json_extract_string(tags, ?)", []any{tagPath(tag)}
+}
+
+// CountByTags counts stored events whose tags equal every value in filters.
+// Promoted tags are matched on their indexed column.
+func (w *Writer) CountByTags(filters map[string]string) (int64, error) {
+	keys := make([]string, 0, len(filters))
+	for k := range filters {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	query := "SELECT COUNT(*) FROM events"
+	var args []any
+	for i, k := range keys {
+		expr, exprArgs := w.tagExpr(k)
+		if i == 0 {
+			query += " WHERE "
+		} else {
+			query += " AND "
+		}
+		query += expr + " = ?"
+		args = append(append(args, exprArgs...), filters[k])
+	}
+
+	var n int64
+	if err := w.db.QueryRow(query, args...).Scan(&n); err != nil {
+		return 0, fmt.Errorf("failed to count events: %w", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,170 @@
+package analytics
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/yaat-app/sidecar/internal/buffer"
+)
+
+func openWriter(tb testing.TB, path string, tags ...string) *Writer {
+	tb.Helper()
+	w, err := NewWriter(Config{
+		DatabasePath:   path,
+		OrganizationID: "local",
+		ServiceName:    "svc",
+		Environment:    "test",
+		IndexedTags:    tags,
+	})
+	if err != nil {
+		tb.Fatalf("Failed to create writer: %v", err)
+	}
+	return w
+}
+
+func taggedEvent(tags map[string]string) buffer.Event {
+	return buffer.Event{"event_id": uuid.NewString(), "event_type": "log", "tags": tags}
+}
+
+func TestIndexedTagsWrittenAndQueried(t *testing.T) {
+	w := openWriter(t, filepath.Join(t.TempDir(), "analytics.db"), "path", "k8s.namespace")
+	defer w.Close()
+
+	batch := []buffer.Event{
+		taggedEvent(map[string]string{"path": "/api", "k8s.namespace": "prod", "user": "a"}),
+		taggedEvent(map[string]string{"path": "/api"}),
+	}
+	if err := w.writeBatchAppender(batch); err != nil {
+		t.Fatalf("Appender write failed: %v", err)
+	}
+	if err := w.writeBatchStmt([]buffer.Event{taggedEvent(map[string]string{"path": "/health", "user": "a"})}); err != nil {
+		t.Fatalf("Statement write failed: %v", err)
+	}
+
+	var nulls int
+	if err := w.db.QueryRow("SELECT COUNT(*) FROM events WHERE tag_k8s_namespace IS NULL").Scan(&nulls); err != nil {
+		t.Fatalf("Expected a tag_k8s_namespace column: %v", err)
+	}
+	if nulls != 2 {
+		t.Errorf("Expected NULL for events without the tag, got %d", nulls)
+	}
+
+	if expr, _ := w.tagExpr("path"); expr != "tag_path" {
+		t.Errorf("Expected the promoted column for path, got %s", expr)
+	}
+	for _, tc := range []struct {
+		filters map[string]string
+		want    int64
+	}{
+		{map[string]string{"path": "/api"}, 2},
+		{map[string]string{"path": "/api", "k8s.namespace": "prod"}, 1},
+		{map[string]string{"user": "a"}, 2}, // Not indexed: read from the JSON column
+		{map[string]string{"path": "/health", "user": "a"}, 1},
+	} {
+		got, err := w.CountByTags(tc.filters)
+		if err != nil {
+			t.Fatalf("CountByTags(%v) failed: %v", tc.filters, err)
+		}
+		if got != tc.want {
+			t.Errorf("Expected %d events for %v, got %d", tc.want, tc.filters, got)
+		}
+	}
+}
+
+func TestIndexedTagAddedToExistingDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "analytics.db")
+	w := openWriter(t, path)
+	if err := w.writeBatch(makeBatch(30)); err != nil {
+		t.Fatalf("writeBatch failed: %v", err)
+	}
+	w.Close()
+
+	// Reopening with a new indexed tag adds and backfills its column
+	w = openWriter(t, path, "i")
+	if n, err := w.CountByTags(map[string]string{"i": "7"}); err != nil || n != 1 {
+		t.Errorf("Expected the backfilled row, got %d (%v)", n, err)
+	}
+	var filled int
+	w.db.QueryRow("SELECT COUNT(tag_i) FROM events").Scan(&filled)
+	if filled != 30 {
+		t.Errorf("Expected all 30 existing rows backfilled, got %d", filled)
+	}
+	w.Close()
+
+	// Dropping the tag from the list keeps the column populated
+	w = openWriter(t, path)
+	if err := w.writeBatch(makeBatch(3)); err != nil {
+		t.Fatalf("writeBatch failed: %v", err)
+	}
+	w.db.QueryRow("SELECT COUNT(tag_i) FROM events").Scan(&filled)
+	if filled != 33 {
+		t.Errorf("Expected the kept column filled for new rows, got %d", filled)
+	}
+	w.Close()
+
+	_, err := NewWriter(Config{DatabasePath: path, IndexedTags: []string{"i", "I"}})
+	if err == nil || !strings.Contains(err.Error(), "share column") {
+		t.Errorf("Expected a column collision error, got %v", err)
+	}
+}
+
+// benchFixtureRows is the size of the table the tag filter benchmarks query.
+const benchFixtureRows = 1_000_000
+
+// benchmarkTagFilter fills a database with benchFixtureRows events spread over
+// 100 paths, optionally promotes path, and counts the events of one path.
+func benchmarkTagFilter(b *testing.B, indexed bool) {
+	path := filepath.Join(b.TempDir(), "analytics.db")
+	w := openWriter(b, path)
+	_, err := w.db.Exec(fmt.Sprintf(`
+		INSERT INTO events (organization_id, service_name, event_id, timestamp, received_at, event_type, level, environment, tags)
+		SELECT 'local', 'svc', 'evt-' || i, now(), now(), 'log', 'info', 'test',
+			'{"path":"/route/' || (i %% 100) || '","user":"u' || i || '"}'
+		FROM range(%d) t(i)`, benchFixtureRows))
+	if err != nil {
+		b.Fatalf("Failed to build fixture: %v", err)
+	}
+	w.Close()
+
+	var tags []string
+	if indexed {
+		tags = []string{"path"}
+	}
+	w = openWriter(b, path, tags...)
+	defer w.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		n, err := w.CountByTags(map[string]string{"path": "/route/42"})
+		if err != nil {
+			b.Fatalf("CountByTags failed: %v", err)
+		}
+		if n != benchFixtureRows/100 {
+			b.Fatalf("Expected %d events, got %d", benchFixtureRows/100, n)
+		}
+	}
+}
+
+func BenchmarkTagFilterJSON(b *testing.B)    { benchmarkTagFilter(b, false) }
+func BenchmarkTagFilterIndexed(b *testing.B) { benchmarkTagFilter(b, true) }
+
+func TestIndexedTagsOfReplayedBatch(t *testing.T) {
+	w := openWriter(t, filepath.Join(t.TempDir(), "analytics.db"), "path")
+	defer w.Close()
+
+	// Tags as decoded from a spilled or queued batch
+	evt := buffer.Event{"event_id": uuid.NewString(), "event_type": "log", "tags": map[string]interface{}{"path": "/api"}}
+	if err := w.writeBatch([]buffer.Event{evt}); err != nil {
+		t.Fatalf("writeBatch failed: %v", err)
+	}
+	var path *string
+	if err := w.db.QueryRow("SELECT tag_path FROM events").Scan(&path); err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if path == nil || *path != "/api" {
+		t.Errorf("Expected the promoted column filled, got %v", path)
+	}
+}
@@ -2,7 +2,6 @@ package analytics
 
 import (
 	"fmt"
-	"log"
 	"time"
 )
 
@@ -22,14 +21,14 @@ func (w *Writer) StartRetentionCleanup(interval time.Duration) {
 
 		// Run cleanup immediately on start
 		if err := w.runRetentionCleanup(); err != nil {
-			log.Printf("[Analytics] Initial retention cleanup failed: %v", err)
+			logger.Errorf("Initial retention cleanup failed: %v", err)
 		}
 
 		for {
 			select {
 			case <-ticker.C:
 				if err := w.runRetentionCleanup(); err != nil {
-					log.Printf("[Analytics] Retention cleanup failed: %v", err)
+					logger.Errorf("Retention cleanup failed: %v", err)
 				}
 
 			case <-w.closeChan:
@@ -53,7 +52,7 @@ func (w *Writer) runRetentionCleanup() error {
 
 	// Step 3: Vacuum database to reclaim space
 	if err := w.vacuumDatabase(); err != nil {
-		log.Printf("[Analytics] Vacuum failed (non-fatal): %v", err)
+		logger.Warnf("Vacuum failed (non-fatal): %v", err)
 		// Don't return error - vacuum is a best-effort operation
 	}
 
@@ -79,7 +78,7 @@ func (w *Writer) deleteOldEvents() error {
 	}
 
 	if rowsDeleted > 0 {
-		log.Printf("[Analytics] Retention cleanup: deleted %d events older than %dd",
+		logger.Infof("Retention cleanup: deleted %d events older than %dd",
 			rowsDeleted, w.config.RetentionDays)
 	}
 
@@ -113,7 +112,7 @@ func (w *Writer) enforceMaxSize() error {
 	estimatedEventSize := int64(1024)
 	eventsToDelete := targetDeleteBytes / estimatedEventSize
 
-	log.Printf("[Analytics] Database size %.2fGB exceeds limit %.2fGB, deleting ~%d oldest events",
+	logger.Warnf("Database size %.2fGB exceeds limit %.2fGB, deleting ~%d oldest events",
 		currentSizeGB, w.config.MaxSizeGB, eventsToDelete)
 
 	// Delete oldest events
@@ -135,7 +134,7 @@ func (w *Writer) enforceMaxSize() error {
 		return err
 	}
 
-	log.Printf("[Analytics] Aggressive cleanup: deleted %d events to free space", rowsDeleted)
+	logger.Warnf("Aggressive cleanup: deleted %d events to free space", rowsDeleted)
 
 	return nil
 }
@@ -147,7 +146,7 @@ func (w *Writer) vacuumDatabase() error {
 		return err
 	}
 
-	log.Printf("[Analytics] Database vacuumed")
+	logger.Infof("Database vacuumed")
 	return nil
 }
 
@@ -60,8 +60,18 @@ CREATE TABLE IF NOT EXISTS schema_version (
 );
 `
 
+// Tags promoted to their own columns by analytics.indexed_tags, in the order
+// the columns were added to events
+const createIndexedTagsTableSQL = `
+CREATE TABLE IF NOT EXISTS indexed_tags (
+    tag VARCHAR PRIMARY KEY,
+    column_name VARCHAR NOT NULL,
+    position INTEGER NOT NULL
+);
+`
+
 // schemaVersion is the current schema version
-const schemaVersion = 1
+const schemaVersion = 2
 
 // initializeSchema creates the database schema if it doesn't exist
 func initializeSchema(db *sql.DB) error {
@@ -90,12 +100,11 @@ func initializeSchema(db *sql.DB) error {
 		}
 	}
 
-	// Future migrations would go here:
-	// if currentVersion < 2 {
-	//     if err := applyMigrationV2(db); err != nil {
-	//         return err
-	//     }
-	// }
+	if currentVersion < 2 {
+		if err := applyMigrationV2(db); err != nil {
+			return fmt.Errorf("failed to apply migration v2: %w", err)
+		}
+	}
 
 	return nil
 }
@@ -130,6 +139,29 @@ func applyMigrationV1(db *sql.DB) error {
 	return nil
 }
 
+// applyMigrationV2 adds the registry of promoted tag columns. The columns
+// themselves are added by promoteTags as analytics.indexed_tags grows.
+func applyMigrationV2(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // No-op if committed
+
+	if _, err := tx.Exec(createIndexedTagsTableSQL); err != nil {
+		return fmt.Errorf("failed to create indexed_tags table: %w", err)
+	}
+	if _, err := tx.Exec("INSERT INTO schema_version (version) VALUES (?)", 2); err != nil {
+		return fmt.Errorf("failed to record schema version: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migration: %w", err)
+	}
+
+	return nil
+}
+
 // getSchemaVersion returns the current schema version
 func getSchemaVersion(db *sql.DB) (int, error) {
 	var version int
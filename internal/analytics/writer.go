@@ -1,29 +1,36 @@
 package analytics
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	_ "github.com/duckdb/duckdb-go/v2" // DuckDB driver
+	duckdb "github.com/duckdb/duckdb-go/v2" // DuckDB driver
 	"github.com/yaat-app/sidecar/internal/buffer"
+	"github.com/yaat-app/sidecar/internal/config"
+	"github.com/yaat-app/sidecar/internal/logging"
+	"github.com/yaat-app/sidecar/internal/queue"
 )
 
+var logger = logging.New("Analytics")
+
 const (
 	// Default values
 	defaultBatchSize    = 500
 	defaultWriteTimeout = 5 * time.Second
-	defaultQueueDepth   = 10 // Buffer 10 batches
 
-	// Truncation limits
-	maxMessageSize    = 100_000 // 100KB
-	maxStacktraceSize = 50_000  // 50KB
+	// Overflow spill defaults
+	defaultMaxSpillBytes = 64 << 20 // 64MB
+	spillReplayInterval  = 5 * time.Second
 
 	// Retry configuration
 	maxRetries     = 3
@@ -40,6 +47,21 @@ type Config struct {
 	MaxSizeGB      float64
 	BatchSize      int
 	WriteTimeout   time.Duration
+
+	// Truncation limits for message/stacktrace fields; 0 disables truncation
+	MaxMessageBytes    int
+	MaxStacktraceBytes int
+
+	// SpillOverflow writes batches that do not fit in the in-memory queue to
+	// a bounded directory next to the database instead of dropping them
+	SpillOverflow bool
+	MaxSpillBytes int64
+	// SpillEncryptionKey encrypts spilled batches like the delivery queue's
+	SpillEncryptionKey []byte
+
+	// IndexedTags are stored in indexed columns of their own as well as in
+	// the JSON tags column, for fast filtering
+	IndexedTags []string
 }
 
 // Writer handles async writes to DuckDB analytics database
@@ -52,9 +74,20 @@ type Writer struct {
 	insertStmt     *sql.Stmt
 	insertStmtLock sync.Mutex
 
+	// Promoted tag columns, in table order after the fixed columns
+	indexed []indexedTag
+
+	// Bulk inserts go through the DuckDB Appender until it proves unavailable
+	appenderDisabled atomic.Bool
+	appendRow        func(buffer.Event) []driver.Value // Row values for the appender; eventRow outside tests
+
+	// Overflow batches waiting on disk (nil when spilling is disabled)
+	spill *queue.Storage
+
 	// Metrics
 	totalWritten  int64
 	totalDropped  int64
+	totalSpilled  int64
 	lastWriteTime atomic.Value // time.Time
 }
 
@@ -62,6 +95,8 @@ type Writer struct {
 type Stats struct {
 	TotalWritten  int64
 	TotalDropped  int64
+	TotalSpilled  int64 // Events written to the overflow spill
+	SpillPending  int   // Events still waiting in the spill
 	QueueDepth    int
 	LastWriteTime time.Time
 }
@@ -75,6 +110,9 @@ func NewWriter(cfg Config) (*Writer, error) {
 	if cfg.WriteTimeout == 0 {
 		cfg.WriteTimeout = defaultWriteTimeout
 	}
+	if cfg.MaxSpillBytes <= 0 {
+		cfg.MaxSpillBytes = defaultMaxSpillBytes
+	}
 
 	// Expand tilde in database path
 	dbPath := cfg.DatabasePath
@@ -109,16 +147,23 @@ func NewWriter(cfg Config) (*Writer, error) {
 		return nil, fmt.Errorf("failed to initialize schema: %w", err)
 	}
 
+	indexed, err := promoteTags(db, cfg.IndexedTags)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to index tags: %w", err)
+	}
+
 	// Prepare insert statement
-	insertSQL := `
-		INSERT INTO events (
-			organization_id, service_name, event_id, timestamp, received_at,
+	columns := `organization_id, service_name, event_id, timestamp, received_at,
 			event_type, level, message, stacktrace,
 			trace_id, span_id, parent_span_id, operation, duration_ms, status_code,
 			metric_name, metric_value,
-			environment, tags
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`
+			environment, tags`
+	placeholders := strings.Repeat("?, ", 19+len(indexed))
+	for _, it := range indexed {
+		columns += ", " + it.Column
+	}
+	insertSQL := fmt.Sprintf("INSERT INTO events (%s) VALUES (%s)", columns, strings.TrimSuffix(placeholders, ", "))
 	stmt, err := db.Prepare(insertSQL)
 	if err != nil {
 		db.Close()
@@ -128,16 +173,30 @@ func NewWriter(cfg Config) (*Writer, error) {
 	w := &Writer{
 		db:         db,
 		config:     cfg,
-		queue:      make(chan []buffer.Event, defaultQueueDepth),
+		queue:      make(chan []buffer.Event, config.DefaultAnalyticsQueueDepth),
 		closeChan:  make(chan struct{}),
 		insertStmt: stmt,
+		indexed:    indexed,
+	}
+	w.appendRow = w.eventRow
+
+	if cfg.SpillOverflow {
+		spill, err := queue.New(filepath.Join(dbDir, "spill"))
+		if err == nil && cfg.SpillEncryptionKey != nil {
+			err = spill.SetEncryptionKey(cfg.SpillEncryptionKey)
+		}
+		if err != nil {
+			logger.Warnf("Overflow spill disabled: %v", err)
+		} else {
+			w.spill = spill
+		}
 	}
 
 	// Start async writer goroutine
 	w.wg.Add(1)
 	go w.processQueue()
 
-	log.Printf("[Analytics] Initialized: %s (retention: %dd, max: %.1fGB)",
+	logger.Infof("Initialized: %s (retention: %dd, max: %.1fGB)",
 		dbPath, cfg.RetentionDays, cfg.MaxSizeGB)
 
 	return w, nil
@@ -153,6 +212,14 @@ func (w *Writer) Write(events []buffer.Event) error {
 	case w.queue <- events:
 		return nil
 	default:
+		if w.spill != nil {
+			err := w.spillBatch(events)
+			if err == nil {
+				return nil
+			}
+			logger.Errorf("Failed to spill overflow batch: %v", err)
+		}
+
 		// Queue full - drop events
 		dropped := int64(len(events))
 		atomic.AddInt64(&w.totalDropped, dropped)
@@ -160,20 +227,84 @@ func (w *Writer) Write(events []buffer.Event) error {
 	}
 }
 
+// spillBatch persists an overflow batch, discarding the oldest spilled
+// batches once the spill exceeds its size bound.
+func (w *Writer) spillBatch(events []buffer.Event) error {
+	if err := w.spill.Enqueue(events); err != nil {
+		return err
+	}
+	atomic.AddInt64(&w.totalSpilled, int64(len(events)))
+
+	dropped, err := w.spill.Trim(w.config.MaxSpillBytes)
+	if dropped > 0 {
+		atomic.AddInt64(&w.totalDropped, int64(dropped))
+		logger.Warnf("Overflow spill full, dropped %d oldest events", dropped)
+	}
+	if err != nil {
+		logger.Errorf("Failed to trim overflow spill: %v", err)
+	}
+	return nil
+}
+
+// replaySpill writes spilled batches back while the in-memory queue is idle.
+// Fresh batches always take priority so the queue does not overflow again.
+func (w *Writer) replaySpill() {
+	if w.spill == nil {
+		return
+	}
+	for len(w.queue) == 0 {
+		select {
+		case <-w.closeChan:
+			// Leave the rest for the next start
+			return
+		default:
+		}
+
+		token, events, err := w.spill.Dequeue()
+		if err != nil {
+			logger.Errorf("Failed to read overflow spill: %v", err)
+			return
+		}
+		if events == nil {
+			return
+		}
+
+		w.writeEvents(events)
+		if err := w.spill.Ack(token); err != nil {
+			logger.Errorf("Failed to remove replayed spill batch: %v", err)
+		}
+	}
+}
+
+// writeEvents writes a batch and updates the writer counters
+func (w *Writer) writeEvents(events []buffer.Event) {
+	if err := w.writeBatchWithRetry(events); err != nil {
+		logger.Errorf("Failed to write batch: %v", err)
+		atomic.AddInt64(&w.totalDropped, int64(len(events)))
+	} else {
+		atomic.AddInt64(&w.totalWritten, int64(len(events)))
+		w.lastWriteTime.Store(time.Now())
+	}
+}
+
 // processQueue runs in a goroutine and processes the write queue
 func (w *Writer) processQueue() {
 	defer w.wg.Done()
 
+	ticker := time.NewTicker(spillReplayInterval)
+	defer ticker.Stop()
+
+	// Batches spilled before a restart are replayed first
+	w.replaySpill()
+
 	for {
 		select {
 		case events := <-w.queue:
-			if err := w.writeBatchWithRetry(events); err != nil {
-				log.Printf("[Analytics] Failed to write batch: %v", err)
-				atomic.AddInt64(&w.totalDropped, int64(len(events)))
-			} else {
-				atomic.AddInt64(&w.totalWritten, int64(len(events)))
-				w.lastWriteTime.Store(time.Now())
-			}
+			w.writeEvents(events)
+			w.replaySpill()
+
+		case <-ticker.C:
+			w.replaySpill()
 
 		case <-w.closeChan:
 			// Drain remaining events
@@ -181,7 +312,7 @@ func (w *Writer) processQueue() {
 				select {
 				case events := <-w.queue:
 					if err := w.writeBatchWithRetry(events); err != nil {
-						log.Printf("[Analytics] Failed to write batch during shutdown: %v", err)
+						logger.Errorf("Failed to write batch during shutdown: %v", err)
 					}
 				default:
 					return
@@ -211,14 +342,87 @@ func (w *Writer) writeBatchWithRetry(events []buffer.Event) error {
 			return fmt.Errorf("database closed: %w", err)
 		}
 
-		log.Printf("[Analytics] Write attempt %d/%d failed: %v", attempt+1, maxRetries, err)
+		logger.Warnf("Write attempt %d/%d failed: %v", attempt+1, maxRetries, err)
 	}
 
 	return fmt.Errorf("failed after %d retries", maxRetries)
 }
 
-// writeBatch writes a batch of events in a single transaction
+// writeBatch writes a batch of events, preferring the DuckDB Appender
 func (w *Writer) writeBatch(events []buffer.Event) error {
+	if !w.appenderDisabled.Load() {
+		err := w.writeBatchAppender(events)
+		if !errors.Is(err, errAppenderUnavailable) {
+			return err
+		}
+		logger.Warnf("Appender unavailable, using prepared statements: %v", err)
+		w.appenderDisabled.Store(true)
+	}
+	return w.writeBatchStmt(events)
+}
+
+// errAppenderUnavailable signals that the driver connection cannot create an Appender
+var errAppenderUnavailable = errors.New("appender unavailable")
+
+// writeBatchAppender bulk-loads a batch through the DuckDB Appender API.
+// The appender runs inside a transaction, so a failed row rolls back the
+// whole batch and a retry cannot store its earlier rows twice.
+func (w *Writer) writeBatchAppender(events []buffer.Event) error {
+	ctx, cancel := context.WithTimeout(context.Background(), w.config.WriteTimeout)
+	defer cancel()
+
+	conn, err := w.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "BEGIN TRANSACTION"); err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			conn.ExecContext(context.Background(), "ROLLBACK")
+		}
+	}()
+
+	err = conn.Raw(func(driverConn any) error {
+		dc, ok := driverConn.(driver.Conn)
+		if !ok {
+			return fmt.Errorf("%w: unexpected driver connection %T", errAppenderUnavailable, driverConn)
+		}
+		appender, err := duckdb.NewAppenderFromConn(dc, "", "events")
+		if err != nil {
+			return fmt.Errorf("%w: %v", errAppenderUnavailable, err)
+		}
+
+		for _, event := range events {
+			if err := appender.AppendRow(w.appendRow(event)...); err != nil {
+				// Closing flushes into the open transaction, which is rolled back
+				appender.Close()
+				return fmt.Errorf("failed to append event %s: %w", w.stringOrDefault(event["event_id"], ""), err)
+			}
+		}
+
+		if err := appender.Close(); err != nil {
+			return fmt.Errorf("failed to flush appender: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := conn.ExecContext(ctx, "COMMIT"); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	committed = true
+	return nil
+}
+
+// writeBatchStmt writes a batch of events in a single transaction
+func (w *Writer) writeBatchStmt(events []buffer.Event) error {
 	tx, err := w.db.Begin()
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
@@ -229,41 +433,14 @@ func (w *Writer) writeBatch(events []buffer.Event) error {
 	defer stmt.Close()
 
 	for _, event := range events {
-		// Truncate large fields
-		truncateEventFields(event)
-
-		// Extract and convert fields
-		orgID := w.stringOrDefault(event["organization_id"], w.config.OrganizationID)
-		serviceName := w.stringOrDefault(event["service_name"], w.config.ServiceName)
-		eventID := w.stringOrDefault(event["event_id"], "")
-		timestamp := w.timeOrNow(event["timestamp"])
-		receivedAt := w.timeOrNow(event["received_at"])
-		eventType := w.stringOrDefault(event["event_type"], "")
-		level := w.stringOrDefault(event["level"], "")
-		message := w.stringOrDefault(event["message"], "")
-		stacktrace := w.stringOrDefault(event["stacktrace"], "")
-		traceID := w.stringOrDefault(event["trace_id"], "")
-		spanID := w.stringOrDefault(event["span_id"], "")
-		parentSpanID := w.stringOrDefault(event["parent_span_id"], "")
-		operation := w.stringOrDefault(event["operation"], "")
-		durationMs := w.float64OrZero(event["duration_ms"])
-		statusCode := w.intOrZero(event["status_code"])
-		metricName := w.stringOrDefault(event["metric_name"], "")
-		metricValue := w.float64OrZero(event["metric_value"])
-		environment := w.stringOrDefault(event["environment"], w.config.Environment)
-
-		// Convert tags to JSON string
-		tagsJSON := w.convertTagsToJSON(event["tags"])
-
-		_, err := stmt.Exec(
-			orgID, serviceName, eventID, timestamp, receivedAt,
-			eventType, level, message, stacktrace,
-			traceID, spanID, parentSpanID, operation, durationMs, statusCode,
-			metricName, metricValue,
-			environment, tagsJSON,
-		)
-		if err != nil {
-			return fmt.Errorf("failed to insert event %s: %w", eventID, err)
+		row := w.eventRow(event)
+		args := make([]interface{}, len(row))
+		for i, v := range row {
+			args[i] = v
+		}
+
+		if _, err := stmt.Exec(args...); err != nil {
+			return fmt.Errorf("failed to insert event %s: %w", w.stringOrDefault(event["event_id"], ""), err)
 		}
 	}
 
@@ -274,14 +451,64 @@ func (w *Writer) writeBatch(events []buffer.Event) error {
 	return nil
 }
 
-// truncateEventFields truncates large message and stacktrace fields
-func truncateEventFields(event buffer.Event) {
-	if msg, ok := event["message"].(string); ok && len(msg) > maxMessageSize {
-		event["message"] = msg[:maxMessageSize] + "...[TRUNCATED]"
+// eventRow truncates large fields and converts an event into column values,
+// in events table column order. Both write paths share it.
+func (w *Writer) eventRow(event buffer.Event) []driver.Value {
+	// Truncate large fields
+	truncateEventFields(event, w.config.MaxMessageBytes, w.config.MaxStacktraceBytes)
+
+	// Extract and convert fields
+	orgID := w.stringOrDefault(event["organization_id"], w.config.OrganizationID)
+	serviceName := w.stringOrDefault(event["service_name"], w.config.ServiceName)
+	eventID := w.stringOrDefault(event["event_id"], "")
+	timestamp := w.timeOrNow(event["timestamp"])
+	receivedAt := w.timeOrNow(event["received_at"])
+	eventType := w.stringOrDefault(event["event_type"], "")
+	level := w.stringOrDefault(event["level"], "")
+	message := w.stringOrDefault(event["message"], "")
+	stacktrace := w.stringOrDefault(event["stacktrace"], "")
+	traceID := w.stringOrDefault(event["trace_id"], "")
+	spanID := w.stringOrDefault(event["span_id"], "")
+	parentSpanID := w.stringOrDefault(event["parent_span_id"], "")
+	operation := w.stringOrDefault(event["operation"], "")
+	durationMs := w.float64OrZero(event["duration_ms"])
+	statusCode := w.uint16OrZero(event["status_code"])
+	metricName := w.stringOrDefault(event["metric_name"], "")
+	metricValue := w.float64OrZero(event["metric_value"])
+	environment := w.stringOrDefault(event["environment"], w.config.Environment)
+
+	// Convert tags to JSON string
+	tags := buffer.StringTags(event["tags"])
+	tagsJSON := w.convertTagsToJSON(tags)
+
+	row := []driver.Value{
+		orgID, serviceName, eventID, timestamp, receivedAt,
+		eventType, level, message, stacktrace,
+		traceID, spanID, parentSpanID, operation, durationMs, statusCode,
+		metricName, metricValue,
+		environment, tagsJSON,
 	}
 
-	if stack, ok := event["stacktrace"].(string); ok && len(stack) > maxStacktraceSize {
-		event["stacktrace"] = stack[:maxStacktraceSize] + "...[TRUNCATED]"
+	// Promoted tags, NULL when the event lacks them
+	for _, it := range w.indexed {
+		if v, ok := tags[it.Tag]; ok {
+			row = append(row, v)
+		} else {
+			row = append(row, nil)
+		}
+	}
+	return row
+}
+
+// truncateEventFields truncates large message and stacktrace fields.
+// A limit of 0 leaves the field untouched.
+func truncateEventFields(event buffer.Event, maxMessage, maxStacktrace int) {
+	if msg, ok := event["message"].(string); ok && maxMessage > 0 && len(msg) > maxMessage {
+		event["message"] = msg[:maxMessage] + "...[TRUNCATED]"
+	}
+
+	if stack, ok := event["stacktrace"].(string); ok && maxStacktrace > 0 && len(stack) > maxStacktrace {
+		event["stacktrace"] = stack[:maxStacktrace] + "...[TRUNCATED]"
 	}
 }
 
@@ -338,16 +565,28 @@ func (w *Writer) intOrZero(val interface{}) int {
 	return 0
 }
 
+// uint16OrZero clamps status codes into the USMALLINT column range
+func (w *Writer) uint16OrZero(val interface{}) uint16 {
+	n := w.intOrZero(val)
+	if n < 0 {
+		return 0
+	}
+	if n > 65535 {
+		return 65535
+	}
+	return uint16(n)
+}
+
 func (w *Writer) convertTagsToJSON(val interface{}) string {
 	// Convert tags map to JSON string for DuckDB VARCHAR column
-	tagsMap, ok := val.(map[string]string)
-	if !ok || len(tagsMap) == 0 {
+	tagsMap := buffer.StringTags(val)
+	if len(tagsMap) == 0 {
 		return "{}"
 	}
 
 	jsonBytes, err := json.Marshal(tagsMap)
 	if err != nil {
-		log.Printf("[Analytics] Failed to marshal tags: %v", err)
+		logger.Errorf("Failed to marshal tags: %v", err)
 		return "{}"
 	}
 	return string(jsonBytes)
@@ -360,9 +599,18 @@ func (w *Writer) Stats() Stats {
 		lastWrite = val.(time.Time)
 	}
 
+	spillPending := 0
+	if w.spill != nil {
+		if summary, err := w.spill.PendingSummary(); err == nil {
+			spillPending = summary.Events
+		}
+	}
+
 	return Stats{
 		TotalWritten:  atomic.LoadInt64(&w.totalWritten),
 		TotalDropped:  atomic.LoadInt64(&w.totalDropped),
+		TotalSpilled:  atomic.LoadInt64(&w.totalSpilled),
+		SpillPending:  spillPending,
 		QueueDepth:    len(w.queue),
 		LastWriteTime: lastWrite,
 	}
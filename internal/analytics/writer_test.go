@@ -0,0 +1,286 @@
+package analytics
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yaat-app/sidecar/internal/buffer"
+	"github.com/yaat-app/sidecar/internal/queue"
+)
+
+func newTestWriter(tb testing.TB) *Writer {
+	tb.Helper()
+	w, err := NewWriter(Config{
+		DatabasePath:   filepath.Join(tb.TempDir(), "analytics.db"),
+		OrganizationID: "local",
+		ServiceName:    "svc",
+		Environment:    "test",
+
+		MaxMessageBytes:    100_000,
+		MaxStacktraceBytes: 50_000,
+	})
+	if err != nil {
+		tb.Fatalf("Failed to create writer: %v", err)
+	}
+	tb.Cleanup(func() { w.Close() })
+	return w
+}
+
+func makeBatch(n int) []buffer.Event {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	events := make([]buffer.Event, 0, n)
+	for i := 0; i < n; i++ {
+		evt := buffer.Event{
+			"organization_id": "local",
+			"service_name":    "svc",
+			"event_id":        uuid.NewString(),
+			"timestamp":       now,
+			"environment":     "test",
+			"tags":            map[string]string{"i": fmt.Sprint(i)},
+		}
+		switch i % 3 {
+		case 0:
+			evt["event_type"] = "log"
+			evt["level"] = "info"
+			evt["message"] = "message " + fmt.Sprint(i)
+		case 1:
+			evt["event_type"] = "span"
+			evt["operation"] = "GET /"
+			evt["duration_ms"] = 12.5
+			evt["status_code"] = 200
+		default:
+			evt["event_type"] = "metric"
+			evt["metric_name"] = "host.cpu.usage_percent"
+			evt["metric_value"] = 42.0
+		}
+		events = append(events, evt)
+	}
+	return events
+}
+
+func countEvents(tb testing.TB, w *Writer) int {
+	tb.Helper()
+	var n int
+	if err := w.db.QueryRow("SELECT COUNT(*) FROM events").Scan(&n); err != nil {
+		tb.Fatalf("Failed to count events: %v", err)
+	}
+	return n
+}
+
+func TestAppenderMatchesPreparedStatement(t *testing.T) {
+	w := newTestWriter(t)
+
+	long := buffer.Event{
+		"event_id":    "appender",
+		"event_type":  "span",
+		"message":     strings.Repeat("x", w.config.MaxMessageBytes+10),
+		"status_code": 503,
+		"tags":        map[string]string{"k": "v"},
+	}
+	stmtEvent := buffer.Event{}
+	for k, v := range long {
+		stmtEvent[k] = v
+	}
+	stmtEvent["event_id"] = "stmt"
+
+	if err := w.writeBatchAppender([]buffer.Event{long}); err != nil {
+		t.Fatalf("Appender write failed: %v", err)
+	}
+	if err := w.writeBatchStmt([]buffer.Event{stmtEvent}); err != nil {
+		t.Fatalf("Statement write failed: %v", err)
+	}
+
+	rows, err := w.db.Query("SELECT event_id, organization_id, length(message), status_code, tags FROM events ORDER BY event_id")
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	defer rows.Close()
+
+	var results []string
+	for rows.Next() {
+		var id, org, tags string
+		var msgLen, status int
+		if err := rows.Scan(&id, &org, &msgLen, &status, &tags); err != nil {
+			t.Fatalf("Scan failed: %v", err)
+		}
+		results = append(results, fmt.Sprintf("%s|%d|%d|%s", org, msgLen, status, tags))
+	}
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 rows, got %d", len(results))
+	}
+	if results[0] != results[1] {
+		t.Errorf("Expected identical rows from both paths, got %q and %q", results[0], results[1])
+	}
+	if !strings.HasPrefix(results[0], fmt.Sprintf("local|%d|503|", w.config.MaxMessageBytes+len("...[TRUNCATED]"))) {
+		t.Errorf("Unexpected converted row: %q", results[0])
+	}
+}
+
+func TestWriteBatchUsesAppender(t *testing.T) {
+	w := newTestWriter(t)
+
+	if err := w.writeBatch(makeBatch(500)); err != nil {
+		t.Fatalf("writeBatch failed: %v", err)
+	}
+	if w.appenderDisabled.Load() {
+		t.Error("Expected appender path to stay enabled")
+	}
+	if got := countEvents(t, w); got != 500 {
+		t.Errorf("Expected 500 events, got %d", got)
+	}
+}
+
+func TestAppenderFailedRowRollsBackBatch(t *testing.T) {
+	w := newTestWriter(t)
+
+	// A value the appender cannot convert fails AppendRow on the last row,
+	// after the rows before it are already appended
+	w.appendRow = func(event buffer.Event) []driver.Value {
+		row := w.eventRow(event)
+		if event["event_id"] == "bad-row" {
+			row[13] = "not a duration"
+		}
+		return row
+	}
+	batch := append(makeBatch(10), buffer.Event{"event_id": "bad-row", "event_type": "span"})
+
+	if err := w.writeBatchAppender(batch); err == nil {
+		t.Fatal("Expected the bad row to fail the batch")
+	}
+	if got := countEvents(t, w); got != 0 {
+		t.Fatalf("Expected failed batch to store nothing, got %d events", got)
+	}
+
+	// Retrying the good rows must not collide with leftovers
+	if err := w.writeBatchWithRetry(batch[:10]); err != nil {
+		t.Fatalf("Retry failed: %v", err)
+	}
+	if got := countEvents(t, w); got != 10 {
+		t.Errorf("Expected 10 events, got %d", got)
+	}
+}
+
+func TestWriteSpillsOverflowInsteadOfDropping(t *testing.T) {
+	w, err := NewWriter(Config{
+		DatabasePath:   filepath.Join(t.TempDir(), "analytics.db"),
+		OrganizationID: "local",
+		ServiceName:    "svc",
+		Environment:    "test",
+		SpillOverflow:  true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	defer w.Close()
+
+	// Far more batches than the in-memory queue holds
+	const batches, perBatch = 40, 200
+	for i := 0; i < batches; i++ {
+		if err := w.Write(makeBatch(perBatch)); err != nil {
+			t.Fatalf("Write %d failed: %v", i, err)
+		}
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for countEvents(t, w) < batches*perBatch && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	stats := w.Stats()
+	if got := countEvents(t, w); got != batches*perBatch {
+		t.Errorf("Expected %d events written, got %d", batches*perBatch, got)
+	}
+	if stats.TotalSpilled == 0 {
+		t.Error("Expected some batches to be spilled")
+	}
+	if stats.TotalDropped != 0 {
+		t.Errorf("Expected no drops, got %d", stats.TotalDropped)
+	}
+	if stats.SpillPending != 0 {
+		t.Errorf("Expected spill to be fully replayed, %d events pending", stats.SpillPending)
+	}
+}
+
+func TestReplayedBatchKeepsTags(t *testing.T) {
+	w := newTestWriter(t)
+
+	// Spilled batches come back through JSON, tags as map[string]interface{}
+	spill, err := queue.New(filepath.Join(t.TempDir(), "spill"))
+	if err != nil {
+		t.Fatalf("Failed to create spill: %v", err)
+	}
+	if err := spill.Enqueue(makeBatch(3)); err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+	_, replayed, err := spill.Dequeue()
+	if err != nil || len(replayed) != 3 {
+		t.Fatalf("Expected the spilled batch back, got %d events (%v)", len(replayed), err)
+	}
+	if _, ok := replayed[0]["tags"].(map[string]interface{}); !ok {
+		t.Fatalf("Expected decoded tags, got %T", replayed[0]["tags"])
+	}
+
+	if err := w.writeBatch(replayed); err != nil {
+		t.Fatalf("writeBatch failed: %v", err)
+	}
+	var empty int
+	if err := w.db.QueryRow("SELECT COUNT(*) FROM events WHERE tags = '{}'").Scan(&empty); err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if empty != 0 {
+		t.Errorf("Expected the tags of replayed events stored, got %d events without", empty)
+	}
+	if n, err := w.CountByTags(map[string]string{"i": "2"}); err != nil || n != 1 {
+		t.Errorf("Expected the replayed event found by its tag, got %d (%v)", n, err)
+	}
+}
+
+func TestTruncateEventFields(t *testing.T) {
+	long := strings.Repeat("x", 200)
+
+	event := buffer.Event{"message": long, "stacktrace": long}
+	truncateEventFields(event, 100, 50)
+	if got := event["message"].(string); got != long[:100]+"...[TRUNCATED]" {
+		t.Errorf("Expected message truncated to 100 bytes, got %d bytes", len(got))
+	}
+	if got := event["stacktrace"].(string); got != long[:50]+"...[TRUNCATED]" {
+		t.Errorf("Expected stacktrace truncated to 50 bytes, got %d bytes", len(got))
+	}
+
+	event = buffer.Event{"message": long, "stacktrace": long}
+	truncateEventFields(event, 0, 0)
+	if event["message"] != long || event["stacktrace"] != long {
+		t.Error("Expected zero limits to disable truncation")
+	}
+}
+
+func BenchmarkWriteBatchAppender(b *testing.B) {
+	w := newTestWriter(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		batch := makeBatch(500)
+		b.StartTimer()
+		if err := w.writeBatchAppender(batch); err != nil {
+			b.Fatalf("Appender write failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkWriteBatchPreparedStatement(b *testing.B) {
+	w := newTestWriter(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		batch := makeBatch(500)
+		b.StartTimer()
+		if err := w.writeBatchStmt(batch); err != nil {
+			b.Fatalf("Statement write failed: %v", err)
+		}
+	}
+}
@@ -2,33 +2,181 @@ package buffer
 
 import (
 	"sync"
+	"time"
 )
 
 // Event represents a single event to be sent to YAAT
 type Event map[string]interface{}
 
+// StringTags returns an event's "tags" value as a string map. Events read
+// back from the queue or the analytics spill decode their tags as
+// map[string]interface{}; those are converted, keeping string values. A
+// map[string]string is returned as is, not copied.
+func StringTags(raw interface{}) map[string]string {
+	switch tags := raw.(type) {
+	case map[string]string:
+		return tags
+	case map[string]interface{}:
+		converted := make(map[string]string, len(tags))
+		for k, v := range tags {
+			if str, ok := v.(string); ok {
+				converted[k] = str
+			}
+		}
+		return converted
+	default:
+		return nil
+	}
+}
+
 // Buffer holds events in memory until flushed
 type Buffer struct {
 	mu     sync.Mutex
 	events []Event
 	size   int
+	peak   int // Most events held at once since creation
+	filter func(Event) bool
+	// Called with every added event; see SetObserver
+	observe func(Event)
+
+	// Early flush trigger; signalled once when len(events) reaches highWater
+	highWater int
+	flushCh   chan struct{}
+
+	// Closed and replaced by Flush to wake writers waiting in AddWait
+	space chan struct{}
 }
 
 // New creates a new Buffer with the specified maximum size
 func New(size int) *Buffer {
 	return &Buffer{
-		events: make([]Event, 0, size),
-		size:   size,
+		events:  make([]Event, 0, size),
+		size:    size,
+		flushCh: make(chan struct{}, 1),
+		space:   make(chan struct{}),
 	}
 }
 
+// SetHighWatermark signals FlushSignal whenever the buffer reaches threshold
+// events. A threshold of 0 disables the signal.
+func (b *Buffer) SetHighWatermark(threshold int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.highWater = threshold
+}
+
+// FlushSignal is notified when the buffer crosses its high watermark, so the
+// flusher can run before the next interval tick.
+func (b *Buffer) FlushSignal() <-chan struct{} {
+	return b.flushCh
+}
+
+// RequestFlush notifies FlushSignal, as crossing the high watermark does, for
+// inputs whose events should go out before the next interval.
+func (b *Buffer) RequestFlush() {
+	// Non-blocking: a pending signal already covers it
+	select {
+	case b.flushCh <- struct{}{}:
+	default:
+	}
+}
+
+// SetFilter drops events for which keep returns false instead of buffering
+// them, so every input shares the same limits. A nil keep removes the filter.
+func (b *Buffer) SetFilter(keep func(Event) bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.filter = keep
+}
+
+// SetObserver calls observe with every event that passes the filter, as it
+// is added and on the adding goroutine, so observe may read the event safely
+// but must not keep it or block. A nil observe removes the observer.
+func (b *Buffer) SetObserver(observe func(Event)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.observe = observe
+}
+
 // Add adds an event to the buffer
 // Returns true if buffer is full and should be flushed
 func (b *Buffer) Add(event Event) bool {
+	b.mu.Lock()
+	keep := b.filter
+	b.mu.Unlock()
+	if keep != nil && !keep(event) {
+		return false
+	}
+	return b.add(event)
+}
+
+// AddWait is Add for readers that can pause, such as tailers. While the
+// buffer is full it waits up to timeout for a flush to make room, so the
+// reader falls behind its source instead of piling events up in memory. The
+// event is added either way; AddWait reports whether it fit within the
+// buffer's size.
+func (b *Buffer) AddWait(event Event, timeout time.Duration) bool {
+	b.mu.Lock()
+	keep := b.filter
+	b.mu.Unlock()
+	if keep != nil && !keep(event) {
+		return true
+	}
+
+	var expired <-chan time.Time
+	for {
+		b.mu.Lock()
+		if b.size <= 0 || len(b.events) < b.size {
+			b.mu.Unlock()
+			b.add(event)
+			return true
+		}
+		space := b.space
+		b.mu.Unlock()
+
+		// Ask for an early flush; a pending signal already covers it
+		select {
+		case b.flushCh <- struct{}{}:
+		default:
+		}
+		if expired == nil {
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+			expired = timer.C
+		}
+		select {
+		case <-space:
+		case <-expired:
+			b.add(event)
+			return false
+		}
+	}
+}
+
+// add appends an event that passed the filter.
+func (b *Buffer) add(event Event) bool {
+	b.mu.Lock()
+	observe := b.observe
+	b.mu.Unlock()
+	// Before Flush can hand the event to code that modifies it
+	if observe != nil {
+		observe(event)
+	}
+
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
 	b.events = append(b.events, event)
+	if len(b.events) > b.peak {
+		b.peak = len(b.events)
+	}
+	if b.highWater > 0 && len(b.events) == b.highWater {
+		// Non-blocking: a pending signal already covers this burst
+		select {
+		case b.flushCh <- struct{}{}:
+		default:
+		}
+	}
 	return len(b.events) >= b.size
 }
 
@@ -47,6 +195,8 @@ func (b *Buffer) Flush() []Event {
 
 	// Clear buffer
 	b.events = b.events[:0]
+	close(b.space)
+	b.space = make(chan struct{})
 
 	return events
 }
@@ -57,3 +207,22 @@ func (b *Buffer) Len() int {
 	defer b.mu.Unlock()
 	return len(b.events)
 }
+
+// Full reports whether the buffer holds its configured size or more.
+func (b *Buffer) Full() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.size > 0 && len(b.events) >= b.size
+}
+
+// Cap returns the configured size at which Add reports the buffer full.
+func (b *Buffer) Cap() int {
+	return b.size
+}
+
+// Peak returns the most events the buffer has held at once.
+func (b *Buffer) Peak() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.peak
+}
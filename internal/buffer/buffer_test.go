@@ -2,6 +2,7 @@ package buffer
 
 import (
 	"testing"
+	"time"
 )
 
 func TestNewBuffer(t *testing.T) {
@@ -133,3 +134,136 @@ func TestConcurrentAccess(t *testing.T) {
 		t.Errorf("Expected 1000 events from concurrent access, got %d", buf.Len())
 	}
 }
+
+func TestHighWatermarkSignal(t *testing.T) {
+	buf := New(10)
+	buf.SetHighWatermark(8)
+
+	for i := 0; i < 7; i++ {
+		buf.Add(Event{"i": i})
+	}
+	select {
+	case <-buf.FlushSignal():
+		t.Fatal("Expected no signal below the high watermark")
+	default:
+	}
+
+	buf.Add(Event{"i": 7})
+	buf.Add(Event{"i": 8})
+	select {
+	case <-buf.FlushSignal():
+	default:
+		t.Fatal("Expected signal once the high watermark is reached")
+	}
+	select {
+	case <-buf.FlushSignal():
+		t.Fatal("Expected a single signal per crossing")
+	default:
+	}
+
+	// Signal re-arms after a flush
+	buf.Flush()
+	for i := 0; i < 8; i++ {
+		buf.Add(Event{"i": i})
+	}
+	select {
+	case <-buf.FlushSignal():
+	default:
+		t.Error("Expected signal after the buffer refilled")
+	}
+}
+
+func TestPeakTracksMostBuffered(t *testing.T) {
+	buf := New(10)
+	if buf.Cap() != 10 {
+		t.Errorf("Expected capacity 10, got %d", buf.Cap())
+	}
+	for i := 0; i < 4; i++ {
+		buf.Add(Event{"n": i})
+	}
+	buf.Flush()
+	buf.Add(Event{"n": 5})
+
+	if buf.Peak() != 4 {
+		t.Errorf("Expected peak 4 after flushing, got %d", buf.Peak())
+	}
+}
+
+func TestFilterDropsEvents(t *testing.T) {
+	buf := New(10)
+	buf.SetFilter(func(e Event) bool { return e["level"] != "debug" })
+
+	buf.Add(Event{"level": "debug"})
+	buf.Add(Event{"level": "info"})
+	if buf.Len() != 1 {
+		t.Errorf("Expected only the info event buffered, got %d", buf.Len())
+	}
+
+	buf.SetFilter(nil)
+	buf.Add(Event{"level": "debug"})
+	if buf.Len() != 2 {
+		t.Errorf("Expected events kept without a filter, got %d", buf.Len())
+	}
+}
+
+func TestObserverSeesKeptEvents(t *testing.T) {
+	buf := New(10)
+	buf.SetFilter(func(e Event) bool { return e["level"] != "debug" })
+	var seen []string
+	buf.SetObserver(func(e Event) { seen = append(seen, e["level"].(string)) })
+
+	buf.Add(Event{"level": "debug"})
+	buf.Add(Event{"level": "info"})
+	buf.AddWait(Event{"level": "error"}, time.Second)
+	if len(seen) != 2 || seen[0] != "info" || seen[1] != "error" {
+		t.Errorf("Expected the observer to see info and error, got %v", seen)
+	}
+
+	buf.SetObserver(nil)
+	buf.Add(Event{"level": "info"})
+	if len(seen) != 2 {
+		t.Errorf("Expected no calls after removing the observer, got %v", seen)
+	}
+}
+
+func TestAddWaitBlocksUntilFlush(t *testing.T) {
+	buf := New(2)
+	buf.Add(Event{"n": 1})
+	buf.Add(Event{"n": 2})
+	if !buf.Full() {
+		t.Fatal("Expected the buffer to report full")
+	}
+
+	done := make(chan bool)
+	go func() { done <- buf.AddWait(Event{"n": 3}, 5*time.Second) }()
+
+	select {
+	case <-done:
+		t.Fatal("Expected AddWait to wait while the buffer is full")
+	case <-buf.FlushSignal():
+		// The waiting writer asks for an early flush
+	case <-time.After(time.Second):
+		t.Fatal("Expected a flush signal from the waiting writer")
+	}
+
+	buf.Flush()
+	if ok := <-done; !ok {
+		t.Error("Expected the event to fit after the flush")
+	}
+	if buf.Len() != 1 {
+		t.Errorf("Expected 1 event after the flush, got %d", buf.Len())
+	}
+}
+
+func TestAddWaitTimesOut(t *testing.T) {
+	buf := New(1)
+	buf.Add(Event{"n": 1})
+
+	if buf.AddWait(Event{"n": 2}, 20*time.Millisecond) {
+		t.Error("Expected AddWait to report the buffer still full")
+	}
+	// Events are never dropped, only delayed
+	if buf.Len() != 2 {
+		t.Errorf("Expected both events buffered, got %d", buf.Len())
+	}
+}
@@ -0,0 +1,411 @@
+package buffer
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+)
+
+// EventRecord is the typed form of an Event. It marshals without reflection,
+// producing byte-for-byte the same JSON as encoding/json does for the
+// equivalent map.
+//
+// Only the forwarder uses it, to encode each event once before delivery.
+// Producers still build the map form, which stays the format of the buffer,
+// the pipeline stages that edit events (scrubbing, routing, analytics, OTLP)
+// and the queue.
+//
+// Fields that have no typed slot, or whose value in the map had an unexpected
+// type, are kept in Extra so the conversion is lossless.
+type EventRecord struct {
+	OrganizationID string
+	ServiceName    string
+	EventID        string
+	Timestamp      string
+	ReceivedAt     string
+	Type           string
+	Environment    string
+	Level          string
+	Message        string
+	Stacktrace     string
+
+	// Span fields
+	TraceID      string
+	SpanID       string
+	ParentSpanID string
+	Operation    string
+	DurationMS   float64
+	StatusCode   int
+
+	// Metric fields
+	MetricName  string
+	MetricValue float64
+
+	Tags  map[string]string
+	Extra map[string]interface{}
+
+	// set records which typed fields are present, since the wire format
+	// distinguishes a missing key from an empty value.
+	set recordField
+}
+
+type recordField uint32
+
+const (
+	fieldDurationMS recordField = 1 << iota
+	fieldEnvironment
+	fieldEventID
+	fieldType
+	fieldLevel
+	fieldMessage
+	fieldMetricName
+	fieldMetricValue
+	fieldOperation
+	fieldOrganizationID
+	fieldParentSpanID
+	fieldReceivedAt
+	fieldServiceName
+	fieldSpanID
+	fieldStacktrace
+	fieldStatusCode
+	fieldTags
+	fieldTimestamp
+	fieldTraceID
+)
+
+// recordKeys lists the typed fields by wire key in sorted order, which is
+// the order encoding/json writes map keys in.
+var recordKeys = []struct {
+	key   string
+	field recordField
+}{
+	{"duration_ms", fieldDurationMS},
+	{"environment", fieldEnvironment},
+	{"event_id", fieldEventID},
+	{"event_type", fieldType},
+	{"level", fieldLevel},
+	{"message", fieldMessage},
+	{"metric_name", fieldMetricName},
+	{"metric_value", fieldMetricValue},
+	{"operation", fieldOperation},
+	{"organization_id", fieldOrganizationID},
+	{"parent_span_id", fieldParentSpanID},
+	{"received_at", fieldReceivedAt},
+	{"service_name", fieldServiceName},
+	{"span_id", fieldSpanID},
+	{"stacktrace", fieldStacktrace},
+	{"status_code", fieldStatusCode},
+	{"tags", fieldTags},
+	{"timestamp", fieldTimestamp},
+	{"trace_id", fieldTraceID},
+}
+
+var recordKeyFields = func() map[string]recordField {
+	fields := make(map[string]recordField, len(recordKeys))
+	for _, k := range recordKeys {
+		fields[k.key] = k.field
+	}
+	return fields
+}()
+
+// RecordFromEvent converts the map form into a record. The event is not modified.
+func RecordFromEvent(evt Event) *EventRecord {
+	r := &EventRecord{}
+	r.fill(evt)
+	return r
+}
+
+func (r *EventRecord) fill(evt Event) {
+	for key, value := range evt {
+		if field, ok := recordKeyFields[key]; ok && r.setField(field, value) {
+			continue
+		}
+		if r.Extra == nil {
+			r.Extra = make(map[string]interface{})
+		}
+		r.Extra[key] = value
+	}
+}
+
+// setField stores value in its typed slot, reporting false when the value
+// does not have the type the slot expects.
+func (r *EventRecord) setField(field recordField, value interface{}) bool {
+	switch field {
+	case fieldDurationMS, fieldMetricValue:
+		v, ok := value.(float64)
+		if !ok {
+			return false
+		}
+		if field == fieldDurationMS {
+			r.DurationMS = v
+		} else {
+			r.MetricValue = v
+		}
+	case fieldStatusCode:
+		v, ok := value.(int)
+		if !ok {
+			return false
+		}
+		r.StatusCode = v
+	case fieldTags:
+		v, ok := value.(map[string]string)
+		if !ok {
+			return false
+		}
+		r.Tags = v
+	default:
+		v, ok := value.(string)
+		if !ok {
+			return false
+		}
+		*r.stringField(field) = v
+	}
+	r.set |= field
+	return true
+}
+
+func (r *EventRecord) stringField(field recordField) *string {
+	switch field {
+	case fieldEnvironment:
+		return &r.Environment
+	case fieldEventID:
+		return &r.EventID
+	case fieldType:
+		return &r.Type
+	case fieldLevel:
+		return &r.Level
+	case fieldMessage:
+		return &r.Message
+	case fieldMetricName:
+		return &r.MetricName
+	case fieldOperation:
+		return &r.Operation
+	case fieldOrganizationID:
+		return &r.OrganizationID
+	case fieldParentSpanID:
+		return &r.ParentSpanID
+	case fieldReceivedAt:
+		return &r.ReceivedAt
+	case fieldServiceName:
+		return &r.ServiceName
+	case fieldSpanID:
+		return &r.SpanID
+	case fieldStacktrace:
+		return &r.Stacktrace
+	case fieldTimestamp:
+		return &r.Timestamp
+	case fieldTraceID:
+		return &r.TraceID
+	}
+	return nil
+}
+
+func (r *EventRecord) fieldValue(field recordField) interface{} {
+	switch field {
+	case fieldDurationMS:
+		return r.DurationMS
+	case fieldMetricValue:
+		return r.MetricValue
+	case fieldStatusCode:
+		return r.StatusCode
+	case fieldTags:
+		return r.Tags
+	}
+	return *r.stringField(field)
+}
+
+// Event converts the record back into the map form used by the queue's
+// on-disk format and by callers that work with untyped events.
+func (r *EventRecord) Event() Event {
+	evt := make(Event, len(recordKeys)+len(r.Extra))
+	for _, k := range recordKeys {
+		if r.set&k.field != 0 {
+			evt[k.key] = r.fieldValue(k.field)
+		}
+	}
+	for k, v := range r.Extra {
+		evt[k] = v
+	}
+	return evt
+}
+
+// MarshalJSON encodes the record with keys in sorted order, matching the
+// output of json.Marshal on the equivalent Event.
+func (r *EventRecord) MarshalJSON() ([]byte, error) {
+	return r.AppendJSON(nil)
+}
+
+// AppendJSON appends the JSON encoding of the record to dst.
+func (r *EventRecord) AppendJSON(dst []byte) ([]byte, error) {
+	var extra []string
+	if len(r.Extra) > 0 {
+		extra = make([]string, 0, len(r.Extra))
+		for k := range r.Extra {
+			extra = append(extra, k)
+		}
+		sort.Strings(extra)
+	}
+
+	// Merge the pre-sorted typed keys with the sorted extra keys
+	var err error
+	first := true
+	typed := 0
+	for typed < len(recordKeys) || len(extra) > 0 {
+		for typed < len(recordKeys) && r.set&recordKeys[typed].field == 0 {
+			typed++
+		}
+		useExtra := len(extra) > 0 && (typed == len(recordKeys) || extra[0] < recordKeys[typed].key)
+		if !useExtra && typed == len(recordKeys) {
+			break
+		}
+
+		if first {
+			dst = append(dst, '{')
+			first = false
+		} else {
+			dst = append(dst, ',')
+		}
+
+		if useExtra {
+			key := extra[0]
+			extra = extra[1:]
+			dst = appendJSONString(dst, key)
+			dst = append(dst, ':')
+			raw, err := json.Marshal(r.Extra[key])
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %w", key, err)
+			}
+			dst = append(dst, raw...)
+			continue
+		}
+
+		k := recordKeys[typed]
+		typed++
+		dst = appendJSONString(dst, k.key)
+		dst = append(dst, ':')
+		if dst, err = r.appendField(dst, k.key, k.field); err != nil {
+			return nil, err
+		}
+	}
+	if first {
+		dst = append(dst, '{')
+	}
+	return append(dst, '}'), nil
+}
+
+func (r *EventRecord) appendField(dst []byte, key string, field recordField) ([]byte, error) {
+	switch field {
+	case fieldDurationMS:
+		return appendJSONFloat(dst, r.DurationMS, key)
+	case fieldMetricValue:
+		return appendJSONFloat(dst, r.MetricValue, key)
+	case fieldStatusCode:
+		return strconv.AppendInt(dst, int64(r.StatusCode), 10), nil
+	case fieldTags:
+		return appendJSONTags(dst, r.Tags), nil
+	}
+	return appendJSONString(dst, *r.stringField(field)), nil
+}
+
+func appendJSONTags(dst []byte, tags map[string]string) []byte {
+	if tags == nil {
+		return append(dst, "null"...)
+	}
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	dst = append(dst, '{')
+	for i, k := range keys {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst = appendJSONString(dst, k)
+		dst = append(dst, ':')
+		dst = appendJSONString(dst, tags[k])
+	}
+	return append(dst, '}')
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendJSONString mirrors encoding/json's string encoding with HTML
+// escaping, which json.Marshal enables by default.
+func appendJSONString(dst []byte, s string) []byte {
+	dst = append(dst, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if b >= 0x20 && b != '"' && b != '\\' && b != '<' && b != '>' && b != '&' {
+				i++
+				continue
+			}
+			dst = append(dst, s[start:i]...)
+			switch b {
+			case '\\', '"':
+				dst = append(dst, '\\', b)
+			case '\b':
+				dst = append(dst, '\\', 'b')
+			case '\f':
+				dst = append(dst, '\\', 'f')
+			case '\n':
+				dst = append(dst, '\\', 'n')
+			case '\r':
+				dst = append(dst, '\\', 'r')
+			case '\t':
+				dst = append(dst, '\\', 't')
+			default:
+				dst = append(dst, '\\', 'u', '0', '0', hexDigits[b>>4], hexDigits[b&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		c, size := utf8.DecodeRuneInString(s[i:])
+		if c == utf8.RuneError && size == 1 {
+			dst = append(dst, s[start:i]...)
+			dst = append(dst, `\ufffd`...)
+			i += size
+			start = i
+			continue
+		}
+		// U+2028 and U+2029 are escaped for JSONP safety
+		if c == '\u2028' || c == '\u2029' {
+			dst = append(dst, s[start:i]...)
+			dst = append(dst, '\\', 'u', '2', '0', '2', hexDigits[c&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	dst = append(dst, s[start:]...)
+	return append(dst, '"')
+}
+
+// appendJSONFloat mirrors encoding/json's float64 formatting.
+func appendJSONFloat(dst []byte, f float64, key string) ([]byte, error) {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return nil, fmt.Errorf("field %s: unsupported value %v", key, f)
+	}
+
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	dst = strconv.AppendFloat(dst, f, format, -1, 64)
+	if format == 'e' {
+		// Clean up e-09 to e-9
+		n := len(dst)
+		if n >= 4 && dst[n-4] == 'e' && dst[n-3] == '-' && dst[n-2] == '0' {
+			dst[n-2] = dst[n-1]
+			dst = dst[:n-1]
+		}
+	}
+	return dst, nil
+}
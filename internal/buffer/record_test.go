@@ -0,0 +1,100 @@
+package buffer
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func recordTestEvents() []Event {
+	return []Event{
+		{
+			"organization_id": "org",
+			"service_name":    "api",
+			"event_id":        "e1",
+			"timestamp":       "2024-10-26T10:30:15Z",
+			"event_type":      "span",
+			"environment":     "production",
+			"trace_id":        "t1",
+			"span_id":         "s1",
+			"parent_span_id":  "",
+			"operation":       "GET /orders?id=1&x=<y>",
+			"duration_ms":     0.0,
+			"status_code":     200,
+			"metric_value":    1234.0,
+			"tags":            map[string]string{"path": "/orders", "user_agent": "curl/8.0 \"quoted\"", "ünïcode": "✓"},
+		},
+		{
+			"service_name": "worker",
+			"event_type":   "log",
+			"level":        "error",
+			"message":      "line one\nline two\t  \x01",
+			"stacktrace":   "Traceback...",
+			"logger":       "app.jobs",
+			"line_number":  42,
+		},
+		{
+			"service_name": "metrics",
+			"event_type":   "metric",
+			"metric_name":  "host.cpu",
+			"metric_value": 1e21,
+			"duration_ms":  0.0000001,
+			"tags":         map[string]interface{}{"cpu": 1},
+			"status_code":  "200", // Unexpected type is kept as-is
+		},
+		{
+			"service_name":       "nested",
+			"original_timestamp": nil,
+			"extra":              map[string]interface{}{"a": []interface{}{1, "b"}},
+			"tags":               map[string]string(nil),
+		},
+	}
+}
+
+func TestEventRecordMarshalMatchesMap(t *testing.T) {
+	for i, evt := range recordTestEvents() {
+		expected, err := json.Marshal(evt)
+		if err != nil {
+			t.Fatalf("event[%d]: json.Marshal failed: %v", i, err)
+		}
+		got, err := RecordFromEvent(evt).MarshalJSON()
+		if err != nil {
+			t.Fatalf("event[%d]: MarshalJSON failed: %v", i, err)
+		}
+		if string(got) != string(expected) {
+			t.Errorf("event[%d]: Expected %s, got %s", i, expected, got)
+		}
+	}
+}
+
+func TestEventRecordRoundTrip(t *testing.T) {
+	for i, evt := range recordTestEvents() {
+		back := RecordFromEvent(evt).Event()
+		if !reflect.DeepEqual(back, evt) {
+			t.Errorf("event[%d]: Expected %v, got %v", i, evt, back)
+		}
+	}
+}
+
+func TestEventRecordTypedFields(t *testing.T) {
+	r := RecordFromEvent(recordTestEvents()[0])
+	if r.ServiceName != "api" || r.Type != "span" || r.StatusCode != 200 || r.MetricValue != 1234 {
+		t.Errorf("Expected typed fields to be populated, got %+v", r)
+	}
+	if len(r.Extra) != 0 {
+		t.Errorf("Expected no extra fields, got %v", r.Extra)
+	}
+
+	r = RecordFromEvent(recordTestEvents()[2])
+	if _, ok := r.Extra["status_code"]; !ok {
+		t.Error("Expected mistyped status_code to be kept in Extra")
+	}
+}
+
+func TestEventRecordRejectsNaN(t *testing.T) {
+	nan := 0.0
+	nan = nan / nan
+	if _, err := RecordFromEvent(Event{"metric_value": nan}).MarshalJSON(); err == nil {
+		t.Error("Expected NaN metric_value to fail like json.Marshal")
+	}
+}
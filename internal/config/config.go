@@ -1,14 +1,19 @@
 package config
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
+
+	"github.com/yaat-app/sidecar/internal/paths"
 )
 
 // ProxyConfig holds HTTP proxy configuration
@@ -16,34 +21,112 @@ type ProxyConfig struct {
 	Enabled     bool   `yaml:"enabled"`
 	ListenPort  int    `yaml:"listen_port"`
 	UpstreamURL string `yaml:"upstream_url"`
+
+	// Emit per-route http.server.duration percentiles every flush interval
+	LatencyMetrics bool `yaml:"latency_metrics,omitempty"`
+
+	// Finished requests waiting to be recorded as spans; more are dropped
+	MaxPendingEvents int `yaml:"max_pending_events,omitempty"`
+
+	SpanSampling SpanSamplingConfig `yaml:",inline"`
+}
+
+// SpanSamplingConfig thins out successful request spans from the proxy or
+// an access log before they are buffered.
+type SpanSamplingConfig struct {
+	SpanSampleRate   *float64 `yaml:"span_sample_rate,omitempty"`   // Fraction of 2xx/3xx spans kept (default 1)
+	ErrorsAlways     *bool    `yaml:"errors_always,omitempty"`      // Keep every 4xx/5xx span (default true)
+	SampledOutMetric bool     `yaml:"sampled_out_metric,omitempty"` // Count dropped spans as http.requests.sampled_out
+}
+
+func (s *SpanSamplingConfig) applyDefaults() {
+	if s.ErrorsAlways == nil {
+		errorsAlways := true
+		s.ErrorsAlways = &errorsAlways
+	}
+}
+
+// Enabled reports whether any spans can be dropped.
+func (s SpanSamplingConfig) Enabled() bool {
+	return s.SpanSampleRate != nil && *s.SpanSampleRate < 1
 }
 
 // LogConfig holds log file configuration
 type LogConfig struct {
-	Path   string `yaml:"path"`
-	Format string `yaml:"format"` // "django", "nginx", "json"
+	Path                 string             `yaml:"path"`
+	Format               string             `yaml:"format"`                  // "django", "nginx", "json", "auto"
+	Timezone             string             `yaml:"timezone,omitempty"`      // IANA name, "Local" or "UTC" (default)
+	ExtractKV            bool               `yaml:"extract_kv,omitempty"`    // Promote key=value pairs in Django messages to tags
+	DedupeWindow         string             `yaml:"dedupe_window,omitempty"` // Collapse repeated level+message within this window
+	SampleRates          map[string]float64 `yaml:"sample_rates,omitempty"`  // Fraction of events kept per level, e.g. debug: 0.1
+	Backfill             BackfillConfig     `yaml:"backfill,omitempty"`
+	Journald             JournaldConfig     `yaml:"journald,omitempty"` // With format: journald
+	SpanSampling         SpanSamplingConfig `yaml:",inline"`
+	Location             *time.Location     `yaml:"-"`
+	DedupeWindowDuration time.Duration      `yaml:"-"`
+}
+
+// JournaldConfig selects what a journald source reads and forwards.
+type JournaldConfig struct {
+	IncludeFields []string `yaml:"include_fields,omitempty"` // Fields forwarded as journal.field.<name> tags; globs like MY_APP_*
+	Boot          string   `yaml:"boot,omitempty"`           // "current" or "all" (default)
+	Namespace     string   `yaml:"namespace,omitempty"`      // Journal namespace, as in journalctl --namespace
+}
+
+func (j JournaldConfig) isZero() bool {
+	return len(j.IncludeFields) == 0 && j.Boot == "" && j.Namespace == ""
+}
+
+// BackfillConfig controls ingestion of rotated log files (app.log.1, app.log.2.gz, ...) at startup
+type BackfillConfig struct {
+	Enabled        bool          `yaml:"enabled"`
+	MaxFiles       int           `yaml:"max_files,omitempty"`
+	MaxAge         string        `yaml:"max_age,omitempty"`
+	LinesPerSecond int           `yaml:"lines_per_second,omitempty"`
+	MaxAgeDuration time.Duration `yaml:"-"`
 }
 
 // Config represents the sidecar configuration
 type Config struct {
 	OrganizationID string          `yaml:"organization_id"`
 	APIKey         string          `yaml:"api_key"`
+	APIKeyFile     string          `yaml:"api_key_file,omitempty"` // Read api_key from this file when it is empty
 	ServiceName    string          `yaml:"service_name"`
 	Environment    string          `yaml:"environment"`
 	Tags           map[string]string `yaml:"tags,omitempty"`     // Global tags for all events
 	Proxy         ProxyConfig     `yaml:"proxy"`
 	Logs          []LogConfig     `yaml:"logs"`
+	JSONDepth     int             `yaml:"json_depth,omitempty"` // Levels of nested JSON log objects flattened into dotted tags
 	BufferSize    int             `yaml:"buffer_size"`
 	FlushInterval string          `yaml:"flush_interval"`
+	FlushHighWatermark float64    `yaml:"flush_high_watermark,omitempty"` // Fraction of buffer_size that triggers an early flush
+	ShutdownTimeout string        `yaml:"shutdown_timeout,omitempty"`     // Bound on the final flush and queue drain at exit
 	APIEndpoint   string          `yaml:"api_endpoint"`
 	Delivery      DeliveryConfig  `yaml:"delivery"`
 	Metrics       MetricsConfig   `yaml:"metrics"`
 	Scrubbing     ScrubbingConfig `yaml:"scrubbing"`
 	Analytics     AnalyticsConfig `yaml:"analytics"`
+	Routing       []RoutingRule   `yaml:"routing,omitempty"`
+	OTLP          OTLPConfig      `yaml:"otlp,omitempty"`
+	Heartbeat     HeartbeatConfig `yaml:"heartbeat,omitempty"`
+	StartupProbe  StartupProbeConfig `yaml:"startup_probe,omitempty"`
+	Correlation   CorrelationConfig `yaml:"correlation,omitempty"`
+	Health        HealthConfig    `yaml:"health,omitempty"`
+	LocalAPI      LocalAPIConfig  `yaml:"local_api,omitempty"`
+	Detection     DetectionConfig `yaml:"detection,omitempty"`
+	Limits        LimitsConfig    `yaml:"limits,omitempty"`
+	LogFormat     string          `yaml:"log_format,omitempty"` // Sidecar's own log output: text or json
+	LogLevel      string          `yaml:"log_level,omitempty"`  // Minimum level of the sidecar's own logs
 
-	// Parsed flush interval
-	FlushIntervalDuration time.Duration `yaml:"-"`
-	SourcePath            string        `yaml:"-"`
+	// Parsed flush interval and shutdown timeout
+	FlushIntervalDuration   time.Duration `yaml:"-"`
+	ShutdownTimeoutDuration time.Duration `yaml:"-"`
+	SourcePath              string        `yaml:"-"`
+	APIKeyFromFile          bool          `yaml:"-"` // APIKey was read from api_key_file; SaveConfig leaves it out
+	UnknownKeys             []string      `yaml:"-"` // Keys in the file that no option takes; they were ignored
+
+	// Templated tag values by section and key, restored by SaveConfig
+	tagTemplates map[string]map[string]tagTemplate
 }
 
 // DeliveryConfig tunes forwarding behaviour.
@@ -53,8 +136,33 @@ type DeliveryConfig struct {
 	MaxBatchBytes               int           `yaml:"max_batch_bytes"`       // optional soft limit (0 disables)
 	QueueRetention              string        `yaml:"queue_retention"`       // e.g. "24h", "0s" disables
 	DeadLetterRetention         string        `yaml:"dead_letter_retention"` // e.g. "168h"
+	MaxConcurrency              int           `yaml:"max_concurrency,omitempty"`
+	MaxEventBytes               *int          `yaml:"max_event_bytes,omitempty"` // truncate larger events (0 disables)
 	QueueRetentionDuration      time.Duration `yaml:"-"`
 	DeadLetterRetentionDuration time.Duration `yaml:"-"`
+
+	// Dead-lettered batches are requeued every DLQRetryInterval until they
+	// failed DLQMaxRetries more times, then dropped (0 disables retrying)
+	DLQMaxRetries            int           `yaml:"dlq_max_retries,omitempty"`
+	DLQRetryInterval         string        `yaml:"dlq_retry_interval,omitempty"` // default "15m"
+	DLQRetryIntervalDuration time.Duration `yaml:"-"`
+
+	// Per-service delivery for hosts shipping several services
+	GroupByService bool              `yaml:"group_by_service,omitempty"` // never mix services in one request
+	ServiceKeys    map[string]string `yaml:"service_keys,omitempty"`     // service_name -> api_key, with group_by_service
+
+	// Clock skew is measured from the API response Date header
+	ClockSkewWarn         string        `yaml:"clock_skew_warn,omitempty"`    // warn above this offset (default "30s")
+	ClockSkewCorrect      bool          `yaml:"clock_skew_correct,omitempty"` // shift received_at/default timestamps by the skew
+	ClockSkewWarnDuration time.Duration `yaml:"-"`
+
+	// PinGlobalTags adds the global tags at send time to any event that lacks
+	// them, whichever input produced it (default true)
+	PinGlobalTags *bool `yaml:"pin_global_tags,omitempty"`
+
+	// QueueEncryptionKeyFile holds a 32-byte key (raw or hex) used to
+	// encrypt persisted batches with AES-GCM
+	QueueEncryptionKeyFile string `yaml:"queue_encryption_key_file,omitempty"`
 }
 
 // MetricsConfig controls host metrics collection.
@@ -62,22 +170,46 @@ type MetricsConfig struct {
 	Enabled          bool              `yaml:"enabled"`
 	Interval         string            `yaml:"interval"`
 	Tags             map[string]string `yaml:"tags,omitempty"`
+	Namespace        string            `yaml:"namespace,omitempty"` // Prefix for host metric names
 	IntervalDuration time.Duration     `yaml:"-"`
 	StatsD           StatsDConfig      `yaml:"statsd"`
+	// MaxTagCardinality caps the distinct values per StatsD tag key; beyond
+	// it the key's values are replaced with __high_cardinality__
+	MaxTagCardinality int `yaml:"max_tag_cardinality,omitempty"`
+	// DiskDevices limits disk I/O metrics to these devices ("nvme*" matches
+	// a prefix); empty reports every device but loop and RAM disks
+	DiskDevices []string `yaml:"disk_devices,omitempty"`
+	// NetInterfaces limits network metrics, per interface and in the
+	// aggregate, to these interfaces; empty means all but loopback
+	NetInterfaces []string `yaml:"net_interfaces,omitempty"`
 }
 
 // StatsDConfig controls the embedded StatsD/dogstatsd listener.
 type StatsDConfig struct {
-	Enabled    bool              `yaml:"enabled"`
-	ListenAddr string            `yaml:"listen_addr"`
-	Namespace  string            `yaml:"namespace"`
-	Tags       map[string]string `yaml:"tags,omitempty"`
+	Enabled          bool              `yaml:"enabled"`
+	ListenAddr       string            `yaml:"listen_addr"`
+	Namespace        string            `yaml:"namespace"`
+	Tags             map[string]string `yaml:"tags,omitempty"`
+	GaugeTTL         string            `yaml:"gauge_ttl,omitempty"` // Forget gauges not updated for this long
+	GaugeTTLDuration time.Duration     `yaml:"-"`
+	// MaxTimestampAge bounds how far a client |T timestamp may be from now
+	MaxTimestampAge         string        `yaml:"max_timestamp_age,omitempty"`
+	MaxTimestampAgeDuration time.Duration `yaml:"-"`
+	// FlushInterval batches metrics and asks for a delivery this often,
+	// independent of the global flush_interval
+	FlushInterval         string        `yaml:"flush_interval,omitempty"`
+	FlushIntervalDuration time.Duration `yaml:"-"`
 }
 
 // ScrubbingConfig controls regex-based redaction/drop rules.
 type ScrubbingConfig struct {
-	Enabled bool        `yaml:"enabled"`
-	Rules   []ScrubRule `yaml:"rules"`
+	Enabled      bool        `yaml:"enabled"`
+	Rules        []ScrubRule `yaml:"rules"`
+	TagAllowlist []string    `yaml:"tag_allowlist,omitempty"` // When set, every other tag is removed; "http.*" allows a prefix
+	// RuleBudget is the longest one rule may take on one event before it is
+	// disabled
+	RuleBudget         string        `yaml:"rule_budget,omitempty"`
+	RuleBudgetDuration time.Duration `yaml:"-"`
 }
 
 // ScrubRule describes an individual regex replacement/drop instruction.
@@ -90,6 +222,113 @@ type ScrubRule struct {
 	Drop        bool     `yaml:"drop,omitempty"`
 }
 
+// OTLPConfig controls export of spans and metrics to an OpenTelemetry collector.
+// It runs alongside (or, without an api_key, instead of) the YAAT API.
+type OTLPConfig struct {
+	Enabled         bool              `yaml:"enabled"`
+	Endpoint        string            `yaml:"endpoint"` // OTLP/HTTP base URL, e.g. http://localhost:4318
+	Headers         map[string]string `yaml:"headers,omitempty"`
+	Timeout         string            `yaml:"timeout,omitempty"`
+	TimeoutDuration time.Duration     `yaml:"-"`
+}
+
+// HeartbeatConfig controls the periodic self-telemetry event.
+type HeartbeatConfig struct {
+	Enabled          bool          `yaml:"enabled"`
+	Interval         string        `yaml:"interval,omitempty"`
+	IntervalDuration time.Duration `yaml:"-"`
+}
+
+// CorrelationConfig joins log lines to proxy spans by request ID.
+type CorrelationConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	MaxEntries  int           `yaml:"max_entries,omitempty"` // Recent requests remembered
+	TTL         string        `yaml:"ttl,omitempty"`         // How long a request stays matchable
+	TTLDuration time.Duration `yaml:"-"`
+}
+
+// StartupProbeConfig checks the API key before any input starts, so a bad
+// key fails fast instead of filling the dead-letter queue.
+type StartupProbeConfig struct {
+	Enabled               bool          `yaml:"enabled"`
+	OnFailure             string        `yaml:"on_failure,omitempty"`     // exit (default) or retry
+	RetryInterval         string        `yaml:"retry_interval,omitempty"` // Between attempts with on_failure: retry
+	RetryIntervalDuration time.Duration `yaml:"-"`
+}
+
+// HealthConfig controls the health and metrics HTTP endpoint.
+type HealthConfig struct {
+	Port        int    `yaml:"port,omitempty"`         // 0 disables the endpoint unless listen_addr is set
+	ListenAddr  string `yaml:"listen_addr,omitempty"`  // host:port; defaults to 127.0.0.1:<port>
+	BearerToken string `yaml:"bearer_token,omitempty"` // Required on every path except /healthz when set
+}
+
+// LocalAPIConfig controls the loopback endpoint that accepts custom events.
+type LocalAPIConfig struct {
+	Enabled            bool   `yaml:"enabled"`
+	ListenAddr         string `yaml:"listen_addr,omitempty"`           // Loopback host:port; defaults to 127.0.0.1:8127
+	MaxBodyBytes       int64  `yaml:"max_body_bytes,omitempty"`        // Larger requests are rejected with 413
+	MaxEventsPerMinute int    `yaml:"max_events_per_minute,omitempty"` // Events over the rate are rejected
+}
+
+// Addr returns the address the health endpoint listens on, or "" when it is
+// disabled. A positive portOverride (--health-port) replaces the configured
+// port and enables the endpoint.
+func (h HealthConfig) Addr(portOverride int) string {
+	host, port := "127.0.0.1", strconv.Itoa(h.Port)
+	if h.ListenAddr != "" {
+		var err error
+		if host, port, err = net.SplitHostPort(h.ListenAddr); err != nil {
+			return h.ListenAddr
+		}
+	} else if h.Port <= 0 && portOverride <= 0 {
+		return ""
+	}
+	if portOverride > 0 {
+		port = strconv.Itoa(portOverride)
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// DetectionConfig controls runtime cloud and Kubernetes metadata detection.
+type DetectionConfig struct {
+	Cloud      string            `yaml:"cloud,omitempty"`      // auto, off, aws, gcp or azure
+	Kubernetes string            `yaml:"kubernetes,omitempty"` // auto or off
+	Overrides  map[string]string `yaml:"overrides,omitempty"`  // Tags merged as if detected, e.g. cloud.region
+	// Timeout bounds cloud metadata detection, retries included
+	Timeout         string        `yaml:"timeout,omitempty"`
+	TimeoutDuration time.Duration `yaml:"-"`
+	// Downward API volume with the pod's labels and annotations files, and
+	// the keys of each added as tags; "prefix*" matches a prefix
+	PodInfoPath    string   `yaml:"podinfo_path,omitempty"`
+	PodLabels      []string `yaml:"pod_labels,omitempty"`      // Unset keeps the app.kubernetes.io defaults
+	PodAnnotations []string `yaml:"pod_annotations,omitempty"` // None by default
+}
+
+// LimitsConfig drops events before they are buffered, to control ingest costs.
+type LimitsConfig struct {
+	MinLevel           string   `yaml:"min_level,omitempty"`             // Drop log events below this level
+	MaxEventsPerMinute int      `yaml:"max_events_per_minute,omitempty"` // Global cap across all sources
+	DropEventTypes     []string `yaml:"drop_event_types,omitempty"`      // e.g. ["span"]
+	AlwaysKeepErrors   *bool    `yaml:"always_keep_errors,omitempty"`    // Errors bypass the cap (default true)
+}
+
+// RoutingRule assigns an environment (and optionally a service name) to events
+// whose fields match every matcher. The first matching rule wins.
+type RoutingRule struct {
+	Name        string         `yaml:"name"`
+	Match       []RouteMatcher `yaml:"match"`
+	Environment string         `yaml:"environment,omitempty"`
+	ServiceName string         `yaml:"service_name,omitempty"`
+}
+
+// RouteMatcher tests one event field against a glob or regular expression.
+type RouteMatcher struct {
+	Field string `yaml:"field"`           // top-level field or "tags.<key>"
+	Glob  string `yaml:"glob,omitempty"`  // e.g. "staging-*"
+	Regex string `yaml:"regex,omitempty"` // alternative to glob
+}
+
 // AnalyticsConfig controls local DuckDB analytics storage.
 type AnalyticsConfig struct {
 	Enabled          bool              `yaml:"enabled"`
@@ -99,8 +338,39 @@ type AnalyticsConfig struct {
 	BatchSize        int               `yaml:"batch_size"`
 	WriteTimeout     string            `yaml:"write_timeout"`
 	TimeoutDuration  time.Duration     `yaml:"-"`
+
+	// Truncation limits for stored message/stacktrace fields; 0 disables truncation.
+	// Unset keeps the defaults (100KB / 50KB).
+	MaxMessageBytes    *int `yaml:"max_message_bytes,omitempty"`
+	MaxStacktraceBytes *int `yaml:"max_stacktrace_bytes,omitempty"`
+
+	// Spill batches to disk instead of dropping them when the write queue is full
+	SpillOverflow bool `yaml:"spill_overflow,omitempty"`
+	SpillMaxMB    int  `yaml:"spill_max_mb,omitempty"`
+
+	// Tags also stored in indexed columns of their own, for fast filtering
+	IndexedTags []string `yaml:"indexed_tags,omitempty"`
+
+	// Store only batches whose cloud delivery failed for good (dead-lettered,
+	// expired from the queue or dropped), even with enabled false. The
+	// database is created on the first such batch.
+	RecordFailuresOnly bool `yaml:"record_failures_only,omitempty"`
 }
 
+// DefaultShutdownTimeout is the shutdown_timeout used when none is set.
+const DefaultShutdownTimeout = 20 * time.Second
+
+// DefaultAnalyticsQueueDepth is how many batches wait in memory for the
+// analytics database.
+const DefaultAnalyticsQueueDepth = 10
+
+// Default analytics truncation limits, used when max_message_bytes or
+// max_stacktrace_bytes is unset.
+const (
+	DefaultAnalyticsMaxMessageBytes    = 100_000 // 100KB
+	DefaultAnalyticsMaxStacktraceBytes = 50_000  // 50KB
+)
+
 // LoadConfig loads configuration from a YAML file
 func LoadConfig(path string) (*Config, error) {
 	data, resolvedPath, err := readConfig(path)
@@ -115,17 +385,46 @@ func LoadConfig(path string) (*Config, error) {
 	}
 
 	cfg.SourcePath = resolvedPath
+	cfg.UnknownKeys = unknownKeys(data)
 
+	if err := cfg.loadAPIKeyFile(); err != nil {
+		return nil, err
+	}
+	cfg.expandTagTemplates()
 	if err := cfg.applyDefaults(); err != nil {
 		return nil, err
 	}
-	if err := cfg.validate(); err != nil {
+	if err := cfg.Validate(); err != nil {
 		return nil, err
 	}
 
 	return &cfg, nil
 }
 
+// loadAPIKeyFile fills an empty api_key from api_key_file, or from
+// YAAT_API_KEY_FILE which overrides it. This keeps the key out of the YAML
+// when it is mounted as a Docker or Kubernetes secret.
+func (cfg *Config) loadAPIKeyFile() error {
+	if override := os.Getenv("YAAT_API_KEY_FILE"); override != "" {
+		cfg.APIKeyFile = override
+	}
+	if cfg.APIKeyFile == "" || cfg.APIKey != "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(cfg.APIKeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to read api_key_file: %w", err)
+	}
+	key := strings.TrimSpace(string(data))
+	if key == "" {
+		return fmt.Errorf("api_key_file %s is empty", cfg.APIKeyFile)
+	}
+	cfg.APIKey = key
+	cfg.APIKeyFromFile = true
+	return nil
+}
+
 // CreateSampleConfig creates a sample configuration file
 func CreateSampleConfig(path string) error {
 	sampleConfig := `# YAAT Sidecar Configuration
@@ -138,6 +437,9 @@ organization_id: "org_your_organization_id_here"
 # Your YAAT organization API key (required)
 # Get this from: https://yaat.io → Settings → API Keys
 api_key: "yaat_your_api_key_here"
+# Or read the key from a file such as a mounted secret (used when api_key is
+# empty; YAAT_API_KEY_FILE overrides this path)
+# api_key_file: "/run/secrets/yaat_api_key"
 
 # Service name (required)
 # This identifies your service in the YAAT dashboard
@@ -151,10 +453,13 @@ environment: "production"
 # Tags applied to all events (logs, spans, metrics)
 # Cloud provider (AWS/GCP/Azure) and Kubernetes metadata are auto-detected
 # and merged with custom tags. Custom tags take priority.
+# Values may reference environment variables as ${VAR} and the hostname as %h
 # tags:
 #   team: "backend"
 #   version: "v1.2.3"
 #   region: "us-west-2"
+#   host: "%h"
+#   pod: "${POD_NAME}"
 
 # HTTP Proxy Configuration (optional)
 # Monitor HTTP traffic by proxying requests to your application
@@ -162,44 +467,145 @@ proxy:
   enabled: false
   listen_port: 19000          # Port for sidecar to listen on
   upstream_url: "http://127.0.0.1:8000"  # Your application's URL
+  # latency_metrics: true  # Per-route http.server.duration percentiles
+  # max_pending_events: 1000  # Spans waiting to be recorded before new ones are dropped
+  # span_sample_rate: 0.1  # Fraction of 2xx/3xx spans kept; 4xx/5xx are kept with errors_always (default)
 
 # Log File Monitoring (optional)
 # Monitor multiple log files with different formats
 logs:
   # Example: Django application logs
   - path: "/var/log/myapp/app.log"
-    format: "django"  # Options: django, nginx, json
+    format: "django"  # Options: django, nginx, json, or auto to detect per line
+    # timezone: "Local"  # Zone for timestamps without an offset (IANA name, Local, or UTC)
+    # dedupe_window: "10s"  # Collapse identical repeated lines into one event with a count tag
+    # sample_rates:         # Keep a fraction of chatty levels; unlisted levels are always kept
+    #   debug: 0.1
 
   # Example: Nginx access logs
   # - path: "/var/log/nginx/access.log"
   #   format: "nginx"
+  #   span_sample_rate: 0.1     # Fraction of 2xx/3xx request spans kept
+  #   sampled_out_metric: true  # Count the dropped ones as http.requests.sampled_out
 
   # Example: JSON logs
   # - path: "/var/log/myapp/events.json"
   #   format: "json"
 
+  # Example: systemd journal of one unit
+  # - path: "myapp.service"
+  #   format: "journald"
+  #   journald:
+  #     include_fields: ["MY_APP_*"]  # Custom fields forwarded as journal.field.<name> tags
+  #     boot: current                 # Only this boot (default: all)
+
+  # Example: IIS logs (also alb for AWS ALB, cloudflare for Logpush JSON)
+  # - path: "C:/inetpub/logs/LogFiles/W3SVC1/u_ex.log"
+  #   format: "w3c"
+
+# Nested objects in JSON logs become dotted tags ({"http":{"status":500}} is
+# http.status=500) down to this many levels; deeper ones stay JSON strings
+# json_depth: 3
+
 # Event buffering configuration
 buffer_size: 1000           # Number of events to buffer before flushing
 flush_interval: "10s"       # How often to send events (e.g., 10s, 1m, 30s)
+flush_high_watermark: 0.8   # Flush early once the buffer is this full (fraction of buffer_size)
+shutdown_timeout: "20s"     # On SIGTERM, time allowed to flush and drain the queue before exiting
+
+# Sidecar's own log output: "text" or "json" (timestamp, level, component, message)
+# log_format: "text"
+# log_level: "info"         # debug | info | warn | error
 
 # Delivery tuning
 delivery:
   batch_size: 500           # Max events per HTTP request
   compress: true            # Gzip compress payloads
   max_batch_bytes: 0        # Optional soft limit in bytes (0 to disable)
+  # max_event_bytes: 262144 # Truncate larger events and tag them oversized=true (0 disables)
   queue_retention: "24h"    # How long to keep persisted batches before cleanup
   dead_letter_retention: "168h" # Retention for dead-letter batches
+  # dlq_max_retries: 3      # Retry dead-lettered batches this many times, then drop them (0 = never retry)
+  # dlq_retry_interval: "15m" # How often dead-lettered batches are retried
+  max_concurrency: 1        # Chunks of one flush sent in parallel (raise to catch up faster)
+  # group_by_service: true  # Never mix service_name values in one request
+  # service_keys:           # Per-service API keys (needs group_by_service; others use api_key)
+  #   billing: "yaat_billing_key"
+  clock_skew_warn: "30s"    # Warn when the host clock drifts from the API server by more than this
+  clock_skew_correct: false # Shift generated timestamps by the measured skew
+  pin_global_tags: true     # Add missing global tags to every event before sending
+  # queue_encryption_key_file: "/etc/yaat/queue.key" # Encrypt persisted batches (openssl rand -hex 32; chmod 600)
 
 # Host metrics
 metrics:
   enabled: false            # Set to true to publish host metrics
   interval: "30s"           # Sampling interval
   tags: {}                  # Optional static tags applied to host metrics
+  # namespace: "myprefix"   # Prefix host metric names (myprefix.host.cpu.usage_percent)
+  # max_tag_cardinality: 1000 # Distinct values per StatsD tag key before it is masked
+  # disk_devices: ["nvme*", "sda"] # Devices with I/O metrics (default: all but loop/ram)
+  # net_interfaces: ["eth*"]  # Interfaces with network metrics (default: all but lo)
   statsd:
     enabled: false          # Enable embedded StatsD/dogstatsd listener
     listen_addr: ":8125"   # UDP address to listen on (host:port or :port)
     namespace: ""          # Optional prefix added to metric names
     tags: {}                # Additional tags applied to all StatsD metrics
+    # gauge_ttl: "1h"        # Forget gauges not updated for this long (+N/-N restart from 0)
+    # max_timestamp_age: "1h" # Accept |T<unix> client timestamps up to this far from now
+    # flush_interval: "5s"    # Deliver StatsD metrics this often, ahead of flush_interval
+
+# Health and Prometheus metrics endpoint (--health-port overrides the port)
+# health:
+#   port: 8090                  # Listens on 127.0.0.1 unless listen_addr is set
+#   listen_addr: "0.0.0.0:8090" # Expose beyond localhost, e.g. for Kubernetes probes
+#   bearer_token: "change-me"   # Required on every path except /healthz
+
+# Self-telemetry: a periodic event with version, uptime, queue depths and
+# delivery counters so dead or struggling sidecars can be spotted
+# heartbeat:
+#   enabled: true
+#   interval: "60s"
+
+# Stamp the proxy's trace_id onto log lines whose request_id or x_request_id
+# matches the X-Request-ID header of a proxied request
+# correlation:
+#   enabled: true
+#   max_entries: 10000   # Recent requests remembered
+#   ttl: "5m"            # How long after the request a log line can match
+
+# Check the API key before tailing starts, so a bad key fails fast instead
+# of queueing. Posts an empty batch; no data is sent
+# startup_probe:
+#   enabled: true
+#   on_failure: exit       # exit (non-zero) or retry until the API accepts the key
+#   retry_interval: "30s"
+
+# Loopback HTTP endpoint for scripts and cron jobs: POST a JSON array of
+# events to /ingest and they are scrubbed, tagged and sent like any other
+# local_api:
+#   enabled: true
+#   listen_addr: "127.0.0.1:8127"   # Must be a loopback address
+#   max_body_bytes: 1048576         # Larger requests get 413
+#   max_events_per_minute: 6000     # Events over the rate are rejected
+
+# Cost controls applied to every input before buffering
+# limits:
+#   min_level: info               # Drop debug logs
+#   max_events_per_minute: 60000  # Global cap; overflow is dropped and counted
+#   drop_event_types: ["span"]    # Drop every event of these types
+#   always_keep_errors: true      # Error logs bypass the per-minute cap
+
+# Cloud and Kubernetes metadata detection at startup. Set cloud: off on
+# air-gapped hosts to skip the metadata service probes
+# detection:
+#   cloud: auto          # auto, off (or false), aws, gcp or azure
+#   kubernetes: auto     # auto or off (or false)
+#   timeout: "2s"        # Deadline for the metadata probes, retries included
+#   podinfo_path: /etc/podinfo   # Downward API volume with labels/annotations files
+#   pod_labels: ["app.kubernetes.io/*"]   # Labels tagged as k8s.label.<key>
+#   pod_annotations: ["team"]             # Annotations tagged as k8s.annotation.<key>
+#   overrides:           # Tags merged as if detected
+#     cloud.region: "us-east-1"
 
 # Data scrubbing (mask sensitive values before sending to YAAT)
 scrubbing:
@@ -215,6 +621,10 @@ scrubbing:
       pattern: "(?i)[A-Z0-9._%+-]+@[A-Z0-9.-]+\\.[A-Z]{2,}"
       replacement: "[EMAIL]"
       fields: ["message", "stacktrace", "tags.*"]
+  # rule_budget: "100ms"  # A rule taking longer on one event is disabled and logged
+  # tag_allowlist:   # Strict mode: remove every tag not listed, after the rules
+  #   - "http.*"
+  #   - "request_id"
 
 # Local Analytics (DuckDB)
 # Store events locally for instant SQL queries
@@ -226,6 +636,12 @@ analytics:
   max_size_gb: 2.0              # Max database size (auto-cleanup when exceeded)
   batch_size: 500               # Events per transaction
   write_timeout: "5s"           # Per-batch write timeout
+  # max_message_bytes: 100000   # Truncate stored messages (0 = keep full messages; larger DB)
+  # max_stacktrace_bytes: 50000 # Truncate stored stack traces (0 = no truncation)
+  # spill_overflow: true        # Spill batches to disk during write stalls instead of dropping
+  # spill_max_mb: 64            # Cap for the spill; oldest batches are dropped beyond it
+  # indexed_tags: [path, method] # Tags stored in indexed columns for fast filtering
+  # record_failures_only: true  # Store only batches that failed delivery (dead-lettered or expired)
 
 # YAAT API endpoint (required for cloud mode)
 # Production: https://yaat.io/api/v1/ingest
@@ -245,12 +661,14 @@ api_endpoint: "https://yaat.io/api/v1/ingest"
 }
 
 // SaveConfig persists the configuration to disk, creating parent directories when required.
+// An existing file is updated in place: comments, key order and unknown keys are kept
+// (blank lines and comment alignment are normalised by the YAML encoder).
 func SaveConfig(path string, cfg *Config) error {
 	if cfg == nil {
 		return fmt.Errorf("config is nil")
 	}
 
-	if err := cfg.validate(); err != nil {
+	if err := cfg.Validate(); err != nil {
 		return err
 	}
 	if err := cfg.applyDefaults(); err != nil {
@@ -264,9 +682,33 @@ func SaveConfig(path string, cfg *Config) error {
 		}
 	}
 
-	data, err := yaml.Marshal(cfg)
-	if err != nil {
-		return fmt.Errorf("failed to marshal config: %w", err)
+	// A key read from api_key_file is never written into the YAML
+	out := cfg
+	if cfg.APIKeyFromFile {
+		stripped := *cfg
+		stripped.APIKey = ""
+		out = &stripped
+	}
+	// Templated tags are written back as templates, not this host's values
+	out = out.withTagTemplates()
+
+	// Round-trip through the existing document so comments and keys the
+	// struct does not know about survive; marshal from scratch only for new files.
+	var data []byte
+	original, err := os.ReadFile(path)
+	switch {
+	case err == nil && len(bytes.TrimSpace(original)) > 0:
+		data, err = mergeIntoDocument(original, out)
+		if err != nil {
+			return err
+		}
+	case err != nil && !errors.Is(err, os.ErrNotExist):
+		return fmt.Errorf("failed to read existing config: %w", err)
+	default:
+		data, err = yaml.Marshal(out)
+		if err != nil {
+			return fmt.Errorf("failed to marshal config: %w", err)
+		}
 	}
 
 	if err := os.WriteFile(path, data, 0o600); err != nil {
@@ -277,6 +719,41 @@ func SaveConfig(path string, cfg *Config) error {
 	return nil
 }
 
+// Redacted returns a copy of cfg that is safe to print: the API key, per-service
+// keys, OTLP header values and the health bearer token are masked. cfg itself
+// is not modified.
+func (cfg *Config) Redacted() *Config {
+	out := *cfg
+	out.APIKey = maskSecret(cfg.APIKey)
+	out.Delivery.ServiceKeys = maskValues(cfg.Delivery.ServiceKeys)
+	out.OTLP.Headers = maskValues(cfg.OTLP.Headers)
+	out.Health.BearerToken = maskSecret(cfg.Health.BearerToken)
+	return &out
+}
+
+// maskSecret keeps a short prefix so keys can still be told apart.
+func maskSecret(secret string) string {
+	switch {
+	case secret == "":
+		return ""
+	case len(secret) < 10:
+		return "***"
+	default:
+		return secret[:7] + "***"
+	}
+}
+
+func maskValues(values map[string]string) map[string]string {
+	if values == nil {
+		return nil
+	}
+	masked := make(map[string]string, len(values))
+	for k, v := range values {
+		masked[k] = maskSecret(v)
+	}
+	return masked
+}
+
 // DefaultConfigPath returns the recommended location for the config file.
 func DefaultConfigPath() string {
 	if override := os.Getenv("YAAT_CONFIG_PATH"); override != "" {
@@ -288,24 +765,237 @@ func DefaultConfigPath() string {
 	return "yaat.yaml"
 }
 
-func (cfg *Config) validate() error {
+// DefaultAnalyticsPath returns the default instance's analytics database,
+// ~/.yaat/analytics.db.
+func DefaultAnalyticsPath() string {
+	return paths.Detect().HomeLayout(paths.DefaultInstance).AnalyticsDB
+}
+
+// FieldError reports an invalid value for one configuration key.
+type FieldError struct {
+	Field   string // YAML key, e.g. "organization_id" or "otlp.endpoint"
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	return e.Field + " " + e.Message
+}
+
+// ValidationError lists every invalid field found by Validate.
+type ValidationError struct {
+	Fields []*FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Field returns the error reported for key, or nil.
+func (e *ValidationError) Field(key string) *FieldError {
+	for _, f := range e.Fields {
+		if f.Field == key {
+			return f
+		}
+	}
+	return nil
+}
+
+// Validate applies the rules LoadConfig and SaveConfig enforce. It returns a
+// *ValidationError describing every invalid field, or nil.
+func (cfg *Config) Validate() error {
+	var errs ValidationError
+	fail := func(field, format string, args ...interface{}) {
+		errs.Fields = append(errs.Fields, &FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
 	if cfg.ServiceName == "" {
-		return fmt.Errorf("service_name is required")
+		fail("service_name", "is required")
 	}
 
 	// API key and organization ID are not required in local-only mode
 	if cfg.APIKey != "" {
 		if cfg.OrganizationID == "" {
-			return fmt.Errorf("organization_id is required when api_key is set")
+			fail("organization_id", "is required when api_key is set")
 		}
 		if cfg.APIEndpoint == "" {
-			return fmt.Errorf("api_endpoint is required when api_key is set")
+			fail("api_endpoint", "is required when api_key is set")
 		}
 	}
+	if len(cfg.Delivery.ServiceKeys) > 0 && !cfg.Delivery.GroupByService {
+		fail("delivery.service_keys", "requires delivery.group_by_service")
+	}
+	if cfg.OTLP.Enabled && cfg.OTLP.Endpoint == "" {
+		fail("otlp.endpoint", "is required when otlp is enabled")
+	}
+	if cfg.FlushHighWatermark < 0 || cfg.FlushHighWatermark > 1 {
+		fail("flush_high_watermark", "must be between 0 and 1, got %v", cfg.FlushHighWatermark)
+	}
+	for i, logCfg := range cfg.Logs {
+		for level, rate := range logCfg.SampleRates {
+			if rate < 0 || rate > 1 {
+				fail(fmt.Sprintf("logs[%d].sample_rates.%s", i, level), "must be between 0 and 1, got %v", rate)
+			}
+		}
+		if rate := logCfg.SpanSampling.SpanSampleRate; rate != nil && (*rate < 0 || *rate > 1) {
+			fail(fmt.Sprintf("logs[%d].span_sample_rate", i), "must be between 0 and 1, got %v", *rate)
+		}
+		if !strings.EqualFold(logCfg.Format, "journald") {
+			if !logCfg.Journald.isZero() {
+				fail(fmt.Sprintf("logs[%d].journald", i), "only applies to format: journald")
+			}
+			continue
+		}
+		for _, pattern := range logCfg.Journald.IncludeFields {
+			if _, err := filepath.Match(pattern, ""); err != nil || pattern == "" {
+				fail(fmt.Sprintf("logs[%d].journald.include_fields", i), "invalid pattern %q", pattern)
+			}
+		}
+		switch logCfg.Journald.Boot {
+		case "", "current", "all":
+		default:
+			fail(fmt.Sprintf("logs[%d].journald.boot", i), "must be current or all, got %q", logCfg.Journald.Boot)
+		}
+		if strings.ContainsAny(logCfg.Journald.Namespace, "/ ") {
+			fail(fmt.Sprintf("logs[%d].journald.namespace", i), "invalid namespace %q", logCfg.Journald.Namespace)
+		}
+	}
+	if rate := cfg.Proxy.SpanSampling.SpanSampleRate; rate != nil && (*rate < 0 || *rate > 1) {
+		fail("proxy.span_sample_rate", "must be between 0 and 1, got %v", *rate)
+	}
+	if cfg.Health.Port < 0 || cfg.Health.Port > 65535 {
+		fail("health.port", "must be between 0 and 65535, got %d", cfg.Health.Port)
+	}
+	if cfg.Health.ListenAddr != "" {
+		if _, port, err := net.SplitHostPort(cfg.Health.ListenAddr); err != nil {
+			fail("health.listen_addr", "must be host:port: %v", err)
+		} else if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+			fail("health.listen_addr", "has invalid port %q", port)
+		}
+	}
+	if cfg.LocalAPI.ListenAddr != "" {
+		if host, port, err := net.SplitHostPort(cfg.LocalAPI.ListenAddr); err != nil {
+			fail("local_api.listen_addr", "must be host:port: %v", err)
+		} else if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+			fail("local_api.listen_addr", "has invalid port %q", port)
+		} else if !isLoopback(host) {
+			fail("local_api.listen_addr", "must be a loopback address, got %q", host)
+		}
+	}
+	switch strings.ToLower(cfg.Limits.MinLevel) {
+	case "", "debug", "info", "warn", "warning", "error", "critical":
+	default:
+		fail("limits.min_level", "must be debug, info, warning, error or critical, got %q", cfg.Limits.MinLevel)
+	}
+	if cfg.Limits.MaxEventsPerMinute < 0 {
+		fail("limits.max_events_per_minute", "must not be negative, got %d", cfg.Limits.MaxEventsPerMinute)
+	}
+	for i, eventType := range cfg.Limits.DropEventTypes {
+		switch strings.ToLower(eventType) {
+		case "log", "span", "metric":
+		default:
+			fail(fmt.Sprintf("limits.drop_event_types[%d]", i), "must be log, span or metric, got %q", eventType)
+		}
+	}
+	switch cfg.Detection.Cloud {
+	case "", "auto", "off", "true", "false", "aws", "gcp", "azure":
+	default:
+		fail("detection.cloud", "must be auto, off, aws, gcp or azure, got %q", cfg.Detection.Cloud)
+	}
+	switch cfg.Detection.Kubernetes {
+	case "", "auto", "off", "true", "false":
+	default:
+		fail("detection.kubernetes", "must be auto or off, got %q", cfg.Detection.Kubernetes)
+	}
+	switch cfg.StartupProbe.OnFailure {
+	case "", "exit", "retry":
+	default:
+		fail("startup_probe.on_failure", "must be exit or retry, got %q", cfg.StartupProbe.OnFailure)
+	}
+	switch cfg.LogFormat {
+	case "", "text", "json":
+	default:
+		fail("log_format", "must be text or json, got %q", cfg.LogFormat)
+	}
+	switch cfg.LogLevel {
+	case "", "debug", "info", "warn", "warning", "error":
+	default:
+		fail("log_level", "must be debug, info, warn or error, got %q", cfg.LogLevel)
+	}
+	// A batch waits up to a flush interval before its first delivery attempt;
+	// with too little retention left it can age out of the queue untried
+	if retention := cfg.Delivery.QueueRetentionDuration; retention > 0 && cfg.FlushIntervalDuration > retention/10 {
+		fail("flush_interval", "must be at most 10%% of delivery.queue_retention (%s), got %s", cfg.Delivery.QueueRetention, cfg.FlushInterval)
+	}
 
+	if len(errs.Fields) > 0 {
+		return &errs
+	}
 	return nil
 }
 
+// Warnings reports settings that are valid on their own but combine badly,
+// such as intervals that make events pile up in memory, and keys of the file
+// that were ignored because no option takes them. Unlike Validate
+// failures they do not stop the sidecar; --validate lists them and startup
+// logs them.
+func (cfg *Config) Warnings() []*FieldError {
+	var warnings []*FieldError
+	warn := func(field, format string, args ...interface{}) {
+		warnings = append(warnings, &FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if cfg.Metrics.Enabled && cfg.Metrics.IntervalDuration > 0 && cfg.Metrics.IntervalDuration < cfg.FlushIntervalDuration {
+		samples := int(cfg.FlushIntervalDuration / cfg.Metrics.IntervalDuration)
+		warn("metrics.interval", "(%s) is shorter than flush_interval (%s), so %d host metric samples build up between flushes; make sure buffer_size (%d) has room for them next to logs and spans",
+			cfg.Metrics.Interval, cfg.FlushInterval, samples, cfg.BufferSize)
+	}
+	if cfg.Analytics.Enabled && cfg.FlushIntervalDuration > 0 && cfg.Analytics.TimeoutDuration > cfg.FlushIntervalDuration {
+		warn("analytics.write_timeout", "(%s) is longer than flush_interval (%s); a stalled database fills the %d-batch analytics queue within %s and later batches are dropped",
+			cfg.Analytics.WriteTimeout, cfg.FlushInterval, DefaultAnalyticsQueueDepth, cfg.FlushIntervalDuration*DefaultAnalyticsQueueDepth)
+	}
+	if d := cfg.Delivery; d.DLQMaxRetries > 0 && d.DeadLetterRetentionDuration > 0 && d.DLQRetryIntervalDuration*time.Duration(d.DLQMaxRetries) > d.DeadLetterRetentionDuration {
+		warn("delivery.dlq_max_retries", "(%d) retries every %s take longer than dead_letter_retention (%s); batches are removed by retention before their last retry",
+			d.DLQMaxRetries, d.DLQRetryInterval, d.DeadLetterRetention)
+	}
+	if cfg.Analytics.RecordFailuresOnly {
+		if cfg.APIKey == "" {
+			warn("analytics.record_failures_only", "has no effect without api_key; nothing is delivered, so nothing can fail")
+		} else if cfg.Analytics.Enabled {
+			warn("analytics.enabled", "is overridden by record_failures_only; successfully delivered events are not stored")
+		}
+	}
+	for _, key := range cfg.UnknownKeys {
+		warn(key, "is not a known option and was ignored")
+	}
+	return warnings
+}
+
+// isLoopback reports whether host names this machine only.
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// detectionMode maps the YAML booleans accepted by detection.cloud and
+// detection.kubernetes onto auto and off, and defaults to auto.
+func detectionMode(value string) string {
+	switch value {
+	case "", "true":
+		return "auto"
+	case "false":
+		return "off"
+	default:
+		return value
+	}
+}
+
 func (cfg *Config) applyDefaults() error {
 	if cfg.Environment == "" {
 		cfg.Environment = "production"
@@ -316,12 +1006,27 @@ func (cfg *Config) applyDefaults() error {
 	if cfg.FlushInterval == "" {
 		cfg.FlushInterval = "10s"
 	}
+	if cfg.ShutdownTimeout == "" {
+		cfg.ShutdownTimeout = DefaultShutdownTimeout.String()
+	}
+	if cfg.FlushHighWatermark == 0 {
+		cfg.FlushHighWatermark = 0.8
+	}
 	if cfg.Delivery.BatchSize <= 0 {
 		cfg.Delivery.BatchSize = 500
 	}
 	if cfg.Delivery.MaxBatchBytes < 0 {
 		cfg.Delivery.MaxBatchBytes = 0
 	}
+	if cfg.Delivery.MaxConcurrency <= 0 {
+		cfg.Delivery.MaxConcurrency = 1
+	}
+	if cfg.Delivery.MaxEventBytes == nil {
+		limit := 256 * 1024
+		cfg.Delivery.MaxEventBytes = &limit
+	} else if *cfg.Delivery.MaxEventBytes < 0 {
+		return fmt.Errorf("invalid delivery.max_event_bytes: must be >= 0")
+	}
 	if cfg.Delivery.QueueRetention == "" {
 		cfg.Delivery.QueueRetention = "24h"
 	}
@@ -342,6 +1047,28 @@ func (cfg *Config) applyDefaults() error {
 			return fmt.Errorf("invalid delivery.dead_letter_retention: %w", err)
 		}
 	}
+	if cfg.Delivery.DLQMaxRetries < 0 {
+		return fmt.Errorf("invalid delivery.dlq_max_retries: must be >= 0")
+	}
+	if cfg.Delivery.DLQRetryInterval == "" {
+		cfg.Delivery.DLQRetryInterval = "15m"
+	}
+	dlqRetryInterval, err := time.ParseDuration(cfg.Delivery.DLQRetryInterval)
+	if err != nil {
+		return fmt.Errorf("invalid delivery.dlq_retry_interval: %w", err)
+	}
+	if dlqRetryInterval <= 0 {
+		return fmt.Errorf("invalid delivery.dlq_retry_interval: must be positive")
+	}
+	cfg.Delivery.DLQRetryIntervalDuration = dlqRetryInterval
+	if cfg.Delivery.ClockSkewWarn == "" {
+		cfg.Delivery.ClockSkewWarn = "30s"
+	}
+	if dur, err := time.ParseDuration(cfg.Delivery.ClockSkewWarn); err == nil {
+		cfg.Delivery.ClockSkewWarnDuration = dur
+	} else {
+		return fmt.Errorf("invalid delivery.clock_skew_warn: %w", err)
+	}
 	if cfg.Metrics.Enabled {
 		if cfg.Metrics.Interval == "" {
 			cfg.Metrics.Interval = "30s"
@@ -357,6 +1084,174 @@ func (cfg *Config) applyDefaults() error {
 		}
 		cfg.Metrics.IntervalDuration = dur
 	}
+	if cfg.Scrubbing.RuleBudget == "" {
+		cfg.Scrubbing.RuleBudget = "100ms"
+	}
+	if dur, err := time.ParseDuration(cfg.Scrubbing.RuleBudget); err != nil {
+		return fmt.Errorf("invalid scrubbing.rule_budget: %w", err)
+	} else if dur <= 0 {
+		return fmt.Errorf("invalid scrubbing.rule_budget: must be positive")
+	} else {
+		cfg.Scrubbing.RuleBudgetDuration = dur
+	}
+	if cfg.Metrics.MaxTagCardinality < 0 {
+		return fmt.Errorf("invalid metrics.max_tag_cardinality: must be >= 0")
+	}
+	if cfg.Metrics.MaxTagCardinality == 0 {
+		cfg.Metrics.MaxTagCardinality = 1000
+	}
+	if cfg.Metrics.StatsD.GaugeTTL != "" {
+		dur, err := time.ParseDuration(cfg.Metrics.StatsD.GaugeTTL)
+		if err != nil {
+			return fmt.Errorf("invalid metrics.statsd.gauge_ttl: %w", err)
+		}
+		if dur <= 0 {
+			return fmt.Errorf("invalid metrics.statsd.gauge_ttl: must be positive")
+		}
+		cfg.Metrics.StatsD.GaugeTTLDuration = dur
+	}
+	if cfg.Metrics.StatsD.MaxTimestampAge != "" {
+		dur, err := time.ParseDuration(cfg.Metrics.StatsD.MaxTimestampAge)
+		if err != nil {
+			return fmt.Errorf("invalid metrics.statsd.max_timestamp_age: %w", err)
+		}
+		if dur <= 0 {
+			return fmt.Errorf("invalid metrics.statsd.max_timestamp_age: must be positive")
+		}
+		cfg.Metrics.StatsD.MaxTimestampAgeDuration = dur
+	}
+	if cfg.Metrics.StatsD.FlushInterval != "" {
+		dur, err := time.ParseDuration(cfg.Metrics.StatsD.FlushInterval)
+		if err != nil {
+			return fmt.Errorf("invalid metrics.statsd.flush_interval: %w", err)
+		}
+		if dur <= 0 {
+			return fmt.Errorf("invalid metrics.statsd.flush_interval: must be positive")
+		}
+		cfg.Metrics.StatsD.FlushIntervalDuration = dur
+	}
+	if cfg.Delivery.PinGlobalTags == nil {
+		pin := true
+		cfg.Delivery.PinGlobalTags = &pin
+	}
+	if cfg.Limits.AlwaysKeepErrors == nil {
+		keep := true
+		cfg.Limits.AlwaysKeepErrors = &keep
+	}
+	cfg.Detection.Cloud = detectionMode(cfg.Detection.Cloud)
+	cfg.Detection.Kubernetes = detectionMode(cfg.Detection.Kubernetes)
+	if cfg.Detection.Timeout == "" {
+		cfg.Detection.Timeout = "2s"
+	}
+	if dur, err := time.ParseDuration(cfg.Detection.Timeout); err != nil {
+		return fmt.Errorf("invalid detection.timeout: %w", err)
+	} else if dur <= 0 {
+		return fmt.Errorf("invalid detection.timeout: must be positive")
+	} else {
+		cfg.Detection.TimeoutDuration = dur
+	}
+	if cfg.Heartbeat.Enabled && cfg.Heartbeat.Interval == "" {
+		cfg.Heartbeat.Interval = "60s"
+	}
+	if cfg.Heartbeat.Interval != "" {
+		dur, err := time.ParseDuration(cfg.Heartbeat.Interval)
+		if err != nil {
+			return fmt.Errorf("invalid heartbeat.interval: %w", err)
+		}
+		if dur <= 0 {
+			return fmt.Errorf("invalid heartbeat.interval: must be positive")
+		}
+		cfg.Heartbeat.IntervalDuration = dur
+	}
+	if cfg.JSONDepth < 0 {
+		return fmt.Errorf("invalid json_depth: must be >= 0")
+	}
+	if cfg.Proxy.MaxPendingEvents < 0 {
+		return fmt.Errorf("invalid proxy.max_pending_events: must be >= 0")
+	}
+	if cfg.LocalAPI.MaxBodyBytes < 0 {
+		return fmt.Errorf("invalid local_api.max_body_bytes: must be >= 0")
+	}
+	if cfg.LocalAPI.MaxEventsPerMinute < 0 {
+		return fmt.Errorf("invalid local_api.max_events_per_minute: must be >= 0")
+	}
+	if cfg.LocalAPI.Enabled {
+		if cfg.LocalAPI.ListenAddr == "" {
+			cfg.LocalAPI.ListenAddr = "127.0.0.1:8127"
+		}
+		if cfg.LocalAPI.MaxBodyBytes == 0 {
+			cfg.LocalAPI.MaxBodyBytes = 1 << 20
+		}
+		if cfg.LocalAPI.MaxEventsPerMinute == 0 {
+			cfg.LocalAPI.MaxEventsPerMinute = 6000
+		}
+	}
+	if cfg.Correlation.MaxEntries < 0 {
+		return fmt.Errorf("invalid correlation.max_entries: must be >= 0")
+	}
+	if cfg.Correlation.TTL != "" {
+		dur, err := time.ParseDuration(cfg.Correlation.TTL)
+		if err != nil {
+			return fmt.Errorf("invalid correlation.ttl: %w", err)
+		}
+		if dur <= 0 {
+			return fmt.Errorf("invalid correlation.ttl: must be positive")
+		}
+		cfg.Correlation.TTLDuration = dur
+	}
+	if cfg.StartupProbe.OnFailure == "" {
+		cfg.StartupProbe.OnFailure = "exit"
+	}
+	if cfg.StartupProbe.RetryInterval == "" {
+		cfg.StartupProbe.RetryInterval = "30s"
+	}
+	dur, err := time.ParseDuration(cfg.StartupProbe.RetryInterval)
+	if err != nil {
+		return fmt.Errorf("invalid startup_probe.retry_interval: %w", err)
+	}
+	if dur <= 0 {
+		return fmt.Errorf("invalid startup_probe.retry_interval: must be positive")
+	}
+	cfg.StartupProbe.RetryIntervalDuration = dur
+	for i := range cfg.Logs {
+		loc, err := parseTimezone(cfg.Logs[i].Timezone)
+		if err != nil {
+			return fmt.Errorf("invalid logs[%d].timezone: %w", i, err)
+		}
+		cfg.Logs[i].Location = loc
+
+		if cfg.Logs[i].DedupeWindow != "" {
+			dur, err := time.ParseDuration(cfg.Logs[i].DedupeWindow)
+			if err != nil {
+				return fmt.Errorf("invalid logs[%d].dedupe_window: %w", i, err)
+			}
+			if dur < 0 {
+				return fmt.Errorf("invalid logs[%d].dedupe_window: must not be negative", i)
+			}
+			cfg.Logs[i].DedupeWindowDuration = dur
+		}
+
+		backfill := &cfg.Logs[i].Backfill
+		if backfill.Enabled {
+			if backfill.MaxFiles <= 0 {
+				backfill.MaxFiles = 3
+			}
+			if backfill.LinesPerSecond <= 0 {
+				backfill.LinesPerSecond = 1000
+			}
+		}
+		if backfill.MaxAge != "" {
+			dur, err := time.ParseDuration(backfill.MaxAge)
+			if err != nil {
+				return fmt.Errorf("invalid logs[%d].backfill.max_age: %w", i, err)
+			}
+			backfill.MaxAgeDuration = dur
+		}
+	}
+	cfg.Proxy.SpanSampling.applyDefaults()
+	for i := range cfg.Logs {
+		cfg.Logs[i].SpanSampling.applyDefaults()
+	}
 	for i := range cfg.Scrubbing.Rules {
 		if cfg.Scrubbing.Rules[i].Replacement == "" && !cfg.Scrubbing.Rules[i].Drop {
 			cfg.Scrubbing.Rules[i].Replacement = "[REDACTED]"
@@ -367,14 +1262,29 @@ func (cfg *Config) applyDefaults() error {
 		return fmt.Errorf("invalid flush_interval: %w", err)
 	}
 	cfg.FlushIntervalDuration = duration
+	shutdownTimeout, err := time.ParseDuration(cfg.ShutdownTimeout)
+	if err != nil {
+		return fmt.Errorf("invalid shutdown_timeout: %w", err)
+	}
+	if shutdownTimeout <= 0 {
+		return fmt.Errorf("invalid shutdown_timeout: must be positive")
+	}
+	cfg.ShutdownTimeoutDuration = shutdownTimeout
+
+	if cfg.OTLP.Enabled && cfg.OTLP.Timeout == "" {
+		cfg.OTLP.Timeout = "10s"
+	}
+	if cfg.OTLP.Timeout != "" {
+		dur, err := time.ParseDuration(cfg.OTLP.Timeout)
+		if err != nil {
+			return fmt.Errorf("invalid otlp.timeout: %w", err)
+		}
+		cfg.OTLP.TimeoutDuration = dur
+	}
 
 	// Analytics defaults
 	if cfg.Analytics.DatabasePath == "" {
-		if home, err := os.UserHomeDir(); err == nil {
-			cfg.Analytics.DatabasePath = filepath.Join(home, ".yaat", "analytics.db")
-		} else {
-			cfg.Analytics.DatabasePath = ".yaat/analytics.db"
-		}
+		cfg.Analytics.DatabasePath = DefaultAnalyticsPath()
 	}
 	if cfg.Analytics.RetentionDays == 0 {
 		cfg.Analytics.RetentionDays = 14
@@ -388,6 +1298,26 @@ func (cfg *Config) applyDefaults() error {
 	if cfg.Analytics.WriteTimeout == "" {
 		cfg.Analytics.WriteTimeout = "5s"
 	}
+	if cfg.Analytics.MaxMessageBytes == nil {
+		limit := DefaultAnalyticsMaxMessageBytes
+		cfg.Analytics.MaxMessageBytes = &limit
+	} else if *cfg.Analytics.MaxMessageBytes < 0 {
+		return fmt.Errorf("invalid analytics.max_message_bytes: must be >= 0")
+	}
+	if cfg.Analytics.MaxStacktraceBytes == nil {
+		limit := DefaultAnalyticsMaxStacktraceBytes
+		cfg.Analytics.MaxStacktraceBytes = &limit
+	} else if *cfg.Analytics.MaxStacktraceBytes < 0 {
+		return fmt.Errorf("invalid analytics.max_stacktrace_bytes: must be >= 0")
+	}
+	if cfg.Analytics.SpillMaxMB <= 0 {
+		cfg.Analytics.SpillMaxMB = 64
+	}
+	for _, tag := range cfg.Analytics.IndexedTags {
+		if strings.TrimSpace(tag) == "" || strings.Contains(tag, `"`) {
+			return fmt.Errorf("invalid analytics.indexed_tags entry %q", tag)
+		}
+	}
 	if cfg.Analytics.WriteTimeout != "" {
 		dur, err := time.ParseDuration(cfg.Analytics.WriteTimeout)
 		if err != nil {
@@ -399,6 +1329,18 @@ func (cfg *Config) applyDefaults() error {
 	return nil
 }
 
+// parseTimezone resolves a log timezone setting. Empty keeps the UTC default.
+func parseTimezone(name string) (*time.Location, error) {
+	name = strings.TrimSpace(name)
+	switch strings.ToLower(name) {
+	case "", "utc":
+		return time.UTC, nil
+	case "local":
+		return time.Local, nil
+	}
+	return time.LoadLocation(name)
+}
+
 func readConfig(path string) ([]byte, string, error) {
 	candidates := []string{path}
 
@@ -0,0 +1,547 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func copyFixture(t *testing.T, name string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "yaat.yaml")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("Failed to write fixture: %v", err)
+	}
+	return path
+}
+
+func normalizedLines(text string) []string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		lines = append(lines, line[:indent]+strings.Join(strings.Fields(line), " "))
+	}
+	return lines
+}
+
+func TestSaveConfigPreservesCommentsAndUnknownKeys(t *testing.T) {
+	path := copyFixture(t, "commented.yaml")
+	original, _ := os.ReadFile(path)
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	cfg.Environment = "production"
+	cfg.BufferSize = 2500
+
+	if err := SaveConfig(path, cfg); err != nil {
+		t.Fatalf("SaveConfig failed: %v", err)
+	}
+	saved, _ := os.ReadFile(path)
+
+	// Exactly the two edited lines should differ. The encoder drops blank
+	// lines and re-aligns trailing comments, so compare normalised lines.
+	before := normalizedLines(string(original))
+	after := normalizedLines(string(saved))
+	if len(before) != len(after) {
+		t.Fatalf("Expected %d lines, got %d:\n%s", len(before), len(after), saved)
+	}
+	var changed []string
+	for i := range before {
+		if before[i] != after[i] {
+			changed = append(changed, after[i])
+		}
+	}
+	expected := []string{`environment: "production"`, `buffer_size: 2500`}
+	if strings.Join(changed, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected changed lines %q, got %q\n%s", expected, changed, saved)
+	}
+
+	reloaded, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if reloaded.Environment != "production" || reloaded.BufferSize != 2500 {
+		t.Errorf("Expected edits to round-trip, got %q/%d", reloaded.Environment, reloaded.BufferSize)
+	}
+}
+
+func TestSaveConfigUpdatesNestedAndAddsKeys(t *testing.T) {
+	path := copyFixture(t, "commented.yaml")
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	cfg.Logs[0].Format = "json"
+	cfg.Tags = map[string]string{"team": "payments"}
+
+	if err := SaveConfig(path, cfg); err != nil {
+		t.Fatalf("SaveConfig failed: %v", err)
+	}
+	saved, _ := os.ReadFile(path)
+	text := string(saved)
+
+	for _, want := range []string{"# Application log", "region: eu-west-1", `format: "json"`, "team: payments"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected output to contain %q:\n%s", want, text)
+		}
+	}
+}
+
+func TestSaveConfigWritesNewFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "yaat.yaml")
+	cfg := &Config{
+		OrganizationID: "org_123",
+		APIKey:         "yaat_secret",
+		ServiceName:    "svc",
+		APIEndpoint:    "https://yaat.io/api/v1/ingest",
+	}
+
+	if err := SaveConfig(path, cfg); err != nil {
+		t.Fatalf("SaveConfig failed: %v", err)
+	}
+	reloaded, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if reloaded.ServiceName != "svc" {
+		t.Errorf("Expected service_name svc, got %q", reloaded.ServiceName)
+	}
+}
+
+func TestValidateReportsEveryField(t *testing.T) {
+	cfg := &Config{APIKey: "yaat_secret", LogFormat: "xml"}
+	cfg.Delivery.ServiceKeys = map[string]string{"billing": "yaat_billing"}
+	cfg.StartupProbe.OnFailure = "crash"
+
+	err := cfg.Validate()
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("Expected *ValidationError, got %v", err)
+	}
+	for _, field := range []string{"service_name", "organization_id", "api_endpoint", "log_format", "delivery.service_keys", "startup_probe.on_failure"} {
+		if verr.Field(field) == nil {
+			t.Errorf("Expected an error for %s, got %v", field, err)
+		}
+	}
+
+	// Local-only mode needs neither the org ID nor the endpoint
+	local := &Config{ServiceName: "svc"}
+	if err := local.Validate(); err != nil {
+		t.Errorf("Expected local-only config to be valid, got %v", err)
+	}
+}
+
+func TestAPIKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "api_key")
+	if err := os.WriteFile(keyPath, []byte("  yaat_from_secret\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+	path := filepath.Join(dir, "yaat.yaml")
+	content := "organization_id: org_123\nservice_name: svc\napi_key: \"\"\napi_key_file: " + keyPath + "\napi_endpoint: https://yaat.io/api/v1/ingest\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	t.Setenv("YAAT_API_KEY_FILE", "")
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if cfg.APIKey != "yaat_from_secret" || !cfg.APIKeyFromFile {
+		t.Fatalf("Expected trimmed key from file, got %q (from file: %v)", cfg.APIKey, cfg.APIKeyFromFile)
+	}
+
+	cfg.Environment = "staging"
+	if err := SaveConfig(path, cfg); err != nil {
+		t.Fatalf("SaveConfig failed: %v", err)
+	}
+	saved, _ := os.ReadFile(path)
+	if strings.Contains(string(saved), "yaat_from_secret") {
+		t.Errorf("Expected key from file not to be written back:\n%s", saved)
+	}
+	if !strings.Contains(string(saved), "api_key_file: "+keyPath) {
+		t.Errorf("Expected api_key_file to be kept:\n%s", saved)
+	}
+
+	// The environment variable overrides the configured path
+	envKey := filepath.Join(dir, "env_key")
+	if err := os.WriteFile(envKey, []byte("yaat_from_env"), 0o600); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+	t.Setenv("YAAT_API_KEY_FILE", envKey)
+	cfg, err = LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if cfg.APIKey != "yaat_from_env" {
+		t.Errorf("Expected key from YAAT_API_KEY_FILE, got %q", cfg.APIKey)
+	}
+
+	// An inline key wins over the file
+	inline := strings.Replace(content, `api_key: ""`, `api_key: "yaat_inline"`, 1)
+	if err := os.WriteFile(path, []byte(inline), 0o600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	cfg, err = LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if cfg.APIKey != "yaat_inline" || cfg.APIKeyFromFile {
+		t.Errorf("Expected inline key, got %q (from file: %v)", cfg.APIKey, cfg.APIKeyFromFile)
+	}
+
+	t.Setenv("YAAT_API_KEY_FILE", filepath.Join(dir, "missing"))
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if _, err := LoadConfig(path); err == nil {
+		t.Error("Expected error for a missing key file")
+	}
+}
+
+func TestRedactedMasksSecrets(t *testing.T) {
+	cfg := &Config{
+		APIKey:   "yaat_live_1234567890",
+		Delivery: DeliveryConfig{ServiceKeys: map[string]string{"billing": "yaat_billing_abcdef"}},
+		OTLP:     OTLPConfig{Headers: map[string]string{"Authorization": "Bearer token"}},
+		Health:   HealthConfig{BearerToken: "health_token_123"},
+	}
+
+	out := cfg.Redacted()
+	if out.APIKey != "yaat_li***" {
+		t.Errorf("Expected masked api_key, got %q", out.APIKey)
+	}
+	if out.Delivery.ServiceKeys["billing"] != "yaat_bi***" {
+		t.Errorf("Expected masked service key, got %q", out.Delivery.ServiceKeys["billing"])
+	}
+	if out.OTLP.Headers["Authorization"] != "Bearer ***" {
+		t.Errorf("Expected masked OTLP header, got %q", out.OTLP.Headers["Authorization"])
+	}
+	if out.Health.BearerToken != "health_***" {
+		t.Errorf("Expected masked health bearer token, got %q", out.Health.BearerToken)
+	}
+
+	if cfg.APIKey != "yaat_live_1234567890" || cfg.Delivery.ServiceKeys["billing"] != "yaat_billing_abcdef" {
+		t.Error("Expected the original config to be left unmasked")
+	}
+}
+
+func TestHealthAddr(t *testing.T) {
+	tests := []struct {
+		name     string
+		health   HealthConfig
+		override int
+		want     string
+	}{
+		{"disabled", HealthConfig{}, 0, ""},
+		{"port binds localhost", HealthConfig{Port: 8090}, 0, "127.0.0.1:8090"},
+		{"flag enables on localhost", HealthConfig{}, 9000, "127.0.0.1:9000"},
+		{"flag overrides port", HealthConfig{Port: 8090}, 9000, "127.0.0.1:9000"},
+		{"listen_addr", HealthConfig{ListenAddr: "0.0.0.0:8090"}, 0, "0.0.0.0:8090"},
+		{"flag keeps listen_addr host", HealthConfig{ListenAddr: "0.0.0.0:8090"}, 9000, "0.0.0.0:9000"},
+		{"ipv6 listen_addr", HealthConfig{ListenAddr: "[::1]:8090"}, 0, "[::1]:8090"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.health.Addr(tt.override); got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+
+	cfg := &Config{ServiceName: "svc", Health: HealthConfig{Port: 70000, ListenAddr: "8090"}}
+	verr, ok := cfg.Validate().(*ValidationError)
+	if !ok || verr.Field("health.port") == nil || verr.Field("health.listen_addr") == nil {
+		t.Errorf("Expected health.port and health.listen_addr errors, got %v", cfg.Validate())
+	}
+}
+
+func TestTagTemplates(t *testing.T) {
+	t.Setenv("YAAT_TEST_POD", "web-7f9c")
+	t.Setenv("YAAT_TEST_UNSET", "")
+	hostname, _ := os.Hostname()
+
+	path := filepath.Join(t.TempDir(), "yaat.yaml")
+	content := `service_name: svc
+tags:
+  pod: "${YAAT_TEST_POD}"
+  host: "%h"
+  mixed: "${YAAT_TEST_POD}.%h"
+  missing: "x${YAAT_TEST_UNSET}"
+  static: "price $5 and $HOME"
+metrics:
+  tags:
+    node: "${YAAT_TEST_POD}"
+  statsd:
+    tags:
+      host: "%h"
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	want := map[string]string{
+		"pod":     "web-7f9c",
+		"host":    hostname,
+		"mixed":   "web-7f9c." + hostname,
+		"missing": "x",
+		"static":  "price $5 and $HOME",
+	}
+	for key, value := range want {
+		if cfg.Tags[key] != value {
+			t.Errorf("Expected tags.%s=%q, got %q", key, value, cfg.Tags[key])
+		}
+	}
+	if cfg.Metrics.Tags["node"] != "web-7f9c" {
+		t.Errorf("Expected metrics.tags.node expanded, got %q", cfg.Metrics.Tags["node"])
+	}
+	if cfg.Metrics.StatsD.Tags["host"] != hostname {
+		t.Errorf("Expected metrics.statsd.tags.host expanded, got %q", cfg.Metrics.StatsD.Tags["host"])
+	}
+
+	// Saving keeps the templates, except for values edited since loading
+	cfg.Tags["mixed"] = "edited"
+	if err := SaveConfig(path, cfg); err != nil {
+		t.Fatalf("SaveConfig failed: %v", err)
+	}
+	saved, _ := os.ReadFile(path)
+	for _, fragment := range []string{`pod: "${YAAT_TEST_POD}"`, `host: "%h"`, `node: "${YAAT_TEST_POD}"`, `mixed: "edited"`} {
+		if !strings.Contains(string(saved), fragment) {
+			t.Errorf("Expected %s in saved config:\n%s", fragment, saved)
+		}
+	}
+	if cfg.Tags["pod"] != "web-7f9c" {
+		t.Errorf("Expected the in-memory config to stay expanded, got %q", cfg.Tags["pod"])
+	}
+}
+
+func TestDetectionAcceptsBooleans(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "yaat.yaml")
+	content := `service_name: svc
+detection:
+  cloud: false
+  kubernetes: true
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if cfg.Detection.Cloud != "off" {
+		t.Errorf("Expected detection.cloud false to mean off, got %q", cfg.Detection.Cloud)
+	}
+	if cfg.Detection.Kubernetes != "auto" {
+		t.Errorf("Expected detection.kubernetes true to mean auto, got %q", cfg.Detection.Kubernetes)
+	}
+}
+
+func TestLocalAPIListenAddrMustBeLoopback(t *testing.T) {
+	for _, addr := range []string{"127.0.0.1:8127", "localhost:8127", "[::1]:8127"} {
+		cfg := &Config{ServiceName: "svc", LocalAPI: LocalAPIConfig{Enabled: true, ListenAddr: addr}}
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("Expected %s to be accepted, got %v", addr, err)
+		}
+	}
+
+	cfg := &Config{ServiceName: "svc", LocalAPI: LocalAPIConfig{Enabled: true, ListenAddr: "0.0.0.0:8127"}}
+	verr, ok := cfg.Validate().(*ValidationError)
+	if !ok || verr.Field("local_api.listen_addr") == nil {
+		t.Errorf("Expected a local_api.listen_addr error, got %v", cfg.Validate())
+	}
+}
+
+func TestIntervalInterplay(t *testing.T) {
+	tests := []struct {
+		name      string
+		yaml      string
+		wantError string   // Field rejected by Validate
+		warnings  []string // Fields reported by Warnings
+	}{
+		{"defaults", "", "", nil},
+		{"flush interval above 10% of retention", "flush_interval: 3h\ndelivery:\n  queue_retention: 24h\n", "flush_interval", nil},
+		{"flush interval at 10% of retention", "flush_interval: 6m\ndelivery:\n  queue_retention: 1h\n", "", nil},
+		{"retention disabled", "flush_interval: 30m\ndelivery:\n  queue_retention: 0s\n", "", nil},
+		{"metrics sampled faster than flushes", "flush_interval: 1m\nmetrics:\n  enabled: true\n  interval: 10s\n", "", []string{"metrics.interval"}},
+		{"metrics disabled", "flush_interval: 1m\nmetrics:\n  interval: 10s\n", "", nil},
+		{"analytics write timeout above flush interval", "flush_interval: 2s\nanalytics:\n  enabled: true\n  write_timeout: 5s\n", "", []string{"analytics.write_timeout"}},
+		{"analytics write timeout within flush interval", "flush_interval: 10s\nanalytics:\n  enabled: true\n  write_timeout: 5s\n", "", nil},
+		{"dlq retries outlast dead-letter retention", "delivery:\n  dlq_max_retries: 10\n  dlq_retry_interval: 1h\n  dead_letter_retention: 2h\n", "", []string{"delivery.dlq_max_retries"}},
+		{"dlq retries within dead-letter retention", "delivery:\n  dlq_max_retries: 3\n  dlq_retry_interval: 15m\n", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "yaat.yaml")
+			if err := os.WriteFile(path, []byte("service_name: svc\n"+tt.yaml), 0o600); err != nil {
+				t.Fatalf("Failed to write config: %v", err)
+			}
+			cfg, err := LoadConfig(path)
+			if tt.wantError != "" {
+				verr, ok := err.(*ValidationError)
+				if !ok || verr.Field(tt.wantError) == nil {
+					t.Errorf("Expected a %s error, got %v", tt.wantError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to load config: %v", err)
+			}
+			var got []string
+			for _, w := range cfg.Warnings() {
+				got = append(got, w.Field)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.warnings, ",") {
+				t.Errorf("Expected warnings %v, got %v", tt.warnings, cfg.Warnings())
+			}
+		})
+	}
+}
+
+func TestSpanSamplingConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "yaat.yaml")
+	content := `service_name: svc
+proxy:
+  span_sample_rate: 0.2
+  errors_always: false
+logs:
+  - path: /var/log/nginx/access.log
+    format: nginx
+    span_sample_rate: 0
+    sampled_out_metric: true
+  - path: /var/log/app.log
+    format: json
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	access := cfg.Logs[0].SpanSampling
+	if !access.Enabled() || *access.SpanSampleRate != 0 || !*access.ErrorsAlways || !access.SampledOutMetric {
+		t.Errorf("Expected rate 0 with errors kept and counted, got %+v", access)
+	}
+	if cfg.Logs[1].SpanSampling.Enabled() {
+		t.Error("Expected sampling off without span_sample_rate")
+	}
+	if proxy := cfg.Proxy.SpanSampling; *proxy.SpanSampleRate != 0.2 || *proxy.ErrorsAlways {
+		t.Errorf("Expected proxy rate 0.2 sampling errors too, got %+v", proxy)
+	}
+
+	rate := 1.5
+	cfg.Logs[0].SpanSampling.SpanSampleRate = &rate
+	verr, ok := cfg.Validate().(*ValidationError)
+	if !ok || verr.Field("logs[0].span_sample_rate") == nil {
+		t.Errorf("Expected logs[0].span_sample_rate error, got %v", cfg.Validate())
+	}
+}
+
+func TestUnknownKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "yaat.yaml")
+	content := `service_name: svc
+flush_intervall: 5s
+tags:
+  anything: goes
+delivery:
+  batch_size: 100
+  retries: 3
+proxy:
+  span_sample_rate: 0.5
+  sample_rate: 0.5
+logs:
+  - path: /var/log/app.log
+    format: json
+    fromat: nginx
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	expected := []string{"delivery.retries", "flush_intervall", "logs[0].fromat", "proxy.sample_rate"}
+	if strings.Join(cfg.UnknownKeys, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected unknown keys %v, got %v", expected, cfg.UnknownKeys)
+	}
+	var warned []string
+	for _, w := range cfg.Warnings() {
+		warned = append(warned, w.Field)
+	}
+	if strings.Join(warned, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected a warning per unknown key, got %v", cfg.Warnings())
+	}
+}
+
+func TestJournaldConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "yaat.yaml")
+	content := `service_name: svc
+logs:
+  - path: shop.service
+    format: journald
+    journald:
+      include_fields: ["MY_APP_*"]
+      boot: current
+      namespace: tenants
+  - path: /var/log/app.log
+    format: json
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if j := cfg.Logs[0].Journald; len(j.IncludeFields) != 1 || j.Boot != "current" || j.Namespace != "tenants" {
+		t.Errorf("Unexpected journald options: %+v", j)
+	}
+
+	cfg.Logs[0].Journald = JournaldConfig{IncludeFields: []string{"MY_[APP"}, Boot: "last"}
+	cfg.Logs[1].Journald = JournaldConfig{Boot: "current"}
+	verr, ok := cfg.Validate().(*ValidationError)
+	if !ok {
+		t.Fatalf("Expected a ValidationError, got %v", cfg.Validate())
+	}
+	for _, field := range []string{"logs[0].journald.include_fields", "logs[0].journald.boot", "logs[1].journald"} {
+		if verr.Field(field) == nil {
+			t.Errorf("Expected %s error, got %v", field, verr)
+		}
+	}
+}
+
+func TestShutdownTimeoutMustBePositive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "yaat.yaml")
+	for _, value := range []string{"0s", "-5s"} {
+		content := "organization_id: org_123\nservice_name: svc\napi_key: yaat_key\nshutdown_timeout: " + value + "\n"
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+		_, err := LoadConfig(path)
+		if err == nil || !strings.Contains(err.Error(), "shutdown_timeout") {
+			t.Errorf("Expected a shutdown_timeout error for %s, got %v", value, err)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// mergeIntoDocument rewrites original so that it reflects cfg while keeping
+// comments, key order and keys the Config struct does not know about.
+//
+// Only values that differ from what the original document decodes to are
+// touched, so defaults filled in by applyDefaults are not written back.
+func mergeIntoDocument(original []byte, cfg *Config) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(original, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse existing config: %w", err)
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("existing config is not a YAML mapping")
+	}
+
+	var updated yaml.Node
+	if err := updated.Encode(cfg); err != nil {
+		return nil, fmt.Errorf("failed to marshal config: %w", err)
+	}
+
+	// Baseline is the original file as the struct sees it; a value only
+	// counts as changed when it differs from this.
+	var baseline *yaml.Node
+	var previous Config
+	if err := yaml.Unmarshal(original, &previous); err == nil {
+		if err := previous.applyDefaults(); err == nil {
+			var node yaml.Node
+			if err := node.Encode(&previous); err == nil {
+				baseline = &node
+			}
+		}
+	}
+
+	mergeMapping(doc.Content[0], baseline, &updated)
+
+	var out bytes.Buffer
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to marshal config: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("failed to marshal config: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+// mergeMapping applies the keys of updated that differ from baseline onto dst.
+// Keys present in baseline but dropped from updated are removed from dst.
+func mergeMapping(dst, baseline, updated *yaml.Node) {
+	for i := 0; i+1 < len(updated.Content); i += 2 {
+		key := updated.Content[i].Value
+		value := updated.Content[i+1]
+		base := mappingValue(baseline, key)
+		if base != nil && nodesEqual(base, value) {
+			continue
+		}
+
+		idx := mappingIndex(dst, key)
+		if idx < 0 {
+			dst.Content = append(dst.Content, updated.Content[i], value)
+			continue
+		}
+		dst.Content[idx+1] = mergeValue(dst.Content[idx+1], base, value)
+	}
+
+	if baseline == nil {
+		return
+	}
+	for i := 0; i+1 < len(baseline.Content); i += 2 {
+		key := baseline.Content[i].Value
+		if mappingIndex(updated, key) >= 0 {
+			continue
+		}
+		if idx := mappingIndex(dst, key); idx >= 0 {
+			dst.Content = append(dst.Content[:idx], dst.Content[idx+2:]...)
+		}
+	}
+}
+
+// mergeValue returns the node that should replace current. Nested mappings and
+// same-length sequences are merged in place so their comments survive.
+func mergeValue(current, baseline, updated *yaml.Node) *yaml.Node {
+	switch {
+	case current.Kind == yaml.MappingNode && updated.Kind == yaml.MappingNode:
+		mergeMapping(current, baseline, updated)
+		return current
+	case current.Kind == yaml.SequenceNode && updated.Kind == yaml.SequenceNode &&
+		len(current.Content) == len(updated.Content):
+		for i, item := range updated.Content {
+			var base *yaml.Node
+			if baseline != nil && baseline.Kind == yaml.SequenceNode && i < len(baseline.Content) {
+				base = baseline.Content[i]
+			}
+			if base != nil && nodesEqual(base, item) {
+				continue
+			}
+			current.Content[i] = mergeValue(current.Content[i], base, item)
+		}
+		return current
+	}
+
+	updated.HeadComment = current.HeadComment
+	updated.LineComment = current.LineComment
+	updated.FootComment = current.FootComment
+	if current.Kind == yaml.ScalarNode && updated.Kind == yaml.ScalarNode && updated.Tag == "!!str" {
+		updated.Style = current.Style
+	}
+	return updated
+}
+
+func mappingIndex(node *yaml.Node, key string) int {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return -1
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if idx := mappingIndex(node, key); idx >= 0 {
+		return node.Content[idx+1]
+	}
+	return nil
+}
+
+func nodesEqual(a, b *yaml.Node) bool {
+	if a.Kind != b.Kind || a.Value != b.Value || len(a.Content) != len(b.Content) {
+		return false
+	}
+	if a.Kind == yaml.ScalarNode && a.ShortTag() != b.ShortTag() {
+		return false
+	}
+	for i := range a.Content {
+		if !nodesEqual(a.Content[i], b.Content[i]) {
+			return false
+		}
+	}
+	return true
+}
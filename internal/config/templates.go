@@ -0,0 +1,88 @@
+package config
+
+import (
+	"os"
+	"regexp"
+	"strings"
+)
+
+// tagTemplatePattern matches ${VAR} references in tag values.
+var tagTemplatePattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// tagTemplate remembers a templated tag value so SaveConfig can write the
+// template back instead of the value it expanded to on this host.
+type tagTemplate struct {
+	template string
+	expanded string
+}
+
+// tagSections lists the tag maps that support templating, by YAML path.
+func (cfg *Config) tagSections() map[string]map[string]string {
+	return map[string]map[string]string{
+		"tags":                cfg.Tags,
+		"metrics.tags":        cfg.Metrics.Tags,
+		"metrics.statsd.tags": cfg.Metrics.StatsD.Tags,
+	}
+}
+
+// expandTagTemplates replaces ${VAR} with the environment variable (empty if
+// unset) and %h with the hostname in tag values. Other values are untouched.
+func (cfg *Config) expandTagTemplates() {
+	for section, tags := range cfg.tagSections() {
+		for key, value := range tags {
+			expanded := expandTagValue(value)
+			if expanded == value {
+				continue
+			}
+			if cfg.tagTemplates == nil {
+				cfg.tagTemplates = make(map[string]map[string]tagTemplate)
+			}
+			if cfg.tagTemplates[section] == nil {
+				cfg.tagTemplates[section] = make(map[string]tagTemplate)
+			}
+			cfg.tagTemplates[section][key] = tagTemplate{template: value, expanded: expanded}
+			tags[key] = expanded
+		}
+	}
+}
+
+func expandTagValue(value string) string {
+	if !strings.Contains(value, "${") && !strings.Contains(value, "%h") {
+		return value
+	}
+	value = tagTemplatePattern.ReplaceAllStringFunc(value, func(ref string) string {
+		return os.Getenv(ref[2 : len(ref)-1])
+	})
+	if strings.Contains(value, "%h") {
+		hostname, _ := os.Hostname()
+		value = strings.ReplaceAll(value, "%h", hostname)
+	}
+	return value
+}
+
+// withTagTemplates returns a copy of cfg whose tag maps hold the original
+// templates for values that were not changed since loading.
+func (cfg *Config) withTagTemplates() *Config {
+	if len(cfg.tagTemplates) == 0 {
+		return cfg
+	}
+	out := *cfg
+	restore := func(section string, tags map[string]string) map[string]string {
+		templates := cfg.tagTemplates[section]
+		if len(templates) == 0 || tags == nil {
+			return tags
+		}
+		restored := make(map[string]string, len(tags))
+		for key, value := range tags {
+			if t, ok := templates[key]; ok && t.expanded == value {
+				value = t.template
+			}
+			restored[key] = value
+		}
+		return restored
+	}
+	out.Tags = restore("tags", cfg.Tags)
+	out.Metrics.Tags = restore("metrics.tags", cfg.Metrics.Tags)
+	out.Metrics.StatsD.Tags = restore("metrics.statsd.tags", cfg.Metrics.StatsD.Tags)
+	return &out
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// unknownKeys returns the dotted paths of keys in the YAML document that no
+// field of Config takes, such as misspelled options, which yaml.Unmarshal
+// silently ignores. Keys under maps (tags, service_keys, ...) are free-form
+// and never reported.
+func unknownKeys(data []byte) []string {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil || len(doc.Content) == 0 {
+		return nil
+	}
+	var unknown []string
+	collectUnknown(doc.Content[0], reflect.TypeOf(Config{}), "", &unknown)
+	sort.Strings(unknown)
+	return unknown
+}
+
+func collectUnknown(node *yaml.Node, typ reflect.Type, path string, unknown *[]string) {
+	for node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+
+	switch {
+	case typ.Kind() == reflect.Struct && node.Kind == yaml.MappingNode:
+		fields := yamlFields(typ)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			if key == "<<" {
+				collectUnknown(node.Content[i+1], typ, path, unknown)
+				continue
+			}
+			fieldType, ok := fields[key]
+			if !ok {
+				*unknown = append(*unknown, joinKey(path, key))
+				continue
+			}
+			collectUnknown(node.Content[i+1], fieldType, joinKey(path, key), unknown)
+		}
+	case typ.Kind() == reflect.Map && node.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			collectUnknown(node.Content[i+1], typ.Elem(), joinKey(path, node.Content[i].Value), unknown)
+		}
+	case typ.Kind() == reflect.Slice && node.Kind == yaml.SequenceNode:
+		for i, item := range node.Content {
+			collectUnknown(item, typ.Elem(), path+"["+strconv.Itoa(i)+"]", unknown)
+		}
+	}
+}
+
+// yamlFields maps the YAML keys of a struct to their field types, following
+// inlined structs as yaml.v3 does.
+func yamlFields(typ reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		tag := field.Tag.Get("yaml")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if strings.Contains(opts, "inline") {
+			for key, fieldType := range yamlFields(field.Type) {
+				fields[key] = fieldType
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+func joinKey(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,250 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/logging"
+	"github.com/yaat-app/sidecar/internal/paths"
+)
+
+var logger = logging.New("Control")
+
+// FlushResult reports what an out-of-band flush delivered.
+type FlushResult struct {
+	Flushed int    `json:"flushed"` // Events taken from the in-memory buffer
+	Drained int    `json:"drained"` // Events delivered from the persistent queue
+	Error   string `json:"error,omitempty"`
+}
+
+// FlushFunc performs a flush on behalf of a control request.
+type FlushFunc func(ctx context.Context) FlushResult
+
+// ReloadKeyFunc rereads the API key from the configuration file and starts
+// using it.
+type ReloadKeyFunc func() error
+
+// reloadKeyResult is the response to a key reload request.
+type reloadKeyResult struct {
+	Error string `json:"error,omitempty"`
+}
+
+// Server exposes daemon control commands on a Unix domain socket. Access is
+// restricted by file permissions, so only the owning user can connect.
+type Server struct {
+	path      string
+	flush     FlushFunc
+	reloadKey ReloadKeyFunc
+	events    *EventStream
+	listener  net.Listener
+	server    *http.Server
+	stopping  chan struct{} // Closed by Stop to end event streams
+}
+
+// SocketPath returns the control socket of the instance laid out by layout,
+// honouring YAAT_CONTROL_SOCKET.
+func SocketPath(layout paths.Layout) string {
+	if override := os.Getenv("YAAT_CONTROL_SOCKET"); override != "" {
+		return override
+	}
+	return layout.ControlSock
+}
+
+// New creates a control server listening on path.
+func New(path string, flush FlushFunc) *Server {
+	return &Server{path: path, flush: flush}
+}
+
+// SetReloadKey enables key reload requests from --rotate-key. Call before Start.
+func (s *Server) SetReloadKey(reload ReloadKeyFunc) {
+	s.reloadKey = reload
+}
+
+// Start binds the socket and serves requests in the background.
+func (s *Server) Start() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return fmt.Errorf("failed to create control directory: %w", err)
+	}
+
+	// A socket left behind by a crashed process would make Listen fail
+	if err := removeStaleSocket(s.path); err != nil {
+		return err
+	}
+
+	listener, err := listenPrivate(s.path)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/control/flush", s.handleFlush)
+	mux.HandleFunc("/control/reload-key", s.handleReloadKey)
+	mux.HandleFunc("/control/events", s.handleEvents)
+
+	s.listener = listener
+	s.stopping = make(chan struct{})
+	s.server = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Errorf("Server error: %v", err)
+		}
+	}()
+	return nil
+}
+
+// Stop closes the listener and removes the socket file.
+func (s *Server) Stop() {
+	if s.server == nil {
+		return
+	}
+	close(s.stopping)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	s.server.Shutdown(ctx)
+	os.Remove(s.path)
+}
+
+func (s *Server) handleFlush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	result := s.flush(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	if result.Error != "" {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+func (s *Server) handleReloadKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var result reloadKeyResult
+	if s.reloadKey == nil {
+		result.Error = "this sidecar cannot reload its API key; restart it"
+	} else if err := s.reloadKey(); err != nil {
+		result.Error = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if result.Error != "" {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+// RequestFlush asks the daemon listening on path to flush its buffer and
+// drain the persistent queue immediately.
+func RequestFlush(path string, timeout time.Duration) (FlushResult, error) {
+	resp, err := socketClient(path, timeout).Post("http://yaat-sidecar/control/flush", "application/json", nil)
+	if err != nil {
+		return FlushResult{}, fmt.Errorf("failed to reach sidecar at %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	var result FlushResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return FlushResult{}, fmt.Errorf("invalid control response (HTTP %d): %w", resp.StatusCode, err)
+	}
+	if result.Error != "" {
+		return result, errors.New(result.Error)
+	}
+	return result, nil
+}
+
+// RequestKeyReload asks the daemon listening on path to reread its API key
+// from the configuration file.
+func RequestKeyReload(path string, timeout time.Duration) error {
+	resp, err := socketClient(path, timeout).Post("http://yaat-sidecar/control/reload-key", "application/json", nil)
+	if err != nil {
+		return fmt.Errorf("failed to reach sidecar at %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	var result reloadKeyResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("invalid control response (HTTP %d): %w", resp.StatusCode, err)
+	}
+	if result.Error != "" {
+		return errors.New(result.Error)
+	}
+	return nil
+}
+
+// socketClient returns an HTTP client that connects to the control socket at path.
+func socketClient(path string, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", path)
+			},
+		},
+	}
+}
+
+// listenPrivate binds a socket that only the owner can connect to, at no
+// point reachable with wider permissions: it is bound and restricted inside
+// a fresh 0700 directory, then renamed to path.
+func listenPrivate(path string) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".control-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create control directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, "s")
+	listener, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+	// Stop removes the socket under its final name
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, 0o600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to restrict control socket: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+	return listener, nil
+}
+
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to inspect %s: %w", path, err)
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	// Refuse to steal the socket from a live daemon
+	if conn, err := net.DialTimeout("unix", path, 500*time.Millisecond); err == nil {
+		conn.Close()
+		return fmt.Errorf("another sidecar is already listening on %s", path)
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package control
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+	"github.com/yaat-app/sidecar/internal/paths"
+)
+
+func shortSocketPath(t *testing.T) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "yaat-ctl")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "control.sock")
+}
+
+func TestRequestFlushReportsError(t *testing.T) {
+	path := shortSocketPath(t)
+	srv := New(path, func(ctx context.Context) FlushResult {
+		return FlushResult{Flushed: 4, Error: "send failed"}
+	})
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer srv.Stop()
+
+	result, err := RequestFlush(path, time.Second)
+	if err == nil || err.Error() != "send failed" {
+		t.Errorf("Expected send failed error, got %v", err)
+	}
+	if result.Flushed != 4 {
+		t.Errorf("Expected partial result to be returned, got %+v", result)
+	}
+}
+
+func TestStartRestrictsSocket(t *testing.T) {
+	path := shortSocketPath(t)
+	srv := New(path, func(ctx context.Context) FlushResult { return FlushResult{} })
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("Expected socket mode 0600, got %o", perm)
+	}
+	if _, err := RequestFlush(path, time.Second); err != nil {
+		t.Errorf("Expected the renamed socket to accept requests, got %v", err)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("Expected only the socket in its directory, found %d entries", len(entries))
+	}
+
+	srv.Stop()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected Stop to remove the socket, got %v", err)
+	}
+}
+
+func TestSocketPathPerInstance(t *testing.T) {
+	t.Setenv("YAAT_CONTROL_SOCKET", "")
+	resolver := paths.Resolver{Home: "/home/test"}
+	if got := SocketPath(resolver.HomeLayout(paths.DefaultInstance)); got != "/home/test/.yaat/control.sock" {
+		t.Errorf("Expected the default instance socket, got %s", got)
+	}
+	if got := SocketPath(resolver.HomeLayout("api")); got != "/home/test/.yaat/api/control.sock" {
+		t.Errorf("Expected the api instance socket, got %s", got)
+	}
+	t.Setenv("YAAT_CONTROL_SOCKET", "/tmp/override.sock")
+	if got := SocketPath(resolver.HomeLayout("api")); got != "/tmp/override.sock" {
+		t.Errorf("Expected YAAT_CONTROL_SOCKET to win, got %s", got)
+	}
+}
+
+func TestStartReplacesStaleSocket(t *testing.T) {
+	path := shortSocketPath(t)
+
+	// Simulate a socket left behind by a crashed daemon
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	listener.Close()
+
+	srv := New(path, func(ctx context.Context) FlushResult { return FlushResult{} })
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Expected stale socket to be replaced, got %v", err)
+	}
+	defer srv.Stop()
+
+	second := New(path, func(ctx context.Context) FlushResult { return FlushResult{} })
+	if err := second.Start(); err == nil {
+		second.Stop()
+		t.Error("Expected second server to refuse a live socket")
+	}
+}
+
+func TestRequestKeyReload(t *testing.T) {
+	path := shortSocketPath(t)
+	srv := New(path, func(ctx context.Context) FlushResult { return FlushResult{} })
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if err := RequestKeyReload(path, time.Second); err == nil {
+		t.Error("Expected an error from a server without key reload")
+	}
+	srv.Stop()
+
+	reloads := 0
+	srv = New(path, func(ctx context.Context) FlushResult { return FlushResult{} })
+	srv.SetReloadKey(func() error {
+		reloads++
+		return nil
+	})
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer srv.Stop()
+
+	if err := RequestKeyReload(path, time.Second); err != nil {
+		t.Errorf("Expected reload to succeed, got %v", err)
+	}
+	if reloads != 1 {
+		t.Errorf("Expected one reload, got %d", reloads)
+	}
+}
+
+func TestStreamEvents(t *testing.T) {
+	path := shortSocketPath(t)
+	stream := NewEventStream()
+	srv := New(path, func(ctx context.Context) FlushResult { return FlushResult{} })
+	srv.SetEventStream(stream)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer srv.Stop()
+
+	// Nothing is encoded without a client
+	stream.Publish(buffer.Event{"message": "before"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	reader, writer := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- StreamEvents(ctx, path, writer)
+		writer.Close()
+	}()
+
+	// Publish until the client is subscribed and the first line arrives
+	lines := bufio.NewScanner(reader)
+	go func() {
+		for i := 0; ctx.Err() == nil; i++ {
+			stream.mu.Lock()
+			subscribed := len(stream.subscribers) > 0
+			stream.mu.Unlock()
+			if subscribed {
+				stream.Publish(buffer.Event{"message": "first", "tags": map[string]string{"k": "v"}})
+				stream.Publish(buffer.Event{"message": "second"})
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+
+	var got []map[string]interface{}
+	for len(got) < 2 && lines.Scan() {
+		var event map[string]interface{}
+		if err := json.Unmarshal(lines.Bytes(), &event); err != nil {
+			t.Fatalf("Expected one JSON object per line, got %q: %v", lines.Text(), err)
+		}
+		got = append(got, event)
+	}
+	if len(got) != 2 || got[0]["message"] != "first" || got[1]["message"] != "second" {
+		t.Errorf("Expected first and second in order, got %v", got)
+	}
+
+	cancel()
+	go io.Copy(io.Discard, reader)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected a clean stop on cancel, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("StreamEvents did not return after cancel")
+	}
+}
+
+func TestStreamEventsWithoutStream(t *testing.T) {
+	path := shortSocketPath(t)
+	srv := New(path, func(ctx context.Context) FlushResult { return FlushResult{} })
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer srv.Stop()
+
+	if err := StreamEvents(context.Background(), path, io.Discard); err == nil {
+		t.Error("Expected an error from a server without an event stream")
+	}
+}
@@ -0,0 +1,165 @@
+package control
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+)
+
+// subscriberBacklog is how many encoded events a client may fall behind by
+// before further events are dropped for it.
+const subscriberBacklog = 1024
+
+// EventStream fans buffered events out to --events-tail clients. Install
+// Publish with buffer.SetObserver; events are only encoded while a client is
+// connected.
+type EventStream struct {
+	mu          sync.Mutex
+	subscribers map[*subscriber]struct{}
+}
+
+type subscriber struct {
+	lines   chan []byte
+	dropped int64 // Guarded by EventStream.mu
+}
+
+// NewEventStream returns a stream without clients.
+func NewEventStream() *EventStream {
+	return &EventStream{subscribers: make(map[*subscriber]struct{})}
+}
+
+// Publish sends event to every connected client, dropping it for clients
+// that are too far behind rather than slowing the caller down.
+func (s *EventStream) Publish(event buffer.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.subscribers) == 0 {
+		return
+	}
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+	for sub := range s.subscribers {
+		select {
+		case sub.lines <- line:
+		default:
+			sub.dropped++
+		}
+	}
+}
+
+func (s *EventStream) subscribe() *subscriber {
+	sub := &subscriber{lines: make(chan []byte, subscriberBacklog)}
+	s.mu.Lock()
+	s.subscribers[sub] = struct{}{}
+	s.mu.Unlock()
+	return sub
+}
+
+// unsubscribe removes sub and returns how many events it missed.
+func (s *EventStream) unsubscribe(sub *subscriber) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subscribers, sub)
+	return sub.dropped
+}
+
+// SetEventStream enables /control/events for --events-tail. Call before Start.
+func (s *Server) SetEventStream(stream *EventStream) {
+	s.events = stream
+}
+
+// handleEvents streams events as newline-delimited JSON, one event per line
+// as it is buffered, until the client disconnects.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.events == nil {
+		http.Error(w, "this sidecar does not stream events", http.StatusNotFound)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	sub := s.events.subscribe()
+	defer func() {
+		if dropped := s.events.unsubscribe(sub); dropped > 0 {
+			logger.Warnf("Event stream client fell behind; %d events were not streamed to it", dropped)
+		}
+	}()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.stopping:
+			return
+		case line := <-sub.lines:
+			if _, err := w.Write(line); err != nil {
+				return
+			}
+			// Send whatever else is ready in the same write
+			for pending := len(sub.lines); pending > 0; pending-- {
+				if _, err := w.Write(<-sub.lines); err != nil {
+					return
+				}
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// StreamEvents copies the events the daemon listening on path buffers to
+// out, one JSON object per line, until ctx is done or the daemon stops.
+func StreamEvents(ctx context.Context, path string, out io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://yaat-sidecar/control/events", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := socketClient(path, 0).Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return fmt.Errorf("failed to reach sidecar at %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("event stream refused (HTTP %d): %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			if _, writeErr := out.Write(line); writeErr != nil {
+				return writeErr
+			}
+		}
+		if err != nil {
+			if ctx.Err() != nil || err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("event stream interrupted: %w", err)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// Package correlate joins log lines to the proxy span of the request that
+// wrote them. The proxy records each request's X-Request-ID with its trace
+// and span IDs; tailers look up the request ID found in a log event and
+// stamp the same trace onto it.
+package correlate
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+)
+
+// Defaults used when correlation.max_entries or correlation.ttl are not set.
+const (
+	DefaultMaxEntries = 10000
+	DefaultTTL        = 5 * time.Minute
+)
+
+// RequestIDTags are the log event tags that carry a request ID, in lookup order.
+var RequestIDTags = []string{"request_id", "x_request_id"}
+
+// Span identifies the proxy span recorded for a request.
+type Span struct {
+	TraceID string
+	SpanID  string
+}
+
+type entry struct {
+	requestID string
+	span      Span
+	added     time.Time
+}
+
+// Cache maps recent request IDs to their proxy spans. It holds at most
+// maxEntries, evicting the least recently recorded, and forgets entries
+// older than ttl. It is safe for concurrent use.
+type Cache struct {
+	mu         sync.Mutex
+	maxEntries int
+	ttl        time.Duration
+	entries    map[string]*list.Element
+	order      *list.List // Front is the most recently recorded
+	now        func() time.Time
+}
+
+// New creates a Cache. Non-positive limits use the defaults.
+func New(maxEntries int, ttl time.Duration) *Cache {
+	if maxEntries <= 0 {
+		maxEntries = DefaultMaxEntries
+	}
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &Cache{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+		now:        time.Now,
+	}
+}
+
+// Record remembers the span for requestID. Empty IDs are ignored.
+func (c *Cache) Record(requestID string, span Span) {
+	if requestID == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[requestID]; ok {
+		c.order.Remove(el)
+	}
+	c.entries[requestID] = c.order.PushFront(&entry{requestID: requestID, span: span, added: c.now()})
+	for c.order.Len() > c.maxEntries {
+		c.removeLocked(c.order.Back())
+	}
+}
+
+// Lookup returns the span recorded for requestID within the TTL.
+func (c *Cache) Lookup(requestID string) (Span, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[requestID]
+	if !ok {
+		return Span{}, false
+	}
+	e := el.Value.(*entry)
+	if c.now().Sub(e.added) > c.ttl {
+		c.removeLocked(el)
+		return Span{}, false
+	}
+	return e.span, true
+}
+
+// Len returns the number of cached request IDs, expired ones included.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *Cache) removeLocked(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*entry).requestID)
+}
+
+// Stamp sets trace_id on a log event from the span recorded for its request
+// ID tag, with the proxy span as parent_span_id. Events that already carry a
+// trace_id are left alone. It reports whether the event was stamped.
+func (c *Cache) Stamp(evt buffer.Event) bool {
+	if c == nil || evt == nil {
+		return false
+	}
+	if traceID, _ := evt["trace_id"].(string); traceID != "" {
+		return false
+	}
+	tags, _ := evt["tags"].(map[string]string)
+	for _, key := range RequestIDTags {
+		requestID := tags[key]
+		if requestID == "" {
+			continue
+		}
+		span, ok := c.Lookup(requestID)
+		if !ok {
+			return false
+		}
+		evt["trace_id"] = span.TraceID
+		evt["parent_span_id"] = span.SpanID
+		return true
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package correlate
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+)
+
+func TestCacheEvictsOldestAndExpires(t *testing.T) {
+	c := New(2, time.Minute)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	for i := 1; i <= 3; i++ {
+		c.Record(fmt.Sprintf("req-%d", i), Span{TraceID: fmt.Sprintf("trace-%d", i)})
+	}
+	if _, ok := c.Lookup("req-1"); ok {
+		t.Error("Expected the oldest request to be evicted at max_entries")
+	}
+	if span, ok := c.Lookup("req-3"); !ok || span.TraceID != "trace-3" {
+		t.Errorf("Expected req-3 -> trace-3, got %+v, %t", span, ok)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, ok := c.Lookup("req-3"); ok {
+		t.Error("Expected entries older than the TTL to expire")
+	}
+	if c.Len() != 1 {
+		t.Errorf("Expected the expired entry to be removed, got %d entries", c.Len())
+	}
+
+	c.Record("", Span{TraceID: "ignored"})
+	if c.Len() != 1 {
+		t.Errorf("Expected empty request IDs to be ignored, got %d entries", c.Len())
+	}
+}
+
+func TestStamp(t *testing.T) {
+	c := New(0, 0)
+	c.Record("abc-123", Span{TraceID: "trace-1", SpanID: "span-1"})
+
+	evt := buffer.Event{"message": "charged card", "tags": map[string]string{"x_request_id": "abc-123"}}
+	if !c.Stamp(evt) {
+		t.Fatal("Expected the event to be stamped")
+	}
+	if evt["trace_id"] != "trace-1" || evt["parent_span_id"] != "span-1" {
+		t.Errorf("Expected trace-1/span-1, got %v/%v", evt["trace_id"], evt["parent_span_id"])
+	}
+
+	own := buffer.Event{"trace_id": "from-app", "tags": map[string]string{"request_id": "abc-123"}}
+	if c.Stamp(own) || own["trace_id"] != "from-app" {
+		t.Errorf("Expected an existing trace_id to be kept, got %v", own["trace_id"])
+	}
+
+	unknown := buffer.Event{"tags": map[string]string{"request_id": "other"}}
+	if c.Stamp(unknown) {
+		t.Error("Expected no stamp for an unknown request ID")
+	}
+
+	var disabled *Cache
+	if disabled.Stamp(evt) {
+		t.Error("Expected a nil cache to stamp nothing")
+	}
+}
@@ -10,15 +10,21 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/yaat-app/sidecar/internal/config"
+	"github.com/yaat-app/sidecar/internal/paths"
 )
 
-// Start starts the sidecar as a daemon process
-func Start(configPath, logFilePath, pidPath string, verbose bool) error {
+// Start starts the sidecar as a daemon process, logging to layout.LogFile
+// and recording its PID in layout.PIDFile.
+func Start(configPath string, layout paths.Layout, verbose bool, extraArgs ...string) error {
 	// Check if already running
-	if IsRunning(pidPath) {
-		return fmt.Errorf("sidecar is already running (PID file exists: %s)", pidPath)
+	if IsRunning(layout) {
+		return fmt.Errorf("sidecar is already running (PID file exists: %s)", GetPidPath(layout))
+	}
+	if err := layout.Ensure(); err != nil {
+		return fmt.Errorf("failed to prepare directories: %w", err)
 	}
 
 	// Get current executable path
@@ -32,22 +38,8 @@ func Start(configPath, logFilePath, pidPath string, verbose bool) error {
 	if verbose {
 		args = append(args, "--verbose")
 	}
-
-	// Determine log file path
-	logPath := logFilePath
-	if logPath == "" {
-		// Use default log path if not specified
-		logPath = "/var/log/yaat-sidecar.log"
-		// Create log directory if it doesn't exist
-		logDir := filepath.Dir(logPath)
-		if err := os.MkdirAll(logDir, 0755); err != nil && !os.IsPermission(err) {
-			// If we can't create /var/log, use home directory
-			home, _ := os.UserHomeDir()
-			logPath = filepath.Join(home, ".yaat", "sidecar.log")
-			os.MkdirAll(filepath.Dir(logPath), 0755)
-		}
-	}
-	args = append(args, "--log-file", logPath)
+	args = append(args, "--log-file", layout.LogFile)
+	args = append(args, extraArgs...)
 
 	// Create the command
 	cmd := exec.Command(executable, args...)
@@ -62,17 +54,10 @@ func Start(configPath, logFilePath, pidPath string, verbose bool) error {
 		return fmt.Errorf("failed to start daemon: %w", err)
 	}
 
-	// Write PID file
-	// Create PID directory if needed
-	os.MkdirAll(filepath.Dir(pidPath), 0755)
-	if err := writePidFile(pidPath, cmd.Process.Pid); err != nil {
-		// Try user home directory if /var/run is not writable
-		home, _ := os.UserHomeDir()
-		pidPath = filepath.Join(home, ".yaat", "sidecar.pid")
-		os.MkdirAll(filepath.Dir(pidPath), 0755)
-		if err := writePidFile(pidPath, cmd.Process.Pid); err != nil {
-			return fmt.Errorf("failed to write PID file: %w", err)
-		}
+	// Without a PID file the daemon could not be stopped; do not leave it running
+	if err := writePidFile(layout.PIDFile, cmd.Process.Pid); err != nil {
+		cmd.Process.Kill()
+		return fmt.Errorf("failed to write PID file: %w", err)
 	}
 
 	// Release the process so it runs independently
@@ -81,9 +66,12 @@ func Start(configPath, logFilePath, pidPath string, verbose bool) error {
 	return nil
 }
 
-// Stop stops the daemon process
-func Stop(pidPath string) error {
-	pid, actualPidPath, err := readPID(pidPath)
+// Stop sends SIGTERM to the daemon and waits up to timeout for it to exit,
+// so a daemon started right after does not run next to one still draining
+// its queue. The PID file is removed once the process is gone; on timeout
+// it is kept and an error is returned.
+func Stop(layout paths.Layout, timeout time.Duration) error {
+	pid, actualPidPath, err := readPID(layout)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			return fmt.Errorf("sidecar is not running")
@@ -99,18 +87,43 @@ func Stop(pidPath string) error {
 
 	// Send SIGTERM
 	if err := process.Signal(syscall.SIGTERM); err != nil {
+		if errors.Is(err, os.ErrProcessDone) || errors.Is(err, syscall.ESRCH) {
+			os.Remove(actualPidPath)
+			return fmt.Errorf("sidecar is not running")
+		}
 		return fmt.Errorf("failed to stop process: %w", err)
 	}
 
+	if !waitForExit(process, timeout) {
+		return fmt.Errorf("sidecar (PID %d) did not exit within %s", pid, timeout)
+	}
+
 	// Remove PID file
 	os.Remove(actualPidPath)
 
 	return nil
 }
 
+// stopPollInterval is how often Stop checks whether the daemon has exited.
+const stopPollInterval = 100 * time.Millisecond
+
+// waitForExit polls process until it is gone, for at most timeout.
+func waitForExit(process *os.Process, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if process.Signal(syscall.Signal(0)) != nil {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(stopPollInterval)
+	}
+}
+
 // IsRunning checks if the daemon is currently running
-func IsRunning(pidPath string) bool {
-	pid, _, err := readPID(pidPath)
+func IsRunning(layout paths.Layout) bool {
+	pid, _, err := readPID(layout)
 	if err != nil {
 		return false
 	}
@@ -126,23 +139,32 @@ func IsRunning(pidPath string) bool {
 	return err == nil
 }
 
+// uninstallStopTimeout bounds the wait for the daemon during uninstall; it
+// covers the default shutdown_timeout.
+const uninstallStopTimeout = config.DefaultShutdownTimeout + 10*time.Second
+
 // Uninstall removes YAAT Sidecar from the system
 // Returns: (warnings, error)
 // warnings: list of non-fatal issues encountered
 // error: fatal error that prevented uninstallation (nil if successful)
-func Uninstall() ([]string, error) {
+func Uninstall(opts UninstallOptions) ([]string, error) {
+	executable, _ := os.Executable()
+	if opts.DryRun {
+		printUninstallPlan(planUninstall(opts, "", executable), opts)
+		return nil, nil
+	}
+
 	fmt.Println("🧹  Uninstalling YAAT Sidecar...")
 	fmt.Println()
 
-	executable, _ := os.Executable()
 	var warnings []string
 
 	// Step 1: stop any running processes
 	fmt.Print("→ Stopping running processes... ")
 	stopped := false
-	defaultPidPath := "/var/run/yaat-sidecar.pid"
-	if IsRunning(defaultPidPath) {
-		if err := Stop(defaultPidPath); err != nil {
+	layout := paths.Detect().Resolve(paths.DefaultInstance)
+	if IsRunning(layout) {
+		if err := Stop(layout, uninstallStopTimeout); err != nil {
 			warnings = append(warnings, fmt.Sprintf("stop daemon: %v", err))
 		} else {
 			stopped = true
@@ -166,21 +188,10 @@ func Uninstall() ([]string, error) {
 	// Step 2: remove systemd service (Linux-only)
 	warnings = append(warnings, removeSystemdUnits()...)
 
-	// Step 3: remove PID files
-	warnings = append(warnings, removePathsGroup("PID files", possiblePidFiles(), true)...)
-
-	// Step 4: remove log files
-	warnings = append(warnings, removePathsGroup("log files", possibleLogFiles(), true)...)
+	// Step 3: remove PID, log and config files, then data directories
+	warnings = append(warnings, removePlanned(planUninstall(opts, "", executable), opts)...)
 
-	// Step 5: remove configuration files
-	warnings = append(warnings, removePathsGroup("configuration files", possibleConfigFiles(), true)...)
-
-	// Step 6: remove state and queue directories
-	warnings = append(warnings, removePathsGroup("state files", possibleStateFiles(), false)...)
-	warnings = append(warnings, removeDirectoriesGroup("queue directories", possibleQueueDirs())...)
-	warnings = append(warnings, removeDirectoriesGroup("state directories", possibleStateDirs())...)
-
-	// Step 7: remove binary and symlinks
+	// Step 4: remove binary and symlinks
 	warnings = append(warnings, removeBinaryAndLinks(executable)...)
 
 	fmt.Println()
@@ -250,37 +261,15 @@ func removeSystemdUnits() []string {
 	fmt.Print("→ Removing systemd unit... ")
 	var warnings []string
 
-	servicePaths := []struct {
-		path string
-		user bool
-	}{
-		{"/etc/systemd/system/yaat-sidecar.service", false},
-		{"/lib/systemd/system/yaat-sidecar.service", false},
-		{"/usr/lib/systemd/system/yaat-sidecar.service", false},
-	}
-
-	if home, err := os.UserHomeDir(); err == nil && home != "" {
-		servicePaths = append(servicePaths,
-			struct {
-				path string
-				user bool
-			}{filepath.Join(home, ".config", "systemd", "user", "yaat-sidecar.service"), true},
-			struct {
-				path string
-				user bool
-			}{filepath.Join(home, ".local", "share", "systemd", "user", "yaat-sidecar.service"), true},
-		)
-	}
-
 	found := false
-	for _, candidate := range servicePaths {
-		if _, err := os.Stat(candidate.path); err != nil {
+	for _, path := range possibleSystemdUnits() {
+		if _, err := os.Stat(path); err != nil {
 			continue
 		}
 		found = true
 
 		systemctlArgs := []string{"stop", "yaat-sidecar"}
-		if candidate.user {
+		if strings.Contains(path, filepath.Join("systemd", "user")) {
 			systemctlArgs = append([]string{"--user"}, systemctlArgs...)
 		}
 		exec.Command("systemctl", systemctlArgs...).Run()
@@ -288,11 +277,11 @@ func removeSystemdUnits() []string {
 		systemctlArgs[1] = "disable"
 		exec.Command("systemctl", systemctlArgs...).Run()
 
-		if err := os.Remove(candidate.path); err != nil {
+		if err := os.Remove(path); err != nil {
 			if os.IsPermission(err) {
-				warnings = append(warnings, fmt.Sprintf("remove systemd unit %s: permission denied", candidate.path))
+				warnings = append(warnings, fmt.Sprintf("remove systemd unit %s: permission denied", path))
 			} else {
-				warnings = append(warnings, fmt.Sprintf("remove systemd unit %s: %v", candidate.path, err))
+				warnings = append(warnings, fmt.Sprintf("remove systemd unit %s: %v", path, err))
 			}
 		}
 	}
@@ -317,98 +306,6 @@ func removeSystemdUnits() []string {
 	return warnings
 }
 
-func removePathsGroup(label string, paths []string, removeParents bool) []string {
-	fmt.Printf("→ Removing %s... ", strings.ToLower(label))
-	var warnings []string
-
-	seen := map[string]struct{}{}
-	removed := 0
-
-	for _, p := range paths {
-		if p == "" {
-			continue
-		}
-		if _, ok := seen[p]; ok {
-			continue
-		}
-		seen[p] = struct{}{}
-
-		if err := os.Remove(p); err != nil {
-			if os.IsNotExist(err) {
-				continue
-			}
-			warnings = append(warnings, fmt.Sprintf("remove %s %s: %v", label, p, err))
-			continue
-		}
-
-		removed++
-		if removeParents {
-			removeParentDirIfEmpty(filepath.Dir(p))
-		}
-	}
-
-	if removed > 0 {
-		fmt.Printf("✓ (removed %d)\n", removed)
-	} else {
-		fmt.Println("(none found)")
-	}
-
-	return warnings
-}
-
-func removeDirectoriesGroup(label string, dirs []string) []string {
-	fmt.Printf("→ Removing %s... ", strings.ToLower(label))
-	var warnings []string
-
-	seen := map[string]struct{}{}
-	removed := 0
-
-	for _, dir := range dirs {
-		if dir == "" {
-			continue
-		}
-		if _, ok := seen[dir]; ok {
-			continue
-		}
-		seen[dir] = struct{}{}
-
-		// Check if directory exists
-		info, err := os.Stat(dir)
-		if os.IsNotExist(err) {
-			continue
-		}
-		if err != nil {
-			warnings = append(warnings, fmt.Sprintf("stat %s %s: %v", label, dir, err))
-			continue
-		}
-
-		// Skip if not a directory
-		if !info.IsDir() {
-			continue
-		}
-
-		// Remove directory recursively
-		if err := os.RemoveAll(dir); err != nil {
-			if os.IsPermission(err) {
-				warnings = append(warnings, fmt.Sprintf("remove %s %s: permission denied", label, dir))
-			} else {
-				warnings = append(warnings, fmt.Sprintf("remove %s %s: %v", label, dir, err))
-			}
-			continue
-		}
-
-		removed++
-	}
-
-	if removed > 0 {
-		fmt.Printf("✓ (removed %d)\n", removed)
-	} else {
-		fmt.Println("(none found)")
-	}
-
-	return warnings
-}
-
 func removeBinaryAndLinks(executable string) []string {
 	fmt.Print("→ Removing binary... ")
 	var warnings []string
@@ -529,21 +426,57 @@ func removeIfSymlinkTo(path, target string) (bool, error) {
 	return true, nil
 }
 
-func possiblePidFiles() []string {
-	paths := []string{"/var/run/yaat-sidecar.pid"}
+func possibleSystemdUnits() []string {
+	paths := []string{
+		"/etc/systemd/system/yaat-sidecar.service",
+		"/lib/systemd/system/yaat-sidecar.service",
+		"/usr/lib/systemd/system/yaat-sidecar.service",
+	}
 	if home, err := os.UserHomeDir(); err == nil && home != "" {
-		paths = append(paths, filepath.Join(home, ".yaat", "sidecar.pid"))
+		paths = append(paths,
+			filepath.Join(home, ".config", "systemd", "user", "yaat-sidecar.service"),
+			filepath.Join(home, ".local", "share", "systemd", "user", "yaat-sidecar.service"),
+		)
 	}
 	return paths
 }
 
+// defaultLayouts returns the system and home layouts of the default
+// instance; the home one only when the home directory is known.
+func defaultLayouts() []paths.Layout {
+	resolver := paths.Detect()
+	if resolver.Home == "" {
+		return []paths.Layout{resolver.SystemLayout(paths.DefaultInstance)}
+	}
+	return resolver.Candidates(paths.DefaultInstance)
+}
+
+// homeLayout returns the default instance's home layout, or false when the
+// home directory is unknown.
+func homeLayout() (paths.Layout, bool) {
+	resolver := paths.Detect()
+	return resolver.HomeLayout(paths.DefaultInstance), resolver.Home != ""
+}
+
+func possiblePidFiles() []string {
+	var files []string
+	for _, layout := range defaultLayouts() {
+		files = append(files, layout.PIDFile)
+	}
+	return files
+}
+
 func possibleLogFiles() []string {
-	paths := []string{"/var/log/yaat-sidecar.log", "/var/log/yaat/sidecar.log"}
+	var files []string
+	for _, layout := range defaultLayouts() {
+		files = append(files, layout.LogFile)
+	}
+	// Locations used by older releases
+	files = append(files, "/var/log/yaat/sidecar.log")
 	if home, err := os.UserHomeDir(); err == nil && home != "" {
-		paths = append(paths, filepath.Join(home, ".yaat", "sidecar.log"))
-		paths = append(paths, filepath.Join(home, "Library", "Logs", "yaat-sidecar.log"))
+		files = append(files, filepath.Join(home, "Library", "Logs", "yaat-sidecar.log"))
 	}
-	return paths
+	return files
 }
 
 func possibleConfigFiles() []string {
@@ -589,40 +522,43 @@ func possibleBinaryLinks() []string {
 }
 
 func possibleStateFiles() []string {
-	var paths []string
-	if home, err := os.UserHomeDir(); err == nil && home != "" {
-		paths = append(paths, filepath.Join(home, ".yaat", "state.json"))
+	if layout, ok := homeLayout(); ok {
+		return []string{layout.StateFile}
 	}
-	return paths
+	return nil
+}
+
+func possibleAnalyticsFiles() []string {
+	if layout, ok := homeLayout(); ok {
+		return []string{layout.AnalyticsDB, layout.AnalyticsDB + ".wal"}
+	}
+	return nil
 }
 
 func possibleQueueDirs() []string {
-	var paths []string
-	if home, err := os.UserHomeDir(); err == nil && home != "" {
-		paths = append(paths, filepath.Join(home, ".yaat", "queue"))
+	var dirs []string
+	if layout, ok := homeLayout(); ok {
+		dirs = append(dirs, layout.QueueDir)
 	}
 	// Check for custom queue directory from environment
 	if queueDir := os.Getenv("YAAT_QUEUE_DIR"); queueDir != "" {
-		paths = append(paths, queueDir)
+		dirs = append(dirs, queueDir)
 	}
-	return paths
+	return dirs
 }
 
-func possibleStateDirs() []string {
-	var paths []string
-
-	// Linux state directories
-	paths = append(paths,
-		"/var/lib/yaat",
-		"/var/log/yaat",
-	)
+func possibleLogDirs() []string {
+	return []string{"/var/log/yaat"}
+}
 
-	if home, err := os.UserHomeDir(); err == nil && home != "" {
-		// User-level state directory
-		paths = append(paths, filepath.Join(home, ".yaat"))
+func possibleStateDirs() []string {
+	// Used by older releases
+	dirs := []string{"/var/lib/yaat"}
+	if layout, ok := homeLayout(); ok {
+		// Holds the data of every instance
+		dirs = append(dirs, layout.DataDir)
 	}
-
-	return paths
+	return dirs
 }
 
 func isTextFileBusy(err error) bool {
@@ -714,43 +650,26 @@ exit 0
 	return nil
 }
 
-// GetPidPath returns the path to the PID file
-func GetPidPath(expectedPath string) string {
-	return getPidFilePath(expectedPath)
-}
-
-// GetLogPath returns the actual log file path if it exists, empty string otherwise
-func GetLogPath(expectedPath string) string {
-	// Check expected path first
-	if _, err := os.Stat(expectedPath); err == nil {
-		return expectedPath
-	}
-
-	// Check user home fallback
-	if home, err := os.UserHomeDir(); err == nil {
-		homePath := filepath.Join(home, ".yaat", "sidecar.log")
-		if _, err := os.Stat(homePath); err == nil {
-			return homePath
+// GetPidPath returns the PID file a running instance wrote, or
+// layout.PIDFile when there is none.
+func GetPidPath(layout paths.Layout) string {
+	for _, path := range layout.PIDFiles() {
+		if _, err := os.Stat(path); err == nil {
+			return path
 		}
 	}
-
-	return "" // No log file exists
+	return layout.PIDFile
 }
 
-// GetExpectedLogPath returns where logs will be written (even if file doesn't exist yet)
-func GetExpectedLogPath(expectedPath string) string {
-	// Check if directory for expected path exists (writable)
-	dir := filepath.Dir(expectedPath)
-	if _, err := os.Stat(dir); err == nil {
-		return expectedPath
-	}
-
-	// Fallback to user home
-	if home, err := os.UserHomeDir(); err == nil {
-		return filepath.Join(home, ".yaat", "sidecar.log")
+// GetLogPath returns the log file the instance wrote, or "" when there is
+// none yet.
+func GetLogPath(layout paths.Layout) string {
+	for _, path := range layout.LogFiles() {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
 	}
-
-	return expectedPath // Last resort
+	return ""
 }
 
 // Helper functions
@@ -759,25 +678,8 @@ func writePidFile(path string, pid int) error {
 	return ioutil.WriteFile(path, []byte(strconv.Itoa(pid)), 0644)
 }
 
-func getPidFilePath(expectedPath string) string {
-	// Check expected path first
-	if _, err := os.Stat(expectedPath); err == nil {
-		return expectedPath
-	}
-	// Check home directory fallback
-	home, _ := os.UserHomeDir()
-	if home == "" {
-		return expectedPath
-	}
-	userPid := filepath.Join(home, ".yaat", "sidecar.pid")
-	if _, err := os.Stat(userPid); err == nil {
-		return userPid
-	}
-	return expectedPath
-}
-
-func readPID(expectedPath string) (int, string, error) {
-	pidPath := getPidFilePath(expectedPath)
+func readPID(layout paths.Layout) (int, string, error) {
+	pidPath := GetPidPath(layout)
 
 	pidBytes, err := ioutil.ReadFile(pidPath)
 	if err != nil {
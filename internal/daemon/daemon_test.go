@@ -0,0 +1,70 @@
+package daemon
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/paths"
+)
+
+// startTrapped starts a shell that ignores SIGTERM for delay before exiting,
+// like a daemon draining its queue, and records its PID in the layout.
+func startTrapped(t *testing.T, delay string) (paths.Layout, *exec.Cmd) {
+	t.Helper()
+	layout := paths.Resolver{Home: t.TempDir(), SystemRoot: t.TempDir()}.HomeLayout(paths.DefaultInstance)
+	if err := layout.Ensure(); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command("sh", "-c", "trap 'sleep "+delay+"; exit 0' TERM; while :; do sleep 0.05; done")
+	if err := cmd.Start(); err != nil {
+		t.Skipf("sh not available: %v", err)
+	}
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait() // Reap it so the PID disappears once it exits
+		close(exited)
+	}()
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		<-exited
+	})
+	if err := writePidFile(layout.PIDFile, cmd.Process.Pid); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond) // Let the shell install its trap
+	return layout, cmd
+}
+
+func TestStopWaitsForExit(t *testing.T) {
+	layout, _ := startTrapped(t, "0.5")
+
+	start := time.Now()
+	if err := Stop(layout, 5*time.Second); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("Expected Stop to wait for the process to exit, returned after %s", elapsed)
+	}
+	if IsRunning(layout) {
+		t.Error("Expected the sidecar not to be running after Stop")
+	}
+	if _, err := os.Stat(layout.PIDFile); !os.IsNotExist(err) {
+		t.Errorf("Expected the PID file to be removed, got %v", err)
+	}
+}
+
+func TestStopKeepsPIDFileOnTimeout(t *testing.T) {
+	layout, cmd := startTrapped(t, "5")
+
+	if err := Stop(layout, 200*time.Millisecond); err == nil {
+		t.Fatal("Expected an error when the process outlives the timeout")
+	}
+	data, err := os.ReadFile(layout.PIDFile)
+	if err != nil || string(data) != strconv.Itoa(cmd.Process.Pid) {
+		t.Errorf("Expected the PID file to be kept, got %q (%v)", data, err)
+	}
+}
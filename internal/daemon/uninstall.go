@@ -0,0 +1,316 @@
+package daemon
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// UninstallOptions controls what Uninstall removes.
+type UninstallOptions struct {
+	DryRun     bool // List what would be removed without touching anything
+	KeepData   bool // Keep state, queue and analytics data
+	KeepConfig bool // Keep configuration files
+}
+
+// UninstallItem is an existing path Uninstall would remove.
+type UninstallItem struct {
+	Group     string
+	Path      string
+	Dir       bool
+	Size      int64    // Bytes freed, excluding preserved contents and earlier items
+	Preserved []string // Paths inside Dir that are kept; only the rest is removed
+}
+
+type uninstallGroup struct {
+	label         string
+	paths         func() []string
+	dirs          bool
+	removeParents bool
+	data          bool // Skipped with KeepData
+	config        bool // Skipped with KeepConfig
+}
+
+// uninstallGroups lists what Uninstall removes, in removal order. Systemd
+// units and the binary are handled separately around these.
+var uninstallGroups = []uninstallGroup{
+	{label: "PID files", paths: possiblePidFiles, removeParents: true},
+	{label: "log files", paths: possibleLogFiles, removeParents: true},
+	{label: "configuration files", paths: possibleConfigFiles, removeParents: true, config: true},
+	{label: "state files", paths: possibleStateFiles, data: true},
+	{label: "analytics databases", paths: possibleAnalyticsFiles, data: true},
+	{label: "queue directories", paths: possibleQueueDirs, dirs: true, data: true},
+	{label: "log directories", paths: possibleLogDirs, dirs: true},
+	{label: "state directories", paths: possibleStateDirs, dirs: true, data: true},
+}
+
+func (g uninstallGroup) skipped(opts UninstallOptions) bool {
+	return (g.data && opts.KeepData) || (g.config && opts.KeepConfig)
+}
+
+// PlanUninstall lists the existing paths Uninstall would remove with opts.
+func PlanUninstall(opts UninstallOptions) []UninstallItem {
+	executable, _ := os.Executable()
+	return planUninstall(opts, "", executable)
+}
+
+// planUninstall stats every candidate under root (empty for the real
+// filesystem). Paths of skipped groups are preserved, so directories that
+// contain them are only partially removed.
+func planUninstall(opts UninstallOptions, root, executable string) []UninstallItem {
+	rooted := func(path string) string {
+		if root == "" {
+			return path
+		}
+		return filepath.Join(root, path)
+	}
+
+	preserved := map[string]bool{}
+	for _, group := range uninstallGroups {
+		if !group.skipped(opts) {
+			continue
+		}
+		for _, path := range group.paths() {
+			if path = rooted(path); path != "" && exists(path) {
+				preserved[filepath.Clean(path)] = true
+			}
+		}
+	}
+
+	var items []UninstallItem
+	claimed := map[string]bool{}
+	add := func(group, path string, wantDir bool) {
+		path = filepath.Clean(path)
+		if claimed[path] || preserved[path] {
+			return
+		}
+		info, err := os.Lstat(path)
+		if err != nil || info.IsDir() != wantDir {
+			return
+		}
+
+		item := UninstallItem{Group: group, Path: path, Dir: wantDir, Size: info.Size()}
+		if wantDir {
+			for keep := range preserved {
+				if isWithin(keep, path) {
+					item.Preserved = append(item.Preserved, keep)
+				}
+			}
+			sort.Strings(item.Preserved)
+			item.Size = dirSize(path, func(p string) bool { return claimed[p] || preserved[p] })
+		}
+		claimed[path] = true
+		items = append(items, item)
+	}
+
+	for _, unit := range possibleSystemdUnits() {
+		add("systemd units", rooted(unit), false)
+	}
+	for _, group := range uninstallGroups {
+		if group.skipped(opts) {
+			continue
+		}
+		for _, path := range group.paths() {
+			if path != "" {
+				add(group.label, rooted(path), group.dirs)
+			}
+		}
+	}
+
+	if executable != "" {
+		resolved := executable
+		if eval, err := filepath.EvalSymlinks(executable); err == nil && eval != "" {
+			resolved = eval
+		}
+		add("binary", resolved, false)
+		for _, link := range possibleBinaryLinks() {
+			link = rooted(link)
+			if target, err := filepath.EvalSymlinks(link); err == nil && target == resolved && link != resolved {
+				add("binary", link, false)
+			}
+		}
+	}
+
+	return items
+}
+
+// printUninstallPlan writes the dry-run listing.
+func printUninstallPlan(items []UninstallItem, opts UninstallOptions) {
+	fmt.Println("🧹  Uninstall dry run: nothing will be removed")
+	fmt.Println()
+
+	if opts.KeepConfig {
+		fmt.Println("→ Keeping configuration files (--keep-config)")
+	}
+	if opts.KeepData {
+		fmt.Println("→ Keeping state, queue and analytics data (--keep-data)")
+	}
+
+	var total int64
+	group := ""
+	for _, item := range items {
+		if item.Group != group {
+			group = item.Group
+			fmt.Printf("→ Would remove %s:\n", group)
+		}
+		fmt.Printf("   • %s\n", item)
+		total += item.Size
+	}
+
+	fmt.Println()
+	if len(items) == 0 {
+		fmt.Println("Nothing to remove")
+		return
+	}
+	fmt.Printf("%d path(s), %s in total\n", len(items), FormatSize(total))
+}
+
+// String renders the item as "path (size[, keeping n path(s)])".
+func (item UninstallItem) String() string {
+	detail := FormatSize(item.Size)
+	if len(item.Preserved) > 0 {
+		detail += fmt.Sprintf(", keeping %d path(s)", len(item.Preserved))
+	}
+	return fmt.Sprintf("%s (%s)", item.Path, detail)
+}
+
+// removePlanned removes the planned items of every group in order, printing
+// one progress line per group.
+func removePlanned(items []UninstallItem, opts UninstallOptions) []string {
+	var warnings []string
+
+	for _, group := range uninstallGroups {
+		if group.skipped(opts) {
+			fmt.Printf("→ Keeping %s\n", strings.ToLower(group.label))
+			continue
+		}
+
+		fmt.Printf("→ Removing %s... ", strings.ToLower(group.label))
+		removed := 0
+		for _, item := range items {
+			if item.Group != group.label {
+				continue
+			}
+
+			var err error
+			switch {
+			case len(item.Preserved) > 0:
+				err = removeAllExcept(item.Path, item.Preserved)
+			case item.Dir:
+				err = os.RemoveAll(item.Path)
+			default:
+				err = os.Remove(item.Path)
+			}
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				if os.IsPermission(err) {
+					warnings = append(warnings, fmt.Sprintf("remove %s %s: permission denied", group.label, item.Path))
+				} else {
+					warnings = append(warnings, fmt.Sprintf("remove %s %s: %v", group.label, item.Path, err))
+				}
+				continue
+			}
+
+			removed++
+			if group.removeParents {
+				removeParentDirIfEmpty(filepath.Dir(item.Path))
+			}
+		}
+
+		if removed > 0 {
+			fmt.Printf("✓ (removed %d)\n", removed)
+		} else {
+			fmt.Println("(none found)")
+		}
+	}
+
+	return warnings
+}
+
+// removeAllExcept removes everything inside dir apart from the keep paths
+// and the directories leading to them.
+func removeAllExcept(dir string, keep []string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		kept, holdsKept := false, false
+		for _, k := range keep {
+			if k == path {
+				kept = true
+			} else if isWithin(k, path) {
+				holdsKept = true
+			}
+		}
+
+		switch {
+		case kept:
+			continue
+		case holdsKept:
+			if err := removeAllExcept(path, keep); err != nil {
+				return err
+			}
+		default:
+			if err := os.RemoveAll(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// dirSize sums file sizes under dir, skipping paths for which skip is true.
+func dirSize(dir string, skip func(string) bool) int64 {
+	var total int64
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if path != dir && skip(path) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	return total
+}
+
+// isWithin reports whether path lies strictly inside dir.
+func isWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// FormatSize renders a byte count with a binary unit, e.g. "1.5 MB".
+func FormatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,170 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeInstall lays out an installation under a temp root with HOME=/home/test
+// and returns the root and the path of the fake binary.
+func fakeInstall(t *testing.T) (string, string) {
+	t.Helper()
+	t.Setenv("HOME", "/home/test")
+	t.Setenv("YAAT_CONFIG_PATH", "")
+	t.Setenv("YAAT_QUEUE_DIR", "")
+
+	root := t.TempDir()
+	files := map[string]string{
+		"home/test/.yaat/yaat.yaml":        "service_name: svc\n",
+		"home/test/.yaat/state.json":       "{}",
+		"home/test/.yaat/analytics.db":     strings.Repeat("x", 2048),
+		"home/test/.yaat/sidecar.log":      "log line\n",
+		"home/test/.yaat/sidecar.pid":      "123",
+		"home/test/.yaat/queue/batch.json": "[]",
+		"home/test/.yaat/control.sock.tmp": "",
+		"var/lib/yaat/spill/0001.json":     "[]",
+		"var/log/yaat/sidecar.log":         "old log\n",
+		"opt/yaat/bin/yaat-sidecar":        "binary",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	binary := filepath.Join(root, "opt/yaat/bin/yaat-sidecar")
+	link := filepath.Join(root, "usr/local/bin/yaat-sidecar")
+	if err := os.MkdirAll(filepath.Dir(link), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(binary, link); err != nil {
+		t.Fatal(err)
+	}
+	return root, binary
+}
+
+func planPaths(items []UninstallItem) map[string]UninstallItem {
+	paths := make(map[string]UninstallItem, len(items))
+	for _, item := range items {
+		paths[item.Path] = item
+	}
+	return paths
+}
+
+func TestPlanUninstallListsExistingPaths(t *testing.T) {
+	root, binary := fakeInstall(t)
+	home := filepath.Join(root, "home/test/.yaat")
+
+	items := planUninstall(UninstallOptions{DryRun: true}, root, binary)
+	paths := planPaths(items)
+
+	for _, want := range []string{
+		filepath.Join(home, "sidecar.pid"),
+		filepath.Join(home, "sidecar.log"),
+		filepath.Join(home, "yaat.yaml"),
+		filepath.Join(home, "state.json"),
+		filepath.Join(home, "analytics.db"),
+		filepath.Join(home, "queue"),
+		filepath.Join(root, "var/log/yaat"),
+		filepath.Join(root, "var/lib/yaat"),
+		home,
+		binary,
+		filepath.Join(root, "usr/local/bin/yaat-sidecar"),
+	} {
+		if _, ok := paths[want]; !ok {
+			t.Errorf("Expected %s in plan, got %v", want, items)
+		}
+	}
+	if len(paths) != len(items) {
+		t.Errorf("Expected no duplicate paths, got %v", items)
+	}
+
+	if size := paths[filepath.Join(home, "analytics.db")].Size; size != 2048 {
+		t.Errorf("Expected analytics.db size 2048, got %d", size)
+	}
+	// Files listed earlier are not counted again in the directory holding them
+	if size := paths[home].Size; size != 0 {
+		t.Errorf("Expected ~/.yaat remainder size 0, got %d", size)
+	}
+
+	// Planning never touches the filesystem
+	if _, err := os.Stat(filepath.Join(home, "analytics.db")); err != nil {
+		t.Errorf("Expected dry run to leave files in place: %v", err)
+	}
+}
+
+func TestUninstallKeepConfig(t *testing.T) {
+	root, binary := fakeInstall(t)
+	home := filepath.Join(root, "home/test/.yaat")
+	opts := UninstallOptions{KeepConfig: true}
+
+	items := planUninstall(opts, root, binary)
+	paths := planPaths(items)
+	if _, ok := paths[filepath.Join(home, "yaat.yaml")]; ok {
+		t.Error("Expected config file to be left out of the plan")
+	}
+	if kept := paths[home].Preserved; len(kept) != 1 || kept[0] != filepath.Join(home, "yaat.yaml") {
+		t.Errorf("Expected ~/.yaat to preserve yaat.yaml, got %v", kept)
+	}
+
+	if warnings := removePlanned(items, opts); len(warnings) > 0 {
+		t.Fatalf("Unexpected warnings: %v", warnings)
+	}
+	if _, err := os.Stat(filepath.Join(home, "yaat.yaml")); err != nil {
+		t.Errorf("Expected config to survive: %v", err)
+	}
+	entries, _ := os.ReadDir(home)
+	if len(entries) != 1 {
+		t.Errorf("Expected only yaat.yaml to remain in ~/.yaat, got %d entries", len(entries))
+	}
+	if exists(filepath.Join(root, "var/lib/yaat")) {
+		t.Error("Expected /var/lib/yaat to be removed")
+	}
+}
+
+func TestUninstallKeepData(t *testing.T) {
+	root, binary := fakeInstall(t)
+	home := filepath.Join(root, "home/test/.yaat")
+	opts := UninstallOptions{KeepData: true}
+
+	items := planUninstall(opts, root, binary)
+	if warnings := removePlanned(items, opts); len(warnings) > 0 {
+		t.Fatalf("Unexpected warnings: %v", warnings)
+	}
+
+	for _, kept := range []string{
+		filepath.Join(home, "state.json"),
+		filepath.Join(home, "analytics.db"),
+		filepath.Join(home, "queue", "batch.json"),
+		filepath.Join(root, "var/lib/yaat/spill/0001.json"),
+	} {
+		if !exists(kept) {
+			t.Errorf("Expected %s to be kept", kept)
+		}
+	}
+	for _, removed := range []string{
+		filepath.Join(home, "yaat.yaml"),
+		filepath.Join(home, "sidecar.log"),
+		filepath.Join(home, "sidecar.pid"),
+		filepath.Join(root, "var/log/yaat"),
+	} {
+		if exists(removed) {
+			t.Errorf("Expected %s to be removed", removed)
+		}
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	cases := map[int64]string{0: "0 B", 1023: "1023 B", 1536: "1.5 KB", 5 << 20: "5.0 MB"}
+	for bytes, want := range cases {
+		if got := FormatSize(bytes); got != want {
+			t.Errorf("FormatSize(%d) = %q, expected %q", bytes, got, want)
+		}
+	}
+}
@@ -19,54 +19,163 @@ type CloudProvider struct {
 	Tags         map[string]string // provider-specific tags
 }
 
-// DetectCloudProvider attempts to detect the cloud provider and metadata
-func DetectCloudProvider() *CloudProvider {
-	// Try AWS first (most common)
-	if cloud := detectAWS(); cloud != nil {
-		return cloud
-	}
+// Cloud detection modes for DetectCloud.
+const (
+	CloudAuto  = "auto"
+	CloudOff   = "off"
+	CloudAWS   = "aws"
+	CloudGCP   = "gcp"
+	CloudAzure = "azure"
+)
 
-	// Try GCP
-	if cloud := detectGCP(); cloud != nil {
-		return cloud
-	}
+// DefaultTimeout bounds all metadata probes of one detection together,
+// retries included, unless detection.timeout sets another deadline.
+const DefaultTimeout = 2 * time.Second
 
-	// Try Azure
-	if cloud := detectAzure(); cloud != nil {
-		return cloud
-	}
+const (
+	// metadataAttempts is how often each probe asks its metadata service.
+	// Right after boot the service may refuse connections or be slow to
+	// answer for a moment.
+	metadataAttempts = 3
 
-	// Not in cloud or detection failed
-	return &CloudProvider{
-		Provider: "unknown",
-		Tags:     make(map[string]string),
-	}
+	// metadataBackoff is the wait before the second attempt, doubled before
+	// each one after.
+	metadataBackoff = 100 * time.Millisecond
+)
+
+// newMetadataClient returns a client that always connects directly. The
+// link-local metadata services are unreachable through a proxy, so
+// HTTP_PROXY/HTTPS_PROXY set for delivery must not apply here. Requests are
+// bounded by the detection deadline rather than a client timeout.
+func newMetadataClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	return &http.Client{Transport: transport}
 }
 
-// detectAWS queries EC2 metadata service
-func detectAWS() *CloudProvider {
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-	defer cancel()
+// getMetadata fetches url, retrying failed attempts with backoff until
+// metadataAttempts is reached or ctx ends. Each attempt gets an equal share
+// of the time left, so one hung request cannot use up the whole deadline.
+func getMetadata(ctx context.Context, client *http.Client, url string, header http.Header) []byte {
+	backoff := metadataBackoff
+	for attempt := 1; ; attempt++ {
+		if body, ok := getMetadataOnce(ctx, client, url, header, metadataAttempts-attempt+1); ok {
+			return body
+		}
+		if attempt == metadataAttempts {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
 
-	// Try to get instance identity document
-	req, err := http.NewRequestWithContext(ctx, "GET", "http://169.254.169.254/latest/dynamic/instance-identity/document", nil)
+func getMetadataOnce(ctx context.Context, client *http.Client, url string, header http.Header, attemptsLeft int) ([]byte, bool) {
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Until(deadline)/time.Duration(attemptsLeft))
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		return nil
+		return nil, false
+	}
+	for key, values := range header {
+		req.Header[key] = values
 	}
 
-	client := &http.Client{Timeout: 2 * time.Second}
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil
+		return nil, false
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		return nil
+		return nil, false
 	}
-
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
+		return nil, false
+	}
+	return body, true
+}
+
+type cloudProbe func(ctx context.Context, client *http.Client) *CloudProvider
+
+// cloudProbes lists the metadata services DetectCloud can probe.
+var cloudProbes = []struct {
+	name  string
+	probe cloudProbe
+}{
+	{CloudAWS, detectAWS},
+	{CloudGCP, detectGCP},
+	{CloudAzure, detectAzure},
+}
+
+// DetectCloudProvider attempts to detect the cloud provider and metadata
+func DetectCloudProvider() *CloudProvider {
+	return DetectCloud(CloudAuto, 0, nil)
+}
+
+// DetectCloud probes the metadata services selected by mode: all of them
+// for CloudAuto, one provider's, or none for CloudOff. Probes run
+// concurrently, retry failed requests and share one deadline (timeout, or
+// DefaultTimeout when zero); the first to succeed wins and the rest are
+// cancelled. A nil client uses a default one.
+func DetectCloud(mode string, timeout time.Duration, client *http.Client) *CloudProvider {
+	unknown := &CloudProvider{
+		Provider: "unknown",
+		Tags:     make(map[string]string),
+	}
+	if mode == "" {
+		mode = CloudAuto
+	}
+	if mode == CloudOff {
+		return unknown
+	}
+	if client == nil {
+		client = newMetadataClient()
+	}
+
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Buffered so probes still running after the winner returns can exit
+	found := make(chan *CloudProvider, len(cloudProbes))
+	running := 0
+	for _, p := range cloudProbes {
+		if mode != CloudAuto && mode != p.name {
+			continue
+		}
+		running++
+		go func(probe cloudProbe) {
+			found <- probe(ctx, client)
+		}(p.probe)
+	}
+
+	// Only one metadata service answers on a given host; returning cancels
+	// the probes still waiting on the others
+	for ; running > 0; running-- {
+		if cloud := <-found; cloud != nil {
+			return cloud
+		}
+	}
+	// Not in cloud or detection failed
+	return unknown
+}
+
+// detectAWS queries EC2 metadata service
+func detectAWS(ctx context.Context, client *http.Client) *CloudProvider {
+	// Try to get instance identity document
+	body := getMetadata(ctx, client, "http://169.254.169.254/latest/dynamic/instance-identity/document", nil)
+	if body == nil {
 		return nil
 	}
 
@@ -98,30 +207,11 @@ func detectAWS() *CloudProvider {
 }
 
 // detectGCP queries GCP metadata service
-func detectGCP() *CloudProvider {
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-	defer cancel()
-
+func detectGCP(ctx context.Context, client *http.Client) *CloudProvider {
 	// GCP requires Metadata-Flavor header
-	req, err := http.NewRequestWithContext(ctx, "GET", "http://metadata.google.internal/computeMetadata/v1/instance/?recursive=true", nil)
-	if err != nil {
-		return nil
-	}
-	req.Header.Set("Metadata-Flavor", "Google")
-
-	client := &http.Client{Timeout: 2 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		return nil
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
+	body := getMetadata(ctx, client, "http://metadata.google.internal/computeMetadata/v1/instance/?recursive=true",
+		http.Header{"Metadata-Flavor": {"Google"}})
+	if body == nil {
 		return nil
 	}
 
@@ -169,29 +259,10 @@ func detectGCP() *CloudProvider {
 }
 
 // detectAzure queries Azure Instance Metadata Service
-func detectAzure() *CloudProvider {
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-	defer cancel()
-
-	req, err := http.NewRequestWithContext(ctx, "GET", "http://169.254.169.254/metadata/instance?api-version=2021-02-01", nil)
-	if err != nil {
-		return nil
-	}
-	req.Header.Set("Metadata", "true")
-
-	client := &http.Client{Timeout: 2 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		return nil
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
+func detectAzure(ctx context.Context, client *http.Client) *CloudProvider {
+	body := getMetadata(ctx, client, "http://169.254.169.254/metadata/instance?api-version=2021-02-01",
+		http.Header{"Metadata": {"true"}})
+	if body == nil {
 		return nil
 	}
 
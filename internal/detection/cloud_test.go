@@ -0,0 +1,161 @@
+package detection
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (fn roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return fn(req)
+}
+
+// metadataClient serves the GCP metadata document and fails everything else,
+// counting requests.
+func metadataClient(calls *atomic.Int32, delay time.Duration) *http.Client {
+	return &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		calls.Add(1)
+		if req.URL.Host != "metadata.google.internal" {
+			select {
+			case <-time.After(delay):
+			case <-req.Context().Done():
+			}
+			return nil, req.Context().Err()
+		}
+		body := `{"id": 1, "machineType": "projects/1/machineTypes/e2-small", "zone": "projects/1/zones/europe-west1-b", "name": "vm-1"}`
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(body)), Header: make(http.Header)}, nil
+	})}
+}
+
+func TestDetectCloudOffMakesNoRequests(t *testing.T) {
+	var calls atomic.Int32
+	cloud := DetectCloud(CloudOff, 0, metadataClient(&calls, 0))
+	if cloud.Provider != "unknown" || len(cloud.Tags) != 0 {
+		t.Errorf("Expected unknown provider, got %+v", cloud)
+	}
+	if calls.Load() != 0 {
+		t.Errorf("Expected no metadata requests, got %d", calls.Load())
+	}
+}
+
+func TestDetectCloudSingleProvider(t *testing.T) {
+	var calls atomic.Int32
+	cloud := DetectCloud(CloudAWS, 0, metadataClient(&calls, 0))
+	if cloud.Provider != "unknown" {
+		t.Errorf("Expected AWS probe to fail, got %+v", cloud)
+	}
+	if calls.Load() != metadataAttempts {
+		t.Errorf("Expected only the AWS probe and its retries, got %d requests", calls.Load())
+	}
+}
+
+func TestDetectCloudRetriesSlowMetadataService(t *testing.T) {
+	var calls atomic.Int32
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		switch calls.Add(1) {
+		case 1:
+			// Still booting: the first request hangs until its share of the deadline ends
+			<-req.Context().Done()
+			return nil, req.Context().Err()
+		case 2:
+			return &http.Response{StatusCode: 503, Body: io.NopCloser(strings.NewReader("")), Header: make(http.Header)}, nil
+		}
+		body := `{"instanceId": "i-1", "instanceType": "t3.micro", "region": "us-east-1", "availabilityZone": "us-east-1a"}`
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(body)), Header: make(http.Header)}, nil
+	})}
+
+	start := time.Now()
+	cloud := DetectCloud(CloudAWS, 900*time.Millisecond, client)
+	if cloud.Provider != "aws" || cloud.Region != "us-east-1" {
+		t.Fatalf("Expected AWS metadata on the third attempt, got %+v", cloud)
+	}
+	if elapsed := time.Since(start); elapsed >= 900*time.Millisecond {
+		t.Errorf("Expected detection within the deadline, took %v", elapsed)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("Expected 3 attempts, got %d", calls.Load())
+	}
+}
+
+func TestDetectCloudAutoProbesConcurrently(t *testing.T) {
+	var calls atomic.Int32
+	start := time.Now()
+	// The other probes would hang past the deadline; GCP answering cancels them
+	cloud := DetectCloud(CloudAuto, 0, metadataClient(&calls, 10*time.Second))
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected detection to stop once GCP answered, took %v", elapsed)
+	}
+	if cloud.Provider != "gcp" || cloud.Region != "europe-west1" || cloud.Tags["cloud.instance_type"] != "e2-small" {
+		t.Errorf("Expected GCP metadata, got %+v", cloud)
+	}
+	// The losing probes may still be starting when the winner returns
+	deadline := time.Now().Add(time.Second)
+	for calls.Load() < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("Expected all three probes, got %d requests", calls.Load())
+	}
+}
+
+func TestDetectCloudFastestProviderWins(t *testing.T) {
+	// AWS comes first in cloudProbes but answers after Azure
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/latest/"):
+			select {
+			case <-time.After(500 * time.Millisecond):
+			case <-r.Context().Done():
+				return
+			}
+			io.WriteString(w, `{"instanceId": "i-1", "instanceType": "t3.micro", "region": "us-east-1", "availabilityZone": "us-east-1a"}`)
+		case strings.HasPrefix(r.URL.Path, "/metadata/"):
+			time.Sleep(20 * time.Millisecond)
+			io.WriteString(w, `{"compute": {"vmSize": "Standard_B1s", "location": "westeurope", "vmId": "vm-1"}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	target, _ := url.Parse(server.URL)
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		req.URL.Host = target.Host
+		return http.DefaultTransport.RoundTrip(req)
+	})}
+
+	start := time.Now()
+	cloud := DetectCloud(CloudAuto, 0, client)
+	if elapsed := time.Since(start); elapsed >= 500*time.Millisecond {
+		t.Errorf("Expected detection to return with the first answer, took %v", elapsed)
+	}
+	if cloud.Provider != "azure" || cloud.Region != "westeurope" {
+		t.Errorf("Expected the faster Azure probe to win, got %+v", cloud)
+	}
+}
+
+func TestMetadataClientIgnoresProxyEnv(t *testing.T) {
+	t.Setenv("HTTP_PROXY", "http://proxy.corp.example:3128")
+	t.Setenv("HTTPS_PROXY", "http://proxy.corp.example:3128")
+	t.Setenv("NO_PROXY", "")
+
+	transport, ok := newMetadataClient().Transport.(*http.Transport)
+	if !ok {
+		t.Fatal("Expected an *http.Transport")
+	}
+	if transport.Proxy != nil {
+		req, _ := http.NewRequest(http.MethodGet, "http://169.254.169.254/latest/meta-data/", nil)
+		proxyURL, _ := transport.Proxy(req)
+		t.Errorf("Expected metadata requests to connect directly, got proxy %v", proxyURL)
+	}
+	if http.DefaultTransport.(*http.Transport).Proxy == nil {
+		t.Error("Expected the default transport to keep honouring proxy env for delivery")
+	}
+}
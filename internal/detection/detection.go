@@ -1,12 +1,15 @@
 package detection
 
 import (
+	"bufio"
 	"encoding/json"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"sort"
 	"strings"
+
+	"gopkg.in/yaml.v3"
 )
 
 // DetectedService represents a detected service
@@ -15,6 +18,7 @@ type DetectedService struct {
 	Type       string // "web_server", "framework", "database", etc.
 	ConfigPath string
 	LogPaths   []string
+	LogLabels  map[string]string // Log path -> where it is configured, e.g. an nginx server_name
 	Running    bool
 }
 
@@ -53,8 +57,13 @@ func DetectEnvironment() *DetectedEnvironment {
 	env.Services = append(env.Services, detectDjango()...)
 	env.Services = append(env.Services, detectNodeJS()...)
 
+	// Detect datastores
+	env.Services = append(env.Services, detectRedis()...)
+	env.Services = append(env.Services, detectMongo()...)
+
 	// Discover log files
 	env.LogFiles = discoverLogFiles()
+	env.LogFiles = appendServiceLogFiles(env.LogFiles, env.Services)
 	env.Journald = hasJournald()
 
 	return env
@@ -84,12 +93,13 @@ func detectNginx() []DetectedService {
 
 	var configPath string
 	var logPaths []string
+	var logLabels map[string]string
 
 	for _, path := range configPaths {
 		if _, err := os.Stat(path); err == nil {
 			configPath = path
-			// Parse config for log paths
-			logPaths = parseNginxConfig(path)
+			// Parse config, including sites-enabled and conf.d, for log paths
+			logPaths, logLabels = parseNginxConfig(path)
 			break
 		}
 	}
@@ -100,6 +110,7 @@ func detectNginx() []DetectedService {
 			Type:       "web_server",
 			ConfigPath: configPath,
 			LogPaths:   logPaths,
+			LogLabels:  logLabels,
 			Running:    running,
 		})
 	}
@@ -213,6 +224,225 @@ func detectNodeJS() []DetectedService {
 	return services
 }
 
+// detectRedis checks for a Redis server and the log file configured in redis.conf
+func detectRedis() []DetectedService {
+	var services []DetectedService
+
+	_, err := exec.LookPath("redis-server")
+	installed := err == nil
+
+	running := false
+	if output, err := exec.Command("pgrep", "-x", "redis-server").Output(); err == nil && len(output) > 0 {
+		running = true
+	}
+
+	configPath := firstExisting(append([]string{
+		"/etc/redis/redis.conf",
+		"/etc/redis.conf",
+		"/usr/local/etc/redis.conf",
+		"/opt/homebrew/etc/redis.conf",
+	}, mustGlob("/etc/redis/*.conf")...))
+
+	if !installed && !running && configPath == "" {
+		return services
+	}
+
+	var logPaths []string
+	if configPath != "" {
+		if logPath := parseRedisConfig(configPath); logPath != "" {
+			logPaths = append(logPaths, logPath)
+		}
+	}
+	if len(logPaths) == 0 {
+		logPaths = existingPaths([]string{
+			"/var/log/redis/redis-server.log",
+			"/var/log/redis/redis.log",
+			"/usr/local/var/log/redis.log",
+		})
+	}
+
+	services = append(services, DetectedService{
+		Name:       "Redis",
+		Type:       "database",
+		ConfigPath: configPath,
+		LogPaths:   logPaths,
+		Running:    running,
+	})
+
+	return services
+}
+
+// detectMongo checks for a MongoDB server and the log file configured in mongod.conf
+func detectMongo() []DetectedService {
+	var services []DetectedService
+
+	_, err := exec.LookPath("mongod")
+	installed := err == nil
+
+	running := false
+	if output, err := exec.Command("pgrep", "-x", "mongod").Output(); err == nil && len(output) > 0 {
+		running = true
+	}
+
+	configPath := firstExisting([]string{
+		"/etc/mongod.conf",
+		"/usr/local/etc/mongod.conf",
+		"/opt/homebrew/etc/mongod.conf",
+	})
+
+	if !installed && !running && configPath == "" {
+		return services
+	}
+
+	var logPaths []string
+	if configPath != "" {
+		if logPath := parseMongoConfig(configPath); logPath != "" {
+			logPaths = append(logPaths, logPath)
+		}
+	}
+	if len(logPaths) == 0 {
+		logPaths = existingPaths([]string{
+			"/var/log/mongodb/mongod.log",
+			"/usr/local/var/log/mongodb/mongo.log",
+		})
+	}
+
+	services = append(services, DetectedService{
+		Name:       "MongoDB",
+		Type:       "database",
+		ConfigPath: configPath,
+		LogPaths:   logPaths,
+		Running:    running,
+	})
+
+	return services
+}
+
+// parseRedisConfig returns the logfile setting from redis.conf.
+// An empty logfile means Redis logs to stdout, so nothing is returned.
+func parseRedisConfig(configPath string) string {
+	file, err := os.Open(configPath)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	logPath := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && strings.EqualFold(fields[0], "logfile") {
+			// Later directives override earlier ones, as in redis itself
+			logPath = strings.Trim(strings.Join(fields[1:], " "), `"'`)
+		}
+	}
+
+	return logPath
+}
+
+// parseMongoConfig returns systemLog.path from a YAML mongod.conf, falling back
+// to the legacy INI-style "logpath=" option.
+func parseMongoConfig(configPath string) string {
+	content, err := os.ReadFile(configPath)
+	if err != nil {
+		return ""
+	}
+
+	var cfg struct {
+		SystemLog struct {
+			Destination string `yaml:"destination"`
+			Path        string `yaml:"path"`
+		} `yaml:"systemLog"`
+	}
+	if err := yaml.Unmarshal(content, &cfg); err == nil && cfg.SystemLog.Path != "" {
+		if cfg.SystemLog.Destination != "" && cfg.SystemLog.Destination != "file" {
+			return ""
+		}
+		return cfg.SystemLog.Path
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "logpath") {
+			parts := strings.SplitN(line, "=", 2)
+			if len(parts) == 2 && strings.TrimSpace(parts[0]) == "logpath" {
+				return strings.Trim(strings.TrimSpace(parts[1]), `"'`)
+			}
+		}
+	}
+
+	return ""
+}
+
+// appendServiceLogFiles adds log paths found in service configs that discovery missed
+// and labels discovered files with the service that writes them.
+func appendServiceLogFiles(files []LogFile, services []DetectedService) []LogFile {
+	seen := make(map[string]int, len(files))
+	for i, f := range files {
+		seen[f.Path] = i
+	}
+
+	for _, svc := range services {
+		for _, path := range svc.LogPaths {
+			source := svc.Name
+			if label := svc.LogLabels[path]; label != "" {
+				source = svc.Name + " – " + label
+			}
+			if i, ok := seen[path]; ok {
+				if files[i].Source == "" {
+					files[i].Source = source
+				}
+				continue
+			}
+			info, err := os.Stat(path)
+			if err != nil || info.IsDir() {
+				continue
+			}
+			seen[path] = len(files)
+
+			readable := true
+			if f, err := os.Open(path); err == nil {
+				_ = f.Close()
+			} else {
+				readable = false
+			}
+
+			files = append(files, LogFile{
+				Path:            path,
+				SuggestedFormat: suggestLogFormat(path),
+				Size:            info.Size(),
+				Readable:        readable,
+				Source:          source,
+			})
+		}
+	}
+
+	return files
+}
+
+func firstExisting(paths []string) string {
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+	}
+	return ""
+}
+
+func existingPaths(paths []string) []string {
+	var existing []string
+	for _, path := range paths {
+		if _, err := os.Stat(path); err == nil {
+			existing = append(existing, path)
+		}
+	}
+	return existing
+}
+
 // discoverLogFiles finds common log file locations
 func discoverLogFiles() []LogFile {
 	files := discoverFilesystemLogs()
@@ -349,7 +579,7 @@ func containerSourceHint(path string) (string, string) {
 	case strings.Contains(path, "/var/log/containers/"):
 		return kubernetesSymlinkHint(path), "docker"
 	case strings.Contains(path, "/var/log/pods/"):
-		return kubernetesPodHint(path), "docker"
+		return kubernetesPodHint(path), "cri"
 	default:
 		return "container runtime", "docker"
 	}
@@ -483,6 +713,13 @@ func suggestLogFormat(path string) string {
 	if strings.Contains(lower, "django") || strings.Contains(lower, "gunicorn") {
 		return "django"
 	}
+	if strings.Contains(lower, "mongo") {
+		// MongoDB 4.4+ writes structured JSON log lines
+		return "json"
+	}
+	if strings.Contains(lower, "redis") {
+		return "generic"
+	}
 	if strings.Contains(lower, ".json") {
 		return "json"
 	}
@@ -490,31 +727,177 @@ func suggestLogFormat(path string) string {
 	return "generic"
 }
 
-// parseNginxConfig extracts log paths from nginx.conf
-func parseNginxConfig(configPath string) []string {
-	var logPaths []string
+const (
+	maxNginxIncludeDepth = 8
+	maxNginxConfigFiles  = 200
+)
 
-	content, err := os.ReadFile(configPath)
+// parseNginxConfig extracts access_log and error_log paths from nginx.conf and
+// the files it includes (sites-enabled/*, conf.d/*.conf, ...). Logs declared
+// inside a server block are labelled with its first server_name.
+func parseNginxConfig(configPath string) ([]string, map[string]string) {
+	p := &nginxParser{
+		prefix: filepath.Dir(configPath),
+		seen:   make(map[string]bool),
+		labels: make(map[string]string),
+	}
+	p.parseFile(configPath, 0)
+	return p.paths, p.labels
+}
+
+type nginxParser struct {
+	prefix string // Relative includes resolve against the main config's directory
+	files  int
+
+	paths  []string
+	seen   map[string]bool
+	labels map[string]string
+
+	// Open blocks, innermost last; server is nil for blocks other than server
+	blocks []nginxBlock
+}
+
+type nginxBlock struct {
+	server *nginxServer
+}
+
+type nginxServer struct {
+	name string
+	logs []string
+}
+
+func (p *nginxParser) parseFile(path string, depth int) {
+	if depth > maxNginxIncludeDepth || p.files >= maxNginxConfigFiles {
+		return
+	}
+	content, err := os.ReadFile(path)
 	if err != nil {
-		return logPaths
+		return
 	}
+	p.files++
 
-	lines := strings.Split(string(content), "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if strings.HasPrefix(line, "access_log") {
-			parts := strings.Fields(line)
-			if len(parts) >= 2 {
-				logPath := parts[1]
-				logPath = strings.TrimSuffix(logPath, ";")
-				if logPath != "off" && !strings.HasPrefix(logPath, "syslog:") {
-					logPaths = append(logPaths, logPath)
+	nginxStatements(string(content), func(words []string, term byte) {
+		switch term {
+		case '{':
+			var block nginxBlock
+			if len(words) > 0 && words[0] == "server" {
+				block.server = &nginxServer{}
+			}
+			p.blocks = append(p.blocks, block)
+			return
+		case '}':
+			p.closeBlock()
+			return
+		}
+		if len(words) < 2 {
+			return
+		}
+
+		switch words[0] {
+		case "include":
+			pattern := words[1]
+			if !filepath.IsAbs(pattern) {
+				pattern = filepath.Join(p.prefix, pattern)
+			}
+			matches, _ := filepath.Glob(pattern)
+			sort.Strings(matches)
+			for _, match := range matches {
+				p.parseFile(match, depth+1)
+			}
+		case "server_name":
+			if server := p.currentServer(); server != nil && server.name == "" {
+				for _, name := range words[1:] {
+					if name != "_" && name != "" {
+						server.name = name
+						break
+					}
 				}
 			}
+		case "access_log", "error_log":
+			p.addLog(words[1])
+		}
+	})
+}
+
+func (p *nginxParser) currentServer() *nginxServer {
+	for i := len(p.blocks) - 1; i >= 0; i-- {
+		if p.blocks[i].server != nil {
+			return p.blocks[i].server
 		}
 	}
+	return nil
+}
 
-	return logPaths
+func (p *nginxParser) addLog(path string) {
+	if path == "off" || path == "stderr" || strings.HasPrefix(path, "syslog:") || strings.HasPrefix(path, "/dev/") || strings.Contains(path, "$") {
+		return
+	}
+	if server := p.currentServer(); server != nil {
+		server.logs = append(server.logs, path)
+	}
+	if !p.seen[path] {
+		p.seen[path] = true
+		p.paths = append(p.paths, path)
+	}
+}
+
+func (p *nginxParser) closeBlock() {
+	if len(p.blocks) == 0 {
+		return
+	}
+	block := p.blocks[len(p.blocks)-1]
+	p.blocks = p.blocks[:len(p.blocks)-1]
+	if block.server == nil || block.server.name == "" {
+		return
+	}
+	for _, path := range block.server.logs {
+		if _, ok := p.labels[path]; !ok {
+			p.labels[path] = block.server.name
+		}
+	}
+}
+
+// nginxStatements splits nginx config into statements, calling fn with the
+// words of each and the byte that ended it: ';', '{' or '}'. Comments are
+// skipped and quotes removed.
+func nginxStatements(content string, fn func(words []string, term byte)) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	endWord := func() {
+		if inWord {
+			words = append(words, word.String())
+			word.Reset()
+			inWord = false
+		}
+	}
+
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		switch {
+		case c == '#' && !inWord:
+			for i < len(content) && content[i] != '\n' {
+				i++
+			}
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(content[i+1:], c)
+			if end < 0 {
+				end = len(content) - i - 1
+			}
+			word.WriteString(content[i+1 : i+1+end])
+			inWord = true
+			i += end + 1
+		case c == ';' || c == '{' || c == '}':
+			endWord()
+			fn(words, c)
+			words = nil
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			endWord()
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
 }
 
 // isRunningInContainer checks if running inside a container
@@ -553,18 +936,27 @@ func isRunningInK8s() bool {
 
 // KubernetesMetadata represents detected Kubernetes metadata
 type KubernetesMetadata struct {
-	InCluster bool              // running in k8s
-	PodName   string            // from POD_NAME env or hostname
-	Namespace string            // from POD_NAMESPACE env or service account
-	NodeName  string            // from NODE_NAME env
-	PodIP     string            // from POD_IP env
-	Labels    map[string]string // from downward API
-	Tags      map[string]string // merged tags for events
+	InCluster   bool              // running in k8s
+	PodName     string            // from POD_NAME env or hostname
+	Namespace   string            // from POD_NAMESPACE env or service account
+	NodeName    string            // from NODE_NAME env
+	PodIP       string            // from POD_IP env
+	OwnerKind   string            // inferred from the downward API labels, e.g. ReplicaSet
+	Labels      map[string]string // allowed labels from the downward API
+	Annotations map[string]string // allowed annotations from the downward API
+	Tags        map[string]string // merged tags for events
 }
 
-// DetectKubernetesMetadata extracts Kubernetes metadata
+// DetectKubernetesMetadata extracts Kubernetes metadata, reading the default
+// labels from the downward API volume at DefaultPodInfoDir.
 func DetectKubernetesMetadata() *KubernetesMetadata {
-	if !isRunningInK8s() {
+	return DetectKubernetes(PodInfoOptions{Labels: DefaultPodLabels})
+}
+
+// DetectKubernetes extracts Kubernetes metadata from the downward API
+// environment variables and the volume described by opts.
+func DetectKubernetes(opts PodInfoOptions) *KubernetesMetadata {
+	if !isRunningInK8s() && !hasPodInfo(opts.Dir) {
 		return &KubernetesMetadata{
 			InCluster: false,
 			Tags:      make(map[string]string),
@@ -572,9 +964,10 @@ func DetectKubernetesMetadata() *KubernetesMetadata {
 	}
 
 	k8s := &KubernetesMetadata{
-		InCluster: true,
-		Labels:    make(map[string]string),
-		Tags:      make(map[string]string),
+		InCluster:   true,
+		Labels:      make(map[string]string),
+		Annotations: make(map[string]string),
+		Tags:        make(map[string]string),
 	}
 
 	// Read from downward API environment variables (most reliable)
@@ -609,24 +1002,8 @@ func DetectKubernetesMetadata() *KubernetesMetadata {
 		k8s.Tags["k8s.pod.ip"] = k8s.PodIP
 	}
 
-	// Try to read labels from downward API volume mount
-	// In K8s, labels can be mounted to /etc/podinfo/labels
-	if data, err := os.ReadFile("/etc/podinfo/labels"); err == nil {
-		for _, line := range strings.Split(string(data), "\n") {
-			line = strings.TrimSpace(line)
-			if line == "" {
-				continue
-			}
-			parts := strings.SplitN(line, "=", 2)
-			if len(parts) == 2 {
-				key := strings.TrimSpace(parts[0])
-				val := strings.TrimSpace(strings.Trim(parts[1], "\""))
-				k8s.Labels[key] = val
-				// Add as tag with k8s.label. prefix
-				k8s.Tags["k8s.label."+key] = val
-			}
-		}
-	}
+	// Labels and annotations mounted by a downwardAPI volume
+	applyPodInfo(k8s, opts)
 
 	return k8s
 }
@@ -0,0 +1,137 @@
+package detection
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseRedisConfig(t *testing.T) {
+	got := parseRedisConfig("testdata/redis.conf")
+	if got != "/var/log/redis/redis-server.log" {
+		t.Errorf("Expected redis logfile, got %q", got)
+	}
+
+	stdout := filepath.Join(t.TempDir(), "redis.conf")
+	if err := os.WriteFile(stdout, []byte("port 6379\nlogfile \"\"\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if got := parseRedisConfig(stdout); got != "" {
+		t.Errorf("Expected no logfile for stdout logging, got %q", got)
+	}
+}
+
+func TestParseMongoConfig(t *testing.T) {
+	got := parseMongoConfig("testdata/mongod.conf")
+	if got != "/var/log/mongodb/mongod.log" {
+		t.Errorf("Expected mongod log path, got %q", got)
+	}
+
+	dir := t.TempDir()
+	legacy := filepath.Join(dir, "legacy.conf")
+	if err := os.WriteFile(legacy, []byte("dbpath=/data/db\nlogpath=/var/log/mongo.log\nfork=true\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if got := parseMongoConfig(legacy); got != "/var/log/mongo.log" {
+		t.Errorf("Expected legacy logpath, got %q", got)
+	}
+
+	syslog := filepath.Join(dir, "syslog.conf")
+	if err := os.WriteFile(syslog, []byte("systemLog:\n  destination: syslog\n  path: /ignored.log\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if got := parseMongoConfig(syslog); got != "" {
+		t.Errorf("Expected no path for syslog destination, got %q", got)
+	}
+}
+
+func TestParseNginxConfigFollowsIncludes(t *testing.T) {
+	paths, labels := parseNginxConfig("testdata/nginx/nginx.conf")
+
+	want := []string{
+		"/var/log/nginx/error.log",
+		"/var/log/nginx/access.log",
+		"/var/log/nginx/api.access.log",
+		"/var/log/nginx/api.error.log",
+		"/var/log/nginx/api.ssl.log",
+		"/var/log/shop/access.log",
+	}
+	if len(paths) != len(want) {
+		t.Fatalf("Expected paths %v, got %v", want, paths)
+	}
+	for i := range want {
+		if paths[i] != want[i] {
+			t.Errorf("Expected paths %v, got %v", want, paths)
+			break
+		}
+	}
+
+	wantLabels := map[string]string{
+		"/var/log/nginx/api.access.log": "api.example.com",
+		"/var/log/nginx/api.error.log":  "api.example.com",
+		"/var/log/nginx/api.ssl.log":    "api.example.com", // From a snippet included in the server block
+		"/var/log/shop/access.log":      "shop.example.com",
+	}
+	if len(labels) != len(wantLabels) {
+		t.Errorf("Expected labels %v, got %v", wantLabels, labels)
+	}
+	for path, name := range wantLabels {
+		if labels[path] != name {
+			t.Errorf("Expected %s to be labelled %q, got %q", path, name, labels[path])
+		}
+	}
+}
+
+func TestParseNginxConfigStopsIncludeLoops(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nginx.conf")
+	if err := os.WriteFile(path, []byte("include nginx.conf;\naccess_log /var/log/nginx/loop.log;\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	paths, _ := parseNginxConfig(path)
+	if len(paths) != 1 || paths[0] != "/var/log/nginx/loop.log" {
+		t.Errorf("Expected the log once, got %v", paths)
+	}
+}
+
+func TestAppendServiceLogFiles(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "redis", "redis-server.log")
+	if err := os.MkdirAll(filepath.Dir(logPath), 0o755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(logPath, []byte("1:M 26 Oct 2024 10:30:15.123 * Ready\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write log: %v", err)
+	}
+
+	services := []DetectedService{
+		{Name: "Redis", Type: "database", LogPaths: []string{logPath, filepath.Join(dir, "missing.log")}},
+	}
+	files := appendServiceLogFiles(nil, services)
+	if len(files) != 1 {
+		t.Fatalf("Expected 1 log file, got %d", len(files))
+	}
+	if files[0].Source != "Redis" || files[0].SuggestedFormat != "generic" || !files[0].Readable {
+		t.Errorf("Unexpected log file: %+v", files[0])
+	}
+
+	// Already discovered paths are not duplicated
+	if again := appendServiceLogFiles(files, services); len(again) != 1 {
+		t.Errorf("Expected no duplicates, got %d files", len(again))
+	}
+
+	// Discovered files are labelled with the service and server_name
+	discovered := []LogFile{{Path: logPath}}
+	nginx := []DetectedService{{Name: "Nginx", LogPaths: []string{logPath}, LogLabels: map[string]string{logPath: "api.example.com"}}}
+	if got := appendServiceLogFiles(discovered, nginx); got[0].Source != "Nginx – api.example.com" {
+		t.Errorf("Expected labelled source, got %q", got[0].Source)
+	}
+}
+
+func TestSuggestLogFormatDatastores(t *testing.T) {
+	if got := suggestLogFormat("/var/log/mongodb/mongod.log"); got != "json" {
+		t.Errorf("Expected json for mongodb, got %s", got)
+	}
+	if got := suggestLogFormat("/var/log/redis/redis-server.log"); got != "generic" {
+		t.Errorf("Expected generic for redis, got %s", got)
+	}
+}
@@ -0,0 +1,119 @@
+package detection
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DefaultPodInfoDir is where pod specs conventionally mount the downward API
+// volume holding the labels and annotations files.
+const DefaultPodInfoDir = "/etc/podinfo"
+
+// DefaultPodLabels are the pod labels added as tags when no allowlist is
+// configured: the recommended app.kubernetes.io labels and the common "app".
+var DefaultPodLabels = []string{
+	"app",
+	"app.kubernetes.io/name",
+	"app.kubernetes.io/instance",
+	"app.kubernetes.io/version",
+	"app.kubernetes.io/component",
+	"app.kubernetes.io/part-of",
+}
+
+// PodInfoOptions selects what is read from the downward API volume. Keys
+// ending in "*" match every key with that prefix.
+type PodInfoOptions struct {
+	Dir         string   // Directory holding the labels and annotations files
+	Labels      []string // Label keys added as k8s.label.<key> tags
+	Annotations []string // Annotation keys added as k8s.annotation.<key> tags
+}
+
+// readPodInfoFile parses a downward API file of key="value" lines. Values
+// are quoted and escaped like Go strings.
+func readPodInfoFile(path string) (map[string]string, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	values := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		key, raw, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		key, raw = strings.TrimSpace(key), strings.TrimSpace(raw)
+		value, err := strconv.Unquote(raw)
+		if err != nil {
+			value = strings.Trim(raw, `"`)
+		}
+		values[key] = value
+	}
+	return values, true
+}
+
+// selectKeys returns the entries of values whose key matches allow.
+func selectKeys(values map[string]string, allow []string) map[string]string {
+	selected := make(map[string]string)
+	for key, value := range values {
+		for _, pattern := range allow {
+			if prefix, ok := strings.CutSuffix(pattern, "*"); (ok && strings.HasPrefix(key, prefix)) || key == pattern {
+				selected[key] = value
+				break
+			}
+		}
+	}
+	return selected
+}
+
+// ownerKind infers the kind of controller that created the pod from the
+// labels each controller stamps on its pods.
+func ownerKind(labels map[string]string) string {
+	switch {
+	case labels["pod-template-hash"] != "":
+		return "ReplicaSet"
+	case labels["statefulset.kubernetes.io/pod-name"] != "":
+		return "StatefulSet"
+	case labels["controller-revision-hash"] != "" && labels["pod-template-generation"] != "":
+		return "DaemonSet"
+	case labels["batch.kubernetes.io/job-name"] != "" || labels["job-name"] != "":
+		return "Job"
+	}
+	return ""
+}
+
+// applyPodInfo merges the allowed labels and annotations from the downward
+// API volume, and the owner kind inferred from all labels, into k8s.
+func applyPodInfo(k8s *KubernetesMetadata, opts PodInfoOptions) {
+	dir := opts.Dir
+	if dir == "" {
+		dir = DefaultPodInfoDir
+	}
+
+	if labels, ok := readPodInfoFile(filepath.Join(dir, "labels")); ok {
+		if kind := ownerKind(labels); kind != "" {
+			k8s.OwnerKind = kind
+			k8s.Tags["k8s.owner.kind"] = kind
+		}
+		for key, value := range selectKeys(labels, opts.Labels) {
+			k8s.Labels[key] = value
+			k8s.Tags["k8s.label."+key] = value
+		}
+	}
+	if annotations, ok := readPodInfoFile(filepath.Join(dir, "annotations")); ok {
+		for key, value := range selectKeys(annotations, opts.Annotations) {
+			k8s.Annotations[key] = value
+			k8s.Tags["k8s.annotation."+key] = value
+		}
+	}
+}
+
+// hasPodInfo reports whether a downward API volume is mounted at dir.
+func hasPodInfo(dir string) bool {
+	if dir == "" {
+		dir = DefaultPodInfoDir
+	}
+	_, err := os.Stat(filepath.Join(dir, "labels"))
+	return err == nil
+}
@@ -0,0 +1,69 @@
+package detection
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectKubernetesReadsPodInfo(t *testing.T) {
+	dir := t.TempDir()
+	labels := `app.kubernetes.io/name="checkout"
+app.kubernetes.io/version="1.4.2"
+pod-template-hash="7d9f8c6b5"
+team="payments"
+`
+	annotations := `kubectl.kubernetes.io/last-applied-configuration="{\"apiVersion\":\"v1\"}"
+oncall="payments-primary"
+`
+	if err := os.WriteFile(filepath.Join(dir, "labels"), []byte(labels), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "annotations"), []byte(annotations), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("POD_NAME", "checkout-7d9f8c6b5-x2x4q")
+	t.Setenv("POD_NAMESPACE", "shop")
+
+	k8s := DetectKubernetes(PodInfoOptions{
+		Dir:         dir,
+		Labels:      []string{"app.kubernetes.io/*"},
+		Annotations: []string{"oncall"},
+	})
+	if !k8s.InCluster {
+		t.Fatal("Expected a mounted downward API volume to count as in-cluster")
+	}
+
+	want := map[string]string{
+		"k8s.pod.name":                        "checkout-7d9f8c6b5-x2x4q",
+		"k8s.namespace":                       "shop",
+		"k8s.label.app.kubernetes.io/name":    "checkout",
+		"k8s.label.app.kubernetes.io/version": "1.4.2",
+		"k8s.annotation.oncall":               "payments-primary",
+		"k8s.owner.kind":                      "ReplicaSet",
+	}
+	for k, v := range want {
+		if k8s.Tags[k] != v {
+			t.Errorf("Expected tag %s=%q, got %q", k, v, k8s.Tags[k])
+		}
+	}
+	for _, k := range []string{"k8s.label.team", "k8s.label.pod-template-hash", "k8s.annotation.kubectl.kubernetes.io/last-applied-configuration"} {
+		if _, ok := k8s.Tags[k]; ok {
+			t.Errorf("Expected %s left out by the allowlist", k)
+		}
+	}
+}
+
+func TestOwnerKind(t *testing.T) {
+	cases := map[string]map[string]string{
+		"StatefulSet": {"statefulset.kubernetes.io/pod-name": "db-0", "controller-revision-hash": "db-5f"},
+		"DaemonSet":   {"controller-revision-hash": "agent-6c", "pod-template-generation": "3"},
+		"Job":         {"batch.kubernetes.io/job-name": "migrate"},
+		"":            {"app": "standalone"},
+	}
+	for want, labels := range cases {
+		if got := ownerKind(labels); got != want {
+			t.Errorf("Expected %q for %v, got %q", want, labels, got)
+		}
+	}
+}
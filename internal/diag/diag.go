@@ -9,15 +9,102 @@ import (
 type Snapshot struct {
 	CollectedAt       time.Time `json:"collected_at"`
 	InMemoryQueue     int       `json:"in_memory_queue"`
+	BufferCapacity    int       `json:"buffer_capacity"`   // buffer_size
+	BufferHighWater   int       `json:"buffer_high_water"` // Most events buffered at once since start
 	PersistedQueue    int       `json:"persisted_queue"`
 	DeadLetterQueue   int       `json:"dead_letter_queue"`
-	QueueLength       int       `json:"queue_length"`
+	QueueLength       int       `json:"queue_length"` // In-memory plus persisted events
 	LastSuccessAt     time.Time `json:"last_success_at"`
 	LastFailureAt     time.Time `json:"last_failure_at"`
 	LastError         string    `json:"last_error"`
+	LastErrorCategory string    `json:"last_error_category,omitempty"` // One of the Error* categories, when known
 	TotalEventsSent   int64     `json:"total_events_sent"`
 	TotalEventsFailed int64     `json:"total_events_failed"`
 	ThroughputPerMin  float64   `json:"throughput_per_min"`
+
+	// Events and age of the oldest batch in the on-disk queues; the batch
+	// counts are PersistedQueue and DeadLetterQueue
+	PersistedEvents         int     `json:"persisted_events"`
+	PersistedOldestSeconds  float64 `json:"persisted_oldest_age_seconds"`
+	DeadLetterEvents        int     `json:"dead_letter_events"`
+	DeadLetterOldestSeconds float64 `json:"dead_letter_oldest_age_seconds"`
+	DeadLetterLastError     string  `json:"dead_letter_last_error,omitempty"` // Why the latest batch was dead-lettered
+
+	// Pending batches the configured queue encryption key cannot decrypt;
+	// they are skipped until the right key is set
+	UndecryptableBatches int    `json:"undecryptable_batches,omitempty"`
+	QueueKeyError        string `json:"queue_key_error,omitempty"`
+
+	// Offset of the API server clock from the local clock (server minus local)
+	ClockSkewMillis  int64 `json:"clock_skew_ms"`
+	ClockSkewWarning bool  `json:"clock_skew_warning"`
+
+	// Aggregates over the forwarder's recent requests
+	Delivery *DeliveryStats `json:"delivery,omitempty"`
+
+	// Events dropped by limits before buffering, by reason (min_level,
+	// event_type, rate_limit, proxy_backpressure), and the rate over the
+	// last minute
+	DroppedEvents map[string]int64 `json:"dropped_events,omitempty"`
+	DropsPerMin   float64          `json:"drops_per_min,omitempty"`
+
+	// Events truncated by delivery.max_event_bytes before sending
+	OversizedEvents int64 `json:"oversized_events,omitempty"`
+
+	// StatsD tag keys whose values were masked by metrics.max_tag_cardinality
+	SuppressedTagKeys int64 `json:"suppressed_tag_keys,omitempty"`
+
+	// Scrubbing rules that overran scrubbing.rule_budget on consecutive
+	// events and now redact their fields wholesale instead of matching
+	TrippedScrubRules []string `json:"tripped_scrub_rules,omitempty"`
+
+	// Events matched per routing rule ("default" when no rule matched)
+	RoutedEvents map[string]int64 `json:"routed_events,omitempty"`
+
+	// Per log source counters, keyed by path
+	Sources map[string]SourceStats `json:"sources,omitempty"`
+}
+
+// SourceStats tracks reading progress for a tailed log source.
+type SourceStats struct {
+	LinesRead     int64 `json:"lines_read"`
+	PossibleLoss  int64 `json:"possible_loss"`  // Rotations that discarded unread data
+	SkippedBytes  int64 `json:"skipped_bytes"`  // Estimated bytes lost to those rotations
+	SampledOut    int64 `json:"sampled_out"`    // Events dropped by logs[].sample_rates
+	ParseFailures int64 `json:"parse_failures"` // Lines that did not match logs[].format
+
+	SpansSampledOut int64 `json:"spans_sampled_out,omitempty"` // Spans dropped by span_sample_rate
+}
+
+// DeliveryStats summarizes the forwarder's recent requests.
+type DeliveryStats struct {
+	Requests         int     `json:"requests"`
+	P50LatencyMillis int64   `json:"p50_latency_ms"`
+	P95LatencyMillis int64   `json:"p95_latency_ms"`
+	AvgPayloadBytes  int     `json:"avg_payload_bytes"` // On the wire
+	CompressionRatio float64 `json:"compression_ratio"` // Uncompressed over sent bytes
+}
+
+// Categories of delivery failures, so an invalid API key can be told apart
+// from an outage at a glance.
+const (
+	ErrorAuth        = "auth"         // API key rejected (401/403)
+	ErrorRateLimited = "rate_limited" // 429
+	ErrorNetwork     = "network"      // No response: DNS, connection or timeout
+	ErrorServer      = "server"       // 5xx
+	ErrorClient      = "client"       // Other 4xx, such as a wrong api_endpoint
+	ErrorPayload     = "payload"      // Batch rejected as invalid or too large
+)
+
+// QueueStats describes an on-disk queue.
+type QueueStats struct {
+	Batches   int
+	Events    int
+	OldestAge time.Duration // Zero when the queue is empty
+	LastError string        // Most recent recorded delivery failure, if any
+
+	Undecryptable int    // Batches skipped for a missing or wrong encryption key
+	KeyError      string // Why they cannot be decrypted
 }
 
 // State tracks runtime diagnostics.
@@ -25,6 +112,7 @@ type State struct {
 	mu       sync.RWMutex
 	snapshot Snapshot
 	history  []sendSample
+	drops    []sendSample
 }
 
 type sendSample struct {
@@ -45,16 +133,53 @@ func Global() *State {
 func (s *State) Snapshot() Snapshot {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return s.snapshot
+	snap := s.snapshot
+	if s.snapshot.Delivery != nil {
+		delivery := *s.snapshot.Delivery
+		snap.Delivery = &delivery
+	}
+	if s.snapshot.DroppedEvents != nil {
+		snap.DroppedEvents = make(map[string]int64, len(s.snapshot.DroppedEvents))
+		for reason, count := range s.snapshot.DroppedEvents {
+			snap.DroppedEvents[reason] = count
+		}
+		cutoff := time.Now().Add(-1 * time.Minute)
+		for _, sample := range s.drops {
+			if sample.at.After(cutoff) {
+				snap.DropsPerMin += float64(sample.count)
+			}
+		}
+	}
+	snap.TrippedScrubRules = append([]string(nil), s.snapshot.TrippedScrubRules...)
+	if s.snapshot.RoutedEvents != nil {
+		snap.RoutedEvents = make(map[string]int64, len(s.snapshot.RoutedEvents))
+		for rule, count := range s.snapshot.RoutedEvents {
+			snap.RoutedEvents[rule] = count
+		}
+	}
+	if s.snapshot.Sources != nil {
+		snap.Sources = make(map[string]SourceStats, len(s.snapshot.Sources))
+		for source, stats := range s.snapshot.Sources {
+			snap.Sources[source] = stats
+		}
+	}
+	return snap
 }
 
 // SetQueueState records the current queue lengths.
-func (s *State) SetQueueState(inMemory, persisted, deadLetter int) {
+func (s *State) SetQueueState(inMemory int, persisted, deadLetter QueueStats) {
 	s.mu.Lock()
 	s.snapshot.InMemoryQueue = inMemory
-	s.snapshot.PersistedQueue = persisted
-	s.snapshot.DeadLetterQueue = deadLetter
-	total := inMemory + persisted
+	s.snapshot.PersistedQueue = persisted.Batches
+	s.snapshot.PersistedEvents = persisted.Events
+	s.snapshot.PersistedOldestSeconds = persisted.OldestAge.Seconds()
+	s.snapshot.DeadLetterQueue = deadLetter.Batches
+	s.snapshot.DeadLetterEvents = deadLetter.Events
+	s.snapshot.DeadLetterOldestSeconds = deadLetter.OldestAge.Seconds()
+	s.snapshot.DeadLetterLastError = deadLetter.LastError
+	s.snapshot.UndecryptableBatches = persisted.Undecryptable
+	s.snapshot.QueueKeyError = persisted.KeyError
+	total := inMemory + persisted.Events
 	if total < 0 {
 		total = 0
 	}
@@ -63,12 +188,148 @@ func (s *State) SetQueueState(inMemory, persisted, deadLetter int) {
 	s.mu.Unlock()
 }
 
+// SetBufferState records the buffer's capacity and the peak it has reached.
+func (s *State) SetBufferState(capacity, highWater int) {
+	s.mu.Lock()
+	s.snapshot.BufferCapacity = capacity
+	s.snapshot.BufferHighWater = highWater
+	s.mu.Unlock()
+}
+
+// SetClockSkew records the measured clock offset and whether it exceeds the warning threshold.
+func (s *State) SetClockSkew(offset time.Duration, exceeded bool) {
+	s.mu.Lock()
+	s.snapshot.ClockSkewMillis = offset.Milliseconds()
+	s.snapshot.ClockSkewWarning = exceeded
+	s.mu.Unlock()
+}
+
+// SetDeliveryStats records aggregates over recent delivery requests.
+func (s *State) SetDeliveryStats(stats DeliveryStats) {
+	s.mu.Lock()
+	s.snapshot.Delivery = &stats
+	s.mu.Unlock()
+}
+
+// RecordRouting adds per-rule routing counts.
+func (s *State) RecordRouting(counts map[string]int) {
+	if len(counts) == 0 {
+		return
+	}
+	s.mu.Lock()
+	if s.snapshot.RoutedEvents == nil {
+		s.snapshot.RoutedEvents = make(map[string]int64, len(counts))
+	}
+	for rule, count := range counts {
+		s.snapshot.RoutedEvents[rule] += int64(count)
+	}
+	s.mu.Unlock()
+}
+
+// RecordDropped counts events dropped by limits for reason.
+func (s *State) RecordDropped(reason string, events int) {
+	now := time.Now().UTC()
+	s.mu.Lock()
+	if s.snapshot.DroppedEvents == nil {
+		s.snapshot.DroppedEvents = make(map[string]int64)
+	}
+	s.snapshot.DroppedEvents[reason] += int64(events)
+	// One sample per second keeps the history small at high drop rates
+	if n := len(s.drops); n > 0 && now.Sub(s.drops[n-1].at) < time.Second {
+		s.drops[n-1].count += events
+	} else {
+		s.drops = append(s.drops, sendSample{at: now, count: events})
+	}
+	cutoff := now.Add(-1 * time.Minute)
+	for len(s.drops) > 0 && !s.drops[0].at.After(cutoff) {
+		s.drops = s.drops[1:]
+	}
+	s.mu.Unlock()
+}
+
+// RecordOversized counts events truncated to fit delivery.max_event_bytes.
+func (s *State) RecordOversized(events int) {
+	s.mu.Lock()
+	s.snapshot.OversizedEvents += int64(events)
+	s.mu.Unlock()
+}
+
+// RecordSuppressedTag counts a tag key masked for exceeding its cardinality limit.
+func (s *State) RecordSuppressedTag() {
+	s.mu.Lock()
+	s.snapshot.SuppressedTagKeys++
+	s.mu.Unlock()
+}
+
+// RecordTrippedScrubRule records a scrubbing rule that now redacts its
+// fields wholesale for being too slow.
+func (s *State) RecordTrippedScrubRule(name string) {
+	s.mu.Lock()
+	s.snapshot.TrippedScrubRules = append(s.snapshot.TrippedScrubRules, name)
+	s.mu.Unlock()
+}
+
+// RecordLinesRead adds to the lines read from a log source.
+func (s *State) RecordLinesRead(source string, lines int64) {
+	s.mu.Lock()
+	stats := s.sourceLocked(source)
+	stats.LinesRead += lines
+	s.snapshot.Sources[source] = stats
+	s.mu.Unlock()
+}
+
+// RecordPossibleLoss counts a rotation of source that skipped unread bytes.
+func (s *State) RecordPossibleLoss(source string, skippedBytes int64) {
+	s.mu.Lock()
+	stats := s.sourceLocked(source)
+	stats.PossibleLoss++
+	stats.SkippedBytes += skippedBytes
+	s.snapshot.Sources[source] = stats
+	s.mu.Unlock()
+}
+
+// RecordSampledOut counts events from source dropped by level sampling.
+func (s *State) RecordSampledOut(source string, events int64) {
+	s.mu.Lock()
+	stats := s.sourceLocked(source)
+	stats.SampledOut += events
+	s.snapshot.Sources[source] = stats
+	s.mu.Unlock()
+}
+
+// RecordSpansSampledOut counts spans from source dropped by span sampling.
+// The proxy counts under "proxy".
+func (s *State) RecordSpansSampledOut(source string, spans int64) {
+	s.mu.Lock()
+	stats := s.sourceLocked(source)
+	stats.SpansSampledOut += spans
+	s.snapshot.Sources[source] = stats
+	s.mu.Unlock()
+}
+
+// RecordParseFailures counts lines from source that failed to parse.
+func (s *State) RecordParseFailures(source string, lines int64) {
+	s.mu.Lock()
+	stats := s.sourceLocked(source)
+	stats.ParseFailures += lines
+	s.snapshot.Sources[source] = stats
+	s.mu.Unlock()
+}
+
+func (s *State) sourceLocked(source string) SourceStats {
+	if s.snapshot.Sources == nil {
+		s.snapshot.Sources = make(map[string]SourceStats)
+	}
+	return s.snapshot.Sources[source]
+}
+
 // RecordSendSuccess updates metrics after a successful send.
 func (s *State) RecordSendSuccess(events int) {
 	now := time.Now().UTC()
 	s.mu.Lock()
 	s.snapshot.LastSuccessAt = now
 	s.snapshot.LastError = ""
+	s.snapshot.LastErrorCategory = ""
 	s.snapshot.TotalEventsSent += int64(events)
 	s.appendSampleLocked(now, events)
 	s.snapshot.CollectedAt = now
@@ -76,13 +337,15 @@ func (s *State) RecordSendSuccess(events int) {
 	s.mu.Unlock()
 }
 
-// RecordSendFailure tracks a failed send attempt.
-func (s *State) RecordSendFailure(err error, events int) {
+// RecordSendFailure tracks a failed send attempt; category is one of the
+// Error* categories, or empty when the error fits none.
+func (s *State) RecordSendFailure(err error, category string, events int) {
 	now := time.Now().UTC()
 	s.mu.Lock()
 	s.snapshot.LastFailureAt = now
 	if err != nil {
 		s.snapshot.LastError = err.Error()
+		s.snapshot.LastErrorCategory = category
 	}
 	if events > 0 {
 		s.snapshot.TotalEventsFailed += int64(events)
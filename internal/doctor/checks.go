@@ -0,0 +1,278 @@
+package doctor
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/config"
+	"github.com/yaat-app/sidecar/internal/logs"
+)
+
+// Free space below which a data directory fails or warns.
+const (
+	minFreeBytes  = 10 << 20
+	warnFreeBytes = 500 << 20
+)
+
+func checkConfig(cfg *config.Config) Result {
+	result := Result{Name: "Config", Status: Pass, Detail: "valid"}
+	if cfg.SourcePath != "" {
+		result.Detail = cfg.SourcePath + " is valid"
+	}
+	if err := cfg.Validate(); err != nil {
+		result.Status = Fail
+		result.Detail = err.Error()
+		result.Hint = "Fix the fields listed, or rerun yaat-sidecar --setup"
+		return result
+	}
+	if cfg.APIKey == "" {
+		result.Status = Warn
+		result.Detail += " (local-only: no api_key, events are not sent to YAAT)"
+		result.Hint = "Set api_key to deliver events"
+	}
+	return result
+}
+
+// checkLogFiles opens every configured log file the way the tailer will.
+func checkLogFiles(sources []config.LogConfig) []Result {
+	var results []Result
+	for _, source := range sources {
+		if source.Format == "journald" || source.Path == "" {
+			continue
+		}
+		result := Result{Name: "Log file " + source.Path, Status: Pass, Detail: "readable"}
+		f, err := os.Open(source.Path)
+		switch {
+		case os.IsNotExist(err):
+			result.Status = Warn
+			result.Detail = "does not exist yet"
+			result.Hint = "The sidecar waits for it to be created; check the path if it never is"
+		case os.IsPermission(err):
+			result.Status = Fail
+			result.Detail = "permission denied"
+			result.Hint = fmt.Sprintf("Grant the sidecar's user read access, e.g. sudo setfacl -m u:%s:r %s", currentUser(), source.Path)
+		case err != nil:
+			result.Status = Fail
+			result.Detail = err.Error()
+		default:
+			info, statErr := f.Stat()
+			f.Close()
+			if statErr == nil && info.IsDir() {
+				result.Status = Fail
+				result.Detail = "is a directory"
+				result.Hint = "Point path at a file inside it"
+			}
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func currentUser() string {
+	if user := os.Getenv("USER"); user != "" {
+		return user
+	}
+	return strconv.Itoa(os.Getuid())
+}
+
+// checkJournald reports whether journald can be read, when a source uses it.
+func checkJournald(sources []config.LogConfig) Result {
+	result := Result{Name: "Journald", Status: Skip, Detail: "not configured"}
+	for _, source := range sources {
+		if source.Format != "journald" {
+			continue
+		}
+		if err := logs.JournaldAvailable(); err != nil {
+			result.Status = Fail
+			result.Detail = err.Error()
+			result.Hint = "Journald needs Linux with systemd; add the sidecar's user to the systemd-journal group"
+			return result
+		}
+		result.Status = Pass
+		result.Detail = "available via " + logs.JournaldBackend
+		return result
+	}
+	return result
+}
+
+// checkPorts binds every listener the configuration enables and releases it.
+func checkPorts(cfg *config.Config, healthAddr string) []Result {
+	var results []Result
+	if cfg.Proxy.Enabled {
+		results = append(results, checkListen("Proxy port", "tcp", fmt.Sprintf(":%d", cfg.Proxy.ListenPort)))
+	}
+	if cfg.Metrics.StatsD.Enabled {
+		results = append(results, checkListen("StatsD port", "udp", cfg.Metrics.StatsD.ListenAddr))
+	}
+	if healthAddr != "" {
+		results = append(results, checkListen("Health port", "tcp", healthAddr))
+	}
+	if cfg.LocalAPI.Enabled {
+		results = append(results, checkListen("Local API port", "tcp", cfg.LocalAPI.ListenAddr))
+	}
+	return results
+}
+
+func checkListen(name, network, addr string) Result {
+	result := Result{Name: name, Status: Pass, Detail: fmt.Sprintf("%s %s is free", network, addr)}
+	var err error
+	if network == "udp" {
+		var conn net.PacketConn
+		if conn, err = net.ListenPacket(network, addr); err == nil {
+			conn.Close()
+		}
+	} else {
+		var ln net.Listener
+		if ln, err = net.Listen(network, addr); err == nil {
+			ln.Close()
+		}
+	}
+	if err != nil {
+		result.Status = Fail
+		result.Detail = fmt.Sprintf("cannot bind %s %s: %v", network, addr, err)
+		result.Hint = "Another process holds it (a running sidecar? see lsof -i " + network + addr + "), or pick another port"
+		if errors.Is(err, os.ErrPermission) {
+			result.Hint = "Ports below 1024 need root or CAP_NET_BIND_SERVICE; pick a higher port"
+		}
+	}
+	return result
+}
+
+// checkEndpoint resolves, connects to and (for https) completes a TLS
+// handshake with the ingest endpoint, or the proxy when one is configured.
+func checkEndpoint(ctx context.Context, cfg *config.Config) Result {
+	result := Result{Name: "API endpoint", Status: Pass}
+	if cfg.APIKey == "" {
+		result.Status = Skip
+		result.Detail = "local-only mode"
+		return result
+	}
+	endpoint, err := url.Parse(cfg.APIEndpoint)
+	if err != nil || endpoint.Host == "" {
+		result.Status = Fail
+		result.Detail = fmt.Sprintf("invalid api_endpoint %q", cfg.APIEndpoint)
+		result.Hint = "Use a full URL such as https://yaat.io/api/v1/ingest"
+		return result
+	}
+
+	target, via := endpoint, ""
+	req := &http.Request{URL: endpoint}
+	if proxyURL, err := http.ProxyFromEnvironment(req); err == nil && proxyURL != nil {
+		target, via = proxyURL, " via proxy "+proxyURL.Host
+	}
+	host, port := target.Hostname(), target.Port()
+	if port == "" {
+		port = "80"
+		if target.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	start := time.Now()
+	if _, err := net.DefaultResolver.LookupHost(ctx, host); err != nil {
+		result.Status = Fail
+		result.Detail = fmt.Sprintf("DNS lookup of %s failed: %v", host, err)
+		result.Hint = "Check /etc/resolv.conf and that the host name is spelled correctly"
+		return result
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		result.Status = Fail
+		result.Detail = fmt.Sprintf("cannot connect to %s:%s: %v", host, port, err)
+		result.Hint = "Allow outbound traffic to it in the firewall, or set HTTPS_PROXY"
+		return result
+	}
+	defer conn.Close()
+
+	if via == "" && target.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: host})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			result.Status = Fail
+			result.Detail = fmt.Sprintf("TLS handshake with %s failed: %v", host, err)
+			result.Hint = "A wrong system clock or a TLS-intercepting proxy breaks certificate checks; check both"
+			return result
+		}
+	}
+	result.Detail = fmt.Sprintf("%s reachable%s in %v", endpoint.Host, via, time.Since(start).Truncate(time.Millisecond))
+	return result
+}
+
+// checkDirectory verifies dir (or the directory that will hold it) is
+// writable and has free space.
+func checkDirectory(name, dir string) Result {
+	result := Result{Name: name, Status: Pass}
+
+	// The sidecar creates missing directories; check the nearest existing one
+	existing := dir
+	for {
+		info, err := os.Stat(existing)
+		if err == nil {
+			if !info.IsDir() {
+				result.Status = Fail
+				result.Detail = existing + " is not a directory"
+				return result
+			}
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			break
+		}
+		existing = parent
+	}
+
+	probe, err := os.CreateTemp(existing, ".yaat-doctor-*")
+	if err != nil {
+		result.Status = Fail
+		result.Detail = fmt.Sprintf("%s is not writable: %v", existing, err)
+		result.Hint = "Give the sidecar's user write access, or move it with YAAT_QUEUE_DIR / analytics.database_path"
+		return result
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+
+	result.Detail = dir + " is writable"
+	free, ok := freeBytes(existing)
+	if !ok {
+		return result
+	}
+	result.Detail += fmt.Sprintf(", %s free", formatBytes(free))
+	switch {
+	case free < minFreeBytes:
+		result.Status = Fail
+		result.Hint = "Free disk space; queued events cannot be persisted on a full disk"
+	case free < warnFreeBytes:
+		result.Status = Warn
+		result.Hint = "Free disk space before an outage fills the queue"
+	}
+	return result
+}
+
+func parentDir(path string) string {
+	if path == "" {
+		return "."
+	}
+	return filepath.Dir(path)
+}
+
+func formatBytes(n uint64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	default:
+		return fmt.Sprintf("%d KB", n>>10)
+	}
+}
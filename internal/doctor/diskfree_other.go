@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package doctor
+
+// freeBytes is not implemented on this platform; only writability is checked.
+func freeBytes(path string) (uint64, bool) {
+	return 0, false
+}
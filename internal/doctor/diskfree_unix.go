@@ -0,0 +1,15 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package doctor
+
+import "syscall"
+
+// freeBytes returns the space available to unprivileged users at path.
+func freeBytes(path string) (uint64, bool) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, false
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), true
+}
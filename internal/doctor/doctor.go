@@ -0,0 +1,137 @@
+// Package doctor checks a configuration against the host it runs on: files
+// the sidecar must read, ports it must bind, directories it writes to and
+// whether the ingest endpoint is reachable. --doctor prints the results,
+// the daemon logs the ones that fail at startup and the dashboard's Test
+// view lists them.
+package doctor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/config"
+	"github.com/yaat-app/sidecar/internal/queue"
+)
+
+// Status is the outcome of one check.
+type Status int
+
+const (
+	Pass Status = iota
+	Warn        // Works, but likely not as intended
+	Fail        // The sidecar will not work as configured
+	Skip        // Not applicable to this configuration
+)
+
+func (s Status) String() string {
+	switch s {
+	case Pass:
+		return "pass"
+	case Warn:
+		return "warn"
+	case Fail:
+		return "fail"
+	default:
+		return "skip"
+	}
+}
+
+// Result describes one check.
+type Result struct {
+	Name   string
+	Status Status
+	Detail string
+	Hint   string // How to fix a warning or failure
+}
+
+// Options tunes which checks Run performs.
+type Options struct {
+	HealthAddr string // Health endpoint address, "" when disabled
+	QueueDir   string // Persistent queue directory (default queue.DefaultDir)
+
+	// SkipPorts leaves out bind checks, for when the sidecar already holds the ports
+	SkipPorts bool
+	// SkipNetwork leaves out the ingest endpoint reachability check
+	SkipNetwork bool
+
+	Timeout time.Duration // For the endpoint check (default 5s)
+}
+
+const defaultTimeout = 5 * time.Second
+
+// Run performs every applicable check on cfg.
+func Run(cfg *config.Config, opts Options) []Result {
+	if opts.QueueDir == "" {
+		opts.QueueDir = queue.DefaultDir()
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultTimeout
+	}
+
+	results := []Result{checkConfig(cfg)}
+	results = append(results, checkLogFiles(cfg.Logs)...)
+	if journald := checkJournald(cfg.Logs); journald.Status != Skip {
+		results = append(results, journald)
+	}
+	if !opts.SkipPorts {
+		results = append(results, checkPorts(cfg, opts.HealthAddr)...)
+	}
+	if !opts.SkipNetwork {
+		ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+		results = append(results, checkEndpoint(ctx, cfg))
+		cancel()
+	}
+	if cfg.APIKey != "" {
+		results = append(results, checkDirectory("Queue directory", opts.QueueDir))
+	}
+	if cfg.Analytics.Enabled {
+		results = append(results, checkDirectory("Analytics directory", parentDir(cfg.Analytics.DatabasePath)))
+	}
+	return results
+}
+
+// Failed reports whether any check failed.
+func Failed(results []Result) bool {
+	for _, r := range results {
+		if r.Status == Fail {
+			return true
+		}
+	}
+	return false
+}
+
+// Print writes a ✓/✗ report with a hint under every warning and failure.
+func Print(w io.Writer, results []Result) {
+	width := 0
+	for _, r := range results {
+		if len(r.Name) > width {
+			width = len(r.Name)
+		}
+	}
+
+	counts := make(map[Status]int)
+	for _, r := range results {
+		counts[r.Status]++
+		fmt.Fprintf(w, "%s %-*s  %s\n", symbol(r.Status), width, r.Name, r.Detail)
+		if r.Hint != "" && (r.Status == Warn || r.Status == Fail) {
+			fmt.Fprintf(w, "  %s  → %s\n", strings.Repeat(" ", width), r.Hint)
+		}
+	}
+	fmt.Fprintf(w, "\n%d passed, %d warnings, %d failed\n", counts[Pass], counts[Warn], counts[Fail])
+}
+
+func symbol(s Status) string {
+	switch s {
+	case Pass:
+		return "✓"
+	case Warn:
+		return "!"
+	case Fail:
+		return "✗"
+	default:
+		return "-"
+	}
+}
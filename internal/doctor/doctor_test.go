@@ -0,0 +1,187 @@
+package doctor
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yaat-app/sidecar/internal/config"
+)
+
+func TestCheckConfig(t *testing.T) {
+	cfg := &config.Config{ServiceName: "api", APIKey: "key", OrganizationID: "org", APIEndpoint: "https://yaat.io/api/v1/ingest", Environment: "production"}
+	if result := checkConfig(cfg); result.Status == Fail {
+		t.Errorf("Expected valid config to pass, got %v: %s", result.Status, result.Detail)
+	}
+
+	cfg.ServiceName = ""
+	result := checkConfig(cfg)
+	if result.Status != Fail {
+		t.Errorf("Expected fail for missing service_name, got %v", result.Status)
+	}
+	if result.Hint == "" {
+		t.Error("Expected a hint for an invalid config")
+	}
+}
+
+func TestCheckLogFiles(t *testing.T) {
+	dir := t.TempDir()
+	readable := filepath.Join(dir, "app.log")
+	if err := os.WriteFile(readable, []byte("line\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	results := checkLogFiles([]config.LogConfig{
+		{Path: readable, Format: "json"},
+		{Path: filepath.Join(dir, "missing.log"), Format: "json"},
+		{Path: dir, Format: "json"},
+		{Format: "journald"},
+	})
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 results (journald skipped), got %d", len(results))
+	}
+	want := []Status{Pass, Warn, Fail}
+	for i, result := range results {
+		if result.Status != want[i] {
+			t.Errorf("%s: expected %v, got %v (%s)", result.Name, want[i], result.Status, result.Detail)
+		}
+	}
+}
+
+func TestCheckLogFilesPermissionDenied(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can read any file")
+	}
+	path := filepath.Join(t.TempDir(), "secret.log")
+	if err := os.WriteFile(path, nil, 0o000); err != nil {
+		t.Fatal(err)
+	}
+	results := checkLogFiles([]config.LogConfig{{Path: path, Format: "json"}})
+	if len(results) != 1 || results[0].Status != Fail {
+		t.Fatalf("Expected fail for unreadable file, got %+v", results)
+	}
+	if !strings.Contains(results[0].Hint, path) {
+		t.Errorf("Expected hint to name the file, got %q", results[0].Hint)
+	}
+}
+
+func TestCheckJournald(t *testing.T) {
+	result := checkJournald([]config.LogConfig{{Path: "/var/log/app.log", Format: "json"}})
+	if result.Status != Skip {
+		t.Errorf("Expected skip without a journald source, got %v", result.Status)
+	}
+
+	result = checkJournald([]config.LogConfig{{Format: "journald"}})
+	if result.Status != Pass && result.Status != Fail {
+		t.Errorf("Expected pass or fail with a journald source, got %v", result.Status)
+	}
+}
+
+func TestCheckPorts(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	cfg := &config.Config{}
+	results := checkPorts(cfg, ln.Addr().String())
+	if len(results) != 1 || results[0].Status != Fail {
+		t.Fatalf("Expected fail for a port in use, got %+v", results)
+	}
+
+	results = checkPorts(cfg, "127.0.0.1:0")
+	if len(results) != 1 || results[0].Status != Pass {
+		t.Fatalf("Expected pass for a free port, got %+v", results)
+	}
+
+	cfg.Metrics.StatsD.Enabled = true
+	cfg.Metrics.StatsD.ListenAddr = "127.0.0.1:0"
+	if results := checkPorts(cfg, ""); len(results) != 1 || results[0].Name != "StatsD port" {
+		t.Errorf("Expected only the statsd check, got %+v", results)
+	}
+}
+
+func TestCheckEndpoint(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	t.Setenv("HTTP_PROXY", "")
+	t.Setenv("HTTPS_PROXY", "")
+
+	ctx := context.Background()
+	cfg := &config.Config{APIEndpoint: "http://" + ln.Addr().String() + "/v1/ingest"}
+	if result := checkEndpoint(ctx, cfg); result.Status != Skip {
+		t.Errorf("Expected skip in local-only mode, got %v", result.Status)
+	}
+
+	cfg.APIKey = "key"
+	if result := checkEndpoint(ctx, cfg); result.Status != Pass {
+		t.Errorf("Expected pass for a listening endpoint, got %v: %s", result.Status, result.Detail)
+	}
+
+	addr := ln.Addr().String()
+	ln.Close()
+	cfg.APIEndpoint = "http://" + addr
+	if result := checkEndpoint(ctx, cfg); result.Status != Fail {
+		t.Errorf("Expected fail for a closed port, got %v", result.Status)
+	}
+
+	cfg.APIEndpoint = "not a url"
+	if result := checkEndpoint(ctx, cfg); result.Status != Fail {
+		t.Errorf("Expected fail for an invalid endpoint, got %v", result.Status)
+	}
+}
+
+func TestCheckDirectory(t *testing.T) {
+	dir := t.TempDir()
+	result := checkDirectory("Queue directory", filepath.Join(dir, "not", "created", "yet"))
+	if result.Status == Fail {
+		t.Errorf("Expected a missing directory under a writable parent to pass, got %s", result.Detail)
+	}
+
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if result := checkDirectory("Queue directory", file); result.Status != Fail {
+		t.Errorf("Expected fail for a regular file, got %v", result.Status)
+	}
+}
+
+func TestRunAndPrint(t *testing.T) {
+	cfg := &config.Config{ServiceName: "api", Environment: "production"}
+	results := Run(cfg, Options{SkipPorts: true, SkipNetwork: true})
+	if Failed(results) {
+		t.Errorf("Expected no failures, got %+v", results)
+	}
+
+	results = append(results, Result{Name: "Broken", Status: Fail, Detail: "bad", Hint: "fix it"})
+	if !Failed(results) {
+		t.Error("Expected Failed to report the failing check")
+	}
+
+	var out bytes.Buffer
+	Print(&out, results)
+	if !strings.Contains(out.String(), "✗ Broken") || !strings.Contains(out.String(), "→ fix it") {
+		t.Errorf("Expected failing check and hint in report, got:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "1 failed") {
+		t.Errorf("Expected summary line, got:\n%s", out.String())
+	}
+}
@@ -0,0 +1,75 @@
+// Package embedding holds what the sidecar binary hands to pkg/sidecar on top
+// of the public Config. sidecar.Option is a function of Options, so WithHooks
+// here yields an option only this module can build: the binary and embedders
+// share one Emitter without the binary's extras becoming part of the public
+// API.
+package embedding
+
+import (
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+	"github.com/yaat-app/sidecar/internal/forwarder"
+	"github.com/yaat-app/sidecar/internal/pipeline"
+	"github.com/yaat-app/sidecar/internal/queue"
+)
+
+// Options collects what the options passed to sidecar.New set.
+type Options struct {
+	Hooks *Hooks
+}
+
+// WithHooks returns a sidecar.Option wiring hooks into the Emitter.
+func WithHooks(hooks Hooks) func(*Options) {
+	return func(o *Options) { o.Hooks = &hooks }
+}
+
+// Hooks extends an Emitter with the binary's inputs and outputs.
+type Hooks struct {
+	// Forwarder replaces the delivery options derived from Config
+	Forwarder *forwarder.Options
+
+	Filter        func(buffer.Event) bool // Runs on every buffered event; see buffer.SetFilter
+	HighWatermark int                     // Buffered events that trigger an early flush (0 disables)
+
+	// Sinks are interfaces so the public package does not link DuckDB; leave
+	// them nil rather than setting a nil pointer
+	Analytics Analytics
+	Failures  FailureRecorder // Records batches that were never delivered
+	OTLP      Exporter
+
+	QueueRetention      time.Duration
+	DeadLetterRetention time.Duration
+
+	DeadLetterMaxRetries    int // 0 leaves dead-lettered batches alone
+	DeadLetterRetryInterval time.Duration
+
+	// Built receives the assembled parts before the pipeline starts, so the
+	// caller can wire inputs to the buffer or probe the API first.
+	Built func(Parts) error
+}
+
+// Analytics stores events locally; *analytics.Writer implements it.
+type Analytics interface {
+	Write(events []buffer.Event) error
+}
+
+// FailureRecorder stores batches whose delivery failed for good;
+// *analytics.FailureRecorder implements it.
+type FailureRecorder interface {
+	Record(events []buffer.Event, reason, deliveryErr string)
+}
+
+// Exporter sends events to an OpenTelemetry collector; *otlp.Exporter
+// implements it.
+type Exporter interface {
+	Send(events []buffer.Event) error
+}
+
+// Parts are the pieces behind an Emitter.
+type Parts struct {
+	Buffer    *buffer.Buffer
+	Forwarder *forwarder.Forwarder
+	Queue     *queue.Storage // nil when the queue is disabled or failed to open
+	Pipeline  *pipeline.Pipeline
+}
@@ -0,0 +1,79 @@
+package forwarder
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/diag"
+)
+
+const defaultClockSkewWarn = 30 * time.Second
+
+// clockSkew tracks the offset between the API server clock and the local
+// clock, measured from the Date header of successful responses.
+type clockSkew struct {
+	offset   atomic.Int64 // server minus local, in nanoseconds
+	measured atomic.Bool
+	warned   atomic.Bool
+}
+
+// observe updates the offset from a response received for a request sent at start.
+func (c *clockSkew) observe(resp *http.Response, start, end time.Time, warnAt time.Duration) {
+	serverTime, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return
+	}
+
+	// The server stamped the response somewhere during the round trip; the
+	// midpoint is the best local estimate. Date has one-second resolution.
+	local := start.Add(end.Sub(start) / 2)
+	offset := serverTime.Sub(local).Truncate(time.Second)
+
+	c.offset.Store(int64(offset))
+	c.measured.Store(true)
+
+	exceeded := warnAt > 0 && absDuration(offset) > warnAt
+	diag.Global().SetClockSkew(offset, exceeded)
+
+	if exceeded {
+		if !c.warned.Swap(true) {
+			logger.Warnf("Local clock differs from the API server by %v (threshold %v); event timestamps may be wrong", offset, warnAt)
+		}
+	} else if c.warned.Swap(false) {
+		logger.Infof("Clock skew back within threshold (%v)", offset)
+	}
+}
+
+// current returns the last measured offset, if any.
+func (c *clockSkew) current() (time.Duration, bool) {
+	if !c.measured.Load() {
+		return 0, false
+	}
+	return time.Duration(c.offset.Load()), true
+}
+
+// ClockSkew returns the last measured offset of the API server clock relative
+// to the local clock (positive when the local clock is behind).
+func (f *Forwarder) ClockSkew() (time.Duration, bool) {
+	return f.skew.current()
+}
+
+// now returns the time used to stamp outgoing events, corrected by the
+// measured skew when correction is enabled.
+func (f *Forwarder) now() time.Time {
+	now := time.Now().UTC()
+	if f.opts.ClockSkewCorrect {
+		if offset, ok := f.skew.current(); ok {
+			now = now.Add(offset)
+		}
+	}
+	return now
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
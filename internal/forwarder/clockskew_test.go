@@ -0,0 +1,113 @@
+package forwarder
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+	"github.com/yaat-app/sidecar/internal/diag"
+)
+
+// skewedClient answers every request with a Date header offset from local time
+// and captures the last decoded payload.
+func skewedClient(offset time.Duration, captured *[]map[string]interface{}) *http.Client {
+	return &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			var payload struct {
+				Events []map[string]interface{} `json:"events"`
+			}
+			if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+				return nil, err
+			}
+			*captured = payload.Events
+
+			header := make(http.Header)
+			header.Set("Date", time.Now().Add(offset).UTC().Format(http.TimeFormat))
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     header,
+				Body:       io.NopCloser(bytes.NewReader([]byte(`{"status":"ok"}`))),
+			}, nil
+		}),
+	}
+}
+
+func TestClockSkewMeasuredFromDateHeader(t *testing.T) {
+	var captured []map[string]interface{}
+	f := NewWithOptions("https://example.test/ingest", "key", Options{})
+	f.SetHTTPClient(skewedClient(2*time.Hour, &captured))
+
+	if _, ok := f.ClockSkew(); ok {
+		t.Fatal("Expected no skew before the first request")
+	}
+	if err := f.Send([]buffer.Event{{"service_name": "svc", "message": "hello"}}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	skew, ok := f.ClockSkew()
+	if !ok {
+		t.Fatal("Expected skew to be measured")
+	}
+	if skew < 2*time.Hour-2*time.Second || skew > 2*time.Hour+2*time.Second {
+		t.Errorf("Expected ~2h skew, got %v", skew)
+	}
+
+	snap := diag.Global().Snapshot()
+	if !snap.ClockSkewWarning {
+		t.Error("Expected clock skew warning flag in diagnostics")
+	}
+	if snap.ClockSkewMillis < (2*time.Hour - 2*time.Second).Milliseconds() {
+		t.Errorf("Expected skew in diagnostics, got %dms", snap.ClockSkewMillis)
+	}
+
+	// Without correction, received_at stays on the local clock
+	if err := f.Send([]buffer.Event{{"service_name": "svc", "message": "again"}}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	receivedAt, _ := time.Parse(time.RFC3339Nano, captured[0]["received_at"].(string))
+	if time.Since(receivedAt) > time.Minute || time.Since(receivedAt) < -time.Minute {
+		t.Errorf("Expected uncorrected received_at near local time, got %v", receivedAt)
+	}
+}
+
+func TestClockSkewCorrectionShiftsTimestamps(t *testing.T) {
+	var captured []map[string]interface{}
+	f := NewWithOptions("https://example.test/ingest", "key", Options{ClockSkewCorrect: true})
+	f.SetHTTPClient(skewedClient(-3*time.Hour, &captured))
+
+	// First send measures the skew, the second is corrected
+	if err := f.Send([]buffer.Event{{"service_name": "svc"}}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	explicit := "2024-10-26T10:30:15Z"
+	if err := f.Send([]buffer.Event{{"service_name": "svc"}, {"service_name": "svc", "timestamp": explicit}}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	expected := time.Now().Add(-3 * time.Hour)
+	for _, field := range []string{"received_at", "timestamp"} {
+		ts, err := time.Parse(time.RFC3339Nano, captured[0][field].(string))
+		if err != nil {
+			t.Fatalf("Invalid %s: %v", field, err)
+		}
+		if diff := ts.Sub(expected); diff > 5*time.Second || diff < -5*time.Second {
+			t.Errorf("Expected corrected %s near %v, got %v", field, expected, ts)
+		}
+	}
+	if captured[1]["timestamp"] != explicit {
+		t.Errorf("Expected explicit timestamp to be kept, got %v", captured[1]["timestamp"])
+	}
+
+	// A skew under the threshold clears the warning
+	f.SetHTTPClient(skewedClient(0, &captured))
+	if err := f.Send([]buffer.Event{{"service_name": "svc"}}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if diag.Global().Snapshot().ClockSkewWarning {
+		t.Error("Expected warning to clear once skew is within threshold")
+	}
+}
@@ -4,33 +4,69 @@ import (
 	"bytes"
 	"compress/gzip"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"math"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 
 	"github.com/yaat-app/sidecar/internal/buffer"
+	"github.com/yaat-app/sidecar/internal/diag"
+	"github.com/yaat-app/sidecar/internal/logging"
 )
 
+var logger = logging.New("Forwarder")
+
 // Options configures Forwarder behaviour.
 type Options struct {
 	BatchSize     int
 	Compress      bool
 	MaxBatchBytes int
+	// MaxEventBytes shrinks any event whose encoding is larger (0 disables);
+	// see shrinkEvent.
+	MaxEventBytes int
+	// MaxConcurrency bounds how many chunks of one Send are in flight at once
+	// (0 or 1 delivers sequentially).
+	MaxConcurrency int
+
+	// ClockSkewWarn logs a warning when the API server clock differs from the
+	// local clock by more than this (0 uses the 30s default).
+	ClockSkewWarn time.Duration
+	// ClockSkewCorrect shifts received_at and defaulted timestamps by the measured skew.
+	ClockSkewCorrect bool
+
+	// GroupByService chunks each service_name separately so a request never
+	// mixes services; ServiceKeys then picks the API key per chunk, falling
+	// back to the forwarder's key for services without an entry.
+	GroupByService bool
+	ServiceKeys    map[string]string
+
+	// Version is reported in the User-Agent header.
+	Version string
+
+	// GlobalTags are added to every event that lacks them, whichever input
+	// produced it. Event tags take priority. They are added after scrubbing,
+	// so pass them as scrubbing leaves them (see scrubber.Tags).
+	GlobalTags map[string]string
 }
 
 // Forwarder sends events to the YAAT API.
 type Forwarder struct {
 	apiEndpoint string
-	apiKey      string
+	keyMu       sync.RWMutex
+	apiKey      string // Guarded by keyMu; replaced by SetAPIKey
 	client      *http.Client
 	opts        Options
+	skew        clockSkew
+	requests    requestLog
+	hints       serverHints
+	userAgent   string
 }
 
 // TestReport captures the details of a connectivity test.
@@ -38,6 +74,10 @@ type TestReport struct {
 	Endpoint string
 	Events   []buffer.Event
 	Latency  time.Duration
+
+	// Offset of the API server clock from the local clock, when measured
+	ClockSkew         time.Duration
+	ClockSkewMeasured bool
 }
 
 func defaultOptions() Options {
@@ -62,6 +102,9 @@ func NewWithOptions(apiEndpoint, apiKey string, opts Options) *Forwarder {
 	if opts.MaxBatchBytes < 0 {
 		opts.MaxBatchBytes = defaults.MaxBatchBytes
 	}
+	if opts.ClockSkewWarn <= 0 {
+		opts.ClockSkewWarn = defaultClockSkewWarn
+	}
 
 	return &Forwarder{
 		apiEndpoint: apiEndpoint,
@@ -69,7 +112,8 @@ func NewWithOptions(apiEndpoint, apiKey string, opts Options) *Forwarder {
 		client: &http.Client{
 			Timeout: 30 * time.Second,
 		},
-		opts: opts,
+		opts:      opts,
+		userAgent: userAgent(opts.Version),
 	}
 }
 
@@ -82,6 +126,12 @@ func (f *Forwarder) SetHTTPClient(client *http.Client) {
 }
 
 // Send sends events to the YAAT API with retry logic.
+//
+// Events are split into chunks of at most BatchSize, or the smaller batch
+// size the API last suggested. With MaxConcurrency > 1
+// chunks are delivered in parallel; chunks are never ordered relative to each
+// other in either mode. When some chunks fail, the error is a *SendError
+// listing the events that were not delivered.
 func (f *Forwarder) Send(events []buffer.Event) error {
 	if len(events) == 0 {
 		return nil
@@ -91,92 +141,262 @@ func (f *Forwarder) Send(events []buffer.Event) error {
 	if err != nil {
 		return err
 	}
+	if len(chunks) == 1 {
+		return f.sendChunk(chunks[0])
+	}
 
-	for _, chunk := range chunks {
-		if err := f.sendChunk(chunk); err != nil {
-			return err
+	errs := f.deliver(chunks)
+	sendErr := &SendError{}
+	for i, chunkErr := range errs {
+		if chunkErr == nil {
+			sendErr.Delivered += len(chunks[i].events)
+			continue
+		}
+		sendErr.Failed = append(sendErr.Failed, chunks[i].events...)
+		if !errors.Is(chunkErr, errNotAttempted) {
+			sendErr.Errs = append(sendErr.Errs, chunkErr)
 		}
 	}
+	if len(sendErr.Failed) == 0 {
+		return nil
+	}
+	return sendErr
+}
 
-	return nil
+// errNotAttempted marks chunks skipped after an earlier sequential failure.
+var errNotAttempted = errors.New("not attempted")
+
+// deliver sends chunks using up to MaxConcurrency workers and returns the
+// error of each chunk by index.
+func (f *Forwarder) deliver(chunks []chunk) []error {
+	errs := make([]error, len(chunks))
+
+	workers := f.opts.MaxConcurrency
+	if workers <= 1 {
+		// Stop at the first failure rather than waiting out retries for every chunk
+		for i, c := range chunks {
+			if errs[i] = f.sendChunk(c); errs[i] != nil {
+				for j := i + 1; j < len(chunks); j++ {
+					errs[j] = errNotAttempted
+				}
+				break
+			}
+		}
+		return errs
+	}
+	if workers > len(chunks) {
+		workers = len(chunks)
+	}
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				errs[i] = f.sendChunk(chunks[i])
+			}
+		}()
+	}
+	for i := range chunks {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return errs
+}
+
+// SendError reports a Send in which some chunks were not delivered.
+type SendError struct {
+	Failed    []buffer.Event // Events that were not delivered
+	Delivered int            // Events accepted by the API
+	Errs      []error        // Distinct chunk failures
+}
+
+func (e *SendError) Error() string {
+	return fmt.Sprintf("%d of %d events not delivered: %v", len(e.Failed), len(e.Failed)+e.Delivered, errors.Join(e.Errs...))
+}
+
+func (e *SendError) Unwrap() []error {
+	return e.Errs
 }
 
-func (f *Forwarder) sendChunk(events []buffer.Event) error {
-	body, compressed, err := f.encodePayload(events)
+// Undelivered returns the events from a failed Send that still need to be
+// persisted: the failed subset for a *SendError, otherwise all of events.
+func Undelivered(err error, events []buffer.Event) []buffer.Event {
+	if err == nil {
+		return nil
+	}
+	var sendErr *SendError
+	if errors.As(err, &sendErr) {
+		return sendErr.Failed
+	}
+	return events
+}
+
+// chunk is one request's worth of events together with each event's
+// pre-encoded JSON, so events are marshalled once regardless of batching.
+// service is set when chunks are grouped by service_name.
+type chunk struct {
+	service string
+	events  []buffer.Event
+	encoded [][]byte
+}
+
+// keyFor returns the API key used for chunks of service.
+func (f *Forwarder) keyFor(service string) string {
+	if key := f.opts.ServiceKeys[service]; key != "" {
+		return key
+	}
+	return f.key()
+}
+
+// SetAPIKey replaces the API key from the next request on, so a rotated key
+// takes effect without restarting or losing buffered events. Keys from
+// ServiceKeys are not affected.
+func (f *Forwarder) SetAPIKey(key string) {
+	f.keyMu.Lock()
+	f.apiKey = key
+	f.keyMu.Unlock()
+}
+
+func (f *Forwarder) key() string {
+	f.keyMu.RLock()
+	defer f.keyMu.RUnlock()
+	return f.apiKey
+}
+
+func (f *Forwarder) sendChunk(c chunk) error {
+	events := c.events
+	body, compressed, err := f.encodePayload(c.encoded)
 	if err != nil {
 		return err
 	}
 
 	if f.opts.MaxBatchBytes > 0 && len(body) > f.opts.MaxBatchBytes {
-		log.Printf("[Forwarder] Warning: payload size %d bytes exceeds configured limit %d; sending anyway", len(body), f.opts.MaxBatchBytes)
+		logger.Warnf("Payload size %d bytes exceeds configured limit %d; sending anyway", len(body), f.opts.MaxBatchBytes)
 	}
 
+	record := RequestRecord{
+		At:        time.Now().UTC(),
+		Events:    len(events),
+		RawBytes:  payloadSize(c.encoded),
+		SentBytes: len(body),
+	}
+	defer func() { f.requests.add(record) }()
+
 	maxRetries := 3
 	for attempt := 0; attempt < maxRetries; attempt++ {
 		if attempt > 0 {
 			backoff := time.Duration(math.Pow(2, float64(attempt))) * time.Second
-			log.Printf("[Forwarder] Retry attempt %d after %v", attempt+1, backoff)
+			logger.Warnf("Retry attempt %d after %v", attempt+1, backoff)
 			time.Sleep(backoff)
 		}
 
-		err = f.sendRequest(body, compressed)
+		start := time.Now()
+		record.RequestID = uuid.NewString()
+		record.StatusCode, err = f.sendRequest(body, compressed, f.keyFor(c.service), record.RequestID)
+		record.Duration += time.Since(start)
+		record.Attempts++
 		if err == nil {
-			log.Printf("[Forwarder] Successfully sent %d events", len(events))
+			record.Error = ""
+			logger.Debugf("Successfully sent %d events", len(events))
 			return nil
 		}
+		record.Error = err.Error()
 
 		if !isRetryable(err) {
-			log.Printf("[Forwarder] Non-retryable error: %v", err)
+			logger.Errorf("Non-retryable error (request %s): %v", record.RequestID, err)
 			return err
 		}
 
-		log.Printf("[Forwarder] Retryable error (attempt %d/%d): %v", attempt+1, maxRetries, err)
+		logger.Warnf("Retryable error (attempt %d/%d, request %s): %v", attempt+1, maxRetries, record.RequestID, err)
 	}
 
-	return fmt.Errorf("failed after %d retries: %w", maxRetries, err)
+	return fmt.Errorf("failed after %d retries (last request %s): %w", maxRetries, record.RequestID, err)
 }
 
-func (f *Forwarder) partition(events []buffer.Event) ([][]buffer.Event, error) {
-	now := time.Now().UTC()
+func (f *Forwarder) partition(events []buffer.Event) ([]chunk, error) {
+	now := f.now()
+	encoded := make([][]byte, len(events))
+	sizeHint := 512
 	for i := range events {
-		if err := normalizeEvent(events[i], now); err != nil {
-			return nil, fmt.Errorf("event[%d] invalid: %w", i, err)
+		if err := normalizeEvent(events[i], now, f.opts.GlobalTags); err != nil {
+			return nil, &payloadError{fmt.Errorf("event[%d] invalid: %w", i, err)}
 		}
-	}
 
-	var batches [][]buffer.Event
-	for i := 0; i < len(events); {
-		end := i + f.opts.BatchSize
-		if end > len(events) {
-			end = len(events)
+		// Size each buffer from the previous event so most encode without growing
+		raw, err := buffer.RecordFromEvent(events[i]).AppendJSON(make([]byte, 0, sizeHint))
+		if err != nil {
+			return nil, &payloadError{fmt.Errorf("failed to marshal events: %w", err)}
 		}
-
-		for {
-			chunk := events[i:end]
-			raw, err := f.marshalEvents(chunk)
-			if err != nil {
-				return nil, err
+		if limit := f.opts.MaxEventBytes; limit > 0 && len(raw) > limit {
+			logger.Warnf("Event %s is %d bytes (max_event_bytes %d); truncating it", getString(events[i], "event_id"), len(raw), limit)
+			if raw, err = shrinkEvent(events[i], limit); err != nil {
+				return nil, &payloadError{fmt.Errorf("failed to marshal events: %w", err)}
 			}
+			diag.Global().RecordOversized(1)
+		}
+		encoded[i] = raw
+		sizeHint = len(raw) + len(raw)/4
+	}
 
-			if f.opts.MaxBatchBytes > 0 && len(raw) > f.opts.MaxBatchBytes && end-i > 1 {
-				end--
-				continue
-			}
+	if !f.opts.GroupByService {
+		return f.split("", events, encoded), nil
+	}
 
-			batches = append(batches, chunk)
-			i = end
-			break
+	// Group by service in order of first appearance, keeping event order within each
+	var services []string
+	groups := make(map[string][]int)
+	for i, evt := range events {
+		service := getString(evt, "service_name")
+		if _, seen := groups[service]; !seen {
+			services = append(services, service)
 		}
+		groups[service] = append(groups[service], i)
 	}
 
-	return batches, nil
+	var chunks []chunk
+	for _, service := range services {
+		indices := groups[service]
+		groupEvents := make([]buffer.Event, len(indices))
+		groupEncoded := make([][]byte, len(indices))
+		for j, idx := range indices {
+			groupEvents[j] = events[idx]
+			groupEncoded[j] = encoded[idx]
+		}
+		chunks = append(chunks, f.split(service, groupEvents, groupEncoded)...)
+	}
+	return chunks, nil
 }
 
-func (f *Forwarder) encodePayload(events []buffer.Event) ([]byte, bool, error) {
-	raw, err := f.marshalEvents(events)
-	if err != nil {
-		return nil, false, err
+// split cuts events into chunks within the batch and byte limits.
+func (f *Forwarder) split(service string, events []buffer.Event, encoded [][]byte) []chunk {
+	var chunks []chunk
+	batchSize := f.batchSize()
+	for i := 0; i < len(events); {
+		// Grow the chunk while it stays within the batch and byte limits,
+		// always taking at least one event
+		end := i + 1
+		size := payloadSize(encoded[i : i+1])
+		for end < len(events) && end-i < batchSize {
+			next := size + len(encoded[end]) + 1
+			if f.opts.MaxBatchBytes > 0 && next > f.opts.MaxBatchBytes {
+				break
+			}
+			size = next
+			end++
+		}
+
+		chunks = append(chunks, chunk{service: service, events: events[i:end], encoded: encoded[i:end]})
+		i = end
 	}
+	return chunks
+}
+
+func (f *Forwarder) encodePayload(encoded [][]byte) ([]byte, bool, error) {
+	raw := marshalPayload(encoded)
 
 	if !f.opts.Compress {
 		return raw, false, nil
@@ -194,49 +414,74 @@ func (f *Forwarder) encodePayload(events []buffer.Event) ([]byte, bool, error) {
 	return buf.Bytes(), true, nil
 }
 
-func (f *Forwarder) marshalEvents(events []buffer.Event) ([]byte, error) {
-	payload := map[string]interface{}{
-		"events": events,
+const (
+	payloadPrefix = `{"events":[`
+	payloadSuffix = `]}`
+)
+
+// marshalPayload wraps pre-encoded events in the ingest envelope.
+func marshalPayload(encoded [][]byte) []byte {
+	raw := make([]byte, 0, payloadSize(encoded))
+	raw = append(raw, payloadPrefix...)
+	for i, event := range encoded {
+		if i > 0 {
+			raw = append(raw, ',')
+		}
+		raw = append(raw, event...)
 	}
-	raw, err := json.Marshal(payload)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal events: %w", err)
+	return append(raw, payloadSuffix...)
+}
+
+func payloadSize(encoded [][]byte) int {
+	size := len(payloadPrefix) + len(payloadSuffix)
+	for i, event := range encoded {
+		if i > 0 {
+			size++
+		}
+		size += len(event)
 	}
-	return raw, nil
+	return size
 }
 
-// sendRequest sends a single HTTP request.
-func (f *Forwarder) sendRequest(body []byte, compressed bool) error {
+// sendRequest sends a single HTTP request authorized with apiKey and tagged
+// with requestID, and returns the response status code, or 0 when no
+// response arrived.
+func (f *Forwarder) sendRequest(body []byte, compressed bool, apiKey, requestID string) (int, error) {
 	req, err := http.NewRequest("POST", f.apiEndpoint, bytes.NewBuffer(body))
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return 0, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", f.apiKey))
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiKey))
+	req.Header.Set("User-Agent", f.userAgent)
+	req.Header.Set("X-Yaat-Request-ID", requestID)
 	if compressed {
 		req.Header.Set("Content-Encoding", "gzip")
 	}
 
+	start := time.Now()
 	resp, err := f.client.Do(req)
 	if err != nil {
-		return &RetryableError{Err: err}
+		return 0, &RetryableError{Err: err}
 	}
 	defer resp.Body.Close()
 
 	respBody, _ := io.ReadAll(resp.Body)
+	f.hints.observe(resp, f.opts.BatchSize)
 
 	switch resp.StatusCode {
 	case 200, 201:
-		return nil
+		f.skew.observe(resp, start, time.Now(), f.opts.ClockSkewWarn)
+		return resp.StatusCode, nil
 	case 401:
-		return fmt.Errorf("authentication failed: invalid API key")
+		return resp.StatusCode, &StatusError{Code: resp.StatusCode, Err: fmt.Errorf("authentication failed: invalid API key")}
 	case 429:
-		return &RetryableError{Err: fmt.Errorf("rate limited")}
+		return resp.StatusCode, &RetryableError{Err: &StatusError{Code: resp.StatusCode, Err: fmt.Errorf("rate limited")}}
 	case 500, 502, 503, 504:
-		return &RetryableError{Err: fmt.Errorf("server error: %d - %s", resp.StatusCode, string(respBody))}
+		return resp.StatusCode, &RetryableError{Err: &StatusError{Code: resp.StatusCode, Err: fmt.Errorf("server error: %d - %s", resp.StatusCode, string(respBody))}}
 	default:
-		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(respBody))
+		return resp.StatusCode, &StatusError{Code: resp.StatusCode, Err: fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(respBody))}
 	}
 }
 
@@ -249,12 +494,105 @@ func (e *RetryableError) Error() string {
 	return e.Err.Error()
 }
 
+func (e *RetryableError) Unwrap() error {
+	return e.Err
+}
+
+// StatusError is a request the API answered with an unsuccessful status.
+type StatusError struct {
+	Code int
+	Err  error
+}
+
+func (e *StatusError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+// StatusCode returns the HTTP status of the last response behind err, or 0
+// when the request never got one.
+func StatusCode(err error) int {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Code
+	}
+	return 0
+}
+
+// payloadError is a batch that could not be encoded, so it was never sent.
+type payloadError struct {
+	err error
+}
+
+func (e *payloadError) Error() string {
+	return e.err.Error()
+}
+
+func (e *payloadError) Unwrap() error {
+	return e.err
+}
+
+// Classify returns the diag category of a Send error, or "" when it fits
+// none. A *SendError is classified by its first chunk failure.
+func Classify(err error) string {
+	var sendErr *SendError
+	if errors.As(err, &sendErr) && len(sendErr.Errs) > 0 {
+		err = sendErr.Errs[0]
+	}
+	if err == nil {
+		return ""
+	}
+
+	switch code := StatusCode(err); {
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		return diag.ErrorAuth
+	case code == http.StatusTooManyRequests:
+		return diag.ErrorRateLimited
+	case code == http.StatusBadRequest || code == http.StatusRequestEntityTooLarge || code == http.StatusUnprocessableEntity:
+		return diag.ErrorPayload
+	case code >= 500:
+		return diag.ErrorServer
+	case code >= 400:
+		return diag.ErrorClient
+	}
+
+	var encodeErr *payloadError
+	if errors.As(err, &encodeErr) {
+		return diag.ErrorPayload
+	}
+	// Retryable without a status means no response arrived
+	var retryErr *RetryableError
+	if errors.As(err, &retryErr) {
+		return diag.ErrorNetwork
+	}
+	return ""
+}
+
 // isRetryable checks if an error is retryable.
 func isRetryable(err error) bool {
 	_, ok := err.(*RetryableError)
 	return ok
 }
 
+// Probe checks that the API accepts the forwarder's key by posting an empty
+// batch, so no data is sent. A 401 or 403 is returned as is; network
+// failures, rate limits and server errors come back as *RetryableError. Any
+// other response means the key was accepted.
+func (f *Forwarder) Probe() error {
+	status, err := f.sendRequest(marshalPayload(nil), false, f.key(), uuid.NewString())
+	if err == nil || isRetryable(err) {
+		return err
+	}
+	if status == http.StatusUnauthorized || status == http.StatusForbidden {
+		return err
+	}
+	// Authenticated; the API only rejected the empty batch
+	return nil
+}
+
 // Test sends a curated batch of test events to validate connectivity.
 func (f *Forwarder) Test(serviceName, environment string, globalTags map[string]string) (*TestReport, error) {
 	if serviceName == "" {
@@ -271,11 +609,13 @@ func (f *Forwarder) Test(serviceName, environment string, globalTags map[string]
 		return nil, err
 	}
 
-	return &TestReport{
+	report := &TestReport{
 		Endpoint: f.apiEndpoint,
 		Events:   cloneEvents(events),
 		Latency:  time.Since(start),
-	}, nil
+	}
+	report.ClockSkew, report.ClockSkewMeasured = f.ClockSkew()
+	return report, nil
 }
 
 func makeTestEvents(serviceName, environment string, globalTags map[string]string) []buffer.Event {
@@ -373,7 +713,15 @@ func cloneEvents(events []buffer.Event) []buffer.Event {
 	return cloned
 }
 
-func normalizeEvent(evt buffer.Event, now time.Time) error {
+var validEventTypes = map[string]struct{}{"log": {}, "span": {}, "metric": {}}
+
+// NormalizeEvent checks evt and fills in defaults the way Send does, so an
+// input that accepts arbitrary events can reject invalid ones up front.
+func NormalizeEvent(evt buffer.Event, now time.Time) error {
+	return normalizeEvent(evt, now, nil)
+}
+
+func normalizeEvent(evt buffer.Event, now time.Time, globalTags map[string]string) error {
 	serviceName := strings.TrimSpace(getString(evt, "service_name"))
 	if serviceName == "" {
 		return fmt.Errorf("service_name is required")
@@ -394,7 +742,7 @@ func normalizeEvent(evt buffer.Event, now time.Time) error {
 	if eventType == "" {
 		eventType = "log"
 	}
-	if _, ok := map[string]struct{}{"log": {}, "span": {}, "metric": {}}[eventType]; !ok {
+	if _, ok := validEventTypes[eventType]; !ok {
 		return fmt.Errorf("invalid event_type %q", eventType)
 	}
 	evt["event_type"] = eventType
@@ -412,10 +760,33 @@ func normalizeEvent(evt buffer.Event, now time.Time) error {
 	if err := normalizeTags(evt); err != nil {
 		return fmt.Errorf("tags: %w", err)
 	}
+	pinTags(evt, globalTags)
 
 	return nil
 }
 
+// pinTags adds the global tags evt lacks. The map is copied before any
+// change, as inputs may share one tag map between events.
+func pinTags(evt buffer.Event, globalTags map[string]string) {
+	tags := evt["tags"].(map[string]string)
+	var merged map[string]string
+	for k, v := range globalTags {
+		if _, exists := tags[k]; exists {
+			continue
+		}
+		if merged == nil {
+			merged = make(map[string]string, len(tags)+len(globalTags))
+			for tk, tv := range tags {
+				merged[tk] = tv
+			}
+		}
+		merged[k] = v
+	}
+	if merged != nil {
+		evt["tags"] = merged
+	}
+}
+
 func normalizeTimestamp(value interface{}, fallback time.Time) (time.Time, error) {
 	if value == nil {
 		return fallback, nil
@@ -3,11 +3,20 @@ package forwarder
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+	"unicode/utf8"
 
 	"github.com/yaat-app/sidecar/internal/buffer"
+	"github.com/yaat-app/sidecar/internal/diag"
+	"github.com/yaat-app/sidecar/internal/logs"
 )
 
 type roundTripFunc func(*http.Request) (*http.Response, error)
@@ -121,6 +130,46 @@ func TestSendUnauthorized(t *testing.T) {
 	}
 }
 
+func TestProbe(t *testing.T) {
+	cases := []struct {
+		status    int
+		wantErr   bool
+		retryable bool
+	}{
+		{http.StatusOK, false, false},
+		{http.StatusBadRequest, false, false}, // Key accepted, empty batch rejected
+		{http.StatusUnauthorized, true, false},
+		{http.StatusForbidden, true, false},
+		{http.StatusServiceUnavailable, true, true},
+	}
+	for _, tc := range cases {
+		var body string
+		f := New("https://example.test/ingest", "test-key")
+		f.SetHTTPClient(&http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				raw, _ := io.ReadAll(req.Body)
+				body = string(raw)
+				return &http.Response{
+					StatusCode: tc.status,
+					Header:     make(http.Header),
+					Body:       io.NopCloser(bytes.NewReader(nil)),
+				}, nil
+			}),
+		})
+
+		err := f.Probe()
+		if (err != nil) != tc.wantErr {
+			t.Errorf("status %d: expected error=%t, got %v", tc.status, tc.wantErr, err)
+		}
+		if err != nil && isRetryable(err) != tc.retryable {
+			t.Errorf("status %d: expected retryable=%t, got %v", tc.status, tc.retryable, err)
+		}
+		if body != `{"events":[]}` {
+			t.Errorf("Expected an empty batch, got %s", body)
+		}
+	}
+}
+
 func TestSendServerError(t *testing.T) {
 	f := New("https://example.test/ingest", "test-key")
 	f.SetHTTPClient(&http.Client{
@@ -154,3 +203,527 @@ func TestRetryableError(t *testing.T) {
 		t.Error("Expected normal error to not be retryable")
 	}
 }
+
+func TestStatusCode(t *testing.T) {
+	rateLimited := &RetryableError{Err: &StatusError{Code: 429, Err: errors.New("rate limited")}}
+	if got := StatusCode(&SendError{Errs: []error{rateLimited}}); got != 429 {
+		t.Errorf("Expected 429 through SendError and RetryableError, got %d", got)
+	}
+	if got := StatusCode(&RetryableError{Err: http.ErrServerClosed}); got != 0 {
+		t.Errorf("Expected 0 without a response, got %d", got)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	status := func(code int) error { return &StatusError{Code: code, Err: errors.New("status")} }
+	tests := []struct {
+		err      error
+		category string
+	}{
+		{nil, ""},
+		{status(401), diag.ErrorAuth},
+		{status(403), diag.ErrorAuth},
+		{&RetryableError{Err: status(429)}, diag.ErrorRateLimited},
+		{fmt.Errorf("failed after 3 retries: %w", &RetryableError{Err: status(503)}), diag.ErrorServer},
+		{status(413), diag.ErrorPayload},
+		{status(404), diag.ErrorClient},
+		{&payloadError{errors.New("event[0] invalid")}, diag.ErrorPayload},
+		{&RetryableError{Err: errors.New("dial tcp: connection refused")}, diag.ErrorNetwork},
+		{&SendError{Errs: []error{status(401), &RetryableError{Err: status(503)}}}, diag.ErrorAuth},
+		{errors.New("failed to create request"), ""},
+	}
+	for _, tt := range tests {
+		if got := Classify(tt.err); got != tt.category {
+			t.Errorf("Expected %q for %v, got %q", tt.category, tt.err, got)
+		}
+	}
+
+	f := New("https://example.test/ingest", "key")
+	err := f.Send([]buffer.Event{{"event_type": "bogus", "service_name": "svc"}})
+	if got := Classify(err); got != diag.ErrorPayload {
+		t.Errorf("Expected an invalid event to be a payload error, got %q (%v)", got, err)
+	}
+}
+
+func nginxEvents(n int) []buffer.Event {
+	events := make([]buffer.Event, 0, n)
+	for i := 0; i < n; i++ {
+		line := fmt.Sprintf(`10.0.0.%d - - [26/Oct/2024:10:30:15 +0000] "GET /api/orders/%d?page=2&sort=asc HTTP/1.1" 200 %d "https://example.com/" "Mozilla/5.0 (X11; Linux x86_64)"`, i%255, i, 512+i)
+		events = append(events, *logs.ParseNginxLog(line, "org", "api", "production"))
+	}
+	return events
+}
+
+func TestPartitionMatchesMapEncoding(t *testing.T) {
+	f := NewWithOptions("https://example.test/ingest", "key", Options{BatchSize: 4, MaxBatchBytes: 2500})
+	events := nginxEvents(10)
+
+	chunks, err := f.partition(events)
+	if err != nil {
+		t.Fatalf("partition failed: %v", err)
+	}
+
+	total := 0
+	for _, c := range chunks {
+		expected, err := json.Marshal(map[string]interface{}{"events": c.events})
+		if err != nil {
+			t.Fatalf("json.Marshal failed: %v", err)
+		}
+		if got := marshalPayload(c.encoded); string(got) != string(expected) {
+			t.Errorf("Expected payload %s, got %s", expected, got)
+		}
+		if len(c.events) > 4 {
+			t.Errorf("Expected at most 4 events per chunk, got %d", len(c.events))
+		}
+		if len(c.events) > 1 && len(expected) > 2500 {
+			t.Errorf("Expected chunk under 2500 bytes, got %d", len(expected))
+		}
+		total += len(c.events)
+	}
+	if total != len(events) {
+		t.Errorf("Expected %d events across chunks, got %d", len(events), total)
+	}
+}
+
+// BenchmarkPipelineMap is the parse -> normalize -> marshal path using
+// reflection-based encoding of the map form, for comparison.
+func BenchmarkPipelineMap(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		events := nginxEvents(10000)
+		now := time.Now()
+		for _, evt := range events {
+			if err := normalizeEvent(evt, now, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+		for start := 0; start < len(events); start += 500 {
+			if _, err := json.Marshal(map[string]interface{}{"events": events[start : start+500]}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// BenchmarkPipelineRecord is the same path through the forwarder's
+// EventRecord encoding. Parsing still yields the map form in both.
+func BenchmarkPipelineRecord(b *testing.B) {
+	f := New("https://example.test/ingest", "key")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		chunks, err := f.partition(nginxEvents(10000))
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, c := range chunks {
+			marshalPayload(c.encoded)
+		}
+	}
+}
+
+// countingClient accepts every request after delay, failing those whose
+// first event's message is in reject.
+func countingClient(delay time.Duration, reject map[string]bool, inFlight, peak *int32) *http.Client {
+	return &http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			current := atomic.AddInt32(inFlight, 1)
+			defer atomic.AddInt32(inFlight, -1)
+			for {
+				old := atomic.LoadInt32(peak)
+				if current <= old || atomic.CompareAndSwapInt32(peak, old, current) {
+					break
+				}
+			}
+			time.Sleep(delay)
+
+			var payload struct {
+				Events []map[string]interface{} `json:"events"`
+			}
+			if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+				return nil, err
+			}
+			status := http.StatusOK
+			if reject[payload.Events[0]["message"].(string)] {
+				status = http.StatusBadRequest
+			}
+			return &http.Response{
+				StatusCode: status,
+				Header:     make(http.Header),
+				Body:       io.NopCloser(bytes.NewReader([]byte(`{}`))),
+			}, nil
+		}),
+	}
+}
+
+func numberedEvents(n int) []buffer.Event {
+	events := make([]buffer.Event, n)
+	for i := range events {
+		events[i] = buffer.Event{"service_name": "svc", "message": fmt.Sprintf("event-%d", i)}
+	}
+	return events
+}
+
+func TestSendConcurrentReportsUndeliveredChunks(t *testing.T) {
+	var inFlight, peak int32
+	f := NewWithOptions("https://example.test/ingest", "key", Options{BatchSize: 2, MaxConcurrency: 3})
+	// Chunks starting at event-2 and event-6 are rejected with a non-retryable 400
+	f.SetHTTPClient(countingClient(20*time.Millisecond, map[string]bool{"event-2": true, "event-6": true}, &inFlight, &peak))
+
+	events := numberedEvents(10)
+	err := f.Send(events)
+
+	var sendErr *SendError
+	if !errors.As(err, &sendErr) {
+		t.Fatalf("Expected *SendError, got %v", err)
+	}
+	if sendErr.Delivered != 6 {
+		t.Errorf("Expected 6 delivered events, got %d", sendErr.Delivered)
+	}
+	failed := Undelivered(err, events)
+	if len(failed) != 4 || failed[0]["message"] != "event-2" || failed[2]["message"] != "event-6" {
+		t.Errorf("Expected events 2,3,6,7 undelivered, got %v", failed)
+	}
+	if peak := atomic.LoadInt32(&peak); peak < 2 || peak > 3 {
+		t.Errorf("Expected 2-3 concurrent requests, got %d", peak)
+	}
+}
+
+func TestSendSequentialStopsAtFirstFailure(t *testing.T) {
+	var inFlight, peak int32
+	f := NewWithOptions("https://example.test/ingest", "key", Options{BatchSize: 2})
+	f.SetHTTPClient(countingClient(0, map[string]bool{"event-2": true}, &inFlight, &peak))
+
+	events := numberedEvents(6)
+	err := f.Send(events)
+	failed := Undelivered(err, events)
+	if len(failed) != 4 || failed[0]["message"] != "event-2" {
+		t.Errorf("Expected events from the failed chunk onward, got %v", failed)
+	}
+	if peak != 1 {
+		t.Errorf("Expected sequential delivery, got %d concurrent requests", peak)
+	}
+	if Undelivered(nil, events) != nil {
+		t.Error("Expected no undelivered events without an error")
+	}
+}
+
+// BenchmarkCatchUp delivers a 20-chunk backlog against a 20ms API.
+func BenchmarkCatchUp(b *testing.B) {
+	for _, concurrency := range []int{1, 4, 8} {
+		b.Run(fmt.Sprintf("concurrency=%d", concurrency), func(b *testing.B) {
+			var inFlight, peak int32
+			f := NewWithOptions("https://example.test/ingest", "key", Options{BatchSize: 500, MaxConcurrency: concurrency})
+			f.SetHTTPClient(countingClient(20*time.Millisecond, nil, &inFlight, &peak))
+			for i := 0; i < b.N; i++ {
+				if err := f.Send(numberedEvents(10000)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestSendGroupsByServiceWithPerServiceKeys(t *testing.T) {
+	type request struct {
+		auth     string
+		services []string
+	}
+	var (
+		mu       sync.Mutex
+		requests []request
+	)
+	f := NewWithOptions("https://example.test/ingest", "global-key", Options{
+		BatchSize:      2,
+		GroupByService: true,
+		ServiceKeys:    map[string]string{"billing": "billing-key"},
+	})
+	f.SetHTTPClient(&http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			var payload struct {
+				Events []map[string]interface{} `json:"events"`
+			}
+			if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+				return nil, err
+			}
+			r := request{auth: req.Header.Get("Authorization")}
+			for _, evt := range payload.Events {
+				r.services = append(r.services, evt["service_name"].(string))
+			}
+			mu.Lock()
+			requests = append(requests, r)
+			mu.Unlock()
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     make(http.Header),
+				Body:       io.NopCloser(bytes.NewReader([]byte(`{}`))),
+			}, nil
+		}),
+	})
+
+	var events []buffer.Event
+	for i, service := range []string{"api", "billing", "api", "billing", "api", "worker"} {
+		events = append(events, buffer.Event{"service_name": service, "message": fmt.Sprintf("event-%d", i)})
+	}
+	if err := f.Send(events); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	// api: 3 events in chunks of 2 and 1; billing: 2; worker: 1
+	expected := []request{
+		{"Bearer global-key", []string{"api", "api"}},
+		{"Bearer global-key", []string{"api"}},
+		{"Bearer billing-key", []string{"billing", "billing"}},
+		{"Bearer global-key", []string{"worker"}},
+	}
+	if len(requests) != len(expected) {
+		t.Fatalf("Expected %d requests, got %d: %v", len(expected), len(requests), requests)
+	}
+	for i, want := range expected {
+		got := requests[i]
+		if got.auth != want.auth || strings.Join(got.services, ",") != strings.Join(want.services, ",") {
+			t.Errorf("Request %d: expected %v, got %v", i, want, got)
+		}
+	}
+}
+
+func TestRecentRequestsRecordsFields(t *testing.T) {
+	f := NewWithOptions("https://example.test/ingest", "test-key", Options{BatchSize: 2, Compress: true})
+	var calls atomic.Int32
+	f.SetHTTPClient(&http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			io.Copy(io.Discard, req.Body)
+			status := http.StatusOK
+			if calls.Add(1) == 2 {
+				status = http.StatusUnauthorized
+			}
+			return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader("")), Header: make(http.Header)}, nil
+		}),
+	})
+
+	events := make([]buffer.Event, 3)
+	for i := range events {
+		events[i] = buffer.Event{"service_name": "svc", "message": strings.Repeat("repeated text ", 20)}
+	}
+	if err := f.Send(events); err == nil {
+		t.Fatal("Expected the second chunk to fail")
+	}
+
+	records := f.RecentRequests()
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(records))
+	}
+	ok, failed := records[0], records[1]
+	if ok.Events != 2 || ok.Attempts != 1 || ok.StatusCode != 200 || ok.Error != "" {
+		t.Errorf("Expected a successful 2-event request, got %+v", ok)
+	}
+	if ok.RawBytes <= ok.SentBytes || ok.SentBytes == 0 {
+		t.Errorf("Expected compressed payload smaller than raw, got raw=%d sent=%d", ok.RawBytes, ok.SentBytes)
+	}
+	if ok.At.IsZero() || ok.Duration <= 0 {
+		t.Errorf("Expected timestamp and duration, got %+v", ok)
+	}
+	if failed.Events != 1 || failed.StatusCode != 401 || !strings.Contains(failed.Error, "authentication failed") {
+		t.Errorf("Expected a failed 1-event request with 401, got %+v", failed)
+	}
+}
+
+func TestRequestLogKeepsLastRequests(t *testing.T) {
+	var l requestLog
+	for i := 1; i <= recentRequestsSize+10; i++ {
+		l.add(RequestRecord{Events: i, Duration: time.Duration(i) * time.Millisecond, RawBytes: 400, SentBytes: 100})
+	}
+	records := l.recent()
+	if len(records) != recentRequestsSize || records[0].Events != 11 || records[len(records)-1].Events != recentRequestsSize+10 {
+		t.Fatalf("Expected the last %d records oldest first, got %d from %d", recentRequestsSize, len(records), records[0].Events)
+	}
+
+	stats := summarizeRequests(records)
+	if stats.P50LatencyMillis != 60 || stats.P95LatencyMillis != 105 {
+		t.Errorf("Expected p50=60ms p95=105ms, got %d/%d", stats.P50LatencyMillis, stats.P95LatencyMillis)
+	}
+	if stats.AvgPayloadBytes != 100 || stats.CompressionRatio != 4 {
+		t.Errorf("Expected 100 byte payloads at 4x, got %d at %.1fx", stats.AvgPayloadBytes, stats.CompressionRatio)
+	}
+}
+
+func TestPartitionShrinksOversizedEvents(t *testing.T) {
+	f := NewWithOptions("https://example.test/ingest", "key", Options{MaxEventBytes: 4096})
+	shared := map[string]string{"region": "eu", "blob": strings.Repeat("x", 2000)}
+	big := buffer.Event{
+		"service_name": "svc",
+		"event_type":   "log",
+		"level":        "error",
+		"message":      strings.Repeat("é", 10000),
+		"stacktrace":   strings.Repeat("frame\n", 2000),
+		"tags":         shared,
+	}
+	small := buffer.Event{"service_name": "svc", "message": "ok", "tags": shared}
+	before := diag.Global().Snapshot().OversizedEvents
+
+	chunks, err := f.partition([]buffer.Event{big, small})
+	if err != nil {
+		t.Fatalf("partition failed: %v", err)
+	}
+	if len(chunks) != 1 || len(chunks[0].encoded) != 2 {
+		t.Fatalf("Expected both events in one chunk, got %d chunks", len(chunks))
+	}
+	if n := len(chunks[0].encoded[0]); n > 4096 {
+		t.Errorf("Expected the oversized event under 4096 bytes, got %d", n)
+	}
+	if !json.Valid(chunks[0].encoded[0]) {
+		t.Error("Expected valid JSON after truncation")
+	}
+
+	tags := big["tags"].(map[string]string)
+	if tags["oversized"] != "true" || tags["region"] != "eu" {
+		t.Errorf("Expected oversized tag and short tags kept, got %v", tags)
+	}
+	if _, ok := tags["blob"]; ok {
+		t.Error("Expected the long tag value to be dropped")
+	}
+	if msg := big["message"].(string); !utf8.ValidString(msg) || !strings.HasSuffix(msg, "...[TRUNCATED]") {
+		t.Errorf("Expected a truncated valid UTF-8 message, got %d bytes", len(msg))
+	}
+	if big["level"] != "error" || big["service_name"] != "svc" {
+		t.Errorf("Expected identity fields untouched, got %v", big)
+	}
+
+	if _, ok := shared["oversized"]; ok || shared["blob"] == "" {
+		t.Error("Expected the shared tag map to be left alone")
+	}
+	if _, ok := small["tags"].(map[string]string)["oversized"]; ok {
+		t.Error("Expected the small event to be untouched")
+	}
+	if got := diag.Global().Snapshot().OversizedEvents - before; got != 1 {
+		t.Errorf("Expected 1 oversized event recorded, got %d", got)
+	}
+}
+
+func TestSendSetsUserAgentAndRequestID(t *testing.T) {
+	f := NewWithOptions("https://example.test/ingest", "test-key", Options{Version: "1.2.3"})
+	var ids []string
+	var agent string
+	f.SetHTTPClient(&http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			io.Copy(io.Discard, req.Body)
+			agent = req.Header.Get("User-Agent")
+			ids = append(ids, req.Header.Get("X-Yaat-Request-ID"))
+			status := http.StatusOK
+			if len(ids) == 1 {
+				status = http.StatusServiceUnavailable
+			}
+			return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader("")), Header: make(http.Header)}, nil
+		}),
+	})
+
+	if err := f.Send([]buffer.Event{{"service_name": "svc", "message": "hello"}}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if !strings.HasPrefix(agent, "yaat-sidecar/1.2.3 (") {
+		t.Errorf("Expected a yaat-sidecar/1.2.3 User-Agent, got %q", agent)
+	}
+	if len(ids) != 2 || ids[0] == "" || ids[0] == ids[1] {
+		t.Errorf("Expected a new request ID per attempt, got %v", ids)
+	}
+	if records := f.RecentRequests(); len(records) != 1 || records[0].RequestID != ids[1] {
+		t.Errorf("Expected the last request ID recorded, got %+v", records)
+	}
+}
+
+func TestSendFollowsServerHints(t *testing.T) {
+	f := NewWithOptions("https://example.test/ingest", "test-key", Options{BatchSize: 10})
+	var sizes []int
+	header := http.Header{}
+	header.Set("X-Yaat-Suggested-Batch-Size", "2")
+	header.Set("X-Yaat-Min-Flush-Interval", "30s")
+	f.SetHTTPClient(&http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			var payload struct {
+				Events []json.RawMessage `json:"events"`
+			}
+			json.NewDecoder(req.Body).Decode(&payload)
+			sizes = append(sizes, len(payload.Events))
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("")), Header: header.Clone()}, nil
+		}),
+	})
+
+	events := func(n int) []buffer.Event {
+		out := make([]buffer.Event, n)
+		for i := range out {
+			out[i] = buffer.Event{"service_name": "svc", "message": fmt.Sprintf("event %d", i)}
+		}
+		return out
+	}
+	if err := f.Send(events(4)); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if f.MinFlushInterval() != 30*time.Second {
+		t.Errorf("Expected a 30s minimum flush interval, got %v", f.MinFlushInterval())
+	}
+
+	// The next send follows the suggested batch size
+	if err := f.Send(events(4)); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if len(sizes) != 3 || sizes[0] != 4 || sizes[1] != 2 || sizes[2] != 2 {
+		t.Errorf("Expected one batch of 4, then batches of 2, got %v", sizes)
+	}
+
+	// Suggestions above batch_size and intervals past the cap are bounded;
+	// a response without hints lifts them
+	header.Set("X-Yaat-Suggested-Batch-Size", "50")
+	header.Set("X-Yaat-Min-Flush-Interval", "3600")
+	f.Send(events(1))
+	if f.batchSize() != 10 || f.MinFlushInterval() != maxHintedFlushInterval {
+		t.Errorf("Expected hints bounded to 10 and %v, got %d and %v", maxHintedFlushInterval, f.batchSize(), f.MinFlushInterval())
+	}
+	header = http.Header{}
+	f.Send(events(1))
+	if f.batchSize() != 10 || f.MinFlushInterval() != 0 {
+		t.Errorf("Expected hints lifted, got %d and %v", f.batchSize(), f.MinFlushInterval())
+	}
+}
+
+func TestSendPinsGlobalTags(t *testing.T) {
+	f := NewWithOptions("https://example.test/ingest", "test-key", Options{
+		GlobalTags: map[string]string{"org": "acme", "service": "billing"},
+	})
+	var received []map[string]string
+	f.SetHTTPClient(&http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			var payload struct {
+				Events []struct {
+					Tags map[string]string `json:"tags"`
+				} `json:"events"`
+			}
+			if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+				return nil, err
+			}
+			for _, evt := range payload.Events {
+				received = append(received, evt.Tags)
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("")), Header: make(http.Header)}, nil
+		}),
+	})
+
+	shared := map[string]string{"service": "checkout"}
+	events := []buffer.Event{
+		{"service_name": "svc", "message": "no tags"},
+		{"service_name": "svc", "message": "own service tag", "tags": shared},
+	}
+	if err := f.Send(events); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if len(received) != 2 {
+		t.Fatalf("Expected 2 events, got %d", len(received))
+	}
+	if received[0]["org"] != "acme" || received[0]["service"] != "billing" {
+		t.Errorf("Expected the global tags on an untagged event, got %v", received[0])
+	}
+	if received[1]["org"] != "acme" || received[1]["service"] != "checkout" {
+		t.Errorf("Expected the event's own tag to win, got %v", received[1])
+	}
+	if len(shared) != 1 {
+		t.Errorf("Expected the input's tag map left unchanged, got %v", shared)
+	}
+}
@@ -0,0 +1,110 @@
+package forwarder
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Headers the API may return to slow a noisy sidecar down. Every response
+// replaces the previous hints, so a response without them lifts the throttle.
+const (
+	suggestedBatchSizeHeader = "X-Yaat-Suggested-Batch-Size"
+	minFlushIntervalHeader   = "X-Yaat-Min-Flush-Interval"
+
+	// maxHintedFlushInterval caps how far the API can stretch the flush interval.
+	maxHintedFlushInterval = 5 * time.Minute
+)
+
+// userAgent identifies the sidecar build in requests to the API.
+func userAgent(version string) string {
+	if version == "" {
+		version = "dev"
+	}
+	return fmt.Sprintf("yaat-sidecar/%s (%s/%s)", version, runtime.GOOS, runtime.GOARCH)
+}
+
+// serverHints holds the throttling the API last asked for.
+type serverHints struct {
+	mu               sync.Mutex
+	batchSize        int
+	minFlushInterval time.Duration
+}
+
+// observe records the hints of resp. batch_size bounds the suggested batch
+// size and maxHintedFlushInterval the flush interval; malformed values are
+// ignored.
+func (h *serverHints) observe(resp *http.Response, maxBatch int) {
+	batchSize, _ := strconv.Atoi(strings.TrimSpace(resp.Header.Get(suggestedBatchSizeHeader)))
+	if batchSize < 0 {
+		batchSize = 0
+	}
+	if batchSize > maxBatch {
+		batchSize = maxBatch
+	}
+	interval := parseHintInterval(resp.Header.Get(minFlushIntervalHeader))
+	if interval > maxHintedFlushInterval {
+		interval = maxHintedFlushInterval
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if batchSize != h.batchSize {
+		if batchSize > 0 {
+			logger.Infof("API suggested a batch size of %d events", batchSize)
+		} else if h.batchSize > 0 {
+			logger.Infof("API lifted the batch size limit; back to %d events", maxBatch)
+		}
+		h.batchSize = batchSize
+	}
+	if interval != h.minFlushInterval {
+		if interval > 0 {
+			logger.Infof("API asked for at least %v between flushes", interval)
+		} else if h.minFlushInterval > 0 {
+			logger.Infof("API lifted the minimum flush interval")
+		}
+		h.minFlushInterval = interval
+	}
+}
+
+// parseHintInterval accepts a Go duration ("30s") or whole seconds ("30").
+func parseHintInterval(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds <= 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0
+	}
+	return d
+}
+
+// batchSize returns the chunk size to use: the API's suggestion when it is
+// below the configured batch_size.
+func (f *Forwarder) batchSize() int {
+	f.hints.mu.Lock()
+	defer f.hints.mu.Unlock()
+	if f.hints.batchSize > 0 {
+		return f.hints.batchSize
+	}
+	return f.opts.BatchSize
+}
+
+// MinFlushInterval returns the shortest time between flushes the API last
+// asked for, or 0 when it asked for none.
+func (f *Forwarder) MinFlushInterval() time.Duration {
+	f.hints.mu.Lock()
+	defer f.hints.mu.Unlock()
+	return f.hints.minFlushInterval
+}
@@ -0,0 +1,78 @@
+package forwarder
+
+import (
+	"unicode/utf8"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+)
+
+// identityFields are never truncated; the API needs them intact to accept
+// and correlate the event.
+var identityFields = map[string]bool{
+	"organization_id": true,
+	"service_name":    true,
+	"event_id":        true,
+	"timestamp":       true,
+	"received_at":     true,
+	"event_type":      true,
+	"environment":     true,
+	"level":           true,
+	"trace_id":        true,
+	"span_id":         true,
+	"parent_span_id":  true,
+	"metric_name":     true,
+}
+
+// shrinkPasses bounds how many times shrinkEvent halves its limits.
+const shrinkPasses = 4
+
+// shrinkEvent cuts an event whose encoding exceeds maxBytes so one bad event
+// cannot push a whole batch over the API's request limit: long string fields
+// are truncated and long tag values dropped, with stricter limits on each
+// pass until the event fits. The event is tagged oversized=true and its new
+// encoding returned.
+func shrinkEvent(evt buffer.Event, maxBytes int) ([]byte, error) {
+	fieldLimit, tagLimit := maxBytes/4, maxBytes/16
+
+	tags, _ := evt["tags"].(map[string]string)
+	// Tag maps can be shared between events (global tags), so never edit in place
+	shrunk := make(map[string]string, len(tags)+1)
+	for k, v := range tags {
+		shrunk[k] = v
+	}
+	evt["tags"] = shrunk
+
+	var raw []byte
+	for pass := 0; pass < shrinkPasses; pass++ {
+		for key, val := range evt {
+			if s, ok := val.(string); ok && !identityFields[key] && len(s) > fieldLimit {
+				evt[key] = truncateUTF8(s, fieldLimit) + "...[TRUNCATED]"
+			}
+		}
+		for k, v := range shrunk {
+			if len(k)+len(v) > tagLimit {
+				delete(shrunk, k)
+			}
+		}
+		shrunk["oversized"] = "true"
+
+		var err error
+		raw, err = buffer.RecordFromEvent(evt).AppendJSON(nil)
+		if err != nil || len(raw) <= maxBytes {
+			return raw, err
+		}
+		fieldLimit, tagLimit = fieldLimit/2, tagLimit/2
+	}
+	return raw, nil
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a rune.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
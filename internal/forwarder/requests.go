@@ -0,0 +1,97 @@
+package forwarder
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/diag"
+)
+
+const recentRequestsSize = 100
+
+// RequestRecord describes one delivered (or failed) chunk.
+type RequestRecord struct {
+	At         time.Time     `json:"at"`
+	Events     int           `json:"events"`
+	RawBytes   int           `json:"raw_bytes"`  // Payload size before compression
+	SentBytes  int           `json:"sent_bytes"` // Payload size on the wire
+	Duration   time.Duration `json:"duration"`   // Round trips of all attempts, excluding backoff
+	Attempts   int           `json:"attempts"`
+	StatusCode int           `json:"status_code"` // Of the last attempt; 0 when no response arrived
+	Error      string        `json:"error,omitempty"`
+	RequestID  string        `json:"request_id,omitempty"` // X-Yaat-Request-ID of the last attempt
+}
+
+// requestLog keeps the most recent requests in a ring buffer.
+type requestLog struct {
+	mu      sync.Mutex
+	records []RequestRecord
+	next    int
+}
+
+func (l *requestLog) add(record RequestRecord) {
+	l.mu.Lock()
+	if len(l.records) < recentRequestsSize {
+		l.records = append(l.records, record)
+	} else {
+		l.records[l.next] = record
+	}
+	l.next = (l.next + 1) % recentRequestsSize
+	stats := summarizeRequests(l.records)
+	l.mu.Unlock()
+
+	diag.Global().SetDeliveryStats(stats)
+}
+
+// recent returns the recorded requests, oldest first.
+func (l *requestLog) recent() []RequestRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make([]RequestRecord, 0, len(l.records))
+	if len(l.records) == recentRequestsSize {
+		out = append(out, l.records[l.next:]...)
+		return append(out, l.records[:l.next]...)
+	}
+	return append(out, l.records...)
+}
+
+// RecentRequests returns the last requests sent by the forwarder, oldest first.
+func (f *Forwarder) RecentRequests() []RequestRecord {
+	return f.requests.recent()
+}
+
+// summarizeRequests computes latency percentiles and payload averages.
+func summarizeRequests(records []RequestRecord) diag.DeliveryStats {
+	stats := diag.DeliveryStats{Requests: len(records)}
+	if len(records) == 0 {
+		return stats
+	}
+
+	latencies := make([]time.Duration, len(records))
+	var raw, sent int
+	for i, record := range records {
+		latencies[i] = record.Duration
+		raw += record.RawBytes
+		sent += record.SentBytes
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	stats.P50LatencyMillis = percentile(latencies, 0.50).Milliseconds()
+	stats.P95LatencyMillis = percentile(latencies, 0.95).Milliseconds()
+	stats.AvgPayloadBytes = sent / len(records)
+	if sent > 0 {
+		stats.CompressionRatio = float64(raw) / float64(sent)
+	}
+	return stats
+}
+
+// percentile uses the nearest-rank method on sorted values.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
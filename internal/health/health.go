@@ -1,8 +1,10 @@
 package health
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"runtime"
 	"strings"
@@ -13,11 +15,13 @@ import (
 
 // Health provides a health check HTTP endpoint
 type Health struct {
-	port        int
+	addr        string
+	bearerToken string
 	version     string
 	serviceName string
 	startTime   time.Time
 	snapshotFn  func() diag.Snapshot
+	ui          *UIConfig // Status page at /ui when set
 }
 
 // HealthResponse is the JSON response from the health endpoint
@@ -41,10 +45,10 @@ type MemoryStats struct {
 	NumGC      uint32 `json:"num_gc"`
 }
 
-// New creates a new health check service
-func New(port int, version, serviceName string, snapshotFn func() diag.Snapshot) *Health {
+// New creates a new health check service listening on addr (host:port)
+func New(addr, version, serviceName string, snapshotFn func() diag.Snapshot) *Health {
 	return &Health{
-		port:        port,
+		addr:        addr,
 		version:     version,
 		serviceName: serviceName,
 		startTime:   time.Now(),
@@ -52,15 +56,95 @@ func New(port int, version, serviceName string, snapshotFn func() diag.Snapshot)
 	}
 }
 
+// SetBearerToken requires "Authorization: Bearer <token>" on every path
+// except /healthz. An empty token disables authentication.
+func (h *Health) SetBearerToken(token string) {
+	h.bearerToken = token
+}
+
+// Listen binds the configured address, so a port conflict is reported
+// before serving starts.
+func (h *Health) Listen() (net.Listener, error) {
+	return net.Listen("tcp", h.addr)
+}
+
+// Serve answers health requests on ln until it is closed.
+func (h *Health) Serve(ln net.Listener) error {
+	return http.Serve(ln, h.Handler())
+}
+
 // Start starts the health check HTTP server
 func (h *Health) Start() error {
+	ln, err := h.Listen()
+	if err != nil {
+		return err
+	}
+	return h.Serve(ln)
+}
+
+// Handler returns the routes of the health endpoint behind authentication.
+func (h *Health) Handler() http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/health", h.handleHealth)
+	mux.HandleFunc("/status", h.handleHealth)
 	mux.HandleFunc("/", h.handleHealth) // Also respond on root
 	mux.HandleFunc("/metrics", h.handleMetrics)
+	mux.HandleFunc("/healthz", h.handleLiveness)
+	mux.HandleFunc("/readyz", h.handleReadiness)
+	if h.ui != nil {
+		mux.HandleFunc("/ui", h.handleUI)
+	}
+	return h.requireToken(mux)
+}
 
-	addr := fmt.Sprintf(":%d", h.port)
-	return http.ListenAndServe(addr, mux)
+// requireToken rejects requests without the bearer token before routing, so
+// a 401 says nothing about whether the path exists. /healthz and /readyz stay
+// open for probes that cannot send headers; they reveal nothing beyond
+// liveness and readiness.
+func (h *Health) requireToken(next http.Handler) http.Handler {
+	if h.bearerToken == "" {
+		return next
+	}
+	expected := []byte("Bearer " + h.bearerToken)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" && r.URL.Path != "/readyz" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="yaat-sidecar"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleLiveness answers "ok" without any diagnostics.
+func (h *Health) handleLiveness(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+// handleReadiness answers 503 while the API rejects the key: nothing can be
+// delivered until the key is fixed, unlike outages the queue rides out.
+// Tripped scrubbing rules are reported on /status only; rule names are not
+// for unauthenticated probes.
+func (h *Health) handleReadiness(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if h.snapshotFn != nil {
+		snap := h.snapshotFn()
+		if snap.LastErrorCategory == diag.ErrorAuth {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, "not ready: API key rejected")
+			return
+		}
+	}
+	fmt.Fprintln(w, "ok")
 }
 
 // handleHealth handles health check requests
@@ -117,13 +201,25 @@ func (h *Health) handleMetrics(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
 
 	fmt.Fprintf(w, "yaat_sidecar_queue_inmemory %d\n", snapshot.InMemoryQueue)
+	fmt.Fprintf(w, "yaat_sidecar_buffer_capacity %d\n", snapshot.BufferCapacity)
+	fmt.Fprintf(w, "yaat_sidecar_buffer_high_water %d\n", snapshot.BufferHighWater)
 	fmt.Fprintf(w, "yaat_sidecar_queue_persisted %d\n", snapshot.PersistedQueue)
+	fmt.Fprintf(w, "yaat_sidecar_queue_persisted_events %d\n", snapshot.PersistedEvents)
+	fmt.Fprintf(w, "yaat_sidecar_queue_persisted_oldest_age_seconds %.0f\n", snapshot.PersistedOldestSeconds)
 	fmt.Fprintf(w, "yaat_sidecar_queue_deadletter %d\n", snapshot.DeadLetterQueue)
+	fmt.Fprintf(w, "yaat_sidecar_queue_deadletter_events %d\n", snapshot.DeadLetterEvents)
+	fmt.Fprintf(w, "yaat_sidecar_queue_deadletter_oldest_age_seconds %.0f\n", snapshot.DeadLetterOldestSeconds)
 	fmt.Fprintf(w, "yaat_sidecar_events_sent_total %d\n", snapshot.TotalEventsSent)
 	fmt.Fprintf(w, "yaat_sidecar_events_failed_total %d\n", snapshot.TotalEventsFailed)
 	fmt.Fprintf(w, "yaat_sidecar_throughput_per_min %.2f\n", snapshot.ThroughputPerMin)
+	if d := snapshot.Delivery; d != nil {
+		fmt.Fprintf(w, "yaat_sidecar_request_latency_ms{quantile=\"0.5\"} %d\n", d.P50LatencyMillis)
+		fmt.Fprintf(w, "yaat_sidecar_request_latency_ms{quantile=\"0.95\"} %d\n", d.P95LatencyMillis)
+		fmt.Fprintf(w, "yaat_sidecar_request_payload_bytes_avg %d\n", d.AvgPayloadBytes)
+		fmt.Fprintf(w, "yaat_sidecar_compression_ratio %.2f\n", d.CompressionRatio)
+	}
 	if snapshot.LastError != "" {
-		fmt.Fprintf(w, "yaat_sidecar_last_error{message=\"%s\"} 1\n", escapeLabel(snapshot.LastError))
+		fmt.Fprintf(w, "yaat_sidecar_last_error{message=\"%s\",category=\"%s\"} 1\n", escapeLabel(snapshot.LastError), snapshot.LastErrorCategory)
 	} else {
 		fmt.Fprintf(w, "yaat_sidecar_last_error 0\n")
 	}
@@ -0,0 +1,166 @@
+package health
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/yaat-app/sidecar/internal/diag"
+)
+
+func newTestHealth(token string) *Health {
+	h := New("127.0.0.1:0", "1.0.0", "svc", func() diag.Snapshot {
+		return diag.Snapshot{LastError: "POST https://api.example/ingest?key=secret failed"}
+	})
+	h.SetBearerToken(token)
+	return h
+}
+
+func get(t *testing.T, handler http.Handler, path, auth string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandlerWithoutTokenIsOpen(t *testing.T) {
+	handler := newTestHealth("").Handler()
+	for _, path := range []string{"/health", "/status", "/metrics", "/healthz"} {
+		if rec := get(t, handler, path, ""); rec.Code != http.StatusOK {
+			t.Errorf("Expected 200 for %s, got %d", path, rec.Code)
+		}
+	}
+}
+
+func TestHandlerRequiresBearerToken(t *testing.T) {
+	handler := newTestHealth("s3cret").Handler()
+
+	for _, path := range []string{"/health", "/status", "/metrics", "/", "/does-not-exist"} {
+		for _, auth := range []string{"", "Bearer wrong", "s3cret", "Basic czNjcmV0"} {
+			rec := get(t, handler, path, auth)
+			if rec.Code != http.StatusUnauthorized {
+				t.Errorf("Expected 401 for %s with %q, got %d", path, auth, rec.Code)
+			}
+			if strings.Contains(rec.Body.String(), "secret") || strings.Contains(rec.Body.String(), "svc") {
+				t.Errorf("Expected no diagnostics in a 401 body, got %q", rec.Body.String())
+			}
+			if rec.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("Expected WWW-Authenticate on 401 for %s", path)
+			}
+		}
+	}
+
+	// An unknown path gets the same 401 as a known one
+	known, unknown := get(t, handler, "/metrics", ""), get(t, handler, "/nope", "")
+	if known.Body.String() != unknown.Body.String() {
+		t.Errorf("Expected identical 401 responses, got %q and %q", known.Body.String(), unknown.Body.String())
+	}
+
+	for _, path := range []string{"/health", "/metrics"} {
+		if rec := get(t, handler, path, "Bearer s3cret"); rec.Code != http.StatusOK {
+			t.Errorf("Expected 200 for %s with the token, got %d", path, rec.Code)
+		}
+	}
+}
+
+func TestHealthzSkipsAuthAndDiagnostics(t *testing.T) {
+	handler := newTestHealth("s3cret").Handler()
+	rec := get(t, handler, "/healthz", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 for /healthz without a token, got %d", rec.Code)
+	}
+	if body := strings.TrimSpace(rec.Body.String()); body != "ok" {
+		t.Errorf("Expected plain ok, got %q", body)
+	}
+}
+
+func TestReadyzFailsOnAuthErrors(t *testing.T) {
+	snap := diag.Snapshot{LastError: "server error: 503", LastErrorCategory: diag.ErrorServer}
+	h := New("127.0.0.1:0", "1.0.0", "svc", func() diag.Snapshot { return snap })
+	h.SetBearerToken("s3cret")
+	handler := h.Handler()
+
+	if rec := get(t, handler, "/readyz", ""); rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 for /readyz without a token during a server outage, got %d", rec.Code)
+	}
+	snap = diag.Snapshot{LastError: "authentication failed: invalid API key", LastErrorCategory: diag.ErrorAuth}
+	if rec := get(t, handler, "/readyz", ""); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 for /readyz after an auth failure, got %d", rec.Code)
+	}
+	snap = diag.Snapshot{TrippedScrubRules: []string{"emails"}}
+	if rec := get(t, handler, "/readyz", ""); rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "emails") {
+		t.Errorf("Expected 200 without rule names for tripped scrubbing rules, got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestListenBindsConfiguredAddress(t *testing.T) {
+	ln, err := newTestHealth("").Listen()
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer ln.Close()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	if !addr.IP.IsLoopback() {
+		t.Errorf("Expected a loopback bind, got %v", addr)
+	}
+
+	go newTestHealth("").Serve(ln)
+	resp, err := http.Get("http://" + ln.Addr().String() + "/healthz")
+	if err != nil {
+		t.Fatalf("GET /healthz failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200, got %d", resp.StatusCode)
+	}
+
+	// The port is taken, so a second health endpoint fails up front
+	second := New(ln.Addr().String(), "1.0.0", "svc", nil)
+	if ln2, err := second.Listen(); err == nil {
+		ln2.Close()
+		t.Error("Expected Listen on a used address to fail")
+	}
+}
+
+func TestStatusPage(t *testing.T) {
+	h := New("127.0.0.1:0", "1.0.0", "svc", func() diag.Snapshot {
+		return diag.Snapshot{
+			TotalEventsSent: 42,
+			LastError:       "<script>alert(1)</script>",
+			Sources: map[string]diag.SourceStats{
+				"/var/log/app.log": {LinesRead: 7},
+				"journald":         {LinesRead: 3},
+			},
+		}
+	})
+
+	// Without SetUI, /ui is answered by the JSON catch-all
+	if rec := get(t, h.Handler(), "/ui", ""); strings.Contains(rec.Header().Get("Content-Type"), "html") {
+		t.Error("Expected no status page unless enabled")
+	}
+
+	h.SetUI(&UIConfig{
+		Settings: []Setting{{Name: "api_key", Value: "yaat_li***"}},
+		Sources:  []Source{{Path: "/var/log/app.log", Format: "django"}, {Path: "/var/log/idle.log", Format: "nginx"}},
+	})
+	rec := get(t, h.Handler(), "/ui", "")
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("Expected an HTML page, got %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	body := rec.Body.String()
+	for _, want := range []string{`http-equiv="refresh"`, "degraded", ">42<", "/var/log/app.log", "no lines read yet", "journald", "yaat_li***"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected the page to contain %q", want)
+		}
+	}
+	if strings.Contains(body, "<script>") {
+		t.Error("Expected diagnostics to be HTML-escaped")
+	}
+}
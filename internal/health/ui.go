@@ -0,0 +1,200 @@
+package health
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/diag"
+)
+
+// uiRefreshSeconds is how often the status page reloads itself.
+const uiRefreshSeconds = 5
+
+// UIConfig is what the status page shows besides diagnostics. Values are
+// displayed as given, so secrets must already be masked.
+type UIConfig struct {
+	Settings []Setting
+	Sources  []Source
+}
+
+// Setting is one configuration value on the status page.
+type Setting struct {
+	Name  string
+	Value string
+}
+
+// Source is a configured log input.
+type Source struct {
+	Path   string
+	Format string
+}
+
+// SetUI serves a self-refreshing HTML status page at /ui. A nil cfg disables it.
+func (h *Health) SetUI(cfg *UIConfig) {
+	h.ui = cfg
+}
+
+// uiSource pairs a configured source with its counters.
+type uiSource struct {
+	Source
+	Stats diag.SourceStats
+	Seen  bool // Counters were reported for the path
+}
+
+type uiPage struct {
+	Refresh     int
+	Status      string
+	Version     string
+	ServiceName string
+	Uptime      string
+	Now         string
+	Snapshot    diag.Snapshot
+	LastSuccess string
+	LastFailure string
+	Sources     []uiSource
+	Settings    []Setting
+}
+
+var uiTemplate = template.Must(template.New("ui").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="{{.Refresh}}">
+<title>YAAT Sidecar - {{.ServiceName}}</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2rem; color: #222; background: #fafafa; }
+h1 { font-size: 1.4rem; margin-bottom: 0.2rem; }
+h2 { font-size: 1.1rem; margin-top: 2rem; }
+.meta { color: #666; }
+.ok { color: #1a7f37; } .degraded { color: #b35900; } .bad { color: #c62828; }
+table { border-collapse: collapse; min-width: 32rem; background: #fff; }
+th, td { text-align: left; padding: 0.3rem 0.8rem; border-bottom: 1px solid #e5e5e5; }
+th { background: #f0f0f0; font-weight: 600; }
+td.num { text-align: right; font-variant-numeric: tabular-nums; }
+code { font-size: 0.9em; }
+</style>
+</head>
+<body>
+<h1>YAAT Sidecar v{{.Version}} - {{.ServiceName}}</h1>
+<p class="meta">Status: <strong class="{{.Status}}">{{.Status}}</strong> &middot; up {{.Uptime}} &middot; {{.Now}} &middot; refreshes every {{.Refresh}}s</p>
+
+<h2>Delivery</h2>
+<table>
+<tr><th>Events sent</th><td class="num">{{.Snapshot.TotalEventsSent}}</td></tr>
+<tr><th>Events failed</th><td class="num">{{.Snapshot.TotalEventsFailed}}</td></tr>
+<tr><th>Throughput</th><td class="num">{{printf "%.1f" .Snapshot.ThroughputPerMin}}/min</td></tr>
+<tr><th>Buffered</th><td class="num">{{.Snapshot.InMemoryQueue}} of {{.Snapshot.BufferCapacity}} (peak {{.Snapshot.BufferHighWater}})</td></tr>
+<tr><th>Queued on disk</th><td class="num">{{.Snapshot.PersistedEvents}} events in {{.Snapshot.PersistedQueue}} batches</td></tr>
+<tr><th>Dead-letter</th><td class="num">{{.Snapshot.DeadLetterEvents}} events in {{.Snapshot.DeadLetterQueue}} batches</td></tr>
+{{- if .Snapshot.DeadLetterLastError}}
+<tr><th>Dead-letter reason</th><td>{{.Snapshot.DeadLetterLastError}}</td></tr>
+{{- end}}
+<tr><th>Last success</th><td>{{.LastSuccess}}</td></tr>
+<tr><th>Last failure</th><td>{{.LastFailure}}</td></tr>
+{{- if .Snapshot.LastError}}
+<tr><th>Last error</th><td class="bad">{{with .Snapshot.LastErrorCategory}}[{{.}}] {{end}}{{.Snapshot.LastError}}</td></tr>
+{{- end}}
+{{- with .Snapshot.Delivery}}
+<tr><th>Latency p50 / p95</th><td class="num">{{.P50LatencyMillis}} ms / {{.P95LatencyMillis}} ms</td></tr>
+{{- end}}
+</table>
+
+<h2>Log sources</h2>
+{{- if .Sources}}
+<table>
+<tr><th>Path</th><th>Format</th><th>Lines read</th><th>Possible loss</th><th>Sampled out</th><th>Spans sampled out</th><th>Parse failures</th></tr>
+{{- range .Sources}}
+<tr>
+<td><code>{{.Path}}</code></td><td>{{.Format}}</td>
+{{- if .Seen}}
+<td class="num">{{.Stats.LinesRead}}</td>
+<td class="num{{if .Stats.PossibleLoss}} bad{{end}}">{{.Stats.PossibleLoss}}{{if .Stats.SkippedBytes}} ({{.Stats.SkippedBytes}} bytes){{end}}</td>
+<td class="num">{{.Stats.SampledOut}}</td>
+<td class="num">{{.Stats.SpansSampledOut}}</td>
+<td class="num{{if .Stats.ParseFailures}} degraded{{end}}">{{.Stats.ParseFailures}}</td>
+{{- else}}
+<td class="num degraded" colspan="5">no lines read yet</td>
+{{- end}}
+</tr>
+{{- end}}
+</table>
+{{- else}}
+<p class="meta">No log sources configured.</p>
+{{- end}}
+
+{{- if .Settings}}
+<h2>Configuration</h2>
+<table>
+{{- range .Settings}}
+<tr><th>{{.Name}}</th><td><code>{{.Value}}</code></td></tr>
+{{- end}}
+</table>
+{{- end}}
+</body>
+</html>
+`))
+
+// handleUI renders the status page.
+func (h *Health) handleUI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	page := uiPage{
+		Refresh:     uiRefreshSeconds,
+		Status:      "ok",
+		Version:     h.version,
+		ServiceName: h.serviceName,
+		Uptime:      time.Since(h.startTime).Round(time.Second).String(),
+		Now:         time.Now().Format(time.DateTime),
+		Settings:    h.ui.Settings,
+	}
+	if h.snapshotFn != nil {
+		page.Snapshot = h.snapshotFn()
+	}
+	if page.Snapshot.LastError != "" {
+		page.Status = "degraded"
+	}
+	page.LastSuccess = formatSince(page.Snapshot.LastSuccessAt)
+	page.LastFailure = formatSince(page.Snapshot.LastFailureAt)
+	page.Sources = uiSources(h.ui.Sources, page.Snapshot.Sources)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := uiTemplate.Execute(w, page); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// uiSources lists the configured sources, then any others that reported
+// counters (e.g. journald), ordered by path.
+func uiSources(configured []Source, stats map[string]diag.SourceStats) []uiSource {
+	out := make([]uiSource, 0, len(configured))
+	listed := make(map[string]bool, len(configured))
+	for _, src := range configured {
+		s, ok := stats[src.Path]
+		out = append(out, uiSource{Source: src, Stats: s, Seen: ok})
+		listed[src.Path] = true
+	}
+	var extra []string
+	for path := range stats {
+		if !listed[path] {
+			extra = append(extra, path)
+		}
+	}
+	sort.Strings(extra)
+	for _, path := range extra {
+		out = append(out, uiSource{Source: Source{Path: path}, Stats: stats[path], Seen: true})
+	}
+	return out
+}
+
+func formatSince(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return fmt.Sprintf("%s (%s ago)", t.Local().Format(time.DateTime), time.Since(t).Round(time.Second))
+}
@@ -0,0 +1,132 @@
+// Package heartbeat periodically reports the sidecar's own health as an event
+// so the backend can spot agents that stopped reporting or are falling behind.
+package heartbeat
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+	"github.com/yaat-app/sidecar/internal/diag"
+	"github.com/yaat-app/sidecar/internal/logging"
+)
+
+var logger = logging.New("Heartbeat")
+
+// Emitter adds a heartbeat event to the buffer on every interval.
+type Emitter struct {
+	organizationID string
+	serviceName    string
+	environment    string
+	version        string
+	tags           map[string]string
+	interval       time.Duration
+	buf            *buffer.Buffer
+	snapshot       func() diag.Snapshot
+	started        time.Time
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// New creates an emitter. Global tags, including detected cloud and
+// Kubernetes metadata, are copied onto every heartbeat.
+func New(organizationID, serviceName, environment, version string, globalTags map[string]string, interval time.Duration, buf *buffer.Buffer, snapshotFn func() diag.Snapshot) *Emitter {
+	tagsCopy := make(map[string]string, len(globalTags))
+	for k, v := range globalTags {
+		tagsCopy[k] = v
+	}
+	return &Emitter{
+		organizationID: organizationID,
+		serviceName:    serviceName,
+		environment:    environment,
+		version:        version,
+		tags:           tagsCopy,
+		interval:       interval,
+		buf:            buf,
+		snapshot:       snapshotFn,
+		started:        time.Now(),
+		stop:           make(chan struct{}),
+	}
+}
+
+// Start sends a heartbeat immediately and then on every interval. Call the
+// returned function to stop the emitter.
+func (e *Emitter) Start() func() {
+	e.wg.Add(1)
+	ticker := time.NewTicker(e.interval)
+
+	go func() {
+		defer e.wg.Done()
+		defer ticker.Stop()
+
+		e.emit()
+		for {
+			select {
+			case <-ticker.C:
+				e.emit()
+			case <-e.stop:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(e.stop)
+		e.wg.Wait()
+	}
+}
+
+// emit buffers a heartbeat. Add keeps it even when the buffer is at
+// buffer_size and reports that; it waits for the next flush then.
+func (e *Emitter) emit() {
+	if full := e.buf.Add(e.event(time.Now())); full {
+		logger.Warnf("Buffer full, heartbeat waits for the next flush")
+	}
+}
+
+// event builds the heartbeat from the current diagnostics snapshot.
+func (e *Emitter) event(now time.Time) buffer.Event {
+	snap := e.snapshot()
+	uptime := now.Sub(e.started)
+
+	tags := make(map[string]string, len(e.tags)+10)
+	for k, v := range e.tags {
+		tags[k] = v
+	}
+	tags["yaat.sidecar"] = "true"
+	tags["sidecar.version"] = e.version
+	tags["uptime_seconds"] = strconv.FormatInt(int64(uptime.Seconds()), 10)
+	tags["queue.in_memory"] = strconv.Itoa(snap.InMemoryQueue)
+	tags["queue.persisted_events"] = strconv.Itoa(snap.PersistedEvents)
+	tags["queue.persisted_batches"] = strconv.Itoa(snap.PersistedQueue)
+	tags["queue.dead_letter_events"] = strconv.Itoa(snap.DeadLetterEvents)
+	tags["events_sent"] = strconv.FormatInt(snap.TotalEventsSent, 10)
+	tags["events_failed"] = strconv.FormatInt(snap.TotalEventsFailed, 10)
+	if snap.LastError != "" {
+		tags["last_error"] = snap.LastError
+	}
+
+	level := "info"
+	if snap.TotalEventsFailed > 0 && snap.LastFailureAt.After(snap.LastSuccessAt) {
+		level = "warning"
+	}
+
+	return buffer.Event{
+		"organization_id": e.organizationID,
+		"service_name":    e.serviceName,
+		"event_id":        uuid.New().String(),
+		"timestamp":       now.UTC().Format(time.RFC3339Nano),
+		"event_type":      "log",
+		"environment":     e.environment,
+		"level":           level,
+		"logger":          "yaat.sidecar.heartbeat",
+		"message": fmt.Sprintf("Sidecar v%s alive for %s: %d sent, %d failed, %d queued",
+			e.version, uptime.Truncate(time.Second), snap.TotalEventsSent, snap.TotalEventsFailed, snap.QueueLength),
+		"tags": tags,
+	}
+}
@@ -0,0 +1,110 @@
+package heartbeat
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+	"github.com/yaat-app/sidecar/internal/diag"
+	"github.com/yaat-app/sidecar/internal/logging"
+)
+
+func TestHeartbeatEvent(t *testing.T) {
+	snap := diag.Snapshot{
+		InMemoryQueue:     3,
+		PersistedQueue:    2,
+		PersistedEvents:   40,
+		DeadLetterEvents:  5,
+		QueueLength:       43,
+		TotalEventsSent:   1200,
+		TotalEventsFailed: 7,
+	}
+	e := New("org_123", "svc", "prod", "1.2.3", map[string]string{"cloud.provider": "gcp"}, time.Minute, buffer.New(10), func() diag.Snapshot {
+		return snap
+	})
+	e.started = time.Now().Add(-90 * time.Second)
+
+	event := e.event(time.Now())
+	if event["organization_id"] != "org_123" || event["service_name"] != "svc" || event["environment"] != "prod" {
+		t.Errorf("Expected identity fields, got %v", event)
+	}
+	if event["logger"] != "yaat.sidecar.heartbeat" {
+		t.Errorf("Expected heartbeat logger, got %v", event["logger"])
+	}
+	if event["level"] != "info" {
+		t.Errorf("Expected info level, got %v", event["level"])
+	}
+	if !strings.Contains(event["message"].(string), "v1.2.3") {
+		t.Errorf("Expected version in message, got %v", event["message"])
+	}
+
+	tags := event["tags"].(map[string]string)
+	expected := map[string]string{
+		"sidecar.version":          "1.2.3",
+		"uptime_seconds":           "90",
+		"queue.in_memory":          "3",
+		"queue.persisted_events":   "40",
+		"queue.persisted_batches":  "2",
+		"queue.dead_letter_events": "5",
+		"events_sent":              "1200",
+		"events_failed":            "7",
+		"cloud.provider":           "gcp",
+	}
+	for k, want := range expected {
+		if tags[k] != want {
+			t.Errorf("Expected tag %s=%s, got %q", k, want, tags[k])
+		}
+	}
+}
+
+func TestHeartbeatWarnsWhileFailing(t *testing.T) {
+	state := &diag.State{}
+	state.RecordSendSuccess(10)
+	time.Sleep(time.Millisecond)
+	state.RecordSendFailure(errors.New("server error: 503"), diag.ErrorServer, 4)
+
+	e := New("org", "svc", "prod", "1.0.0", nil, time.Minute, buffer.New(10), state.Snapshot)
+	event := e.event(time.Now())
+	if event["level"] != "warning" {
+		t.Errorf("Expected warning level after a failed send, got %v", event["level"])
+	}
+	if tags := event["tags"].(map[string]string); tags["last_error"] != "server error: 503" {
+		t.Errorf("Expected last_error tag, got %q", tags["last_error"])
+	}
+}
+
+func TestHeartbeatStartEmitsImmediately(t *testing.T) {
+	buf := buffer.New(10)
+	e := New("org", "svc", "prod", "1.0.0", nil, time.Hour, buf, func() diag.Snapshot { return diag.Snapshot{} })
+	stop := e.Start()
+	defer stop()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for buf.Len() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if buf.Len() != 1 {
+		t.Errorf("Expected one heartbeat on start, got %d events", buf.Len())
+	}
+}
+
+func TestHeartbeatWarnsOnlyWhenBufferFull(t *testing.T) {
+	var out bytes.Buffer
+	logging.SetOutput(&out)
+	defer logging.SetOutput(os.Stderr)
+
+	snapshot := func() diag.Snapshot { return diag.Snapshot{} }
+	New("org", "svc", "prod", "1.0.0", nil, time.Hour, buffer.New(10), snapshot).emit()
+	if strings.Contains(out.String(), "Buffer full") {
+		t.Errorf("Expected no warning for a heartbeat that fit, got %q", out.String())
+	}
+
+	New("org", "svc", "prod", "1.0.0", nil, time.Hour, buffer.New(1), snapshot).emit()
+	if !strings.Contains(out.String(), "Buffer full") {
+		t.Errorf("Expected a warning once the buffer is full, got %q", out.String())
+	}
+}
@@ -0,0 +1,277 @@
+package localapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+	"github.com/yaat-app/sidecar/internal/config"
+	"github.com/yaat-app/sidecar/internal/forwarder"
+	"github.com/yaat-app/sidecar/internal/logging"
+	"github.com/yaat-app/sidecar/internal/scrubber"
+)
+
+var logger = logging.New("LocalAPI")
+
+// Defaults used when the config leaves a limit at zero.
+const (
+	defaultMaxBodyBytes       = 1 << 20
+	defaultMaxEventsPerMinute = 6000
+)
+
+// Server accepts custom events as JSON over HTTP and buffers them like any
+// other input.
+type Server struct {
+	addr           string
+	maxBodyBytes   int64
+	perMinute      int
+	tags           map[string]string
+	organizationID string
+	service        string
+	env            string
+	buf            *buffer.Buffer
+
+	mu         sync.Mutex
+	tokens     float64
+	refilled   time.Time
+	listenAddr string
+	now        func() time.Time
+}
+
+// Result reports what happened to one submitted event.
+type Result struct {
+	Index    int    `json:"index"`
+	Accepted bool   `json:"accepted"`
+	EventID  string `json:"event_id,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Response is the reply to a well-formed /ingest request.
+type Response struct {
+	Accepted int      `json:"accepted"`
+	Rejected int      `json:"rejected"`
+	Results  []Result `json:"results"`
+}
+
+// New creates a local API server. Events default to the sidecar's
+// organization, service and environment and get the global tags.
+func New(cfg config.LocalAPIConfig, organizationID, serviceName, environment string, globalTags map[string]string, buf *buffer.Buffer) *Server {
+	maxBody := cfg.MaxBodyBytes
+	if maxBody <= 0 {
+		maxBody = defaultMaxBodyBytes
+	}
+	perMinute := cfg.MaxEventsPerMinute
+	if perMinute <= 0 {
+		perMinute = defaultMaxEventsPerMinute
+	}
+	s := &Server{
+		addr:           cfg.ListenAddr,
+		maxBodyBytes:   maxBody,
+		perMinute:      perMinute,
+		tags:           globalTags,
+		organizationID: organizationID,
+		service:        serviceName,
+		env:            environment,
+		buf:            buf,
+		tokens:         float64(perMinute),
+		now:            time.Now,
+	}
+	s.refilled = s.now()
+	return s
+}
+
+// Start binds the configured address and serves until the returned func is
+// called.
+func (s *Server) Start() (func(), error) {
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return nil, fmt.Errorf("listen tcp %s: %w", s.addr, err)
+	}
+	s.mu.Lock()
+	s.listenAddr = ln.Addr().String()
+	s.mu.Unlock()
+
+	srv := &http.Server{Handler: s.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Errorf("Local API error: %v", err)
+		}
+	}()
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(ctx)
+		<-done
+	}, nil
+}
+
+// Addr returns the listener address, useful for tests.
+func (s *Server) Addr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.listenAddr
+}
+
+// Handler routes POST /ingest.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ingest", s.handleIngest)
+	return mux
+}
+
+// handleIngest accepts a JSON array of events. Each is validated, scrubbed
+// and buffered on its own; the reply lists the outcome per event.
+func (s *Server) handleIngest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.maxBodyBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("Request body exceeds %d bytes", s.maxBodyBytes), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+	var raw []json.RawMessage
+	if err := json.Unmarshal(body, &raw); err != nil {
+		http.Error(w, "Request body must be a JSON array of events", http.StatusBadRequest)
+		return
+	}
+
+	resp := Response{Results: make([]Result, 0, len(raw))}
+	limited := false
+	now := s.now()
+	for i, msg := range raw {
+		result := Result{Index: i}
+		evt, err := s.accept(msg, now)
+		switch {
+		case errors.Is(err, errRateLimited):
+			limited = true
+			result.Error = err.Error()
+		case err != nil:
+			result.Error = err.Error()
+		default:
+			result.Accepted = true
+			result.EventID, _ = evt["event_id"].(string)
+		}
+		if result.Accepted {
+			resp.Accepted++
+		} else {
+			resp.Rejected++
+		}
+		resp.Results = append(resp.Results, result)
+	}
+
+	status := http.StatusOK
+	if resp.Accepted == 0 && resp.Rejected > 0 {
+		status = http.StatusBadRequest
+		if limited {
+			status = http.StatusTooManyRequests
+			w.Header().Set("Retry-After", strconv.Itoa(s.retryAfter()))
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+var errRateLimited = errors.New("rate limit exceeded")
+
+// accept turns one array element into a buffered event.
+func (s *Server) accept(msg json.RawMessage, now time.Time) (buffer.Event, error) {
+	var evt buffer.Event
+	if err := json.Unmarshal(msg, &evt); err != nil || evt == nil {
+		return nil, fmt.Errorf("event must be a JSON object")
+	}
+	s.applyDefaults(evt)
+	if err := forwarder.NormalizeEvent(evt, now); err != nil {
+		return nil, err
+	}
+	s.mergeGlobalTags(evt)
+
+	if !s.take() {
+		return nil, errRateLimited
+	}
+	if !scrubber.Apply(evt) {
+		return nil, fmt.Errorf("dropped by a scrubbing rule")
+	}
+	s.buf.Add(evt)
+	return evt, nil
+}
+
+// applyDefaults fills in the sidecar's identity where the event has none.
+func (s *Server) applyDefaults(evt buffer.Event) {
+	defaults := map[string]string{
+		"organization_id": s.organizationID,
+		"service_name":    s.service,
+		"environment":     s.env,
+	}
+	for key, value := range defaults {
+		if current, ok := evt[key].(string); ok && current != "" {
+			continue
+		}
+		if value != "" {
+			evt[key] = value
+		}
+	}
+}
+
+// mergeGlobalTags adds global tags to evt; event tags take priority.
+// NormalizeEvent has already given evt its own map[string]string.
+func (s *Server) mergeGlobalTags(evt buffer.Event) {
+	tags, _ := evt["tags"].(map[string]string)
+	if tags == nil {
+		return
+	}
+	for k, v := range s.tags {
+		if _, exists := tags[k]; !exists {
+			tags[k] = v
+		}
+	}
+}
+
+// take spends one token from the bucket, which refills at perMinute per
+// minute up to perMinute.
+func (s *Server) take() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.tokens += now.Sub(s.refilled).Minutes() * float64(s.perMinute)
+	if max := float64(s.perMinute); s.tokens > max {
+		s.tokens = max
+	}
+	s.refilled = now
+
+	if s.tokens >= 1 {
+		s.tokens--
+		return true
+	}
+	return false
+}
+
+// retryAfter returns the whole seconds until the bucket holds a token.
+func (s *Server) retryAfter() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seconds := (1 - s.tokens) * 60 / float64(s.perMinute)
+	return int(math.Max(1, math.Ceil(seconds)))
+}
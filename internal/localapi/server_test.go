@@ -0,0 +1,132 @@
+package localapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+	"github.com/yaat-app/sidecar/internal/config"
+)
+
+func post(t *testing.T, s *Server, body string) (*httptest.ResponseRecorder, Response) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ingest", strings.NewReader(body)))
+	var resp Response
+	if strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") {
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Failed to decode response %q: %v", rec.Body.String(), err)
+		}
+	}
+	return rec, resp
+}
+
+func TestIngestValidEvents(t *testing.T) {
+	buf := buffer.New(10)
+	s := New(config.LocalAPIConfig{}, "org_123", "svc", "prod", map[string]string{"region": "eu", "team": "core"}, buf)
+
+	rec, resp := post(t, s, `[
+		{"message": "deploy finished", "level": "INFO", "tags": {"team": "web", "build": 42}},
+		{"event_type": "metric", "service_name": "billing", "metric_name": "jobs.done", "metric_value": 3}
+	]`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if resp.Accepted != 2 || resp.Rejected != 0 {
+		t.Fatalf("Expected 2 accepted, got %+v", resp)
+	}
+	if resp.Results[0].EventID == "" {
+		t.Error("Expected an event_id for an accepted event")
+	}
+
+	events := buf.Flush()
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 buffered events, got %d", len(events))
+	}
+	first := events[0]
+	if first["organization_id"] != "org_123" || first["service_name"] != "svc" || first["environment"] != "prod" {
+		t.Errorf("Expected sidecar defaults, got %v", first)
+	}
+	if first["level"] != "info" || first["event_type"] != "log" {
+		t.Errorf("Expected normalized level and event_type, got %v / %v", first["level"], first["event_type"])
+	}
+	tags := first["tags"].(map[string]string)
+	if tags["team"] != "web" || tags["region"] != "eu" || tags["build"] != "42" {
+		t.Errorf("Expected event tags merged over global tags, got %v", tags)
+	}
+	if events[1]["service_name"] != "billing" {
+		t.Errorf("Expected the event's own service_name, got %v", events[1]["service_name"])
+	}
+}
+
+func TestIngestInvalidEvents(t *testing.T) {
+	buf := buffer.New(10)
+	s := New(config.LocalAPIConfig{}, "org_123", "svc", "prod", nil, buf)
+
+	rec, resp := post(t, s, `[{"message": "ok"}, {"event_type": "trace"}, 7, {"timestamp": "yesterday"}]`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 with one valid event, got %d", rec.Code)
+	}
+	if resp.Accepted != 1 || resp.Rejected != 3 {
+		t.Fatalf("Expected 1 accepted and 3 rejected, got %+v", resp)
+	}
+	for _, result := range resp.Results[1:] {
+		if result.Accepted || result.Error == "" {
+			t.Errorf("Expected event %d rejected with a reason, got %+v", result.Index, result)
+		}
+	}
+	if buf.Len() != 1 {
+		t.Errorf("Expected only the valid event buffered, got %d", buf.Len())
+	}
+
+	rec, resp = post(t, s, `[{"event_type": "trace"}]`)
+	if rec.Code != http.StatusBadRequest || resp.Rejected != 1 {
+		t.Errorf("Expected 400 when every event is rejected, got %d %+v", rec.Code, resp)
+	}
+
+	for _, body := range []string{`{"message": "not an array"}`, `not json`} {
+		if rec, _ := post(t, s, body); rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %q, got %d", body, rec.Code)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ingest", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for GET, got %d", rec.Code)
+	}
+}
+
+func TestIngestOversizedPayload(t *testing.T) {
+	buf := buffer.New(10)
+	s := New(config.LocalAPIConfig{MaxBodyBytes: 64}, "org_123", "svc", "prod", nil, buf)
+
+	rec, _ := post(t, s, `[{"message": "`+strings.Repeat("x", 100)+`"}]`)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413, got %d", rec.Code)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Expected nothing buffered, got %d", buf.Len())
+	}
+}
+
+func TestIngestRateLimit(t *testing.T) {
+	buf := buffer.New(10)
+	s := New(config.LocalAPIConfig{MaxEventsPerMinute: 2}, "org_123", "svc", "prod", nil, buf)
+
+	_, resp := post(t, s, `[{"message": "a"}, {"message": "b"}, {"message": "c"}]`)
+	if resp.Accepted != 2 || resp.Results[2].Error != errRateLimited.Error() {
+		t.Fatalf("Expected the third event rate limited, got %+v", resp)
+	}
+
+	rec, _ := post(t, s, `[{"message": "d"}]`)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected 429 once the allowance is spent, got %d", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("Expected a Retry-After header")
+	}
+}
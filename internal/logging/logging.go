@@ -0,0 +1,257 @@
+// Package logging is the sidecar's leveled logger for its own diagnostics.
+// Text output keeps the familiar "[Component] message" lines written through
+// the standard library logger; JSON output writes one object per line with
+// timestamp, level, component and message.
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log entry.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	default:
+		return "info"
+	}
+}
+
+// ParseLevel validates a log_level / --log-level value. Empty means info.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return LevelDebug, nil
+	case "", "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q (expected debug, info, warn or error)", s)
+}
+
+// Format selects how entries are written.
+type Format string
+
+const (
+	FormatText Format = "text"
+	FormatJSON Format = "json"
+)
+
+// ParseFormat validates a log_format / --log-format value. Empty means text.
+func ParseFormat(s string) (Format, error) {
+	switch Format(strings.ToLower(strings.TrimSpace(s))) {
+	case "", FormatText:
+		return FormatText, nil
+	case FormatJSON:
+		return FormatJSON, nil
+	}
+	return "", fmt.Errorf("unknown log format %q (expected text or json)", s)
+}
+
+var (
+	mu       sync.Mutex
+	out      io.Writer = os.Stderr
+	format             = FormatText
+	verbose  bool
+	minLevel = LevelInfo
+)
+
+// Configure sets the destination and format for all loggers. Verbose output
+// includes the calling file and line and lowers the level to debug.
+func Configure(w io.Writer, f Format, isVerbose bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	out, format, verbose = w, f, isVerbose
+	minLevel = LevelInfo
+	if isVerbose {
+		minLevel = LevelDebug
+	}
+	configureStdlib()
+}
+
+// SetFormat switches the output format, keeping the destination and level.
+func SetFormat(f Format) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	format = f
+	configureStdlib()
+}
+
+// SetOutput switches the destination, keeping the format and level.
+func SetOutput(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	out = w
+	configureStdlib()
+}
+
+// SetLevel sets the minimum level written; entries below it are dropped.
+func SetLevel(l Level) {
+	mu.Lock()
+	minLevel = l
+	mu.Unlock()
+}
+
+// configureStdlib routes stray standard library logging through the same
+// output. Callers hold mu.
+func configureStdlib() {
+	switch {
+	case format == FormatJSON:
+		log.SetFlags(0)
+		log.SetOutput(stdlibWriter{})
+	case verbose:
+		log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds | log.Lshortfile)
+		log.SetOutput(out)
+	default:
+		log.SetFlags(log.Ldate | log.Ltime)
+		log.SetOutput(out)
+	}
+}
+
+// Logger writes entries tagged with a component name.
+type Logger struct {
+	component string
+}
+
+// New returns a logger for component, e.g. "Proxy" or "Forwarder".
+func New(component string) *Logger {
+	return &Logger{component: component}
+}
+
+// Debugf logs a debug entry; it is only written at the debug level.
+func (l *Logger) Debugf(msg string, args ...interface{}) {
+	l.output(LevelDebug, msg, args...)
+}
+
+// Infof logs an informational entry.
+func (l *Logger) Infof(msg string, args ...interface{}) {
+	l.output(LevelInfo, msg, args...)
+}
+
+// Warnf logs a warning.
+func (l *Logger) Warnf(msg string, args ...interface{}) {
+	l.output(LevelWarn, msg, args...)
+}
+
+// Errorf logs an error.
+func (l *Logger) Errorf(msg string, args ...interface{}) {
+	l.output(LevelError, msg, args...)
+}
+
+// Fatalf logs an error and exits with status 1.
+func (l *Logger) Fatalf(msg string, args ...interface{}) {
+	l.output(LevelError, msg, args...)
+	os.Exit(1)
+}
+
+// callDepth is the number of frames between the caller of a Logger method
+// and the call into the standard library logger or runtime.Caller.
+const callDepth = 3
+
+func (l *Logger) output(level Level, msg string, args ...interface{}) {
+	mu.Lock()
+	f, w, v, min := format, out, verbose, minLevel
+	mu.Unlock()
+
+	if level < min {
+		return
+	}
+	if len(args) > 0 {
+		msg = fmt.Sprintf(msg, args...)
+	}
+
+	if f != FormatJSON {
+		if level == LevelWarn {
+			msg = "Warning: " + msg
+		}
+		log.Output(callDepth, "["+l.component+"] "+msg)
+		return
+	}
+
+	entry := jsonEntry{
+		Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+		Level:     level.String(),
+		Component: l.component,
+		Message:   msg,
+	}
+	if v {
+		if _, file, line, ok := runtime.Caller(callDepth - 1); ok {
+			entry.Caller = fmt.Sprintf("%s:%d", filepath.Base(file), line)
+		}
+	}
+	writeJSON(w, entry)
+}
+
+type jsonEntry struct {
+	Timestamp string `json:"timestamp"`
+	Level     string `json:"level"`
+	Component string `json:"component"`
+	Message   string `json:"message"`
+	Caller    string `json:"caller,omitempty"`
+}
+
+func writeJSON(w io.Writer, entry jsonEntry) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	mu.Lock()
+	defer mu.Unlock()
+	w.Write(line)
+}
+
+// stdlibWriter converts lines from the standard library logger into JSON
+// entries, taking the component from a leading "[Component]" if present.
+type stdlibWriter struct{}
+
+func (stdlibWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimRight(string(p), "\n")
+	component := "stdlib"
+	if strings.HasPrefix(msg, "[") {
+		if end := strings.Index(msg, "] "); end > 1 {
+			component, msg = msg[1:end], msg[end+2:]
+		}
+	}
+
+	mu.Lock()
+	w := out
+	mu.Unlock()
+	writeJSON(w, jsonEntry{
+		Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+		Level:     LevelInfo.String(),
+		Component: component,
+		Message:   msg,
+	})
+	return len(p), nil
+}
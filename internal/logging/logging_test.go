@@ -0,0 +1,151 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestJSONOutput(t *testing.T) {
+	var out bytes.Buffer
+	Configure(&out, FormatJSON, false)
+	defer Configure(os.Stderr, FormatText, false)
+
+	logger := New("Proxy")
+	logger.Warnf("upstream slow: %dms", 250)
+	logger.Debugf("hidden unless verbose")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Expected 1 line, got %d: %q", len(lines), out.String())
+	}
+
+	var entry map[string]string
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("Invalid JSON %q: %v", lines[0], err)
+	}
+	if entry["level"] != "warn" {
+		t.Errorf("Expected level warn, got %q", entry["level"])
+	}
+	if entry["component"] != "Proxy" {
+		t.Errorf("Expected component Proxy, got %q", entry["component"])
+	}
+	if entry["message"] != "upstream slow: 250ms" {
+		t.Errorf("Expected formatted message, got %q", entry["message"])
+	}
+	if entry["timestamp"] == "" {
+		t.Error("Expected timestamp")
+	}
+	if _, ok := entry["caller"]; ok {
+		t.Error("Expected no caller outside verbose mode")
+	}
+}
+
+func TestJSONVerboseCaller(t *testing.T) {
+	var out bytes.Buffer
+	Configure(&out, FormatJSON, true)
+	defer Configure(os.Stderr, FormatText, false)
+
+	New("Tailer").Debugf("debug entry")
+
+	var entry map[string]string
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("Invalid JSON %q: %v", out.String(), err)
+	}
+	if entry["level"] != "debug" {
+		t.Errorf("Expected level debug, got %q", entry["level"])
+	}
+	if !strings.HasPrefix(entry["caller"], "logging_test.go:") {
+		t.Errorf("Expected caller in this file, got %q", entry["caller"])
+	}
+}
+
+func TestJSONWrapsStdlibLog(t *testing.T) {
+	var out bytes.Buffer
+	Configure(&out, FormatJSON, false)
+	defer Configure(os.Stderr, FormatText, false)
+
+	log.Printf("[Legacy] plain message")
+
+	var entry map[string]string
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("Invalid JSON %q: %v", out.String(), err)
+	}
+	if entry["component"] != "Legacy" || entry["message"] != "plain message" {
+		t.Errorf("Expected component and message split, got %v", entry)
+	}
+}
+
+func TestTextOutput(t *testing.T) {
+	var out bytes.Buffer
+	Configure(&out, FormatText, false)
+	defer Configure(os.Stderr, FormatText, false)
+
+	logger := New("Forwarder")
+	logger.Infof("Successfully sent %d events", 3)
+	logger.Warnf("payload too large")
+
+	text := out.String()
+	if !strings.Contains(text, "[Forwarder] Successfully sent 3 events\n") {
+		t.Errorf("Expected component prefix, got %q", text)
+	}
+	if !strings.Contains(text, "[Forwarder] Warning: payload too large\n") {
+		t.Errorf("Expected warning prefix, got %q", text)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for input, want := range map[string]Format{"": FormatText, "text": FormatText, "JSON": FormatJSON} {
+		got, err := ParseFormat(input)
+		if err != nil || got != want {
+			t.Errorf("ParseFormat(%q) = %q, %v; expected %q", input, got, err, want)
+		}
+	}
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Error("Expected error for unknown format")
+	}
+}
+
+func TestLevelFiltering(t *testing.T) {
+	var out bytes.Buffer
+	Configure(&out, FormatText, false)
+	defer Configure(os.Stderr, FormatText, false)
+	SetLevel(LevelWarn)
+
+	logger := New("Forwarder")
+	logger.Debugf("debug")
+	logger.Infof("info")
+	logger.Warnf("retrying")
+	logger.Errorf("failed")
+
+	text := out.String()
+	if strings.Contains(text, "debug") || strings.Contains(text, "info") {
+		t.Errorf("Expected entries below warn to be dropped, got %q", text)
+	}
+	if !strings.Contains(text, "Warning: retrying") || !strings.Contains(text, "failed") {
+		t.Errorf("Expected warn and error entries, got %q", text)
+	}
+
+	// Switching format keeps the level
+	out.Reset()
+	SetFormat(FormatJSON)
+	logger.Infof("info")
+	if out.Len() != 0 {
+		t.Errorf("Expected level to survive SetFormat, got %q", out.String())
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for input, want := range map[string]Level{"": LevelInfo, "debug": LevelDebug, "WARN": LevelWarn, "warning": LevelWarn, "error": LevelError} {
+		got, err := ParseLevel(input)
+		if err != nil || got != want {
+			t.Errorf("ParseLevel(%q) = %v, %v; expected %v", input, got, err, want)
+		}
+	}
+	if _, err := ParseLevel("trace"); err == nil {
+		t.Error("Expected error for unknown level")
+	}
+}
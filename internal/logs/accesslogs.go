@@ -0,0 +1,389 @@
+package logs
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yaat-app/sidecar/internal/buffer"
+)
+
+// albFieldCount is the number of fields up to request_creation_time; newer
+// ALB log versions append more, older ones never have fewer.
+const albFieldCount = 22
+
+// ParseALBLog parses an AWS Application Load Balancer access log line
+// Format: type time elb client:port target:port request_processing_time target_processing_time response_processing_time elb_status_code target_status_code received_bytes sent_bytes "request" "user_agent" ...
+func ParseALBLog(line, organizationID, serviceName, environment string) *buffer.Event {
+	fields := splitQuotedFields(line)
+	if len(fields) < albFieldCount {
+		return nil
+	}
+	method, target, ok := strings.Cut(fields[12], " ")
+	if !ok {
+		return nil
+	}
+	target, _, _ = strings.Cut(target, " ") // Drop the protocol version
+
+	timestamp, parsed := parseTimestamp(fields[1], time.UTC)
+	if !parsed {
+		timestamp = time.Now().UTC()
+	}
+
+	// Time spent in the load balancer and the target; -1 when the request
+	// never reached a target
+	durationMs := 0.0
+	for _, raw := range fields[5:7] {
+		if seconds, err := strconv.ParseFloat(raw, 64); err == nil && seconds > 0 {
+			durationMs += seconds * 1000
+		}
+	}
+
+	status, _ := strconv.Atoi(fields[8])
+	size, _ := strconv.Atoi(fields[11])
+
+	tags := map[string]string{
+		"method":       method,
+		"path":         requestPath(target),
+		"client_ip":    hostOnly(fields[3]),
+		"content_size": fields[11],
+		"lb.type":      fields[0],
+		"lb.name":      fields[2],
+	}
+	optional := map[string]string{
+		"lb.target":             fields[4],
+		"lb.target_status_code": fields[9],
+		"user_agent":            fields[13],
+		"aws.trace_id":          fields[17],
+		"http.host":             fields[18],
+	}
+	if len(fields) > 24 {
+		optional["lb.error_reason"] = fields[24]
+	}
+	for k, v := range optional {
+		if v != "" && v != "-" {
+			tags[k] = v
+		}
+	}
+
+	event := &buffer.Event{
+		"organization_id": organizationID,
+		"service_name":    serviceName,
+		"event_id":        uuid.New().String(),
+		"timestamp":       timestamp.Format(time.RFC3339Nano),
+		"event_type":      "span",
+		"environment":     environment,
+		"trace_id":        uuid.New().String(),
+		"span_id":         uuid.New().String(),
+		"parent_span_id":  "",
+		"operation":       method + " " + tags["path"],
+		"duration_ms":     durationMs,
+		"status_code":     status,
+		"tags":            tags,
+		"metric_value":    float64(size),
+	}
+	if !parsed {
+		preserveOriginalTimestamp(event, fields[1])
+	}
+	return event
+}
+
+// ParseCloudflareLog parses a Cloudflare Logpush HTTP requests JSON line
+func ParseCloudflareLog(line, organizationID, serviceName, environment string) *buffer.Event {
+	return parseCloudflareLog(line, organizationID, serviceName, environment, time.UTC)
+}
+
+func parseCloudflareLog(line, organizationID, serviceName, environment string, loc *time.Location) *buffer.Event {
+	// Numbers are kept exact: Unix nanosecond timestamps overflow a float64
+	var record map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(line))
+	decoder.UseNumber()
+	if err := decoder.Decode(&record); err != nil {
+		return parseJSONLog(line, organizationID, serviceName, environment, loc)
+	}
+	str := func(key string) string {
+		switch v := record[key].(type) {
+		case string:
+			return v
+		case json.Number:
+			return v.String()
+		}
+		return ""
+	}
+	num := func(key string) float64 {
+		n, _ := record[key].(json.Number)
+		f, _ := n.Float64()
+		return f
+	}
+
+	start, startOK := cloudflareTime(record["EdgeStartTimestamp"])
+	timestamp := start
+	if !startOK {
+		timestamp = time.Now().UTC()
+	}
+
+	// Prefer the origin's response time; cached responses have none, so fall
+	// back to the edge's own start-to-end time
+	durationMs := 0.0
+	if v := num("OriginResponseDurationMs"); v > 0 {
+		durationMs = v
+	} else if v := num("OriginResponseTime"); v > 0 {
+		durationMs = v / 1e6 // Nanoseconds
+	} else if end, ok := cloudflareTime(record["EdgeEndTimestamp"]); ok && startOK && end.After(start) {
+		durationMs = float64(end.Sub(start)) / float64(time.Millisecond)
+	}
+
+	method := str("ClientRequestMethod")
+	path := str("ClientRequestPath")
+	if path == "" {
+		path = requestPath(str("ClientRequestURI"))
+	}
+	status, _ := strconv.Atoi(str("EdgeResponseStatus"))
+	size, _ := strconv.Atoi(str("EdgeResponseBytes"))
+
+	tags := map[string]string{
+		"method": method,
+		"path":   path,
+	}
+	for key, tag := range map[string]string{
+		"ClientIP":               "client_ip",
+		"ClientRequestHost":      "http.host",
+		"ClientRequestUserAgent": "user_agent",
+		"ClientRequestReferer":   "referer",
+		"ClientCountry":          "cf.country",
+		"RayID":                  "cf.ray_id",
+		"CacheCacheStatus":       "cf.cache_status",
+		"OriginResponseStatus":   "cf.origin_status",
+		"EdgeColoCode":           "cf.colo",
+		"EdgeResponseBytes":      "content_size",
+	} {
+		if v := str(key); v != "" {
+			tags[tag] = v
+		}
+	}
+
+	event := &buffer.Event{
+		"organization_id": organizationID,
+		"service_name":    serviceName,
+		"event_id":        uuid.New().String(),
+		"timestamp":       timestamp.Format(time.RFC3339Nano),
+		"event_type":      "span",
+		"environment":     environment,
+		"trace_id":        uuid.New().String(),
+		"span_id":         uuid.New().String(),
+		"parent_span_id":  "",
+		"operation":       method + " " + path,
+		"duration_ms":     durationMs,
+		"status_code":     status,
+		"tags":            tags,
+		"metric_value":    float64(size),
+	}
+	if raw := str("EdgeStartTimestamp"); !startOK && raw != "" {
+		preserveOriginalTimestamp(event, raw)
+	}
+	return event
+}
+
+// cloudflareTime reads a Logpush timestamp in any of its output formats:
+// RFC 3339, Unix seconds or Unix nanoseconds.
+func cloudflareTime(v interface{}) (time.Time, bool) {
+	switch ts := v.(type) {
+	case string:
+		return parseTimestamp(ts, time.UTC)
+	case json.Number:
+		if nanos, err := ts.Int64(); err == nil && nanos > 1e15 {
+			return time.Unix(0, nanos).UTC(), true
+		}
+		if seconds, err := ts.Float64(); err == nil && seconds > 0 {
+			return epochToTime(seconds), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// defaultW3CFields are the fields IIS logs by default, used until a #Fields
+// directive has been read.
+var defaultW3CFields = []string{
+	"date", "time", "s-ip", "cs-method", "cs-uri-stem", "cs-uri-query", "s-port", "cs-username",
+	"c-ip", "cs(User-Agent)", "cs(Referer)", "sc-status", "sc-substatus", "sc-win32-status", "time-taken",
+}
+
+// w3cTags maps W3C fields onto the tags the other access log parsers use.
+var w3cTags = map[string]string{
+	"c-ip":            "client_ip",
+	"cs(User-Agent)":  "user_agent",
+	"cs(Referer)":     "referer",
+	"cs-host":         "http.host",
+	"sc-bytes":        "content_size",
+	"cs-username":     "user",
+	"s-sitename":      "iis.site",
+	"s-computername":  "iis.server",
+	"s-ip":            "server_ip",
+	"s-port":          "server_port",
+	"sc-substatus":    "iis.substatus",
+	"sc-win32-status": "iis.win32_status",
+}
+
+// parseW3CFieldsDirective returns the field names of a "#Fields:" directive.
+func parseW3CFieldsDirective(line string) ([]string, bool) {
+	rest, ok := strings.CutPrefix(line, "#Fields:")
+	if !ok {
+		return nil, false
+	}
+	fields := strings.Fields(rest)
+	return fields, len(fields) > 0
+}
+
+// w3cHeaderLines bounds how far into a file readW3CHeader looks for directives.
+const w3cHeaderLines = 20
+
+// readW3CHeader returns the fields of the last #Fields directive in the
+// header of the file at path.
+func readW3CHeader(path string) ([]string, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+
+	var fields []string
+	scanner := bufio.NewScanner(f)
+	for i := 0; i < w3cHeaderLines && scanner.Scan(); i++ {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "#") {
+			break
+		}
+		if parsed, ok := parseW3CFieldsDirective(line); ok {
+			fields = parsed
+		}
+	}
+	return fields, fields != nil
+}
+
+// ParseW3CLog parses a W3C Extended Log Format line with the IIS default fields
+func ParseW3CLog(line, organizationID, serviceName, environment string) *buffer.Event {
+	return parseW3CLog(line, defaultW3CFields, organizationID, serviceName, environment, time.UTC)
+}
+
+// parseW3CLog parses a W3C Extended Log Format line whose columns are named by
+// fields, from the file's #Fields directive. Directive lines yield nil.
+func parseW3CLog(line string, fields []string, organizationID, serviceName, environment string, loc *time.Location) *buffer.Event {
+	if strings.HasPrefix(line, "#") {
+		return nil
+	}
+	values := strings.Fields(line)
+	if len(values) != len(fields) {
+		return nil
+	}
+	record := make(map[string]string, len(fields))
+	for i, name := range fields {
+		if values[i] != "-" {
+			record[name] = values[i]
+		}
+	}
+
+	// W3C logs are written in UTC
+	raw := strings.TrimSpace(record["date"] + " " + record["time"])
+	timestamp, parsed := parseTimestamp(raw, time.UTC)
+	if !parsed {
+		timestamp = time.Now().UTC()
+	}
+
+	method := record["cs-method"]
+	path := record["cs-uri-stem"]
+	if path == "" {
+		path = requestPath(record["cs-uri"])
+	}
+	status, _ := strconv.Atoi(record["sc-status"])
+	size, _ := strconv.Atoi(record["sc-bytes"])
+	// IIS writes time-taken in milliseconds
+	durationMs, _ := strconv.ParseFloat(record["time-taken"], 64)
+
+	tags := map[string]string{
+		"method": method,
+		"path":   path,
+	}
+	for field, tag := range w3cTags {
+		if v, ok := record[field]; ok {
+			// IIS encodes spaces in header values as '+'
+			if strings.HasPrefix(field, "cs(") {
+				v = strings.ReplaceAll(v, "+", " ")
+			}
+			tags[tag] = v
+		}
+	}
+
+	event := &buffer.Event{
+		"organization_id": organizationID,
+		"service_name":    serviceName,
+		"event_id":        uuid.New().String(),
+		"timestamp":       timestamp.Format(time.RFC3339Nano),
+		"event_type":      "span",
+		"environment":     environment,
+		"trace_id":        uuid.New().String(),
+		"span_id":         uuid.New().String(),
+		"parent_span_id":  "",
+		"operation":       method + " " + path,
+		"duration_ms":     durationMs,
+		"status_code":     status,
+		"tags":            tags,
+		"metric_value":    float64(size),
+	}
+	if !parsed && raw != "" {
+		preserveOriginalTimestamp(event, raw)
+	}
+	return event
+}
+
+// splitQuotedFields splits line on spaces, keeping double-quoted fields
+// (which may contain spaces) together without their quotes.
+func splitQuotedFields(line string) []string {
+	var fields []string
+	var current strings.Builder
+	inQuotes, started := false, false
+	for _, r := range line {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			started = true
+		case r == ' ' && !inQuotes:
+			if started {
+				fields = append(fields, current.String())
+				current.Reset()
+				started = false
+			}
+		default:
+			current.WriteRune(r)
+			started = true
+		}
+	}
+	if started {
+		fields = append(fields, current.String())
+	}
+	return fields
+}
+
+// requestPath returns the path of an absolute or origin-form request target.
+func requestPath(target string) string {
+	if target == "" {
+		return ""
+	}
+	if u, err := url.Parse(target); err == nil && u.Path != "" {
+		return u.Path
+	}
+	path, _, _ := strings.Cut(target, "?")
+	return path
+}
+
+// hostOnly strips the port from an address such as 192.168.1.10:2817.
+func hostOnly(addr string) string {
+	if i := strings.LastIndex(addr, ":"); i > 0 && !strings.Contains(addr[i:], "]") {
+		return strings.Trim(addr[:i], "[]")
+	}
+	return addr
+}
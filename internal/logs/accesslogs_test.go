@@ -0,0 +1,169 @@
+package logs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+)
+
+func readFixtureLines(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+	return strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+}
+
+func TestParseALBFixture(t *testing.T) {
+	lines := readFixtureLines(t, "testdata/alb.log")
+
+	event := ParseLog(lines[0], "alb", "org", "svc", "prod")
+	if event == nil {
+		t.Fatal("Expected ALB line to parse")
+	}
+	e := *event
+	if e["event_type"] != "span" || e["operation"] != "GET /api/orders" {
+		t.Errorf("Expected span GET /api/orders, got %v %v", e["event_type"], e["operation"])
+	}
+	if e["duration_ms"] != 251.0 {
+		t.Errorf("Expected request + target processing time 251ms, got %v", e["duration_ms"])
+	}
+	if e["status_code"] != 200 || e["metric_value"] != 366.0 {
+		t.Errorf("Expected status 200 and 366 bytes, got %v and %v", e["status_code"], e["metric_value"])
+	}
+	if e["timestamp"] != "2024-10-26T10:30:15.123456Z" {
+		t.Errorf("Expected log timestamp, got %v", e["timestamp"])
+	}
+	tags := e["tags"].(map[string]string)
+	if tags["client_ip"] != "192.168.131.39" || tags["user_agent"] != "curl/7.46.0" || tags["http.host"] != "www.example.com" {
+		t.Errorf("Unexpected tags: %v", tags)
+	}
+	if tags["aws.trace_id"] != "Root=1-58337262-36d228ad5d99923122bbe354" || tags["lb.target"] != "10.0.0.1:80" {
+		t.Errorf("Expected trace and target tags, got %v", tags)
+	}
+
+	// No target: processing times are -1 and the LB answers itself
+	event = ParseLog(lines[1], "alb", "org", "svc", "prod")
+	if event == nil {
+		t.Fatal("Expected ALB line without a target to parse")
+	}
+	e = *event
+	if e["duration_ms"] != 0.0 || e["status_code"] != 503 || e["operation"] != "POST /checkout" {
+		t.Errorf("Expected POST /checkout 503 with no duration, got %v %v %v", e["operation"], e["status_code"], e["duration_ms"])
+	}
+	tags = e["tags"].(map[string]string)
+	if _, ok := tags["lb.target"]; ok {
+		t.Errorf("Expected no target tag, got %v", tags)
+	}
+	if tags["lb.error_reason"] != "TargetNotFound" || tags["user_agent"] != "Mozilla/5.0 (X11; Linux x86_64)" {
+		t.Errorf("Unexpected tags: %v", tags)
+	}
+
+	if ParseLog("not an alb line", "alb", "org", "svc", "prod") != nil {
+		t.Error("Expected nil for a non-ALB line")
+	}
+}
+
+func TestParseCloudflareFixture(t *testing.T) {
+	lines := readFixtureLines(t, "testdata/cloudflare.json")
+
+	e := *ParseLog(lines[0], "cloudflare", "org", "svc", "prod")
+	if e["event_type"] != "span" || e["operation"] != "GET /products/42" {
+		t.Errorf("Expected span GET /products/42, got %v %v", e["event_type"], e["operation"])
+	}
+	if e["duration_ms"] != 150.0 {
+		t.Errorf("Expected origin response time 150ms, got %v", e["duration_ms"])
+	}
+	if e["timestamp"] != "2024-10-26T10:30:15.123Z" {
+		t.Errorf("Expected EdgeStartTimestamp from unix nanos, got %v", e["timestamp"])
+	}
+	if e["status_code"] != 200 || e["metric_value"] != 5120.0 {
+		t.Errorf("Expected status 200 and 5120 bytes, got %v and %v", e["status_code"], e["metric_value"])
+	}
+	tags := e["tags"].(map[string]string)
+	if tags["client_ip"] != "203.0.113.7" || tags["cf.ray_id"] != "8d7f2a1b3c4d5e6f" || tags["cf.cache_status"] != "miss" {
+		t.Errorf("Unexpected tags: %v", tags)
+	}
+	if _, ok := tags["EdgeStartTimestamp"]; ok {
+		t.Errorf("Expected vendor keys to be mapped rather than copied, got %v", tags)
+	}
+
+	// A cache hit has no origin time; the edge duration is used
+	e = *ParseLog(lines[1], "cloudflare", "org", "svc", "prod")
+	if e["duration_ms"] != 4.0 || e["status_code"] != 304 {
+		t.Errorf("Expected 304 with 4ms edge duration, got %v %v", e["status_code"], e["duration_ms"])
+	}
+	if e["timestamp"] != "2024-10-26T10:30:16Z" {
+		t.Errorf("Expected RFC 3339 EdgeStartTimestamp, got %v", e["timestamp"])
+	}
+}
+
+func TestParseW3CFixtureThroughTailer(t *testing.T) {
+	buf := buffer.New(100)
+	tailer := New("testdata/iis.log", "w3c", "org", "svc", "prod", nil, buf)
+	for _, line := range readFixtureLines(t, "testdata/iis.log") {
+		tailer.handleLine(line)
+	}
+
+	events := buf.Flush()
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 events, got %d", len(events))
+	}
+
+	first := events[0]
+	if first["operation"] != "GET /api/orders" || first["duration_ms"] != 87.0 || first["status_code"] != 200 {
+		t.Errorf("Unexpected first event: %v %v %v", first["operation"], first["duration_ms"], first["status_code"])
+	}
+	if first["timestamp"] != "2024-10-26T10:30:15Z" {
+		t.Errorf("Expected UTC timestamp from date and time, got %v", first["timestamp"])
+	}
+	tags := first["tags"].(map[string]string)
+	if tags["user_agent"] != "Mozilla/5.0 (Windows NT 10.0)" || tags["client_ip"] != "198.51.100.4" || tags["iis.site"] != "W3SVC1" {
+		t.Errorf("Unexpected tags: %v", tags)
+	}
+	if _, ok := tags["user"]; ok {
+		t.Errorf("Expected '-' values to be omitted, got %v", tags)
+	}
+
+	if second := events[1]; second["status_code"] != 500 || second["tags"].(map[string]string)["user"] != "alice" {
+		t.Errorf("Unexpected second event: %v", second)
+	}
+
+	// A later #Fields directive replaces the columns
+	if third := events[2]; third["operation"] != "GET /health" || third["duration_ms"] != 3.0 {
+		t.Errorf("Expected columns from the second #Fields directive, got %v", third)
+	}
+}
+
+func TestReadW3CHeader(t *testing.T) {
+	fields, ok := readW3CHeader("testdata/iis.log")
+	if !ok || len(fields) != 17 || fields[0] != "date" || fields[16] != "time-taken" {
+		t.Errorf("Expected the fields from the file header, got %v", fields)
+	}
+
+	path := filepath.Join(t.TempDir(), "u_ex241026.log")
+	if err := os.WriteFile(path, []byte("2024-10-26 10:30:15 GET / 200 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := readW3CHeader(path); ok {
+		t.Error("Expected no fields without a header")
+	}
+}
+
+func TestParseW3CDefaultFields(t *testing.T) {
+	line := "2024-10-26 10:30:15 10.0.0.5 GET /index.html - 80 - 198.51.100.4 curl/8.0 - 404 0 2 15"
+	event := ParseLog(line, "w3c", "org", "svc", "prod")
+	if event == nil {
+		t.Fatal("Expected a line in the IIS default fields to parse")
+	}
+	if (*event)["status_code"] != 404 || (*event)["operation"] != "GET /index.html" {
+		t.Errorf("Unexpected event: %v", *event)
+	}
+	if ParseLog("2024-10-26 10:30:15 GET", "w3c", "org", "svc", "prod") != nil {
+		t.Error("Expected nil for a line that does not match the fields")
+	}
+}
@@ -0,0 +1,71 @@
+package logs
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// autoCacheStreak is how many consecutive lines of one format it takes before
+// that format is tried first.
+const autoCacheStreak = 20
+
+// autoFormats are tried in order for each line with format "auto"; lines
+// matching none are parsed as generic logs.
+var autoFormats = []struct {
+	name  string
+	match func(line string) bool
+}{
+	{"json", looksLikeJSON},
+	{"nginx", nginxLogRegex.MatchString},
+	{"django", djangoLogRegex.MatchString},
+}
+
+func looksLikeJSON(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return strings.HasPrefix(trimmed, "{") && json.Valid([]byte(trimmed))
+}
+
+// formatSniffer picks a parser per line for format "auto". Once a file has
+// been consistently one format, that format's check runs first.
+type formatSniffer struct {
+	cached int // Index into autoFormats, or -1
+	last   int
+	streak int
+}
+
+func newFormatSniffer() *formatSniffer {
+	return &formatSniffer{cached: -1, last: -1}
+}
+
+// detect returns the format to parse line with.
+func (s *formatSniffer) detect(line string) string {
+	if s.cached >= 0 && autoFormats[s.cached].match(line) {
+		return autoFormats[s.cached].name
+	}
+
+	for i, f := range autoFormats {
+		if i == s.cached || !f.match(line) {
+			continue
+		}
+		s.observe(i)
+		return f.name
+	}
+	s.observe(-1)
+	return "generic"
+}
+
+func (s *formatSniffer) observe(index int) {
+	if index == s.last {
+		s.streak++
+	} else {
+		s.last = index
+		s.streak = 1
+	}
+	switch {
+	case index >= 0 && s.streak >= autoCacheStreak:
+		s.cached = index
+	case index != s.cached:
+		// A line the cached format rejected: check every format again
+		s.cached = -1
+	}
+}
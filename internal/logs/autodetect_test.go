@@ -0,0 +1,67 @@
+package logs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+)
+
+func TestAutoFormatRoutesMixedLines(t *testing.T) {
+	buf := buffer.New(100)
+	tailer := New("/var/log/app.log", "auto", "org_123", "svc", "prod", nil, buf)
+
+	lines := []string{
+		`{"level": "warning", "message": "cache miss", "user": "42"}`,
+		`127.0.0.1 - - [26/Oct/2024:10:30:15 +0000] "GET /api/users HTTP/1.1" 200 512 "-" "curl/8.0"`,
+		`[2024-10-26 10:30:15,123] ERROR [django.request] Internal Server Error: /checkout`,
+		"Traceback (most recent call last):",
+		`  File "/app/views.py", line 10, in checkout`,
+		"ValueError: bad cart",
+		"plain text line without structure",
+	}
+	for _, line := range lines {
+		tailer.handleLine(line)
+	}
+
+	events := buf.Flush()
+	if len(events) != 4 {
+		t.Fatalf("Expected 4 events, got %d: %v", len(events), events)
+	}
+	if events[0]["level"] != "warning" || events[0]["message"] != "cache miss" {
+		t.Errorf("Expected JSON line parsed as JSON, got %v", events[0])
+	}
+	if events[1]["event_type"] != "span" || events[1]["status_code"] != 200 {
+		t.Errorf("Expected access log line parsed as a span, got %v", events[1])
+	}
+	if events[2]["level"] != "error" || !strings.Contains(events[2]["stacktrace"].(string), "ValueError: bad cart") {
+		t.Errorf("Expected Django error with its traceback, got %v", events[2])
+	}
+	if events[3]["message"] != "plain text line without structure" || events[3]["level"] != "info" {
+		t.Errorf("Expected generic fallback, got %v", events[3])
+	}
+}
+
+func TestFormatSnifferCachesConsistentFormat(t *testing.T) {
+	s := newFormatSniffer()
+	json := `{"message": "ok"}`
+	for i := 0; i < autoCacheStreak; i++ {
+		if got := s.detect(json); got != "json" {
+			t.Fatalf("Expected json, got %s", got)
+		}
+	}
+	if s.cached < 0 || autoFormats[s.cached].name != "json" {
+		t.Fatalf("Expected json to be cached after %d lines", autoCacheStreak)
+	}
+
+	// A different line still routes correctly and drops the cache
+	if got := s.detect(`[2024-10-26 10:30:15,123] INFO [app] started`); got != "django" {
+		t.Errorf("Expected django, got %s", got)
+	}
+	if s.cached != -1 {
+		t.Errorf("Expected the cache to be cleared, got %d", s.cached)
+	}
+	if got := s.detect("{not json"); got != "generic" {
+		t.Errorf("Expected invalid JSON to fall back to generic, got %s", got)
+	}
+}
@@ -0,0 +1,234 @@
+package logs
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/logging"
+	"github.com/yaat-app/sidecar/internal/state"
+)
+
+var backfillLogger = logging.New("Backfill")
+
+const (
+	defaultBackfillMaxFiles       = 3
+	defaultBackfillLinesPerSecond = 1000
+
+	// fingerprintBytes of decompressed content identify a rotated file even after
+	// logrotate renames or compresses it.
+	fingerprintBytes = 4096
+)
+
+// BackfillOptions controls ingestion of rotated siblings of a tailed file at startup.
+type BackfillOptions struct {
+	MaxFiles       int           // Newest rotated files to consider
+	MaxAge         time.Duration // Skip files last modified before this window (0 = no limit)
+	LinesPerSecond int           // Upper bound on ingest rate so live tailing is not starved
+}
+
+// SetBackfill enables backfilling rotated files when the tailer starts.
+func (t *Tailer) SetBackfill(opts BackfillOptions) {
+	if opts.MaxFiles <= 0 {
+		opts.MaxFiles = defaultBackfillMaxFiles
+	}
+	if opts.LinesPerSecond <= 0 {
+		opts.LinesPerSecond = defaultBackfillLinesPerSecond
+	}
+	t.backfill = &opts
+}
+
+// runBackfill ingests rotated files oldest-first through a separate parsing
+// state so multi-line handling does not interleave with the live tail.
+func (t *Tailer) runBackfill() {
+	defer func() {
+		if r := recover(); r != nil {
+			backfillLogger.Errorf("Panic recovered in %s: %v", t.path, r)
+		}
+	}()
+
+	files := rotatedSiblings(t.path, *t.backfill, time.Now())
+	if len(files) == 0 {
+		return
+	}
+	backfillLogger.Infof("Found %d rotated file(s) for %s", len(files), t.path)
+
+	worker := New(t.path, t.format, t.organizationID, t.serviceName, t.environment, t.globalTags, t.buffer)
+	worker.SetLocation(t.location)
+	worker.SetExtractKV(t.extractKV)
+	if t.dedupe != nil {
+		worker.SetDedupeWindow(t.dedupe.window)
+	}
+	worker.sampler = t.sampler
+	worker.backpressure = t.backpressure
+
+	for _, file := range files {
+		fingerprint, err := fileFingerprint(t.path, file)
+		if err != nil {
+			backfillLogger.Warnf("Skipping %s: %v", file, err)
+			continue
+		}
+		if fingerprint == "" || state.BackfillCompleted(fingerprint) {
+			continue
+		}
+
+		lines, err := worker.ingestFile(file, t.backfill.LinesPerSecond)
+		if err != nil {
+			backfillLogger.Errorf("Failed to read %s after %d lines: %v", file, lines, err)
+			continue
+		}
+		backfillLogger.Infof("Ingested %d lines from %s", lines, file)
+
+		if err := state.RecordBackfill(fingerprint, state.BackfillRecord{Source: t.path, File: file, Lines: lines}); err != nil {
+			backfillLogger.Warnf("Could not record progress for %s: %v", file, err)
+		}
+	}
+}
+
+// ingestFile reads a plain or gzip-compressed file line by line at a bounded rate.
+func (t *Tailer) ingestFile(path string, linesPerSecond int) (int, error) {
+	// Sleep between small batches rather than per line to keep overhead low
+	batch := linesPerSecond / 10
+	if batch < 1 {
+		batch = 1
+	}
+	pause := time.Second * time.Duration(batch) / time.Duration(linesPerSecond)
+
+	return t.ReadFile(path, func(lines int) {
+		if lines%batch == 0 {
+			time.Sleep(pause)
+		}
+	})
+}
+
+// ReadFile parses every line of the plain or gzip-compressed file at path into
+// the buffer the way tailing would, multi-line entries included, and returns
+// the number of lines read. afterLine, when set, runs after each line with
+// the count so far, e.g. to pace reading or drain the buffer.
+func (t *Tailer) ReadFile(path string, afterLine func(lines int)) (int, error) {
+	reader, closeFn, err := openLogFile(path)
+	if err != nil {
+		return 0, err
+	}
+	defer closeFn()
+	return t.ReadLines(reader, afterLine)
+}
+
+// ReadLines is ReadFile for an already open reader such as stdin; it reads r
+// to EOF.
+func (t *Tailer) ReadLines(r io.Reader, afterLine func(lines int)) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	lines := 0
+	for scanner.Scan() {
+		t.handleLine(scanner.Text())
+		lines++
+		if afterLine != nil {
+			afterLine(lines)
+		}
+	}
+	t.flushPending()
+	t.flushDedupe()
+
+	return lines, scanner.Err()
+}
+
+// rotatedSiblings lists rotated variants of path (app.log.1, app.log.2.gz,
+// app.log-20241026.gz, ...) ordered oldest-first.
+func rotatedSiblings(path string, opts BackfillOptions, now time.Time) []string {
+	matches, err := filepath.Glob(path + "*")
+	if err != nil {
+		return nil
+	}
+
+	type candidate struct {
+		path    string
+		modTime time.Time
+	}
+	var candidates []candidate
+	for _, match := range matches {
+		suffix := strings.TrimPrefix(match, path)
+		if suffix == "" || (suffix[0] != '.' && suffix[0] != '-') {
+			continue
+		}
+		info, err := os.Stat(match)
+		if err != nil || !info.Mode().IsRegular() || info.Size() == 0 {
+			continue
+		}
+		if opts.MaxAge > 0 && now.Sub(info.ModTime()) > opts.MaxAge {
+			continue
+		}
+		candidates = append(candidates, candidate{path: match, modTime: info.ModTime()})
+	}
+
+	// Keep the newest MaxFiles, then return them oldest-first
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].modTime.Equal(candidates[j].modTime) {
+			return candidates[i].path > candidates[j].path
+		}
+		return candidates[i].modTime.After(candidates[j].modTime)
+	})
+	if opts.MaxFiles > 0 && len(candidates) > opts.MaxFiles {
+		candidates = candidates[:opts.MaxFiles]
+	}
+
+	files := make([]string, 0, len(candidates))
+	for i := len(candidates) - 1; i >= 0; i-- {
+		files = append(files, candidates[i].path)
+	}
+	return files
+}
+
+// fileFingerprint hashes the source path and the start of the decompressed
+// content, so a file is recognised after being renamed or gzipped.
+func fileFingerprint(source, path string) (string, error) {
+	reader, closeFn, err := openLogFile(path)
+	if err != nil {
+		return "", err
+	}
+	defer closeFn()
+
+	head := make([]byte, fingerprintBytes)
+	n, err := io.ReadFull(reader, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	if n == 0 {
+		return "", nil
+	}
+
+	sum := sha256.New()
+	sum.Write([]byte(source))
+	sum.Write([]byte{0})
+	sum.Write(head[:n])
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+func openLogFile(path string) (io.Reader, func(), error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		return file, func() { file.Close() }, nil
+	}
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("open gzip: %w", err)
+	}
+	return gz, func() {
+		gz.Close()
+		file.Close()
+	}, nil
+}
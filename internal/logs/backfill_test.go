@@ -0,0 +1,138 @@
+package logs
+
+import (
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+)
+
+func writeGzip(t *testing.T, path, content string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create %s: %v", path, err)
+	}
+	gz := gzip.NewWriter(f)
+	if _, err := gz.Write([]byte(content)); err != nil {
+		t.Fatalf("Failed to write gzip: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("Failed to close gzip: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Failed to close file: %v", err)
+	}
+}
+
+func setupRotatedLogs(t *testing.T) string {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	now := time.Now()
+
+	if err := os.WriteFile(path, []byte(`{"msg":"live"}`+"\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write live log: %v", err)
+	}
+	plain := path + ".1"
+	if err := os.WriteFile(plain, []byte(`{"msg":"plain-1"}`+"\n"+`{"msg":"plain-2"}`+"\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write rotated log: %v", err)
+	}
+	compressed := path + ".2.gz"
+	writeGzip(t, compressed, `{"msg":"gz-1"}`+"\n"+`{"msg":"gz-2"}`+"\n")
+	stale := path + ".3.gz"
+	writeGzip(t, stale, `{"msg":"stale"}`+"\n")
+
+	touch := func(p string, age time.Duration) {
+		if err := os.Chtimes(p, now.Add(-age), now.Add(-age)); err != nil {
+			t.Fatalf("Failed to set mtime: %v", err)
+		}
+	}
+	touch(plain, time.Hour)
+	touch(compressed, 25*time.Hour)
+	touch(stale, 200*time.Hour)
+
+	return path
+}
+
+func messages(events []buffer.Event) []string {
+	var out []string
+	for _, evt := range events {
+		out = append(out, evt["message"].(string))
+	}
+	return out
+}
+
+func TestRotatedSiblingsOrderAndLimits(t *testing.T) {
+	path := setupRotatedLogs(t)
+
+	files := rotatedSiblings(path, BackfillOptions{MaxFiles: 3, MaxAge: 72 * time.Hour}, time.Now())
+	if len(files) != 2 {
+		t.Fatalf("Expected 2 files within max_age, got %v", files)
+	}
+	if filepath.Base(files[0]) != "app.log.2.gz" || filepath.Base(files[1]) != "app.log.1" {
+		t.Errorf("Expected oldest-first order, got %v", files)
+	}
+
+	files = rotatedSiblings(path, BackfillOptions{MaxFiles: 1}, time.Now())
+	if len(files) != 1 || filepath.Base(files[0]) != "app.log.1" {
+		t.Errorf("Expected only the newest rotated file, got %v", files)
+	}
+}
+
+func TestBackfillPlainAndGzip(t *testing.T) {
+	path := setupRotatedLogs(t)
+	buf := buffer.New(100)
+
+	tailer := New(path, "json", "org", "svc", "prod", nil, buf)
+	tailer.SetBackfill(BackfillOptions{MaxFiles: 3, MaxAge: 72 * time.Hour, LinesPerSecond: 100000})
+	tailer.runBackfill()
+
+	got := messages(buf.Flush())
+	expected := []string{"gz-1", "gz-2", "plain-1", "plain-2"}
+	if len(got) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected, got)
+			break
+		}
+	}
+
+	// A second run must not ingest the same files again
+	tailer.runBackfill()
+	if buf.Len() != 0 {
+		t.Errorf("Expected no duplicate ingestion, got %d events", buf.Len())
+	}
+}
+
+func TestBackfillRecognisesCompressedRotation(t *testing.T) {
+	path := setupRotatedLogs(t)
+	buf := buffer.New(100)
+
+	tailer := New(path, "json", "org", "svc", "prod", nil, buf)
+	tailer.SetBackfill(BackfillOptions{MaxFiles: 3, MaxAge: 72 * time.Hour, LinesPerSecond: 100000})
+	tailer.runBackfill()
+	buf.Flush()
+
+	// logrotate later compresses app.log.1 into app.log.2.gz
+	data, err := os.ReadFile(path + ".1")
+	if err != nil {
+		t.Fatalf("Failed to read rotated log: %v", err)
+	}
+	if err := os.Remove(path + ".1"); err != nil {
+		t.Fatalf("Failed to remove rotated log: %v", err)
+	}
+	writeGzip(t, path+".2.gz", string(data))
+
+	tailer.runBackfill()
+	if buf.Len() != 0 {
+		t.Errorf("Expected recompressed file to be recognised, got %v", messages(buf.Flush()))
+	}
+}
@@ -0,0 +1,231 @@
+package logs
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/yaat-app/sidecar/internal/buffer"
+)
+
+// criLine is a single line in the CRI (CRI-O / containerd) log format:
+// 2024-10-26T10:30:15.123456789Z stdout F message
+type criLine struct {
+	timestamp string
+	stream    string
+	tag       string
+	message   string
+}
+
+func splitCRILine(line string) (criLine, bool) {
+	parts := strings.SplitN(line, " ", 4)
+	if len(parts) < 3 {
+		return criLine{}, false
+	}
+	stream := parts[1]
+	if stream != "stdout" && stream != "stderr" {
+		return criLine{}, false
+	}
+	// The tag is "P" (partial) or "F" (full), optionally followed by ":"-separated extras
+	tag := strings.SplitN(parts[2], ":", 2)[0]
+	if tag != "P" && tag != "F" {
+		return criLine{}, false
+	}
+	message := ""
+	if len(parts) == 4 {
+		message = parts[3]
+	}
+	return criLine{timestamp: parts[0], stream: stream, tag: tag, message: message}, true
+}
+
+// ParseCRILog parses a CRI container runtime log line
+func ParseCRILog(line, organizationID, serviceName, environment string) *buffer.Event {
+	return parseCRILog(line, organizationID, serviceName, environment, time.UTC)
+}
+
+func parseCRILog(line, organizationID, serviceName, environment string, loc *time.Location) *buffer.Event {
+	entry, ok := splitCRILine(strings.TrimRight(line, "\r\n"))
+	if !ok {
+		return nil
+	}
+
+	timestamp, parsed := parseTimestamp(entry.timestamp, time.UTC)
+	if !parsed {
+		timestamp = time.Now().UTC()
+	}
+
+	level := "info"
+	if entry.stream == "stderr" {
+		level = "error"
+	}
+
+	tags := map[string]string{
+		"container.stream":  entry.stream,
+		"container.runtime": "cri",
+	}
+
+	// Applications writing JSON to stdout keep their structured fields
+	trimmed := strings.TrimSpace(entry.message)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		if inner := parseJSONLog(trimmed, organizationID, serviceName, environment, loc); inner != nil {
+			(*inner)["timestamp"] = timestamp.Format(time.RFC3339Nano)
+			if existing, ok := (*inner)["tags"].(map[string]string); ok {
+				for k, v := range tags {
+					existing[k] = v
+				}
+			} else {
+				(*inner)["tags"] = tags
+			}
+			if entry.stream == "stderr" {
+				if current, ok := (*inner)["level"].(string); !ok || current == "" || current == "info" {
+					(*inner)["level"] = "error"
+				}
+			}
+			if !parsed {
+				preserveOriginalTimestamp(inner, entry.timestamp)
+			}
+			return inner
+		}
+	}
+
+	event := &buffer.Event{
+		"organization_id": organizationID,
+		"service_name":    serviceName,
+		"event_id":        uuid.New().String(),
+		"timestamp":       timestamp.Format(time.RFC3339Nano),
+		"event_type":      "log",
+		"environment":     environment,
+		"level":           level,
+		"message":         entry.message,
+		"tags":            tags,
+	}
+	if !parsed {
+		preserveOriginalTimestamp(event, entry.timestamp)
+	}
+
+	return event
+}
+
+// criPartialIdle is how long a partial entry waits for its next fragment
+// before it is emitted as is, e.g. when the container died mid-line.
+const criPartialIdle = 5 * time.Second
+
+// defaultCRIMaxBytes bounds a reassembled entry when no limit is set.
+const defaultCRIMaxBytes = 1 << 20
+
+// criTruncated marks a reassembled entry cut at the limit.
+const criTruncated = "...[TRUNCATED]"
+
+// criReassembler joins CRI partial ("P") lines with the full ("F") line that
+// terminates them. Streams are tracked separately since they may interleave.
+// Each entry keeps at most maxBytes of message; later fragments are dropped
+// until the entry ends.
+type criReassembler struct {
+	maxBytes int
+	partials map[string]*criPartial
+}
+
+type criPartial struct {
+	timestamp string
+	fragments []string
+	size      int       // Bytes kept in fragments
+	truncated bool      // Fragments were dropped at maxBytes
+	updated   time.Time // When the last fragment arrived
+}
+
+func newCRIReassembler(maxBytes int) *criReassembler {
+	return &criReassembler{maxBytes: maxBytes}
+}
+
+// Add consumes a raw CRI line and returns a complete line once the entry is finished.
+// Lines that are not in CRI format are passed through unchanged.
+func (r *criReassembler) Add(line string) (string, bool) {
+	return r.add(line, time.Now())
+}
+
+func (r *criReassembler) add(line string, now time.Time) (string, bool) {
+	entry, ok := splitCRILine(line)
+	if !ok {
+		return line, true
+	}
+
+	if entry.tag == "P" {
+		if r.partials == nil {
+			r.partials = make(map[string]*criPartial)
+		}
+		partial, exists := r.partials[entry.stream]
+		if !exists {
+			// The reassembled entry keeps the timestamp of its first fragment
+			partial = &criPartial{timestamp: entry.timestamp}
+			r.partials[entry.stream] = partial
+		}
+		r.append(partial, entry.message)
+		partial.updated = now
+		return "", false
+	}
+
+	partial, exists := r.partials[entry.stream]
+	if !exists {
+		return line, true
+	}
+	delete(r.partials, entry.stream)
+
+	r.append(partial, entry.message)
+	return partial.line(entry.stream), true
+}
+
+// append keeps as much of fragment as fits under maxBytes.
+func (r *criReassembler) append(partial *criPartial, fragment string) {
+	if partial.truncated {
+		return
+	}
+	if r.maxBytes > 0 && partial.size+len(fragment) > r.maxBytes {
+		fragment = truncateUTF8(fragment, r.maxBytes-partial.size)
+		partial.truncated = true
+	}
+	partial.fragments = append(partial.fragments, fragment)
+	partial.size += len(fragment)
+}
+
+// Expire returns, as complete lines, the entries whose last fragment
+// arrived before cutoff, so a stream that never sends its "F" line does not
+// hold its fragments forever.
+func (r *criReassembler) Expire(cutoff time.Time) []string {
+	var lines []string
+	for stream, partial := range r.partials {
+		if partial.updated.Before(cutoff) {
+			delete(r.partials, stream)
+			lines = append(lines, partial.line(stream))
+		}
+	}
+	return lines
+}
+
+// Flush returns every pending entry as a complete line.
+func (r *criReassembler) Flush() []string {
+	return r.Expire(time.Now().Add(time.Hour))
+}
+
+// line renders the entry as a full CRI line.
+func (p *criPartial) line(stream string) string {
+	message := strings.Join(p.fragments, "")
+	if p.truncated {
+		message += criTruncated
+	}
+	return p.timestamp + " " + stream + " F " + message
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a rune.
+func truncateUTF8(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
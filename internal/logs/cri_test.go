@@ -0,0 +1,125 @@
+package logs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCRILog(t *testing.T) {
+	line := "2024-10-26T10:30:15.123456789Z stderr F connection refused"
+	event := ParseLog(line, "cri", "org", "svc", "prod")
+	if event == nil {
+		t.Fatal("Expected event, got nil")
+	}
+
+	if (*event)["message"] != "connection refused" {
+		t.Errorf("Expected message, got %v", (*event)["message"])
+	}
+	if (*event)["level"] != "error" {
+		t.Errorf("Expected level error for stderr, got %v", (*event)["level"])
+	}
+	if (*event)["timestamp"] != "2024-10-26T10:30:15.123456789Z" {
+		t.Errorf("Expected CRI timestamp, got %v", (*event)["timestamp"])
+	}
+	tags := (*event)["tags"].(map[string]string)
+	if tags["container.stream"] != "stderr" || tags["container.runtime"] != "cri" {
+		t.Errorf("Unexpected tags: %v", tags)
+	}
+
+	if ParseCRILog(`{"log":"docker envelope"}`, "org", "svc", "prod") != nil {
+		t.Error("Expected nil for non-CRI line")
+	}
+}
+
+func TestParseCRILogJSONPayload(t *testing.T) {
+	line := `2024-10-26T10:30:15Z stdout F {"level":"warn","msg":"slow","user":"42"}`
+	event := ParseCRILog(line, "org", "svc", "prod")
+	if event == nil {
+		t.Fatal("Expected event, got nil")
+	}
+	if (*event)["message"] != "slow" || (*event)["level"] != "warning" {
+		t.Errorf("Expected JSON payload to be parsed, got %v/%v", (*event)["message"], (*event)["level"])
+	}
+	tags := (*event)["tags"].(map[string]string)
+	if tags["user"] != "42" || tags["container.stream"] != "stdout" {
+		t.Errorf("Expected merged tags, got %v", tags)
+	}
+}
+
+func TestCRIReassemblerPartialLines(t *testing.T) {
+	r := &criReassembler{}
+	lines := []string{
+		"2024-10-26T10:30:15.000Z stdout P first ",
+		"2024-10-26T10:30:15.100Z stderr F unrelated error",
+		"2024-10-26T10:30:15.200Z stdout P second ",
+		"2024-10-26T10:30:15.300Z stdout F third",
+		"2024-10-26T10:30:16.000Z stdout F standalone",
+	}
+
+	var complete []string
+	for _, line := range lines {
+		if text, ok := r.Add(line); ok {
+			complete = append(complete, text)
+		}
+	}
+
+	if len(complete) != 3 {
+		t.Fatalf("Expected 3 complete lines, got %d: %v", len(complete), complete)
+	}
+	if complete[0] != "2024-10-26T10:30:15.100Z stderr F unrelated error" {
+		t.Errorf("Expected interleaved stderr line untouched, got %q", complete[0])
+	}
+
+	event := ParseCRILog(complete[1], "org", "svc", "prod")
+	if (*event)["message"] != "first second third" {
+		t.Errorf("Expected reassembled message, got %q", (*event)["message"])
+	}
+	if (*event)["timestamp"] != "2024-10-26T10:30:15Z" {
+		t.Errorf("Expected first fragment timestamp, got %v", (*event)["timestamp"])
+	}
+	if complete[2] != "2024-10-26T10:30:16.000Z stdout F standalone" {
+		t.Errorf("Expected standalone line untouched, got %q", complete[2])
+	}
+}
+
+func TestCRIReassemblerTruncatesAtLimit(t *testing.T) {
+	r := newCRIReassembler(10)
+	for _, line := range []string{
+		"2024-10-26T10:30:15.000Z stdout P 123456",
+		"2024-10-26T10:30:15.100Z stdout P 7890abcdef",
+		"2024-10-26T10:30:15.200Z stdout P more",
+	} {
+		if _, ok := r.Add(line); ok {
+			t.Fatalf("Expected partial line %q to be held", line)
+		}
+	}
+	if size := r.partials["stdout"].size; size != 10 {
+		t.Errorf("Expected 10 bytes held, got %d", size)
+	}
+
+	text, ok := r.Add("2024-10-26T10:30:15.300Z stdout F end")
+	if !ok {
+		t.Fatal("Expected the full line to complete the entry")
+	}
+	if want := "2024-10-26T10:30:15.000Z stdout F 1234567890" + criTruncated; text != want {
+		t.Errorf("Expected %q, got %q", want, text)
+	}
+}
+
+func TestCRIReassemblerExpiresStalePartials(t *testing.T) {
+	r := newCRIReassembler(defaultCRIMaxBytes)
+	start := time.Now()
+	r.add("2024-10-26T10:30:15.000Z stdout P stuck", start)
+	r.add("2024-10-26T10:30:20.000Z stderr P fresh", start.Add(criPartialIdle))
+
+	lines := r.Expire(start.Add(time.Second))
+	if len(lines) != 1 || lines[0] != "2024-10-26T10:30:15.000Z stdout F stuck" {
+		t.Errorf("Expected only the stale stdout entry, got %v", lines)
+	}
+	if _, held := r.partials["stderr"]; !held || len(r.partials) != 1 {
+		t.Errorf("Expected the recent stderr entry to stay pending, got %v", r.partials)
+	}
+	if lines := r.Flush(); len(lines) != 1 {
+		t.Errorf("Expected Flush to emit the remaining entry, got %v", lines)
+	}
+}
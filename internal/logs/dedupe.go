@@ -0,0 +1,85 @@
+package logs
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+)
+
+// deduper collapses runs of events with the same level and message into one
+// event. The first event of a run is held until a different event arrives or
+// the window since it was read elapses; if it repeated, it is emitted with
+// count, first_seen and last_seen tags.
+type deduper struct {
+	window time.Duration
+
+	held      buffer.Event
+	key       string
+	count     int
+	firstRead time.Time
+	lastSeen  interface{} // Timestamp of the latest repeat
+}
+
+func newDeduper(window time.Duration) *deduper {
+	return &deduper{window: window}
+}
+
+func dedupeKey(event buffer.Event) string {
+	level, _ := event["level"].(string)
+	message, _ := event["message"].(string)
+	return level + "\x00" + message
+}
+
+// add takes the next event and returns the event to emit, if any.
+func (d *deduper) add(event buffer.Event, now time.Time) buffer.Event {
+	key := dedupeKey(event)
+	if d.held != nil && key == d.key && now.Sub(d.firstRead) < d.window {
+		d.count++
+		d.lastSeen = event["timestamp"]
+		return nil
+	}
+
+	out := d.flush()
+	d.held = event
+	d.key = key
+	d.count = 1
+	d.firstRead = now
+	d.lastSeen = event["timestamp"]
+	return out
+}
+
+// expired reports whether the held event's window has elapsed.
+func (d *deduper) expired(now time.Time) bool {
+	return d.held != nil && now.Sub(d.firstRead) >= d.window
+}
+
+// flush returns the held event, annotated if it repeated, and clears it.
+func (d *deduper) flush() buffer.Event {
+	event := d.held
+	if event == nil {
+		return nil
+	}
+	if d.count > 1 {
+		// Copy: the tags map may be the tailer's shared global tags
+		existing, _ := event["tags"].(map[string]string)
+		tags := make(map[string]string, len(existing)+3)
+		for k, v := range existing {
+			tags[k] = v
+		}
+		event["tags"] = tags
+		tags["count"] = strconv.Itoa(d.count)
+		if first, ok := event["timestamp"].(string); ok {
+			tags["first_seen"] = first
+		}
+		if last, ok := d.lastSeen.(string); ok {
+			tags["last_seen"] = last
+		}
+	}
+
+	d.held = nil
+	d.key = ""
+	d.count = 0
+	d.lastSeen = nil
+	return event
+}
@@ -0,0 +1,84 @@
+package logs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+)
+
+func dedupeEvent(level, message, timestamp string, tags map[string]string) buffer.Event {
+	return buffer.Event{"level": level, "message": message, "timestamp": timestamp, "tags": tags}
+}
+
+func TestDedupeCollapsesRepeats(t *testing.T) {
+	shared := map[string]string{"team": "core"}
+	d := newDeduper(10 * time.Second)
+	start := time.Now()
+
+	for i, ts := range []string{"t1", "t2", "t3"} {
+		if out := d.add(dedupeEvent("error", "db down", ts, shared), start.Add(time.Duration(i)*time.Second)); out != nil {
+			t.Fatalf("Expected repeat %d to be held, got %v", i, out)
+		}
+	}
+
+	// A different message emits the collapsed event
+	out := d.add(dedupeEvent("error", "db up", "t4", shared), start.Add(3*time.Second))
+	if out == nil {
+		t.Fatal("Expected the held event when the message changes")
+	}
+	tags := out["tags"].(map[string]string)
+	if tags["count"] != "3" || tags["first_seen"] != "t1" || tags["last_seen"] != "t3" {
+		t.Errorf("Expected count=3 first_seen=t1 last_seen=t3, got %v", tags)
+	}
+	if tags["team"] != "core" {
+		t.Errorf("Expected existing tags to be kept, got %v", tags)
+	}
+	if _, ok := shared["count"]; ok {
+		t.Error("Expected the shared tags map to be left untouched")
+	}
+
+	// A single event is emitted without dedupe tags
+	out = d.flush()
+	if out == nil || out["message"] != "db up" {
+		t.Fatalf("Expected the held single event, got %v", out)
+	}
+	if _, ok := out["tags"].(map[string]string)["count"]; ok {
+		t.Errorf("Expected no count tag on a single event, got %v", out["tags"])
+	}
+	if d.flush() != nil {
+		t.Error("Expected nothing held after flush")
+	}
+}
+
+func TestDedupeLevelIsPartOfKey(t *testing.T) {
+	d := newDeduper(time.Minute)
+	now := time.Now()
+	d.add(dedupeEvent("info", "retrying", "t1", nil), now)
+	if out := d.add(dedupeEvent("error", "retrying", "t2", nil), now); out == nil {
+		t.Error("Expected a different level to flush the held event")
+	}
+}
+
+func TestDedupeWindowExpiry(t *testing.T) {
+	d := newDeduper(5 * time.Second)
+	start := time.Now()
+	d.add(dedupeEvent("info", "tick", "t1", nil), start)
+	d.add(dedupeEvent("info", "tick", "t2", nil), start.Add(time.Second))
+
+	if d.expired(start.Add(4 * time.Second)) {
+		t.Error("Expected the window to still be open")
+	}
+	if !d.expired(start.Add(5 * time.Second)) {
+		t.Error("Expected the window to have elapsed")
+	}
+
+	// A repeat after the window starts a new run
+	out := d.add(dedupeEvent("info", "tick", "t3", nil), start.Add(6*time.Second))
+	if out == nil || out["tags"].(map[string]string)["count"] != "2" {
+		t.Fatalf("Expected the first run with count=2, got %v", out)
+	}
+	if out = d.flush(); out == nil || out["timestamp"] != "t3" {
+		t.Errorf("Expected the new run to start at t3, got %v", out)
+	}
+}
@@ -1,26 +1,33 @@
-//go:build linux && cgo
-// +build linux,cgo
-
 package logs
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"path"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/coreos/go-systemd/v22/sdjournal"
-
 	"github.com/yaat-app/sidecar/internal/buffer"
+	"github.com/yaat-app/sidecar/internal/logging"
 	"github.com/yaat-app/sidecar/internal/scrubber"
 )
 
+var journaldLogger = logging.New("Journald")
+
 // JournaldTailer reads entries from systemd-journald and converts them to events.
+// Builds with cgo read the journal through sd-journal; others run journalctl.
 type JournaldTailer struct {
 	organizationID string
 	serviceName    string
 	environment    string
 	globalTags     map[string]string
 	buf            *buffer.Buffer
+	backpressure   time.Duration
+	includeFields  []string // Upper-cased globs of fields forwarded as tags
+	currentBoot    bool
+	namespace      string
 	ctx            context.Context
 	cancel         context.CancelFunc
 }
@@ -39,81 +46,27 @@ func NewJournaldTailer(organizationID, serviceName, environment string, globalTa
 	}
 }
 
-// Start begins tailing. It spawns a goroutine; callers should maintain lifecycle via returned cancel func.
-func (t *JournaldTailer) Start(matchUnit string) error {
-	journal, err := sdjournal.NewJournal()
-	if err != nil {
-		return fmt.Errorf("open journald: %w", err)
-	}
-
-	if matchUnit != "" {
-		if err := journal.AddMatch(fmt.Sprintf("_SYSTEMD_UNIT=%s", matchUnit)); err != nil {
-			_ = journal.Close()
-			return fmt.Errorf("journald add match: %w", err)
-		}
-	}
+// SetBackpressure makes the tailer wait up to timeout per entry for a flush
+// when the buffer is full, as Tailer.SetBackpressure does. Zero disables it.
+func (t *JournaldTailer) SetBackpressure(timeout time.Duration) {
+	t.backpressure = timeout
+}
 
-	if err := journal.SeekTail(); err != nil {
-		_ = journal.Close()
-		return fmt.Errorf("journald seek tail: %w", err)
+// SetIncludeFields forwards the entry fields matching any of patterns
+// (globs such as MY_APP_*, compared upper-cased as journald stores names)
+// as journal.field.<name> tags, on top of the fixed journal.* tags.
+func (t *JournaldTailer) SetIncludeFields(patterns []string) {
+	t.includeFields = make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		t.includeFields = append(t.includeFields, strings.ToUpper(pattern))
 	}
-	// Skip existing entries
-	_, _ = journal.Next()
-
-	go func() {
-		defer journal.Close()
-
-		for {
-			select {
-			case <-t.ctx.Done():
-				return
-			default:
-			}
-
-			n, err := journal.Next()
-			if err != nil {
-				// Error occurred, wait and retry
-				time.Sleep(200 * time.Millisecond)
-				continue
-			}
-
-			if n == 0 {
-				// No new entries, wait before checking again
-				time.Sleep(200 * time.Millisecond)
-				continue
-			}
-
-			entry, err := journal.GetEntry()
-			if err != nil {
-				time.Sleep(200 * time.Millisecond)
-				continue
-			}
-
-			event := t.convertEntry(entry)
-
-			// Merge global tags with event-specific tags
-			if len(t.globalTags) > 0 {
-				eventTags, ok := event["tags"].(map[string]string)
-				if !ok || eventTags == nil {
-					// No existing tags, use global tags
-					event["tags"] = t.globalTags
-				} else {
-					// Merge tags (event-specific tags take priority)
-					for k, v := range t.globalTags {
-						if _, exists := eventTags[k]; !exists {
-							eventTags[k] = v
-						}
-					}
-				}
-			}
-
-			if scrubber.Apply(event) {
-				t.buf.Add(event)
-			}
-		}
-	}()
+}
 
-	return nil
+// SetScope limits the tailer to entries of the current boot and, when
+// namespace is set, to that journal namespace. Call before Start.
+func (t *JournaldTailer) SetScope(currentBoot bool, namespace string) {
+	t.currentBoot = currentBoot
+	t.namespace = namespace
 }
 
 // Stop cancels the tailer.
@@ -121,24 +74,28 @@ func (t *JournaldTailer) Stop() {
 	t.cancel()
 }
 
-func (t *JournaldTailer) convertEntry(entry *sdjournal.JournalEntry) buffer.Event {
-	timestamp := time.Unix(0, int64(entry.RealtimeTimestamp)*int64(time.Microsecond)).UTC()
-	message := entry.Fields["MESSAGE"]
-	priority := entry.Fields["PRIORITY"]
-	unit := entry.Fields["_SYSTEMD_UNIT"]
-	identifier := entry.Fields["SYSLOG_IDENTIFIER"]
+// journalEvent converts journal fields into an event. realtimeMicros is the
+// entry's wall-clock timestamp in microseconds since the epoch.
+func (t *JournaldTailer) journalEvent(fields map[string]string, realtimeMicros uint64) buffer.Event {
+	timestamp := time.Unix(0, int64(realtimeMicros)*int64(time.Microsecond)).UTC()
+	priority := fields["PRIORITY"]
 
 	level := mapJournalPriority(priority)
 	tags := map[string]string{
-		"journal.unit":       unit,
+		"journal.unit":       fields["_SYSTEMD_UNIT"],
 		"journal.priority":   priority,
-		"journal.identifier": identifier,
-		"journal.hostname":   entry.Fields["_HOSTNAME"],
-		"journal.transport":  entry.Fields["__TRANSPORT"],
-		"journal.pid":        entry.Fields["_PID"],
-		"journal.comm":       entry.Fields["_COMM"],
-		"journal.executable": entry.Fields["_EXE"],
-		"journal.subsystem":  entry.Fields["SYSLOG_FACILITY"],
+		"journal.identifier": fields["SYSLOG_IDENTIFIER"],
+		"journal.hostname":   fields["_HOSTNAME"],
+		"journal.transport":  fields["__TRANSPORT"],
+		"journal.pid":        fields["_PID"],
+		"journal.comm":       fields["_COMM"],
+		"journal.executable": fields["_EXE"],
+		"journal.subsystem":  fields["SYSLOG_FACILITY"],
+	}
+	for name, value := range fields {
+		if t.includesField(name) {
+			tags["journal.field."+strings.ToLower(name)] = value
+		}
 	}
 	for k, v := range tags {
 		if v == "" {
@@ -153,11 +110,127 @@ func (t *JournaldTailer) convertEntry(entry *sdjournal.JournalEntry) buffer.Even
 		"event_type":      "log",
 		"timestamp":       timestamp.Format(time.RFC3339Nano),
 		"level":           level,
-		"message":         message,
+		"message":         fields["MESSAGE"],
 		"tags":            tags,
 	}
 }
 
+func (t *JournaldTailer) includesField(name string) bool {
+	for _, pattern := range t.includeFields {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// journalMatches returns the field matches that select the tailer's entries
+// with sd-journal; bootID is the current boot's ID.
+func (t *JournaldTailer) journalMatches(matchUnit, bootID string) []string {
+	var matches []string
+	if matchUnit != "" {
+		matches = append(matches, "_SYSTEMD_UNIT="+matchUnit)
+	}
+	if t.currentBoot && bootID != "" {
+		matches = append(matches, "_BOOT_ID="+bootID)
+	}
+	if t.namespace != "" {
+		matches = append(matches, "_NAMESPACE="+t.namespace)
+	}
+	return matches
+}
+
+// journalctlArgs returns the arguments that stream the tailer's entries with
+// journalctl, resuming after cursor when it is set.
+func (t *JournaldTailer) journalctlArgs(matchUnit, cursor string) []string {
+	args := []string{"--follow", "--output=json", "--no-pager"}
+	if cursor != "" {
+		args = append(args, "--after-cursor="+cursor)
+	} else {
+		// Skip existing entries, like the sd-journal implementation
+		args = append(args, "--lines=0")
+	}
+	if t.currentBoot {
+		args = append(args, "--boot")
+	}
+	if t.namespace != "" {
+		args = append(args, "--namespace="+t.namespace)
+	}
+	if matchUnit != "" {
+		args = append(args, "_SYSTEMD_UNIT="+matchUnit)
+	}
+	return args
+}
+
+// emit merges global tags into event and buffers it unless scrubbing drops it.
+func (t *JournaldTailer) emit(event buffer.Event) {
+	if len(t.globalTags) > 0 {
+		eventTags, ok := event["tags"].(map[string]string)
+		if !ok || eventTags == nil {
+			// No existing tags, use a copy of the global tags; scrubbing edits them in place
+			tags := make(map[string]string, len(t.globalTags))
+			for k, v := range t.globalTags {
+				tags[k] = v
+			}
+			event["tags"] = tags
+		} else {
+			// Merge tags (event-specific tags take priority)
+			for k, v := range t.globalTags {
+				if _, exists := eventTags[k]; !exists {
+					eventTags[k] = v
+				}
+			}
+		}
+	}
+
+	if !scrubber.Apply(event) {
+		return
+	}
+	if t.backpressure > 0 {
+		t.buf.AddWait(event, t.backpressure)
+		return
+	}
+	t.buf.Add(event)
+}
+
+// parseJournalctlLine decodes one line of `journalctl --output=json`. Values
+// are usually strings; binary fields arrive as byte arrays and repeated fields
+// as arrays of strings, of which the first is kept.
+func parseJournalctlLine(line []byte) (map[string]string, uint64, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(line, &raw); err != nil {
+		return nil, 0, fmt.Errorf("invalid journalctl JSON: %w", err)
+	}
+
+	fields := make(map[string]string, len(raw))
+	for key, value := range raw {
+		var s string
+		if err := json.Unmarshal(value, &s); err == nil {
+			fields[key] = s
+			continue
+		}
+		var numbers []int
+		if err := json.Unmarshal(value, &numbers); err == nil {
+			data := make([]byte, len(numbers))
+			for i, n := range numbers {
+				data[i] = byte(n)
+			}
+			fields[key] = string(data)
+			continue
+		}
+		var values []string
+		if err := json.Unmarshal(value, &values); err == nil && len(values) > 0 {
+			fields[key] = values[0]
+		}
+	}
+
+	realtime, err := strconv.ParseUint(fields["__REALTIME_TIMESTAMP"], 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid __REALTIME_TIMESTAMP %q", fields["__REALTIME_TIMESTAMP"])
+	}
+	return fields, realtime, nil
+}
+
 func mapJournalPriority(priority string) string {
 	switch priority {
 	case "0", "1":
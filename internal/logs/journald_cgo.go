@@ -0,0 +1,126 @@
+//go:build linux && cgo
+// +build linux,cgo
+
+package logs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/sdjournal"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+)
+
+// JournaldBackend names the journald implementation compiled into this build.
+const JournaldBackend = "sd-journal (cgo)"
+
+// JournaldAvailable reports whether the journal can be opened.
+func JournaldAvailable() error {
+	journal, err := sdjournal.NewJournal()
+	if err != nil {
+		return fmt.Errorf("open journald: %w", err)
+	}
+	return journal.Close()
+}
+
+// Start begins tailing. It spawns a goroutine; callers should maintain lifecycle via returned cancel func.
+func (t *JournaldTailer) Start(matchUnit string) error {
+	journal, err := openJournal(t.namespace)
+	if err != nil {
+		return fmt.Errorf("open journald: %w", err)
+	}
+
+	bootID := ""
+	if t.currentBoot {
+		if bootID, err = journal.GetBootID(); err != nil {
+			_ = journal.Close()
+			return fmt.Errorf("journald boot ID: %w", err)
+		}
+	}
+	for _, match := range t.journalMatches(matchUnit, bootID) {
+		if err := journal.AddMatch(match); err != nil {
+			_ = journal.Close()
+			return fmt.Errorf("journald add match: %w", err)
+		}
+	}
+
+	if err := journal.SeekTail(); err != nil {
+		_ = journal.Close()
+		return fmt.Errorf("journald seek tail: %w", err)
+	}
+	// Skip existing entries
+	_, _ = journal.Next()
+
+	go func() {
+		defer journal.Close()
+
+		for {
+			select {
+			case <-t.ctx.Done():
+				return
+			default:
+			}
+
+			n, err := journal.Next()
+			if err != nil {
+				// Error occurred, wait and retry
+				time.Sleep(200 * time.Millisecond)
+				continue
+			}
+
+			if n == 0 {
+				// No new entries, wait before checking again
+				time.Sleep(200 * time.Millisecond)
+				continue
+			}
+
+			entry, err := journal.GetEntry()
+			if err != nil {
+				time.Sleep(200 * time.Millisecond)
+				continue
+			}
+
+			t.emit(t.convertEntry(entry))
+		}
+	}()
+
+	return nil
+}
+
+// openJournal opens the system journal, or the files of namespace, which
+// sd-journal does not read unless pointed at them.
+func openJournal(namespace string) (*sdjournal.Journal, error) {
+	if namespace == "" {
+		return sdjournal.NewJournal()
+	}
+	dir, err := namespaceDir(namespace)
+	if err != nil {
+		return nil, err
+	}
+	return sdjournal.NewJournalFromDir(dir)
+}
+
+// namespaceDir returns where journald keeps a namespace's files, persistent
+// under /var/log/journal or volatile under /run/log/journal.
+func namespaceDir(namespace string) (string, error) {
+	machineID, err := os.ReadFile("/etc/machine-id")
+	if err != nil {
+		return "", fmt.Errorf("read machine ID: %w", err)
+	}
+	name := strings.TrimSpace(string(machineID)) + "." + namespace
+	for _, root := range []string{"/var/log/journal", "/run/log/journal"} {
+		dir := filepath.Join(root, name)
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return dir, nil
+		}
+	}
+	return "", fmt.Errorf("no journal files for namespace %q", namespace)
+}
+
+func (t *JournaldTailer) convertEntry(entry *sdjournal.JournalEntry) buffer.Event {
+	return t.journalEvent(entry.Fields, entry.RealtimeTimestamp)
+}
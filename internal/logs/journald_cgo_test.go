@@ -0,0 +1,60 @@
+//go:build linux && cgo
+// +build linux,cgo
+
+package logs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/coreos/go-systemd/v22/sdjournal"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+)
+
+func TestConvertEntryIncludeFields(t *testing.T) {
+	entry := &sdjournal.JournalEntry{
+		Fields: map[string]string{
+			"MESSAGE":          "order placed",
+			"PRIORITY":         "4",
+			"_SYSTEMD_UNIT":    "shop.service",
+			"MY_APP_TENANT":    "acme",
+			"MY_APP_REQUEST":   "r-17",
+			"MY_APP_EMPTY":     "",
+			"CODE_FILE":        "orders.c",
+			"OTHER_APP_TENANT": "globex",
+		},
+		RealtimeTimestamp: 1729938615123456,
+	}
+
+	tailer := NewJournaldTailer("org", "svc", "prod", nil, buffer.New(10))
+	tags := tailer.convertEntry(entry)["tags"].(map[string]string)
+	for key := range tags {
+		if strings.HasPrefix(key, "journal.field.") {
+			t.Errorf("Expected no custom fields without include_fields, got %s", key)
+		}
+	}
+
+	tailer.SetIncludeFields([]string{"my_app_*", "CODE_FILE"})
+	event := tailer.convertEntry(entry)
+	tags = event["tags"].(map[string]string)
+	expected := map[string]string{
+		"journal.field.my_app_tenant":  "acme",
+		"journal.field.my_app_request": "r-17",
+		"journal.field.code_file":      "orders.c",
+	}
+	for key, value := range expected {
+		if tags[key] != value {
+			t.Errorf("Expected %s=%s, got %q", key, value, tags[key])
+		}
+	}
+	if _, ok := tags["journal.field.other_app_tenant"]; ok {
+		t.Error("Expected fields matching no pattern to be left out")
+	}
+	if _, ok := tags["journal.field.my_app_empty"]; ok {
+		t.Error("Expected empty fields to be left out")
+	}
+	if tags["journal.unit"] != "shop.service" || event["level"] != "warning" {
+		t.Errorf("Expected the fixed tags and priority mapping unchanged, got %v / %v", tags, event["level"])
+	}
+}
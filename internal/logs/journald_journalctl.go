@@ -0,0 +1,107 @@
+//go:build linux && !cgo
+// +build linux,!cgo
+
+package logs
+
+import (
+	"bufio"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// JournaldBackend names the journald implementation compiled into this build.
+const JournaldBackend = "journalctl subprocess (no cgo)"
+
+const (
+	journalctlMinBackoff = time.Second
+	journalctlMaxBackoff = 30 * time.Second
+	// A run lasting this long counts as healthy and resets the backoff
+	journalctlStableRun = time.Minute
+)
+
+// JournaldAvailable reports whether journalctl can be run.
+func JournaldAvailable() error {
+	if _, err := exec.LookPath("journalctl"); err != nil {
+		return fmt.Errorf("journalctl not found: %w", err)
+	}
+	return nil
+}
+
+// Start runs `journalctl --follow --output=json` and restarts it with backoff
+// whenever it exits, resuming after the last cursor seen.
+func (t *JournaldTailer) Start(matchUnit string) error {
+	path, err := exec.LookPath("journalctl")
+	if err != nil {
+		return fmt.Errorf("journalctl not found: %w", err)
+	}
+
+	go func() {
+		cursor := ""
+		backoff := journalctlMinBackoff
+		for {
+			started := time.Now()
+			next, err := t.runJournalctl(path, matchUnit, cursor)
+			if next != "" {
+				cursor = next
+			}
+
+			select {
+			case <-t.ctx.Done():
+				return
+			default:
+			}
+
+			if time.Since(started) > journalctlStableRun {
+				backoff = journalctlMinBackoff
+			}
+			journaldLogger.Warnf("journalctl exited (%v); restarting in %s", err, backoff)
+
+			select {
+			case <-t.ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > journalctlMaxBackoff {
+				backoff = journalctlMaxBackoff
+			}
+		}
+	}()
+
+	return nil
+}
+
+// runJournalctl streams entries until the subprocess exits or the tailer is
+// stopped, returning the cursor of the last entry read.
+func (t *JournaldTailer) runJournalctl(path, matchUnit, cursor string) (string, error) {
+	cmd := exec.CommandContext(t.ctx, path, t.journalctlArgs(matchUnit, cursor)...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	if err := cmd.Start(); err != nil {
+		return "", err
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	last := ""
+	for scanner.Scan() {
+		fields, realtime, err := parseJournalctlLine(scanner.Bytes())
+		if err != nil {
+			journaldLogger.Warnf("Skipping entry: %v", err)
+			continue
+		}
+		if c := fields["__CURSOR"]; c != "" {
+			last = c
+		}
+		t.emit(t.journalEvent(fields, realtime))
+	}
+	scanErr := scanner.Err()
+
+	if err := cmd.Wait(); err != nil {
+		return last, err
+	}
+	return last, scanErr
+}
@@ -1,23 +1,19 @@
-//go:build !linux || !cgo
-// +build !linux !cgo
+//go:build !linux
+// +build !linux
 
 package logs
 
-import (
-	"log"
+import "errors"
 
-	"github.com/yaat-app/sidecar/internal/buffer"
-)
+// JournaldBackend names the journald implementation compiled into this build.
+const JournaldBackend = "unsupported"
 
-type JournaldTailer struct{}
-
-func NewJournaldTailer(organizationID, serviceName, environment string, globalTags map[string]string, buf *buffer.Buffer) *JournaldTailer {
-	return &JournaldTailer{}
+// JournaldAvailable always fails: journald exists only on Linux.
+func JournaldAvailable() error {
+	return errors.New("journald is only supported on Linux")
 }
 
 func (t *JournaldTailer) Start(matchUnit string) error {
-	log.Printf("[Journald] Streaming not supported on this platform")
+	journaldLogger.Warnf("Streaming not supported on this platform")
 	return nil
 }
-
-func (t *JournaldTailer) Stop() {}
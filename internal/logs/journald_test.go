@@ -0,0 +1,85 @@
+package logs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+)
+
+const journalctlLine = `{"__CURSOR":"s=abc;i=42","__REALTIME_TIMESTAMP":"1729938615123456","PRIORITY":"3","_SYSTEMD_UNIT":"app.service","SYSLOG_IDENTIFIER":"app","_HOSTNAME":"web-1","_PID":"1234","__TRANSPORT":"stdout","MESSAGE":"connection refused","_EXE":null}`
+
+func TestParseJournalctlLine(t *testing.T) {
+	fields, realtime, err := parseJournalctlLine([]byte(journalctlLine))
+	if err != nil {
+		t.Fatalf("parseJournalctlLine failed: %v", err)
+	}
+	if realtime != 1729938615123456 {
+		t.Errorf("Expected realtime timestamp, got %d", realtime)
+	}
+	if fields["__CURSOR"] != "s=abc;i=42" {
+		t.Errorf("Expected cursor, got %q", fields["__CURSOR"])
+	}
+
+	buf := buffer.New(10)
+	tailer := NewJournaldTailer("org", "svc", "prod", map[string]string{"region": "eu", "journal.unit": "override"}, buf)
+	tailer.emit(tailer.journalEvent(fields, realtime))
+
+	events := buf.Flush()
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(events))
+	}
+	event := events[0]
+	if event["message"] != "connection refused" || event["level"] != "error" {
+		t.Errorf("Unexpected message/level: %v/%v", event["message"], event["level"])
+	}
+	if event["timestamp"] != "2024-10-26T10:30:15.123456Z" {
+		t.Errorf("Expected timestamp from __REALTIME_TIMESTAMP, got %v", event["timestamp"])
+	}
+	tags := event["tags"].(map[string]string)
+	if tags["journal.unit"] != "app.service" || tags["journal.pid"] != "1234" || tags["region"] != "eu" {
+		t.Errorf("Unexpected tags: %v", tags)
+	}
+	if _, ok := tags["journal.executable"]; ok {
+		t.Error("Expected empty fields to be omitted from tags")
+	}
+}
+
+func TestParseJournalctlLineEncodings(t *testing.T) {
+	line := `{"__REALTIME_TIMESTAMP":"1","MESSAGE":[104,105,0,10],"SYSLOG_IDENTIFIER":["first","second"]}`
+	fields, _, err := parseJournalctlLine([]byte(line))
+	if err != nil {
+		t.Fatalf("parseJournalctlLine failed: %v", err)
+	}
+	if fields["MESSAGE"] != "hi\x00\n" {
+		t.Errorf("Expected binary message to be decoded, got %q", fields["MESSAGE"])
+	}
+	if fields["SYSLOG_IDENTIFIER"] != "first" {
+		t.Errorf("Expected first value of repeated field, got %q", fields["SYSLOG_IDENTIFIER"])
+	}
+
+	if _, _, err := parseJournalctlLine([]byte(`{"MESSAGE":"no timestamp"}`)); err == nil {
+		t.Error("Expected error for entry without __REALTIME_TIMESTAMP")
+	}
+	if _, _, err := parseJournalctlLine([]byte(`not json`)); err == nil {
+		t.Error("Expected error for invalid JSON")
+	}
+}
+
+func TestJournaldScope(t *testing.T) {
+	tailer := NewJournaldTailer("org", "svc", "prod", nil, buffer.New(10))
+	if got := strings.Join(tailer.journalctlArgs("app.service", ""), " "); got != "--follow --output=json --no-pager --lines=0 _SYSTEMD_UNIT=app.service" {
+		t.Errorf("Unexpected default journalctl arguments: %s", got)
+	}
+	if got := tailer.journalMatches("", "b00t"); len(got) != 0 {
+		t.Errorf("Expected no matches for an unscoped tailer, got %v", got)
+	}
+
+	tailer.SetScope(true, "tenants")
+	if got := strings.Join(tailer.journalctlArgs("app.service", "s=abc"), " "); got != "--follow --output=json --no-pager --after-cursor=s=abc --boot --namespace=tenants _SYSTEMD_UNIT=app.service" {
+		t.Errorf("Unexpected scoped journalctl arguments: %s", got)
+	}
+	if got := strings.Join(tailer.journalMatches("app.service", "b00t"), " "); got != "_SYSTEMD_UNIT=app.service _BOOT_ID=b00t _NAMESPACE=tenants" {
+		t.Errorf("Unexpected scoped matches: %s", got)
+	}
+}
@@ -0,0 +1,109 @@
+package logs
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/yaat-app/sidecar/internal/state"
+)
+
+// ReadSince parses the lines of the tailer's file written after from and
+// returns the offset to resume at next time, for runs that exit between
+// reads (--once). With no previous offset nothing is read and the current
+// end is returned, as the daemon also starts tailing at the end.
+//
+// When the file was rotated since, the rest of the old file is read first if
+// it is still next to it uncompressed (app.log.1), then the new file from
+// the start. A last line without a newline is left for the next run, as it
+// may still be being written.
+func (t *Tailer) ReadSince(from *state.LogOffset, afterLine func(lines int)) (state.LogOffset, int, error) {
+	inode, size, ok := statFile(t.path)
+	if !ok {
+		_, err := os.Stat(t.path)
+		return state.LogOffset{}, 0, err
+	}
+	if from == nil {
+		return state.LogOffset{Inode: inode, Offset: size}, 0, nil
+	}
+
+	lines := 0
+	start := from.Offset
+	if from.Inode != inode || size < from.Offset {
+		if from.Inode != inode {
+			if rotated := findByInode(t.path, from.Inode); rotated != "" {
+				n, _, err := t.readRange(rotated, from.Offset, afterLine, 0)
+				if err != nil {
+					return *from, 0, err
+				}
+				lines += n
+			}
+		}
+		start = 0
+	}
+
+	n, consumed, err := t.readRange(t.path, start, afterLine, lines)
+	lines += n
+	t.flushPending()
+	t.flushDedupe()
+	if err != nil {
+		return *from, lines, err
+	}
+	return state.LogOffset{Inode: inode, Offset: start + consumed}, lines, nil
+}
+
+// readRange handles the complete lines of path from offset to its current
+// end and returns how many lines and bytes it consumed. counted lines were
+// already reported to afterLine.
+func (t *Tailer) readRange(path string, offset int64, afterLine func(lines int), counted int) (int, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return 0, 0, err
+	}
+
+	reader := bufio.NewReader(file)
+	lines := 0
+	var consumed int64
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			return lines, consumed, nil
+		}
+		if err != nil {
+			return lines, consumed, err
+		}
+		consumed += int64(len(line))
+		t.handleLine(strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"))
+		lines++
+		if afterLine != nil {
+			afterLine(counted + lines)
+		}
+	}
+}
+
+// findByInode returns the rotated sibling of path (app.log.1, app.log-2024...)
+// that is the file with inode, or "" once it was compressed or removed.
+func findByInode(path string, inode uint64) string {
+	if inode == 0 {
+		return ""
+	}
+	matches, err := filepath.Glob(path + "*")
+	if err != nil {
+		return ""
+	}
+	for _, match := range matches {
+		if match == path {
+			continue
+		}
+		if candidate, _, ok := statFile(match); ok && candidate == inode {
+			return match
+		}
+	}
+	return ""
+}
@@ -0,0 +1,97 @@
+package logs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+	"github.com/yaat-app/sidecar/internal/state"
+)
+
+func appendLog(t *testing.T, path, content string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatalf("Failed to open log: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatalf("Failed to write log: %v", err)
+	}
+}
+
+func TestReadSince(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	appendLog(t, path, `{"msg":"old"}`+"\n")
+	buf := buffer.New(0)
+	tailer := New(path, "json", "org", "svc", "prod", nil, buf)
+
+	// The first run only records the end, like the daemon starting to tail
+	offset, lines, err := tailer.ReadSince(nil, nil)
+	if err != nil || lines != 0 || buf.Len() != 0 {
+		t.Fatalf("Expected nothing read on the first run, got %d lines, err %v", lines, err)
+	}
+
+	// A line still being written is left for the next run
+	appendLog(t, path, `{"msg":"one"}`+"\n"+`{"msg":"two"}`+"\n"+`{"msg":"thr`)
+	offset, lines, err = tailer.ReadSince(&offset, nil)
+	if err != nil {
+		t.Fatalf("ReadSince failed: %v", err)
+	}
+	if got := messages(buf.Flush()); lines != 2 || len(got) != 2 || got[0] != "one" || got[1] != "two" {
+		t.Errorf("Expected the two complete lines, got %d lines and %v", lines, got)
+	}
+
+	appendLog(t, path, `ee"}`+"\n")
+	offset, _, err = tailer.ReadSince(&offset, nil)
+	if err != nil {
+		t.Fatalf("ReadSince failed: %v", err)
+	}
+	if got := messages(buf.Flush()); len(got) != 1 || got[0] != "three" {
+		t.Errorf("Expected the finished line, got %v", got)
+	}
+
+	// Nothing new reads nothing
+	if _, lines, _ := tailer.ReadSince(&offset, nil); lines != 0 {
+		t.Errorf("Expected no lines without new data, got %d", lines)
+	}
+}
+
+func TestReadSinceAfterRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	appendLog(t, path, `{"msg":"old"}`+"\n")
+	buf := buffer.New(0)
+	tailer := New(path, "json", "org", "svc", "prod", nil, buf)
+	offset, _, err := tailer.ReadSince(nil, nil)
+	if err != nil {
+		t.Fatalf("ReadSince failed: %v", err)
+	}
+
+	// Written before logrotate renamed the file, then a fresh file
+	appendLog(t, path, `{"msg":"before rotation"}`+"\n")
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatalf("Failed to rotate: %v", err)
+	}
+	appendLog(t, path, `{"msg":"after rotation"}`+"\n")
+
+	offset, lines, err := tailer.ReadSince(&offset, nil)
+	if err != nil {
+		t.Fatalf("ReadSince failed: %v", err)
+	}
+	got := messages(buf.Flush())
+	if lines != 2 || len(got) != 2 || got[0] != "before rotation" || got[1] != "after rotation" {
+		t.Errorf("Expected the rest of app.log.1, then the new file, got %v", got)
+	}
+
+	// Truncated in place: read from the start
+	if err := os.WriteFile(path, []byte(`{"msg":"x"}`+"\n"), 0o644); err != nil {
+		t.Fatalf("Failed to truncate: %v", err)
+	}
+	if _, _, err := tailer.ReadSince(&state.LogOffset{Inode: offset.Inode, Offset: 1 << 20}, nil); err != nil {
+		t.Fatalf("ReadSince failed: %v", err)
+	}
+	if got := messages(buf.Flush()); len(got) != 1 || got[0] != "x" {
+		t.Errorf("Expected a truncated file read from the start, got %v", got)
+	}
+}
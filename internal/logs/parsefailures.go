@@ -0,0 +1,144 @@
+package logs
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/yaat-app/sidecar/internal/buffer"
+	"github.com/yaat-app/sidecar/internal/diag"
+	"github.com/yaat-app/sidecar/internal/scrubber"
+)
+
+const (
+	// parseFailureStreak is how many lines in a row must fail to parse
+	// before the tailer warns that its format looks wrong.
+	parseFailureStreak = 100
+
+	// parseFailureWindow lines are counted before the failure ratio is
+	// checked and the counts start over.
+	parseFailureWindow = 1000
+
+	// parseFailureRatio of a window failing to parse gets a format suggestion.
+	parseFailureRatio = 0.9
+
+	// maxParseSampleLen bounds the sample line quoted in warnings.
+	maxParseSampleLen = 200
+
+	// parseFailuresLogger names the event that reports a mismatched format.
+	parseFailuresLogger = "yaat.tailer.parse_failures"
+)
+
+// parseFailures notices a tailer whose lines do not match its format, such
+// as JSON lines read with format "nginx", which would otherwise ship
+// nothing without saying why.
+type parseFailures struct {
+	streak    int
+	lines     int // In the current window
+	failed    int // In the current window
+	sample    string
+	warned    bool // For the current streak
+	reported  bool // The parse_failures event was emitted
+	suggested bool
+}
+
+// observeParse records whether line parsed and returns the event to emit, once
+// per tailer, when a streak of failures first reaches parseFailureStreak.
+func (t *Tailer) observeParse(line string, parsed bool) buffer.Event {
+	if strings.TrimSpace(line) == "" {
+		return nil
+	}
+	p := &t.parseFailures
+	p.lines++
+	if parsed {
+		p.streak = 0
+		p.warned = false
+	} else {
+		p.streak++
+		p.failed++
+		p.sample = line
+		diag.Global().RecordParseFailures(t.path, 1)
+	}
+
+	var event buffer.Event
+	if p.streak >= parseFailureStreak && !p.warned {
+		p.warned = true
+		tailerLogger.Warnf("%d lines in a row of %s failed to parse as format %q and were dropped; check logs[].format. Sample: %s",
+			p.streak, t.path, t.format, sanitizeSample(p.sample))
+		if !p.reported {
+			p.reported = true
+			event = t.parseFailureEvent(p.streak)
+		}
+	}
+
+	if p.lines >= parseFailureWindow {
+		if !p.suggested && float64(p.failed) > parseFailureRatio*float64(p.lines) {
+			p.suggested = true
+			if suggestion := suggestFormat(p.sample); suggestion != t.format {
+				tailerLogger.Warnf("%d of the last %d lines of %s failed to parse as format %q; they look like %q, try format: %s",
+					p.failed, p.lines, t.path, t.format, suggestion, suggestion)
+			}
+		}
+		p.lines, p.failed = 0, 0
+	}
+	return event
+}
+
+// parseFailureEvent reports a mismatched format to YAAT, where the missing
+// events would otherwise go unexplained.
+func (t *Tailer) parseFailureEvent(streak int) buffer.Event {
+	event := buffer.Event{
+		"organization_id": t.organizationID,
+		"service_name":    t.serviceName,
+		"event_id":        uuid.New().String(),
+		"timestamp":       time.Now().UTC().Format(time.RFC3339Nano),
+		"event_type":      "log",
+		"environment":     t.environment,
+		"level":           "warning",
+		"logger":          parseFailuresLogger,
+		"message":         fmt.Sprintf("%d lines in a row of %s failed to parse as format %q", streak, t.path, t.format),
+		"tags": map[string]string{
+			"yaat.sidecar": "true",
+			"source":       t.path,
+			"format":       t.format,
+		},
+	}
+	t.mergeGlobalTags(event)
+	return event
+}
+
+// suggestFormat guesses the format of line the way format "auto" would,
+// falling back to "generic".
+func suggestFormat(line string) string {
+	for _, f := range autoFormats {
+		if f.match(line) {
+			return f.name
+		}
+	}
+	if apacheLogRegex.MatchString(line) {
+		return "apache"
+	}
+	return "generic"
+}
+
+// sanitizeSample prepares a line for quoting in a warning: the scrubbing
+// rules run on it as a message, control characters are escaped and it is
+// cut to maxParseSampleLen.
+func sanitizeSample(line string) string {
+	evt := buffer.Event{"message": line}
+	if !scrubber.Apply(evt) {
+		return "(withheld by a scrubbing rule)"
+	}
+	line, _ = evt["message"].(string)
+	if runes := []rune(line); len(runes) > maxParseSampleLen {
+		line = string(runes[:maxParseSampleLen]) + "…"
+	}
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return '?'
+		}
+		return r
+	}, line)
+}
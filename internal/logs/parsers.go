@@ -2,9 +2,11 @@ package logs
 
 import (
 	"encoding/json"
+	"math"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -15,16 +17,25 @@ import (
 // Format: [2024-10-26 10:30:15,123] ERROR [django.request] Message here
 var djangoLogRegex = regexp.MustCompile(`^\[(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2},\d{3})\] (\w+) \[([^\]]+)\] (.+)$`)
 
+// keyValueRegex matches key=value pairs embedded in free-form messages.
+// Values may be bare or wrapped in double/single quotes.
+var keyValueRegex = regexp.MustCompile(`(?:^|\s)([A-Za-z_][\w.-]*)=("(?:[^"\\]|\\.)*"|'[^']*'|[^\s,;]+)`)
+
 // NginxLogParser parses Nginx access log format
 // Format: IP - - [timestamp] "METHOD /path HTTP/1.1" status size "referer" "user-agent"
 var nginxLogRegex = regexp.MustCompile(`^(\S+) - - \[([^\]]+)\] "(\w+) ([^ ]+) HTTP/[^"]+" (\d+) (\d+)(?: "([^"]*)" "([^"]*)")?`)
 
 // ApacheLogParser parses Apache Common/Combined log format
-// Format: IP - - [timestamp] "METHOD /path HTTP/1.1" status size "referer" "user-agent"
-var apacheLogRegex = regexp.MustCompile(`^(\S+) - - \[([^\]]+)\] "(\w+) ([^ ]+) HTTP/[^"]+" (\d+) (\d+)(?: "([^"]*)" "([^"]*)")?`)
+// Format: IP - - [timestamp] "METHOD /path HTTP/1.1" status size "referer" "user-agent" [%D]
+// A trailing number is the %D response time in microseconds.
+var apacheLogRegex = regexp.MustCompile(`^(\S+) - - \[([^\]]+)\] "(\w+) ([^ ]+) HTTP/[^"]+" (\d+) (\d+)(?: "([^"]*)" "([^"]*)")?(?: (\d+)(?:\s|$))?`)
 
 // ParseDjangoLog parses a Django log line
 func ParseDjangoLog(line, organizationID, serviceName, environment string) *buffer.Event {
+	return parseDjangoLog(line, organizationID, serviceName, environment, time.UTC)
+}
+
+func parseDjangoLog(line, organizationID, serviceName, environment string, loc *time.Location) *buffer.Event {
 	matches := djangoLogRegex.FindStringSubmatch(line)
 	if matches == nil {
 		// If it doesn't match, treat as generic log
@@ -47,20 +58,20 @@ func ParseDjangoLog(line, organizationID, serviceName, environment string) *buff
 	logger := matches[3]
 	message := matches[4]
 
-	// Parse timestamp
-	t, err := time.Parse("2006-01-02 15:04:05,000", timestamp)
-	if err != nil {
+	// Parse timestamp (Django writes local wall-clock time without an offset)
+	t, parsed := parseTimestamp(timestamp, loc)
+	if !parsed {
 		t = time.Now().UTC()
 	}
 
 	// Map Django log levels to standard levels
 	logLevel := mapLogLevel(level)
 
-	return &buffer.Event{
+	event := &buffer.Event{
 		"organization_id": organizationID,
 		"service_name":    serviceName,
 		"event_id":        uuid.New().String(),
-		"timestamp":       t.UTC().Format(time.RFC3339),
+		"timestamp":       t.Format(time.RFC3339Nano),
 		"event_type":      "log",
 		"environment":     environment,
 		"level":           logLevel,
@@ -70,6 +81,11 @@ func ParseDjangoLog(line, organizationID, serviceName, environment string) *buff
 			"logger": logger,
 		},
 	}
+	if !parsed {
+		preserveOriginalTimestamp(event, timestamp)
+	}
+
+	return event
 }
 
 // ParseNginxLog parses an Nginx access log line
@@ -101,8 +117,8 @@ func ParseNginxLog(line, organizationID, serviceName, environment string) *buffe
 	size, _ := strconv.Atoi(sizeStr)
 
 	// Parse timestamp (Apache/Nginx format: 02/Jan/2006:15:04:05 -0700)
-	parsedTime, err := time.Parse("02/Jan/2006:15:04:05 -0700", timestamp)
-	if err != nil {
+	parsedTime, parsed := parseTimestamp(timestamp, time.UTC)
+	if !parsed {
 		parsedTime = time.Now().UTC()
 	}
 
@@ -121,11 +137,11 @@ func ParseNginxLog(line, organizationID, serviceName, environment string) *buffe
 	}
 
 	// Create span event for HTTP request
-	return &buffer.Event{
+	event := &buffer.Event{
 		"organization_id": organizationID,
 		"service_name":    serviceName,
 		"event_id":        uuid.New().String(),
-		"timestamp":       parsedTime.UTC().Format(time.RFC3339),
+		"timestamp":       parsedTime.Format(time.RFC3339Nano),
 		"event_type":      "span",
 		"environment":     environment,
 		"trace_id":        uuid.New().String(),
@@ -137,6 +153,11 @@ func ParseNginxLog(line, organizationID, serviceName, environment string) *buffe
 		"tags":            tags,
 		"metric_value":    float64(size),
 	}
+	if !parsed {
+		preserveOriginalTimestamp(event, timestamp)
+	}
+
+	return event
 }
 
 // ParseApacheLog parses an Apache access log line (Common/Combined format)
@@ -171,9 +192,17 @@ func ParseApacheLog(line, organizationID, serviceName, environment string) *buff
 		size = 0
 	}
 
+	// Response time from a trailing %D (microseconds)
+	durationMs := 0.0
+	if len(matches) > 9 && matches[9] != "" {
+		if micros, err := strconv.ParseInt(matches[9], 10, 64); err == nil {
+			durationMs = float64(micros) / 1000
+		}
+	}
+
 	// Parse timestamp
-	parsedTime, err := time.Parse("02/Jan/2006:15:04:05 -0700", timestamp)
-	if err != nil {
+	parsedTime, parsed := parseTimestamp(timestamp, time.UTC)
+	if !parsed {
 		parsedTime = time.Now().UTC()
 	}
 
@@ -191,26 +220,35 @@ func ParseApacheLog(line, organizationID, serviceName, environment string) *buff
 		tags["user_agent"] = userAgent
 	}
 
-	return &buffer.Event{
+	event := &buffer.Event{
 		"organization_id": organizationID,
 		"service_name":    serviceName,
 		"event_id":        uuid.New().String(),
-		"timestamp":       parsedTime.UTC().Format(time.RFC3339),
+		"timestamp":       parsedTime.Format(time.RFC3339Nano),
 		"event_type":      "span",
 		"environment":     environment,
 		"trace_id":        uuid.New().String(),
 		"span_id":         uuid.New().String(),
 		"parent_span_id":  "",
 		"operation":       method + " " + path,
-		"duration_ms":     0.0,
+		"duration_ms":     durationMs,
 		"status_code":     status,
 		"tags":            tags,
 		"metric_value":    float64(size),
 	}
+	if !parsed {
+		preserveOriginalTimestamp(event, timestamp)
+	}
+
+	return event
 }
 
 // ParseDockerLog parses Docker/container runtime JSON log envelope lines.
 func ParseDockerLog(line, organizationID, serviceName, environment string) *buffer.Event {
+	return parseDockerLog(line, organizationID, serviceName, environment, time.UTC)
+}
+
+func parseDockerLog(line, organizationID, serviceName, environment string, loc *time.Location) *buffer.Event {
 	type dockerEnvelope struct {
 		Log       string `json:"log"`
 		Stream    string `json:"stream"`
@@ -223,7 +261,7 @@ func ParseDockerLog(line, organizationID, serviceName, environment string) *buff
 	var env dockerEnvelope
 	if err := json.Unmarshal([]byte(line), &env); err != nil {
 		// Fall back to generic JSON parser; the payload might already be application JSON.
-		return ParseJSONLog(line, organizationID, serviceName, environment)
+		return parseJSONLog(line, organizationID, serviceName, environment, loc)
 	}
 
 	message := strings.TrimRight(env.Log, "\r\n")
@@ -233,11 +271,8 @@ func ParseDockerLog(line, organizationID, serviceName, environment string) *buff
 
 	timestamp := time.Now().UTC()
 	if env.Time != "" {
-		for _, format := range []string{time.RFC3339Nano, time.RFC3339, "2006-01-02T15:04:05.000000000Z07:00"} {
-			if parsed, err := time.Parse(format, env.Time); err == nil {
-				timestamp = parsed.UTC()
-				break
-			}
+		if parsed, ok := parseTimestamp(env.Time, time.UTC); ok {
+			timestamp = parsed
 		}
 	} else if env.TimeNano > 0 {
 		timestamp = time.Unix(0, env.TimeNano).UTC()
@@ -253,7 +288,7 @@ func ParseDockerLog(line, organizationID, serviceName, environment string) *buff
 
 	trimmed := strings.TrimSpace(message)
 	if len(trimmed) > 0 && trimmed[0] == '{' {
-		if inner := ParseJSONLog(trimmed, organizationID, serviceName, environment); inner != nil {
+		if inner := parseJSONLog(trimmed, organizationID, serviceName, environment, loc); inner != nil {
 			(*inner)["timestamp"] = timestamp.Format(time.RFC3339Nano)
 			(*inner)["event_id"] = uuid.New().String()
 			tags := map[string]string{
@@ -314,8 +349,64 @@ func ParseDockerLog(line, organizationID, serviceName, environment string) *buff
 	return &event
 }
 
+// DefaultJSONDepth is how many levels of nested JSON objects are flattened
+// into tags when SetJSONDepth has not been called.
+const DefaultJSONDepth = 3
+
+var jsonDepth atomic.Int32
+
+// SetJSONDepth sets how many levels of nested objects in JSON logs become
+// dotted tags ({"http":{"status":500}} is http.status=500 at depth 2).
+// Objects nested deeper are kept as one JSON string tag. 0 restores the
+// default.
+func SetJSONDepth(depth int) {
+	if depth <= 0 {
+		depth = DefaultJSONDepth
+	}
+	jsonDepth.Store(int32(depth))
+}
+
+func currentJSONDepth() int {
+	if depth := jsonDepth.Load(); depth > 0 {
+		return int(depth)
+	}
+	return DefaultJSONDepth
+}
+
+// flattenJSON adds val to tags under key. Objects are descended with dotted
+// keys while depth allows; arrays, and objects past the depth limit, become
+// JSON strings. Nulls are skipped.
+func flattenJSON(tags map[string]string, key string, val interface{}, depth int) {
+	switch v := val.(type) {
+	case string:
+		tags[key] = v
+	case float64:
+		tags[key] = strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		tags[key] = strconv.FormatBool(v)
+	case map[string]interface{}:
+		if depth > 1 {
+			for k, child := range v {
+				flattenJSON(tags, key+"."+k, child, depth-1)
+			}
+			return
+		}
+		if encoded, err := json.Marshal(v); err == nil {
+			tags[key] = string(encoded)
+		}
+	case []interface{}:
+		if encoded, err := json.Marshal(v); err == nil {
+			tags[key] = string(encoded)
+		}
+	}
+}
+
 // ParseJSONLog parses a JSON log line
 func ParseJSONLog(line, organizationID, serviceName, environment string) *buffer.Event {
+	return parseJSONLog(line, organizationID, serviceName, environment, time.UTC)
+}
+
+func parseJSONLog(line, organizationID, serviceName, environment string, loc *time.Location) *buffer.Event {
 	// Try to parse as JSON
 	var logData map[string]interface{}
 	if err := json.Unmarshal([]byte(line), &logData); err != nil {
@@ -337,7 +428,8 @@ func ParseJSONLog(line, organizationID, serviceName, environment string) *buffer
 	level := "info"
 	message := line
 	stacktrace := ""
-	timestamp := time.Now().UTC().Format(time.RFC3339)
+	timestamp := time.Now().UTC().Format(time.RFC3339Nano)
+	originalTimestamp := ""
 
 	// Try to extract level (various common field names)
 	for _, key := range []string{"level", "severity", "log_level", "loglevel"} {
@@ -361,23 +453,23 @@ func ParseJSONLog(line, organizationID, serviceName, environment string) *buffer
 
 	// Try to extract timestamp
 	for _, key := range []string{"timestamp", "time", "@timestamp", "ts"} {
-		if val, ok := logData[key]; ok {
-			if str, ok := val.(string); ok {
-				// Try to parse various timestamp formats
-				for _, format := range []string{
-					time.RFC3339,
-					time.RFC3339Nano,
-					"2006-01-02T15:04:05.000Z07:00",
-					"2006-01-02 15:04:05",
-				} {
-					if t, err := time.Parse(format, str); err == nil {
-						timestamp = t.UTC().Format(time.RFC3339)
-						break
-					}
-				}
-				break
+		val, ok := logData[key]
+		if !ok {
+			continue
+		}
+		switch v := val.(type) {
+		case string:
+			if t, ok := parseTimestamp(v, loc); ok {
+				timestamp = t.Format(time.RFC3339Nano)
+			} else {
+				originalTimestamp = v
 			}
+		case float64:
+			timestamp = epochToTime(v).Format(time.RFC3339Nano)
+		default:
+			continue
 		}
+		break
 	}
 
 	// Try to extract stack trace
@@ -390,8 +482,9 @@ func ParseJSONLog(line, organizationID, serviceName, environment string) *buffer
 		}
 	}
 
-	// Build tags from remaining fields
+	// Build tags from remaining fields, flattening nested objects
 	tags := make(map[string]string)
+	depth := currentJSONDepth()
 	for key, val := range logData {
 		// Skip fields we've already extracted
 		if key == "level" || key == "severity" || key == "log_level" || key == "loglevel" ||
@@ -401,13 +494,90 @@ func ParseJSONLog(line, organizationID, serviceName, environment string) *buffer
 			continue
 		}
 
-		// Convert value to string
+		flattenJSON(tags, key, val, depth)
+	}
+
+	event := &buffer.Event{
+		"organization_id": organizationID,
+		"service_name":    serviceName,
+		"event_id":        uuid.New().String(),
+		"timestamp":       timestamp,
+		"event_type":      "log",
+		"environment":     environment,
+		"level":           level,
+		"message":         message,
+		"stacktrace":      stacktrace,
+	}
+
+	// Add tags if any
+	if len(tags) > 0 {
+		(*event)["tags"] = tags
+	}
+	if originalTimestamp != "" {
+		preserveOriginalTimestamp(event, originalTimestamp)
+	}
+
+	return event
+}
+
+// ParseGELF parses a Graylog Extended Log Format (GELF) JSON line
+func ParseGELF(line, organizationID, serviceName, environment string) *buffer.Event {
+	return parseGELF(line, organizationID, serviceName, environment, time.UTC)
+}
+
+func parseGELF(line, organizationID, serviceName, environment string, loc *time.Location) *buffer.Event {
+	var gelf map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &gelf); err != nil {
+		// Not GELF, let the JSON parser produce its generic fallback
+		return parseJSONLog(line, organizationID, serviceName, environment, loc)
+	}
+
+	message := line
+	if str, ok := gelf["short_message"].(string); ok && str != "" {
+		message = str
+	}
+	stacktrace := ""
+	if str, ok := gelf["full_message"].(string); ok {
+		stacktrace = str
+	}
+
+	// GELF levels are syslog severities (0=emergency .. 7=debug)
+	level := "info"
+	switch v := gelf["level"].(type) {
+	case float64:
+		level = mapSyslogSeverity(int(v))
+	case string:
+		if n, err := strconv.Atoi(v); err == nil {
+			level = mapSyslogSeverity(n)
+		} else {
+			level = mapLogLevel(v)
+		}
+	}
+
+	timestamp := time.Now().UTC()
+	if v, ok := gelf["timestamp"].(float64); ok && v > 0 {
+		timestamp = epochToTime(v)
+	}
+
+	tags := make(map[string]string)
+	if host, ok := gelf["host"].(string); ok && host != "" {
+		tags["host"] = host
+	}
+	if facility, ok := gelf["facility"].(string); ok && facility != "" {
+		tags["facility"] = facility
+	}
+	for key, val := range gelf {
+		// Additional fields are prefixed with an underscore
+		if !strings.HasPrefix(key, "_") || len(key) == 1 {
+			continue
+		}
+		name := strings.TrimPrefix(key, "_")
 		if str, ok := val.(string); ok {
-			tags[key] = str
+			tags[name] = str
 		} else if num, ok := val.(float64); ok {
-			tags[key] = strconv.FormatFloat(num, 'f', -1, 64)
+			tags[name] = strconv.FormatFloat(num, 'f', -1, 64)
 		} else if b, ok := val.(bool); ok {
-			tags[key] = strconv.FormatBool(b)
+			tags[name] = strconv.FormatBool(b)
 		}
 	}
 
@@ -415,15 +585,13 @@ func ParseJSONLog(line, organizationID, serviceName, environment string) *buffer
 		"organization_id": organizationID,
 		"service_name":    serviceName,
 		"event_id":        uuid.New().String(),
-		"timestamp":       timestamp,
+		"timestamp":       timestamp.Format(time.RFC3339Nano),
 		"event_type":      "log",
 		"environment":     environment,
 		"level":           level,
 		"message":         message,
 		"stacktrace":      stacktrace,
 	}
-
-	// Add tags if any
 	if len(tags) > 0 {
 		(*event)["tags"] = tags
 	}
@@ -431,6 +599,124 @@ func ParseJSONLog(line, organizationID, serviceName, environment string) *buffer
 	return event
 }
 
+// mapSyslogSeverity maps a numeric syslog severity to standard levels
+func mapSyslogSeverity(severity int) string {
+	switch {
+	case severity <= 2:
+		return "critical"
+	case severity == 3:
+		return "error"
+	case severity == 4:
+		return "warning"
+	case severity == 7:
+		return "debug"
+	default:
+		return "info"
+	}
+}
+
+// timestampLayouts are tried in order when parsing a textual timestamp.
+// Layouts without a zone offset are interpreted in the caller's location.
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05 MST",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"02/Jan/2006:15:04:05 -0700",
+	time.RFC1123Z,
+	time.RFC1123,
+}
+
+// parseTimestamp parses raw against timestampLayouts and returns the instant in UTC.
+// Fractional seconds (with '.' or ',') are accepted on every layout.
+func parseTimestamp(raw string, loc *time.Location) (time.Time, bool) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return time.Time{}, false
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+	for _, layout := range timestampLayouts {
+		if t, err := time.ParseInLocation(layout, raw, loc); err == nil {
+			return t.UTC(), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// epochToTime converts a numeric Unix timestamp in seconds or milliseconds.
+func epochToTime(v float64) time.Time {
+	if v > 1e12 {
+		return time.UnixMilli(int64(v)).UTC()
+	}
+	sec, frac := math.Modf(v)
+	return time.Unix(int64(sec), int64(frac*1e9)).UTC()
+}
+
+// preserveOriginalTimestamp records a timestamp that could not be parsed so
+// events that fell back to the ingest time are not silently mis-dated.
+func preserveOriginalTimestamp(event *buffer.Event, raw string) {
+	if raw == "" {
+		return
+	}
+	tags, ok := (*event)["tags"].(map[string]string)
+	if !ok || tags == nil {
+		tags = make(map[string]string)
+		(*event)["tags"] = tags
+	}
+	tags["original_timestamp"] = raw
+}
+
+// extractKeyValues returns key=value pairs found in a log message
+func extractKeyValues(message string) map[string]string {
+	matches := keyValueRegex.FindAllStringSubmatch(message, -1)
+	if len(matches) == 0 {
+		return nil
+	}
+
+	pairs := make(map[string]string, len(matches))
+	for _, m := range matches {
+		value := m[2]
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') {
+			if unquoted, err := strconv.Unquote(value); err == nil && value[0] == '"' {
+				value = unquoted
+			} else {
+				value = value[1 : len(value)-1]
+			}
+		}
+		pairs[m[1]] = value
+	}
+	return pairs
+}
+
+// promoteKeyValues copies key=value pairs from the event message into tags.
+// Existing tags win, and the message itself is left untouched.
+func promoteKeyValues(event *buffer.Event) {
+	message, ok := (*event)["message"].(string)
+	if !ok || message == "" {
+		return
+	}
+	pairs := extractKeyValues(message)
+	if len(pairs) == 0 {
+		return
+	}
+
+	tags, ok := (*event)["tags"].(map[string]string)
+	if !ok || tags == nil {
+		tags = make(map[string]string, len(pairs))
+		(*event)["tags"] = tags
+	}
+	for k, v := range pairs {
+		if _, exists := tags[k]; !exists {
+			tags[k] = v
+		}
+	}
+}
+
 // mapLogLevel maps various log level strings to standard levels
 func mapLogLevel(level string) string {
 	level = strings.ToLower(level)
@@ -452,17 +738,40 @@ func mapLogLevel(level string) string {
 
 // ParseLog parses a log line based on format
 func ParseLog(line, format, organizationID, serviceName, environment string) *buffer.Event {
+	return ParseLogInLocation(line, format, organizationID, serviceName, environment, time.UTC)
+}
+
+// ParseLogInLocation parses a log line based on format, interpreting timestamps
+// that carry no zone offset in loc. A nil loc is treated as UTC.
+func ParseLogInLocation(line, format, organizationID, serviceName, environment string, loc *time.Location) *buffer.Event {
+	if loc == nil {
+		loc = time.UTC
+	}
 	switch format {
 	case "django":
-		return ParseDjangoLog(line, organizationID, serviceName, environment)
+		return parseDjangoLog(line, organizationID, serviceName, environment, loc)
 	case "nginx":
 		return ParseNginxLog(line, organizationID, serviceName, environment)
 	case "apache":
 		return ParseApacheLog(line, organizationID, serviceName, environment)
 	case "json":
-		return ParseJSONLog(line, organizationID, serviceName, environment)
+		return parseJSONLog(line, organizationID, serviceName, environment, loc)
 	case "docker":
-		return ParseDockerLog(line, organizationID, serviceName, environment)
+		return parseDockerLog(line, organizationID, serviceName, environment, loc)
+	case "cri":
+		return parseCRILog(line, organizationID, serviceName, environment, loc)
+	case "gelf":
+		return parseGELF(line, organizationID, serviceName, environment, loc)
+	case "postgres":
+		return parsePostgresLog(line, organizationID, serviceName, environment, loc)
+	case "mysql_slow":
+		return parseMySQLSlowLog(line, organizationID, serviceName, environment, loc)
+	case "alb":
+		return ParseALBLog(line, organizationID, serviceName, environment)
+	case "cloudflare":
+		return parseCloudflareLog(line, organizationID, serviceName, environment, loc)
+	case "w3c":
+		return parseW3CLog(line, defaultW3CFields, organizationID, serviceName, environment, loc)
 	default:
 		// Generic log
 		return &buffer.Event{
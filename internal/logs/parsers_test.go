@@ -2,6 +2,7 @@ package logs
 
 import (
 	"testing"
+	"time"
 )
 
 func TestParseDjangoLogValid(t *testing.T) {
@@ -147,6 +148,29 @@ func TestParseNginxLogValid(t *testing.T) {
 	}
 }
 
+func TestParseApacheLogResponseTime(t *testing.T) {
+	cases := []struct {
+		line string
+		want float64
+	}{
+		{`10.0.0.1 - - [26/Oct/2024:10:30:15 +0000] "POST /api/orders HTTP/1.1" 201 567 "https://example.com" "curl/7.64.1" 48250`, 48.25},
+		{`10.0.0.1 - - [26/Oct/2024:10:30:15 +0000] "GET /health HTTP/1.1" 200 2 1500`, 1.5},
+		{`10.0.0.1 - - [26/Oct/2024:10:30:15 +0000] "POST /api/orders HTTP/1.1" 201 567 "https://example.com" "curl/7.64.1"`, 0},
+	}
+	for _, tc := range cases {
+		event := ParseApacheLog(tc.line, "org_test123", "apache", "production")
+		if event == nil {
+			t.Fatalf("ParseApacheLog returned nil for %q", tc.line)
+		}
+		if (*event)["duration_ms"] != tc.want {
+			t.Errorf("Expected duration_ms %v, got %v", tc.want, (*event)["duration_ms"])
+		}
+		if (*event)["status_code"] == 0 {
+			t.Errorf("Expected status_code to be parsed, got %v", (*event)["status_code"])
+		}
+	}
+}
+
 func TestParseNginxLogInvalid(t *testing.T) {
 	line := "This is not a valid Nginx log line"
 	organizationID := "org_test123"
@@ -267,3 +291,232 @@ func TestMapLogLevel(t *testing.T) {
 		})
 	}
 }
+
+func TestParseTimestampLayouts(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("tzdata unavailable: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		raw      string
+		loc      *time.Location
+		expected string
+	}{
+		{"rfc3339", "2025-10-26T09:15:23Z", berlin, "2025-10-26T09:15:23Z"},
+		{"rfc3339 nano offset", "2025-10-26T09:15:23.123456+02:00", time.UTC, "2025-10-26T07:15:23.123456Z"},
+		{"compact offset", "2025-10-26T09:15:23+0100", time.UTC, "2025-10-26T08:15:23Z"},
+		{"naive utc", "2025-10-26 09:15:23", time.UTC, "2025-10-26T09:15:23Z"},
+		{"naive local", "2025-10-26 09:15:23", berlin, "2025-10-26T08:15:23Z"},
+		{"naive local summer", "2025-07-01 09:15:23", berlin, "2025-07-01T07:15:23Z"},
+		{"naive iso", "2025-07-01T09:15:23.5", berlin, "2025-07-01T07:15:23.5Z"},
+		{"django comma millis", "2025-07-01 09:15:23,250", berlin, "2025-07-01T07:15:23.25Z"},
+		{"access log", "26/Oct/2025:09:15:23 -0700", berlin, "2025-10-26T16:15:23Z"},
+		{"rfc1123z", "Sun, 26 Oct 2025 09:15:23 +0000", berlin, "2025-10-26T09:15:23Z"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, ok := parseTimestamp(tt.raw, tt.loc)
+			if !ok {
+				t.Fatalf("parseTimestamp(%q) failed", tt.raw)
+			}
+			if got := parsed.Format(time.RFC3339Nano); got != tt.expected {
+				t.Errorf("parseTimestamp(%q) = %s, expected %s", tt.raw, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseLogInLocation(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skipf("tzdata unavailable: %v", err)
+	}
+
+	line := "[2025-10-26 09:15:23,123] ERROR [django.request] boom"
+	event := ParseLogInLocation(line, "django", "org", "svc", "prod", tokyo)
+	if event == nil {
+		t.Fatal("Expected event, got nil")
+	}
+	if (*event)["timestamp"] != "2025-10-26T00:15:23.123Z" {
+		t.Errorf("Expected Tokyo wall time converted to UTC, got %v", (*event)["timestamp"])
+	}
+
+	jsonLine := `{"level":"info","msg":"hi","time":"2025-10-26 09:15:23"}`
+	event = ParseLogInLocation(jsonLine, "json", "org", "svc", "prod", tokyo)
+	if (*event)["timestamp"] != "2025-10-26T00:15:23Z" {
+		t.Errorf("Expected JSON naive time in Tokyo, got %v", (*event)["timestamp"])
+	}
+
+	// Default ParseLog keeps the UTC interpretation.
+	event = ParseLog(jsonLine, "json", "org", "svc", "prod")
+	if (*event)["timestamp"] != "2025-10-26T09:15:23Z" {
+		t.Errorf("Expected UTC interpretation, got %v", (*event)["timestamp"])
+	}
+}
+
+func TestParseJSONLogEpochTimestamp(t *testing.T) {
+	for _, line := range []string{
+		`{"msg":"seconds","ts":1761470123.5}`,
+		`{"msg":"millis","ts":1761470123500}`,
+	} {
+		event := ParseJSONLog(line, "org", "svc", "prod")
+		if (*event)["timestamp"] != "2025-10-26T09:15:23.5Z" {
+			t.Errorf("Expected epoch timestamp, got %v for %s", (*event)["timestamp"], line)
+		}
+	}
+}
+
+func TestParseJSONLogFlattensNestedObjects(t *testing.T) {
+	line := `{"msg":"request failed","http":{"status":500,"method":"POST","route":{"name":"checkout","params":{"id":7}}},"user":{"ids":[1,2],"admin":false},"trace":null}`
+
+	event := ParseJSONLog(line, "org", "svc", "prod")
+	tags := (*event)["tags"].(map[string]string)
+	want := map[string]string{
+		"http.status":       "500",
+		"http.method":       "POST",
+		"http.route.name":   "checkout",
+		"http.route.params": `{"id":7}`,
+		"user.ids":          "[1,2]",
+		"user.admin":        "false",
+	}
+	for key, value := range want {
+		if tags[key] != value {
+			t.Errorf("Expected %s=%s, got %q", key, value, tags[key])
+		}
+	}
+	if len(tags) != len(want) {
+		t.Errorf("Expected %d tags, got %v", len(want), tags)
+	}
+
+	SetJSONDepth(1)
+	defer SetJSONDepth(0)
+	event = ParseJSONLog(line, "org", "svc", "prod")
+	tags = (*event)["tags"].(map[string]string)
+	if tags["http"] != `{"method":"POST","route":{"name":"checkout","params":{"id":7}},"status":500}` {
+		t.Errorf("Expected http kept as JSON at depth 1, got %q", tags["http"])
+	}
+	if _, ok := tags["http.status"]; ok {
+		t.Error("Did not expect http.status at depth 1")
+	}
+}
+
+func TestOriginalTimestampPreservedOnFallback(t *testing.T) {
+	event := ParseJSONLog(`{"msg":"odd","timestamp":"yesterday-ish"}`, "org", "svc", "prod")
+	tags, ok := (*event)["tags"].(map[string]string)
+	if !ok {
+		t.Fatal("Expected tags on event")
+	}
+	if tags["original_timestamp"] != "yesterday-ish" {
+		t.Errorf("Expected original_timestamp tag, got %q", tags["original_timestamp"])
+	}
+
+	event = ParseNginxLog(`10.0.0.1 - - [99/Foo/2025:09:15:23 +0000] "GET / HTTP/1.1" 200 12`, "org", "svc", "prod")
+	if event == nil {
+		t.Fatal("Expected event, got nil")
+	}
+	tags = (*event)["tags"].(map[string]string)
+	if tags["original_timestamp"] != "99/Foo/2025:09:15:23 +0000" {
+		t.Errorf("Expected original_timestamp tag on access log, got %q", tags["original_timestamp"])
+	}
+
+	event = ParseJSONLog(`{"msg":"ok","timestamp":"2025-10-26T09:15:23Z"}`, "org", "svc", "prod")
+	if tags, ok := (*event)["tags"].(map[string]string); ok {
+		if _, exists := tags["original_timestamp"]; exists {
+			t.Error("Did not expect original_timestamp when parsing succeeds")
+		}
+	}
+}
+
+func TestParseGELF(t *testing.T) {
+	line := `{"version":"1.1","host":"web-1","short_message":"Payment failed","full_message":"Traceback: boom","timestamp":1761470123.5,"level":3,"_user_id":42,"_region":"eu","_debug":true}`
+	event := ParseLog(line, "gelf", "org", "svc", "prod")
+	if event == nil {
+		t.Fatal("Expected event, got nil")
+	}
+
+	if (*event)["message"] != "Payment failed" {
+		t.Errorf("Expected short_message as message, got %v", (*event)["message"])
+	}
+	if (*event)["stacktrace"] != "Traceback: boom" {
+		t.Errorf("Expected full_message as stacktrace, got %v", (*event)["stacktrace"])
+	}
+	if (*event)["level"] != "error" {
+		t.Errorf("Expected level error, got %v", (*event)["level"])
+	}
+	if (*event)["timestamp"] != "2025-10-26T09:15:23.5Z" {
+		t.Errorf("Expected GELF timestamp, got %v", (*event)["timestamp"])
+	}
+
+	tags := (*event)["tags"].(map[string]string)
+	expected := map[string]string{"host": "web-1", "user_id": "42", "region": "eu", "debug": "true"}
+	for k, v := range expected {
+		if tags[k] != v {
+			t.Errorf("Expected tag %s=%s, got %s", k, v, tags[k])
+		}
+	}
+	if _, ok := tags["version"]; ok {
+		t.Error("Did not expect version to be copied into tags")
+	}
+
+	event = ParseLog(`{"version":"1.1","host":"web-1","short_message":"Disk failing","level":2}`, "gelf", "org", "svc", "prod")
+	if (*event)["level"] != "critical" {
+		t.Errorf("Expected level critical for GELF level 2, got %v", (*event)["level"])
+	}
+}
+
+func TestMapSyslogSeverity(t *testing.T) {
+	tests := map[int]string{0: "critical", 1: "critical", 2: "critical", 3: "error", 4: "warning", 5: "info", 6: "info", 7: "debug"}
+	for input, expected := range tests {
+		if got := mapSyslogSeverity(input); got != expected {
+			t.Errorf("mapSyslogSeverity(%d) = %s, expected %s", input, got, expected)
+		}
+	}
+}
+
+func TestExtractKeyValues(t *testing.T) {
+	tests := []struct {
+		name     string
+		message  string
+		expected map[string]string
+	}{
+		{"bare pairs", "Order created request_id=abc-123 user=42", map[string]string{"request_id": "abc-123", "user": "42"}},
+		{"quoted values", `Login failed user="jane doe" reason='bad password'`, map[string]string{"user": "jane doe", "reason": "bad password"}},
+		{"trailing punctuation", "Checkout done order_id=991, total=12.50; ok", map[string]string{"order_id": "991", "total": "12.50"}},
+		{"no pairs", "Internal server error: /api/orders", nil},
+		{"ignores urls", "GET /search?q=shoes took 12ms", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := extractKeyValues(tt.message)
+			if len(got) != len(tt.expected) {
+				t.Fatalf("extractKeyValues(%q) = %v, expected %v", tt.message, got, tt.expected)
+			}
+			for k, v := range tt.expected {
+				if got[k] != v {
+					t.Errorf("Expected %s=%q, got %q", k, v, got[k])
+				}
+			}
+		})
+	}
+}
+
+func TestPromoteKeyValuesDjango(t *testing.T) {
+	line := "[2024-10-26 10:30:15,123] INFO [orders.views] Order created request_id=abc-123 logger=spoofed"
+	event := ParseDjangoLog(line, "org", "svc", "prod")
+	promoteKeyValues(event)
+
+	if (*event)["message"] != "Order created request_id=abc-123 logger=spoofed" {
+		t.Errorf("Expected message to be preserved, got %v", (*event)["message"])
+	}
+	tags := (*event)["tags"].(map[string]string)
+	if tags["request_id"] != "abc-123" {
+		t.Errorf("Expected request_id tag, got %q", tags["request_id"])
+	}
+	if tags["logger"] != "orders.views" {
+		t.Errorf("Expected parsed logger tag to win, got %q", tags["logger"])
+	}
+}
@@ -0,0 +1,65 @@
+package logs
+
+import (
+	"bufio"
+	"os"
+	"time"
+
+	"github.com/hpcloud/tail"
+)
+
+// pipeReopenDelay paces reopening a named pipe after a writer that sent
+// nothing disconnects, so a writer that opens and closes in a loop does not
+// spin the reader.
+const pipeReopenDelay = 100 * time.Millisecond
+
+// isNamedPipe reports whether path is a FIFO.
+func isNamedPipe(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode()&os.ModeNamedPipe != 0
+}
+
+// startPipe reads a named pipe instead of tailing it. There is nothing to
+// seek or backfill: each writer's lines are read as they arrive, and the pipe
+// is reopened for the next writer once the current one closes it.
+func (t *Tailer) startPipe() error {
+	tailerLogger.Infof("Started reading named pipe %s (format: %s)", t.path, t.format)
+	t.rotation = &rotationMonitor{path: t.path, pipe: true}
+	if t.backfill != nil {
+		tailerLogger.Infof("Skipping backfill for named pipe %s", t.path)
+	}
+
+	lines := make(chan *tail.Line)
+	go t.readPipe(lines)
+	go t.run(lines)
+	return nil
+}
+
+// readPipe sends every line of the pipe to lines, reopening it on EOF.
+// Opening blocks until a writer connects.
+func (t *Tailer) readPipe(lines chan<- *tail.Line) {
+	for {
+		f, err := os.Open(t.path)
+		if err != nil {
+			lines <- &tail.Line{Err: err, Time: time.Now()}
+			time.Sleep(time.Second)
+			continue
+		}
+
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		read := 0
+		for scanner.Scan() {
+			lines <- &tail.Line{Text: scanner.Text(), Time: time.Now()}
+			read++
+		}
+		if err := scanner.Err(); err != nil {
+			lines <- &tail.Line{Err: err, Time: time.Now()}
+		}
+		f.Close()
+
+		if read == 0 {
+			time.Sleep(pipeReopenDelay)
+		}
+	}
+}
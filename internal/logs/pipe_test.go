@@ -0,0 +1,56 @@
+//go:build unix
+
+package logs
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+)
+
+func TestTailerReadsNamedPipe(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.pipe")
+	if err := syscall.Mkfifo(path, 0o600); err != nil {
+		t.Skipf("mkfifo unavailable: %v", err)
+	}
+
+	buf := buffer.New(100)
+	tailer := New(path, "json", "org", "svc", "prod", map[string]string{"region": "eu"}, buf)
+	if err := tailer.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	// Two writers in turn: the reader reopens the pipe after the first closes it
+	write := func(lines string) {
+		f, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			t.Errorf("Failed to open pipe: %v", err)
+			return
+		}
+		f.WriteString(lines)
+		f.Close()
+	}
+	go func() {
+		write(`{"msg":"first"}` + "\n" + `{"msg":"second"}` + "\n")
+		write(`{"msg":"third"}` + "\n")
+	}()
+
+	var events []buffer.Event
+	deadline := time.Now().Add(5 * time.Second)
+	for len(events) < 3 && time.Now().Before(deadline) {
+		events = append(events, buf.Flush()...)
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	got := messages(events)
+	if len(got) != 3 || got[0] != "first" || got[2] != "third" {
+		t.Fatalf("Expected the lines of both writers, got %v", got)
+	}
+	if tags, _ := events[0]["tags"].(map[string]string); tags["region"] != "eu" {
+		t.Errorf("Expected global tags, got %v", events[0]["tags"])
+	}
+}
@@ -0,0 +1,138 @@
+package logs
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yaat-app/sidecar/internal/buffer"
+	"github.com/yaat-app/sidecar/internal/diag"
+)
+
+// rotationCheckInterval is how often the tailed file is stat'ed. It is kept
+// close to the tail poll interval so the last observed size is recent when a
+// rotation happens.
+const rotationCheckInterval = 500 * time.Millisecond
+
+// rotationMonitor follows the inode and size of a tailed file alongside the
+// bytes actually consumed, to notice rotations that discarded unread data.
+type rotationMonitor struct {
+	path string
+
+	inode    uint64
+	size     int64 // Size at the previous check
+	consumed int64 // Bytes of the current file handed to the parser
+	lines    int64 // Lines read since the last report to diag
+	pipe     bool  // Named pipes have no size or inode to follow; only lines are counted
+}
+
+// rotation describes a detected truncation or replacement.
+type rotation struct {
+	kind    string // "truncated" or "replaced"
+	skipped int64  // Estimated bytes that were never read
+}
+
+func newRotationMonitor(path string) *rotationMonitor {
+	m := &rotationMonitor{path: path}
+	if inode, size, ok := statFile(path); ok {
+		// Tailing starts at the end of the file
+		m.inode, m.size, m.consumed = inode, size, size
+	}
+	return m
+}
+
+// lineRead accounts for a line delivered by the tailer.
+func (m *rotationMonitor) lineRead(text string) {
+	m.consumed += int64(len(text)) + 1 // Trailing newline
+	m.lines++
+}
+
+// observe compares the current inode and size with the previous check.
+// Unread bytes are estimated from the size seen at that check minus what has
+// been consumed since; data written after it is not visible, so the
+// estimate is a lower bound.
+func (m *rotationMonitor) observe(inode uint64, size int64) *rotation {
+	var kind string
+	switch {
+	case m.inode != 0 && inode != m.inode:
+		kind = "replaced"
+	case size < m.consumed:
+		kind = "truncated"
+	}
+
+	if kind == "" {
+		m.inode = inode
+		if size > m.size {
+			m.size = size
+		}
+		return nil
+	}
+
+	skipped := m.size - m.consumed
+	m.inode = inode
+	m.size = size
+	// The tailer reopens the new file from the start
+	m.consumed = 0
+	if skipped <= 0 {
+		return &rotation{kind: kind}
+	}
+	return &rotation{kind: kind, skipped: skipped}
+}
+
+// check stats the file, reports line counts and returns a rotation that lost data.
+func (m *rotationMonitor) check() *rotation {
+	diag.Global().RecordLinesRead(m.path, m.lines)
+	m.lines = 0
+	if m.pipe {
+		return nil
+	}
+
+	inode, size, ok := statFile(m.path)
+	if !ok {
+		// Missing between rename and recreate; the next check will see the new file
+		return nil
+	}
+	r := m.observe(inode, size)
+	if r == nil {
+		return nil
+	}
+	if r.skipped == 0 {
+		tailerLogger.Infof("%s was %s (no unread data)", m.path, r.kind)
+		return nil
+	}
+
+	tailerLogger.Infof("%s was %s; an estimated %d bytes were skipped", m.path, r.kind, r.skipped)
+	diag.Global().RecordPossibleLoss(m.path, r.skipped)
+	return r
+}
+
+// rotationEvent is the synthetic warning emitted when a rotation lost data.
+func (t *Tailer) rotationEvent(r *rotation) buffer.Event {
+	return buffer.Event{
+		"organization_id": t.organizationID,
+		"service_name":    t.serviceName,
+		"event_id":        uuid.New().String(),
+		"timestamp":       time.Now().UTC().Format(time.RFC3339Nano),
+		"event_type":      "log",
+		"environment":     t.environment,
+		"level":           "warning",
+		"logger":          "yaat.tailer.rotation_detected",
+		"message":         fmt.Sprintf("Log rotation detected on %s (%s); an estimated %d bytes were skipped", t.path, r.kind, r.skipped),
+		"tags": map[string]string{
+			"yaat.sidecar":  "true",
+			"log.path":      t.path,
+			"rotation":      r.kind,
+			"skipped_bytes": strconv.FormatInt(r.skipped, 10),
+		},
+	}
+}
+
+func statFile(path string) (inode uint64, size int64, ok bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, 0, false
+	}
+	return fileInode(info), info.Size(), true
+}
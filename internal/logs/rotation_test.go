@@ -0,0 +1,147 @@
+package logs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yaat-app/sidecar/internal/diag"
+)
+
+func appendLines(t *testing.T, path string, lines ...string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("open %s: %v", path, err)
+	}
+	defer f.Close()
+	for _, line := range lines {
+		if _, err := f.WriteString(line + "\n"); err != nil {
+			t.Fatalf("write %s: %v", path, err)
+		}
+	}
+}
+
+func TestRotationCopyTruncate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	appendLines(t, path, "existing line before tailing")
+
+	m := newRotationMonitor(path)
+	appendLines(t, path, "line one", "line two", "line three")
+
+	// The tailer has read the first new line when the size is sampled
+	m.lineRead("line one")
+	if r := m.check(); r != nil {
+		t.Fatalf("Expected no rotation while the file grows, got %+v", r)
+	}
+
+	// copytruncate: the rest is copied away and the file emptied in place
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+	appendLines(t, path, "after")
+
+	r := m.check()
+	if r == nil {
+		t.Fatal("Expected truncation to be detected")
+	}
+	if r.kind != "truncated" {
+		t.Errorf("Expected kind truncated, got %s", r.kind)
+	}
+	want := int64(len("line two\n") + len("line three\n"))
+	if r.skipped != want {
+		t.Errorf("Expected %d skipped bytes, got %d", want, r.skipped)
+	}
+
+	// Reading continues from the start of the truncated file
+	m.lineRead("after")
+	if r := m.check(); r != nil {
+		t.Errorf("Expected no further rotation, got %+v", r)
+	}
+
+	stats := diag.Global().Snapshot().Sources[path]
+	if stats.PossibleLoss != 1 {
+		t.Errorf("Expected possible_loss 1, got %d", stats.PossibleLoss)
+	}
+	if stats.SkippedBytes != want {
+		t.Errorf("Expected skipped_bytes %d, got %d", want, stats.SkippedBytes)
+	}
+	if stats.LinesRead != 2 {
+		t.Errorf("Expected lines_read 2, got %d", stats.LinesRead)
+	}
+}
+
+func TestRotationFullyReadIsNotLoss(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	appendLines(t, path, "existing")
+
+	m := newRotationMonitor(path)
+	appendLines(t, path, "one", "two")
+	m.lineRead("one")
+	m.lineRead("two")
+	m.check()
+
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+	if r := m.check(); r != nil {
+		t.Errorf("Expected no loss when everything was read, got %+v", r)
+	}
+	if stats := diag.Global().Snapshot().Sources[path]; stats.PossibleLoss != 0 {
+		t.Errorf("Expected possible_loss 0, got %d", stats.PossibleLoss)
+	}
+}
+
+func TestRotationReplacedFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	appendLines(t, path, "existing")
+
+	m := newRotationMonitor(path)
+	appendLines(t, path, "unread")
+	m.check()
+
+	if err := os.Rename(path, filepath.Join(dir, "app.log.1")); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	appendLines(t, path, "fresh")
+
+	r := m.check()
+	if r == nil {
+		t.Fatal("Expected replacement to be detected")
+	}
+	if r.kind != "replaced" {
+		t.Errorf("Expected kind replaced, got %s", r.kind)
+	}
+	if r.skipped != int64(len("unread\n")) {
+		t.Errorf("Expected %d skipped bytes, got %d", len("unread\n"), r.skipped)
+	}
+}
+
+func TestRotationEvent(t *testing.T) {
+	tailer := New("/var/log/app.log", "json", "org", "svc", "prod", map[string]string{"region": "eu"}, nil)
+	event := tailer.rotationEvent(&rotation{kind: "truncated", skipped: 42})
+	tailer.mergeGlobalTags(event)
+
+	if event["logger"] != "yaat.tailer.rotation_detected" {
+		t.Errorf("Expected rotation logger, got %v", event["logger"])
+	}
+	if event["level"] != "warning" {
+		t.Errorf("Expected warning level, got %v", event["level"])
+	}
+	if !strings.Contains(event["message"].(string), "/var/log/app.log") {
+		t.Errorf("Expected path in message, got %v", event["message"])
+	}
+
+	tags := event["tags"].(map[string]string)
+	if tags["log.path"] != "/var/log/app.log" {
+		t.Errorf("Expected log.path tag, got %q", tags["log.path"])
+	}
+	if tags["skipped_bytes"] != "42" {
+		t.Errorf("Expected skipped_bytes 42, got %q", tags["skipped_bytes"])
+	}
+	if tags["region"] != "eu" {
+		t.Errorf("Expected global tag region, got %q", tags["region"])
+	}
+}
@@ -0,0 +1,16 @@
+//go:build unix
+
+package logs
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileInode returns the inode of the file described by info.
+func fileInode(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Ino)
+	}
+	return 0
+}
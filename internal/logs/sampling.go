@@ -0,0 +1,55 @@
+package logs
+
+import (
+	"hash/fnv"
+	"math"
+	"strings"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+)
+
+// levelSampler keeps a fraction of events per level. Levels without a rate,
+// including error and critical unless configured, are always kept.
+type levelSampler struct {
+	rates map[string]float64
+}
+
+func newLevelSampler(rates map[string]float64) *levelSampler {
+	normalized := make(map[string]float64, len(rates))
+	for level, rate := range rates {
+		normalized[strings.ToLower(level)] = rate
+	}
+	return &levelSampler{rates: normalized}
+}
+
+// keep decides by hashing the level and message, so repeats of the same
+// message are either all kept or all dropped.
+func (s *levelSampler) keep(event buffer.Event) bool {
+	level, _ := event["level"].(string)
+	level = strings.ToLower(level)
+	rate, ok := s.rates[level]
+	if !ok || rate >= 1 {
+		return true
+	}
+	if rate <= 0 {
+		return false
+	}
+
+	message, _ := event["message"].(string)
+	h := fnv.New64a()
+	h.Write([]byte(level))
+	h.Write([]byte{0})
+	h.Write([]byte(message))
+	return float64(mix64(h.Sum64()))/float64(math.MaxUint64) < rate
+}
+
+// mix64 spreads FNV's weak high bits (the splitmix64 finalizer); similar
+// messages such as "query 1" and "query 2" otherwise hash close together.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
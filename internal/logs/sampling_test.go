@@ -0,0 +1,46 @@
+package logs
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+)
+
+func TestLevelSamplerRates(t *testing.T) {
+	s := newLevelSampler(map[string]float64{"DEBUG": 0.1, "info": 0})
+
+	kept := 0
+	for i := 0; i < 10000; i++ {
+		if s.keep(buffer.Event{"level": "debug", "message": fmt.Sprintf("query %d", i)}) {
+			kept++
+		}
+	}
+	if kept < 800 || kept > 1200 {
+		t.Errorf("Expected about 10%% of debug events kept, got %d of 10000", kept)
+	}
+
+	if s.keep(buffer.Event{"level": "info", "message": "hello"}) {
+		t.Error("Expected rate 0 to drop every info event")
+	}
+	for _, level := range []string{"error", "critical", "warning"} {
+		if !s.keep(buffer.Event{"level": level, "message": "hello"}) {
+			t.Errorf("Expected unlisted level %s to be kept", level)
+		}
+	}
+}
+
+func TestLevelSamplerIsDeterministic(t *testing.T) {
+	s := newLevelSampler(map[string]float64{"debug": 0.5})
+	for i := 0; i < 100; i++ {
+		event := buffer.Event{"level": "debug", "message": fmt.Sprintf("cache miss %d", i)}
+		first := s.keep(event)
+		for j := 0; j < 3; j++ {
+			// A repeat with a different ID and timestamp samples the same way
+			repeat := buffer.Event{"level": "debug", "message": event["message"], "event_id": fmt.Sprint(j), "timestamp": fmt.Sprint(j)}
+			if s.keep(repeat) != first {
+				t.Fatalf("Expected message %q to sample consistently", event["message"])
+			}
+		}
+	}
+}
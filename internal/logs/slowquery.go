@@ -0,0 +1,365 @@
+package logs
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yaat-app/sidecar/internal/buffer"
+)
+
+// PostgreSQL log line prefix: timestamp with optional zone, e.g.
+// 2024-10-26 10:30:15.123 UTC [12345] app@shop LOG:  duration: 1234.567 ms  statement: SELECT ...
+var postgresPrefixRegex = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}(?:\.\d+)?)(?: ([A-Za-z]{2,5}|[+-]\d{2}(?::?\d{2})?))?`)
+
+var postgresSeverityRegex = regexp.MustCompile(`\b(LOG|WARNING|ERROR|FATAL|PANIC|NOTICE|INFO|DEBUG\d?|STATEMENT|DETAIL|HINT|CONTEXT):\s+`)
+
+var postgresDurationRegex = regexp.MustCompile(`(?s)^duration: ([\d.]+) ms(?:\s+(?:statement|(?:execute|parse|bind) [^:]*): (.*))?$`)
+
+var postgresUserDBRegex = regexp.MustCompile(`\s([\w.-]+)@([\w.-]+)\s*$`)
+
+var postgresKeyValueRegex = regexp.MustCompile(`\b(user|db|app|client)=([^\s,\]]+)`)
+
+var mysqlQueryTimeRegex = regexp.MustCompile(`Query_time:\s*([\d.]+)(?:\s+Lock_time:\s*([\d.]+))?(?:\s+Rows_sent:\s*(\d+))?(?:\s+Rows_examined:\s*(\d+))?`)
+
+var mysqlUserHostRegex = regexp.MustCompile(`^# User@Host:\s*([^\[\s]*)\[[^\]]*\]\s*@\s*(\S*)\s*(?:\[([^\]]*)\])?`)
+
+var mysqlUseRegex = regexp.MustCompile(`(?i)^use\s+` + "`?" + `([\w$-]+)` + "`?" + `;?$`)
+
+var mysqlSetTimestampRegex = regexp.MustCompile(`(?i)^SET timestamp=(\d+);?$`)
+
+var sqlTableRegex = regexp.MustCompile("(?is)\\b(?:FROM|INTO|UPDATE|JOIN|TABLE)\\s+((?:[\\w$]+|`[^`]+`|\"[^\"]+\")(?:\\.(?:[\\w$]+|`[^`]+`|\"[^\"]+\"))?)")
+
+// ParsePostgresLog parses a (possibly multi-line) PostgreSQL log entry.
+// Entries reporting a statement duration become span events; other entries are logs.
+func ParsePostgresLog(entry, organizationID, serviceName, environment string) *buffer.Event {
+	return parsePostgresLog(entry, organizationID, serviceName, environment, time.UTC)
+}
+
+func parsePostgresLog(entry, organizationID, serviceName, environment string, loc *time.Location) *buffer.Event {
+	entry = strings.TrimRight(entry, "\r\n")
+	prefix := postgresPrefixRegex.FindStringSubmatch(entry)
+	sev := postgresSeverityRegex.FindStringSubmatchIndex(entry)
+	if prefix == nil || sev == nil {
+		return nil
+	}
+
+	// Timestamp (server zone names other than UTC are read in the configured location)
+	rawTimestamp := prefix[1]
+	zone := prefix[2]
+	tsLoc := loc
+	switch {
+	case strings.EqualFold(zone, "UTC") || strings.EqualFold(zone, "GMT"):
+		tsLoc = time.UTC
+	case strings.HasPrefix(zone, "+") || strings.HasPrefix(zone, "-"):
+		rawTimestamp += " " + normalizeZoneOffset(zone)
+	}
+	timestamp, parsed := parseTimestamp(rawTimestamp, tsLoc)
+	if !parsed {
+		timestamp = time.Now().UTC()
+	}
+
+	tags := map[string]string{"db.system": "postgresql"}
+	header := entry[len(prefix[0]):sev[0]]
+	for _, kv := range postgresKeyValueRegex.FindAllStringSubmatch(header, -1) {
+		switch kv[1] {
+		case "user":
+			tags["db.user"] = kv[2]
+		case "db":
+			tags["db.name"] = kv[2]
+		case "app":
+			tags["db.application"] = kv[2]
+		case "client":
+			tags["client_ip"] = kv[2]
+		}
+	}
+	if m := postgresUserDBRegex.FindStringSubmatch(header); m != nil {
+		if _, ok := tags["db.user"]; !ok {
+			tags["db.user"] = m[1]
+		}
+		if _, ok := tags["db.name"]; !ok {
+			tags["db.name"] = m[2]
+		}
+	}
+
+	severity := entry[sev[2]:sev[3]]
+	body := strings.TrimSpace(entry[sev[1]:])
+
+	var event *buffer.Event
+	if m := postgresDurationRegex.FindStringSubmatch(body); m != nil && m[2] != "" {
+		durationMs, _ := strconv.ParseFloat(m[1], 64)
+		statement := strings.TrimSpace(m[2])
+		event = newQuerySpan(organizationID, serviceName, environment, timestamp, statement, durationMs, tags)
+	} else {
+		event = &buffer.Event{
+			"organization_id": organizationID,
+			"service_name":    serviceName,
+			"event_id":        uuid.New().String(),
+			"timestamp":       timestamp.Format(time.RFC3339Nano),
+			"event_type":      "log",
+			"environment":     environment,
+			"level":           mapPostgresSeverity(severity),
+			"message":         body,
+			"stacktrace":      "",
+			"tags":            tags,
+		}
+	}
+	if !parsed {
+		preserveOriginalTimestamp(event, prefix[0])
+	}
+
+	return event
+}
+
+// ParseMySQLSlowLog parses a MySQL slow query log block
+// (# Time / # User@Host / # Query_time header lines followed by the SQL).
+func ParseMySQLSlowLog(entry, organizationID, serviceName, environment string) *buffer.Event {
+	return parseMySQLSlowLog(entry, organizationID, serviceName, environment, time.UTC)
+}
+
+func parseMySQLSlowLog(entry, organizationID, serviceName, environment string, loc *time.Location) *buffer.Event {
+	tags := map[string]string{"db.system": "mysql"}
+	var (
+		rawTimestamp string
+		timestamp    time.Time
+		haveTime     bool
+		durationMs   float64
+		haveDuration bool
+		statement    []string
+	)
+
+	for _, line := range strings.Split(entry, "\n") {
+		line = strings.TrimRight(line, "\r")
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "" || isMySQLServerBanner(trimmed):
+			continue
+		case strings.HasPrefix(trimmed, "# Time:"):
+			rawTimestamp = strings.TrimSpace(strings.TrimPrefix(trimmed, "# Time:"))
+			timestamp, haveTime = parseMySQLTime(rawTimestamp, loc)
+		case strings.HasPrefix(trimmed, "# User@Host:"):
+			if m := mysqlUserHostRegex.FindStringSubmatch(trimmed); m != nil {
+				if m[1] != "" {
+					tags["db.user"] = m[1]
+				}
+				if m[2] != "" {
+					tags["db.host"] = m[2]
+				}
+				if m[3] != "" {
+					tags["client_ip"] = m[3]
+				}
+			}
+		case strings.HasPrefix(trimmed, "# Schema:"):
+			if fields := strings.Fields(strings.TrimPrefix(trimmed, "# Schema:")); len(fields) > 0 {
+				tags["db.name"] = fields[0]
+			}
+			fallthrough
+		case strings.HasPrefix(trimmed, "#"):
+			if m := mysqlQueryTimeRegex.FindStringSubmatch(trimmed); m != nil {
+				if seconds, err := strconv.ParseFloat(m[1], 64); err == nil {
+					durationMs = seconds * 1000
+					haveDuration = true
+				}
+				if m[2] != "" {
+					tags["lock_time"] = m[2]
+				}
+				if m[3] != "" {
+					tags["rows_sent"] = m[3]
+				}
+				if m[4] != "" {
+					tags["rows_examined"] = m[4]
+				}
+			}
+		case mysqlUseRegex.MatchString(trimmed) && len(statement) == 0:
+			tags["db.name"] = mysqlUseRegex.FindStringSubmatch(trimmed)[1]
+		case mysqlSetTimestampRegex.MatchString(trimmed) && len(statement) == 0:
+			if !haveTime {
+				secs, _ := strconv.ParseInt(mysqlSetTimestampRegex.FindStringSubmatch(trimmed)[1], 10, 64)
+				timestamp = time.Unix(secs, 0).UTC()
+				haveTime = true
+			}
+		default:
+			statement = append(statement, line)
+		}
+	}
+
+	if !haveDuration || len(statement) == 0 {
+		return nil
+	}
+
+	parsed := haveTime
+	if !parsed {
+		timestamp = time.Now().UTC()
+	}
+
+	event := newQuerySpan(organizationID, serviceName, environment, timestamp, strings.TrimSpace(strings.Join(statement, "\n")), durationMs, tags)
+	if !parsed {
+		preserveOriginalTimestamp(event, rawTimestamp)
+	}
+
+	return event
+}
+
+// isMySQLServerBanner matches the header mysqld writes when (re)opening the slow log.
+func isMySQLServerBanner(line string) bool {
+	return strings.Contains(line, ", Version: ") ||
+		strings.HasPrefix(line, "Tcp port:") ||
+		(strings.HasPrefix(line, "Time ") && strings.Contains(line, "Command"))
+}
+
+func newQuerySpan(organizationID, serviceName, environment string, timestamp time.Time, statement string, durationMs float64, tags map[string]string) *buffer.Event {
+	return &buffer.Event{
+		"organization_id": organizationID,
+		"service_name":    serviceName,
+		"event_id":        uuid.New().String(),
+		"timestamp":       timestamp.Format(time.RFC3339Nano),
+		"event_type":      "span",
+		"environment":     environment,
+		"trace_id":        uuid.New().String(),
+		"span_id":         uuid.New().String(),
+		"parent_span_id":  "",
+		"operation":       normalizeStatement(statement),
+		"duration_ms":     durationMs,
+		"message":         statement,
+		"tags":            tags,
+	}
+}
+
+// normalizeStatement reduces a SQL statement to its leading keyword and,
+// when derivable, the first table it touches (e.g. "SELECT users").
+func normalizeStatement(statement string) string {
+	sql := stripLeadingSQLComments(statement)
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return "QUERY"
+	}
+	keyword := strings.ToUpper(strings.TrimRight(fields[0], ";("))
+
+	switch keyword {
+	case "SELECT", "INSERT", "UPDATE", "DELETE", "REPLACE", "WITH", "CREATE", "ALTER", "DROP", "TRUNCATE":
+		if m := sqlTableRegex.FindStringSubmatch(sql); m != nil {
+			table := strings.NewReplacer("`", "", `"`, "").Replace(m[1])
+			return keyword + " " + table
+		}
+	}
+	return keyword
+}
+
+func stripLeadingSQLComments(sql string) string {
+	for {
+		sql = strings.TrimSpace(sql)
+		switch {
+		case strings.HasPrefix(sql, "/*"):
+			end := strings.Index(sql, "*/")
+			if end < 0 {
+				return ""
+			}
+			sql = sql[end+2:]
+		case strings.HasPrefix(sql, "--"):
+			end := strings.Index(sql, "\n")
+			if end < 0 {
+				return ""
+			}
+			sql = sql[end+1:]
+		default:
+			return sql
+		}
+	}
+}
+
+// parseMySQLTime accepts both the 5.7+ ISO form and the legacy "yymmdd hh:mm:ss" form.
+func parseMySQLTime(raw string, loc *time.Location) (time.Time, bool) {
+	if t, ok := parseTimestamp(raw, loc); ok {
+		return t, true
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+	if t, err := time.ParseInLocation("060102 15:04:05", strings.Join(strings.Fields(raw), " "), loc); err == nil {
+		return t.UTC(), true
+	}
+	return time.Time{}, false
+}
+
+// normalizeZoneOffset turns "+02" or "+05:30" into "+0200" / "+0530".
+func normalizeZoneOffset(zone string) string {
+	digits := strings.ReplaceAll(zone[1:], ":", "")
+	if len(digits) == 2 {
+		digits += "00"
+	}
+	if len(digits) != 4 {
+		return zone
+	}
+	return zone[:1] + digits
+}
+
+// mapPostgresSeverity maps PostgreSQL message severities to standard levels
+func mapPostgresSeverity(severity string) string {
+	switch {
+	case severity == "PANIC" || severity == "FATAL":
+		return "critical"
+	case severity == "ERROR":
+		return "error"
+	case severity == "WARNING":
+		return "warning"
+	case strings.HasPrefix(severity, "DEBUG"):
+		return "debug"
+	default:
+		return "info"
+	}
+}
+
+// statementAccumulator groups physical lines of a database log into entries.
+// PostgreSQL continues a statement on indented lines; MySQL slow log blocks
+// start with a "# Time:" or "# User@Host:" header.
+type statementAccumulator struct {
+	format  string
+	pending []string
+}
+
+// Add consumes a line and returns a completed entry when this line starts a new one.
+func (a *statementAccumulator) Add(line string) (string, bool) {
+	if !a.startsEntry(line) {
+		if len(a.pending) > 0 {
+			a.pending = append(a.pending, line)
+		}
+		return "", false
+	}
+
+	entry, ok := a.Flush()
+	a.pending = []string{line}
+	return entry, ok
+}
+
+// Flush returns the entry accumulated so far, if any.
+func (a *statementAccumulator) Flush() (string, bool) {
+	if len(a.pending) == 0 {
+		return "", false
+	}
+	entry := strings.Join(a.pending, "\n")
+	a.pending = nil
+	return entry, true
+}
+
+func (a *statementAccumulator) startsEntry(line string) bool {
+	switch a.format {
+	case "postgres":
+		return line != "" && line[0] != ' ' && line[0] != '\t'
+	case "mysql_slow":
+		if strings.HasPrefix(line, "# Time:") {
+			return true
+		}
+		if strings.HasPrefix(line, "# User@Host:") {
+			// A "# Time:" header directly before belongs to the same block
+			return len(a.pending) == 0 || !strings.HasPrefix(a.pending[len(a.pending)-1], "# Time:")
+		}
+	}
+	return false
+}
+
+// isStatementFormat reports whether format needs multi-line statement accumulation.
+func isStatementFormat(format string) bool {
+	return format == "postgres" || format == "mysql_slow"
+}
@@ -0,0 +1,145 @@
+package logs
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+)
+
+// readFixtureEntries runs a fixture through the tailer's statement accumulator
+// and parses every completed entry.
+func readFixtureEntries(t *testing.T, path, format string) []*buffer.Event {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+
+	acc := &statementAccumulator{format: format}
+	var events []*buffer.Event
+	emit := func(entry string) {
+		if event := ParseLog(entry, format, "org", "svc", "prod"); event != nil {
+			events = append(events, event)
+		}
+	}
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		if entry, ok := acc.Add(line); ok {
+			emit(entry)
+		}
+	}
+	if entry, ok := acc.Flush(); ok {
+		emit(entry)
+	}
+	return events
+}
+
+func TestParsePostgresFixture(t *testing.T) {
+	events := readFixtureEntries(t, "testdata/postgres.log", "postgres")
+	if len(events) != 4 {
+		t.Fatalf("Expected 4 events, got %d", len(events))
+	}
+
+	slow := *events[0]
+	if slow["event_type"] != "span" {
+		t.Errorf("Expected span, got %v", slow["event_type"])
+	}
+	if slow["duration_ms"] != 1234.567 {
+		t.Errorf("Expected duration 1234.567, got %v", slow["duration_ms"])
+	}
+	if slow["operation"] != "SELECT orders" {
+		t.Errorf("Expected operation 'SELECT orders', got %v", slow["operation"])
+	}
+	if msg := slow["message"].(string); !strings.Contains(msg, "WHERE o.customer_id = 42;") {
+		t.Errorf("Expected multi-line statement in message, got %q", msg)
+	}
+	if slow["timestamp"] != "2024-10-26T10:30:15.123Z" {
+		t.Errorf("Expected UTC timestamp, got %v", slow["timestamp"])
+	}
+	tags := slow["tags"].(map[string]string)
+	if tags["db.user"] != "app" || tags["db.name"] != "shop" {
+		t.Errorf("Expected user/db tags, got %v", tags)
+	}
+
+	checkpoint := *events[1]
+	if checkpoint["event_type"] != "log" || checkpoint["level"] != "info" {
+		t.Errorf("Expected info log for checkpoint, got %v/%v", checkpoint["event_type"], checkpoint["level"])
+	}
+
+	execute := *events[2]
+	if execute["operation"] != "UPDATE public.accounts" {
+		t.Errorf("Expected operation 'UPDATE public.accounts', got %v", execute["operation"])
+	}
+	if execute["timestamp"] != "2024-10-26T10:30:17.5Z" {
+		t.Errorf("Expected offset-adjusted timestamp, got %v", execute["timestamp"])
+	}
+	tags = execute["tags"].(map[string]string)
+	if tags["db.user"] != "report" || tags["db.name"] != "analytics" || tags["client_ip"] != "10.0.0.5" {
+		t.Errorf("Expected key=value prefix tags, got %v", tags)
+	}
+
+	if (*events[3])["level"] != "error" {
+		t.Errorf("Expected error level, got %v", (*events[3])["level"])
+	}
+}
+
+func TestParseMySQLSlowFixture(t *testing.T) {
+	events := readFixtureEntries(t, "testdata/mysql_slow.log", "mysql_slow")
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 events, got %d", len(events))
+	}
+
+	first := *events[0]
+	if first["event_type"] != "span" {
+		t.Errorf("Expected span, got %v", first["event_type"])
+	}
+	if first["operation"] != "SELECT orders" {
+		t.Errorf("Expected operation 'SELECT orders', got %v", first["operation"])
+	}
+	if first["duration_ms"] != 1234.567 {
+		t.Errorf("Expected duration 1234.567, got %v", first["duration_ms"])
+	}
+	if first["timestamp"] != "2024-10-26T10:30:15.123456Z" {
+		t.Errorf("Expected # Time timestamp, got %v", first["timestamp"])
+	}
+	if msg := first["message"].(string); !strings.HasPrefix(msg, "SELECT *") || !strings.Contains(msg, "'pending'") {
+		t.Errorf("Expected full SQL in message, got %q", msg)
+	}
+	tags := first["tags"].(map[string]string)
+	if tags["db.user"] != "app" || tags["db.name"] != "shop" || tags["rows_examined"] != "100000" {
+		t.Errorf("Unexpected tags: %v", tags)
+	}
+
+	second := *events[1]
+	if second["operation"] != "DELETE sessions" {
+		t.Errorf("Expected operation 'DELETE sessions', got %v", second["operation"])
+	}
+	if second["timestamp"] != "2024-10-26T10:30:20Z" {
+		t.Errorf("Expected SET timestamp fallback, got %v", second["timestamp"])
+	}
+	if second["tags"].(map[string]string)["client_ip"] != "10.0.0.9" {
+		t.Errorf("Expected client_ip tag, got %v", second["tags"])
+	}
+}
+
+func TestNormalizeStatement(t *testing.T) {
+	tests := []struct {
+		sql      string
+		expected string
+	}{
+		{"select * from users where id = 1", "SELECT users"},
+		{"/* app:web */ INSERT INTO audit_log (a) VALUES (1)", "INSERT audit_log"},
+		{"update `shop`.`orders` set x = 1", "UPDATE shop.orders"},
+		{"BEGIN", "BEGIN"},
+		{"SELECT 1", "SELECT"},
+		{"", "QUERY"},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeStatement(tt.sql); got != tt.expected {
+			t.Errorf("normalizeStatement(%q) = %q, expected %q", tt.sql, got, tt.expected)
+		}
+	}
+}
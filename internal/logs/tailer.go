@@ -1,14 +1,21 @@
 package logs
 
 import (
-	"log"
 	"strings"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/hpcloud/tail"
 	"github.com/yaat-app/sidecar/internal/buffer"
+	"github.com/yaat-app/sidecar/internal/correlate"
+	"github.com/yaat-app/sidecar/internal/diag"
+	"github.com/yaat-app/sidecar/internal/logging"
 	"github.com/yaat-app/sidecar/internal/scrubber"
+	"github.com/yaat-app/sidecar/internal/spansample"
 )
 
+var tailerLogger = logging.New("Tailer")
+
 // Tailer tails a log file and parses lines
 type Tailer struct {
 	path           string
@@ -18,16 +25,67 @@ type Tailer struct {
 	environment    string
 	globalTags     map[string]string
 	buffer         *buffer.Buffer
+	location       *time.Location
+	extractKV      bool
 
 	// Multi-line tracking for stack traces
 	inTraceback    bool
 	tracebackLines []string
 	lastErrorEvent *buffer.Event
+
+	// Multi-line statement tracking for database logs
+	statements *statementAccumulator
+
+	// Partial line reassembly for CRI container logs
+	partials *criReassembler
+
+	// Optional ingestion of rotated files at startup
+	backfill *BackfillOptions
+
+	// Detects rotations that discarded unread lines
+	rotation *rotationMonitor
+
+	// Optional collapsing of repeated messages
+	dedupe *deduper
+
+	// Optional per-level sampling
+	sampler *levelSampler
+
+	// Optional sampling of access-log spans, with sampled-out counts
+	// emitted every spanCountInterval when it is set
+	spanSampler       *spansample.Sampler
+	spanCountInterval time.Duration
+
+	// Per-line format detection for format "auto"
+	sniffer *formatSniffer
+
+	// Column names from the last #Fields directive for format "w3c"
+	w3cFields []string
+
+	// Optional request ID -> proxy span lookup
+	correlator *correlate.Cache
+
+	// Lines that fail to parse, for spotting a wrong format
+	parseFailures parseFailures
+
+	// How long to wait for room in a full buffer; zero never waits
+	backpressure time.Duration
+	backedUp     bool // The last wait ran out, already logged
 }
 
+// DefaultBackpressure is how long the daemon's tailers wait per event for
+// room in a full buffer. Each flush empties the buffer, even when delivery
+// fails and the events go to the persistent queue, so waits are normally
+// short.
+const DefaultBackpressure = 5 * time.Second
+
+// statementIdleFlush is how long a pending database log entry waits for
+// continuation lines before it is emitted.
+const statementIdleFlush = 2 * time.Second
+
 // New creates a new Tailer
 func New(path, format, organizationID, serviceName, environment string, globalTags map[string]string, buf *buffer.Buffer) *Tailer {
-	return &Tailer{
+	t := &Tailer{
 		path:           path,
 		format:         format,
 		organizationID: organizationID,
@@ -35,11 +93,95 @@ func New(path, format, organizationID, serviceName, environment string, globalTa
 		environment:    environment,
 		globalTags:     globalTags,
 		buffer:         buf,
+		location:       time.UTC,
+	}
+	if isStatementFormat(format) {
+		t.statements = &statementAccumulator{format: format}
+	}
+	if format == "cri" {
+		t.partials = newCRIReassembler(defaultCRIMaxBytes)
+	}
+	if format == "auto" {
+		t.sniffer = newFormatSniffer()
+	}
+	if format == "w3c" {
+		t.w3cFields = defaultW3CFields
 	}
+	return t
+}
+
+// SetMaxEntryBytes bounds the message of a CRI entry reassembled from
+// partial lines; the rest is dropped. Zero keeps the default of 1MB.
+func (t *Tailer) SetMaxEntryBytes(n int) {
+	if t.partials != nil && n > 0 {
+		t.partials.maxBytes = n
+	}
+}
+
+// SetExtractKV enables promoting key=value pairs in Django messages to tags.
+func (t *Tailer) SetExtractKV(enabled bool) {
+	t.extractKV = enabled
+}
+
+// SetDedupeWindow collapses repeats of the same level and message read within
+// window of the first into one event with a count tag. Zero disables it.
+func (t *Tailer) SetDedupeWindow(window time.Duration) {
+	if window <= 0 {
+		t.dedupe = nil
+		return
+	}
+	t.dedupe = newDeduper(window)
+}
+
+// SetSampleRates keeps only the given fraction (0-1) of events per level, e.g.
+// {"debug": 0.1}. Levels not listed are always kept.
+func (t *Tailer) SetSampleRates(rates map[string]float64) {
+	if len(rates) == 0 {
+		t.sampler = nil
+		return
+	}
+	t.sampler = newLevelSampler(rates)
+}
+
+// SetSpanSampler drops spans s does not keep before they reach the buffer.
+// A positive countInterval also emits the sampler's sampled-out counts that
+// often. Call before Start.
+func (t *Tailer) SetSpanSampler(s *spansample.Sampler, countInterval time.Duration) {
+	t.spanSampler = s
+	t.spanCountInterval = 0
+	if s != nil && countInterval > 0 {
+		s.CountSampledOut()
+		t.spanCountInterval = countInterval
+	}
+}
+
+// SetCorrelator stamps the proxy's trace onto events whose request_id or
+// x_request_id tag matches a request recorded in c.
+func (t *Tailer) SetCorrelator(c *correlate.Cache) {
+	t.correlator = c
+}
+
+// SetBackpressure makes the tailer wait up to timeout per event for a flush
+// when the buffer is full, pausing reads so the file is left unread on disk
+// rather than held in memory. Zero disables waiting.
+func (t *Tailer) SetBackpressure(timeout time.Duration) {
+	t.backpressure = timeout
+}
+
+// SetLocation sets the timezone used for timestamps that carry no offset.
+func (t *Tailer) SetLocation(loc *time.Location) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	t.location = loc
 }
 
 // Start starts tailing the log file
 func (t *Tailer) Start() error {
+	if isNamedPipe(t.path) {
+		return t.startPipe()
+	}
+
 	// Configure tail
 	config := tail.Config{
 		Follow: true, // Continue watching for new lines
@@ -57,68 +199,297 @@ func (t *Tailer) Start() error {
 		return err
 	}
 
-	log.Printf("[Tailer] Started tailing %s (format: %s)", t.path, t.format)
+	tailerLogger.Infof("Started tailing %s (format: %s)", t.path, t.format)
+	if t.w3cFields != nil {
+		// Tailing starts at the end, past the header that names the columns
+		if fields, ok := readW3CHeader(t.path); ok {
+			t.w3cFields = fields
+		}
+	}
+	t.rotation = newRotationMonitor(t.path)
 
-	// Read lines
-	go func() {
-		defer func() {
-			if r := recover(); r != nil {
-				log.Printf("[Tailer] Panic recovered in %s: %v", t.path, r)
-			}
-		}()
+	if t.backfill != nil {
+		go t.runBackfill()
+	}
+
+	go t.run(tailFile.Lines)
+	return nil
+}
+
+// run feeds lines through parsing until the channel closes, flushing pending
+// multi-line entries and held duplicates on idle and checking for rotation.
+func (t *Tailer) run(lines <-chan *tail.Line) {
+	defer func() {
+		if r := recover(); r != nil {
+			tailerLogger.Errorf("Panic recovered in %s: %v", t.path, r)
+		}
+	}()
+
+	rotationTicker := time.NewTicker(rotationCheckInterval)
+	defer rotationTicker.Stop()
+
+	// Emit held duplicates once their window has elapsed
+	var dedupeTick <-chan time.Time
+	if t.dedupe != nil {
+		dedupeTicker := time.NewTicker(dedupeCheckInterval(t.dedupe.window))
+		defer dedupeTicker.Stop()
+		dedupeTick = dedupeTicker.C
+	}
 
-		for line := range tailFile.Lines {
+	var spanCountTick <-chan time.Time
+	if t.spanCountInterval > 0 {
+		spanCountTicker := time.NewTicker(t.spanCountInterval)
+		defer spanCountTicker.Stop()
+		spanCountTick = spanCountTicker.C
+	}
+
+	for {
+		// Emit a pending database entry once its continuation lines stop arriving
+		var idle <-chan time.Time
+		if t.statements != nil && len(t.statements.pending) > 0 {
+			idle = time.After(statementIdleFlush)
+		}
+
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.flushPending()
+				t.flushDedupe()
+				return
+			}
 			if line.Err != nil {
-				log.Printf("[Tailer] Error reading %s: %v", t.path, line.Err)
+				tailerLogger.Errorf("Error reading %s: %v", t.path, line.Err)
 				continue
 			}
-
-			// Handle multi-line tracebacks for Django format
-			if t.format == "django" {
-				if t.handleMultiLineLog(line.Text) {
-					continue // Line was part of traceback
-				}
+			t.rotation.lineRead(line.Text)
+			t.handleLine(line.Text)
+		case <-idle:
+			t.flushPending()
+		case now := <-dedupeTick:
+			if t.dedupe.expired(now) {
+				t.flushDedupe()
 			}
-
-			// Parse log line
-			event := ParseLog(line.Text, t.format, t.organizationID, t.serviceName, t.environment)
-			if event == nil {
-				continue
+		case now := <-spanCountTick:
+			t.flushSpanCounts(now)
+		case now := <-rotationTicker.C:
+			t.flushStalePartials(now)
+			if r := t.rotation.check(); r != nil {
+				event := t.rotationEvent(r)
+				t.mergeGlobalTags(event)
+				t.emitInternal(event)
 			}
+		}
+	}
+}
 
-			if !scrubber.Apply(*event) {
-				continue
-			}
+// handleLine routes a raw line through multi-line handling before parsing
+func (t *Tailer) handleLine(text string) {
+	// W3C directives describe the lines that follow; IIS rewrites them on restart
+	if t.w3cFields != nil && strings.HasPrefix(text, "#") {
+		if fields, ok := parseW3CFieldsDirective(text); ok {
+			t.w3cFields = fields
+		}
+		return
+	}
 
-			// Merge global tags with event-specific tags
-			if len(t.globalTags) > 0 {
-				eventTags, ok := (*event)["tags"].(map[string]string)
-				if !ok || eventTags == nil {
-					// No existing tags, use global tags
-					(*event)["tags"] = t.globalTags
-				} else {
-					// Merge tags (event-specific tags take priority)
-					for k, v := range t.globalTags {
-						if _, exists := eventTags[k]; !exists {
-							eventTags[k] = v
-						}
-					}
-				}
-			}
+	// Handle multi-line tracebacks for Django format
+	if t.format == "django" || t.sniffer != nil {
+		if t.handleMultiLineLog(text) {
+			return // Line was part of traceback
+		}
+	}
 
-			// Track error events for potential tracebacks
-			if t.format == "django" {
-				if level, ok := (*event)["level"].(string); ok && (level == "error" || level == "critical") {
-					t.lastErrorEvent = event
-				}
-			}
+	// Reassemble CRI partial lines before parsing
+	if t.partials != nil {
+		if complete, ok := t.partials.Add(text); ok {
+			t.processLine(complete)
+		}
+		return
+	}
 
-			// Add to buffer
-			t.buffer.Add(*event)
+	// Accumulate multi-line statements for database formats
+	if t.statements != nil {
+		if entry, ok := t.statements.Add(text); ok {
+			t.processLine(entry)
 		}
-	}()
+		return
+	}
 
-	return nil
+	t.processLine(text)
+}
+
+// flushPending emits any multi-line entry still waiting for continuation lines
+func (t *Tailer) flushPending() {
+	if t.partials != nil {
+		for _, entry := range t.partials.Flush() {
+			t.processLine(entry)
+		}
+	}
+	if t.statements == nil {
+		return
+	}
+	if entry, ok := t.statements.Flush(); ok {
+		t.processLine(entry)
+	}
+}
+
+// flushStalePartials emits CRI entries whose fragments stopped arriving.
+func (t *Tailer) flushStalePartials(now time.Time) {
+	if t.partials == nil {
+		return
+	}
+	for _, entry := range t.partials.Expire(now.Add(-criPartialIdle)) {
+		t.processLine(entry)
+	}
+}
+
+// processLine parses a complete log entry and adds it to the buffer
+func (t *Tailer) processLine(text string) {
+	format := t.format
+	if t.sniffer != nil {
+		format = t.sniffer.detect(text)
+	}
+	var event *buffer.Event
+	if t.w3cFields != nil {
+		event = parseW3CLog(text, t.w3cFields, t.organizationID, t.serviceName, t.environment, t.location)
+	} else {
+		event = ParseLogInLocation(text, format, t.organizationID, t.serviceName, t.environment, t.location)
+	}
+	if report := t.observeParse(text, event != nil); report != nil {
+		t.emitInternal(report)
+	}
+	if event == nil {
+		return
+	}
+
+	if t.extractKV && format == "django" {
+		promoteKeyValues(event)
+	}
+
+	if t.correlator != nil {
+		t.correlator.Stamp(*event)
+	}
+
+	// Global tags first, so tag rules and tag_allowlist cover them too
+	t.mergeGlobalTags(*event)
+
+	if !scrubber.Apply(*event) {
+		return
+	}
+
+	// Track error events for potential tracebacks
+	if format == "django" {
+		if level, ok := (*event)["level"].(string); ok && (level == "error" || level == "critical") {
+			t.lastErrorEvent = event
+		}
+	}
+
+	// After correlation stamping, so the decision follows the proxy's trace
+	if t.spanSampler != nil && !t.spanSampler.Keep(*event) {
+		return
+	}
+
+	if t.sampler != nil && !t.sampler.keep(*event) {
+		diag.Global().RecordSampledOut(t.path, 1)
+		return
+	}
+
+	if t.dedupe != nil {
+		if out := t.dedupe.add(*event, time.Now()); out != nil {
+			t.emit(out)
+		}
+		return
+	}
+
+	// Add to buffer
+	t.emit(*event)
+}
+
+// emit adds an event to the buffer, waiting for room when backpressure is set.
+func (t *Tailer) emit(event buffer.Event) {
+	if t.backpressure <= 0 {
+		t.buffer.Add(event)
+		return
+	}
+	if t.buffer.AddWait(event, t.backpressure) {
+		if t.backedUp {
+			t.backedUp = false
+			tailerLogger.Infof("Buffer has room again; resumed reading %s", t.path)
+		}
+		return
+	}
+	if !t.backedUp {
+		t.backedUp = true
+		tailerLogger.Warnf("Buffer still full after %v; reading %s one event per wait until it drains", t.backpressure, t.path)
+	}
+}
+
+// flushSpanCounts emits the spans sampled out since the last call as metrics.
+func (t *Tailer) flushSpanCounts(now time.Time) {
+	for _, event := range t.spanSampler.Flush(now, t.baseMetricEvent) {
+		t.emitInternal(event)
+	}
+}
+
+func (t *Tailer) baseMetricEvent() buffer.Event {
+	tags := make(map[string]string, len(t.globalTags)+3)
+	for k, v := range t.globalTags {
+		tags[k] = v
+	}
+	tags["source"] = t.path
+	return buffer.Event{
+		"organization_id": t.organizationID,
+		"service_name":    t.serviceName,
+		"environment":     t.environment,
+		"event_id":        uuid.New().String(),
+		"tags":            tags,
+	}
+}
+
+// flushDedupe emits the event held for deduplication, if any.
+func (t *Tailer) flushDedupe() {
+	if t.dedupe == nil {
+		return
+	}
+	if event := t.dedupe.flush(); event != nil {
+		t.emit(event)
+	}
+}
+
+// dedupeCheckInterval polls often enough that a held event is emitted at
+// most a fraction of the window late.
+func dedupeCheckInterval(window time.Duration) time.Duration {
+	interval := window / 4
+	if interval < 100*time.Millisecond {
+		interval = 100 * time.Millisecond
+	}
+	return interval
+}
+
+// emitInternal scrubs an event the tailer generates itself, such as a
+// rotation notice, and adds it to the buffer.
+func (t *Tailer) emitInternal(event buffer.Event) {
+	if scrubber.Apply(event) {
+		t.emit(event)
+	}
+}
+
+// mergeGlobalTags adds global tags to event; event-specific tags take priority.
+// Events get their own copy, since scrubbing edits tags in place.
+func (t *Tailer) mergeGlobalTags(event buffer.Event) {
+	if len(t.globalTags) == 0 {
+		return
+	}
+	eventTags, ok := event["tags"].(map[string]string)
+	if !ok || eventTags == nil {
+		eventTags = make(map[string]string, len(t.globalTags))
+		event["tags"] = eventTags
+	}
+	for k, v := range t.globalTags {
+		if _, exists := eventTags[k]; !exists {
+			eventTags[k] = v
+		}
+	}
 }
 
 // handleMultiLineLog processes multi-line log entries (like stack traces)
@@ -147,7 +518,7 @@ func (t *Tailer) handleMultiLineLog(line string) bool {
 				if t.lastErrorEvent != nil {
 					stacktrace := strings.Join(t.tracebackLines, "\n")
 					(*t.lastErrorEvent)["stacktrace"] = stacktrace
-					log.Printf("[Tailer] Captured traceback (%d lines) for error event", len(t.tracebackLines))
+					tailerLogger.Infof("Captured traceback (%d lines) for error event", len(t.tracebackLines))
 				}
 
 				// Reset state
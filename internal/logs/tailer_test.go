@@ -0,0 +1,166 @@
+package logs
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+	"github.com/yaat-app/sidecar/internal/config"
+	"github.com/yaat-app/sidecar/internal/diag"
+	"github.com/yaat-app/sidecar/internal/logging"
+	"github.com/yaat-app/sidecar/internal/scrubber"
+	"github.com/yaat-app/sidecar/internal/spansample"
+)
+
+func TestTailerBacksOffWhenBufferFull(t *testing.T) {
+	buf := buffer.New(3)
+	tailer := New("app.log", "json", "org", "svc", "prod", nil, buf)
+	tailer.SetBackpressure(5 * time.Second)
+
+	var input strings.Builder
+	for i := 0; i < 6; i++ {
+		fmt.Fprintf(&input, `{"msg":"line-%d"}`+"\n", i)
+	}
+	done := make(chan int)
+	go func() {
+		lines, _ := tailer.ReadLines(strings.NewReader(input.String()), nil)
+		done <- lines
+	}()
+
+	// The reader stops at the buffer's size instead of racing ahead
+	select {
+	case <-buf.FlushSignal():
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the tailer to ask for a flush once the buffer filled")
+	}
+	select {
+	case <-done:
+		t.Fatal("Expected the tailer to wait for room before reading on")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if buf.Len() != 3 {
+		t.Fatalf("Expected the buffer held at its size of 3, got %d", buf.Len())
+	}
+
+	// Each flush lets it continue; nothing is dropped
+	got := messages(buf.Flush())
+	var lines int
+	select {
+	case lines = <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the tailer to finish after the flush")
+	}
+	got = append(got, messages(buf.Flush())...)
+	if lines != 6 || len(got) != 6 || got[5] != "line-5" {
+		t.Errorf("Expected all 6 lines delivered in order, got %d lines and %v", lines, got)
+	}
+}
+
+func TestTailerReportsParseFailures(t *testing.T) {
+	var out bytes.Buffer
+	logging.SetOutput(&out)
+	defer logging.SetOutput(os.Stderr)
+
+	buf := buffer.New(0)
+	path := filepath.Join(t.TempDir(), "access.log")
+	tailer := New(path, "nginx", "org", "svc", "prod", nil, buf)
+	for i := 0; i < parseFailureWindow; i++ {
+		tailer.processLine(fmt.Sprintf(`{"level":"info","msg":"request %d"}`, i))
+	}
+
+	if got := diag.Global().Snapshot().Sources[path].ParseFailures; got != parseFailureWindow {
+		t.Errorf("Expected %d parse failures counted, got %d", parseFailureWindow, got)
+	}
+	logged := out.String()
+	if strings.Count(logged, "failed to parse as format \"nginx\"") != 2 {
+		t.Errorf("Expected one streak warning and one suggestion, got:\n%s", logged)
+	}
+	if !strings.Contains(logged, `Sample: {"level":"info","msg":"request 99"}`) {
+		t.Errorf("Expected a sample line in the warning, got:\n%s", logged)
+	}
+	if !strings.Contains(logged, "try format: json") {
+		t.Errorf("Expected json suggested, got:\n%s", logged)
+	}
+
+	events := buf.Flush()
+	if len(events) != 1 || events[0]["logger"] != parseFailuresLogger {
+		t.Fatalf("Expected one %s event, got %v", parseFailuresLogger, events)
+	}
+
+	// A matching line ends the streak
+	tailer.processLine(`127.0.0.1 - - [10/Oct/2025:13:55:36 +0000] "GET / HTTP/1.1" 200 612`)
+	if tailer.parseFailures.streak != 0 {
+		t.Errorf("Expected the streak reset by a parsed line, got %d", tailer.parseFailures.streak)
+	}
+}
+
+func TestSanitizeSample(t *testing.T) {
+	long := strings.Repeat("x", maxParseSampleLen+10)
+	if got := sanitizeSample(long); len([]rune(got)) != maxParseSampleLen+1 {
+		t.Errorf("Expected the sample cut to %d runes, got %d", maxParseSampleLen, len([]rune(got)))
+	}
+	if got := sanitizeSample("a\x1b[31mb"); got != "a?[31mb" {
+		t.Errorf("Expected control characters escaped, got %q", got)
+	}
+}
+
+func TestTailerSpanSampling(t *testing.T) {
+	buf := buffer.New(0)
+	tailer := New("access.log", "nginx", "org", "svc", "prod", nil, buf)
+	tailer.SetSpanSampler(spansample.New("access.log", 0, true), time.Minute)
+
+	for i := 0; i < 5; i++ {
+		tailer.processLine(fmt.Sprintf(`127.0.0.1 - - [10/Oct/2025:13:55:3%d +0000] "GET / HTTP/1.1" 200 612`, i))
+	}
+	tailer.processLine(`127.0.0.1 - - [10/Oct/2025:13:55:40 +0000] "GET /missing HTTP/1.1" 404 0`)
+
+	events := buf.Flush()
+	if len(events) != 1 || events[0]["status_code"] != 404 {
+		t.Fatalf("Expected only the 404 span buffered, got %v", events)
+	}
+
+	tailer.flushSpanCounts(time.Now())
+	events = buf.Flush()
+	if len(events) != 1 || events[0]["metric_name"] != spansample.MetricName || events[0]["metric_value"] != 5.0 {
+		t.Fatalf("Expected a count of 5 sampled-out spans, got %v", events)
+	}
+	if tags := events[0]["tags"].(map[string]string); tags["source"] != "access.log" || tags["status_class"] != "2xx" {
+		t.Errorf("Expected source and status class tags, got %v", tags)
+	}
+}
+
+func TestTailerAllowlistCoversGlobalTags(t *testing.T) {
+	if err := scrubber.Configure(config.ScrubbingConfig{
+		Enabled:      true,
+		TagAllowlist: []string{"env", "k8s.namespace"},
+		Rules:        []config.ScrubRule{{Name: "mask-ns", Pattern: "prod-.*", Replacement: "[NS]", Fields: []string{"tags.k8s.namespace"}}},
+	}); err != nil {
+		t.Fatalf("Configure failed: %v", err)
+	}
+	defer scrubber.Configure(config.ScrubbingConfig{})
+
+	globalTags := map[string]string{"env": "prod", "k8s.namespace": "prod-payments", "k8s.annotation.secret": "hunter2"}
+	buf := buffer.New(0)
+	tailer := New("app.log", "json", "org", "svc", "prod", globalTags, buf)
+	tailer.processLine(`{"level":"info","msg":"hello"}`)
+
+	events := buf.Flush()
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(events))
+	}
+	tags := events[0]["tags"].(map[string]string)
+	if _, ok := tags["k8s.annotation.secret"]; ok {
+		t.Errorf("Expected the unlisted global tag dropped, got %v", tags)
+	}
+	if tags["env"] != "prod" || tags["k8s.namespace"] != "[NS]" {
+		t.Errorf("Expected listed global tags kept and scrubbed, got %v", tags)
+	}
+	if globalTags["k8s.namespace"] != "prod-payments" || len(globalTags) != 3 {
+		t.Errorf("Expected the tailer's global tags untouched, got %v", globalTags)
+	}
+}
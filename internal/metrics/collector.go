@@ -1,23 +1,29 @@
 package metrics
 
 import (
-	"log"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/yaat-app/sidecar/internal/buffer"
 	"github.com/yaat-app/sidecar/internal/config"
+	"github.com/yaat-app/sidecar/internal/logging"
 	"github.com/yaat-app/sidecar/internal/scrubber"
 )
 
+var logger = logging.New("Metrics")
+
 // Collector periodically samples host metrics and enqueues metric events.
 type Collector struct {
 	organizationID string
 	serviceName    string
 	environment    string
+	namespace      string
 	tags           map[string]string
 	interval       time.Duration
 	buf            *buffer.Buffer
+	disks          deviceFilter
+	interfaces     deviceFilter
 
 	sampler sampler
 
@@ -25,8 +31,16 @@ type Collector struct {
 	wg   sync.WaitGroup
 
 	prev *Counters
+
+	// Counters whose reset has been logged, so each is reported once
+	resetLogged map[string]bool
 }
 
+// maxRateGap is how many intervals may pass between samples before a delta
+// is considered stale; a long pause (suspend, stalled ticker) would otherwise
+// average a burst over the wrong window.
+const maxRateGap = 3
+
 // NewCollector constructs a collector using the provided configuration.
 func NewCollector(organizationID, serviceName, environment string, globalTags map[string]string, cfg config.MetricsConfig, buf *buffer.Buffer) (*Collector, error) {
 	sampler, err := newSampler()
@@ -47,9 +61,12 @@ func NewCollector(organizationID, serviceName, environment string, globalTags ma
 		organizationID: organizationID,
 		serviceName:    serviceName,
 		environment:    environment,
+		namespace:      cfg.Namespace,
 		tags:           tagsCopy,
 		interval:       cfg.IntervalDuration,
 		buf:            buf,
+		disks:          newDeviceFilter(cfg.DiskDevices, defaultExcludedDisks),
+		interfaces:     newDeviceFilter(cfg.NetInterfaces, defaultExcludedInterfaces),
 		sampler:        sampler,
 		stop:           make(chan struct{}),
 	}, nil
@@ -84,7 +101,7 @@ func (c *Collector) Start() func() {
 func (c *Collector) sample() {
 	counters, err := c.sampler.Read()
 	if err != nil {
-		log.Printf("[Metrics] Sample failed: %v", err)
+		logger.Errorf("Sample failed: %v", err)
 		return
 	}
 
@@ -110,6 +127,9 @@ func (c *Collector) buildEvents(curr Counters) []buffer.Event {
 		for k, v := range tags {
 			eventTags[k] = v
 		}
+		if c.namespace != "" {
+			name = c.namespace + "." + name
+		}
 		return buffer.Event{
 			"organization_id": c.organizationID,
 			"service_name":    c.serviceName,
@@ -122,11 +142,11 @@ func (c *Collector) buildEvents(curr Counters) []buffer.Event {
 		}
 	}
 
-	if c.prev != nil && curr.CPUTotal > c.prev.CPUTotal {
-		totalDelta := float64(curr.CPUTotal - c.prev.CPUTotal)
-		idleDelta := float64(curr.CPUIdle - c.prev.CPUIdle)
-		if totalDelta > 0 && idleDelta >= 0 {
-			cpuUsage := (1.0 - idleDelta/totalDelta) * 100.0
+	if c.prev != nil {
+		totalDelta, totalOK := c.counterDelta("cpu.total", curr.CPUTotal, c.prev.CPUTotal)
+		idleDelta, idleOK := c.counterDelta("cpu.idle", curr.CPUIdle, c.prev.CPUIdle)
+		if totalOK && idleOK && totalDelta > 0 && idleDelta <= totalDelta {
+			cpuUsage := (1.0 - float64(idleDelta)/float64(totalDelta)) * 100.0
 			events = append(events, toEvent("host.cpu.usage_percent", cpuUsage, map[string]string{
 				"unit": "percent",
 			}))
@@ -158,23 +178,141 @@ func (c *Collector) buildEvents(curr Counters) []buffer.Event {
 		}))
 	}
 
-	if c.prev != nil {
+	if c.prev != nil && c.rateWindowOK(curr.Timestamp.Sub(c.prev.Timestamp)) {
 		elapsed := curr.Timestamp.Sub(c.prev.Timestamp).Seconds()
-		if elapsed > 0 {
-			if curr.NetRxBytes >= c.prev.NetRxBytes {
-				rxRate := float64(curr.NetRxBytes-c.prev.NetRxBytes) / elapsed
-				events = append(events, toEvent("host.net.rx_bytes_per_sec", rxRate, map[string]string{
-					"unit": "bytes_per_sec",
-				}))
-			}
-			if curr.NetTxBytes >= c.prev.NetTxBytes {
-				txRate := float64(curr.NetTxBytes-c.prev.NetTxBytes) / elapsed
-				events = append(events, toEvent("host.net.tx_bytes_per_sec", txRate, map[string]string{
-					"unit": "bytes_per_sec",
+		currRx, currTx := c.netTotals(curr)
+		prevRx, prevTx := c.netTotals(*c.prev)
+		if rx, ok := c.counterDelta("net.rx_bytes", currRx, prevRx); ok {
+			events = append(events, toEvent("host.net.rx_bytes_per_sec", float64(rx)/elapsed, map[string]string{
+				"unit":      "bytes_per_sec",
+				"interface": aggregateInterface,
+			}))
+		}
+		if tx, ok := c.counterDelta("net.tx_bytes", currTx, prevTx); ok {
+			events = append(events, toEvent("host.net.tx_bytes_per_sec", float64(tx)/elapsed, map[string]string{
+				"unit":      "bytes_per_sec",
+				"interface": aggregateInterface,
+			}))
+		}
+		events = append(events, c.interfaceEvents(curr, elapsed, toEvent)...)
+		events = append(events, c.diskEvents(curr, elapsed, toEvent)...)
+	}
+
+	return events
+}
+
+// aggregateInterface is the interface tag of the rx/tx rates summed over
+// every selected interface, so they do not mix with per-interface series of
+// the same name.
+const aggregateInterface = "all"
+
+// netTotals sums the byte counters of the interfaces metrics.net_interfaces
+// selects. Samples without per-interface counters use the sampler's totals.
+func (c *Collector) netTotals(counters Counters) (rx, tx uint64) {
+	if counters.Interfaces == nil {
+		return counters.NetRxBytes, counters.NetTxBytes
+	}
+	for name, iface := range counters.Interfaces {
+		if c.interfaces.allows(name) {
+			rx += iface.RxBytes
+			tx += iface.TxBytes
+		}
+	}
+	return rx, tx
+}
+
+// interfaceEvents derives per-interface rx/tx rates for interfaces present
+// in both samples and allowed by metrics.net_interfaces.
+func (c *Collector) interfaceEvents(curr Counters, elapsed float64, toEvent func(string, float64, map[string]string) buffer.Event) []buffer.Event {
+	names := make([]string, 0, len(curr.Interfaces))
+	for name := range curr.Interfaces {
+		if _, ok := c.prev.Interfaces[name]; ok && c.interfaces.allows(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var events []buffer.Event
+	for _, name := range names {
+		now, prev := curr.Interfaces[name], c.prev.Interfaces[name]
+		if rx, ok := c.counterDelta("net."+name+".rx_bytes", now.RxBytes, prev.RxBytes); ok {
+			events = append(events, toEvent("host.net.rx_bytes_per_sec", float64(rx)/elapsed, map[string]string{
+				"unit":      "bytes_per_sec",
+				"interface": name,
+			}))
+		}
+		if tx, ok := c.counterDelta("net."+name+".tx_bytes", now.TxBytes, prev.TxBytes); ok {
+			events = append(events, toEvent("host.net.tx_bytes_per_sec", float64(tx)/elapsed, map[string]string{
+				"unit":      "bytes_per_sec",
+				"interface": name,
+			}))
+		}
+	}
+	return events
+}
+
+// diskEvents derives per-device I/O rates for devices present in both
+// samples and allowed by metrics.disk_devices.
+func (c *Collector) diskEvents(curr Counters, elapsed float64, toEvent func(string, float64, map[string]string) buffer.Event) []buffer.Event {
+	devices := make([]string, 0, len(curr.Disks))
+	for device := range curr.Disks {
+		if _, ok := c.prev.Disks[device]; ok && c.disks.allows(device) {
+			devices = append(devices, device)
+		}
+	}
+	sort.Strings(devices)
+
+	var events []buffer.Event
+	for _, device := range devices {
+		now, prev := curr.Disks[device], c.prev.Disks[device]
+		rates := []struct {
+			name       string
+			curr, prev uint64
+			unit       string
+		}{
+			{"read_ops", now.ReadOps, prev.ReadOps, "ops_per_sec"},
+			{"write_ops", now.WriteOps, prev.WriteOps, "ops_per_sec"},
+			{"read_bytes", now.ReadBytes, prev.ReadBytes, "bytes_per_sec"},
+			{"write_bytes", now.WriteBytes, prev.WriteBytes, "bytes_per_sec"},
+		}
+		for _, r := range rates {
+			if delta, ok := c.counterDelta("disk."+device+"."+r.name, r.curr, r.prev); ok {
+				events = append(events, toEvent("host.disk."+r.name+"_per_sec", float64(delta)/elapsed, map[string]string{
+					"unit":   r.unit,
+					"device": device,
 				}))
 			}
 		}
 	}
-
 	return events
 }
+
+// counterDelta returns curr-prev for a monotonic counter. A counter that went
+// backwards was reset (reboot, interface re-created or wrapped), so there is
+// no meaningful delta for this sample; it is skipped and logged once.
+func (c *Collector) counterDelta(name string, curr, prev uint64) (uint64, bool) {
+	if curr >= prev {
+		return curr - prev, true
+	}
+	if !c.resetLogged[name] {
+		if c.resetLogged == nil {
+			c.resetLogged = make(map[string]bool)
+		}
+		c.resetLogged[name] = true
+		logger.Debugf("Counter %s reset (%d -> %d); skipping this sample", name, prev, curr)
+	}
+	return 0, false
+}
+
+// rateWindowOK reports whether elapsed is a usable window for per-second
+// rates: positive and no longer than maxRateGap intervals.
+func (c *Collector) rateWindowOK(elapsed time.Duration) bool {
+	if elapsed <= 0 {
+		return false
+	}
+	if c.interval > 0 && elapsed > maxRateGap*c.interval {
+		logger.Debugf("Skipping rates after a %v gap between samples", elapsed.Truncate(time.Second))
+		return false
+	}
+	return true
+}
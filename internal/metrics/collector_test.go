@@ -0,0 +1,357 @@
+package metrics
+
+import (
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+	"github.com/yaat-app/sidecar/internal/config"
+	"github.com/yaat-app/sidecar/internal/statsd"
+)
+
+func eventKeys(evt buffer.Event) []string {
+	keys := make([]string, 0, len(evt))
+	for k := range evt {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// statsdEvent sends one metric through a real StatsD listener and returns the event it produced.
+func statsdEvent(t *testing.T, globalTags map[string]string) buffer.Event {
+	t.Helper()
+	buf := buffer.New(10)
+	srv := statsd.New(config.StatsDConfig{ListenAddr: "127.0.0.1:0"}, "org_123", "svc", "prod", globalTags, buf)
+	stop, err := srv.Start()
+	if err != nil {
+		t.Fatalf("Failed to start StatsD listener: %v", err)
+	}
+	defer stop()
+
+	conn, err := net.Dial("udp", srv.Addr())
+	if err != nil {
+		t.Fatalf("Failed to dial StatsD listener: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("requests:1|c")); err != nil {
+		t.Fatalf("Failed to send metric: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for buf.Len() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	events := buf.Flush()
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 StatsD event, got %d", len(events))
+	}
+	return events[0]
+}
+
+func TestHostMetricEventsMatchStatsD(t *testing.T) {
+	globalTags := map[string]string{"cloud.provider": "aws", "team": "core"}
+	collector, err := NewCollector("org_123", "svc", "prod", globalTags, config.MetricsConfig{
+		Tags:             map[string]string{"team": "infra"},
+		IntervalDuration: time.Minute,
+	}, buffer.New(10))
+	if err != nil {
+		t.Skipf("Host metrics unavailable: %v", err)
+	}
+
+	events := collector.buildEvents(Counters{
+		Timestamp:    time.Now(),
+		MemTotal:     1000,
+		MemAvailable: 250,
+	})
+	if len(events) == 0 {
+		t.Fatal("Expected host metric events")
+	}
+	reference := statsdEvent(t, globalTags)
+
+	for _, evt := range events {
+		if got, want := eventKeys(evt), eventKeys(reference); len(got) != len(want) {
+			t.Errorf("Expected fields %v like StatsD events, got %v", want, got)
+		} else {
+			for i := range got {
+				if got[i] != want[i] {
+					t.Errorf("Expected fields %v like StatsD events, got %v", want, got)
+					break
+				}
+			}
+		}
+		if evt["organization_id"] != "org_123" {
+			t.Errorf("Expected organization_id org_123, got %v", evt["organization_id"])
+		}
+
+		tags := evt["tags"].(map[string]string)
+		if tags["cloud.provider"] != "aws" {
+			t.Errorf("Expected global tag cloud.provider, got %q", tags["cloud.provider"])
+		}
+		if tags["team"] != "infra" {
+			t.Errorf("Expected metrics.tags to win over global tags, got team=%q", tags["team"])
+		}
+	}
+}
+
+func metricNames(events []buffer.Event) map[string]float64 {
+	names := make(map[string]float64, len(events))
+	for _, evt := range events {
+		names[evt["metric_name"].(string)] = evt["metric_value"].(float64)
+	}
+	return names
+}
+
+func TestBuildEventsRates(t *testing.T) {
+	start := time.Now()
+	c := &Collector{interval: 10 * time.Second}
+	c.prev = &Counters{Timestamp: start, CPUTotal: 1000, CPUIdle: 800, NetRxBytes: 5000, NetTxBytes: 1000}
+
+	got := metricNames(c.buildEvents(Counters{Timestamp: start.Add(10 * time.Second), CPUTotal: 1100, CPUIdle: 875, NetRxBytes: 15000, NetTxBytes: 3000}))
+	if got["host.cpu.usage_percent"] != 25 {
+		t.Errorf("Expected 25%% CPU, got %v", got["host.cpu.usage_percent"])
+	}
+	if got["host.net.rx_bytes_per_sec"] != 1000 || got["host.net.tx_bytes_per_sec"] != 200 {
+		t.Errorf("Expected rx 1000/s and tx 200/s, got %v and %v", got["host.net.rx_bytes_per_sec"], got["host.net.tx_bytes_per_sec"])
+	}
+}
+
+func TestBuildEventsSkipsCounterResets(t *testing.T) {
+	start := time.Now()
+	c := &Collector{interval: 10 * time.Second}
+	c.prev = &Counters{Timestamp: start, CPUTotal: 900000, CPUIdle: 800000, NetRxBytes: 5000, NetTxBytes: 1000}
+
+	// After a reboot the CPU counters restart near zero and the rx counter wrapped
+	curr := Counters{Timestamp: start.Add(10 * time.Second), CPUTotal: 500, CPUIdle: 400, NetRxBytes: 10, NetTxBytes: 3000}
+	got := metricNames(c.buildEvents(curr))
+	if _, ok := got["host.cpu.usage_percent"]; ok {
+		t.Error("Expected CPU usage to be skipped after a reset")
+	}
+	if _, ok := got["host.net.rx_bytes_per_sec"]; ok {
+		t.Error("Expected rx rate to be skipped after a reset")
+	}
+	if got["host.net.tx_bytes_per_sec"] != 200 {
+		t.Errorf("Expected tx rate unaffected by the rx reset, got %v", got["host.net.tx_bytes_per_sec"])
+	}
+	if !c.resetLogged["cpu.total"] || !c.resetLogged["net.rx_bytes"] {
+		t.Errorf("Expected resets to be recorded, got %v", c.resetLogged)
+	}
+
+	// Only the idle counter going backwards is also a reset
+	c.prev = &Counters{Timestamp: start, CPUTotal: 1000, CPUIdle: 800}
+	got = metricNames(c.buildEvents(Counters{Timestamp: start.Add(10 * time.Second), CPUTotal: 1100, CPUIdle: 100}))
+	if _, ok := got["host.cpu.usage_percent"]; ok {
+		t.Error("Expected CPU usage to be skipped when idle went backwards")
+	}
+
+	// The next sample resumes from the new baseline
+	c.prev = &curr
+	got = metricNames(c.buildEvents(Counters{Timestamp: start.Add(20 * time.Second), CPUTotal: 600, CPUIdle: 450, NetRxBytes: 2010, NetTxBytes: 3000}))
+	if got["host.cpu.usage_percent"] != 50 || got["host.net.rx_bytes_per_sec"] != 200 {
+		t.Errorf("Expected CPU 50%% and rx 200/s after the reset, got %v", got)
+	}
+}
+
+func TestBuildEventsSkipsRatesAfterLongPause(t *testing.T) {
+	start := time.Now()
+	c := &Collector{interval: 10 * time.Second}
+	c.prev = &Counters{Timestamp: start, NetRxBytes: 5000}
+
+	got := metricNames(c.buildEvents(Counters{Timestamp: start.Add(time.Hour), NetRxBytes: 50000000}))
+	if _, ok := got["host.net.rx_bytes_per_sec"]; ok {
+		t.Error("Expected rates to be skipped after a gap of many intervals")
+	}
+
+	got = metricNames(c.buildEvents(Counters{Timestamp: start.Add(25 * time.Second), NetRxBytes: 10000}))
+	if got["host.net.rx_bytes_per_sec"] != 200 {
+		t.Errorf("Expected a rate within %d intervals, got %v", maxRateGap, got["host.net.rx_bytes_per_sec"])
+	}
+}
+
+func TestBuildEventsNamespace(t *testing.T) {
+	c := &Collector{namespace: "myprefix"}
+	events := c.buildEvents(Counters{Timestamp: time.Now(), MemTotal: 1000, MemAvailable: 250})
+	if len(events) == 0 {
+		t.Fatal("Expected host metric events")
+	}
+	for _, evt := range events {
+		name := evt["metric_name"].(string)
+		if !strings.HasPrefix(name, "myprefix.host.") {
+			t.Errorf("Expected myprefix.host.* metric name, got %q", name)
+		}
+	}
+}
+
+func loadDiskStats(t *testing.T) map[string]DiskIO {
+	t.Helper()
+	file, err := os.Open("testdata/diskstats")
+	if err != nil {
+		t.Fatalf("Failed to open fixture: %v", err)
+	}
+	defer file.Close()
+	disks, err := parseDiskStats(file)
+	if err != nil {
+		t.Fatalf("Failed to parse diskstats: %v", err)
+	}
+	return disks
+}
+
+func TestParseDiskStats(t *testing.T) {
+	disks := loadDiskStats(t)
+	if len(disks) != 7 {
+		t.Errorf("Expected 7 devices, got %d", len(disks))
+	}
+	want := DiskIO{ReadOps: 184211, WriteOps: 391455, ReadBytes: 12045798 * 512, WriteBytes: 20451236 * 512}
+	if disks["nvme0n1"] != want {
+		t.Errorf("Expected nvme0n1 %+v, got %+v", want, disks["nvme0n1"])
+	}
+	// Older kernels print 14 fields
+	if disks["sda"].WriteBytes != 32768*512 {
+		t.Errorf("Expected sda to parse from the short format, got %+v", disks["sda"])
+	}
+}
+
+func TestDeviceFilter(t *testing.T) {
+	tests := []struct {
+		devices []string
+		device  string
+		want    bool
+	}{
+		{nil, "nvme0n1", true},
+		{nil, "loop0", false},
+		{nil, "ram0", false},
+		{[]string{"nvme*", "sda"}, "nvme0n1p1", true},
+		{[]string{"nvme*", "sda"}, "sda", true},
+		{[]string{"nvme*", "sda"}, "sdb", false},
+		{[]string{"loop0"}, "loop0", true},
+	}
+	for _, tt := range tests {
+		if got := newDeviceFilter(tt.devices, defaultExcludedDisks).allows(tt.device); got != tt.want {
+			t.Errorf("Expected allows(%q) with %v to be %v, got %v", tt.device, tt.devices, tt.want, got)
+		}
+	}
+}
+
+func TestBuildEventsDiskRates(t *testing.T) {
+	start := time.Now()
+	disks := loadDiskStats(t)
+	c := &Collector{interval: 10 * time.Second, disks: newDeviceFilter(nil, defaultExcludedDisks)}
+	c.prev = &Counters{Timestamp: start, Disks: disks}
+
+	next := make(map[string]DiskIO, len(disks))
+	for device, io := range disks {
+		io.ReadOps += 100
+		io.WriteOps += 50
+		io.ReadBytes += 40960
+		io.WriteBytes += 20480
+		next[device] = io
+	}
+	events := c.buildEvents(Counters{Timestamp: start.Add(10 * time.Second), Disks: next})
+
+	got := make(map[string]float64)
+	for _, evt := range events {
+		if tags, ok := evt["tags"].(map[string]string); ok && tags["device"] != "" {
+			got[evt["metric_name"].(string)+"/"+tags["device"]] = evt["metric_value"].(float64)
+		}
+	}
+	if got["host.disk.read_ops_per_sec/nvme0n1"] != 10 || got["host.disk.write_ops_per_sec/nvme0n1"] != 5 {
+		t.Errorf("Expected 10 reads/s and 5 writes/s, got %v", got)
+	}
+	if got["host.disk.read_bytes_per_sec/sda"] != 4096 || got["host.disk.write_bytes_per_sec/sda"] != 2048 {
+		t.Errorf("Expected 4096 and 2048 bytes/s, got %v", got)
+	}
+	for key := range got {
+		if strings.Contains(key, "/loop") || strings.Contains(key, "/ram") {
+			t.Errorf("Expected loop and ram devices to be excluded, got %s", key)
+		}
+	}
+	if len(got) != 16 {
+		t.Errorf("Expected 4 metrics for each of 4 devices, got %d", len(got))
+	}
+}
+
+func loadNetDev(t *testing.T) map[string]NetIO {
+	t.Helper()
+	file, err := os.Open("testdata/net_dev")
+	if err != nil {
+		t.Fatalf("Failed to open fixture: %v", err)
+	}
+	defer file.Close()
+	interfaces, err := parseNetDev(file)
+	if err != nil {
+		t.Fatalf("Failed to parse /proc/net/dev: %v", err)
+	}
+	return interfaces
+}
+
+func TestParseNetDev(t *testing.T) {
+	interfaces := loadNetDev(t)
+	if len(interfaces) != 5 {
+		t.Errorf("Expected 5 interfaces, got %d", len(interfaces))
+	}
+	if want := (NetIO{RxBytes: 1853297425, TxBytes: 189241556}); interfaces["eth0"] != want {
+		t.Errorf("Expected eth0 %+v, got %+v", want, interfaces["eth0"])
+	}
+	// Long names leave no space after the colon
+	if interfaces["veth3a1b2c"].RxBytes != 4933400 {
+		t.Errorf("Expected veth3a1b2c to parse, got %+v", interfaces["veth3a1b2c"])
+	}
+}
+
+func TestBuildEventsInterfaceRates(t *testing.T) {
+	start := time.Now()
+	interfaces := loadNetDev(t)
+	next := make(map[string]NetIO, len(interfaces))
+	for name, iface := range interfaces {
+		iface.RxBytes += 10000
+		iface.TxBytes += 2000
+		next[name] = iface
+	}
+
+	tests := []struct {
+		name       string
+		configured []string
+		want       []string
+		aggregate  float64 // rx bytes per second over the selected interfaces
+	}{
+		{"default skips loopback", nil, []string{"docker0", "eth0", "eth1", "veth3a1b2c"}, 4000},
+		{"configured list", []string{"eth*"}, []string{"eth0", "eth1"}, 2000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Collector{interval: 10 * time.Second, interfaces: newDeviceFilter(tt.configured, defaultExcludedInterfaces)}
+			c.prev = &Counters{Timestamp: start, Interfaces: interfaces}
+			events := c.buildEvents(Counters{Timestamp: start.Add(10 * time.Second), Interfaces: next})
+
+			var got []string
+			aggregate := -1.0
+			for _, evt := range events {
+				if evt["metric_name"] != "host.net.rx_bytes_per_sec" {
+					continue
+				}
+				tags := evt["tags"].(map[string]string)
+				switch name, ok := tags["interface"]; {
+				case !ok:
+					t.Errorf("Expected every rate tagged with its interface, got %v", tags)
+				case name == aggregateInterface:
+					aggregate = evt["metric_value"].(float64)
+				default:
+					got = append(got, name)
+					if evt["metric_value"].(float64) != 1000 {
+						t.Errorf("Expected 1000 bytes/s on %s, got %v", name, evt["metric_value"])
+					}
+				}
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Expected interfaces %v, got %v", tt.want, got)
+			}
+			if aggregate != tt.aggregate {
+				t.Errorf("Expected aggregate rx %v/s, got %v", tt.aggregate, aggregate)
+			}
+		})
+	}
+}
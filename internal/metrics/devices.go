@@ -0,0 +1,39 @@
+package metrics
+
+import "strings"
+
+// deviceFilter decides which block devices or network interfaces get their
+// own metrics. Entries match a name exactly or, ending in "*", by prefix.
+type deviceFilter struct {
+	include []string // Only these when set
+	exclude []string
+}
+
+// newDeviceFilter keeps only names when it is set, and otherwise everything
+// but the excluded defaults.
+func newDeviceFilter(names, excluded []string) deviceFilter {
+	if len(names) > 0 {
+		return deviceFilter{include: names}
+	}
+	return deviceFilter{exclude: excluded}
+}
+
+func (f deviceFilter) allows(name string) bool {
+	if len(f.include) > 0 {
+		return matchesDevice(f.include, name)
+	}
+	return !matchesDevice(f.exclude, name)
+}
+
+func matchesDevice(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if pattern == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// diskSectorSize is the unit of the sector counts in /proc/diskstats, fixed
+// by the kernel regardless of the device's own sector size.
+const diskSectorSize = 512
+
+// defaultExcludedDisks are skipped when metrics.disk_devices is empty:
+// loop and RAM devices are not storage a workload is bound by.
+var defaultExcludedDisks = []string{"loop*", "ram*", "zram*"}
+
+// DiskIO holds one block device's cumulative I/O counters.
+type DiskIO struct {
+	ReadOps    uint64
+	WriteOps   uint64
+	ReadBytes  uint64
+	WriteBytes uint64
+}
+
+// parseDiskStats reads /proc/diskstats: major, minor, device name, then
+// reads completed, reads merged, sectors read, time reading, writes
+// completed, writes merged and sectors written, followed by fields that
+// vary by kernel version.
+func parseDiskStats(r io.Reader) (map[string]DiskIO, error) {
+	disks := make(map[string]DiskIO)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		var values [4]uint64
+		for i, idx := range []int{3, 5, 7, 9} {
+			v, err := strconv.ParseUint(fields[idx], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("parse diskstats for %s: %w", fields[2], err)
+			}
+			values[i] = v
+		}
+		disks[fields[2]] = DiskIO{
+			ReadOps:    values[0],
+			ReadBytes:  values[1] * diskSectorSize,
+			WriteOps:   values[2],
+			WriteBytes: values[3] * diskSectorSize,
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("scan diskstats: %w", err)
+	}
+	return disks, nil
+}
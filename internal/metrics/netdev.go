@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// defaultExcludedInterfaces are skipped when metrics.net_interfaces is
+// empty: loopback traffic never leaves the host.
+var defaultExcludedInterfaces = []string{"lo"}
+
+// NetIO holds one network interface's cumulative byte counters.
+type NetIO struct {
+	RxBytes uint64
+	TxBytes uint64
+}
+
+// parseNetDev reads /proc/net/dev: two header lines, then "iface:" followed
+// by eight receive and eight transmit counters, bytes first in each group.
+func parseNetDev(r io.Reader) (map[string]NetIO, error) {
+	interfaces := make(map[string]NetIO)
+	scanner := bufio.NewScanner(r)
+	for line := 0; scanner.Scan(); line++ {
+		if line < 2 {
+			continue
+		}
+		name, counters, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		name = strings.TrimSpace(name)
+		fields := strings.Fields(counters)
+		if len(fields) < 16 {
+			continue
+		}
+		rx, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse /proc/net/dev rx for %s: %w", name, err)
+		}
+		tx, err := strconv.ParseUint(fields[8], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse /proc/net/dev tx for %s: %w", name, err)
+		}
+		interfaces[name] = NetIO{RxBytes: rx, TxBytes: tx}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("scan /proc/net/dev: %w", err)
+	}
+	return interfaces, nil
+}
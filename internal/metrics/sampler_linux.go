@@ -27,13 +27,18 @@ type Counters struct {
 	DiskFree     uint64
 	NetRxBytes   uint64
 	NetTxBytes   uint64
+	Disks        map[string]DiskIO // By device name
+	Interfaces   map[string]NetIO  // By interface name, loopback included
 }
 
 func newSampler() (sampler, error) {
-	return &linuxSampler{}, nil
+	return &linuxSampler{readDisks: readDiskStats}, nil
 }
 
-type linuxSampler struct{}
+type linuxSampler struct {
+	readDisks   func() (map[string]DiskIO, error)
+	diskFailing bool // The last disk I/O read failed, already logged
+}
 
 func (s *linuxSampler) Read() (Counters, error) {
 	now := time.Now().UTC()
@@ -53,11 +58,22 @@ func (s *linuxSampler) Read() (Counters, error) {
 		return Counters{}, err
 	}
 
-	netRx, netTx, err := readNetDev()
+	interfaces, netRx, netTx, err := readNetDev()
 	if err != nil {
 		return Counters{}, err
 	}
 
+	// Disk I/O rates are optional; the other metrics are still emitted
+	disks, err := s.readDisks()
+	if err != nil {
+		if !s.diskFailing {
+			logger.Warnf("Disk I/O metrics unavailable: %v", err)
+		}
+		s.diskFailing = true
+	} else {
+		s.diskFailing = false
+	}
+
 	return Counters{
 		Timestamp:    now,
 		CPUTotal:     total,
@@ -68,6 +84,8 @@ func (s *linuxSampler) Read() (Counters, error) {
 		DiskFree:     diskFree,
 		NetRxBytes:   netRx,
 		NetTxBytes:   netTx,
+		Disks:        disks,
+		Interfaces:   interfaces,
 	}, nil
 }
 
@@ -156,47 +174,36 @@ func readDiskUsage(path string) (total uint64, free uint64, err error) {
 	return total, free, nil
 }
 
-func readNetDev() (rx uint64, tx uint64, err error) {
-	file, err := os.Open("/proc/net/dev")
+func readDiskStats() (map[string]DiskIO, error) {
+	file, err := os.Open("/proc/diskstats")
+	if os.IsNotExist(err) {
+		// Some sandboxes hide it; disk I/O metrics are simply left out
+		return nil, nil
+	}
 	if err != nil {
-		return 0, 0, fmt.Errorf("open /proc/net/dev: %w", err)
+		return nil, fmt.Errorf("open /proc/diskstats: %w", err)
 	}
 	defer file.Close()
+	return parseDiskStats(file)
+}
 
-	scanner := bufio.NewScanner(file)
-	// Skip two header lines
-	for i := 0; i < 2 && scanner.Scan(); i++ {
+func readNetDev() (interfaces map[string]NetIO, rx uint64, tx uint64, err error) {
+	file, err := os.Open("/proc/net/dev")
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("open /proc/net/dev: %w", err)
 	}
+	defer file.Close()
 
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" {
-			continue
-		}
-		parts := strings.Split(line, ":")
-		if len(parts) != 2 {
-			continue
-		}
-		iface := strings.TrimSpace(parts[0])
-		if iface == "lo" {
-			continue
-		}
-
-		fields := strings.Fields(parts[1])
-		if len(fields) < 16 {
+	interfaces, err = parseNetDev(file)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	for name, iface := range interfaces {
+		if name == "lo" {
 			continue
 		}
-
-		var ifaceRx, ifaceTx uint64
-		fmt.Sscanf(fields[0], "%d", &ifaceRx)
-		fmt.Sscanf(fields[8], "%d", &ifaceTx)
-
-		rx += ifaceRx
-		tx += ifaceTx
+		rx += iface.RxBytes
+		tx += iface.TxBytes
 	}
-	if err := scanner.Err(); err != nil {
-		return 0, 0, fmt.Errorf("scan /proc/net/dev: %w", err)
-	}
-
-	return rx, tx, nil
+	return interfaces, rx, tx, nil
 }
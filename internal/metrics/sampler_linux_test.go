@@ -0,0 +1,35 @@
+//go:build linux
+
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/yaat-app/sidecar/internal/logging"
+)
+
+func TestReadKeepsMetricsWhenDiskStatsFail(t *testing.T) {
+	var logs bytes.Buffer
+	logging.SetOutput(&logs)
+	defer logging.SetOutput(os.Stderr)
+
+	s := &linuxSampler{readDisks: func() (map[string]DiskIO, error) {
+		return nil, errors.New("open /proc/diskstats: permission denied")
+	}}
+	for i := 0; i < 2; i++ {
+		counters, err := s.Read()
+		if err != nil {
+			t.Fatalf("Expected the sample to succeed without disk stats, got %v", err)
+		}
+		if counters.CPUTotal == 0 || counters.MemTotal == 0 || counters.Disks != nil {
+			t.Errorf("Expected CPU and memory counters without disks, got %+v", counters)
+		}
+	}
+	if n := strings.Count(logs.String(), "Disk I/O metrics unavailable"); n != 1 {
+		t.Errorf("Expected the disk error logged once, got %d times:\n%s", n, logs.String())
+	}
+}
@@ -22,6 +22,8 @@ type Counters struct {
 	DiskFree     uint64
 	NetRxBytes   uint64
 	NetTxBytes   uint64
+	Disks        map[string]DiskIO // By device name
+	Interfaces   map[string]NetIO  // By interface name, loopback included
 }
 
 func newSampler() (sampler, error) {
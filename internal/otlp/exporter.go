@@ -0,0 +1,429 @@
+package otlp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+)
+
+const (
+	tracesPath  = "/v1/traces"
+	metricsPath = "/v1/metrics"
+	scopeName   = "yaat-sidecar"
+
+	// OTLP enums
+	spanKindServer       = 2
+	statusCodeError      = 2
+	temporalityDelta     = 1
+	defaultExportTimeout = 10 * time.Second
+)
+
+// Exporter sends span and metric events to an OpenTelemetry collector as OTLP/JSON.
+// Log events are not exported.
+type Exporter struct {
+	endpoint string
+	headers  map[string]string
+	client   *http.Client
+}
+
+// New creates an exporter for an OTLP/HTTP base endpoint such as http://localhost:4318.
+func New(endpoint string, headers map[string]string, timeout time.Duration) *Exporter {
+	if timeout <= 0 {
+		timeout = defaultExportTimeout
+	}
+	return &Exporter{
+		endpoint: strings.TrimRight(endpoint, "/"),
+		headers:  headers,
+		client:   &http.Client{Timeout: timeout},
+	}
+}
+
+// SetHTTPClient overrides the HTTP client (primarily for tests).
+func (e *Exporter) SetHTTPClient(client *http.Client) {
+	if client != nil {
+		e.client = client
+	}
+}
+
+// Send exports the span and metric events in a batch. Other event types are skipped.
+func (e *Exporter) Send(events []buffer.Event) error {
+	var spans, metrics []buffer.Event
+	for _, evt := range events {
+		switch stringField(evt, "event_type") {
+		case "span":
+			spans = append(spans, evt)
+		case "metric":
+			metrics = append(metrics, evt)
+		}
+	}
+
+	if len(spans) > 0 {
+		if err := e.post(tracesPath, buildTraces(spans)); err != nil {
+			return fmt.Errorf("export %d spans: %w", len(spans), err)
+		}
+	}
+	if len(metrics) > 0 {
+		if err := e.post(metricsPath, buildMetrics(metrics)); err != nil {
+			return fmt.Errorf("export %d metrics: %w", len(metrics), err)
+		}
+	}
+	return nil
+}
+
+func (e *Exporter) post(path string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal payload: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("collector returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// OTLP/JSON payload types. Only the fields the sidecar populates are modelled.
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"` // int64 is encoded as a string in OTLP/JSON
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type tracesPayload struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type scopeSpans struct {
+	Scope scope  `json:"scope"`
+	Spans []span `json:"spans"`
+}
+
+type span struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	Status            spanStatus `json:"status"`
+}
+
+type spanStatus struct {
+	Code int `json:"code,omitempty"`
+}
+
+type metricsPayload struct {
+	ResourceMetrics []resourceMetrics `json:"resourceMetrics"`
+}
+
+type resourceMetrics struct {
+	Resource     resource       `json:"resource"`
+	ScopeMetrics []scopeMetrics `json:"scopeMetrics"`
+}
+
+type scopeMetrics struct {
+	Scope   scope    `json:"scope"`
+	Metrics []metric `json:"metrics"`
+}
+
+type metric struct {
+	Name  string `json:"name"`
+	Unit  string `json:"unit,omitempty"`
+	Gauge *gauge `json:"gauge,omitempty"`
+	Sum   *sum   `json:"sum,omitempty"`
+}
+
+type gauge struct {
+	DataPoints []dataPoint `json:"dataPoints"`
+}
+
+type sum struct {
+	DataPoints             []dataPoint `json:"dataPoints"`
+	AggregationTemporality int         `json:"aggregationTemporality"`
+	IsMonotonic            bool        `json:"isMonotonic"`
+}
+
+type dataPoint struct {
+	TimeUnixNano string     `json:"timeUnixNano"`
+	AsDouble     float64    `json:"asDouble"`
+	Attributes   []keyValue `json:"attributes,omitempty"`
+}
+
+// resourceKey groups events that share a service and environment.
+type resourceKey struct {
+	service     string
+	environment string
+}
+
+func groupByResource(events []buffer.Event) ([]resourceKey, map[resourceKey][]buffer.Event) {
+	groups := make(map[resourceKey][]buffer.Event)
+	var order []resourceKey
+	for _, evt := range events {
+		key := resourceKey{service: stringField(evt, "service_name"), environment: stringField(evt, "environment")}
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], evt)
+	}
+	return order, groups
+}
+
+func (k resourceKey) resource() resource {
+	attrs := []keyValue{stringAttr("service.name", k.service)}
+	if k.environment != "" {
+		attrs = append(attrs, stringAttr("deployment.environment", k.environment))
+	}
+	return resource{Attributes: attrs}
+}
+
+func buildTraces(events []buffer.Event) tracesPayload {
+	order, groups := groupByResource(events)
+	payload := tracesPayload{ResourceSpans: make([]resourceSpans, 0, len(order))}
+	for _, key := range order {
+		spans := make([]span, 0, len(groups[key]))
+		for _, evt := range groups[key] {
+			spans = append(spans, toSpan(evt))
+		}
+		payload.ResourceSpans = append(payload.ResourceSpans, resourceSpans{
+			Resource:   key.resource(),
+			ScopeSpans: []scopeSpans{{Scope: scope{Name: scopeName}, Spans: spans}},
+		})
+	}
+	return payload
+}
+
+func toSpan(evt buffer.Event) span {
+	start := eventTime(evt)
+	duration := time.Duration(floatField(evt, "duration_ms") * float64(time.Millisecond))
+
+	s := span{
+		TraceID:           normalizeID(stringField(evt, "trace_id"), 16),
+		SpanID:            normalizeID(stringField(evt, "span_id"), 8),
+		Name:              stringField(evt, "operation"),
+		Kind:              spanKindServer,
+		StartTimeUnixNano: unixNano(start),
+		EndTimeUnixNano:   unixNano(start.Add(duration)),
+		Attributes:        tagAttributes(evt),
+	}
+	if parent := stringField(evt, "parent_span_id"); parent != "" {
+		s.ParentSpanID = normalizeID(parent, 8)
+	}
+	if s.Name == "" {
+		s.Name = "span"
+	}
+
+	if code := intField(evt, "status_code"); code > 0 {
+		s.Attributes = append(s.Attributes, intAttr("http.status_code", int64(code)))
+		if code >= 500 {
+			s.Status.Code = statusCodeError
+		}
+	}
+	return s
+}
+
+func buildMetrics(events []buffer.Event) metricsPayload {
+	order, groups := groupByResource(events)
+	payload := metricsPayload{ResourceMetrics: make([]resourceMetrics, 0, len(order))}
+	for _, key := range order {
+		// One OTLP metric per name, with a data point per event
+		byName := make(map[string]*metric)
+		var names []string
+		for _, evt := range groups[key] {
+			name := stringField(evt, "metric_name")
+			if name == "" {
+				continue
+			}
+			tags := tagMap(evt)
+			m, ok := byName[name]
+			if !ok {
+				m = &metric{Name: name, Unit: tags["unit"]}
+				if tags["statsd_type"] == "c" {
+					m.Sum = &sum{AggregationTemporality: temporalityDelta, IsMonotonic: true}
+				} else {
+					m.Gauge = &gauge{}
+				}
+				byName[name] = m
+				names = append(names, name)
+			}
+
+			point := dataPoint{
+				TimeUnixNano: unixNano(eventTime(evt)),
+				AsDouble:     floatField(evt, "metric_value"),
+				Attributes:   tagAttributes(evt),
+			}
+			if m.Sum != nil {
+				m.Sum.DataPoints = append(m.Sum.DataPoints, point)
+			} else {
+				m.Gauge.DataPoints = append(m.Gauge.DataPoints, point)
+			}
+		}
+
+		metrics := make([]metric, 0, len(names))
+		for _, name := range names {
+			metrics = append(metrics, *byName[name])
+		}
+		payload.ResourceMetrics = append(payload.ResourceMetrics, resourceMetrics{
+			Resource:     key.resource(),
+			ScopeMetrics: []scopeMetrics{{Scope: scope{Name: scopeName}, Metrics: metrics}},
+		})
+	}
+	return payload
+}
+
+// normalizeID renders an ID as the lowercase hex string of size bytes that
+// OTLP/JSON expects. UUIDs lose their dashes; other values are hashed and
+// missing values get a random ID.
+func normalizeID(raw string, size int) string {
+	cleaned := strings.ToLower(strings.ReplaceAll(raw, "-", ""))
+	if len(cleaned) == size*2 {
+		if _, err := hex.DecodeString(cleaned); err == nil {
+			return cleaned
+		}
+	}
+
+	id := make([]byte, size)
+	if raw == "" {
+		rand.Read(id)
+	} else {
+		sumBytes := sha256.Sum256([]byte(raw))
+		copy(id, sumBytes[:size])
+	}
+	return hex.EncodeToString(id)
+}
+
+func tagAttributes(evt buffer.Event) []keyValue {
+	tags := tagMap(evt)
+	if len(tags) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	attrs := make([]keyValue, 0, len(keys))
+	for _, k := range keys {
+		attrs = append(attrs, stringAttr(k, tags[k]))
+	}
+	return attrs
+}
+
+func tagMap(evt buffer.Event) map[string]string {
+	switch tags := evt["tags"].(type) {
+	case map[string]string:
+		return tags
+	case map[string]interface{}:
+		converted := make(map[string]string, len(tags))
+		for k, v := range tags {
+			converted[k] = fmt.Sprint(v)
+		}
+		return converted
+	}
+	return nil
+}
+
+func stringAttr(key, value string) keyValue {
+	return keyValue{Key: key, Value: anyValue{StringValue: &value}}
+}
+
+func intAttr(key string, value int64) keyValue {
+	encoded := strconv.FormatInt(value, 10)
+	return keyValue{Key: key, Value: anyValue{IntValue: &encoded}}
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func eventTime(evt buffer.Event) time.Time {
+	switch v := evt["timestamp"].(type) {
+	case time.Time:
+		return v
+	case string:
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return t
+		}
+	}
+	return time.Now()
+}
+
+func stringField(evt buffer.Event, key string) string {
+	if s, ok := evt[key].(string); ok {
+		return s
+	}
+	return ""
+}
+
+func floatField(evt buffer.Event, key string) float64 {
+	switch v := evt[key].(type) {
+	case float64:
+		return v
+	case float32:
+		return float64(v)
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	}
+	return 0
+}
+
+func intField(evt buffer.Event, key string) int {
+	switch v := evt[key].(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	}
+	return 0
+}
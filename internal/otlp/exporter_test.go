@@ -0,0 +1,143 @@
+package otlp
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+)
+
+func TestSendExportsSpansAndMetrics(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		payloads = make(map[string]map[string]interface{})
+		headers  http.Header
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var decoded map[string]interface{}
+		if err := json.Unmarshal(body, &decoded); err != nil {
+			t.Errorf("Invalid JSON for %s: %v", r.URL.Path, err)
+		}
+		mu.Lock()
+		payloads[r.URL.Path] = decoded
+		headers = r.Header.Clone()
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	exporter := New(server.URL+"/", map[string]string{"X-Team": "payments"}, 0)
+	err := exporter.Send([]buffer.Event{
+		{
+			"event_type":     "span",
+			"service_name":   "api",
+			"environment":    "staging",
+			"timestamp":      "2024-10-26T10:30:15Z",
+			"trace_id":       "4bf92f35-77b3-4da6-a3ce-929d0e0e4736",
+			"span_id":        "00f067aa0ba902b7",
+			"operation":      "GET /orders",
+			"duration_ms":    250.0,
+			"status_code":    503,
+			"tags":           map[string]string{"method": "GET"},
+			"parent_span_id": "",
+		},
+		{
+			"event_type":   "metric",
+			"service_name": "api",
+			"environment":  "staging",
+			"timestamp":    "2024-10-26T10:30:15Z",
+			"metric_name":  "app.requests",
+			"metric_value": 3.0,
+			"tags":         map[string]string{"statsd_type": "c"},
+		},
+		{
+			"event_type":   "metric",
+			"service_name": "api",
+			"environment":  "staging",
+			"timestamp":    "2024-10-26T10:30:15Z",
+			"metric_name":  "host.cpu.usage_percent",
+			"metric_value": 42.5,
+			"tags":         map[string]string{"unit": "percent"},
+		},
+		{"event_type": "log", "service_name": "api", "message": "ignored"},
+	})
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	if len(payloads) != 2 {
+		t.Fatalf("Expected traces and metrics requests, got %v", payloads)
+	}
+	if headers.Get("X-Team") != "payments" || headers.Get("Content-Type") != "application/json" {
+		t.Errorf("Unexpected headers: %v", headers)
+	}
+
+	resourceSpans := payloads["/v1/traces"]["resourceSpans"].([]interface{})[0].(map[string]interface{})
+	attrs := resourceSpans["resource"].(map[string]interface{})["attributes"].([]interface{})
+	if attrs[0].(map[string]interface{})["key"] != "service.name" {
+		t.Errorf("Expected service.name resource attribute, got %v", attrs)
+	}
+	span := resourceSpans["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})[0].(map[string]interface{})
+	if span["traceId"] != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Expected dashless trace ID, got %v", span["traceId"])
+	}
+	if span["spanId"] != "00f067aa0ba902b7" {
+		t.Errorf("Expected span ID to pass through, got %v", span["spanId"])
+	}
+	if span["startTimeUnixNano"] != "1729938615000000000" || span["endTimeUnixNano"] != "1729938615250000000" {
+		t.Errorf("Unexpected span times: %v - %v", span["startTimeUnixNano"], span["endTimeUnixNano"])
+	}
+	if span["name"] != "GET /orders" {
+		t.Errorf("Expected span name from operation, got %v", span["name"])
+	}
+	if code := span["status"].(map[string]interface{})["code"]; code != float64(statusCodeError) {
+		t.Errorf("Expected error status for 503, got %v", code)
+	}
+	if _, ok := span["parentSpanId"]; ok {
+		t.Errorf("Expected root span without parentSpanId, got %v", span["parentSpanId"])
+	}
+
+	metrics := payloads["/v1/metrics"]["resourceMetrics"].([]interface{})[0].(map[string]interface{})["scopeMetrics"].([]interface{})[0].(map[string]interface{})["metrics"].([]interface{})
+	if len(metrics) != 2 {
+		t.Fatalf("Expected 2 metrics, got %d", len(metrics))
+	}
+	counter := metrics[0].(map[string]interface{})
+	if counter["name"] != "app.requests" || counter["sum"] == nil {
+		t.Errorf("Expected StatsD counter as sum, got %v", counter)
+	}
+	gaugeMetric := metrics[1].(map[string]interface{})
+	if gaugeMetric["unit"] != "percent" || gaugeMetric["gauge"] == nil {
+		t.Errorf("Expected gauge with unit, got %v", gaugeMetric)
+	}
+}
+
+func TestSendReportsCollectorErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad payload", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	exporter := New(server.URL, nil, 0)
+	err := exporter.Send([]buffer.Event{{"event_type": "span", "service_name": "api", "operation": "x"}})
+	if err == nil {
+		t.Fatal("Expected error from collector")
+	}
+}
+
+func TestNormalizeID(t *testing.T) {
+	if got := normalizeID("00F067AA0BA902B7", 8); got != "00f067aa0ba902b7" {
+		t.Errorf("Expected lowercase hex, got %s", got)
+	}
+	hashed := normalizeID("req-123", 8)
+	if len(hashed) != 16 || hashed != normalizeID("req-123", 8) {
+		t.Errorf("Expected stable 16-char hash, got %s", hashed)
+	}
+	if random := normalizeID("", 16); len(random) != 32 {
+		t.Errorf("Expected 32-char random trace ID, got %s", random)
+	}
+}
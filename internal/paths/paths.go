@@ -0,0 +1,157 @@
+// Package paths decides where the sidecar keeps its files, so the daemon,
+// queue, state, analytics and uninstall agree on one layout.
+//
+// Data (queue, state, analytics) always lives in ~/.yaat, or ~/.yaat/<name>
+// for a named instance. The PID file and log go to /var/run and /var/log
+// when running as root and those are writable, and next to the data
+// otherwise.
+package paths
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// DefaultInstance is the name of the instance started without --instance.
+const DefaultInstance = "default"
+
+// dataDirName is the directory under the home directory holding all data.
+const dataDirName = ".yaat"
+
+// Layout lists where one sidecar instance keeps its files.
+type Layout struct {
+	DataDir     string
+	PIDFile     string
+	LogFile     string
+	QueueDir    string
+	StateFile   string
+	AnalyticsDB string
+	ControlSock string // Unix socket of --flush-now, --rotate-key and --events-tail
+	System      bool   // PIDFile and LogFile are in the system directories
+
+	// The other location of the PID file and log, where an earlier run with
+	// different privileges may have written them
+	altPIDFile string
+	altLogFile string
+}
+
+// PIDFiles returns where a running instance may have written its PID file,
+// the resolved location first.
+func (l Layout) PIDFiles() []string {
+	return candidates(l.PIDFile, l.altPIDFile)
+}
+
+// LogFiles returns where an instance may have written its log, the
+// resolved location first.
+func (l Layout) LogFiles() []string {
+	return candidates(l.LogFile, l.altLogFile)
+}
+
+func candidates(primary, alt string) []string {
+	if alt == "" || alt == primary {
+		return []string{primary}
+	}
+	return []string{primary, alt}
+}
+
+// WithLogFile returns the layout logging to path, as --log-file asks, with
+// no other log location to look in.
+func (l Layout) WithLogFile(path string) Layout {
+	l.LogFile, l.altLogFile = path, ""
+	return l
+}
+
+// Ensure creates the directories of the data, PID file and log.
+func (l Layout) Ensure() error {
+	for _, dir := range []string{l.DataDir, filepath.Dir(l.PIDFile), filepath.Dir(l.LogFile)} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("create %s: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+// Resolver maps instance names to layouts. Tests set the fields to point at
+// temporary directories.
+type Resolver struct {
+	Home       string // Home directory; empty puts the data under ./.yaat
+	SystemRoot string // Prefix of var/run and var/log; "/" outside tests
+	Privileged bool   // Whether the system directories may be used
+}
+
+// Detect returns the resolver for the current user.
+func Detect() Resolver {
+	home, _ := os.UserHomeDir()
+	return Resolver{Home: home, SystemRoot: "/", Privileged: os.Geteuid() == 0}
+}
+
+// Resolve returns the layout of instance. The system directories are probed
+// for writability on every call; resolve once at startup and pass the
+// layout on.
+func (r Resolver) Resolve(instance string) Layout {
+	home, system := r.HomeLayout(instance), r.SystemLayout(instance)
+	layout := home
+	layout.altPIDFile, layout.altLogFile = system.PIDFile, system.LogFile
+	if !r.Privileged {
+		return layout
+	}
+	if writable(filepath.Dir(system.PIDFile)) && writable(filepath.Dir(system.LogFile)) {
+		layout.PIDFile, layout.LogFile = system.PIDFile, system.LogFile
+		layout.altPIDFile, layout.altLogFile = home.PIDFile, home.LogFile
+		layout.System = true
+	}
+	return layout
+}
+
+// HomeLayout returns the layout with every file under the data directory.
+func (r Resolver) HomeLayout(instance string) Layout {
+	dataDir := filepath.Join(r.Home, dataDirName)
+	if instance != "" && instance != DefaultInstance {
+		dataDir = filepath.Join(dataDir, instance)
+	}
+	return Layout{
+		DataDir:     dataDir,
+		PIDFile:     filepath.Join(dataDir, "sidecar.pid"),
+		LogFile:     filepath.Join(dataDir, "sidecar.log"),
+		QueueDir:    filepath.Join(dataDir, "queue"),
+		StateFile:   filepath.Join(dataDir, "state.json"),
+		AnalyticsDB: filepath.Join(dataDir, "analytics.db"),
+		ControlSock: filepath.Join(dataDir, "control.sock"),
+	}
+}
+
+// SystemLayout returns the layout with the PID file and log in the system
+// directories, without checking that they are writable.
+func (r Resolver) SystemLayout(instance string) Layout {
+	root := r.SystemRoot
+	if root == "" {
+		root = "/"
+	}
+	name := "yaat-sidecar"
+	if instance != "" && instance != DefaultInstance {
+		name = "yaat-" + instance
+	}
+	layout := r.HomeLayout(instance)
+	layout.PIDFile = filepath.Join(root, "var", "run", name+".pid")
+	layout.LogFile = filepath.Join(root, "var", "log", name+".log")
+	layout.System = true
+	return layout
+}
+
+// Candidates returns the system and home layouts of instance, for finding
+// files any earlier run may have written.
+func (r Resolver) Candidates(instance string) []Layout {
+	return []Layout{r.SystemLayout(instance), r.HomeLayout(instance)}
+}
+
+// writable reports whether a file can be created in dir.
+func writable(dir string) bool {
+	probe, err := os.CreateTemp(dir, ".yaat-probe-*")
+	if err != nil {
+		return false
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	return true
+}
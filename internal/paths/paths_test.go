@@ -0,0 +1,118 @@
+package paths
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func systemRoot(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	for _, dir := range []string{"var/run", "var/log"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestResolveUnprivileged(t *testing.T) {
+	home, root := t.TempDir(), systemRoot(t)
+	layout := Resolver{Home: home, SystemRoot: root}.Resolve(DefaultInstance)
+
+	if layout.System {
+		t.Error("Expected the home layout without privileges")
+	}
+	if want := filepath.Join(home, ".yaat", "sidecar.pid"); layout.PIDFile != want {
+		t.Errorf("Expected PID file %s, got %s", want, layout.PIDFile)
+	}
+	if want := filepath.Join(home, ".yaat", "queue"); layout.QueueDir != want {
+		t.Errorf("Expected queue %s, got %s", want, layout.QueueDir)
+	}
+	logs := layout.LogFiles()
+	if len(logs) != 2 || logs[1] != filepath.Join(root, "var", "log", "yaat-sidecar.log") {
+		t.Errorf("Expected the system log as the alternative, got %v", logs)
+	}
+}
+
+func TestResolvePrivileged(t *testing.T) {
+	home, root := t.TempDir(), systemRoot(t)
+	layout := Resolver{Home: home, SystemRoot: root, Privileged: true}.Resolve(DefaultInstance)
+
+	if !layout.System {
+		t.Fatal("Expected the system layout when var/run and var/log are writable")
+	}
+	if want := filepath.Join(root, "var", "run", "yaat-sidecar.pid"); layout.PIDFile != want {
+		t.Errorf("Expected PID file %s, got %s", want, layout.PIDFile)
+	}
+	if want := filepath.Join(home, ".yaat", "state.json"); layout.StateFile != want {
+		t.Errorf("Expected data to stay in home, got %s", layout.StateFile)
+	}
+	pids := layout.PIDFiles()
+	if len(pids) != 2 || pids[1] != filepath.Join(home, ".yaat", "sidecar.pid") {
+		t.Errorf("Expected the home PID file as the alternative, got %v", pids)
+	}
+
+	entries, _ := os.ReadDir(filepath.Join(root, "var", "run"))
+	if len(entries) != 0 {
+		t.Errorf("Expected the writability probe to clean up, found %d files", len(entries))
+	}
+}
+
+func TestResolvePrivilegedFallsBack(t *testing.T) {
+	home := t.TempDir()
+	// var/log is missing, so the system directories are not usable
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "var", "run"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	layout := Resolver{Home: home, SystemRoot: root, Privileged: true}.Resolve(DefaultInstance)
+	if layout.System {
+		t.Error("Expected the home layout when var/log is unusable")
+	}
+	if want := filepath.Join(home, ".yaat", "sidecar.log"); layout.LogFile != want {
+		t.Errorf("Expected log %s, got %s", want, layout.LogFile)
+	}
+}
+
+func TestResolveNamedInstance(t *testing.T) {
+	home, root := t.TempDir(), systemRoot(t)
+	r := Resolver{Home: home, SystemRoot: root, Privileged: true}
+
+	layout := r.Resolve("api")
+	if want := filepath.Join(home, ".yaat", "api", "queue"); layout.QueueDir != want {
+		t.Errorf("Expected queue %s, got %s", want, layout.QueueDir)
+	}
+	if want := filepath.Join(root, "var", "run", "yaat-api.pid"); layout.PIDFile != want {
+		t.Errorf("Expected PID file %s, got %s", want, layout.PIDFile)
+	}
+	if want := filepath.Join(home, ".yaat", "api", "control.sock"); layout.ControlSock != want {
+		t.Errorf("Expected control socket %s, got %s", want, layout.ControlSock)
+	}
+
+	custom := layout.WithLogFile("/tmp/custom.log")
+	if logs := custom.LogFiles(); len(logs) != 1 || logs[0] != "/tmp/custom.log" {
+		t.Errorf("Expected only the --log-file path, got %v", logs)
+	}
+}
+
+func TestEnsure(t *testing.T) {
+	layout := Resolver{Home: t.TempDir()}.Resolve(DefaultInstance)
+	if err := layout.Ensure(); err != nil {
+		t.Fatalf("Ensure failed: %v", err)
+	}
+	if info, err := os.Stat(layout.DataDir); err != nil || !info.IsDir() {
+		t.Errorf("Expected %s to exist, got %v", layout.DataDir, err)
+	}
+
+	// A file where a directory should be is reported, not ignored
+	blocker := filepath.Join(t.TempDir(), "home")
+	if err := os.WriteFile(blocker, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := (Resolver{Home: blocker}.Resolve(DefaultInstance)).Ensure(); err == nil {
+		t.Error("Expected an error when the data directory cannot be created")
+	}
+}
@@ -0,0 +1,72 @@
+package pipeline
+
+import (
+	"sync"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+	"github.com/yaat-app/sidecar/internal/diag"
+	"github.com/yaat-app/sidecar/internal/forwarder"
+	"github.com/yaat-app/sidecar/internal/state"
+)
+
+// recordSendResult updates delivery diagnostics for a Send of events and
+// returns the events that were not delivered and still need persisting.
+func recordSendResult(err error, events []buffer.Event) []buffer.Event {
+	failed := forwarder.Undelivered(err, events)
+	if delivered := len(events) - len(failed); delivered > 0 {
+		diag.Global().RecordSendSuccess(delivered)
+	}
+	if len(failed) > 0 {
+		diag.Global().RecordSendFailure(err, forwarder.Classify(err), len(failed))
+	}
+	deliveryIncidents.record(err, len(failed))
+	return failed
+}
+
+// deliveryIncidents records outages in the state file for the TUI history.
+var deliveryIncidents incidentTracker
+
+// incidentTracker opens an incident when sends start failing and resolves it,
+// with the number of events that failed meanwhile, once a send succeeds.
+type incidentTracker struct {
+	mu      sync.Mutex
+	loaded  bool
+	failing bool
+	queued  int
+}
+
+func (t *incidentTracker) record(err error, failed int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// An incident left open by a previous run is still the same outage
+	if !t.loaded {
+		t.loaded = true
+		if st, loadErr := state.Load(); loadErr == nil && len(st.Incidents) > 0 {
+			t.failing = st.Incidents[len(st.Incidents)-1].Ongoing()
+		}
+	}
+
+	now := time.Now()
+	switch {
+	case failed > 0 && !t.failing:
+		t.failing = true
+		t.queued = failed
+		msg := ""
+		if err != nil {
+			msg = err.Error()
+		}
+		if recordErr := state.RecordIncidentStart(now, msg); recordErr != nil {
+			logger.Warnf("Failed to record delivery incident: %v", recordErr)
+		}
+	case failed > 0:
+		t.queued += failed
+	case t.failing:
+		t.failing = false
+		if recordErr := state.RecordIncidentEnd(now, t.queued); recordErr != nil {
+			logger.Warnf("Failed to record delivery recovery: %v", recordErr)
+		}
+		t.queued = 0
+	}
+}
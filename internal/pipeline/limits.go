@@ -0,0 +1,144 @@
+package pipeline
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+	"github.com/yaat-app/sidecar/internal/diag"
+	"github.com/yaat-app/sidecar/internal/logging"
+)
+
+var limitsLogger = logging.New("Limits")
+
+// Drop reasons reported in diag.
+const (
+	DropMinLevel  = "min_level"
+	DropEventType = "event_type"
+	DropRateLimit = "rate_limit"
+
+	// Dead-lettered events dropped after delivery.dlq_max_retries
+	DropDeadLetterRetries = "dlq_max_retries"
+)
+
+// levelRank orders log levels for min_level; unknown levels are never dropped.
+var levelRank = map[string]int{
+	"debug":    0,
+	"info":     1,
+	"warn":     2,
+	"warning":  2,
+	"error":    3,
+	"critical": 4,
+	"fatal":    4,
+}
+
+// Limits caps what reaches the buffer, to control ingest costs.
+type Limits struct {
+	MinLevel           string   // Drop log events below this level
+	MaxEventsPerMinute int      // Global cap across all sources (0 disables)
+	DropEventTypes     []string // Drop every event of these types
+	AlwaysKeepErrors   bool     // Error and critical logs bypass the per-minute cap
+}
+
+// Active reports whether any limit is set.
+func (l Limits) Active() bool {
+	return l.MinLevel != "" || l.MaxEventsPerMinute > 0 || len(l.DropEventTypes) > 0
+}
+
+// Limiter enforces Limits; install it with buffer.SetFilter(limiter.Allow).
+type Limiter struct {
+	minRank    int
+	hasMin     bool
+	dropTypes  map[string]bool
+	perMinute  int
+	keepErrors bool
+
+	mu       sync.Mutex
+	tokens   float64
+	refilled time.Time
+	warnedAt time.Time
+	overflow int // Rate-limited events since the last warning
+	now      func() time.Time
+}
+
+// NewLimiter creates a Limiter with a full per-minute allowance.
+func NewLimiter(limits Limits) *Limiter {
+	l := &Limiter{
+		dropTypes:  make(map[string]bool, len(limits.DropEventTypes)),
+		perMinute:  limits.MaxEventsPerMinute,
+		keepErrors: limits.AlwaysKeepErrors,
+		tokens:     float64(limits.MaxEventsPerMinute),
+		now:        time.Now,
+	}
+	if rank, ok := levelRank[strings.ToLower(limits.MinLevel)]; ok {
+		l.minRank, l.hasMin = rank, true
+	}
+	for _, eventType := range limits.DropEventTypes {
+		l.dropTypes[strings.ToLower(eventType)] = true
+	}
+	l.refilled = l.now()
+	return l
+}
+
+// Allow reports whether event should be buffered, counting drops in diag.
+func (l *Limiter) Allow(event buffer.Event) bool {
+	eventType, _ := event["event_type"].(string)
+	eventType = strings.ToLower(eventType)
+	if eventType == "" {
+		eventType = "log"
+	}
+	if l.dropTypes[eventType] {
+		diag.Global().RecordDropped(DropEventType, 1)
+		return false
+	}
+
+	rank, known := -1, false
+	if eventType == "log" {
+		level, _ := event["level"].(string)
+		rank, known = levelRank[strings.ToLower(level)]
+		if l.hasMin && known && rank < l.minRank {
+			diag.Global().RecordDropped(DropMinLevel, 1)
+			return false
+		}
+	}
+
+	if l.perMinute <= 0 {
+		return true
+	}
+	if l.keepErrors && known && rank >= levelRank["error"] {
+		return true
+	}
+	if l.take() {
+		return true
+	}
+	diag.Global().RecordDropped(DropRateLimit, 1)
+	return false
+}
+
+// take spends one token from the bucket, which refills at perMinute per
+// minute up to perMinute, and warns at most once a minute while empty.
+func (l *Limiter) take() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.tokens += now.Sub(l.refilled).Minutes() * float64(l.perMinute)
+	if max := float64(l.perMinute); l.tokens > max {
+		l.tokens = max
+	}
+	l.refilled = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return true
+	}
+
+	l.overflow++
+	if now.Sub(l.warnedAt) >= time.Minute {
+		limitsLogger.Warnf("Dropped %d events over limits.max_events_per_minute (%d)", l.overflow, l.perMinute)
+		l.warnedAt = now
+		l.overflow = 0
+	}
+	return false
+}
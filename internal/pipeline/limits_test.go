@@ -0,0 +1,174 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+	"github.com/yaat-app/sidecar/internal/diag"
+)
+
+func logEvent(level string) buffer.Event {
+	return buffer.Event{"event_type": "log", "level": level, "message": "m"}
+}
+
+// newTestLimiter returns a limiter on a clock that only moves when advanced.
+func newTestLimiter(limits Limits) (*Limiter, func(time.Duration)) {
+	now := time.Date(2025, 10, 1, 12, 0, 0, 0, time.UTC)
+	l := NewLimiter(limits)
+	l.now = func() time.Time { return now }
+	l.refilled = now
+	return l, func(d time.Duration) { now = now.Add(d) }
+}
+
+func droppedSince(before diag.Snapshot, reason string) int64 {
+	return diag.Global().Snapshot().DroppedEvents[reason] - before.DroppedEvents[reason]
+}
+
+func TestLimiterMinLevel(t *testing.T) {
+	l, _ := newTestLimiter(Limits{MinLevel: "info"})
+	before := diag.Global().Snapshot()
+
+	if l.Allow(logEvent("debug")) {
+		t.Error("Expected debug log to be dropped")
+	}
+	if l.Allow(logEvent("DEBUG")) {
+		t.Error("Expected level matching to ignore case")
+	}
+	for _, level := range []string{"info", "warning", "error", "", "trace2"} {
+		if !l.Allow(logEvent(level)) {
+			t.Errorf("Expected %q log to be kept", level)
+		}
+	}
+	if !l.Allow(buffer.Event{"event_type": "span", "level": "debug"}) {
+		t.Error("Expected min_level to apply only to logs")
+	}
+	if got := droppedSince(before, DropMinLevel); got != 2 {
+		t.Errorf("Expected 2 min_level drops, got %d", got)
+	}
+}
+
+func TestLimiterDropEventTypes(t *testing.T) {
+	l, _ := newTestLimiter(Limits{DropEventTypes: []string{"span"}})
+	before := diag.Global().Snapshot()
+
+	if l.Allow(buffer.Event{"event_type": "span"}) {
+		t.Error("Expected span to be dropped")
+	}
+	if !l.Allow(buffer.Event{"event_type": "metric"}) || !l.Allow(logEvent("error")) {
+		t.Error("Expected other event types to be kept")
+	}
+	if got := droppedSince(before, DropEventType); got != 1 {
+		t.Errorf("Expected 1 event_type drop, got %d", got)
+	}
+}
+
+func TestLimiterRateLimitRefills(t *testing.T) {
+	l, advance := newTestLimiter(Limits{MaxEventsPerMinute: 60})
+	before := diag.Global().Snapshot()
+
+	kept := 0
+	for i := 0; i < 100; i++ {
+		if l.Allow(logEvent("info")) {
+			kept++
+		}
+	}
+	if kept != 60 {
+		t.Errorf("Expected 60 events within the cap, got %d", kept)
+	}
+	if got := droppedSince(before, DropRateLimit); got != 40 {
+		t.Errorf("Expected 40 rate_limit drops, got %d", got)
+	}
+
+	// One token per second at 60/min
+	advance(10 * time.Second)
+	kept = 0
+	for i := 0; i < 20; i++ {
+		if l.Allow(logEvent("info")) {
+			kept++
+		}
+	}
+	if kept != 10 {
+		t.Errorf("Expected 10 events after a 10s refill, got %d", kept)
+	}
+
+	// The bucket never holds more than a minute's worth
+	advance(time.Hour)
+	kept = 0
+	for i := 0; i < 100; i++ {
+		if l.Allow(logEvent("info")) {
+			kept++
+		}
+	}
+	if kept != 60 {
+		t.Errorf("Expected the refill to stop at 60, got %d", kept)
+	}
+}
+
+func TestLimiterAlwaysKeepErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		keepErrors bool
+		level      string
+		want       bool
+	}{
+		{"error bypasses the cap", true, "error", true},
+		{"critical bypasses the cap", true, "critical", true},
+		{"warning stays capped", true, "warning", false},
+		{"error capped without keep", false, "error", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, _ := newTestLimiter(Limits{MaxEventsPerMinute: 1, AlwaysKeepErrors: tt.keepErrors})
+			l.Allow(logEvent("info")) // Spend the only token
+			if got := l.Allow(logEvent(tt.level)); got != tt.want {
+				t.Errorf("Expected Allow=%v for %s, got %v", tt.want, tt.level, got)
+			}
+		})
+	}
+}
+
+func TestLimiterAlwaysKeepErrorsWithMinLevel(t *testing.T) {
+	// min_level above error still drops errors: always_keep_errors only
+	// exempts them from the rate cap
+	l, _ := newTestLimiter(Limits{MinLevel: "critical", MaxEventsPerMinute: 1, AlwaysKeepErrors: true})
+	before := diag.Global().Snapshot()
+
+	if l.Allow(logEvent("error")) {
+		t.Error("Expected error below min_level to be dropped")
+	}
+	if l.Allow(logEvent("info")) {
+		t.Error("Expected info below min_level to be dropped")
+	}
+	if !l.Allow(logEvent("critical")) {
+		t.Error("Expected critical to be kept")
+	}
+	if got := droppedSince(before, DropMinLevel); got != 2 {
+		t.Errorf("Expected 2 min_level drops, got %d", got)
+	}
+	if got := droppedSince(before, DropRateLimit); got != 0 {
+		t.Errorf("Expected no rate_limit drops, got %d", got)
+	}
+
+	// Errors that pass min_level are never rate limited
+	l, _ = newTestLimiter(Limits{MinLevel: "warning", MaxEventsPerMinute: 1, AlwaysKeepErrors: true})
+	l.Allow(logEvent("warning"))
+	for i := 0; i < 5; i++ {
+		if !l.Allow(logEvent("error")) {
+			t.Fatal("Expected errors to bypass an exhausted cap")
+		}
+	}
+	if l.Allow(logEvent("warning")) {
+		t.Error("Expected warnings to stay capped")
+	}
+}
+
+func TestLimiterFiltersBuffer(t *testing.T) {
+	buf := buffer.New(10)
+	buf.SetFilter(NewLimiter(Limits{MinLevel: "info"}).Allow)
+	buf.Add(logEvent("debug"))
+	buf.Add(logEvent("info"))
+	if buf.Len() != 1 {
+		t.Errorf("Expected 1 buffered event, got %d", buf.Len())
+	}
+}
@@ -0,0 +1,491 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+	"github.com/yaat-app/sidecar/internal/control"
+	"github.com/yaat-app/sidecar/internal/diag"
+	"github.com/yaat-app/sidecar/internal/forwarder"
+	"github.com/yaat-app/sidecar/internal/logging"
+	"github.com/yaat-app/sidecar/internal/queue"
+	"github.com/yaat-app/sidecar/internal/routing"
+)
+
+var (
+	logger          = logging.New("Flusher")
+	sidecarLogger   = logging.New("Sidecar")
+	analyticsLogger = logging.New("Analytics")
+	otlpLogger      = logging.New("OTLP")
+)
+
+// Failure reasons passed to FailureRecorder.Record
+const (
+	FailureDeadLettered = "dead_lettered" // Moved to the dead-letter queue
+	FailureQueueExpired = "queue_expired" // Removed by queue retention before delivery
+	FailureNotQueued    = "not_queued"    // Send failed and there was no queue to keep it
+)
+
+// AnalyticsWriter stores events locally, as *analytics.Writer does.
+type AnalyticsWriter interface {
+	Write(events []buffer.Event) error
+}
+
+// FailureRecorder keeps batches whose delivery failed for good, as
+// *FailureRecorder does.
+type FailureRecorder interface {
+	Record(events []buffer.Event, reason, deliveryErr string)
+}
+
+// Exporter sends events to a secondary collector, as *otlp.Exporter does.
+type Exporter interface {
+	Send(events []buffer.Event) error
+}
+
+// Options configures a Pipeline.
+type Options struct {
+	Buffer    *buffer.Buffer
+	Forwarder *forwarder.Forwarder
+	Queue     *queue.Storage  // Failed sends are persisted here when set
+	Analytics AnalyticsWriter // Optional local analytics
+	Failures  FailureRecorder // Optional record of undelivered batches
+	OTLP      Exporter        // Optional OpenTelemetry export
+
+	// APIKey empty runs local-only: events reach analytics and OTLP but are
+	// not sent to the YAAT API
+	APIKey string
+
+	FlushInterval       time.Duration
+	QueueRetention      time.Duration // 0 keeps pending batches forever
+	DeadLetterRetention time.Duration // 0 keeps dead-lettered batches forever
+
+	// DeadLetterMaxRetries above 0 requeues dead-lettered batches every
+	// DeadLetterRetryInterval until they failed that many more times
+	DeadLetterMaxRetries    int
+	DeadLetterRetryInterval time.Duration
+}
+
+// Pipeline moves buffered events to local analytics, the OTLP collector and
+// the YAAT API. Sends that fail are persisted to the queue and retried from
+// it on every interval.
+type Pipeline struct {
+	buf       *buffer.Buffer
+	fwd       *forwarder.Forwarder
+	store     *queue.Storage
+	analytics AnalyticsWriter
+	exporter  Exporter
+	opts      Options
+
+	requests chan flushRequest
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+
+	lastDeadLetterRetry time.Time // Owned by the flusher goroutine
+}
+
+// Stats describes the events a pipeline is holding.
+type Stats struct {
+	Buffered   int
+	Capacity   int // buffer_size
+	Peak       int // Most events buffered at once
+	Persisted  diag.QueueStats
+	DeadLetter diag.QueueStats
+}
+
+// flushRequest asks the flusher goroutine for an immediate flush outside the ticker.
+type flushRequest struct {
+	reply chan control.FlushResult
+}
+
+// New creates a Pipeline and reports its initial queue state to diag.
+func New(opts Options) *Pipeline {
+	p := &Pipeline{
+		buf:       opts.Buffer,
+		fwd:       opts.Forwarder,
+		store:     opts.Queue,
+		analytics: opts.Analytics,
+		exporter:  opts.OTLP,
+		opts:      opts,
+		requests:  make(chan flushRequest),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if opts.Failures != nil && opts.Queue != nil {
+		opts.Queue.SetExpiredHandler(func(events []buffer.Event) {
+			opts.Failures.Record(events, FailureQueueExpired, "")
+		})
+	}
+	p.publishStats()
+	return p
+}
+
+// Start runs the flusher until ctx is done or Drain is called. It drains the
+// persistent queue first, then flushes on every interval, whenever the
+// buffer crosses its high watermark and on FlushNow.
+func (p *Pipeline) Start(ctx context.Context) {
+	go p.run(ctx)
+}
+
+func (p *Pipeline) run(ctx context.Context) {
+	defer close(p.done)
+	interval := p.opts.FlushInterval
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var lastFlush time.Time
+
+	p.drainQueue(time.Time{})
+	p.publishStats()
+	p.cleanupQueues()
+	p.lastDeadLetterRetry = time.Now()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.retryDeadLetter()
+			p.drainQueue(time.Time{})
+			p.publishStats()
+			p.Flush()
+			lastFlush = time.Now()
+			p.cleanupQueues()
+
+			// Follow the API's minimum flush interval as it changes
+			if next := max(p.opts.FlushInterval, p.minFlushInterval()); next != interval {
+				logger.Infof("Flush interval now %v", next)
+				interval = next
+				ticker.Reset(interval)
+			}
+
+		case <-p.buf.FlushSignal():
+			// Burst filled the buffer past its high watermark; the ticker stays as a floor
+			if time.Since(lastFlush) < p.minFlushInterval() {
+				continue
+			}
+			p.Flush()
+			lastFlush = time.Now()
+
+		case req := <-p.requests:
+			logger.Infof("Flush requested via control socket")
+			var result control.FlushResult
+			drained, drainErr := p.drainQueue(time.Time{})
+			result.Drained = drained
+			flushed, flushErr := p.Flush()
+			result.Flushed = flushed
+			if err := errors.Join(drainErr, flushErr); err != nil {
+				result.Error = err.Error()
+			}
+			req.reply <- result
+
+		case <-ctx.Done():
+			logger.Infof("Stopped")
+			return
+		case <-p.stop:
+			logger.Infof("Stopped")
+			return
+		}
+	}
+}
+
+// minFlushInterval is the shortest time between flushes the API asked for.
+func (p *Pipeline) minFlushInterval() time.Duration {
+	if p.fwd == nil || p.opts.APIKey == "" {
+		return 0
+	}
+	return p.fwd.MinFlushInterval()
+}
+
+// FlushNow hands a flush to the running flusher so out-of-band flushes never
+// race with the periodic one. It is a control.FlushFunc.
+func (p *Pipeline) FlushNow(ctx context.Context) control.FlushResult {
+	req := flushRequest{reply: make(chan control.FlushResult, 1)}
+	select {
+	case p.requests <- req:
+	case <-p.stop:
+		return control.FlushResult{Error: "sidecar is shutting down"}
+	case <-p.done:
+		return control.FlushResult{Error: "sidecar is shutting down"}
+	case <-ctx.Done():
+		return control.FlushResult{Error: ctx.Err().Error()}
+	}
+	select {
+	case result := <-req.reply:
+		return result
+	case <-ctx.Done():
+		return control.FlushResult{Error: ctx.Err().Error()}
+	}
+}
+
+// ErrNotQueued is wrapped by Flush errors when events that failed to send
+// could not be written to the persistent queue either.
+var ErrNotQueued = errors.New("not queued")
+
+// Flush empties the buffer into local analytics, the OTLP collector and the
+// cloud API on the caller's goroutine. Events that fail to send are persisted
+// for a later retry. It returns the number of events taken from the buffer.
+func (p *Pipeline) Flush() (int, error) {
+	events := p.buf.Flush()
+	p.publishStats()
+	if len(events) == 0 {
+		return 0, nil
+	}
+
+	logger.Debugf("Flushing %d events...", len(events))
+	routing.ApplyBatch(events)
+
+	// Write to local analytics (async, non-blocking)
+	if p.analytics != nil {
+		if err := p.analytics.Write(events); err != nil {
+			analyticsLogger.Errorf("Write failed: %v", err)
+		}
+	}
+
+	p.exportOTLP(events)
+
+	// Forward to cloud API (only if api_key is set)
+	if p.opts.APIKey == "" {
+		// Local-only mode - no cloud forwarding
+		logger.Debugf("Local-only mode: %d events stored locally", len(events))
+		return len(events), nil
+	}
+
+	err := p.fwd.Send(events)
+	if failed := recordSendResult(err, events); len(failed) > 0 {
+		logger.Errorf("Failed to send events: %v", err)
+		if p.store != nil {
+			if enqueueErr := p.store.Enqueue(failed); enqueueErr != nil {
+				logger.Errorf("Failed to enqueue events to persistent queue: %v", enqueueErr)
+				p.recordFailure(failed, FailureNotQueued, err.Error())
+				p.publishStats()
+				return len(events), fmt.Errorf("send failed: %w (%w: %v)", err, ErrNotQueued, enqueueErr)
+			}
+			p.publishStats()
+		} else {
+			p.recordFailure(failed, FailureNotQueued, err.Error())
+		}
+		return len(events), fmt.Errorf("send failed: %w", err)
+	}
+	return len(events), nil
+}
+
+// Drain stops the flusher, then empties the buffer and drains the persistent
+// queue until ctx is done. Buffered events are persisted before any network
+// call so a timeout mid-send leaves them queued rather than lost; a batch
+// still in flight at exit is recovered by queue.New on the next start.
+func (p *Pipeline) Drain(ctx context.Context) error {
+	p.stopOnce.Do(func() { close(p.stop) })
+	deadline, _ := ctx.Deadline()
+
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		p.shutdownFlush(deadline)
+	}()
+
+	var err error
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	p.publishStats()
+	return err
+}
+
+func (p *Pipeline) shutdownFlush(deadline time.Time) {
+	events := p.buf.Flush()
+	p.publishStats()
+	if len(events) > 0 {
+		sidecarLogger.Infof("Flushing %d remaining events...", len(events))
+		routing.ApplyBatch(events)
+
+		// Write to local analytics
+		if p.analytics != nil {
+			if err := p.analytics.Write(events); err != nil {
+				analyticsLogger.Errorf("Shutdown write failed: %v", err)
+			}
+		}
+
+		p.exportOTLP(events)
+	}
+
+	// Forward to cloud (only if api_key is set)
+	if p.opts.APIKey == "" {
+		return
+	}
+	if p.store == nil {
+		if len(events) > 0 {
+			err := p.fwd.Send(events)
+			if failed := recordSendResult(err, events); len(failed) > 0 {
+				sidecarLogger.Errorf("Failed to flush events: %v", err)
+				p.recordFailure(failed, FailureNotQueued, err.Error())
+			}
+		}
+		return
+	}
+
+	if err := p.store.Enqueue(events); err != nil {
+		sidecarLogger.Errorf("Failed to enqueue events to persistent queue: %v", err)
+		err = p.fwd.Send(events)
+		if failed := recordSendResult(err, events); len(failed) > 0 {
+			sidecarLogger.Errorf("Failed to flush events: %v", err)
+			p.recordFailure(failed, FailureNotQueued, err.Error())
+		}
+	}
+	if drained, err := p.drainQueue(deadline); drained > 0 || err != nil {
+		sidecarLogger.Infof("Drained %d queued events before exit", drained)
+	}
+}
+
+// drainQueue delivers persisted batches until the queue is empty, a send
+// fails or deadline passes, and returns the number of events delivered. A
+// zero deadline never expires.
+func (p *Pipeline) drainQueue(deadline time.Time) (int, error) {
+	if p.store == nil {
+		return 0, nil
+	}
+
+	drained := 0
+	for {
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return drained, nil
+		}
+		token, events, err := p.store.Dequeue()
+		if err != nil {
+			logger.Errorf("Failed to dequeue persistent batch: %v", err)
+			return drained, fmt.Errorf("dequeue failed: %w", err)
+		}
+		if events == nil {
+			return drained, nil
+		}
+
+		err = p.fwd.Send(events)
+		if failed := recordSendResult(err, events); len(failed) > 0 {
+			logger.Errorf("Failed to send persisted batch: %v", err)
+			// Only the undelivered part of a partially sent batch is dead-lettered
+			attempt := queue.Attempt{Error: err.Error(), StatusCode: forwarder.StatusCode(err)}
+			var moveErr error
+			if len(failed) < len(events) {
+				drained += len(events) - len(failed)
+				moveErr = p.store.MoveEventsToDLQ(token, failed, attempt)
+			} else {
+				moveErr = p.store.MoveToDLQ(token, attempt)
+			}
+			if moveErr != nil {
+				logger.Errorf("Failed to move batch to DLQ: %v", moveErr)
+			} else {
+				p.recordFailure(failed, FailureDeadLettered, err.Error())
+			}
+			p.publishStats()
+			return drained, fmt.Errorf("send of persisted batch failed: %w", err)
+		}
+
+		drained += len(events)
+		if ackErr := p.store.Ack(token); ackErr != nil {
+			logger.Errorf("Failed to ack batch: %v", ackErr)
+		}
+		p.publishStats()
+	}
+}
+
+// retryDeadLetter requeues dead-lettered batches for the drain that follows,
+// once every DeadLetterRetryInterval, and drops those out of retries.
+func (p *Pipeline) retryDeadLetter() {
+	if p.store == nil || p.opts.APIKey == "" || p.opts.DeadLetterMaxRetries <= 0 {
+		return
+	}
+	if time.Since(p.lastDeadLetterRetry) < p.opts.DeadLetterRetryInterval {
+		return
+	}
+	p.lastDeadLetterRetry = time.Now()
+
+	retried, dropped, err := p.store.RetryDeadLetter(p.opts.DeadLetterMaxRetries)
+	if err != nil {
+		logger.Errorf("Failed to retry dead-lettered batches: %v", err)
+	}
+	if retried.Batches > 0 {
+		logger.Infof("Retrying %d dead-lettered batches (%d events)", retried.Batches, retried.Events)
+	}
+	if dropped.Events > 0 {
+		diag.Global().RecordDropped(DropDeadLetterRetries, dropped.Events)
+	}
+	p.publishStats()
+}
+
+func (p *Pipeline) cleanupQueues() {
+	if p.store == nil {
+		return
+	}
+	if p.opts.QueueRetention <= 0 && p.opts.DeadLetterRetention <= 0 {
+		return
+	}
+	if err := p.store.Cleanup(p.opts.QueueRetention, p.opts.DeadLetterRetention); err != nil {
+		sidecarLogger.Errorf("Failed to cleanup queue storage: %v", err)
+	}
+}
+
+// recordFailure hands events whose delivery failed for good to the optional
+// failure recorder.
+func (p *Pipeline) recordFailure(events []buffer.Event, reason, deliveryErr string) {
+	if p.opts.Failures != nil {
+		p.opts.Failures.Record(events, reason, deliveryErr)
+	}
+}
+
+// exportOTLP sends spans and metrics to the OpenTelemetry collector. Failures
+// are logged only; the collector is a secondary target without persistence.
+func (p *Pipeline) exportOTLP(events []buffer.Event) {
+	if p.exporter == nil {
+		return
+	}
+	if err := p.exporter.Send(events); err != nil {
+		otlpLogger.Errorf("Export failed: %v", err)
+	}
+}
+
+// Stats returns the buffered event count and the on-disk queue summaries.
+func (p *Pipeline) Stats() Stats {
+	var stats Stats
+	if p.buf != nil {
+		stats.Buffered = p.buf.Len()
+		stats.Capacity = p.buf.Cap()
+		stats.Peak = p.buf.Peak()
+	}
+	if p.store == nil {
+		return stats
+	}
+	pending, err := p.store.PendingSummary()
+	if err != nil {
+		sidecarLogger.Errorf("Failed to inspect persistent queue: %v", err)
+	}
+	deadLetter, err := p.store.DeadLetterSummary()
+	if err != nil {
+		sidecarLogger.Errorf("Failed to inspect deadletter queue: %v", err)
+	}
+	stats.Persisted = queueStats(pending)
+	stats.DeadLetter = queueStats(deadLetter)
+	return stats
+}
+
+func (p *Pipeline) publishStats() {
+	stats := p.Stats()
+	diag.Global().SetQueueState(stats.Buffered, stats.Persisted, stats.DeadLetter)
+	diag.Global().SetBufferState(stats.Capacity, stats.Peak)
+}
+
+func queueStats(summary queue.Summary) diag.QueueStats {
+	stats := diag.QueueStats{
+		Batches:       summary.Batches,
+		Events:        summary.Events,
+		OldestAge:     summary.OldestAge(),
+		Undecryptable: summary.Undecryptable,
+		KeyError:      summary.KeyError,
+	}
+	if summary.LastFailure != nil {
+		stats.LastError = summary.LastFailure.Error
+	}
+	return stats
+}
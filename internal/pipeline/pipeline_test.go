@@ -0,0 +1,391 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/analytics"
+	"github.com/yaat-app/sidecar/internal/buffer"
+	"github.com/yaat-app/sidecar/internal/control"
+	"github.com/yaat-app/sidecar/internal/forwarder"
+	"github.com/yaat-app/sidecar/internal/queue"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (fn roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return fn(req)
+}
+
+// fakeAPI records the messages of delivered events in order and rejects
+// requests while failing is set.
+type fakeAPI struct {
+	mu       sync.Mutex
+	messages []string
+	failing  bool
+}
+
+func (a *fakeAPI) setFailing(failing bool) {
+	a.mu.Lock()
+	a.failing = failing
+	a.mu.Unlock()
+}
+
+func (a *fakeAPI) received() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]string(nil), a.messages...)
+}
+
+// fakeForwarder returns a forwarder delivering to a fakeAPI.
+func fakeForwarder(t *testing.T) (*forwarder.Forwarder, *fakeAPI) {
+	t.Helper()
+	// Delivery incidents are recorded in ~/.yaat/state.json
+	t.Setenv("HOME", t.TempDir())
+
+	api := &fakeAPI{}
+	fwd := forwarder.New("https://api.test/ingest", "test-key")
+	fwd.SetHTTPClient(&http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			var payload struct {
+				Events []struct {
+					Message string `json:"message"`
+				} `json:"events"`
+			}
+			if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+				return nil, err
+			}
+			api.mu.Lock()
+			defer api.mu.Unlock()
+			status := http.StatusOK
+			if api.failing {
+				// Not retried, so failures are immediate
+				status = http.StatusUnauthorized
+			} else {
+				for _, evt := range payload.Events {
+					api.messages = append(api.messages, evt.Message)
+				}
+			}
+			return &http.Response{
+				StatusCode: status,
+				Header:     make(http.Header),
+				Body:       io.NopCloser(bytes.NewReader([]byte(`{"status":"ok"}`))),
+			}, nil
+		}),
+	})
+	return fwd, api
+}
+
+func testEvent(message string) buffer.Event {
+	return buffer.Event{"service_name": "svc", "message": message}
+}
+
+func newQueue(t *testing.T, messages ...string) *queue.Storage {
+	t.Helper()
+	store, err := queue.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create queue: %v", err)
+	}
+	for _, msg := range messages {
+		if err := store.Enqueue([]buffer.Event{testEvent(msg)}); err != nil {
+			t.Fatalf("Failed to enqueue: %v", err)
+		}
+	}
+	return store
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFlushOnInterval(t *testing.T) {
+	fwd, api := fakeForwarder(t)
+	buf := buffer.New(100)
+	p := New(Options{Buffer: buf, Forwarder: fwd, APIKey: "test-key", FlushInterval: 20 * time.Millisecond})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p.Start(ctx)
+
+	buf.Add(testEvent("one"))
+	buf.Add(testEvent("two"))
+	waitFor(t, func() bool { return len(api.received()) == 2 })
+	if stats := p.Stats(); stats.Buffered != 0 {
+		t.Errorf("Expected an empty buffer, got %d", stats.Buffered)
+	}
+}
+
+func TestFlushEnqueuesFailedSends(t *testing.T) {
+	fwd, api := fakeForwarder(t)
+	api.setFailing(true)
+	buf := buffer.New(100)
+	store := newQueue(t)
+	p := New(Options{Buffer: buf, Forwarder: fwd, Queue: store, APIKey: "test-key"})
+
+	buf.Add(testEvent("lost?"))
+	buf.Add(testEvent("not lost"))
+	flushed, err := p.Flush()
+	if err == nil {
+		t.Fatal("Expected the failed send to be reported")
+	}
+	if flushed != 2 {
+		t.Errorf("Expected 2 events taken from the buffer, got %d", flushed)
+	}
+	if stats := p.Stats(); stats.Persisted.Events != 2 || stats.Persisted.Batches != 1 {
+		t.Errorf("Expected the failed events in one persisted batch, got %+v", stats.Persisted)
+	}
+
+	// Once the API recovers the next interval drains them
+	api.setFailing(false)
+	if drained, err := p.drainQueue(time.Time{}); err != nil || drained != 2 {
+		t.Errorf("Expected 2 events drained, got %d (%v)", drained, err)
+	}
+}
+
+func TestFlushLocalOnlySkipsAPI(t *testing.T) {
+	fwd, api := fakeForwarder(t)
+	buf := buffer.New(100)
+	p := New(Options{Buffer: buf, Forwarder: fwd})
+
+	buf.Add(testEvent("local"))
+	if flushed, err := p.Flush(); err != nil || flushed != 1 {
+		t.Fatalf("Expected 1 event flushed, got %d (%v)", flushed, err)
+	}
+	if got := api.received(); len(got) != 0 {
+		t.Errorf("Expected nothing sent without an API key, got %v", got)
+	}
+}
+
+func TestDrainSendsQueuedBeforeBuffered(t *testing.T) {
+	fwd, api := fakeForwarder(t)
+	store := newQueue(t, "queued-1", "queued-2")
+	buf := buffer.New(100)
+	buf.Add(testEvent("buffered"))
+	p := New(Options{Buffer: buf, Forwarder: fwd, Queue: store, APIKey: "test-key"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := p.Drain(ctx); err != nil {
+		t.Fatalf("Drain failed: %v", err)
+	}
+
+	got := api.received()
+	want := []string{"queued-1", "queued-2", "buffered"}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Expected %v in order, got %v", want, got)
+			break
+		}
+	}
+	if pending, _ := store.Pending(); pending != 0 {
+		t.Errorf("Expected empty queue, got %d batches", pending)
+	}
+}
+
+func TestDrainPersistsPastDeadline(t *testing.T) {
+	fwd, api := fakeForwarder(t)
+	store := newQueue(t)
+	buf := buffer.New(100)
+	buf.Add(testEvent("buffered-1"))
+	buf.Add(testEvent("buffered-2"))
+	p := New(Options{Buffer: buf, Forwarder: fwd, Queue: store, APIKey: "test-key"})
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	p.Drain(ctx)
+
+	// Drain returns at the deadline; persisting still completes
+	waitFor(t, func() bool {
+		events, _ := store.PendingEvents()
+		return events == 2
+	})
+	if got := api.received(); len(got) != 0 {
+		t.Errorf("Expected no delivery after the deadline, got %v", got)
+	}
+}
+
+func TestDrainStopsFlusher(t *testing.T) {
+	fwd, _ := fakeForwarder(t)
+	p := New(Options{Buffer: buffer.New(100), Forwarder: fwd, APIKey: "test-key", FlushInterval: time.Hour})
+	p.Start(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := p.Drain(ctx); err != nil {
+		t.Fatalf("Drain failed: %v", err)
+	}
+	if result := p.FlushNow(ctx); result.Error != "sidecar is shutting down" {
+		t.Errorf("Expected FlushNow to be refused after Drain, got %+v", result)
+	}
+}
+
+func TestFlushNowOverControlSocket(t *testing.T) {
+	// Unix socket paths are length-limited, so avoid the long t.TempDir() path
+	dir, err := os.MkdirTemp("", "yaat-ctl")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	socketPath := filepath.Join(dir, "control.sock")
+
+	store := newQueue(t, "queued-1", "queued-2")
+	fwd, api := fakeForwarder(t)
+	buf := buffer.New(100)
+
+	// A long interval guarantees only the control request triggers delivery
+	p := New(Options{Buffer: buf, Forwarder: fwd, Queue: store, APIKey: "test-key", FlushInterval: time.Hour})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p.Start(ctx)
+
+	srv := control.New(socketPath, p.FlushNow)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Failed to start control server: %v", err)
+	}
+	defer srv.Stop()
+
+	info, err := os.Stat(socketPath)
+	if err != nil {
+		t.Fatalf("Socket not created: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("Expected socket permissions 0600, got %o", perm)
+	}
+
+	// The startup drain delivers the queue; wait for it so the counts below
+	// are deterministic
+	waitFor(t, func() bool { return len(api.received()) == 2 })
+
+	for i := 0; i < 3; i++ {
+		buf.Add(testEvent("buffered"))
+	}
+	if err := store.Enqueue([]buffer.Event{testEvent("queued-3")}); err != nil {
+		t.Fatalf("Failed to enqueue: %v", err)
+	}
+
+	result, err := control.RequestFlush(socketPath, 5*time.Second)
+	if err != nil {
+		t.Fatalf("RequestFlush failed: %v", err)
+	}
+	if result.Flushed != 3 {
+		t.Errorf("Expected 3 flushed events, got %d", result.Flushed)
+	}
+	if result.Drained != 1 {
+		t.Errorf("Expected 1 drained event, got %d", result.Drained)
+	}
+	if got := api.received(); len(got) != 6 {
+		t.Errorf("Expected forwarder to receive 6 events, got %d", len(got))
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Expected empty buffer, got %d", buf.Len())
+	}
+	if pending, _ := store.Pending(); pending != 0 {
+		t.Errorf("Expected empty queue, got %d batches", pending)
+	}
+}
+
+func TestRecordFailuresOnly(t *testing.T) {
+	fwd, api := fakeForwarder(t)
+	buf := buffer.New(100)
+	store := newQueue(t)
+	for i, msg := range []string{"dead-lettered", "expired"} {
+		evt := testEvent(msg)
+		evt["event_id"] = fmt.Sprintf("evt-%d", i)
+		evt["event_type"] = "log"
+		evt["tags"] = map[string]string{"source": "test"}
+		if err := store.Enqueue([]buffer.Event{evt}); err != nil {
+			t.Fatalf("Failed to enqueue: %v", err)
+		}
+	}
+	failures := analytics.NewFailureRecorder(analytics.Config{
+		DatabasePath:   filepath.Join(t.TempDir(), "analytics.db"),
+		OrganizationID: "local",
+		ServiceName:    "svc",
+		Environment:    "test",
+	})
+	defer failures.Close()
+	p := New(Options{Buffer: buf, Forwarder: fwd, Queue: store, Failures: failures, APIKey: "test-key", QueueRetention: time.Hour})
+
+	// Delivered events are never recorded
+	buf.Add(testEvent("delivered"))
+	if _, err := p.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if failures.Writer() != nil {
+		t.Error("Expected no database before the first failure")
+	}
+
+	api.setFailing(true)
+	if _, err := p.drainQueue(time.Time{}); err == nil {
+		t.Fatal("Expected the send of the persisted batch to fail")
+	}
+	if n, _ := store.DeadLetterPending(); n != 1 {
+		t.Fatalf("Expected 1 dead-lettered batch, got %d", n)
+	}
+	w := failures.Writer()
+	if w == nil {
+		t.Fatal("Expected the first failure to open the database")
+	}
+	count := func(filters map[string]string) int64 {
+		n, err := w.CountByTags(filters)
+		if err != nil {
+			t.Fatalf("Failed to count events: %v", err)
+		}
+		return n
+	}
+	waitFor(t, func() bool {
+		return count(map[string]string{"delivery_failed": "true", "delivery_failure_reason": FailureDeadLettered, "source": "test"}) == 1
+	})
+
+	// The batch left in the queue expires by retention
+	batches, err := store.ListBatches()
+	if err != nil || len(batches) != 1 {
+		t.Fatalf("Expected 1 pending batch, got %d (%v)", len(batches), err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(batches[0].Path, old, old); err != nil {
+		t.Fatalf("Failed to age batch: %v", err)
+	}
+	p.cleanupQueues()
+	waitFor(t, func() bool {
+		return count(map[string]string{"delivery_failed": "true", "delivery_failure_reason": FailureQueueExpired}) == 1
+	})
+	if n := count(nil); n != 2 {
+		t.Errorf("Expected only the 2 undelivered events stored, got %d", n)
+	}
+
+	// The dead-lettered copy is untouched by the tagging
+	dead, err := store.DeadLetterBatches()
+	if err != nil || len(dead) != 1 {
+		t.Fatalf("Expected 1 dead-lettered batch, got %d (%v)", len(dead), err)
+	}
+	if _, err := store.ReplayDeadLetter(); err != nil {
+		t.Fatalf("Failed to replay: %v", err)
+	}
+	_, events, err := store.Dequeue()
+	if err != nil || len(events) != 1 {
+		t.Fatalf("Expected the replayed batch, got %d events (%v)", len(events), err)
+	}
+	if tags, _ := events[0]["tags"].(map[string]interface{}); len(tags) != 1 {
+		t.Errorf("Expected the queued event's tags unchanged, got %v", events[0]["tags"])
+	}
+}
@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+	"github.com/yaat-app/sidecar/internal/correlate"
+	"github.com/yaat-app/sidecar/internal/logs"
+)
+
+func TestProxyCorrelatesLogLines(t *testing.T) {
+	cache := correlate.New(0, 0)
+	logPath := filepath.Join(t.TempDir(), "app.log")
+
+	// The upstream app logs a JSON line carrying the request ID it was given
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		line := fmt.Sprintf(`{"level":"info","message":"order created","request_id":%q}`+"\n", r.Header.Get("X-Request-ID"))
+		os.WriteFile(logPath, []byte(line), 0o644)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer upstream.Close()
+
+	buf := buffer.New(10)
+	p, err := New(0, upstream.URL, "org", "svc", "test", nil, buf)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	p.SetCorrelator(cache)
+	front := httptest.NewServer(http.HandlerFunc(p.handleRequest))
+	defer front.Close()
+
+	req, _ := http.NewRequest("POST", front.URL+"/orders", nil)
+	req.Header.Set("X-Request-ID", "req-42")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	waitForEvents(t, buf, 1)
+
+	tailer := logs.New(logPath, "json", "org", "svc", "test", nil, buf)
+	tailer.SetCorrelator(cache)
+	if _, err := tailer.ReadFile(logPath, nil); err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+
+	var span, log buffer.Event
+	for _, evt := range buf.Flush() {
+		switch evt["event_type"] {
+		case "span":
+			span = evt
+		case "log":
+			log = evt
+		}
+	}
+	if span == nil || log == nil {
+		t.Fatalf("Expected a span and a log event, got span=%v log=%v", span, log)
+	}
+	if log["trace_id"] != span["trace_id"] {
+		t.Errorf("Expected log trace_id %v to match the proxy span, got %v", span["trace_id"], log["trace_id"])
+	}
+	if log["parent_span_id"] != span["span_id"] {
+		t.Errorf("Expected the proxy span as parent, got %v", log["parent_span_id"])
+	}
+}
@@ -0,0 +1,151 @@
+package proxy
+
+import (
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+)
+
+const (
+	durationMetricName = "http.server.duration"
+	requestsMetricName = "http.server.requests"
+
+	// maxLatencySeries bounds the distinct route/status pairs tracked per
+	// interval; further routes are folded into routeOverflow.
+	maxLatencySeries = 500
+	routeOverflow    = "other"
+)
+
+var (
+	uuidSegment = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	hexSegment  = regexp.MustCompile(`^[0-9a-fA-F]{16,}$`)
+	numSegment  = regexp.MustCompile(`^\d+$`)
+)
+
+type latencyKey struct {
+	method      string
+	route       string
+	statusClass string
+}
+
+// latencyAggregator collects request durations per route template between
+// flush ticks so the proxy can emit percentiles instead of every span.
+type latencyAggregator struct {
+	mu      sync.Mutex
+	samples map[latencyKey][]float64
+}
+
+func newLatencyAggregator() *latencyAggregator {
+	return &latencyAggregator{samples: make(map[latencyKey][]float64)}
+}
+
+// Observe records one request duration in milliseconds.
+func (a *latencyAggregator) Observe(method, path string, status int, durationMs float64) {
+	key := latencyKey{method: method, route: routeTemplate(path), statusClass: statusClass(status)}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, exists := a.samples[key]; !exists && len(a.samples) >= maxLatencySeries {
+		key.route = routeOverflow
+	}
+	a.samples[key] = append(a.samples[key], durationMs)
+}
+
+// Flush converts the collected durations into p50/p95/p99 metric events and resets the window.
+func (a *latencyAggregator) Flush(now time.Time, base func() buffer.Event) []buffer.Event {
+	a.mu.Lock()
+	samples := a.samples
+	a.samples = make(map[latencyKey][]float64)
+	a.mu.Unlock()
+
+	keys := make([]latencyKey, 0, len(samples))
+	for key := range samples {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].statusClass < keys[j].statusClass
+	})
+
+	timestamp := now.UTC().Format(time.RFC3339Nano)
+	metricEvent := func(key latencyKey, name string, value float64) buffer.Event {
+		event := base()
+		event["timestamp"] = timestamp
+		event["event_type"] = "metric"
+		event["metric_name"] = name
+		event["metric_value"] = value
+
+		tags := event["tags"].(map[string]string)
+		tags["route"] = key.route
+		tags["method"] = key.method
+		tags["status_class"] = key.statusClass
+		return event
+	}
+
+	events := make([]buffer.Event, 0, len(keys)*4)
+	for _, key := range keys {
+		values := samples[key]
+		sort.Float64s(values)
+		for _, q := range []struct {
+			name string
+			p    float64
+		}{{"p50", 0.50}, {"p95", 0.95}, {"p99", 0.99}} {
+			event := metricEvent(key, durationMetricName, percentile(values, q.p))
+			tags := event["tags"].(map[string]string)
+			tags["quantile"] = q.name
+			tags["unit"] = "ms"
+			events = append(events, event)
+		}
+		// Request count over the window completes the rate/errors/duration view
+		events = append(events, metricEvent(key, requestsMetricName, float64(len(values))))
+	}
+	return events
+}
+
+// percentile uses the nearest-rank method on sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+// routeTemplate replaces identifier-like path segments with ":id" so
+// /api/orders/123 and /api/orders/456 aggregate together.
+func routeTemplate(path string) string {
+	if path == "" || path == "/" {
+		return "/"
+	}
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		if numSegment.MatchString(seg) || uuidSegment.MatchString(seg) || hexSegment.MatchString(seg) {
+			segments[i] = ":id"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func statusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "unknown"
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+)
+
+func testBase() buffer.Event {
+	return buffer.Event{"service_name": "svc", "tags": map[string]string{"region": "eu"}}
+}
+
+func TestLatencyAggregatorPercentiles(t *testing.T) {
+	agg := newLatencyAggregator()
+	for i := 1; i <= 100; i++ {
+		agg.Observe("GET", "/api/orders/"+string(rune('0'+i%10)), 200, float64(i))
+	}
+	agg.Observe("GET", "/api/orders/7", 503, 900)
+
+	events := agg.Flush(time.Now(), testBase)
+	// Two series (2xx, 5xx) with three quantiles and a count each
+	if len(events) != 8 {
+		t.Fatalf("Expected 8 events, got %d", len(events))
+	}
+
+	values := make(map[string]float64)
+	for _, event := range events {
+		tags := event["tags"].(map[string]string)
+		if tags["route"] != "/api/orders/:id" {
+			t.Errorf("Expected templated route, got %q", tags["route"])
+		}
+		if tags["region"] != "eu" {
+			t.Errorf("Expected base tags to be kept, got %v", tags)
+		}
+		values[event["metric_name"].(string)+"/"+tags["status_class"]+"/"+tags["quantile"]] = event["metric_value"].(float64)
+	}
+
+	expected := map[string]float64{
+		"http.server.duration/2xx/p50": 50,
+		"http.server.duration/2xx/p95": 95,
+		"http.server.duration/2xx/p99": 99,
+		"http.server.requests/2xx/":    100,
+		"http.server.duration/5xx/p99": 900,
+		"http.server.requests/5xx/":    1,
+	}
+	for key, want := range expected {
+		if got, ok := values[key]; !ok || got != want {
+			t.Errorf("%s: expected %v, got %v (present=%v)", key, want, got, ok)
+		}
+	}
+
+	if again := agg.Flush(time.Now(), testBase); len(again) != 0 {
+		t.Errorf("Expected flush to reset the window, got %d events", len(again))
+	}
+}
+
+func TestRouteTemplate(t *testing.T) {
+	tests := map[string]string{
+		"/":               "/",
+		"/api/orders/123": "/api/orders/:id",
+		"/users/4bf92f35-77b3-4da6-a3ce-929d0e0e4736/edit": "/users/:id/edit",
+		"/blobs/00f067aa0ba902b7":                          "/blobs/:id",
+		"/api/v1/health":                                   "/api/v1/health",
+	}
+	for path, want := range tests {
+		if got := routeTemplate(path); got != want {
+			t.Errorf("routeTemplate(%q) = %q, expected %q", path, got, want)
+		}
+	}
+}
+
+func TestLatencyAggregatorBoundsSeries(t *testing.T) {
+	agg := newLatencyAggregator()
+	for i := 0; i < maxLatencySeries+10; i++ {
+		agg.Observe("GET", "/static/file-"+string(rune('a'+i%26))+string(rune('a'+i/26%26)), 200, 1)
+	}
+	if len(agg.samples) > maxLatencySeries+1 {
+		t.Errorf("Expected at most %d series, got %d", maxLatencySeries+1, len(agg.samples))
+	}
+	if _, ok := agg.samples[latencyKey{method: "GET", route: routeOverflow, statusClass: "2xx"}]; !ok {
+		t.Error("Expected overflow series to collect extra routes")
+	}
+}
@@ -3,16 +3,32 @@ package proxy
 import (
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"net/url"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/yaat-app/sidecar/internal/buffer"
+	"github.com/yaat-app/sidecar/internal/correlate"
+	"github.com/yaat-app/sidecar/internal/diag"
+	"github.com/yaat-app/sidecar/internal/logging"
 	"github.com/yaat-app/sidecar/internal/scrubber"
+	"github.com/yaat-app/sidecar/internal/spansample"
 )
 
+var logger = logging.New("Proxy")
+
+// DropBackpressure is the diag drop reason for spans shed because the
+// recorder fell behind.
+const DropBackpressure = "proxy_backpressure"
+
+// defaultMaxPendingEvents bounds the finished requests waiting to become spans.
+const defaultMaxPendingEvents = 1000
+
+// scrub is scrubber.Apply; tests replace it to simulate a slow scrubber.
+var scrub = scrubber.Apply
+
 // Proxy is an HTTP reverse proxy that captures requests/responses
 type Proxy struct {
 	listenPort     int
@@ -22,6 +38,37 @@ type Proxy struct {
 	environment    string
 	globalTags     map[string]string
 	buffer         *buffer.Buffer
+
+	// Optional per-route latency percentiles emitted every latencyInterval
+	latency         *latencyAggregator
+	latencyInterval time.Duration
+
+	// Optional request ID -> span record shared with the log tailers
+	correlator *correlate.Cache
+
+	// Optional span sampling, with sampled-out counts emitted every
+	// spanCountInterval when it is set
+	spanSampler       *spansample.Sampler
+	spanCountInterval time.Duration
+
+	// Finished requests are turned into span events off the request path
+	maxPending  int
+	pending     chan pendingSpan
+	pendingOnce sync.Once
+}
+
+// pendingSpan is a finished request waiting to be recorded as a span. It
+// copies what the span needs so nothing refers to the request afterwards.
+type pendingSpan struct {
+	method    string
+	path      string
+	host      string
+	traceID   string
+	spanID    string
+	startTime time.Time
+	duration  time.Duration
+	status    int
+	extraTags map[string]string
 }
 
 // New creates a new Proxy
@@ -39,13 +86,58 @@ func New(listenPort int, upstreamURL, organizationID, serviceName, environment s
 		environment:    environment,
 		globalTags:     globalTags,
 		buffer:         buf,
+		maxPending:     defaultMaxPendingEvents,
 	}, nil
 }
 
+// SetMaxPendingEvents bounds how many finished requests may wait for the
+// span recorder; beyond that spans are dropped rather than slowing requests.
+// n <= 0 keeps the default. Call before Start.
+func (p *Proxy) SetMaxPendingEvents(n int) {
+	if n > 0 {
+		p.maxPending = n
+	}
+}
+
+// EnableLatencyMetrics aggregates request durations per route and emits
+// http.server.duration percentiles every interval. Call before Start.
+func (p *Proxy) EnableLatencyMetrics(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	p.latency = newLatencyAggregator()
+	p.latencyInterval = interval
+}
+
+// SetCorrelator records each request's X-Request-ID with its trace and span
+// IDs in c, so tailers can stamp the trace onto the request's log lines.
+func (p *Proxy) SetCorrelator(c *correlate.Cache) {
+	p.correlator = c
+}
+
+// SetSpanSampler drops spans s does not keep before they are queued for the
+// span recorder. A positive countInterval also emits the sampler's
+// sampled-out counts that often. Call before Start.
+func (p *Proxy) SetSpanSampler(s *spansample.Sampler, countInterval time.Duration) {
+	p.spanSampler = s
+	p.spanCountInterval = 0
+	if s != nil && countInterval > 0 {
+		s.CountSampledOut()
+		p.spanCountInterval = countInterval
+	}
+}
+
 // Start starts the HTTP proxy server
 func (p *Proxy) Start() error {
 	addr := fmt.Sprintf(":%d", p.listenPort)
-	log.Printf("[Proxy] Starting HTTP proxy on %s -> %s", addr, p.upstreamURL.String())
+	logger.Infof("Starting HTTP proxy on %s -> %s", addr, p.upstreamURL.String())
+
+	if p.latency != nil {
+		go p.runLatencyFlusher()
+	}
+	if p.spanCountInterval > 0 {
+		go p.runSpanCountFlusher()
+	}
 
 	// Create HTTP server with custom handler
 	server := &http.Server{
@@ -60,17 +152,26 @@ func (p *Proxy) Start() error {
 
 // handleRequest handles an HTTP request
 func (p *Proxy) handleRequest(w http.ResponseWriter, r *http.Request) {
+	// Upgraded connections are tunnelled rather than proxied request by request
+	if isUpgradeRequest(r) {
+		p.handleUpgrade(w, r)
+		return
+	}
+
 	// Generate trace and span IDs
 	traceID := uuid.New().String()
 	spanID := uuid.New().String()
 
+	// Record before forwarding, as the upstream logs while handling the request
+	p.recordRequestID(r, traceID, spanID)
+
 	// Record start time
 	startTime := time.Now()
 
 	// Create upstream request
 	upstreamReq, err := http.NewRequest(r.Method, p.upstreamURL.String()+r.RequestURI, r.Body)
 	if err != nil {
-		log.Printf("[Proxy] Failed to create upstream request: %v", err)
+		logger.Errorf("Failed to create upstream request: %v", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
@@ -92,8 +193,9 @@ func (p *Proxy) handleRequest(w http.ResponseWriter, r *http.Request) {
 	}
 	resp, err := client.Do(upstreamReq)
 	if err != nil {
-		log.Printf("[Proxy] Upstream request failed: %v", err)
+		logger.Errorf("Upstream request failed: %v", err)
 		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		p.observeLatency(r, http.StatusBadGateway, time.Since(startTime))
 		return
 	}
 	defer resp.Body.Close()
@@ -114,41 +216,139 @@ func (p *Proxy) handleRequest(w http.ResponseWriter, r *http.Request) {
 	// Copy response body
 	io.Copy(w, resp.Body)
 
-	// Create span event
+	p.recordSpan(r, traceID, spanID, startTime, duration, resp.StatusCode, nil)
+	p.observeLatency(r, resp.StatusCode, duration)
+
+	logger.Debugf("%s %s -> %d (%dms)", r.Method, r.URL.Path, resp.StatusCode, duration.Milliseconds())
+}
+
+// recordSpan queues a root span for a proxied request once its response is
+// written. Building, scrubbing and buffering the event happen on the span
+// recorder goroutine; when it falls behind the span is dropped and counted
+// in diag, so the request never waits. extraTags are added on top of the
+// request tags.
+func (p *Proxy) recordSpan(r *http.Request, traceID, spanID string, startTime time.Time, duration time.Duration, status int, extraTags map[string]string) {
+	if p.spanSampler != nil && !p.spanSampler.KeepSpan(traceID, r.Method, status) {
+		return
+	}
+	p.pendingOnce.Do(func() {
+		p.pending = make(chan pendingSpan, p.maxPending)
+		go p.runSpanRecorder()
+	})
+
+	span := pendingSpan{
+		method:    r.Method,
+		path:      r.URL.Path,
+		host:      r.Host,
+		traceID:   traceID,
+		spanID:    spanID,
+		startTime: startTime,
+		duration:  duration,
+		status:    status,
+		extraTags: extraTags,
+	}
+	select {
+	case p.pending <- span:
+	default:
+		diag.Global().RecordDropped(DropBackpressure, 1)
+	}
+}
+
+// runSpanRecorder turns queued requests into span events.
+func (p *Proxy) runSpanRecorder() {
+	for span := range p.pending {
+		p.bufferSpan(span)
+	}
+}
+
+func (p *Proxy) bufferSpan(span pendingSpan) {
+	tags := map[string]string{
+		"method": span.method,
+		"path":   span.path,
+		"host":   span.host,
+	}
+	for k, v := range span.extraTags {
+		tags[k] = v
+	}
+
+	// Merge global tags with span-specific tags
+	for k, v := range p.globalTags {
+		if _, exists := tags[k]; !exists {
+			tags[k] = v
+		}
+	}
+
 	event := buffer.Event{
 		"organization_id": p.organizationID,
 		"service_name":    p.serviceName,
 		"event_id":        uuid.New().String(),
-		"timestamp":       startTime.UTC().Format(time.RFC3339),
+		"timestamp":       span.startTime.UTC().Format(time.RFC3339),
 		"event_type":      "span",
 		"environment":     p.environment,
-		"trace_id":        traceID,
-		"span_id":         spanID,
+		"trace_id":        span.traceID,
+		"span_id":         span.spanID,
 		"parent_span_id":  "", // Root span from proxy
-		"operation":       fmt.Sprintf("%s %s", r.Method, r.URL.Path),
-		"duration_ms":     float64(duration.Milliseconds()),
-		"status_code":     resp.StatusCode,
-		"tags": map[string]string{
-			"method": r.Method,
-			"path":   r.URL.Path,
-			"host":   r.Host,
-		},
+		"operation":       fmt.Sprintf("%s %s", span.method, span.path),
+		"duration_ms":     float64(span.duration.Milliseconds()),
+		"status_code":     span.status,
+		"tags":            tags,
 	}
 
-	// Merge global tags with span-specific tags
-	if len(p.globalTags) > 0 {
-		eventTags := event["tags"].(map[string]string)
-		for k, v := range p.globalTags {
-			if _, exists := eventTags[k]; !exists {
-				eventTags[k] = v
-			}
+	// Add to buffer
+	if scrub(event) {
+		p.buffer.Add(event)
+	}
+}
+
+// recordRequestID remembers the request's X-Request-ID for log correlation.
+func (p *Proxy) recordRequestID(r *http.Request, traceID, spanID string) {
+	if p.correlator == nil {
+		return
+	}
+	p.correlator.Record(r.Header.Get("X-Request-ID"), correlate.Span{TraceID: traceID, SpanID: spanID})
+}
+
+func (p *Proxy) observeLatency(r *http.Request, status int, duration time.Duration) {
+	if p.latency == nil {
+		return
+	}
+	p.latency.Observe(r.Method, r.URL.Path, status, float64(duration.Microseconds())/1000)
+}
+
+// runLatencyFlusher emits aggregated latency metrics on every tick.
+func (p *Proxy) runLatencyFlusher() {
+	ticker := time.NewTicker(p.latencyInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		for _, event := range p.latency.Flush(now, p.baseMetricEvent) {
+			p.buffer.Add(event)
 		}
 	}
+}
 
-	// Add to buffer
-	if scrubber.Apply(event) {
-		p.buffer.Add(event)
+// runSpanCountFlusher emits the spans sampled out on every tick.
+func (p *Proxy) runSpanCountFlusher() {
+	ticker := time.NewTicker(p.spanCountInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		for _, event := range p.spanSampler.Flush(now, p.baseMetricEvent) {
+			p.buffer.Add(event)
+		}
 	}
+}
 
-	log.Printf("[Proxy] %s %s -> %d (%dms)", r.Method, r.URL.Path, resp.StatusCode, duration.Milliseconds())
+func (p *Proxy) baseMetricEvent() buffer.Event {
+	tags := make(map[string]string, len(p.globalTags)+5)
+	for k, v := range p.globalTags {
+		tags[k] = v
+	}
+	return buffer.Event{
+		"organization_id": p.organizationID,
+		"service_name":    p.serviceName,
+		"environment":     p.environment,
+		"event_id":        uuid.New().String(),
+		"tags":            tags,
+	}
 }
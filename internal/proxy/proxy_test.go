@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+	"github.com/yaat-app/sidecar/internal/diag"
+	"github.com/yaat-app/sidecar/internal/scrubber"
+)
+
+// waitForEvents polls until buf holds n events, as spans are recorded asynchronously.
+func waitForEvents(t *testing.T, buf *buffer.Buffer, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for buf.Len() < n && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if buf.Len() < n {
+		t.Fatalf("Expected %d buffered events, got %d", n, buf.Len())
+	}
+}
+
+func TestSlowScrubberDoesNotDelayRequests(t *testing.T) {
+	release := make(chan struct{})
+	scrub = func(buffer.Event) bool {
+		<-release
+		return true
+	}
+	t.Cleanup(func() { scrub = scrubber.Apply })
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+
+	buf := buffer.New(10)
+	p, err := New(0, upstream.URL, "org", "svc", "test", nil, buf)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	p.SetMaxPendingEvents(1)
+	front := httptest.NewServer(http.HandlerFunc(p.handleRequest))
+	defer front.Close()
+
+	droppedBefore := diag.Global().Snapshot().DroppedEvents[DropBackpressure]
+	client := &http.Client{Timeout: 2 * time.Second}
+	const requests = 5
+	for i := 0; i < requests; i++ {
+		start := time.Now()
+		resp, err := client.Get(front.URL + "/orders")
+		if err != nil {
+			t.Fatalf("Request %d failed while the scrubber was blocked: %v", i, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "ok" {
+			t.Errorf("Expected upstream body, got %q", body)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("Request %d took %v with a blocked scrubber", i, elapsed)
+		}
+		// Let the recorder pick up the first span so the queue state is predictable
+		if i == 0 {
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+
+	// One span is stuck in the scrubber and one waits in the queue
+	dropped := diag.Global().Snapshot().DroppedEvents[DropBackpressure] - droppedBefore
+	if dropped != requests-2 {
+		t.Errorf("Expected %d spans dropped, got %d", requests-2, dropped)
+	}
+
+	close(release)
+	waitForEvents(t, buf, 2)
+	time.Sleep(20 * time.Millisecond)
+	if n := buf.Len(); n != 2 {
+		t.Errorf("Expected 2 recorded spans, got %d", n)
+	}
+}
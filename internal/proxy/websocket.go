@@ -0,0 +1,164 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const upgradeDialTimeout = 10 * time.Second
+
+// isUpgradeRequest reports whether r asks to switch protocols (WebSocket or
+// any other Connection: Upgrade handshake).
+func isUpgradeRequest(r *http.Request) bool {
+	if r.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, value := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// handleUpgrade forwards the handshake to the upstream and, once it switches
+// protocols, pipes bytes in both directions until either side closes. Frames
+// are not inspected; a single span covers the lifetime of the connection.
+func (p *Proxy) handleUpgrade(w http.ResponseWriter, r *http.Request) {
+	traceID := uuid.New().String()
+	spanID := uuid.New().String()
+	p.recordRequestID(r, traceID, spanID)
+	startTime := time.Now()
+	protocol := strings.ToLower(r.Header.Get("Upgrade"))
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Upgrade not supported", http.StatusInternalServerError)
+		return
+	}
+
+	upstreamConn, err := p.dialUpstream()
+	if err != nil {
+		logger.Errorf("Upstream upgrade dial failed: %v", err)
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		p.observeLatency(r, http.StatusBadGateway, time.Since(startTime))
+		return
+	}
+	defer upstreamConn.Close()
+
+	// Replay the handshake with the hop-by-hop upgrade headers intact
+	upstreamReq := r.Clone(r.Context())
+	upstreamReq.URL, err = url.Parse(p.upstreamURL.String() + r.RequestURI)
+	if err != nil {
+		logger.Errorf("Failed to create upstream request: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	upstreamReq.RequestURI = ""
+	upstreamReq.Host = p.upstreamURL.Host
+	upstreamReq.Header.Set("X-Trace-Id", traceID)
+	upstreamReq.Header.Set("X-Span-Id", spanID)
+	if err := upstreamReq.Write(upstreamConn); err != nil {
+		logger.Errorf("Failed to send upgrade request: %v", err)
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		return
+	}
+
+	upstreamReader := bufio.NewReader(upstreamConn)
+	resp, err := http.ReadResponse(upstreamReader, upstreamReq)
+	if err != nil {
+		logger.Errorf("Invalid upgrade response: %v", err)
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		return
+	}
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		// Upstream declined the upgrade; relay its answer as a normal response
+		defer resp.Body.Close()
+		for key, values := range resp.Header {
+			for _, value := range values {
+				w.Header().Add(key, value)
+			}
+		}
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+
+		duration := time.Since(startTime)
+		p.recordSpan(r, traceID, spanID, startTime, duration, resp.StatusCode, map[string]string{"upgrade": protocol})
+		p.observeLatency(r, resp.StatusCode, duration)
+		return
+	}
+
+	clientConn, clientBuf, err := hijacker.Hijack()
+	if err != nil {
+		logger.Errorf("Failed to hijack connection: %v", err)
+		return
+	}
+	defer clientConn.Close()
+
+	// The server's read/write timeouts must not cut a long-lived tunnel short
+	clientConn.SetDeadline(time.Time{})
+
+	if err := resp.Write(clientConn); err != nil {
+		logger.Errorf("Failed to relay upgrade response: %v", err)
+		return
+	}
+
+	logger.Infof("%s %s upgraded to %s", r.Method, r.URL.Path, protocol)
+
+	// Bytes already buffered on either side belong to the tunnel
+	errc := make(chan error, 2)
+	go pipe(upstreamConn, clientBuf.Reader, errc)
+	go pipe(clientConn, upstreamReader, errc)
+	<-errc
+
+	duration := time.Since(startTime)
+	p.recordSpan(r, traceID, spanID, startTime, duration, resp.StatusCode, map[string]string{"upgrade": protocol})
+
+	logger.Infof("%s %s %s closed after %s", r.Method, r.URL.Path, protocol, duration.Round(time.Millisecond))
+}
+
+// dialUpstream opens a raw connection to the upstream host, using TLS for
+// https/wss upstreams.
+func (p *Proxy) dialUpstream() (net.Conn, error) {
+	host := p.upstreamURL.Host
+	secure := p.upstreamURL.Scheme == "https" || p.upstreamURL.Scheme == "wss"
+	if p.upstreamURL.Port() == "" {
+		if secure {
+			host = net.JoinHostPort(p.upstreamURL.Hostname(), "443")
+		} else {
+			host = net.JoinHostPort(p.upstreamURL.Hostname(), "80")
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: upgradeDialTimeout}
+	if secure {
+		conn, err := tls.DialWithDialer(dialer, "tcp", host, &tls.Config{ServerName: p.upstreamURL.Hostname()})
+		if err != nil {
+			return nil, fmt.Errorf("dial %s: %w", host, err)
+		}
+		return conn, nil
+	}
+	conn, err := dialer.Dial("tcp", host)
+	if err != nil {
+		return nil, fmt.Errorf("dial %s: %w", host, err)
+	}
+	return conn, nil
+}
+
+// pipe copies src to dst and reports when src is exhausted or either side fails.
+func pipe(dst io.Writer, src io.Reader, errc chan<- error) {
+	_, err := io.Copy(dst, src)
+	errc <- err
+}
@@ -0,0 +1,134 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+)
+
+// echoUpgradeServer accepts any Upgrade request, then echoes raw bytes back.
+func echoUpgradeServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Trace-Id") == "" {
+			t.Errorf("Expected trace header on upgrade request")
+		}
+		if r.URL.Path != "/socket" {
+			http.NotFound(w, r)
+			return
+		}
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("Hijack failed: %v", err)
+			return
+		}
+		defer conn.Close()
+		fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		rw.Flush()
+		io.Copy(conn, rw)
+	}))
+}
+
+func TestIsUpgradeRequest(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	if isUpgradeRequest(r) {
+		t.Error("Expected plain request not to be an upgrade")
+	}
+	r.Header.Set("Connection", "keep-alive, Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	if !isUpgradeRequest(r) {
+		t.Error("Expected Connection: Upgrade to be detected")
+	}
+}
+
+func TestProxyTunnelsUpgradedConnections(t *testing.T) {
+	upstream := echoUpgradeServer(t)
+	defer upstream.Close()
+
+	buf := buffer.New(10)
+	p, err := New(0, upstream.URL, "org", "svc", "test", nil, buf)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	front := httptest.NewServer(http.HandlerFunc(p.handleRequest))
+	defer front.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(front.URL, "http://"))
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	fmt.Fprintf(conn, "GET /socket HTTP/1.1\r\nHost: example.test\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("Failed to read handshake: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected 101, got %d", resp.StatusCode)
+	}
+
+	for _, msg := range []string{"ping\n", "second frame\n"} {
+		fmt.Fprint(conn, msg)
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read echo: %v", err)
+		}
+		if line != msg {
+			t.Errorf("Expected echo %q, got %q", msg, line)
+		}
+	}
+
+	if buf.Len() != 0 {
+		t.Errorf("Expected no span while the connection is open, got %d", buf.Len())
+	}
+	conn.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for buf.Len() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	events := buf.Flush()
+	if len(events) != 1 {
+		t.Fatalf("Expected a single span, got %d", len(events))
+	}
+	span := events[0]
+	if span["status_code"] != http.StatusSwitchingProtocols {
+		t.Errorf("Expected status 101, got %v", span["status_code"])
+	}
+	if tags := span["tags"].(map[string]string); tags["upgrade"] != "websocket" {
+		t.Errorf("Expected upgrade tag, got %v", tags)
+	}
+}
+
+func TestProxyRelaysRejectedUpgrade(t *testing.T) {
+	upstream := echoUpgradeServer(t)
+	defer upstream.Close()
+
+	buf := buffer.New(10)
+	p, _ := New(0, upstream.URL, "org", "svc", "test", nil, buf)
+
+	front := httptest.NewServer(http.HandlerFunc(p.handleRequest))
+	defer front.Close()
+
+	req, _ := http.NewRequest("GET", front.URL+"/missing", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected upstream 404 to be relayed, got %d", resp.StatusCode)
+	}
+	waitForEvents(t, buf, 1)
+}
@@ -0,0 +1,144 @@
+package queue
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// KeySize is the length of a queue encryption key (AES-256).
+const KeySize = 32
+
+// Encrypted batches are the gzip-compressed JSON sealed with AES-GCM:
+// encryptionMagic, the key ID, the nonce, then the ciphertext. The key ID
+// tells a wrong key apart from a tampered file.
+var encryptionMagic = []byte("YQE1")
+
+const keyIDSize = 8
+
+var (
+	// errTampered is a batch that fails authentication under the right key.
+	errTampered = errors.New("batch failed authentication")
+
+	errNoKey    = errors.New("batch is encrypted but no queue encryption key is set (delivery.queue_encryption_key_file)")
+	errWrongKey = errors.New("batch was encrypted with a different key than delivery.queue_encryption_key_file")
+)
+
+// LoadKeyFile reads a queue encryption key: 32 raw bytes, or 64 hex
+// characters as written by `openssl rand -hex 32`. Files readable by other
+// users are refused.
+func LoadKeyFile(path string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("queue encryption key: %w", err)
+	}
+	if perm := info.Mode().Perm(); perm&0o004 != 0 {
+		return nil, fmt.Errorf("queue encryption key %s is world-readable (mode %04o); run chmod 600 %s", path, perm, path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("queue encryption key: %w", err)
+	}
+	if len(data) == KeySize {
+		return data, nil
+	}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) == 2*KeySize {
+		if key, err := hex.DecodeString(string(trimmed)); err == nil {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("queue encryption key %s must hold %d bytes or %d hex characters, got %d bytes", path, KeySize, 2*KeySize, len(data))
+}
+
+// SetEncryptionKey encrypts batches written from now on with key. Batches
+// already on disk, plaintext or encrypted with key, stay readable. A nil
+// key writes plaintext batches again.
+func (s *Storage) SetEncryptionKey(key []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if key == nil {
+		s.aead = nil
+		return nil
+	}
+	if len(key) != KeySize {
+		return fmt.Errorf("queue encryption key must be %d bytes, got %d", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return fmt.Errorf("queue encryption key: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return fmt.Errorf("queue encryption key: %w", err)
+	}
+	sum := sha256.Sum256(key)
+	s.aead = aead
+	s.keyID = sum[:keyIDSize]
+	return nil
+}
+
+// seal encrypts a compressed batch. Callers hold s.mu.
+func (s *Storage) seal(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+	aad := append(append([]byte{}, encryptionMagic...), s.keyID...)
+	header := append(append([]byte{}, aad...), nonce...)
+	return s.aead.Seal(header, nonce, plaintext, aad), nil
+}
+
+// open decrypts a batch written by seal. Callers hold s.mu.
+func (s *Storage) open(data []byte) ([]byte, error) {
+	if s.aead == nil {
+		return nil, errNoKey
+	}
+	headerSize := len(encryptionMagic) + keyIDSize + s.aead.NonceSize()
+	if len(data) < headerSize+s.aead.Overhead() || !bytes.HasPrefix(data, encryptionMagic) {
+		return nil, errTampered
+	}
+	aad := data[:len(encryptionMagic)+keyIDSize]
+	nonce := data[len(aad):headerSize]
+	if !bytes.Equal(aad[len(encryptionMagic):], s.keyID) {
+		// The key ID is authenticated: a batch sealed with this key whose
+		// ID was altered still opens under the ID it was sealed with
+		ownAAD := append(append([]byte{}, encryptionMagic...), s.keyID...)
+		if _, err := s.aead.Open(nil, nonce, data[headerSize:], ownAAD); err == nil {
+			return nil, errTampered
+		}
+		return nil, errWrongKey
+	}
+	plaintext, err := s.aead.Open(nil, nonce, data[headerSize:], aad)
+	if err != nil {
+		return nil, errTampered
+	}
+	return plaintext, nil
+}
+
+func isEncrypted(path string) bool {
+	return strings.HasSuffix(strings.TrimSuffix(path, processingExt), encryptedExt)
+}
+
+// quarantine moves a batch that failed authentication from token to the
+// corrupt directory, where it is kept for inspection but never retried.
+// Callers hold s.mu.
+func (s *Storage) quarantine(token, original string) error {
+	dir := filepath.Join(s.dir, "corrupt")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("create corrupt dir: %w", err)
+	}
+	if err := os.Rename(token, filepath.Join(dir, filepath.Base(original))); err != nil {
+		return fmt.Errorf("quarantine batch: %w", err)
+	}
+	os.Remove(original + failureExt)
+	logger.Errorf("Batch %s failed authentication (tampered or truncated); moved it to %s", filepath.Base(original), dir)
+	return nil
+}
@@ -0,0 +1,188 @@
+package queue
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testKey(fill byte) []byte {
+	return bytes.Repeat([]byte{fill}, KeySize)
+}
+
+func encryptedStore(t *testing.T, dir string, key []byte) *Storage {
+	t.Helper()
+	store, err := New(dir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	if err := store.SetEncryptionKey(key); err != nil {
+		t.Fatalf("SetEncryptionKey failed: %v", err)
+	}
+	return store
+}
+
+func TestEncryptedBatchRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	store := encryptedStore(t, dir, testKey(1))
+	if err := store.Enqueue(makeEvents(3)); err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+
+	batches, err := store.ListBatches()
+	if err != nil || len(batches) != 1 {
+		t.Fatalf("Expected one batch, got %v (%v)", batches, err)
+	}
+	if !strings.HasSuffix(batches[0].Path, encryptedExt) || batches[0].Events != 3 {
+		t.Errorf("Expected an encrypted batch of 3 events, got %+v", batches[0])
+	}
+	raw, _ := os.ReadFile(batches[0].Path)
+	if bytes.Contains(raw, []byte("GET /api/orders")) || !bytes.HasPrefix(raw, encryptionMagic) {
+		t.Error("Expected the batch encrypted on disk")
+	}
+
+	token, events, err := store.Dequeue()
+	if err != nil {
+		t.Fatalf("Dequeue failed: %v", err)
+	}
+	if len(events) != 3 || events[2]["message"] != "GET /api/orders/2 completed in 12ms" {
+		t.Errorf("Expected the events decrypted, got %v", events)
+	}
+	store.Ack(token)
+}
+
+func TestEncryptedStoreReadsLegacyBatches(t *testing.T) {
+	dir := t.TempDir()
+	plain, err := New(dir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	plain.Enqueue(makeEvents(2))
+	writeLegacyBatch(t, dir, "1-0001.json", makeEvents(1))
+
+	store := encryptedStore(t, dir, testKey(1))
+	for _, want := range []int{1, 2} {
+		token, events, err := store.Dequeue()
+		if err != nil {
+			t.Fatalf("Dequeue failed: %v", err)
+		}
+		if len(events) != want {
+			t.Errorf("Expected %d events from a plaintext batch, got %d", want, len(events))
+		}
+		store.Ack(token)
+	}
+}
+
+func TestEncryptedBatchWithWrongOrMissingKey(t *testing.T) {
+	dir := t.TempDir()
+	encryptedStore(t, dir, testKey(1)).Enqueue(makeEvents(2))
+
+	for name, key := range map[string][]byte{"missing": nil, "wrong": testKey(2)} {
+		store := encryptedStore(t, dir, key)
+		// A batch written without encryption behind it is still delivered
+		if err := store.SetEncryptionKey(nil); err != nil {
+			t.Fatal(err)
+		}
+		store.Enqueue(makeEvents(3))
+		if err := store.SetEncryptionKey(key); err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 2; i++ {
+			token, events, err := store.Dequeue()
+			if err != nil || len(events) != 3 {
+				t.Fatalf("%s key: expected the plaintext batch past the undecryptable one, got %d events (%v)", name, len(events), err)
+			}
+			store.Fail(token)
+		}
+		summary, _ := store.PendingSummary()
+		if summary.Batches != 2 || summary.Undecryptable != 1 || !strings.Contains(summary.KeyError, "queue_encryption_key_file") {
+			t.Errorf("%s key: expected the encrypted batch left in place and reported, got %+v", name, summary)
+		}
+
+		token, _, _ := store.Dequeue()
+		store.Ack(token)
+	}
+}
+
+func TestTamperedKeyIDIsQuarantined(t *testing.T) {
+	dir := t.TempDir()
+	store := encryptedStore(t, dir, testKey(1))
+	store.Enqueue(makeEvents(2))
+
+	batches, _ := store.ListBatches()
+	raw, _ := os.ReadFile(batches[0].Path)
+	raw[len(encryptionMagic)] ^= 0xff
+	if err := os.WriteFile(batches[0].Path, raw, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, events, err := store.Dequeue(); err != nil || events != nil {
+		t.Fatalf("Expected the tampered batch skipped, got %v (%v)", events, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "corrupt", filepath.Base(batches[0].Path))); err != nil {
+		t.Errorf("Expected the batch in the corrupt directory: %v", err)
+	}
+}
+
+func TestTamperedBatchIsQuarantined(t *testing.T) {
+	dir := t.TempDir()
+	store := encryptedStore(t, dir, testKey(1))
+	store.Enqueue(makeEvents(2))
+	store.Enqueue(makeEvents(4))
+
+	batches, _ := store.ListBatches()
+	raw, _ := os.ReadFile(batches[0].Path)
+	raw[len(raw)-1] ^= 0xff
+	if err := os.WriteFile(batches[0].Path, raw, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The drain moves on to the next batch
+	token, events, err := store.Dequeue()
+	if err != nil {
+		t.Fatalf("Expected the tampered batch skipped, got %v", err)
+	}
+	if len(events) != 4 {
+		t.Errorf("Expected the intact batch of 4 events, got %d", len(events))
+	}
+	store.Ack(token)
+
+	corrupt := filepath.Join(dir, "corrupt", filepath.Base(batches[0].Path))
+	if _, err := os.Stat(corrupt); err != nil {
+		t.Errorf("Expected the tampered batch in the corrupt directory: %v", err)
+	}
+	if pending, _ := store.Pending(); pending != 0 {
+		t.Errorf("Expected nothing left to deliver, got %d batches", pending)
+	}
+}
+
+func TestLoadKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, data []byte, mode os.FileMode) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, mode); err != nil {
+			t.Fatal(err)
+		}
+		os.Chmod(path, mode)
+		return path
+	}
+
+	raw := write("raw.key", testKey(7), 0o600)
+	if key, err := LoadKeyFile(raw); err != nil || !bytes.Equal(key, testKey(7)) {
+		t.Errorf("Expected the raw key, got %x (%v)", key, err)
+	}
+	hexKey := write("hex.key", []byte(hex.EncodeToString(testKey(7))+"\n"), 0o600)
+	if key, err := LoadKeyFile(hexKey); err != nil || !bytes.Equal(key, testKey(7)) {
+		t.Errorf("Expected the hex key decoded, got %x (%v)", key, err)
+	}
+	if _, err := LoadKeyFile(write("open.key", testKey(7), 0o644)); err == nil || !strings.Contains(err.Error(), "world-readable") {
+		t.Errorf("Expected a world-readable key refused, got %v", err)
+	}
+	if _, err := LoadKeyFile(write("short.key", []byte("too short"), 0o600)); err == nil {
+		t.Error("Expected a short key refused")
+	}
+}
@@ -1,39 +1,133 @@
 package queue
 
 import (
+	"bytes"
+	"compress/gzip"
+	"crypto/cipher"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"math/rand"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/yaat-app/sidecar/internal/buffer"
+	"github.com/yaat-app/sidecar/internal/logging"
+	"github.com/yaat-app/sidecar/internal/paths"
 )
 
+var logger = logging.New("Queue")
+
 func init() {
 	rand.Seed(time.Now().UnixNano())
 }
 
-// Storage implements a simple disk-backed queue using JSON batches on disk.
+// Storage implements a simple disk-backed queue using gzip-compressed JSON
+// batches on disk, optionally encrypted. Legacy uncompressed batches are
+// still read.
 type Storage struct {
 	dir    string
 	dlqDir string
 	mu     sync.Mutex
+
+	// Set by SetEncryptionKey; nil writes plaintext batches
+	aead  cipher.AEAD
+	keyID []byte
+
+	// Event counts of legacy .json batches, which carry no count in their name
+	legacyCounts map[string]int
+
+	// Set by SetExpiredHandler; receives pending batches retention removes
+	onExpired func(events []buffer.Event)
+
+	// Pending batches Dequeue skips because the configured key cannot
+	// decrypt them, by path; each is logged once
+	keyErrors map[string]error
+}
+
+// BatchInfo describes a persisted batch without loading its events.
+type BatchInfo struct {
+	Path       string
+	Events     int
+	Bytes      int64 // Size on disk
+	Compressed bool
+	ModTime    time.Time
+	CreatedAt  time.Time // From the filename, or ModTime when it has none
+	Failure    *Failure  // Why delivery failed; nil when not recorded
+}
+
+// Attempt describes one failed delivery of a batch.
+type Attempt struct {
+	Error      string
+	StatusCode int // HTTP status of the last response; 0 when there was none
+}
+
+// Failure is the delivery history kept next to a dead-lettered batch.
+type Failure struct {
+	Error        string    `json:"error"`
+	StatusCode   int       `json:"status_code,omitempty"`
+	Attempts     int       `json:"attempts"`
+	FirstFailure time.Time `json:"first_failure"`
+	LastFailure  time.Time `json:"last_failure"`
+}
+
+// Summary aggregates the batches in a queue directory.
+type Summary struct {
+	Batches int
+	Events  int
+	Bytes   int64
+	Oldest  time.Time // Creation time of the oldest batch; zero when empty
+
+	LastFailure *Failure // Most recent recorded failure; nil when none
+
+	// Batches left in place because the configured key cannot decrypt
+	// them, and why; only set by PendingSummary
+	Undecryptable int
+	KeyError      string
+}
+
+// OldestAge returns how long the oldest batch has been waiting.
+func (s Summary) OldestAge() time.Duration {
+	if s.Oldest.IsZero() {
+		return 0
+	}
+	return time.Since(s.Oldest)
 }
 
 const (
 	activeExt     = ".json"
+	compressedExt = ".json.gz"
+	encryptedExt  = ".json.gz.enc"
 	processingExt = ".processing"
+	tempExt       = ".tmp"
+	failureExt    = ".failure" // Failure record next to a batch: <batch>.failure
 )
 
 // New creates (or opens) a storage directory. Any dangling processing files
-// are moved back to active state.
+// are moved back to active state, so only the process that delivers from
+// the queue may call it; other processes use Open.
 func New(dir string) (*Storage, error) {
+	s, err := Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.recoverProcessing(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Open creates (or opens) a storage directory without touching batches in
+// flight, for inspecting or maintaining the queue of a sidecar that may be
+// running.
+func Open(dir string) (*Storage, error) {
 	if dir == "" {
 		return nil, fmt.Errorf("queue directory is empty")
 	}
@@ -47,11 +141,7 @@ func New(dir string) (*Storage, error) {
 		return nil, fmt.Errorf("create deadletter dir: %w", err)
 	}
 
-	s := &Storage{dir: dir, dlqDir: dlq}
-	if err := s.recoverProcessing(); err != nil {
-		return nil, err
-	}
-	return s, nil
+	return &Storage{dir: dir, dlqDir: dlq, legacyCounts: make(map[string]int), keyErrors: make(map[string]error)}, nil
 }
 
 // Dir returns the underlying directory.
@@ -72,23 +162,48 @@ func (s *Storage) Enqueue(events []buffer.Event) error {
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	_, err := s.writeBatch(s.dir, events)
+	return err
+}
 
-	filename := filepath.Join(s.dir, s.generateFilename())
-	file, err := os.Create(filename)
-	if err != nil {
-		return fmt.Errorf("create queue file: %w", err)
+// writeBatch writes events as a new compressed batch in dir and returns its
+// path. Callers hold s.mu.
+func (s *Storage) writeBatch(dir string, events []buffer.Event) (string, error) {
+	var data bytes.Buffer
+	gz := gzip.NewWriter(&data)
+	if err := json.NewEncoder(gz).Encode(events); err != nil {
+		return "", fmt.Errorf("encode queue file: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return "", fmt.Errorf("compress queue file: %w", err)
+	}
+	ext, contents := compressedExt, data.Bytes()
+	if s.aead != nil {
+		sealed, err := s.seal(contents)
+		if err != nil {
+			return "", fmt.Errorf("encrypt queue file: %w", err)
+		}
+		ext, contents = encryptedExt, sealed
 	}
-	defer file.Close()
 
-	encoder := json.NewEncoder(file)
-	if err := encoder.Encode(events); err != nil {
-		return fmt.Errorf("encode queue file: %w", err)
+	// Write to a temporary name first so a crash never leaves a truncated batch behind
+	filename := filepath.Join(dir, s.generateFilename(len(events), ext))
+	tmp := filename + tempExt
+	if err := os.WriteFile(tmp, contents, 0o644); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("write queue file: %w", err)
 	}
 
-	return nil
+	if err := os.Rename(tmp, filename); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("commit queue file: %w", err)
+	}
+	return filename, nil
 }
 
 // Dequeue loads the oldest batch. The returned token must be passed to Ack or Fail.
+// Batches the configured key cannot decrypt are left in place and skipped,
+// so they do not hold up the ones behind them; PendingSummary reports them.
 func (s *Storage) Dequeue() (token string, events []buffer.Event, err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -101,25 +216,41 @@ func (s *Storage) Dequeue() (token string, events []buffer.Event, err error) {
 		return "", nil, nil
 	}
 
-	original := files[0]
-	processing := original + processingExt
-	if err := os.Rename(original, processing); err != nil {
-		return "", nil, fmt.Errorf("mark processing: %w", err)
-	}
-
-	data, err := os.ReadFile(processing)
-	if err != nil {
-		_ = os.Rename(processing, original)
-		return "", nil, fmt.Errorf("read queue file: %w", err)
-	}
+	for _, original := range files {
+		processing := original + processingExt
+		if err := os.Rename(original, processing); err != nil {
+			return "", nil, fmt.Errorf("mark processing: %w", err)
+		}
 
-	var batch []buffer.Event
-	if err := json.Unmarshal(data, &batch); err != nil {
-		_ = os.Rename(processing, original)
-		return "", nil, fmt.Errorf("decode queue file: %w", err)
+		batch, err := s.readBatch(processing)
+		if errors.Is(err, errTampered) {
+			// Never retried, and no reason to hold up the batches behind it
+			if err := s.quarantine(processing, original); err != nil {
+				_ = os.Rename(processing, original)
+				return "", nil, err
+			}
+			continue
+		}
+		if errors.Is(err, errNoKey) || errors.Is(err, errWrongKey) {
+			// Deliverable once the right key is configured
+			if err := os.Rename(processing, original); err != nil {
+				return "", nil, fmt.Errorf("restore batch: %w", err)
+			}
+			if _, logged := s.keyErrors[original]; !logged {
+				logger.Errorf("Skipping batch %s: %v", filepath.Base(original), err)
+			}
+			s.keyErrors[original] = err
+			continue
+		}
+		if err != nil {
+			_ = os.Rename(processing, original)
+			return "", nil, err
+		}
+		delete(s.legacyCounts, original)
+		delete(s.keyErrors, original)
+		return processing, batch, nil
 	}
-
-	return processing, batch, nil
+	return "", nil, nil
 }
 
 // Ack removes a batch after successful delivery.
@@ -129,6 +260,7 @@ func (s *Storage) Ack(token string) error {
 	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	os.Remove(strings.TrimSuffix(token, processingExt) + failureExt)
 	return os.Remove(token)
 }
 
@@ -156,6 +288,176 @@ func (s *Storage) Pending() (int, error) {
 	return len(files), nil
 }
 
+// ListBatches describes the queued batches, oldest first. Event counts come
+// from the filename; legacy batches are decoded once and cached.
+func (s *Storage) ListBatches() ([]BatchInfo, error) {
+	files, err := s.listActive()
+	if err != nil {
+		return nil, err
+	}
+	return s.describeBatches(s.dir, files), nil
+}
+
+// DeadLetterBatches describes the dead-lettered batches, oldest first.
+func (s *Storage) DeadLetterBatches() ([]BatchInfo, error) {
+	files, err := listBatchFiles(s.dlqDir)
+	if err != nil {
+		return nil, fmt.Errorf("read deadletter dir: %w", err)
+	}
+	return s.describeBatches(s.dlqDir, files), nil
+}
+
+func (s *Storage) describeBatches(dir string, files []string) []BatchInfo {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	batches := make([]BatchInfo, 0, len(files))
+	for _, path := range files {
+		info, err := os.Stat(path)
+		if err != nil {
+			// Dequeued or cleaned up since listing
+			continue
+		}
+		batch := BatchInfo{
+			Path:       path,
+			Bytes:      info.Size(),
+			Compressed: isCompressed(path),
+			ModTime:    info.ModTime(),
+			CreatedAt:  info.ModTime(),
+		}
+		if created, ok := createdAtFromName(path); ok {
+			batch.CreatedAt = created
+		}
+		batch.Failure = readFailure(path)
+		if n, ok := eventCountFromName(path); ok {
+			batch.Events = n
+		} else if n, ok := s.legacyCounts[path]; ok {
+			batch.Events = n
+		} else if events, err := s.readBatch(path); err == nil {
+			batch.Events = len(events)
+			s.legacyCounts[path] = batch.Events
+		}
+		batches = append(batches, batch)
+	}
+
+	// Forget legacy batches of this directory that have since been removed
+	if len(s.legacyCounts) > 0 {
+		listed := make(map[string]struct{}, len(files))
+		for _, path := range files {
+			listed[path] = struct{}{}
+		}
+		for path := range s.legacyCounts {
+			if _, ok := listed[path]; !ok && filepath.Dir(path) == dir {
+				delete(s.legacyCounts, path)
+			}
+		}
+	}
+	return batches
+}
+
+// PendingSummary returns the number of queued batches, events and bytes on
+// disk, and when the oldest batch was written.
+func (s *Storage) PendingSummary() (Summary, error) {
+	batches, err := s.ListBatches()
+	if err != nil {
+		return Summary{}, err
+	}
+	summary := summarize(batches)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	listed := make(map[string]struct{}, len(batches))
+	for _, batch := range batches {
+		listed[batch.Path] = struct{}{}
+	}
+	for path, keyErr := range s.keyErrors {
+		if _, ok := listed[path]; !ok {
+			delete(s.keyErrors, path) // Removed since, e.g. by retention
+			continue
+		}
+		summary.Undecryptable++
+		summary.KeyError = keyErr.Error()
+	}
+	return summary, nil
+}
+
+// DeadLetterSummary is PendingSummary for the dead letter directory.
+func (s *Storage) DeadLetterSummary() (Summary, error) {
+	batches, err := s.DeadLetterBatches()
+	if err != nil {
+		return Summary{}, err
+	}
+	return summarize(batches), nil
+}
+
+// PendingEvents returns the number of events across queued batches.
+func (s *Storage) PendingEvents() (int, error) {
+	summary, err := s.PendingSummary()
+	return summary.Events, err
+}
+
+// OldestBatchAge returns how long the oldest queued batch has waited, or 0
+// when the queue is empty.
+func (s *Storage) OldestBatchAge() (time.Duration, error) {
+	summary, err := s.PendingSummary()
+	return summary.OldestAge(), err
+}
+
+func summarize(batches []BatchInfo) Summary {
+	summary := Summary{Batches: len(batches)}
+	for _, b := range batches {
+		summary.Events += b.Events
+		summary.Bytes += b.Bytes
+		if summary.Oldest.IsZero() || b.CreatedAt.Before(summary.Oldest) {
+			summary.Oldest = b.CreatedAt
+		}
+		if b.Failure != nil && (summary.LastFailure == nil || b.Failure.LastFailure.After(summary.LastFailure.LastFailure)) {
+			summary.LastFailure = b.Failure
+		}
+	}
+	return summary
+}
+
+// Trim removes the oldest queued batches until the queue occupies at most
+// maxBytes on disk, returning the number of events discarded.
+func (s *Storage) Trim(maxBytes int64) (int, error) {
+	if maxBytes <= 0 {
+		return 0, nil
+	}
+	batches, err := s.ListBatches()
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, b := range batches {
+		total += b.Bytes
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dropped := 0
+	for _, b := range batches {
+		if total <= maxBytes {
+			break
+		}
+		if err := os.Remove(b.Path); err != nil {
+			if os.IsNotExist(err) {
+				// Dequeued since listing
+				continue
+			}
+			return dropped, fmt.Errorf("trim queue: %w", err)
+		}
+		os.Remove(b.Path + failureExt)
+		delete(s.legacyCounts, b.Path)
+		total -= b.Bytes
+		dropped += b.Events
+	}
+	return dropped, nil
+}
+
 // DeadLetterPending returns the number of batches in the DLQ.
 func (s *Storage) DeadLetterPending() (int, error) {
 	entries, err := os.ReadDir(s.dlqDir)
@@ -164,7 +466,7 @@ func (s *Storage) DeadLetterPending() (int, error) {
 	}
 	count := 0
 	for _, entry := range entries {
-		if entry.IsDir() {
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), failureExt) {
 			continue
 		}
 		count++
@@ -172,8 +474,67 @@ func (s *Storage) DeadLetterPending() (int, error) {
 	return count, nil
 }
 
-// MoveToDLQ moves a failed batch to the dead letter directory.
-func (s *Storage) MoveToDLQ(token string) error {
+// PurgeDeadLetter deletes every dead-lettered batch and returns what was removed.
+func (s *Storage) PurgeDeadLetter() (Summary, error) {
+	removed, err := s.DeadLetterSummary()
+	if err != nil {
+		return Summary{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := removeQueueFiles(s.dlqDir); err != nil {
+		return Summary{}, fmt.Errorf("purge deadletter: %w", err)
+	}
+	return removed, nil
+}
+
+// Reset deletes every pending and dead-lettered batch, including batches
+// being delivered, and returns what was removed from each.
+func (s *Storage) Reset() (pending, deadLetter Summary, err error) {
+	if pending, err = s.PendingSummary(); err != nil {
+		return Summary{}, Summary{}, err
+	}
+	if deadLetter, err = s.PurgeDeadLetter(); err != nil {
+		return Summary{}, Summary{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := removeQueueFiles(s.dir); err != nil {
+		return Summary{}, deadLetter, fmt.Errorf("reset queue: %w", err)
+	}
+	s.legacyCounts = make(map[string]int)
+	return pending, deadLetter, nil
+}
+
+// removeQueueFiles deletes the batch, processing and temporary files in dir,
+// leaving subdirectories and anything else alone.
+func removeQueueFiles(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			continue
+		}
+		if !strings.HasSuffix(name, activeExt) && !strings.HasSuffix(name, compressedExt) && !strings.HasSuffix(name, encryptedExt) &&
+			!strings.HasSuffix(name, processingExt) && !strings.HasSuffix(name, tempExt) &&
+			!strings.HasSuffix(name, failureExt) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// MoveToDLQ moves a failed batch to the dead letter directory, recording
+// attempt as its last failure.
+func (s *Storage) MoveToDLQ(token string, attempt Attempt) error {
 	if token == "" {
 		return nil
 	}
@@ -184,14 +545,159 @@ func (s *Storage) MoveToDLQ(token string) error {
 		return fmt.Errorf("unexpected token %s", token)
 	}
 
-	base := filepath.Base(strings.TrimSuffix(token, processingExt))
-	dest := filepath.Join(s.dlqDir, base)
+	original := strings.TrimSuffix(token, processingExt)
+	dest := filepath.Join(s.dlqDir, filepath.Base(original))
 	if err := os.Rename(token, dest); err != nil {
 		return fmt.Errorf("move to deadletter: %w", err)
 	}
+	return s.recordFailure(original, dest, attempt)
+}
+
+// MoveEventsToDLQ dead-letters only the given events of a dequeued batch,
+// for deliveries where some of the batch was accepted. The batch is removed.
+func (s *Storage) MoveEventsToDLQ(token string, events []buffer.Event, attempt Attempt) error {
+	if token == "" {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !strings.HasSuffix(token, processingExt) {
+		return fmt.Errorf("unexpected token %s", token)
+	}
+	original := strings.TrimSuffix(token, processingExt)
+	if len(events) > 0 {
+		dest, err := s.writeBatch(s.dlqDir, events)
+		if err != nil {
+			return fmt.Errorf("move to deadletter: %w", err)
+		}
+		if err := s.recordFailure(original, dest, attempt); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(token); err != nil {
+		return fmt.Errorf("remove processed batch: %w", err)
+	}
+	return nil
+}
+
+// recordFailure adds attempt to the failure record of the batch at from,
+// if any, and writes the result next to the batch at to. Callers hold s.mu.
+func (s *Storage) recordFailure(from, to string, attempt Attempt) error {
+	now := time.Now().UTC()
+	failure := readFailure(from)
+	if failure == nil {
+		failure = &Failure{FirstFailure: now}
+	}
+	failure.Error = attempt.Error
+	failure.StatusCode = attempt.StatusCode
+	failure.Attempts++
+	failure.LastFailure = now
+
+	data, err := json.Marshal(failure)
+	if err != nil {
+		return fmt.Errorf("encode failure record: %w", err)
+	}
+	if err := os.WriteFile(to+failureExt, data, 0o644); err != nil {
+		return fmt.Errorf("write failure record: %w", err)
+	}
+	if from != to {
+		os.Remove(from + failureExt)
+	}
 	return nil
 }
 
+// readFailure returns the failure record of the batch at path, or nil when
+// it has none or it cannot be read.
+func readFailure(path string) *Failure {
+	data, err := os.ReadFile(strings.TrimSuffix(path, processingExt) + failureExt)
+	if err != nil {
+		return nil
+	}
+	var failure Failure
+	if err := json.Unmarshal(data, &failure); err != nil {
+		return nil
+	}
+	return &failure
+}
+
+// ReplayDeadLetter moves every dead-lettered batch back into the queue for
+// another delivery attempt and discards their failure records.
+func (s *Storage) ReplayDeadLetter() (Summary, error) {
+	replayed, err := s.DeadLetterSummary()
+	if err != nil {
+		return Summary{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	files, err := listBatchFiles(s.dlqDir)
+	if err != nil {
+		return Summary{}, fmt.Errorf("read deadletter dir: %w", err)
+	}
+	for _, path := range files {
+		if err := os.Rename(path, filepath.Join(s.dir, filepath.Base(path))); err != nil {
+			return Summary{}, fmt.Errorf("replay deadletter: %w", err)
+		}
+		os.Remove(path + failureExt)
+		delete(s.legacyCounts, path)
+	}
+	replayed.LastFailure = nil
+	return replayed, nil
+}
+
+// RetryDeadLetter moves dead-lettered batches back into the queue for
+// another delivery attempt. Unlike ReplayDeadLetter it keeps their failure
+// records, so a batch that fails again returns with one more attempt
+// counted. Batches whose retries after the first failed delivery reached
+// maxRetries are removed instead, with a warning. Requeued batches count
+// towards queue retention from now.
+func (s *Storage) RetryDeadLetter(maxRetries int) (retried, dropped Summary, err error) {
+	batches, err := s.DeadLetterBatches()
+	if err != nil {
+		return Summary{}, Summary{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var retry, drop []BatchInfo
+	now := time.Now()
+	for _, batch := range batches {
+		attempts := 1
+		if batch.Failure != nil {
+			attempts = batch.Failure.Attempts
+		}
+		if attempts > maxRetries {
+			if err := os.Remove(batch.Path); err != nil && !os.IsNotExist(err) {
+				return Summary{}, Summary{}, fmt.Errorf("drop deadletter: %w", err)
+			}
+			os.Remove(batch.Path + failureExt)
+			delete(s.legacyCounts, batch.Path)
+			lastError := ""
+			if batch.Failure != nil {
+				lastError = batch.Failure.Error
+			}
+			logger.Warnf("Dropped dead-lettered batch %s (%d events) after %d failed attempts: %s",
+				filepath.Base(batch.Path), batch.Events, attempts, lastError)
+			drop = append(drop, batch)
+			continue
+		}
+
+		dest := filepath.Join(s.dir, filepath.Base(batch.Path))
+		if err := os.Rename(batch.Path, dest); err != nil {
+			if os.IsNotExist(err) {
+				continue // Purged or cleaned up since listing
+			}
+			return Summary{}, Summary{}, fmt.Errorf("retry deadletter: %w", err)
+		}
+		os.Rename(batch.Path+failureExt, dest+failureExt)
+		os.Chtimes(dest, now, now)
+		delete(s.legacyCounts, batch.Path)
+		retry = append(retry, batch)
+	}
+	return summarize(retry), summarize(drop), nil
+}
+
 func (s *Storage) recoverProcessing() error {
 	entries, err := os.ReadDir(s.dir)
 	if err != nil {
@@ -202,6 +708,11 @@ func (s *Storage) recoverProcessing() error {
 			continue
 		}
 		name := entry.Name()
+		if strings.HasSuffix(name, tempExt) {
+			// Interrupted Enqueue; the batch was never committed
+			_ = os.Remove(filepath.Join(s.dir, name))
+			continue
+		}
 		if strings.HasSuffix(name, processingExt) {
 			src := filepath.Join(s.dir, name)
 			dst := strings.TrimSuffix(src, processingExt)
@@ -214,46 +725,156 @@ func (s *Storage) recoverProcessing() error {
 }
 
 func (s *Storage) listActive() ([]string, error) {
-	entries, err := os.ReadDir(s.dir)
+	files, err := listBatchFiles(s.dir)
 	if err != nil {
 		return nil, fmt.Errorf("read queue dir: %w", err)
 	}
+	return files, nil
+}
+
+// listBatchFiles returns the batch files in dir sorted by name, which is
+// creation order.
+func listBatchFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
 	var files []string
 	for _, entry := range entries {
 		if entry.IsDir() {
 			continue
 		}
-		if strings.HasSuffix(entry.Name(), activeExt) {
-			files = append(files, filepath.Join(s.dir, entry.Name()))
+		if isBatchFile(entry.Name()) {
+			files = append(files, filepath.Join(dir, entry.Name()))
 		}
 	}
 	sort.Strings(files)
 	return files, nil
 }
 
-func (s *Storage) generateFilename() string {
+func isBatchFile(name string) bool {
+	return strings.HasSuffix(name, activeExt) || strings.HasSuffix(name, compressedExt) || strings.HasSuffix(name, encryptedExt)
+}
+
+// generateFilename encodes the creation time and event count, e.g.
+// 1730000000000000000-0042.n500.json.gz, so listing needs no decoding.
+func (s *Storage) generateFilename(events int, ext string) string {
 	now := time.Now().UTC()
-	return fmt.Sprintf("%d-%04d%s", now.UnixNano(), rand.Intn(10000), activeExt)
+	return fmt.Sprintf("%d-%04d.n%d%s", now.UnixNano(), rand.Intn(10000), events, ext)
+}
+
+// eventCountFromName extracts the count written by generateFilename.
+func eventCountFromName(path string) (int, bool) {
+	base := strings.TrimSuffix(filepath.Base(path), processingExt)
+	base = strings.TrimSuffix(base, ".enc")
+	if !strings.HasSuffix(base, compressedExt) {
+		return 0, false
+	}
+	base = strings.TrimSuffix(base, compressedExt)
+	idx := strings.LastIndex(base, ".n")
+	if idx < 0 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(base[idx+2:])
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// createdAtFromName extracts the creation time from the nanosecond prefix
+// used by generateFilename and older releases.
+func createdAtFromName(path string) (time.Time, bool) {
+	base := filepath.Base(path)
+	idx := strings.IndexByte(base, '-')
+	if idx <= 0 {
+		return time.Time{}, false
+	}
+	nanos, err := strconv.ParseInt(base[:idx], 10, 64)
+	if err != nil || nanos <= 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, nanos), true
+}
+
+func isCompressed(path string) bool {
+	path = strings.TrimSuffix(path, processingExt)
+	return strings.HasSuffix(path, compressedExt) || strings.HasSuffix(path, encryptedExt)
+}
+
+// readBatch decodes a plain, gzip-compressed or encrypted batch file.
+// Callers hold s.mu.
+func (s *Storage) readBatch(path string) ([]buffer.Event, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read queue file: %w", err)
+	}
+	if isEncrypted(path) {
+		if data, err = s.open(data); err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(strings.TrimSuffix(path, processingExt)), err)
+		}
+	}
+
+	var reader io.Reader = bytes.NewReader(data)
+	if isCompressed(path) {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, fmt.Errorf("decompress queue file: %w", err)
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	var batch []buffer.Event
+	if err := json.NewDecoder(reader).Decode(&batch); err != nil {
+		return nil, fmt.Errorf("decode queue file: %w", err)
+	}
+	return batch, nil
+}
+
+// SetExpiredHandler has Cleanup pass the events of each pending batch it
+// removes by queue retention to fn first; they were never delivered.
+// Dead-lettered batches are not passed. Call before the first Cleanup.
+func (s *Storage) SetExpiredHandler(fn func(events []buffer.Event)) {
+	s.onExpired = fn
 }
 
 // Cleanup removes files older than retention duration.
 func (s *Storage) Cleanup(queueRetention, dlqRetention time.Duration) error {
 	if queueRetention > 0 {
 		cutoff := time.Now().Add(-queueRetention)
-		if err := cleanupDir(s.dir, cutoff); err != nil {
+		if err := cleanupDir(s.dir, cutoff, s.expire); err != nil {
 			return err
 		}
 	}
 	if dlqRetention > 0 {
 		cutoff := time.Now().Add(-dlqRetention)
-		if err := cleanupDir(s.dlqDir, cutoff); err != nil {
+		if err := cleanupDir(s.dlqDir, cutoff, nil); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func cleanupDir(dir string, cutoff time.Time) error {
+// expire hands a pending batch that retention is about to remove to the
+// expired handler.
+func (s *Storage) expire(path string) {
+	if s.onExpired == nil || filepath.Dir(path) != s.dir || !isBatchFile(filepath.Base(path)) {
+		return
+	}
+	s.mu.Lock()
+	events, err := s.readBatch(path)
+	s.mu.Unlock()
+	if err != nil {
+		logger.Warnf("Failed to read expired batch %s: %v", filepath.Base(path), err)
+		return
+	}
+	s.onExpired(events)
+}
+
+// cleanupDir removes the files in dir last modified before cutoff, calling
+// expire (when set) with each one first.
+func cleanupDir(dir string, cutoff time.Time, expire func(path string)) error {
 	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
@@ -265,18 +886,25 @@ func cleanupDir(dir string, cutoff time.Time) error {
 		if statErr != nil {
 			return statErr
 		}
+		if batch, ok := strings.CutSuffix(path, failureExt); ok {
+			// Failure records go with their batch; drop any left orphaned
+			if _, err := os.Stat(batch); os.IsNotExist(err) {
+				_ = os.Remove(path)
+			}
+			return nil
+		}
 		if info.ModTime().Before(cutoff) {
+			if expire != nil {
+				expire(path)
+			}
 			_ = os.Remove(path)
+			_ = os.Remove(path + failureExt)
 		}
 		return nil
 	})
 }
 
-// DefaultDir returns ~/.yaat/queue.
+// DefaultDir returns the default instance's queue directory, ~/.yaat/queue.
 func DefaultDir() string {
-	home, err := os.UserHomeDir()
-	if err != nil || home == "" {
-		return "./.yaat-queue"
-	}
-	return filepath.Join(home, ".yaat", "queue")
+	return paths.Detect().HomeLayout(paths.DefaultInstance).QueueDir
 }
@@ -0,0 +1,556 @@
+package queue
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+)
+
+func makeEvents(n int) []buffer.Event {
+	events := make([]buffer.Event, n)
+	for i := range events {
+		events[i] = buffer.Event{
+			"event_id":     fmt.Sprintf("evt-%d", i),
+			"event_type":   "log",
+			"service_name": "svc",
+			"level":        "info",
+			"message":      fmt.Sprintf("GET /api/orders/%d completed in 12ms", i),
+			"tags":         map[string]string{"host": "web-1", "path": "/api/orders"},
+		}
+	}
+	return events
+}
+
+// writeLegacyBatch writes an uncompressed batch the way older releases did.
+func writeLegacyBatch(t *testing.T, dir, name string, events []buffer.Event) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	data, err := json.Marshal(events)
+	if err != nil {
+		t.Fatalf("Failed to encode legacy batch: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("Failed to write legacy batch: %v", err)
+	}
+	return path
+}
+
+func TestEnqueueWritesCompressedBatches(t *testing.T) {
+	store, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	if err := store.Enqueue(makeEvents(7)); err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+
+	batches, err := store.ListBatches()
+	if err != nil {
+		t.Fatalf("ListBatches failed: %v", err)
+	}
+	if len(batches) != 1 {
+		t.Fatalf("Expected 1 batch, got %d", len(batches))
+	}
+	if !batches[0].Compressed || !strings.HasSuffix(batches[0].Path, ".n7.json.gz") {
+		t.Errorf("Expected compressed batch with event count in name, got %s", batches[0].Path)
+	}
+	if batches[0].Events != 7 {
+		t.Errorf("Expected 7 events, got %d", batches[0].Events)
+	}
+
+	token, events, err := store.Dequeue()
+	if err != nil {
+		t.Fatalf("Dequeue failed: %v", err)
+	}
+	if len(events) != 7 || events[3]["event_id"] != "evt-3" {
+		t.Errorf("Unexpected dequeued events: %d", len(events))
+	}
+	if err := store.Ack(token); err != nil {
+		t.Fatalf("Ack failed: %v", err)
+	}
+}
+
+func TestMixedFormatQueue(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(dir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	// Legacy batch sorts before anything enqueued now
+	writeLegacyBatch(t, dir, "1000000000000000000-0001.json", makeEvents(3))
+	if err := store.Enqueue(makeEvents(5)); err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+
+	summary, err := store.PendingSummary()
+	if err != nil {
+		t.Fatalf("PendingSummary failed: %v", err)
+	}
+	if summary.Batches != 2 || summary.Events != 8 {
+		t.Errorf("Expected 2 batches / 8 events, got %+v", summary)
+	}
+
+	token, events, err := store.Dequeue()
+	if err != nil {
+		t.Fatalf("Dequeue legacy failed: %v", err)
+	}
+	if len(events) != 3 {
+		t.Errorf("Expected legacy batch first with 3 events, got %d", len(events))
+	}
+	if err := store.Fail(token); err != nil {
+		t.Fatalf("Fail failed: %v", err)
+	}
+
+	// Both batches are now claimed and left in processing state, as after a crash
+	legacyToken, _, err := store.Dequeue()
+	if err != nil {
+		t.Fatalf("Dequeue failed: %v", err)
+	}
+	gzToken, gzEvents, err := store.Dequeue()
+	if err != nil {
+		t.Fatalf("Dequeue compressed failed: %v", err)
+	}
+	if len(gzEvents) != 5 {
+		t.Errorf("Expected compressed batch with 5 events, got %d", len(gzEvents))
+	}
+	for _, tok := range []string{legacyToken, gzToken} {
+		if !strings.HasSuffix(tok, processingExt) {
+			t.Fatalf("Expected processing token, got %s", tok)
+		}
+	}
+
+	recovered, err := New(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen storage: %v", err)
+	}
+	summary, err = recovered.PendingSummary()
+	if err != nil {
+		t.Fatalf("PendingSummary failed: %v", err)
+	}
+	if summary.Batches != 2 || summary.Events != 8 {
+		t.Errorf("Expected recovered 2 batches / 8 events, got %+v", summary)
+	}
+
+	total := 0
+	for {
+		token, events, err := recovered.Dequeue()
+		if err != nil {
+			t.Fatalf("Dequeue after recovery failed: %v", err)
+		}
+		if events == nil {
+			break
+		}
+		total += len(events)
+		if err := recovered.Ack(token); err != nil {
+			t.Fatalf("Ack failed: %v", err)
+		}
+	}
+	if total != 8 {
+		t.Errorf("Expected to drain 8 events, got %d", total)
+	}
+}
+
+func TestRecoverRemovesPartialWrites(t *testing.T) {
+	dir := t.TempDir()
+	partial := filepath.Join(dir, "1000000000000000000-0001.n5.json.gz"+tempExt)
+	if err := os.WriteFile(partial, []byte("garbage"), 0o644); err != nil {
+		t.Fatalf("Failed to write partial file: %v", err)
+	}
+
+	store, err := New(dir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	if _, err := os.Stat(partial); !os.IsNotExist(err) {
+		t.Errorf("Expected partial write to be removed, stat err: %v", err)
+	}
+	if n, _ := store.Pending(); n != 0 {
+		t.Errorf("Expected empty queue, got %d", n)
+	}
+}
+
+func TestOpenLeavesBatchesInFlight(t *testing.T) {
+	dir := t.TempDir()
+	daemon, err := New(dir)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	if err := daemon.Enqueue(makeEvents(3)); err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+	token, _, err := daemon.Dequeue()
+	if err != nil {
+		t.Fatalf("Dequeue failed: %v", err)
+	}
+
+	// Another process inspecting the queue must not hand the batch out again
+	inspector, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if _, err := os.Stat(token); err != nil {
+		t.Errorf("Expected the batch in flight to stay in flight: %v", err)
+	}
+	if n, _ := inspector.Pending(); n != 0 {
+		t.Errorf("Expected no pending batches, got %d", n)
+	}
+}
+
+func TestMoveEventsToDLQKeepsOnlyUndelivered(t *testing.T) {
+	store, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	if err := store.Enqueue(makeEvents(6)); err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+
+	token, events, err := store.Dequeue()
+	if err != nil || len(events) != 6 {
+		t.Fatalf("Dequeue failed: %v (%d events)", err, len(events))
+	}
+	if err := store.MoveEventsToDLQ(token, events[4:], Attempt{}); err != nil {
+		t.Fatalf("MoveEventsToDLQ failed: %v", err)
+	}
+
+	if pending, _ := store.Pending(); pending != 0 {
+		t.Errorf("Expected processed batch to be removed, got %d pending", pending)
+	}
+	if _, err := os.Stat(token); !os.IsNotExist(err) {
+		t.Errorf("Expected processing file to be removed, got %v", err)
+	}
+	files, err := listBatchFiles(store.DeadLetterDir())
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected 1 dead-letter batch, got %d (%v)", len(files), err)
+	}
+	if n, _ := eventCountFromName(files[0]); n != 2 {
+		t.Errorf("Expected 2 dead-lettered events, got %d", n)
+	}
+}
+
+func TestTrimRemovesOldestBatches(t *testing.T) {
+	store, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	for _, n := range []int{5, 10, 20} {
+		if err := store.Enqueue(makeEvents(n)); err != nil {
+			t.Fatalf("Enqueue failed: %v", err)
+		}
+	}
+
+	batches, err := store.ListBatches()
+	if err != nil {
+		t.Fatalf("ListBatches failed: %v", err)
+	}
+	var total int64
+	for _, b := range batches {
+		total += b.Bytes
+	}
+
+	dropped, err := store.Trim(total - 1)
+	if err != nil {
+		t.Fatalf("Trim failed: %v", err)
+	}
+	if dropped != 5 {
+		t.Errorf("Expected the oldest batch (5 events) to be dropped, got %d", dropped)
+	}
+
+	summary, err := store.PendingSummary()
+	if err != nil {
+		t.Fatalf("PendingSummary failed: %v", err)
+	}
+	if summary.Batches != 2 || summary.Events != 30 {
+		t.Errorf("Expected 2 batches / 30 events, got %+v", summary)
+	}
+
+	if dropped, _ := store.Trim(summary.Bytes); dropped != 0 {
+		t.Errorf("Expected no-op trim within bound, dropped %d", dropped)
+	}
+}
+
+func TestQueueEventCountsAndOldestAge(t *testing.T) {
+	store, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	if age, _ := store.OldestBatchAge(); age != 0 {
+		t.Errorf("Expected zero age for an empty queue, got %v", age)
+	}
+
+	// A legacy batch written an hour ago, then two current batches
+	created := time.Now().Add(-time.Hour)
+	writeLegacyBatch(t, store.Dir(), fmt.Sprintf("%d-0001.json", created.UnixNano()), makeEvents(4))
+	for _, n := range []int{3, 8} {
+		if err := store.Enqueue(makeEvents(n)); err != nil {
+			t.Fatalf("Enqueue failed: %v", err)
+		}
+	}
+
+	events, err := store.PendingEvents()
+	if err != nil {
+		t.Fatalf("PendingEvents failed: %v", err)
+	}
+	if events != 15 {
+		t.Errorf("Expected 15 pending events, got %d", events)
+	}
+	age, err := store.OldestBatchAge()
+	if err != nil {
+		t.Fatalf("OldestBatchAge failed: %v", err)
+	}
+	if age < time.Hour || age > time.Hour+time.Minute {
+		t.Errorf("Expected oldest batch age of about an hour, got %v", age)
+	}
+
+	// Dead-letter part of the oldest current batch
+	token, batch, err := store.Dequeue()
+	if err != nil {
+		t.Fatalf("Dequeue failed: %v", err)
+	}
+	if err := store.Ack(token); err != nil {
+		t.Fatalf("Ack failed: %v", err)
+	}
+	token, batch, err = store.Dequeue()
+	if err != nil || len(batch) != 3 {
+		t.Fatalf("Dequeue failed: %v (%d events)", err, len(batch))
+	}
+	if err := store.MoveEventsToDLQ(token, batch[:2], Attempt{}); err != nil {
+		t.Fatalf("MoveEventsToDLQ failed: %v", err)
+	}
+
+	pending, err := store.PendingSummary()
+	if err != nil {
+		t.Fatalf("PendingSummary failed: %v", err)
+	}
+	if pending.Batches != 1 || pending.Events != 8 {
+		t.Errorf("Expected 1 batch / 8 events pending, got %+v", pending)
+	}
+	if pending.OldestAge() > time.Minute {
+		t.Errorf("Expected a fresh oldest batch after the legacy one was sent, got %v", pending.OldestAge())
+	}
+
+	dead, err := store.DeadLetterSummary()
+	if err != nil {
+		t.Fatalf("DeadLetterSummary failed: %v", err)
+	}
+	if dead.Batches != 1 || dead.Events != 2 {
+		t.Errorf("Expected 1 batch / 2 events dead-lettered, got %+v", dead)
+	}
+	if dead.Oldest.IsZero() {
+		t.Error("Expected dead-letter oldest time to be set")
+	}
+}
+
+func TestPurgeDeadLetterAndReset(t *testing.T) {
+	store, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	for _, n := range []int{4, 6, 9} {
+		if err := store.Enqueue(makeEvents(n)); err != nil {
+			t.Fatalf("Enqueue failed: %v", err)
+		}
+	}
+	token, events, err := store.Dequeue()
+	if err != nil {
+		t.Fatalf("Dequeue failed: %v", err)
+	}
+	if err := store.MoveEventsToDLQ(token, events, Attempt{}); err != nil {
+		t.Fatalf("MoveEventsToDLQ failed: %v", err)
+	}
+	// A batch mid-delivery is removed by Reset too
+	if _, _, err := store.Dequeue(); err != nil {
+		t.Fatalf("Dequeue failed: %v", err)
+	}
+
+	purged, err := store.PurgeDeadLetter()
+	if err != nil {
+		t.Fatalf("PurgeDeadLetter failed: %v", err)
+	}
+	if purged.Batches != 1 || purged.Events != 4 {
+		t.Errorf("Expected 1 batch / 4 events purged, got %+v", purged)
+	}
+	if n, _ := store.DeadLetterPending(); n != 0 {
+		t.Errorf("Expected empty deadletter queue, got %d", n)
+	}
+	if n, _ := store.Pending(); n != 1 {
+		t.Errorf("Expected pending batches to survive a purge, got %d", n)
+	}
+
+	if err := store.Enqueue(makeEvents(2)); err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+	token, events, _ = store.Dequeue()
+	store.MoveEventsToDLQ(token, events, Attempt{})
+
+	pending, deadLetter, err := store.Reset()
+	if err != nil {
+		t.Fatalf("Reset failed: %v", err)
+	}
+	if pending.Batches != 1 || pending.Events != 2 {
+		t.Errorf("Expected 1 batch / 2 events reset, got %+v", pending)
+	}
+	if deadLetter.Batches != 1 || deadLetter.Events != 9 {
+		t.Errorf("Expected 1 batch / 9 events purged, got %+v", deadLetter)
+	}
+	entries, _ := os.ReadDir(store.Dir())
+	if len(entries) != 1 || entries[0].Name() != "deadletter" {
+		t.Errorf("Expected only the deadletter directory to remain, got %v", entries)
+	}
+}
+
+func TestDeadLetterFailureRecordRoundTrip(t *testing.T) {
+	store, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if err := store.Enqueue(makeEvents(4)); err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+	token, _, _ := store.Dequeue()
+	if err := store.MoveToDLQ(token, Attempt{Error: "authentication failed: invalid API key", StatusCode: 401}); err != nil {
+		t.Fatalf("MoveToDLQ failed: %v", err)
+	}
+
+	batches, err := store.DeadLetterBatches()
+	if err != nil || len(batches) != 1 {
+		t.Fatalf("Expected 1 dead-letter batch, got %d (%v)", len(batches), err)
+	}
+	failure := batches[0].Failure
+	if failure == nil {
+		t.Fatal("Expected a failure record")
+	}
+	if failure.StatusCode != 401 || failure.Attempts != 1 || !strings.Contains(failure.Error, "invalid API key") {
+		t.Errorf("Unexpected failure record %+v", failure)
+	}
+	if failure.FirstFailure.IsZero() || failure.LastFailure.Before(failure.FirstFailure) {
+		t.Errorf("Expected failure timestamps, got %+v", failure)
+	}
+	if summary, _ := store.DeadLetterSummary(); summary.LastFailure == nil || summary.LastFailure.StatusCode != 401 {
+		t.Errorf("Expected the summary to carry the last failure, got %+v", summary.LastFailure)
+	}
+	if n, _ := store.DeadLetterPending(); n != 1 {
+		t.Errorf("Expected the failure record not to count as a batch, got %d", n)
+	}
+
+	replayed, err := store.ReplayDeadLetter()
+	if err != nil {
+		t.Fatalf("ReplayDeadLetter failed: %v", err)
+	}
+	if replayed.Batches != 1 || replayed.Events != 4 {
+		t.Errorf("Expected 1 batch / 4 events replayed, got %+v", replayed)
+	}
+	entries, _ := os.ReadDir(store.DeadLetterDir())
+	if len(entries) != 0 {
+		t.Errorf("Expected an empty deadletter directory after replay, got %v", entries)
+	}
+	pending, _ := store.ListBatches()
+	if len(pending) != 1 || pending[0].Failure != nil {
+		t.Fatalf("Expected the batch pending again without a failure record, got %+v", pending)
+	}
+
+	// Failing again starts a fresh record, and a partial failure keeps one too
+	token, events, _ := store.Dequeue()
+	if err := store.MoveEventsToDLQ(token, events[:1], Attempt{Error: "rate limited", StatusCode: 429}); err != nil {
+		t.Fatalf("MoveEventsToDLQ failed: %v", err)
+	}
+	batches, _ = store.DeadLetterBatches()
+	if len(batches) != 1 || batches[0].Failure == nil || batches[0].Failure.Attempts != 1 || batches[0].Failure.StatusCode != 429 {
+		t.Errorf("Expected a fresh 429 failure record, got %+v", batches)
+	}
+}
+
+func TestRetryDeadLetterCountsAttempts(t *testing.T) {
+	store, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if err := store.Enqueue(makeEvents(3)); err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+	fail := func() {
+		t.Helper()
+		token, events, err := store.Dequeue()
+		if err != nil || events == nil {
+			t.Fatalf("Expected a queued batch, got %v (%v)", events, err)
+		}
+		if err := store.MoveToDLQ(token, Attempt{Error: "server error", StatusCode: 500}); err != nil {
+			t.Fatalf("MoveToDLQ failed: %v", err)
+		}
+	}
+
+	// The first delivery and two retries fail
+	fail()
+	for retry := 1; retry <= 2; retry++ {
+		retried, dropped, err := store.RetryDeadLetter(2)
+		if err != nil {
+			t.Fatalf("RetryDeadLetter failed: %v", err)
+		}
+		if retried.Batches != 1 || retried.Events != 3 || dropped.Batches != 0 {
+			t.Fatalf("Retry %d: expected 1 batch requeued, got %+v / dropped %+v", retry, retried, dropped)
+		}
+		pending, _ := store.ListBatches()
+		if len(pending) != 1 || pending[0].Failure == nil || pending[0].Failure.Attempts != retry {
+			t.Fatalf("Retry %d: expected the batch queued with its failure record, got %+v", retry, pending)
+		}
+		if time.Since(pending[0].ModTime) > time.Minute {
+			t.Errorf("Retry %d: expected queue retention to count from the retry, got %v", retry, pending[0].ModTime)
+		}
+		fail()
+	}
+	batches, _ := store.DeadLetterBatches()
+	if len(batches) != 1 || batches[0].Failure.Attempts != 3 {
+		t.Fatalf("Expected 3 attempts recorded, got %+v", batches)
+	}
+
+	// Out of retries: the batch is dropped rather than requeued
+	retried, dropped, err := store.RetryDeadLetter(2)
+	if err != nil {
+		t.Fatalf("RetryDeadLetter failed: %v", err)
+	}
+	if retried.Batches != 0 || dropped.Batches != 1 || dropped.Events != 3 {
+		t.Errorf("Expected the batch dropped, got retried %+v / dropped %+v", retried, dropped)
+	}
+	for _, dir := range []string{store.Dir(), store.DeadLetterDir()} {
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				t.Errorf("Expected nothing left in %s, got %s", dir, entry.Name())
+			}
+		}
+	}
+}
+
+func BenchmarkBatchEncoding(b *testing.B) {
+	events := makeEvents(500)
+	var rawBytes, gzBytes int
+	for i := 0; i < b.N; i++ {
+		raw, err := json.Marshal(events)
+		if err != nil {
+			b.Fatal(err)
+		}
+		var compressed bytes.Buffer
+		gz := gzip.NewWriter(&compressed)
+		if _, err := gz.Write(raw); err != nil {
+			b.Fatal(err)
+		}
+		if err := gz.Close(); err != nil {
+			b.Fatal(err)
+		}
+		rawBytes, gzBytes = len(raw), compressed.Len()
+	}
+	b.ReportMetric(float64(rawBytes), "raw-bytes/batch")
+	b.ReportMetric(float64(gzBytes), "gzip-bytes/batch")
+	b.ReportMetric(float64(rawBytes)/float64(gzBytes), "ratio")
+}
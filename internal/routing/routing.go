@@ -0,0 +1,201 @@
+package routing
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+	"github.com/yaat-app/sidecar/internal/config"
+	"github.com/yaat-app/sidecar/internal/diag"
+)
+
+// DefaultRoute is the diag key for events that matched no rule and keep the
+// global environment.
+const DefaultRoute = "default"
+
+type matcher struct {
+	field   string
+	tag     bool
+	pattern *regexp.Regexp
+}
+
+type compiledRule struct {
+	name        string
+	matchers    []matcher
+	environment string
+	serviceName string
+}
+
+var (
+	mu          sync.RWMutex
+	activeRules []*compiledRule
+)
+
+// Configure compiles routing rules from configuration. Invalid globs or
+// regular expressions are reported so misconfiguration fails at startup.
+func Configure(rules []config.RoutingRule) error {
+	compiled := make([]*compiledRule, 0, len(rules))
+	for i, rule := range rules {
+		name := strings.TrimSpace(rule.Name)
+		if name == "" {
+			name = fmt.Sprintf("rule_%d", i+1)
+		}
+		if name == DefaultRoute {
+			return fmt.Errorf("routing rule name %q is reserved", DefaultRoute)
+		}
+		if rule.Environment == "" && rule.ServiceName == "" {
+			return fmt.Errorf("routing rule %q must set environment or service_name", name)
+		}
+		if len(rule.Match) == 0 {
+			return fmt.Errorf("routing rule %q has no matchers", name)
+		}
+
+		cr := &compiledRule{name: name, environment: rule.Environment, serviceName: rule.ServiceName}
+		for _, m := range rule.Match {
+			compiledMatcher, err := compileMatcher(m)
+			if err != nil {
+				return fmt.Errorf("routing rule %q: %w", name, err)
+			}
+			cr.matchers = append(cr.matchers, compiledMatcher)
+		}
+		compiled = append(compiled, cr)
+	}
+
+	mu.Lock()
+	activeRules = compiled
+	mu.Unlock()
+	return nil
+}
+
+func compileMatcher(m config.RouteMatcher) (matcher, error) {
+	field := strings.TrimSpace(m.Field)
+	if field == "" {
+		return matcher{}, fmt.Errorf("matcher has an empty field")
+	}
+
+	var expr string
+	switch {
+	case m.Glob != "" && m.Regex != "":
+		return matcher{}, fmt.Errorf("matcher on %s sets both glob and regex", field)
+	case m.Glob != "":
+		expr = globToRegex(m.Glob)
+	case m.Regex != "":
+		expr = m.Regex
+	default:
+		return matcher{}, fmt.Errorf("matcher on %s needs a glob or regex", field)
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return matcher{}, fmt.Errorf("matcher on %s: %w", field, err)
+	}
+
+	compiled := matcher{field: field, pattern: re}
+	if strings.HasPrefix(strings.ToLower(field), "tags.") {
+		compiled.tag = true
+		compiled.field = field[5:]
+	}
+	return compiled, nil
+}
+
+// globToRegex converts a shell-style glob (* and ?) into an anchored regex.
+func globToRegex(glob string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+// Apply routes a single event and returns the name of the matching rule, or
+// DefaultRoute when the event keeps its current environment.
+func Apply(evt buffer.Event) string {
+	mu.RLock()
+	rules := activeRules
+	mu.RUnlock()
+
+	return apply(rules, evt)
+}
+
+// ApplyBatch routes every event and records per-rule counts in diag.
+func ApplyBatch(events []buffer.Event) {
+	mu.RLock()
+	rules := activeRules
+	mu.RUnlock()
+
+	if len(rules) == 0 || len(events) == 0 {
+		return
+	}
+
+	counts := make(map[string]int)
+	for _, evt := range events {
+		counts[apply(rules, evt)]++
+	}
+	diag.Global().RecordRouting(counts)
+}
+
+func apply(rules []*compiledRule, evt buffer.Event) string {
+	if evt == nil {
+		return DefaultRoute
+	}
+	for _, rule := range rules {
+		if !rule.matches(evt) {
+			continue
+		}
+		if rule.environment != "" {
+			evt["environment"] = rule.environment
+		}
+		if rule.serviceName != "" {
+			evt["service_name"] = rule.serviceName
+		}
+		return rule.name
+	}
+	return DefaultRoute
+}
+
+func (r *compiledRule) matches(evt buffer.Event) bool {
+	for _, m := range r.matchers {
+		value, ok := fieldValue(evt, m)
+		if !ok || !m.pattern.MatchString(value) {
+			return false
+		}
+	}
+	return true
+}
+
+func fieldValue(evt buffer.Event, m matcher) (string, bool) {
+	if !m.tag {
+		value, ok := evt[m.field]
+		if !ok || value == nil {
+			return "", false
+		}
+		if str, ok := value.(string); ok {
+			return str, true
+		}
+		return fmt.Sprint(value), true
+	}
+
+	switch tags := evt["tags"].(type) {
+	case map[string]string:
+		value, ok := tags[m.field]
+		return value, ok
+	case map[string]interface{}:
+		value, ok := tags[m.field]
+		if !ok {
+			return "", false
+		}
+		return fmt.Sprint(value), true
+	}
+	return "", false
+}
@@ -0,0 +1,139 @@
+package routing
+
+import (
+	"testing"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+	"github.com/yaat-app/sidecar/internal/config"
+	"github.com/yaat-app/sidecar/internal/diag"
+	"github.com/yaat-app/sidecar/internal/logs"
+)
+
+// dockerEvent parses a Docker JSON log line and adds tags the way the tailer
+// merges global tags.
+func dockerEvent(t *testing.T, line string, tags map[string]string) buffer.Event {
+	t.Helper()
+	event := logs.ParseLog(line, "docker", "org", "svc", "production")
+	if event == nil {
+		t.Fatalf("Failed to parse docker line: %s", line)
+	}
+	existing, _ := (*event)["tags"].(map[string]string)
+	if existing == nil {
+		existing = make(map[string]string)
+	}
+	for k, v := range tags {
+		existing[k] = v
+	}
+	(*event)["tags"] = existing
+	return *event
+}
+
+func TestRouteDockerEventsByNamespace(t *testing.T) {
+	err := Configure([]config.RoutingRule{
+		{
+			Name:        "staging",
+			Match:       []config.RouteMatcher{{Field: "tags.k8s.namespace", Glob: "staging-*"}},
+			Environment: "staging",
+		},
+		{
+			Name:        "billing-worker",
+			Match:       []config.RouteMatcher{{Field: "tags.k8s.namespace", Regex: "^prod-"}, {Field: "message", Glob: "*invoice*"}},
+			Environment: "production",
+			ServiceName: "billing-worker",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Configure failed: %v", err)
+	}
+	defer Configure(nil)
+
+	line := `{"log":"processed invoice 42\n","stream":"stdout","time":"2024-10-26T10:30:15.123Z"}`
+	staging := dockerEvent(t, line, map[string]string{"k8s.namespace": "staging-web"})
+	prod := dockerEvent(t, line, map[string]string{"k8s.namespace": "prod-billing"})
+	other := dockerEvent(t, `{"log":"GET /health\n","stream":"stdout"}`, map[string]string{"k8s.namespace": "prod-web"})
+	untagged := dockerEvent(t, line, nil)
+
+	before := diag.Global().Snapshot().RoutedEvents
+	ApplyBatch([]buffer.Event{staging, prod, other, untagged})
+
+	if staging["environment"] != "staging" || staging["service_name"] != "svc" {
+		t.Errorf("Expected staging/svc, got %v/%v", staging["environment"], staging["service_name"])
+	}
+	if prod["environment"] != "production" || prod["service_name"] != "billing-worker" {
+		t.Errorf("Expected production/billing-worker, got %v/%v", prod["environment"], prod["service_name"])
+	}
+	if other["environment"] != "production" || other["service_name"] != "svc" {
+		t.Errorf("Expected unmatched event to keep defaults, got %v/%v", other["environment"], other["service_name"])
+	}
+	if untagged["environment"] != "production" {
+		t.Errorf("Expected untagged event to keep global environment, got %v", untagged["environment"])
+	}
+
+	after := diag.Global().Snapshot().RoutedEvents
+	for rule, expected := range map[string]int64{"staging": 1, "billing-worker": 1, DefaultRoute: 2} {
+		if got := after[rule] - before[rule]; got != expected {
+			t.Errorf("Expected %d events counted for %s, got %d", expected, rule, got)
+		}
+	}
+}
+
+func TestFirstMatchingRuleWins(t *testing.T) {
+	err := Configure([]config.RoutingRule{
+		{Name: "first", Match: []config.RouteMatcher{{Field: "level", Glob: "*"}}, Environment: "one"},
+		{Name: "second", Match: []config.RouteMatcher{{Field: "level", Glob: "error"}}, Environment: "two"},
+	})
+	if err != nil {
+		t.Fatalf("Configure failed: %v", err)
+	}
+	defer Configure(nil)
+
+	event := buffer.Event{"level": "error", "environment": "production"}
+	if rule := Apply(event); rule != "first" {
+		t.Errorf("Expected first rule, got %s", rule)
+	}
+	if event["environment"] != "one" {
+		t.Errorf("Expected environment one, got %v", event["environment"])
+	}
+}
+
+func TestConfigureRejectsInvalidRules(t *testing.T) {
+	tests := []struct {
+		name string
+		rule config.RoutingRule
+	}{
+		{"bad regex", config.RoutingRule{Name: "r", Match: []config.RouteMatcher{{Field: "message", Regex: "("}}, Environment: "x"}},
+		{"no target", config.RoutingRule{Name: "r", Match: []config.RouteMatcher{{Field: "message", Glob: "*"}}}},
+		{"no matchers", config.RoutingRule{Name: "r", Environment: "x"}},
+		{"glob and regex", config.RoutingRule{Name: "r", Match: []config.RouteMatcher{{Field: "message", Glob: "*", Regex: ".*"}}, Environment: "x"}},
+		{"empty field", config.RoutingRule{Name: "r", Match: []config.RouteMatcher{{Glob: "*"}}, Environment: "x"}},
+		{"reserved name", config.RoutingRule{Name: DefaultRoute, Match: []config.RouteMatcher{{Field: "message", Glob: "*"}}, Environment: "x"}},
+	}
+
+	for _, tt := range tests {
+		if err := Configure([]config.RoutingRule{tt.rule}); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
+
+func TestGlobToRegex(t *testing.T) {
+	tests := []struct {
+		glob  string
+		value string
+		match bool
+	}{
+		{"staging-*", "staging-web", true},
+		{"staging-*", "prod-staging-web", false},
+		{"web-?", "web-1", true},
+		{"a.b", "axb", false},
+	}
+	for _, tt := range tests {
+		m, err := compileMatcher(config.RouteMatcher{Field: "f", Glob: tt.glob})
+		if err != nil {
+			t.Fatalf("compileMatcher(%q) failed: %v", tt.glob, err)
+		}
+		if got := m.pattern.MatchString(tt.value); got != tt.match {
+			t.Errorf("glob %q on %q: expected %v, got %v", tt.glob, tt.value, tt.match, got)
+		}
+	}
+}
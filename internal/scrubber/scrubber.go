@@ -3,19 +3,52 @@ package scrubber
 import (
 	"fmt"
 	"regexp"
+	"regexp/syntax"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/yaat-app/sidecar/internal/buffer"
 	"github.com/yaat-app/sidecar/internal/config"
+	"github.com/yaat-app/sidecar/internal/diag"
+	"github.com/yaat-app/sidecar/internal/logging"
 )
 
+var logger = logging.New("Scrubber")
+
+// maxProgramSize bounds the compiled size of a rule's pattern. Matching is
+// linear in the input but proportional to this size, so huge patterns (often
+// nested counted repetitions) are rejected rather than run on every event.
+const maxProgramSize = 10000
+
+// defaultRuleBudget is used when scrubbing.rule_budget is not set.
+const defaultRuleBudget = 100 * time.Millisecond
+
+// tripAfterOverruns is how many consecutive events a rule may take longer
+// than its budget on before it trips. A single GC pause or CPU spike does
+// not trip it.
+const tripAfterOverruns = 3
+
+// trippedReplacement replaces every field a tripped rule covers.
+const trippedReplacement = "[SCRUBBED: rule_budget exceeded]"
+
+// structuralFields identify an event rather than carry its content. A
+// tripped "*" rule leaves them, or the API would reject every event.
+var structuralFields = map[string]bool{
+	"organization_id": true, "service_name": true, "environment": true,
+	"event_id": true, "event_type": true, "timestamp": true, "received_at": true,
+	"level": true, "trace_id": true, "span_id": true, "parent_span_id": true,
+	"metric_name": true,
+}
+
 type fieldKind int
 
 const (
 	fieldTopLevel fieldKind = iota
 	fieldTagExact
 	fieldTagWildcard
+	fieldTopLevelWildcard // Every top-level string field
 )
 
 type fieldSelector struct {
@@ -29,74 +62,261 @@ type compiledRule struct {
 	replacement string
 	fields      []fieldSelector
 	drop        bool
+	overruns    atomic.Int32 // Consecutive events that took longer than the budget
+	tripped     atomic.Bool  // Redacts its fields wholesale instead of matching
+}
+
+// tagAllowlist keeps only tags named exactly or under a listed prefix.
+type tagAllowlist struct {
+	exact    map[string]bool
+	prefixes []string // "http." for an "http.*" entry
+}
+
+// Scrubber applies one set of compiled rules and a tag allowlist.
+type Scrubber struct {
+	rules     []*compiledRule
+	allowlist *tagAllowlist
+	budget    time.Duration
 }
 
 var (
-	mu          sync.RWMutex
-	activeRules []*compiledRule
-	enabled     bool
+	mu     sync.RWMutex
+	active *Scrubber // nil while scrubbing is disabled
 )
 
-// Configure installs scrubbing rules compiled from configuration.
-func Configure(cfg config.ScrubbingConfig) error {
-	mu.Lock()
-	defer mu.Unlock()
-
-	if !cfg.Enabled || len(cfg.Rules) == 0 {
-		activeRules = nil
-		enabled = false
-		return nil
+// New compiles cfg into a Scrubber. It returns nil, which keeps every event
+// unchanged, when cfg is disabled or has nothing to apply.
+func New(cfg config.ScrubbingConfig) (*Scrubber, error) {
+	if !cfg.Enabled || (len(cfg.Rules) == 0 && len(cfg.TagAllowlist) == 0) {
+		return nil, nil
 	}
 
 	compiled := make([]*compiledRule, 0, len(cfg.Rules))
 	for _, rule := range cfg.Rules {
-		pattern := strings.TrimSpace(rule.Pattern)
-		if pattern == "" {
-			return fmt.Errorf("scrubbing rule %q has an empty pattern", rule.Name)
-		}
-		re, err := regexp.Compile(pattern)
+		c, err := compileRule(rule)
 		if err != nil {
-			return fmt.Errorf("scrubbing rule %q: %w", rule.Name, err)
+			return nil, err
 		}
-		selectors := buildSelectors(rule.Fields)
-		compiled = append(compiled, &compiledRule{
-			name:        rule.Name,
-			pattern:     re,
-			replacement: rule.Replacement,
-			fields:      selectors,
-			drop:        rule.Drop,
-		})
-	}
-
-	activeRules = compiled
-	enabled = true
+		compiled = append(compiled, c)
+	}
+
+	s := &Scrubber{
+		rules:     compiled,
+		allowlist: buildAllowlist(cfg.TagAllowlist),
+		budget:    defaultRuleBudget,
+	}
+	if cfg.RuleBudgetDuration > 0 {
+		s.budget = cfg.RuleBudgetDuration
+	}
+	return s, nil
+}
+
+// Configure installs scrubbing rules compiled from configuration as the ones
+// Apply uses.
+func Configure(cfg config.ScrubbingConfig) error {
+	s, err := New(cfg)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	active = s
+	mu.Unlock()
 	return nil
 }
 
-// Apply applies configured rules to the provided event. Returns false when the
-// event should be dropped.
-func Apply(evt buffer.Event) bool {
-	if evt == nil {
+func compileRule(rule config.ScrubRule) (*compiledRule, error) {
+	pattern := strings.TrimSpace(rule.Pattern)
+	if pattern == "" {
+		return nil, fmt.Errorf("scrubbing rule %q has an empty pattern", rule.Name)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("scrubbing rule %q: %w", rule.Name, err)
+	}
+	if size := programSize(pattern); size > maxProgramSize {
+		return nil, fmt.Errorf("scrubbing rule %q: pattern too complex (%d instructions, max %d); reduce counted repetitions like {1000}", rule.Name, size, maxProgramSize)
+	}
+	return &compiledRule{
+		name:        rule.Name,
+		pattern:     re,
+		replacement: rule.Replacement,
+		fields:      buildSelectors(rule.Fields),
+		drop:        rule.Drop,
+	}, nil
+}
+
+// programSize returns the number of instructions pattern compiles to, or 0
+// when it does not parse (regexp.Compile has reported that already).
+func programSize(pattern string) int {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return 0
+	}
+	prog, err := syntax.Compile(re.Simplify())
+	if err != nil {
+		return 0
+	}
+	return len(prog.Inst)
+}
+
+// ApplyRule compiles rule exactly as Configure would and applies it to evt
+// alone, ignoring the configured rules. Returns false when the rule drops
+// the event.
+func ApplyRule(rule config.ScrubRule, evt buffer.Event) (bool, error) {
+	compiled, err := compileRule(rule)
+	if err != nil {
+		return false, err
+	}
+	return compiled.apply(evt), nil
+}
+
+func buildAllowlist(entries []string) *tagAllowlist {
+	if len(entries) == 0 {
+		return nil
+	}
+	list := &tagAllowlist{exact: make(map[string]bool, len(entries))}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if prefix, ok := strings.CutSuffix(entry, "*"); ok {
+			list.prefixes = append(list.prefixes, prefix)
+		} else if entry != "" {
+			list.exact[entry] = true
+		}
+	}
+	return list
+}
+
+func (l *tagAllowlist) allows(key string) bool {
+	if l.exact[key] {
 		return true
 	}
+	for _, prefix := range l.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply replaces the event's tags with the allowed ones. The map is copied
+// rather than edited, as inputs may share one tag map between events.
+func (l *tagAllowlist) apply(evt buffer.Event) {
+	tags := ensureTags(evt)
+	if len(tags) == 0 {
+		return
+	}
+	kept := make(map[string]string, len(tags))
+	for key, value := range tags {
+		if l.allows(key) {
+			kept[key] = value
+		}
+	}
+	if len(kept) < len(tags) {
+		evt["tags"] = kept
+	}
+}
 
+// Apply applies the configured rules, then the tag allowlist, to the
+// provided event. Returns false when the event should be dropped.
+func Apply(evt buffer.Event) bool {
 	mu.RLock()
-	rules := activeRules
-	active := enabled
+	s := active
 	mu.RUnlock()
+	return s.Apply(evt)
+}
 
-	if !active || len(rules) == 0 {
+// Apply applies the rules, then the tag allowlist, to evt. Returns false
+// when the event should be dropped. A nil Scrubber keeps every event.
+func (s *Scrubber) Apply(evt buffer.Event) bool {
+	if s == nil || evt == nil {
 		return true
 	}
 
-	for _, rule := range rules {
-		if !rule.apply(evt) {
+	for _, rule := range s.rules {
+		if rule.tripped.Load() {
+			rule.redact(evt)
+			continue
+		}
+		start := time.Now()
+		keep := rule.apply(evt)
+		elapsed := time.Since(start)
+		if elapsed <= s.budget {
+			rule.overruns.Store(0)
+		} else if overruns := rule.overruns.Add(1); overruns < tripAfterOverruns {
+			logger.Warnf("Scrubbing rule %q took %v on one event (rule_budget %v, %d of %d in a row before it trips)", rule.name, elapsed.Round(time.Millisecond), s.budget, overruns, tripAfterOverruns)
+		} else if rule.tripped.CompareAndSwap(false, true) {
+			logger.Errorf("Scrubbing rule %q took longer than rule_budget %v on %d events in a row (last: %v); the fields it covers are now replaced with %q on every event until its pattern is fixed and the sidecar restarted", rule.name, s.budget, overruns, elapsed.Round(time.Millisecond), trippedReplacement)
+			diag.Global().RecordTrippedScrubRule(rule.name)
+		}
+		if !keep {
 			return false
 		}
 	}
+	if s.allowlist != nil {
+		s.allowlist.apply(evt)
+	}
 	return true
 }
 
+// Tags returns tags as the active rules and tag allowlist leave them on an
+// event; see (*Scrubber).Tags.
+func Tags(tags map[string]string) map[string]string {
+	mu.RLock()
+	s := active
+	mu.RUnlock()
+	return s.Tags(tags)
+}
+
+// Tags returns a copy of tags as the rules and tag allowlist leave them on
+// an event, for adding tags after scrubbing without undoing it. It returns
+// nil when a drop rule matches one of them. A nil Scrubber returns tags
+// unchanged.
+func (s *Scrubber) Tags(tags map[string]string) map[string]string {
+	if s == nil || len(tags) == 0 {
+		return tags
+	}
+	copied := make(map[string]string, len(tags))
+	for k, v := range tags {
+		copied[k] = v
+	}
+	evt := buffer.Event{"tags": copied}
+	if !s.Apply(evt) {
+		return nil
+	}
+	return ensureTags(evt)
+}
+
+// redact replaces every non-empty field the rule covers, so a rule too slow
+// to match fails closed rather than letting what it guards through. Drop
+// rules redact too instead of dropping every event.
+func (r *compiledRule) redact(evt buffer.Event) {
+	for _, selector := range r.fields {
+		switch selector.kind {
+		case fieldTopLevel:
+			if value, ok := getStringField(evt, selector.key); ok && value != "" {
+				evt[selector.key] = trippedReplacement
+			}
+		case fieldTopLevelWildcard:
+			for key, raw := range evt {
+				if value, ok := raw.(string); ok && value != "" && !structuralFields[key] {
+					evt[key] = trippedReplacement
+				}
+			}
+		case fieldTagExact:
+			if tags := ensureTags(evt); tags != nil {
+				if _, ok := tags[selector.key]; ok {
+					tags[selector.key] = trippedReplacement
+				}
+			}
+		case fieldTagWildcard:
+			tags := ensureTags(evt)
+			for key := range tags {
+				tags[key] = trippedReplacement
+			}
+		}
+	}
+}
+
 func (r *compiledRule) apply(evt buffer.Event) bool {
 	for _, selector := range r.fields {
 		switch selector.kind {
@@ -115,6 +335,23 @@ func (r *compiledRule) apply(evt buffer.Event) bool {
 			if replaced != value {
 				evt[selector.key] = replaced
 			}
+		case fieldTopLevelWildcard:
+			for key, raw := range evt {
+				value, ok := raw.(string)
+				if !ok {
+					continue
+				}
+				if r.drop {
+					if r.pattern.MatchString(value) {
+						return false
+					}
+					continue
+				}
+				replaced := r.pattern.ReplaceAllString(value, r.replacement)
+				if replaced != value {
+					evt[key] = replaced
+				}
+			}
 		case fieldTagExact:
 			tags := ensureTags(evt)
 			if tags == nil {
@@ -170,6 +407,10 @@ func buildSelectors(fields []string) []fieldSelector {
 		if field == "" {
 			continue
 		}
+		if field == "*" {
+			selectors = append(selectors, fieldSelector{kind: fieldTopLevelWildcard})
+			continue
+		}
 		lower := strings.ToLower(field)
 		if strings.HasPrefix(lower, "tags.") {
 			key := strings.TrimSpace(field[5:])
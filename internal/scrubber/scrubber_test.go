@@ -1,10 +1,13 @@
 package scrubber
 
 import (
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/yaat-app/sidecar/internal/buffer"
 	"github.com/yaat-app/sidecar/internal/config"
+	"github.com/yaat-app/sidecar/internal/diag"
 )
 
 func TestScrubberMasksMessage(t *testing.T) {
@@ -102,6 +105,52 @@ func TestScrubberWildcardTags(t *testing.T) {
 	}
 }
 
+func TestScrubberWildcardTopLevel(t *testing.T) {
+	cfg := config.ScrubbingConfig{
+		Enabled: true,
+		Rules: []config.ScrubRule{
+			{
+				Name:        "Mask emails everywhere",
+				Pattern:     `(?i)[A-Z0-9._%+-]+@[A-Z0-9.-]+\.[A-Z]{2,}`,
+				Replacement: "[EMAIL]",
+				Fields:      []string{"*"},
+			},
+		},
+	}
+
+	if err := Configure(cfg); err != nil {
+		t.Fatalf("configure: %v", err)
+	}
+	defer Configure(config.ScrubbingConfig{})
+
+	event := buffer.Event{
+		"operation":    "GET /users/jane@example.com",
+		"message":      "reset sent to jane@example.com",
+		"duration_ms":  12.5,
+		"status_code":  200,
+		"metric_value": 3.0,
+		"tags":         map[string]string{"email": "jane@example.com"},
+	}
+
+	if !Apply(event) {
+		t.Fatal("expected event kept")
+	}
+
+	if event["operation"] != "GET /users/[EMAIL]" {
+		t.Errorf("unexpected operation: %v", event["operation"])
+	}
+	if event["message"] != "reset sent to [EMAIL]" {
+		t.Errorf("unexpected message: %v", event["message"])
+	}
+	if event["duration_ms"] != 12.5 || event["status_code"] != 200 || event["metric_value"] != 3.0 {
+		t.Errorf("numeric fields changed: %v", event)
+	}
+	// "*" is distinct from "tags.*"
+	if tags := event["tags"].(map[string]string); tags["email"] != "jane@example.com" {
+		t.Errorf("expected tags untouched, got %v", tags)
+	}
+}
+
 func TestScrubberIgnoresWhenDisabled(t *testing.T) {
 	if err := Configure(config.ScrubbingConfig{}); err != nil {
 		t.Fatalf("configure: %v", err)
@@ -115,3 +164,168 @@ func TestScrubberIgnoresWhenDisabled(t *testing.T) {
 		t.Fatal("expected event kept")
 	}
 }
+
+func TestScrubberTagAllowlist(t *testing.T) {
+	cfg := config.ScrubbingConfig{
+		Enabled: true,
+		Rules: []config.ScrubRule{
+			{
+				Name:        "Mask user ids",
+				Pattern:     `\d+`,
+				Replacement: "[ID]",
+				Fields:      []string{"tags.user_id"},
+			},
+		},
+		TagAllowlist: []string{"http.*", "user_id"},
+	}
+
+	if err := Configure(cfg); err != nil {
+		t.Fatalf("configure: %v", err)
+	}
+	defer Configure(config.ScrubbingConfig{})
+
+	shared := map[string]string{
+		"http.status": "500",
+		"http.method": "POST",
+		"user_id":     "42",
+		"email":       "john@example.com",
+		"httpx":       "1",
+	}
+	event := buffer.Event{"message": "request failed", "tags": shared}
+
+	if !Apply(event) {
+		t.Fatal("expected event to be kept")
+	}
+
+	tags := event["tags"].(map[string]string)
+	want := map[string]string{"http.status": "500", "http.method": "POST", "user_id": "[ID]"}
+	if len(tags) != len(want) {
+		t.Fatalf("expected only allowlisted tags, got %v", tags)
+	}
+	for key, value := range want {
+		if tags[key] != value {
+			t.Errorf("expected %s=%s, got %q", key, value, tags[key])
+		}
+	}
+	if _, ok := shared["email"]; !ok {
+		t.Error("expected the original tag map to be left intact")
+	}
+}
+
+func TestScrubberTagAllowlistWithoutRules(t *testing.T) {
+	if err := Configure(config.ScrubbingConfig{Enabled: true, TagAllowlist: []string{"route"}}); err != nil {
+		t.Fatalf("configure: %v", err)
+	}
+	defer Configure(config.ScrubbingConfig{})
+
+	event := buffer.Event{"tags": map[string]interface{}{"route": "/checkout", "session": "abc"}}
+	if !Apply(event) {
+		t.Fatal("expected event to be kept")
+	}
+	tags := event["tags"].(map[string]string)
+	if len(tags) != 1 || tags["route"] != "/checkout" {
+		t.Fatalf("expected only the route tag, got %v", tags)
+	}
+}
+
+func TestApplyRuleIgnoresConfiguredRules(t *testing.T) {
+	if err := Configure(config.ScrubbingConfig{
+		Enabled: true,
+		Rules:   []config.ScrubRule{{Name: "drop all", Pattern: ".", Drop: true, Fields: []string{"*"}}},
+	}); err != nil {
+		t.Fatalf("configure: %v", err)
+	}
+	defer Configure(config.ScrubbingConfig{})
+
+	event := buffer.Event{"tags": map[string]string{"card": "4111 1111 1111 1111"}}
+	kept, err := ApplyRule(config.ScrubRule{Pattern: `\d{4} \d{4} \d{4} (\d{4})`, Replacement: "**** $1", Fields: []string{"tags.card"}}, event)
+	if err != nil || !kept {
+		t.Fatalf("Expected the event kept, got %v, %v", kept, err)
+	}
+	if got := event["tags"].(map[string]string)["card"]; got != "**** 1111" {
+		t.Errorf("Expected the tag masked, got %q", got)
+	}
+
+	if kept, _ := ApplyRule(config.ScrubRule{Pattern: "1111", Drop: true, Fields: []string{"tags.*"}}, event); kept {
+		t.Error("Expected a drop rule to drop a matching event")
+	}
+	if _, err := ApplyRule(config.ScrubRule{Name: "lookahead", Pattern: `foo(?=bar)`}, event); err == nil {
+		t.Error("Expected unsupported syntax to fail to compile")
+	}
+}
+
+func TestConfigureRejectsHugePatterns(t *testing.T) {
+	err := Configure(config.ScrubbingConfig{
+		Enabled: true,
+		Rules:   []config.ScrubRule{{Name: "huge", Pattern: strings.Repeat(`\w{1000}`, 11)}},
+	})
+	defer Configure(config.ScrubbingConfig{})
+	if err == nil || !strings.Contains(err.Error(), "too complex") {
+		t.Errorf("Expected a too complex error, got %v", err)
+	}
+}
+
+func TestSlowRuleTripsClosed(t *testing.T) {
+	if err := Configure(config.ScrubbingConfig{
+		Enabled:            true,
+		RuleBudgetDuration: time.Nanosecond,
+		Rules:              []config.ScrubRule{{Name: "slow", Pattern: `secret-\d+`, Replacement: "[REDACTED]", Fields: []string{"message", "tags.user"}}},
+	}); err != nil {
+		t.Fatalf("configure: %v", err)
+	}
+	defer Configure(config.ScrubbingConfig{})
+
+	// Overruns short of the limit still scrub the event in full
+	long := strings.Repeat("x", 1<<16) + " secret-1"
+	for i := 1; i < tripAfterOverruns; i++ {
+		evt := buffer.Event{"message": long}
+		Apply(evt)
+		if !strings.HasSuffix(evt["message"].(string), "[REDACTED]") {
+			t.Fatalf("Overrun %d: expected the rule to finish the event", i)
+		}
+	}
+	if tripped := diag.Global().Snapshot().TrippedScrubRules; len(tripped) != 0 {
+		t.Fatalf("Expected no tripped rule before %d overruns, got %v", tripAfterOverruns, tripped)
+	}
+
+	Apply(buffer.Event{"message": long})
+	if tripped := diag.Global().Snapshot().TrippedScrubRules; len(tripped) == 0 || tripped[len(tripped)-1] != "slow" {
+		t.Fatalf("Expected the rule reported as tripped, got %v", tripped)
+	}
+
+	// Once tripped, covered fields are replaced without matching
+	evt := buffer.Event{"event_type": "log", "message": "secret-2 and more", "tags": map[string]string{"user": "secret-3", "host": "web-1"}}
+	if !Apply(evt) {
+		t.Fatal("Expected the event kept")
+	}
+	tags := evt["tags"].(map[string]string)
+	if evt["message"] != trippedReplacement || tags["user"] != trippedReplacement {
+		t.Errorf("Expected covered fields redacted, got %v", evt)
+	}
+	if evt["event_type"] != "log" || tags["host"] != "web-1" {
+		t.Errorf("Expected other fields untouched, got %v", evt)
+	}
+}
+
+func TestSingleOverrunDoesNotTrip(t *testing.T) {
+	s, err := New(config.ScrubbingConfig{
+		Enabled:            true,
+		RuleBudgetDuration: time.Hour,
+		Rules:              []config.ScrubRule{{Name: "fast", Pattern: `secret-\d+`, Replacement: "[REDACTED]"}},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	rule := s.rules[0]
+	rule.overruns.Store(tripAfterOverruns - 1)
+
+	// An event within budget resets the count
+	evt := buffer.Event{"message": "secret-1"}
+	s.Apply(evt)
+	if rule.overruns.Load() != 0 || rule.tripped.Load() {
+		t.Errorf("Expected the overrun count reset, got %d (tripped %v)", rule.overruns.Load(), rule.tripped.Load())
+	}
+	if evt["message"] != "[REDACTED]" {
+		t.Errorf("Expected the event scrubbed, got %v", evt["message"])
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/yaat-app/sidecar/internal/daemon"
 	"github.com/yaat-app/sidecar/internal/detection"
 	"github.com/yaat-app/sidecar/internal/forwarder"
+	"github.com/yaat-app/sidecar/internal/paths"
 	"github.com/yaat-app/sidecar/internal/state"
 )
 
@@ -134,13 +135,12 @@ func Run(configPath string) error {
 	}
 
 	if promptYesNo(reader, "Start YAAT Sidecar in the background?", true) {
-		defaultPidPath := "/var/run/yaat-sidecar.pid"
-		defaultLogPath := "/var/log/yaat-sidecar.log"
-		if err := daemon.Start(cfg.SourcePath, "", defaultPidPath, false); err != nil {
+		layout := paths.Detect().Resolve(paths.DefaultInstance)
+		if err := daemon.Start(cfg.SourcePath, layout, false); err != nil {
 			fmt.Printf("✗ Failed to start daemon: %v\n", err)
 		} else {
 			fmt.Println("✓ Sidecar started successfully")
-			fmt.Printf("  Logs: %s\n", daemon.GetLogPath(defaultLogPath))
+			fmt.Printf("  Logs: %s\n", layout.LogFile)
 		}
 	}
 
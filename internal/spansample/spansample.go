@@ -0,0 +1,155 @@
+// Package spansample thins out successful HTTP request spans, from access
+// logs and the proxy, before they reach the buffer. Error spans can bypass
+// sampling, and spans dropped here can be counted per method and status
+// class so request totals stay accurate.
+package spansample
+
+import (
+	"hash/fnv"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+	"github.com/yaat-app/sidecar/internal/diag"
+)
+
+// MetricName is the count of spans sampled out over each interval.
+const MetricName = "http.requests.sampled_out"
+
+type countKey struct {
+	method      string
+	statusClass string
+}
+
+// Sampler keeps a fraction of 2xx/3xx spans, and of 4xx/5xx spans unless
+// errors are always kept. Spans with any other status are always kept. It
+// is safe for concurrent use.
+type Sampler struct {
+	source       string // diag source the dropped spans are counted under
+	rate         float64
+	errorsAlways bool
+
+	mu     sync.Mutex
+	counts map[countKey]int64 // nil unless CountSampledOut was called
+}
+
+// New returns a sampler keeping rate (0-1) of the spans it sees from source.
+func New(source string, rate float64, errorsAlways bool) *Sampler {
+	return &Sampler{source: source, rate: rate, errorsAlways: errorsAlways}
+}
+
+// CountSampledOut makes the sampler tally dropped spans for Flush.
+func (s *Sampler) CountSampledOut() {
+	s.mu.Lock()
+	if s.counts == nil {
+		s.counts = make(map[countKey]int64)
+	}
+	s.mu.Unlock()
+}
+
+// Keep decides for a parsed event; events other than spans are kept.
+func (s *Sampler) Keep(event buffer.Event) bool {
+	if eventType, _ := event["event_type"].(string); eventType != "span" {
+		return true
+	}
+	traceID, _ := event["trace_id"].(string)
+	status, _ := event["status_code"].(int)
+	var method string
+	if tags, ok := event["tags"].(map[string]string); ok {
+		method = tags["method"]
+	}
+	return s.KeepSpan(traceID, method, status)
+}
+
+// KeepSpan decides by hashing the trace ID, so with log correlation an
+// access-log span and the proxy span of the same request, which share a
+// trace, are kept or dropped together.
+func (s *Sampler) KeepSpan(traceID, method string, status int) bool {
+	switch {
+	case status >= 200 && status < 400:
+	case status >= 400 && status < 600:
+		if s.errorsAlways {
+			return true
+		}
+	default:
+		return true
+	}
+	if s.rate >= 1 {
+		return true
+	}
+	if s.rate > 0 {
+		h := fnv.New64a()
+		h.Write([]byte(traceID))
+		if float64(mix64(h.Sum64()))/float64(math.MaxUint64) < s.rate {
+			return true
+		}
+	}
+
+	diag.Global().RecordSpansSampledOut(s.source, 1)
+	s.mu.Lock()
+	if s.counts != nil {
+		s.counts[countKey{method: method, statusClass: statusClass(status)}]++
+	}
+	s.mu.Unlock()
+	return false
+}
+
+// Flush returns one http.requests.sampled_out metric per method and status
+// class dropped since the last call, built on base, and resets the counts.
+func (s *Sampler) Flush(now time.Time, base func() buffer.Event) []buffer.Event {
+	s.mu.Lock()
+	counts := s.counts
+	if counts != nil {
+		s.counts = make(map[countKey]int64)
+	}
+	s.mu.Unlock()
+
+	keys := make([]countKey, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].statusClass < keys[j].statusClass
+	})
+
+	timestamp := now.UTC().Format(time.RFC3339Nano)
+	events := make([]buffer.Event, 0, len(keys))
+	for _, key := range keys {
+		event := base()
+		event["timestamp"] = timestamp
+		event["event_type"] = "metric"
+		event["metric_name"] = MetricName
+		event["metric_value"] = float64(counts[key])
+
+		tags, _ := event["tags"].(map[string]string)
+		if tags == nil {
+			tags = make(map[string]string, 2)
+			event["tags"] = tags
+		}
+		tags["method"] = key.method
+		tags["status_class"] = key.statusClass
+		events = append(events, event)
+	}
+	return events
+}
+
+func statusClass(status int) string {
+	return strconv.Itoa(status/100) + "xx"
+}
+
+// mix64 spreads FNV's weak high bits (the splitmix64 finalizer), so trace
+// IDs that differ only near the end still land far apart.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
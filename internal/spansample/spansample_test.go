@@ -0,0 +1,105 @@
+package spansample
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+	"github.com/yaat-app/sidecar/internal/diag"
+)
+
+func span(traceID string, status int) buffer.Event {
+	return buffer.Event{
+		"event_type":  "span",
+		"trace_id":    traceID,
+		"status_code": status,
+		"tags":        map[string]string{"method": "GET"},
+	}
+}
+
+func keptOf(s *Sampler, status, n int) int {
+	kept := 0
+	for i := 0; i < n; i++ {
+		if s.Keep(span(fmt.Sprintf("trace-%d", i), status)) {
+			kept++
+		}
+	}
+	return kept
+}
+
+func TestBoundaryRates(t *testing.T) {
+	if kept := keptOf(New("test", 1, true), 200, 1000); kept != 1000 {
+		t.Errorf("Expected rate 1.0 to keep every span, kept %d", kept)
+	}
+	if kept := keptOf(New("test", 0, true), 200, 1000); kept != 0 {
+		t.Errorf("Expected rate 0.0 to drop every 2xx span, kept %d", kept)
+	}
+	if kept := keptOf(New("test", 0, true), 302, 1000); kept != 0 {
+		t.Errorf("Expected rate 0.0 to drop every 3xx span, kept %d", kept)
+	}
+	if kept := keptOf(New("test", 0.25, true), 200, 10000); kept < 2200 || kept > 2800 {
+		t.Errorf("Expected about 2500 of 10000 spans at rate 0.25, kept %d", kept)
+	}
+}
+
+func TestErrorsBypassSampling(t *testing.T) {
+	s := New("test", 0, true)
+	for _, status := range []int{400, 404, 500, 503} {
+		if !s.KeepSpan("trace", "GET", status) {
+			t.Errorf("Expected %d to be kept with errors_always", status)
+		}
+	}
+	if kept := keptOf(New("test", 0, false), 500, 100); kept != 0 {
+		t.Errorf("Expected 5xx spans to be sampled without errors_always, kept %d", kept)
+	}
+	// Statuses outside 2xx-5xx, such as an unparsed 0 or a 101 upgrade, are never dropped
+	if !s.KeepSpan("trace", "GET", 0) || !s.KeepSpan("trace", "GET", 101) {
+		t.Error("Expected spans without a 2xx-5xx status to be kept")
+	}
+	if !s.Keep(buffer.Event{"event_type": "log", "status_code": 200}) {
+		t.Error("Expected non-span events to be kept")
+	}
+}
+
+func TestDeterministicPerTrace(t *testing.T) {
+	a, b := New("access.log", 0.5, true), New("proxy", 0.5, true)
+	for i := 0; i < 200; i++ {
+		traceID := fmt.Sprintf("4bf92f3577b34da6a3ce929d0e0e%04d", i)
+		if a.KeepSpan(traceID, "GET", 200) != b.KeepSpan(traceID, "POST", 201) {
+			t.Fatalf("Expected both sources to decide alike for trace %s", traceID)
+		}
+	}
+}
+
+func TestFlushCountsSampledOut(t *testing.T) {
+	before := diag.Global().Snapshot().Sources["flush-test"].SpansSampledOut
+	s := New("flush-test", 0, false)
+	s.CountSampledOut()
+	for i := 0; i < 3; i++ {
+		s.KeepSpan(fmt.Sprintf("t%d", i), "GET", 200)
+	}
+	s.KeepSpan("t", "POST", 503)
+
+	base := func() buffer.Event {
+		return buffer.Event{"service_name": "svc", "tags": map[string]string{"team": "core"}}
+	}
+	events := s.Flush(time.Now(), base)
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 metrics, got %d", len(events))
+	}
+	tags := events[0]["tags"].(map[string]string)
+	if events[0]["metric_name"] != MetricName || events[0]["metric_value"] != 3.0 || tags["method"] != "GET" || tags["status_class"] != "2xx" || tags["team"] != "core" {
+		t.Errorf("Expected 3 GET 2xx sampled out, got %v", events[0])
+	}
+	if tags := events[1]["tags"].(map[string]string); events[1]["metric_value"] != 1.0 || tags["status_class"] != "5xx" {
+		t.Errorf("Expected 1 POST 5xx sampled out, got %v", events[1])
+	}
+	if got := diag.Global().Snapshot().Sources["flush-test"].SpansSampledOut - before; got != 4 {
+		t.Errorf("Expected 4 spans sampled out in diag, got %d", got)
+	}
+
+	if events := s.Flush(time.Now(), base); len(events) != 0 {
+		t.Errorf("Expected counts to reset after a flush, got %d metrics", len(events))
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package state
+
+// lockFile is a no-op where flock is unavailable; the atomic rename in Save
+// still keeps readers from seeing partial files.
+func lockFile(path string) (func(), error) {
+	return func() {}, nil
+}
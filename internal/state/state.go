@@ -7,22 +7,87 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/yaat-app/sidecar/internal/buffer"
+	"github.com/yaat-app/sidecar/internal/logging"
+	"github.com/yaat-app/sidecar/internal/paths"
 )
 
 const (
-	stateDirName        = ".yaat"
 	stateFileName       = "state.json"
 	maxStoredTestEvents = 20
+
+	// schemaVersion is bumped whenever Load needs to migrate older files.
+	// Version 2 added the test history and delivery incidents.
+	schemaVersion = 2
+
+	maxTestHistory = 50
+	maxIncidents   = 50
+
+	// Backfill records older than this are pruned; rotated files are long gone by then.
+	maxBackfillRecordAge = 30 * 24 * time.Hour
 )
 
+// updateMu serialises read-modify-write cycles within this process; the file
+// lock taken by lockFile does the same across processes (daemon and TUI).
+var updateMu sync.Mutex
+
+var logger = logging.New("State")
+
 // State represents persisted UI state for the sidecar.
 type State struct {
+	Version     int        `json:"version"`
 	ConfigPath  string     `json:"config_path"`
 	LastSetupAt time.Time  `json:"last_setup_at"`
 	LastTest    TestResult `json:"last_test"`
+
+	// KeyRotatedAt is when --rotate-key last replaced api_key.
+	KeyRotatedAt time.Time `json:"key_rotated_at,omitempty"`
+
+	// TestHistory holds the most recent test results, oldest first, without their events.
+	TestHistory []TestResult `json:"test_history,omitempty"`
+
+	// Incidents lists recent delivery outages, oldest first.
+	Incidents []Incident `json:"incidents,omitempty"`
+
+	// Backfill records rotated log files that were fully ingested, keyed by content fingerprint.
+	Backfill map[string]BackfillRecord `json:"backfill,omitempty"`
+
+	// LogOffsets records how far --once read each log file, keyed by path.
+	LogOffsets map[string]LogOffset `json:"log_offsets,omitempty"`
+}
+
+// LogOffset is where the next --once run resumes reading a log file. Inode
+// tells the file that was read from one that replaced it after a rotation.
+type LogOffset struct {
+	Inode  uint64    `json:"inode"`
+	Offset int64     `json:"offset"`
+	ReadAt time.Time `json:"read_at"`
+}
+
+// BackfillRecord describes a rotated log file that was ingested at startup.
+type BackfillRecord struct {
+	Source      string    `json:"source"`
+	File        string    `json:"file"`
+	Lines       int       `json:"lines"`
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+// Incident records a period during which the daemon failed to deliver events.
+type Incident struct {
+	StartedAt    time.Time  `json:"started_at"`
+	ResolvedAt   *time.Time `json:"resolved_at,omitempty"`
+	Error        string     `json:"error,omitempty"`
+	OutageMillis int64      `json:"outage_ms,omitempty"`
+	EventsQueued int        `json:"events_queued"` // Events that failed delivery during the outage
+}
+
+// Ongoing reports whether delivery has not recovered yet.
+func (i Incident) Ongoing() bool {
+	return i.ResolvedAt == nil
 }
 
 // TestResult captures the outcome of the last connectivity test.
@@ -35,6 +100,9 @@ type TestResult struct {
 	LatencyMillis int64       `json:"latency_ms"`
 	Error         string      `json:"error,omitempty"`
 	Events        []TestEvent `json:"events,omitempty"`
+
+	// ClockSkewMillis is the API server clock minus the local clock, when measured.
+	ClockSkewMillis *int64 `json:"clock_skew_ms,omitempty"`
 }
 
 // TestEvent represents a simplified view of an event that was sent during a test.
@@ -56,12 +124,30 @@ type TestEvent struct {
 }
 
 // Load reads the persisted state from disk. If no state is present a new instance is returned.
+// A file that cannot be parsed is moved aside to state.json.corrupt and a new instance is returned.
 func Load() (*State, error) {
 	path, err := stateFilePath()
 	if err != nil {
 		return &State{}, err
 	}
 
+	st, err := load(path)
+	if !errors.Is(err, errCorrupt) {
+		return st, err
+	}
+
+	// Re-check under the lock so a file replaced meanwhile is not moved aside
+	unlock, lockErr := lockFile(path)
+	if lockErr != nil {
+		return &State{}, lockErr
+	}
+	defer unlock()
+	return loadOrQuarantine(path)
+}
+
+var errCorrupt = errors.New("corrupt state file")
+
+func load(path string) (*State, error) {
 	data, err := os.ReadFile(path)
 	if errors.Is(err, os.ErrNotExist) {
 		return &State{}, nil
@@ -72,12 +158,39 @@ func Load() (*State, error) {
 
 	var st State
 	if err := json.Unmarshal(data, &st); err != nil {
-		return &State{}, fmt.Errorf("parse state: %w", err)
+		return &State{}, fmt.Errorf("%w: %v", errCorrupt, err)
 	}
+	migrate(&st)
 
 	return &st, nil
 }
 
+// loadOrQuarantine loads path, renaming it to path+".corrupt" if it does not
+// parse. The caller must hold the file lock.
+func loadOrQuarantine(path string) (*State, error) {
+	st, err := load(path)
+	if !errors.Is(err, errCorrupt) {
+		return st, err
+	}
+	if renameErr := os.Rename(path, path+".corrupt"); renameErr != nil {
+		return &State{}, fmt.Errorf("move aside corrupt state: %w", renameErr)
+	}
+	logger.Warnf("%s was corrupt (%v); moved it to %s.corrupt and started fresh", path, err, path)
+	return &State{}, nil
+}
+
+// migrate upgrades state written by older versions in place.
+func migrate(st *State) {
+	if st.Version >= schemaVersion {
+		return
+	}
+	// Version 1 kept only the last test; seed the history with it
+	if st.Version < 2 && len(st.TestHistory) == 0 && !st.LastTest.RanAt.IsZero() {
+		st.TestHistory = []TestResult{historyEntry(st.LastTest)}
+	}
+	st.Version = schemaVersion
+}
+
 // Save writes the state file to disk.
 func Save(st *State) error {
 	if st == nil {
@@ -92,13 +205,44 @@ func Save(st *State) error {
 	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
 		return fmt.Errorf("create state directory: %w", err)
 	}
+	unlock, err := lockFile(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
+	return save(path, st)
+}
+
+// save writes st to a temporary file and renames it over path, so readers
+// never see a partial file. The caller must hold the file lock.
+func save(path string, st *State) error {
+	if st.Version < schemaVersion {
+		st.Version = schemaVersion
+	}
 	data, err := json.MarshalIndent(st, "", "  ")
 	if err != nil {
 		return fmt.Errorf("encode state: %w", err)
 	}
 
-	if err := os.WriteFile(path, data, 0o600); err != nil {
+	tmp, err := os.CreateTemp(filepath.Dir(path), stateFileName+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("write state: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write state: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write state: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
 		return fmt.Errorf("write state: %w", err)
 	}
 
@@ -107,13 +251,29 @@ func Save(st *State) error {
 
 // Update loads the current state, applies the mutator, and persists it.
 func Update(mutator func(*State)) error {
-	st, err := Load()
+	updateMu.Lock()
+	defer updateMu.Unlock()
+
+	path, err := stateFilePath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create state directory: %w", err)
+	}
+	unlock, err := lockFile(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	st, err := loadOrQuarantine(path)
 	if err != nil {
 		return err
 	}
 
 	mutator(st)
-	return Save(st)
+	return save(path, st)
 }
 
 // RecordConfig persists the latest configuration path and timestamp.
@@ -127,6 +287,70 @@ func RecordConfig(configPath string) error {
 	})
 }
 
+// RecordKeyRotation records that api_key was just replaced.
+func RecordKeyRotation() error {
+	return Update(func(st *State) {
+		st.KeyRotatedAt = time.Now().UTC()
+	})
+}
+
+// BackfillCompleted reports whether a rotated file with this fingerprint was already ingested.
+func BackfillCompleted(fingerprint string) bool {
+	st, err := Load()
+	if err != nil {
+		return false
+	}
+	_, ok := st.Backfill[fingerprint]
+	return ok
+}
+
+// RecordBackfill marks a rotated file as ingested and prunes stale records.
+func RecordBackfill(fingerprint string, record BackfillRecord) error {
+	return Update(func(st *State) {
+		if st.Backfill == nil {
+			st.Backfill = make(map[string]BackfillRecord)
+		}
+		if record.CompletedAt.IsZero() {
+			record.CompletedAt = time.Now().UTC()
+		}
+		cutoff := time.Now().Add(-maxBackfillRecordAge)
+		for key, existing := range st.Backfill {
+			if existing.CompletedAt.Before(cutoff) {
+				delete(st.Backfill, key)
+			}
+		}
+		st.Backfill[fingerprint] = record
+	})
+}
+
+// LogOffsetFor returns where --once stopped reading path, if it ever did.
+func LogOffsetFor(path string) (LogOffset, bool) {
+	st, err := Load()
+	if err != nil {
+		return LogOffset{}, false
+	}
+	offset, ok := st.LogOffsets[path]
+	return offset, ok
+}
+
+// RecordLogOffsets saves where --once stopped reading each log file.
+func RecordLogOffsets(offsets map[string]LogOffset) error {
+	if len(offsets) == 0 {
+		return nil
+	}
+	return Update(func(st *State) {
+		if st.LogOffsets == nil {
+			st.LogOffsets = make(map[string]LogOffset, len(offsets))
+		}
+		for path, offset := range offsets {
+			if offset.ReadAt.IsZero() {
+				offset.ReadAt = time.Now().UTC()
+			}
+			st.LogOffsets[path] = offset
+		}
+	})
+}
+
 // RecordTestOutcome builds and saves a test result from the provided data.
 func RecordTestOutcome(endpoint, serviceName, environment string, events []buffer.Event, latency time.Duration, testErr error) error {
 	result := NewTestResult(endpoint, serviceName, environment, events, latency, testErr)
@@ -144,6 +368,43 @@ func RecordTest(result TestResult) error {
 			result.Events = result.Events[:maxStoredTestEvents]
 		}
 		st.LastTest = result
+		st.TestHistory = append(st.TestHistory, historyEntry(result))
+		if excess := len(st.TestHistory) - maxTestHistory; excess > 0 {
+			st.TestHistory = st.TestHistory[excess:]
+		}
+	})
+}
+
+// historyEntry drops the sample events, which only matter for the last test.
+func historyEntry(result TestResult) TestResult {
+	result.Events = nil
+	return result
+}
+
+// RecordIncidentStart opens an incident when delivery starts failing.
+func RecordIncidentStart(startedAt time.Time, errMsg string) error {
+	return Update(func(st *State) {
+		st.Incidents = append(st.Incidents, Incident{StartedAt: startedAt.UTC(), Error: errMsg})
+		if excess := len(st.Incidents) - maxIncidents; excess > 0 {
+			st.Incidents = st.Incidents[excess:]
+		}
+	})
+}
+
+// RecordIncidentEnd resolves the open incident once delivery recovers.
+func RecordIncidentEnd(resolvedAt time.Time, eventsQueued int) error {
+	return Update(func(st *State) {
+		for i := len(st.Incidents) - 1; i >= 0; i-- {
+			incident := &st.Incidents[i]
+			if !incident.Ongoing() {
+				continue
+			}
+			resolved := resolvedAt.UTC()
+			incident.ResolvedAt = &resolved
+			incident.OutageMillis = resolved.Sub(incident.StartedAt).Milliseconds()
+			incident.EventsQueued = eventsQueued
+			return
+		}
 	})
 }
 
@@ -166,6 +427,12 @@ func NewTestResult(endpoint, serviceName, environment string, events []buffer.Ev
 	return result
 }
 
+// SetClockSkew records the clock offset measured during the test.
+func (r *TestResult) SetClockSkew(offset time.Duration) {
+	millis := offset.Milliseconds()
+	r.ClockSkewMillis = &millis
+}
+
 // FromBufferEvents converts buffer events into TestEvent types for persistence.
 func FromBufferEvents(events []buffer.Event, limit int) []TestEvent {
 	if limit <= 0 || len(events) == 0 {
@@ -288,10 +555,22 @@ func fromBufferEvent(evt buffer.Event) TestEvent {
 	return testEvent
 }
 
+// pathOverride holds the state file set by SetPath.
+var pathOverride atomic.Value
+
+// SetPath makes path the state file instead of the default instance's
+// ~/.yaat/state.json.
+func SetPath(path string) {
+	pathOverride.Store(path)
+}
+
 func stateFilePath() (string, error) {
-	home, err := os.UserHomeDir()
-	if err != nil || home == "" {
-		return "", fmt.Errorf("resolve home directory: %w", err)
+	if path, _ := pathOverride.Load().(string); path != "" {
+		return path, nil
+	}
+	resolver := paths.Detect()
+	if resolver.Home == "" {
+		return "", fmt.Errorf("resolve home directory: unknown")
 	}
-	return filepath.Join(home, stateDirName, stateFileName), nil
+	return resolver.HomeLayout(paths.DefaultInstance).StateFile, nil
 }
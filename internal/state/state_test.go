@@ -0,0 +1,195 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func useTempHome(t *testing.T) string {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	return filepath.Join(home, ".yaat", stateFileName)
+}
+
+func TestLoadMigratesSingleTestResult(t *testing.T) {
+	path := useTempHome(t)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	v1 := `{"config_path": "/etc/yaat.yaml", "last_test": {"ran_at": "2025-10-01T12:00:00Z", "success": true, "endpoint": "https://api", "latency_ms": 42, "events": [{"event_id": "e1"}]}}`
+	if err := os.WriteFile(path, []byte(v1), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if st.Version != schemaVersion {
+		t.Errorf("Expected version %d, got %d", schemaVersion, st.Version)
+	}
+	if len(st.TestHistory) != 1 || st.TestHistory[0].LatencyMillis != 42 {
+		t.Fatalf("Expected history seeded with the last test, got %+v", st.TestHistory)
+	}
+	if st.TestHistory[0].Events != nil {
+		t.Error("Expected history entries without events")
+	}
+	if len(st.LastTest.Events) != 1 {
+		t.Errorf("Expected last test events to be kept, got %d", len(st.LastTest.Events))
+	}
+}
+
+func TestRecordTestKeepsBoundedHistory(t *testing.T) {
+	useTempHome(t)
+	start := time.Date(2025, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < maxTestHistory+5; i++ {
+		result := TestResult{RanAt: start.Add(time.Duration(i) * time.Minute), Success: i%2 == 0, Events: []TestEvent{{EventID: "e"}}}
+		if err := RecordTest(result); err != nil {
+			t.Fatalf("RecordTest failed: %v", err)
+		}
+	}
+
+	st, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(st.TestHistory) != maxTestHistory {
+		t.Fatalf("Expected %d history entries, got %d", maxTestHistory, len(st.TestHistory))
+	}
+	if want := start.Add(5 * time.Minute); !st.TestHistory[0].RanAt.Equal(want) {
+		t.Errorf("Expected oldest entry at %v, got %v", want, st.TestHistory[0].RanAt)
+	}
+	if want := start.Add(time.Duration(maxTestHistory+4) * time.Minute); !st.LastTest.RanAt.Equal(want) {
+		t.Errorf("Expected last test at %v, got %v", want, st.LastTest.RanAt)
+	}
+}
+
+func TestRecordIncident(t *testing.T) {
+	useTempHome(t)
+	start := time.Date(2025, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	if err := RecordIncidentStart(start, "connection refused"); err != nil {
+		t.Fatalf("RecordIncidentStart failed: %v", err)
+	}
+	st, _ := Load()
+	if len(st.Incidents) != 1 || !st.Incidents[0].Ongoing() {
+		t.Fatalf("Expected one ongoing incident, got %+v", st.Incidents)
+	}
+
+	if err := RecordIncidentEnd(start.Add(90*time.Second), 250); err != nil {
+		t.Fatalf("RecordIncidentEnd failed: %v", err)
+	}
+	st, _ = Load()
+	incident := st.Incidents[0]
+	if incident.Ongoing() {
+		t.Fatal("Expected the incident to be resolved")
+	}
+	if incident.OutageMillis != 90000 || incident.EventsQueued != 250 || incident.Error != "connection refused" {
+		t.Errorf("Expected 90s outage with 250 events and the first error, got %+v", incident)
+	}
+}
+
+func TestLoadMovesCorruptFileAside(t *testing.T) {
+	path := useTempHome(t)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(`{"config_path": "/etc/ya`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := Load()
+	if err != nil {
+		t.Fatalf("Expected a corrupt file to be tolerated, got %v", err)
+	}
+	if st.ConfigPath != "" {
+		t.Errorf("Expected fresh state, got %+v", st)
+	}
+	if _, err := os.Stat(path + ".corrupt"); err != nil {
+		t.Errorf("Expected the corrupt file at %s.corrupt: %v", path, err)
+	}
+
+	if err := RecordConfig("/etc/yaat.yaml"); err != nil {
+		t.Fatalf("RecordConfig failed after recovery: %v", err)
+	}
+	if st, _ := Load(); st.ConfigPath != "/etc/yaat.yaml" {
+		t.Errorf("Expected config path to be saved, got %q", st.ConfigPath)
+	}
+}
+
+const (
+	workerEnv     = "YAAT_STATE_TEST_WORKER"
+	workerUpdates = 25
+)
+
+// TestUpdateWorker is run as a child process by TestUpdateAcrossProcesses.
+func TestUpdateWorker(t *testing.T) {
+	id := os.Getenv(workerEnv)
+	if id == "" {
+		t.Skip("helper process")
+	}
+	for i := 0; i < workerUpdates; i++ {
+		if err := RecordBackfill(fmt.Sprintf("%s-%d", id, i), BackfillRecord{File: id}); err != nil {
+			t.Fatalf("RecordBackfill failed: %v", err)
+		}
+	}
+}
+
+func TestUpdateAcrossProcesses(t *testing.T) {
+	path := useTempHome(t)
+	const workers = 4
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*2)
+	for w := 0; w < workers; w++ {
+		wg.Add(2)
+		// Another process, as when the daemon and the TUI write together
+		go func(id string) {
+			defer wg.Done()
+			cmd := exec.Command(os.Args[0], "-test.run=^TestUpdateWorker$")
+			cmd.Env = append(os.Environ(), workerEnv+"="+id)
+			if out, err := cmd.CombinedOutput(); err != nil {
+				errs <- fmt.Errorf("worker %s: %v\n%s", id, err, out)
+			}
+		}("proc" + strconv.Itoa(w))
+		// And goroutines in this process
+		go func(id string) {
+			defer wg.Done()
+			for i := 0; i < workerUpdates; i++ {
+				if err := RecordBackfill(fmt.Sprintf("%s-%d", id, i), BackfillRecord{File: id}); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}("goroutine" + strconv.Itoa(w))
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var st State
+	if err := json.Unmarshal(data, &st); err != nil {
+		t.Fatalf("Expected valid JSON after concurrent updates: %v", err)
+	}
+	if want := workers * 2 * workerUpdates; len(st.Backfill) != want {
+		t.Errorf("Expected %d backfill records with no lost updates, got %d", want, len(st.Backfill))
+	}
+	if _, err := os.Stat(path + ".corrupt"); err == nil {
+		t.Error("Expected no corrupt file")
+	}
+}
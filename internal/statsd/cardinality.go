@@ -0,0 +1,94 @@
+package statsd
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/diag"
+)
+
+const (
+	// highCardinalityValue replaces the values of a suppressed tag key.
+	highCardinalityValue = "__high_cardinality__"
+
+	// cardinalityWindow is how long distinct values are counted before the
+	// counts start over and suppressed keys get another chance.
+	cardinalityWindow = 10 * time.Minute
+
+	// maxTrackedTagKeys bounds the keys tracked per window. Keys first seen
+	// once it is reached pass through uncounted until the window ends.
+	maxTrackedTagKeys = 512
+)
+
+// cardinalityGuard counts distinct values per tag key and masks keys that
+// exceed the limit. Values are kept as 64-bit hashes and a key's set is
+// freed once it is suppressed, so memory stays below maxTrackedTagKeys x
+// limit hashes.
+type cardinalityGuard struct {
+	mu          sync.Mutex
+	limit       int
+	windowStart time.Time
+	seen        map[string]map[uint64]struct{}
+	suppressed  map[string]bool
+	warned      map[string]bool // Suppressions already logged, across windows
+	untracked   bool            // maxTrackedTagKeys was reached this window
+}
+
+func newCardinalityGuard(limit int) *cardinalityGuard {
+	return &cardinalityGuard{
+		limit:      limit,
+		seen:       make(map[string]map[uint64]struct{}),
+		suppressed: make(map[string]bool),
+		warned:     make(map[string]bool),
+	}
+}
+
+// value returns v, or highCardinalityValue once key has taken more than the
+// limit of distinct values in the current window.
+func (g *cardinalityGuard) value(key, v string, now time.Time) string {
+	if g == nil || g.limit <= 0 {
+		return v
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if now.Sub(g.windowStart) >= cardinalityWindow {
+		g.windowStart = now
+		g.seen = make(map[string]map[uint64]struct{})
+		g.suppressed = make(map[string]bool)
+		g.untracked = false
+	}
+	if g.suppressed[key] {
+		return highCardinalityValue
+	}
+
+	values, ok := g.seen[key]
+	if !ok {
+		if len(g.seen) >= maxTrackedTagKeys {
+			if !g.untracked {
+				g.untracked = true
+				logger.Warnf("Tracking cardinality of %d tag keys; further keys pass through unchecked until the window ends", maxTrackedTagKeys)
+			}
+			return v
+		}
+		values = make(map[uint64]struct{})
+		g.seen[key] = values
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(v))
+	values[h.Sum64()] = struct{}{}
+	if len(values) <= g.limit {
+		return v
+	}
+
+	g.suppressed[key] = true
+	delete(g.seen, key)
+	if !g.warned[key] {
+		g.warned[key] = true
+		logger.Warnf("Tag %q exceeded %d distinct values; replacing its values with %s (metrics.max_tag_cardinality)", key, g.limit, highCardinalityValue)
+		diag.Global().RecordSuppressedTag()
+	}
+	return highCardinalityValue
+}
@@ -0,0 +1,69 @@
+package statsd
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultGaugeTTL is used when metrics.statsd.gauge_ttl is not set.
+const defaultGaugeTTL = time.Hour
+
+// gaugeRegistry keeps the current value of each gauge so "+N" and "-N"
+// updates can be applied to it, as StatsD servers do.
+type gaugeRegistry struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	values    map[string]gaugeValue
+	lastSweep time.Time
+}
+
+type gaugeValue struct {
+	value   float64
+	updated time.Time
+}
+
+func newGaugeRegistry(ttl time.Duration) *gaugeRegistry {
+	if ttl <= 0 {
+		ttl = defaultGaugeTTL
+	}
+	return &gaugeRegistry{ttl: ttl, values: make(map[string]gaugeValue)}
+}
+
+// gaugeKey identifies a gauge by name and packet tags, in any order.
+func gaugeKey(name string, tags []string) string {
+	if len(tags) == 0 {
+		return name
+	}
+	sorted := append([]string(nil), tags...)
+	sort.Strings(sorted)
+	return name + "|" + strings.Join(sorted, ",")
+}
+
+// apply sets the gauge, or adjusts it when delta is true, and returns its new
+// value. A gauge not updated within the TTL starts again from zero.
+func (r *gaugeRegistry) apply(key string, value float64, delta bool, now time.Time) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if now.Sub(r.lastSweep) >= r.ttl {
+		r.evictLocked(now)
+	}
+
+	current, ok := r.values[key]
+	if delta && ok && now.Sub(current.updated) < r.ttl {
+		value += current.value
+	}
+	r.values[key] = gaugeValue{value: value, updated: now}
+	return value
+}
+
+func (r *gaugeRegistry) evictLocked(now time.Time) {
+	for key, gauge := range r.values {
+		if now.Sub(gauge.updated) >= r.ttl {
+			delete(r.values, key)
+		}
+	}
+	r.lastSweep = now
+}
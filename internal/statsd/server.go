@@ -3,7 +3,6 @@ package statsd
 import (
 	"bufio"
 	"fmt"
-	"log"
 	"net"
 	"strconv"
 	"strings"
@@ -12,9 +11,18 @@ import (
 
 	"github.com/yaat-app/sidecar/internal/buffer"
 	"github.com/yaat-app/sidecar/internal/config"
+	"github.com/yaat-app/sidecar/internal/logging"
 	"github.com/yaat-app/sidecar/internal/scrubber"
 )
 
+var logger = logging.New("StatsD")
+
+// defaultMaxTimestampAge is used when metrics.statsd.max_timestamp_age is not set.
+const defaultMaxTimestampAge = time.Hour
+
+// defaultMaxTagCardinality is used until SetMaxTagCardinality is called.
+const defaultMaxTagCardinality = 1000
+
 // Server listens for StatsD/dogstatsd metrics and forwards them as metric events.
 type Server struct {
 	addr           string
@@ -24,6 +32,14 @@ type Server struct {
 	service        string
 	env            string
 	buf            *buffer.Buffer
+	gauges         *gaugeRegistry
+	maxTSAge       time.Duration
+	cardinality    *cardinalityGuard
+
+	// Metrics waiting for the next flush_interval tick; unused when zero
+	flushInterval time.Duration
+	pendingMu     sync.Mutex
+	pending       []buffer.Event
 
 	mu         sync.RWMutex
 	conns      []net.PacketConn
@@ -43,6 +59,10 @@ func New(cfg config.StatsDConfig, organizationID, serviceName, environment strin
 	for k, v := range cfg.Tags {
 		tagCopy[k] = v
 	}
+	maxTSAge := cfg.MaxTimestampAgeDuration
+	if maxTSAge <= 0 {
+		maxTSAge = defaultMaxTimestampAge
+	}
 	return &Server{
 		addr:           cfg.ListenAddr,
 		namespace:      cfg.Namespace,
@@ -51,10 +71,21 @@ func New(cfg config.StatsDConfig, organizationID, serviceName, environment strin
 		service:        serviceName,
 		env:            environment,
 		buf:            buf,
+		gauges:         newGaugeRegistry(cfg.GaugeTTLDuration),
+		maxTSAge:       maxTSAge,
+		cardinality:    newCardinalityGuard(defaultMaxTagCardinality),
+		flushInterval:  cfg.FlushIntervalDuration,
 		stop:           make(chan struct{}),
 	}
 }
 
+// SetMaxTagCardinality sets how many distinct values a packet tag key may
+// take per window before they are masked (metrics.max_tag_cardinality).
+// Call before Start.
+func (s *Server) SetMaxTagCardinality(limit int) {
+	s.cardinality = newCardinalityGuard(limit)
+}
+
 // Start begins listening for UDP packets. Returns a function to stop the server.
 func (s *Server) Start() (func(), error) {
 	conn, err := net.ListenPacket("udp", s.addr)
@@ -69,6 +100,10 @@ func (s *Server) Start() (func(), error) {
 
 	s.wg.Add(1)
 	go s.serve(conn)
+	if s.flushInterval > 0 {
+		s.wg.Add(1)
+		go s.runFlusher()
+	}
 
 	return func() {
 		close(s.stop)
@@ -104,7 +139,7 @@ func (s *Server) serve(conn net.PacketConn) {
 			if ne, ok := err.(net.Error); ok && ne.Timeout() {
 				continue
 			}
-			log.Printf("[StatsD] Read error: %v", err)
+			logger.Errorf("Read error: %v", err)
 			continue
 		}
 
@@ -123,18 +158,61 @@ func (s *Server) handleMessage(payload string) {
 		}
 		event, err := s.parseLine(line, now)
 		if err != nil {
-			log.Printf("[StatsD] Parse error: %v", err)
+			logger.Errorf("Parse error: %v", err)
 			continue
 		}
 		if scrubber.Apply(event) {
-			s.buf.Add(event)
+			s.emit(event)
 		}
 	}
 	if err := scanner.Err(); err != nil {
-		log.Printf("[StatsD] Scanner error: %v", err)
+		logger.Errorf("Scanner error: %v", err)
+	}
+}
+
+// emit buffers event, or holds it for the next tick with a flush_interval.
+func (s *Server) emit(event buffer.Event) {
+	if s.flushInterval <= 0 {
+		s.buf.Add(event)
+		return
+	}
+	s.pendingMu.Lock()
+	s.pending = append(s.pending, event)
+	s.pendingMu.Unlock()
+}
+
+// runFlusher moves held metrics to the buffer every flush_interval and asks
+// for them to be delivered, rather than waiting for the global flush.
+func (s *Server) runFlusher() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if s.release() > 0 {
+				s.buf.RequestFlush()
+			}
+		case <-s.stop:
+			// The shutdown drain delivers what is left
+			s.release()
+			return
+		}
 	}
 }
 
+// release adds the held metrics to the buffer and returns how many there were.
+func (s *Server) release() int {
+	s.pendingMu.Lock()
+	events := s.pending
+	s.pending = nil
+	s.pendingMu.Unlock()
+	for _, event := range events {
+		s.buf.Add(event)
+	}
+	return len(events)
+}
+
 func (s *Server) parseLine(line string, now time.Time) (buffer.Event, error) {
 	parts := strings.Split(line, "|")
 	if len(parts) < 2 {
@@ -160,6 +238,7 @@ func (s *Server) parseLine(line string, now time.Time) (buffer.Event, error) {
 
 	sampleRate := 1.0
 	var tags []string
+	timestamp, adjusted := now, false
 	for _, part := range parts[2:] {
 		if strings.HasPrefix(part, "@") {
 			if rate, parseErr := strconv.ParseFloat(part[1:], 64); parseErr == nil && rate > 0 {
@@ -167,7 +246,10 @@ func (s *Server) parseLine(line string, now time.Time) (buffer.Event, error) {
 			}
 		}
 		if strings.HasPrefix(part, "#") {
-			tags = strings.Split(part[1:], ",")
+			tags = s.guardTags(strings.Split(part[1:], ","), now)
+		}
+		if strings.HasPrefix(part, "T") {
+			timestamp, adjusted = s.clientTimestamp(part[1:], now)
 		}
 	}
 
@@ -180,10 +262,9 @@ func (s *Server) parseLine(line string, now time.Time) (buffer.Event, error) {
 	case "ms", "h":
 		// send as-is
 	case "g":
-		// StatsD gauges accept +/- adjustments. If prefixed with +/-, treat as delta.
-		if strings.HasPrefix(valueStr, "+") || strings.HasPrefix(valueStr, "-") {
-			// Additional state (per gauge) would be required for cumulative gauges.
-		}
+		// StatsD gauges accept +/- adjustments to the last value
+		delta := strings.HasPrefix(valueStr, "+") || strings.HasPrefix(valueStr, "-")
+		finalValue = s.gauges.apply(gaugeKey(name, tags), value, delta, now)
 	case "s":
 		// sets; we treat as gauge count
 	default:
@@ -210,6 +291,9 @@ func (s *Server) parseLine(line string, now time.Time) (buffer.Event, error) {
 		}
 	}
 	eventTags["statsd_type"] = metricType
+	if adjusted {
+		eventTags["timestamp_adjusted"] = "true"
+	}
 
 	serviceName := s.service
 	if serviceName == "" {
@@ -226,9 +310,39 @@ func (s *Server) parseLine(line string, now time.Time) (buffer.Event, error) {
 		"service_name":    serviceName,
 		"environment":     environment,
 		"event_type":      "metric",
-		"timestamp":       now.Format(time.RFC3339Nano),
+		"timestamp":       timestamp.Format(time.RFC3339Nano),
 		"metric_name":     fullName,
 		"metric_value":    finalValue,
 		"tags":            eventTags,
 	}, nil
 }
+
+// guardTags masks the values of tag keys over the cardinality limit, before
+// they reach the gauge registry or the event.
+func (s *Server) guardTags(tags []string, now time.Time) []string {
+	for i, tag := range tags {
+		key, value, ok := strings.Cut(tag, ":")
+		if !ok {
+			continue
+		}
+		if guarded := s.cardinality.value(key, value, now); guarded != value {
+			tags[i] = key + ":" + guarded
+		}
+	}
+	return tags
+}
+
+// clientTimestamp parses a dogstatsd |T<unix seconds> value. Values that do
+// not parse or lie more than maxTSAge from now fall back to now and are
+// reported as adjusted.
+func (s *Server) clientTimestamp(value string, now time.Time) (time.Time, bool) {
+	secs, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return now, true
+	}
+	ts := time.Unix(secs, 0).UTC()
+	if age := now.Sub(ts); age > s.maxTSAge || -age > s.maxTSAge {
+		return now, true
+	}
+	return ts, false
+}
@@ -0,0 +1,167 @@
+package statsd
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+	"github.com/yaat-app/sidecar/internal/config"
+)
+
+func gaugeValues(t *testing.T, s *Server, now time.Time, lines ...string) []float64 {
+	t.Helper()
+	var values []float64
+	for _, line := range lines {
+		event, err := s.parseLine(line, now)
+		if err != nil {
+			t.Fatalf("parseLine(%q) failed: %v", line, err)
+		}
+		values = append(values, event["metric_value"].(float64))
+	}
+	return values
+}
+
+func TestDeltaGauges(t *testing.T) {
+	s := New(config.StatsDConfig{}, "org_123", "svc", "prod", nil, buffer.New(10))
+	now := time.Now()
+
+	got := gaugeValues(t, s, now, "queue.depth:10|g", "queue.depth:+5|g", "queue.depth:-3|g")
+	want := []float64{10, 15, 12}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Expected gauge values %v, got %v", want, got)
+		}
+	}
+
+	// Each tag set is its own gauge, whatever the tag order
+	got = gaugeValues(t, s, now, "conns:4|g|#host:a,role:db", "conns:+1|g|#role:db,host:a", "conns:+1|g|#host:b")
+	if got[1] != 5 || got[2] != 1 {
+		t.Errorf("Expected per-tagset gauges 5 and 1, got %v", got)
+	}
+}
+
+func TestDeltaGaugeTTL(t *testing.T) {
+	s := New(config.StatsDConfig{GaugeTTLDuration: time.Minute}, "org_123", "svc", "prod", nil, buffer.New(10))
+	start := time.Now()
+
+	gaugeValues(t, s, start, "workers:8|g")
+	if got := gaugeValues(t, s, start.Add(30*time.Second), "workers:+1|g"); got[0] != 9 {
+		t.Errorf("Expected 9 within the TTL, got %v", got[0])
+	}
+	if got := gaugeValues(t, s, start.Add(5*time.Minute), "workers:+1|g"); got[0] != 1 {
+		t.Errorf("Expected a stale gauge to restart from 0, got %v", got[0])
+	}
+	if len(s.gauges.values) != 1 {
+		t.Errorf("Expected stale gauges to be evicted, got %d", len(s.gauges.values))
+	}
+}
+
+func TestClientTimestamps(t *testing.T) {
+	s := New(config.StatsDConfig{}, "org_123", "svc", "prod", nil, buffer.New(10))
+	now := time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+
+	recent := now.Add(-10 * time.Minute)
+	event, err := s.parseLine(fmt.Sprintf("jobs.processed:42|c|#queue:mail|T%d", recent.Unix()), now)
+	if err != nil {
+		t.Fatalf("parseLine failed: %v", err)
+	}
+	if event["timestamp"] != recent.Format(time.RFC3339Nano) {
+		t.Errorf("Expected client timestamp %s, got %v", recent.Format(time.RFC3339Nano), event["timestamp"])
+	}
+	tags := event["tags"].(map[string]string)
+	if tags["queue"] != "mail" {
+		t.Errorf("Expected packet tags alongside the timestamp, got %v", tags)
+	}
+	if _, ok := tags["timestamp_adjusted"]; ok {
+		t.Errorf("Expected no timestamp_adjusted tag, got %v", tags)
+	}
+
+	old := now.Add(-2 * time.Hour)
+	event, err = s.parseLine(fmt.Sprintf("jobs.processed:1|c|T%d", old.Unix()), now)
+	if err != nil {
+		t.Fatalf("parseLine failed: %v", err)
+	}
+	if event["timestamp"] != now.Format(time.RFC3339Nano) {
+		t.Errorf("Expected a too-old timestamp to fall back to now, got %v", event["timestamp"])
+	}
+	if event["tags"].(map[string]string)["timestamp_adjusted"] != "true" {
+		t.Errorf("Expected timestamp_adjusted=true, got %v", event["tags"])
+	}
+
+	wide := New(config.StatsDConfig{MaxTimestampAgeDuration: 24 * time.Hour}, "org_123", "svc", "prod", nil, buffer.New(10))
+	event, _ = wide.parseLine(fmt.Sprintf("jobs.processed:1|c|T%d", old.Unix()), now)
+	if event["timestamp"] != old.Format(time.RFC3339Nano) {
+		t.Errorf("Expected max_timestamp_age to widen the window, got %v", event["timestamp"])
+	}
+}
+
+func TestTagCardinalityGuard(t *testing.T) {
+	s := New(config.StatsDConfig{}, "org_123", "svc", "prod", nil, buffer.New(10))
+	now := time.Now()
+
+	var masked int
+	for i := 0; i < 2000; i++ {
+		event, err := s.parseLine(fmt.Sprintf("api.requests:1|c|#request_id:%d,route:/users", i), now)
+		if err != nil {
+			t.Fatalf("parseLine failed: %v", err)
+		}
+		tags := event["tags"].(map[string]string)
+		if tags["route"] != "/users" {
+			t.Fatalf("Expected other tags to pass through, got %q", tags["route"])
+		}
+		if tags["request_id"] == highCardinalityValue {
+			masked++
+		} else if i >= defaultMaxTagCardinality {
+			t.Fatalf("Expected request_id %d to be masked", i)
+		}
+	}
+	if masked != 2000-defaultMaxTagCardinality {
+		t.Errorf("Expected %d masked values, got %d", 2000-defaultMaxTagCardinality, masked)
+	}
+
+	// Counting starts over in the next window
+	event, _ := s.parseLine("api.requests:1|c|#request_id:fresh", now.Add(cardinalityWindow))
+	if got := event["tags"].(map[string]string)["request_id"]; got != "fresh" {
+		t.Errorf("Expected the key to pass again in a new window, got %q", got)
+	}
+}
+
+func TestTagCardinalityGuardSetLimit(t *testing.T) {
+	s := New(config.StatsDConfig{}, "org_123", "svc", "prod", nil, buffer.New(10))
+	s.SetMaxTagCardinality(2)
+	now := time.Now()
+
+	got := gaugeValues(t, s, now, "conns:1|g|#user:a", "conns:1|g|#user:b", "conns:1|g|#user:c", "conns:+1|g|#user:d")
+	// c and d share the masked gauge, so d adjusts c
+	if got[3] != 2 {
+		t.Errorf("Expected masked tags to share one gauge, got %v", got)
+	}
+	if len(s.gauges.values) != 3 {
+		t.Errorf("Expected 3 gauges, got %d", len(s.gauges.values))
+	}
+}
+
+func TestFlushInterval(t *testing.T) {
+	buf := buffer.New(100)
+	s := New(config.StatsDConfig{ListenAddr: "127.0.0.1:0", FlushIntervalDuration: 50 * time.Millisecond}, "org_123", "svc", "prod", nil, buf)
+	stop, err := s.Start()
+	if err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer stop()
+
+	s.handleMessage("requests:1|c\nrequests:1|c")
+	if buf.Len() != 0 {
+		t.Errorf("Expected metrics held until the next tick, got %d buffered", buf.Len())
+	}
+
+	select {
+	case <-buf.FlushSignal():
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected a flush requested on the tick")
+	}
+	if buf.Len() != 2 {
+		t.Errorf("Expected both metrics buffered on the tick, got %d", buf.Len())
+	}
+}
@@ -1,6 +1,7 @@
 package tui
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -35,6 +36,7 @@ type ConfigEditor struct {
 
 const (
 	fieldAPIKey = iota
+	fieldOrganizationID
 	fieldServiceName
 	fieldEnvironment
 	fieldAPIEndpoint
@@ -46,6 +48,20 @@ const (
 	totalTextInputs
 )
 
+// inputKeys maps each text input to the config key its errors are reported under.
+var inputKeys = [totalTextInputs]string{
+	fieldAPIKey:          "api_key",
+	fieldOrganizationID:  "organization_id",
+	fieldServiceName:     "service_name",
+	fieldEnvironment:     "environment",
+	fieldAPIEndpoint:     "api_endpoint",
+	fieldBufferSize:      "buffer_size",
+	fieldFlushInterval:   "flush_interval",
+	fieldBatchSize:       "delivery.batch_size",
+	fieldMetricsInterval: "metrics.interval",
+	fieldStatsdAddr:      "metrics.statsd.listen_addr",
+}
+
 func NewConfigEditor(cfg *config.Config, path string) *ConfigEditor {
 	var base config.Config
 	if cfg != nil {
@@ -100,6 +116,11 @@ func NewConfigEditor(cfg *config.Config, path string) *ConfigEditor {
 	apiKey.EchoCharacter = '*'
 	apiKey.Width = 48
 
+	orgID := textinput.New()
+	orgID.Placeholder = "org_..."
+	orgID.SetValue(base.OrganizationID)
+	orgID.Width = 48
+
 	service := textinput.New()
 	service.Placeholder = "my-service"
 	service.SetValue(base.ServiceName)
@@ -143,6 +164,7 @@ func NewConfigEditor(cfg *config.Config, path string) *ConfigEditor {
 	statsdAddr.Width = 20
 
 	editor.inputs[fieldAPIKey] = apiKey
+	editor.inputs[fieldOrganizationID] = orgID
 	editor.inputs[fieldServiceName] = service
 	editor.inputs[fieldEnvironment] = env
 	editor.inputs[fieldAPIEndpoint] = endpoint
@@ -296,6 +318,7 @@ func (e *ConfigEditor) save() error {
 	w := e.working
 
 	apiKey := strings.TrimSpace(e.inputs[fieldAPIKey].Value())
+	orgID := strings.TrimSpace(e.inputs[fieldOrganizationID].Value())
 	service := strings.TrimSpace(e.inputs[fieldServiceName].Value())
 	environment := strings.TrimSpace(e.inputs[fieldEnvironment].Value())
 	apiEndpoint := strings.TrimSpace(e.inputs[fieldAPIEndpoint].Value())
@@ -305,27 +328,24 @@ func (e *ConfigEditor) save() error {
 	metricsInterval := strings.TrimSpace(e.inputs[fieldMetricsInterval].Value())
 	statsdAddr := strings.TrimSpace(e.inputs[fieldStatsdAddr].Value())
 
-	if apiKey == "" {
-		return fmt.Errorf("api_key is required")
-	}
-	if service == "" {
-		return fmt.Errorf("service_name is required")
-	}
-	if apiEndpoint == "" {
-		return fmt.Errorf("api_endpoint is required")
-	}
-
+	// Fields the config cannot represent are checked here; everything else
+	// goes through the same validation LoadConfig applies.
+	var invalid []*config.FieldError
 	buf, err := strconv.Atoi(bufferSize)
 	if err != nil || buf <= 0 {
-		return fmt.Errorf("buffer_size must be a positive integer")
+		invalid = append(invalid, &config.FieldError{Field: "buffer_size", Message: "must be a positive integer"})
 	}
-
 	batch, err := strconv.Atoi(batchSize)
 	if err != nil || batch <= 0 {
-		return fmt.Errorf("delivery.batch_size must be a positive integer")
+		invalid = append(invalid, &config.FieldError{Field: "delivery.batch_size", Message: "must be a positive integer"})
 	}
 
+	// Editing a key read from api_key_file stores the new one inline
+	if apiKey != e.working.APIKey {
+		w.APIKeyFromFile = false
+	}
 	w.APIKey = apiKey
+	w.OrganizationID = orgID
 	w.ServiceName = service
 	w.Environment = environment
 	w.APIEndpoint = apiEndpoint
@@ -352,6 +372,17 @@ func (e *ConfigEditor) save() error {
 
 	w.Logs = append([]config.LogConfig(nil), e.logEntries...)
 
+	if err := w.Validate(); err != nil {
+		var verr *config.ValidationError
+		if !errors.As(err, &verr) {
+			return err
+		}
+		invalid = append(invalid, verr.Fields...)
+	}
+	if len(invalid) > 0 {
+		return &config.ValidationError{Fields: invalid}
+	}
+
 	path := e.configPath
 	if path == "" {
 		path = config.DefaultConfigPath()
@@ -361,6 +392,7 @@ func (e *ConfigEditor) save() error {
 	if err := config.SaveConfig(path, &w); err != nil {
 		return err
 	}
+	e.err = nil
 
 	e.working = w
 	e.savedPath = path
@@ -374,26 +406,19 @@ func (e *ConfigEditor) View() string {
 
 	b.WriteString(TitleStyle.Render("Edit Configuration") + "\n\n")
 
-	b.WriteString(LabelStyle.Render("API Key") + "\n")
-	b.WriteString("  " + e.inputs[fieldAPIKey].View() + "\n\n")
-
-	b.WriteString(LabelStyle.Render("Service Name") + "\n")
-	b.WriteString("  " + e.inputs[fieldServiceName].View() + "\n\n")
-
-	b.WriteString(LabelStyle.Render("Environment") + "\n")
-	b.WriteString("  " + e.inputs[fieldEnvironment].View() + "\n\n")
-
-	b.WriteString(LabelStyle.Render("API Endpoint") + "\n")
-	b.WriteString("  " + e.inputs[fieldAPIEndpoint].View() + "\n\n")
-
-	b.WriteString(LabelStyle.Render("Buffer Size") + "\n")
-	b.WriteString("  " + e.inputs[fieldBufferSize].View() + "\n\n")
-
-	b.WriteString(LabelStyle.Render("Flush Interval") + "\n")
-	b.WriteString("  " + e.inputs[fieldFlushInterval].View() + "\n\n")
-
-	b.WriteString(LabelStyle.Render("Delivery Batch Size") + "\n")
-	b.WriteString("  " + e.inputs[fieldBatchSize].View() + "\n\n")
+	e.writeInput(&b, "API Key", fieldAPIKey)
+	if strings.TrimSpace(e.inputs[fieldAPIKey].Value()) == "" {
+		b.WriteString(MutedStyle.Render("  Leave blank for local-only mode (events stay in local analytics)") + "\n\n")
+	} else if e.working.APIKeyFromFile {
+		b.WriteString(MutedStyle.Render("  Read from "+e.working.APIKeyFile+"; not saved to the config file") + "\n\n")
+	}
+	e.writeInput(&b, "Organization ID", fieldOrganizationID)
+	e.writeInput(&b, "Service Name", fieldServiceName)
+	e.writeInput(&b, "Environment", fieldEnvironment)
+	e.writeInput(&b, "API Endpoint", fieldAPIEndpoint)
+	e.writeInput(&b, "Buffer Size", fieldBufferSize)
+	e.writeInput(&b, "Flush Interval", fieldFlushInterval)
+	e.writeInput(&b, "Delivery Batch Size", fieldBatchSize)
 
 	checkbox := func(selected bool, label string, idx int) string {
 		cursor := "  "
@@ -412,11 +437,8 @@ func (e *ConfigEditor) View() string {
 	b.WriteString(checkbox(e.statsdEnabled, "Enable StatsD listener (metrics.statsd.enabled)", 2) + "\n")
 	b.WriteString(checkbox(e.scrubEnabled, "Enable scrubbing rules (scrubbing.enabled)", 3) + "\n\n")
 
-	b.WriteString(LabelStyle.Render("Metrics Interval") + "\n")
-	b.WriteString("  " + e.inputs[fieldMetricsInterval].View() + "\n\n")
-
-	b.WriteString(LabelStyle.Render("StatsD Listen Address") + "\n")
-	b.WriteString("  " + e.inputs[fieldStatsdAddr].View() + "\n\n")
+	e.writeInput(&b, "Metrics Interval", fieldMetricsInterval)
+	e.writeInput(&b, "StatsD Listen Address", fieldStatsdAddr)
 
 	b.WriteString(SectionHeaderStyle.Render("Log Sources") + "\n")
 	if len(e.logEntries) == 0 {
@@ -445,8 +467,8 @@ func (e *ConfigEditor) View() string {
 	b.WriteString("  Path: " + pathView + "\n")
 	b.WriteString("  Format: " + formatView + "\n\n")
 
-	if e.err != nil {
-		b.WriteString(ErrorStyle.Render(fmt.Sprintf("Error: %v", e.err)) + "\n\n")
+	if msg := e.unplacedError(); msg != "" {
+		b.WriteString(ErrorStyle.Render("Error: "+msg) + "\n\n")
 	}
 
 	b.WriteString(MutedStyle.Render("[Tab] Next  [Shift+Tab] Previous  [Space] Toggle  [Enter] Edit/Add log  [Del] Remove log  [Ctrl+N] New log  [Ctrl+S] Save  [Esc] Cancel") + "\n")
@@ -454,6 +476,49 @@ func (e *ConfigEditor) View() string {
 	return BaseStyle.Render(b.String())
 }
 
+// writeInput renders a labelled text input followed by its validation error, if any.
+func (e *ConfigEditor) writeInput(b *strings.Builder, label string, idx int) {
+	b.WriteString(LabelStyle.Render(label) + "\n")
+	b.WriteString("  " + e.inputs[idx].View() + "\n")
+	if fieldErr := e.FieldError(idx); fieldErr != nil {
+		b.WriteString("  " + ErrorStyle.Render(fieldErr.Error()) + "\n")
+	}
+	b.WriteString("\n")
+}
+
+// FieldError returns the validation error from the last save for a text input.
+func (e *ConfigEditor) FieldError(idx int) *config.FieldError {
+	var verr *config.ValidationError
+	if idx < 0 || idx >= totalTextInputs || !errors.As(e.err, &verr) {
+		return nil
+	}
+	return verr.Field(inputKeys[idx])
+}
+
+// unplacedError describes the last save error except for failures already
+// shown next to their input.
+func (e *ConfigEditor) unplacedError() string {
+	if e.err == nil {
+		return ""
+	}
+	var verr *config.ValidationError
+	if !errors.As(e.err, &verr) {
+		return e.err.Error()
+	}
+
+	placed := make(map[string]bool, totalTextInputs)
+	for _, key := range inputKeys {
+		placed[key] = true
+	}
+	var msgs []string
+	for _, f := range verr.Fields {
+		if !placed[f.Field] {
+			msgs = append(msgs, f.Error())
+		}
+	}
+	return strings.Join(msgs, "; ")
+}
+
 func (e *ConfigEditor) totalFocusItems() int {
 	return totalTextInputs + 4 + len(e.logEntries) + 2
 }
@@ -0,0 +1,80 @@
+package tui
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/yaat-app/sidecar/internal/config"
+)
+
+func newTestEditor(t *testing.T) (*ConfigEditor, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "yaat.yaml")
+	editor := NewConfigEditor(nil, path)
+	editor.inputs[fieldServiceName].SetValue("svc")
+	return editor, path
+}
+
+func TestConfigEditorLocalOnly(t *testing.T) {
+	editor, path := newTestEditor(t)
+
+	if err := editor.save(); err != nil {
+		t.Fatalf("Expected local-only config to save, got %v", err)
+	}
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Saved config does not load: %v", err)
+	}
+	if cfg.APIKey != "" || cfg.OrganizationID != "" {
+		t.Errorf("Expected no credentials, got api_key %q organization_id %q", cfg.APIKey, cfg.OrganizationID)
+	}
+}
+
+func TestConfigEditorCloudRequiresOrganizationID(t *testing.T) {
+	editor, path := newTestEditor(t)
+	editor.inputs[fieldAPIKey].SetValue("yaat_secret")
+	editor.inputs[fieldAPIEndpoint].SetValue("")
+
+	editor.err = editor.save()
+	if editor.err == nil {
+		t.Fatal("Expected save to fail without organization_id")
+	}
+	if editor.saved {
+		t.Error("Expected editor not to be marked saved")
+	}
+	if editor.FieldError(fieldOrganizationID) == nil {
+		t.Errorf("Expected organization_id field error, got %v", editor.err)
+	}
+	if editor.FieldError(fieldAPIEndpoint) == nil {
+		t.Errorf("Expected api_endpoint field error, got %v", editor.err)
+	}
+	if editor.FieldError(fieldServiceName) != nil {
+		t.Errorf("Expected no service_name error, got %v", editor.FieldError(fieldServiceName))
+	}
+
+	editor.inputs[fieldOrganizationID].SetValue("org_123")
+	editor.inputs[fieldAPIEndpoint].SetValue("https://yaat.io/api/v1/ingest")
+	if err := editor.save(); err != nil {
+		t.Fatalf("Expected cloud config to save, got %v", err)
+	}
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Saved config does not load: %v", err)
+	}
+	if cfg.OrganizationID != "org_123" {
+		t.Errorf("Expected organization_id org_123, got %q", cfg.OrganizationID)
+	}
+}
+
+func TestConfigEditorInvalidNumbers(t *testing.T) {
+	editor, _ := newTestEditor(t)
+	editor.inputs[fieldBufferSize].SetValue("lots")
+
+	editor.err = editor.save()
+	if editor.FieldError(fieldBufferSize) == nil {
+		t.Errorf("Expected buffer_size field error, got %v", editor.err)
+	}
+	if msg := editor.unplacedError(); msg != "" {
+		t.Errorf("Expected every error to be shown next to its input, got %q", msg)
+	}
+}
@@ -13,7 +13,9 @@ import (
 	"github.com/yaat-app/sidecar/internal/config"
 	"github.com/yaat-app/sidecar/internal/daemon"
 	"github.com/yaat-app/sidecar/internal/diag"
+	"github.com/yaat-app/sidecar/internal/doctor"
 	"github.com/yaat-app/sidecar/internal/forwarder"
+	"github.com/yaat-app/sidecar/internal/paths"
 	"github.com/yaat-app/sidecar/internal/state"
 )
 
@@ -45,6 +47,7 @@ type Dashboard struct {
 	configError error
 
 	// Service status
+	layout    paths.Layout
 	isRunning bool
 	uptime    time.Duration
 	startTime time.Time
@@ -55,6 +58,8 @@ type Dashboard struct {
 	// Test results
 	testResults  []TestResult
 	lastTest     state.TestResult
+	testHistory  []state.TestResult
+	incidents    []state.Incident
 	stateError   error
 	diagSnapshot diag.Snapshot
 
@@ -68,6 +73,7 @@ type Dashboard struct {
 	uninstallConfirm bool
 	uninstallResult  string
 	uninstallWarnings []string
+	uninstallPlan     []daemon.UninstallItem
 
 	// Quit flag
 	quitting bool
@@ -81,8 +87,9 @@ type TailedFile struct {
 
 type TestResult struct {
 	Name   string
-	Status string
+	Status string // pass, warn, fail or skip
 	Detail string
+	Hint   string
 }
 
 // NewDashboard creates a new dashboard
@@ -116,12 +123,15 @@ func NewDashboard() *Dashboard {
 		configPath:  cfgPath,
 		configError: err,
 		tailedFiles: logFiles,
+		layout:      paths.Detect().Resolve(paths.DefaultInstance),
 	}
 
 	if st, stateErr := state.Load(); stateErr != nil {
 		dashboard.stateError = stateErr
 	} else if st != nil {
 		dashboard.lastTest = st.LastTest
+		dashboard.testHistory = st.TestHistory
+		dashboard.incidents = st.Incidents
 		if dashboard.config == nil && st.ConfigPath != "" {
 			dashboard.configPath = st.ConfigPath
 		}
@@ -162,6 +172,11 @@ func (m Dashboard) Init() tea.Cmd {
 func (m Dashboard) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		if msg.String() != "ctrl+c" && m.currentView == viewSetup && m.setupWizard != nil && m.setupWizard.capturesKeys() {
+			// Typing into a wizard field: letters are text, not shortcuts
+			return m.updateSetupWizard(msg)
+		}
+
 		switch msg.String() {
 		case "q", "ctrl+c":
 			m.quitting = true
@@ -224,13 +239,14 @@ func (m Dashboard) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.uninstallConfirm = false
 				m.uninstallResult = ""
 				m.uninstallWarnings = nil
+				m.uninstallPlan = daemon.PlanUninstall(daemon.UninstallOptions{})
 			}
 			return m, nil
 
 		case "y", "Y":
 			if m.currentView == viewUninstall && !m.uninstallConfirm {
 				m.uninstallConfirm = true
-				warnings, err := daemon.Uninstall()
+				warnings, err := daemon.Uninstall(daemon.UninstallOptions{})
 				if err != nil {
 					m.uninstallWarnings = append(warnings, err.Error())
 					m.uninstallResult = "error"
@@ -263,10 +279,7 @@ func (m Dashboard) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 
 		if m.currentView == viewSetup && m.setupWizard != nil {
-			wizard, cmd := m.setupWizard.Update(msg)
-			m.setupWizard = wizard
-			m.handleSetupWizardCompletion()
-			return m, cmd
+			return m.updateSetupWizard(msg)
 		}
 
 		if m.currentView != viewDashboard && m.currentView != viewSetup {
@@ -282,8 +295,7 @@ func (m Dashboard) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	case tickMsg:
 		// Update daemon status
-		defaultPidPath := "/var/run/yaat-sidecar.pid"
-		m.isRunning = daemon.IsRunning(defaultPidPath)
+		m.isRunning = daemon.IsRunning(m.layout)
 		if m.isRunning {
 			m.uptime += 1 * time.Second
 		}
@@ -300,11 +312,21 @@ func (m Dashboard) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.handleConfigEditorResult()
 			return m, cmd
 		}
+		if m.currentView == viewSetup && m.setupWizard != nil {
+			return m.updateSetupWizard(msg)
+		}
 	}
 
 	return m, nil
 }
 
+func (m Dashboard) updateSetupWizard(msg tea.Msg) (tea.Model, tea.Cmd) {
+	wizard, cmd := m.setupWizard.Update(msg)
+	m.setupWizard = wizard
+	m.handleSetupWizardCompletion()
+	return m, cmd
+}
+
 func (m *Dashboard) startConfigEditor() {
 	path := m.configPath
 	if path == "" {
@@ -479,10 +501,9 @@ func (m Dashboard) renderServiceSection() string {
 	}
 	b.WriteString(MetricRow("Daemon", statusLabel, highlight) + "\n")
 
-	defaultLogPath := "/var/log/yaat-sidecar.log"
-	logPath := daemon.GetLogPath(defaultLogPath)
+	logPath := daemon.GetLogPath(m.layout)
 	if logPath == "" {
-		logPath = daemon.GetExpectedLogPath(defaultLogPath) + " (pending)"
+		logPath = m.layout.LogFile + " (pending)"
 	}
 	b.WriteString(MetricRow("Log file", logPath, false) + "\n")
 
@@ -528,6 +549,18 @@ func (m Dashboard) renderConnectivitySection() string {
 		b.WriteString(MetricRow("Latency", fmt.Sprintf("%d ms", m.lastTest.LatencyMillis), false) + "\n")
 	}
 	b.WriteString(MetricRow("Events sent", fmt.Sprintf("%d", len(m.lastTest.Events)), false) + "\n")
+	if m.lastTest.ClockSkewMillis != nil {
+		skew := time.Duration(*m.lastTest.ClockSkewMillis) * time.Millisecond
+		warnAt := 30 * time.Second
+		if m.config != nil && m.config.Delivery.ClockSkewWarnDuration > 0 {
+			warnAt = m.config.Delivery.ClockSkewWarnDuration
+		}
+		row := MetricRow("Clock skew", skew.String(), false)
+		if skew > warnAt || -skew > warnAt {
+			row = WarningStyle.Render(fmt.Sprintf("  ⚠ Clock skew %v exceeds %v", skew, warnAt))
+		}
+		b.WriteString(row + "\n")
+	}
 
 	if m.lastTest.Error != "" {
 		b.WriteString(ErrorStyle.Render("  "+m.lastTest.Error) + "\n")
@@ -564,14 +597,28 @@ func (m Dashboard) renderDeliverySection() string {
 
 	snap := m.diagSnapshot
 	b.WriteString(MetricRow("Queue length", fmt.Sprintf("%d", snap.QueueLength), false) + "\n")
-	b.WriteString(MetricRow("In-memory queue", fmt.Sprintf("%d", snap.InMemoryQueue), false) + "\n")
-	b.WriteString(MetricRow("Persisted queue", fmt.Sprintf("%d", snap.PersistedQueue), false) + "\n")
-	b.WriteString(MetricRow("Dead-letter queue", fmt.Sprintf("%d", snap.DeadLetterQueue), false) + "\n")
+	b.WriteString(MetricRow("In-memory queue", formatBufferFill(snap.InMemoryQueue, snap.BufferCapacity, snap.BufferHighWater), false) + "\n")
+	b.WriteString(MetricRow("Persisted queue", formatDiskQueue(snap.PersistedEvents, snap.PersistedQueue, snap.PersistedOldestSeconds), false) + "\n")
+	b.WriteString(MetricRow("Dead-letter queue", formatDiskQueue(snap.DeadLetterEvents, snap.DeadLetterQueue, snap.DeadLetterOldestSeconds), false) + "\n")
+	if snap.DeadLetterQueue > 0 && snap.DeadLetterLastError != "" {
+		b.WriteString(MetricRow("Dead-letter reason", truncate(snap.DeadLetterLastError, 60), true) + "\n")
+	}
 	b.WriteString(MetricRow("Events sent", fmt.Sprintf("%d", snap.TotalEventsSent), false) + "\n")
 	if snap.TotalEventsFailed > 0 {
 		b.WriteString(MetricRow("Events failed", fmt.Sprintf("%d", snap.TotalEventsFailed), false) + "\n")
 	}
 	b.WriteString(MetricRow("Throughput (events/min)", fmt.Sprintf("%.1f", snap.ThroughputPerMin), false) + "\n")
+	if len(snap.DroppedEvents) > 0 {
+		b.WriteString(MetricRow("Dropped by limits", formatDrops(snap.DroppedEvents, snap.DropsPerMin), false) + "\n")
+	}
+	if d := snap.Delivery; d != nil && d.Requests > 0 {
+		b.WriteString(MetricRow("Request latency", fmt.Sprintf("p50 %dms · p95 %dms (last %d)", d.P50LatencyMillis, d.P95LatencyMillis, d.Requests), false) + "\n")
+		payload := formatBytes(int64(d.AvgPayloadBytes))
+		if d.CompressionRatio > 1 {
+			payload += fmt.Sprintf(" · %.1fx compression", d.CompressionRatio)
+		}
+		b.WriteString(MetricRow("Avg payload", payload, false) + "\n")
+	}
 	if !snap.LastSuccessAt.IsZero() {
 		b.WriteString(MetricRow("Last success", formatRelativeTime(snap.LastSuccessAt), false) + "\n")
 	}
@@ -579,7 +626,11 @@ func (m Dashboard) renderDeliverySection() string {
 		b.WriteString(MetricRow("Last failure", formatRelativeTime(snap.LastFailureAt), false) + "\n")
 	}
 	if snap.LastError != "" {
-		b.WriteString(ErrorStyle.Render("  "+snap.LastError) + "\n")
+		badge := ""
+		if snap.LastErrorCategory != "" {
+			badge = ErrorCategoryBadge(snap.LastErrorCategory) + " "
+		}
+		b.WriteString("  " + badge + ErrorStyle.Render(snap.LastError) + "\n")
 	}
 
 	return b.String()
@@ -602,7 +653,11 @@ func (m Dashboard) renderConfigView() string {
 		content += MutedStyle.Render("Press 'Enter' to create one here, or 'c' to return.") + "\n"
 	} else {
 		content = MutedStyle.Render("Configuration file: ") + ValueStyle.Render(m.configPath) + "\n\n"
-		content += LabelStyle.Render("api_key:       ") + ValueStyle.Render(maskAPIKey(m.config.APIKey)) + "\n"
+		apiKey := maskAPIKey(m.config.APIKey)
+		if m.config.APIKeyFromFile {
+			apiKey += MutedStyle.Render(" (from " + m.config.APIKeyFile + ")")
+		}
+		content += LabelStyle.Render("api_key:       ") + ValueStyle.Render(apiKey) + "\n"
 		content += LabelStyle.Render("service_name:  ") + ValueStyle.Render(m.config.ServiceName) + "\n"
 		content += LabelStyle.Render("environment:   ") + ValueStyle.Render(m.config.Environment) + "\n"
 		content += LabelStyle.Render("api_endpoint:  ") + ValueStyle.Render(m.config.APIEndpoint) + "\n"
@@ -703,9 +758,14 @@ func (m Dashboard) renderTestView() string {
 	} else {
 		for _, test := range m.testResults {
 			var statusText string
-			if test.Status == "pass" {
+			switch test.Status {
+			case "pass":
 				statusText = SuccessStyle.Render("✓ PASS")
-			} else {
+			case "warn":
+				statusText = WarningStyle.Render("! WARN")
+			case "skip":
+				statusText = MutedStyle.Render("- SKIP")
+			default:
 				statusText = ErrorStyle.Render("✗ FAIL")
 			}
 
@@ -713,15 +773,83 @@ func (m Dashboard) renderTestView() string {
 			if test.Detail != "" {
 				content.WriteString(MutedStyle.Render(fmt.Sprintf("  %s", test.Detail)) + "\n")
 			}
+			if test.Hint != "" && (test.Status == "warn" || test.Status == "fail") {
+				content.WriteString(MutedStyle.Render(fmt.Sprintf("  → %s", test.Hint)) + "\n")
+			}
 			content.WriteString("\n")
 		}
 	}
 
+	content.WriteString(m.renderHistoryPanel())
+
 	content.WriteString(MutedStyle.Render("Press 't' to return to dashboard") + "\n")
 
 	return BaseStyle.Render(header+content.String()) + "\n"
 }
 
+// maxShownIncidents caps the delivery incidents listed in the History panel.
+const maxShownIncidents = 5
+
+// renderHistoryPanel shows recent test outcomes as a pass/fail row, oldest
+// first, followed by the latest delivery incidents.
+func (m Dashboard) renderHistoryPanel() string {
+	if len(m.testHistory) == 0 && len(m.incidents) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString(SectionHeaderStyle.Render("History") + "\n")
+
+	if len(m.testHistory) > 0 {
+		passed := 0
+		var row strings.Builder
+		for _, result := range m.testHistory {
+			if result.Success {
+				passed++
+				row.WriteString(SuccessStyle.Render("▮"))
+			} else {
+				row.WriteString(ErrorStyle.Render("▮"))
+			}
+		}
+		first := m.testHistory[0].RanAt
+		b.WriteString(fmt.Sprintf("Tests:     %s %d/%d passed since %s\n", row.String(), passed, len(m.testHistory), formatRelativeTime(first)))
+	}
+
+	if len(m.incidents) == 0 {
+		b.WriteString(MutedStyle.Render("Incidents: none recorded") + "\n\n")
+		return b.String()
+	}
+
+	b.WriteString("Incidents:\n")
+	shown := m.incidents
+	if len(shown) > maxShownIncidents {
+		shown = shown[len(shown)-maxShownIncidents:]
+	}
+	for i := len(shown) - 1; i >= 0; i-- {
+		b.WriteString("  " + formatIncident(shown[i]) + "\n")
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// formatIncident renders one delivery outage on a single line.
+func formatIncident(incident state.Incident) string {
+	var status string
+	if incident.Ongoing() {
+		status = ErrorStyle.Render(fmt.Sprintf("✗ failing since %s", formatRelativeTime(incident.StartedAt)))
+	} else {
+		outage := time.Duration(incident.OutageMillis) * time.Millisecond
+		status = WarningStyle.Render(fmt.Sprintf("⚠ %s outage %s", outage.Truncate(time.Second), formatRelativeTime(incident.StartedAt)))
+		if incident.EventsQueued > 0 {
+			status += MutedStyle.Render(fmt.Sprintf(", %d events queued", incident.EventsQueued))
+		}
+	}
+	if incident.Error != "" {
+		status += MutedStyle.Render(" • " + truncate(incident.Error, 60))
+	}
+	return status
+}
+
 // renderUninstallView renders the uninstall confirmation and results
 func (m Dashboard) renderUninstallView() string {
 	header := TitleStyle.Render("Uninstall YAAT Sidecar") + "\n\n"
@@ -732,11 +860,18 @@ func (m Dashboard) renderUninstallView() string {
 		// Show confirmation prompt
 		content.WriteString(WarningStyle.Render("⚠ WARNING: This will completely remove YAAT Sidecar") + "\n\n")
 		content.WriteString(MutedStyle.Render("The following will be removed:") + "\n")
-		content.WriteString(MutedStyle.Render("  • Binary: /usr/local/bin/yaat-sidecar") + "\n")
-		content.WriteString(MutedStyle.Render("  • Configuration files") + "\n")
-		content.WriteString(MutedStyle.Render("  • State and queue directories") + "\n")
-		content.WriteString(MutedStyle.Render("  • System directories (/var/lib/yaat, /var/log/yaat)") + "\n")
-		content.WriteString(MutedStyle.Render("  • Launch daemon/agent (systemd service or launchd plist)") + "\n\n")
+		var total int64
+		for _, item := range m.uninstallPlan {
+			content.WriteString(MutedStyle.Render(fmt.Sprintf("  • %s: %s", item.Group, item)) + "\n")
+			total += item.Size
+		}
+		if len(m.uninstallPlan) == 0 {
+			content.WriteString(MutedStyle.Render("  (nothing found)") + "\n")
+		} else {
+			content.WriteString(MutedStyle.Render(fmt.Sprintf("  %d path(s), %s in total", len(m.uninstallPlan), daemon.FormatSize(total))) + "\n")
+		}
+		content.WriteString(MutedStyle.Render("  Running processes and the systemd service are stopped first.") + "\n")
+		content.WriteString(MutedStyle.Render("  Use `yaat-sidecar --uninstall --keep-data` to keep queued events and analytics.") + "\n\n")
 		content.WriteString(ErrorStyle.Render("This action cannot be undone!") + "\n\n")
 		content.WriteString(MutedStyle.Render("Are you sure you want to uninstall? ") + "\n")
 		content.WriteString(KeyStyle.Render("y") + MutedStyle.Render(" Yes, uninstall  ") + "\n")
@@ -781,60 +916,29 @@ func (m *Dashboard) runTests() {
 		return
 	}
 
-	m.testResults = append(m.testResults, TestResult{
-		Name:   "Configuration File",
-		Status: "pass",
-		Detail: fmt.Sprintf("Loaded from %s", m.configPath),
-	})
-
-	// Test 2: API Key
-	if m.config.APIKey == "" {
+	for _, r := range doctor.Run(m.config, doctor.Options{
+		HealthAddr: m.config.Health.Addr(0),
+		QueueDir:   os.Getenv("YAAT_QUEUE_DIR"),
+		SkipPorts:  m.isRunning, // The sidecar holds them itself
+	}) {
 		m.testResults = append(m.testResults, TestResult{
-			Name:   "API Key",
-			Status: "fail",
-			Detail: "API key is empty",
+			Name:   r.Name,
+			Status: r.Status.String(),
+			Detail: r.Detail,
+			Hint:   r.Hint,
 		})
-	} else {
-		m.testResults = append(m.testResults, TestResult{
-			Name:   "API Key",
-			Status: "pass",
-			Detail: "API key is set",
-		})
-	}
-
-	// Test 3: Log Files
-	readable := 0
-	for _, log := range m.tailedFiles {
-		if log.Readable {
-			readable++
-		}
 	}
 
-	if len(m.tailedFiles) == 0 {
-		m.testResults = append(m.testResults, TestResult{
-			Name:   "Log Files",
-			Status: "fail",
-			Detail: "No log files configured",
-		})
-	} else if readable == 0 {
-		m.testResults = append(m.testResults, TestResult{
-			Name:   "Log Files",
-			Status: "fail",
-			Detail: fmt.Sprintf("0/%d files readable", len(m.tailedFiles)),
-		})
-	} else {
-		m.testResults = append(m.testResults, TestResult{
-			Name:   "Log Files",
-			Status: "pass",
-			Detail: fmt.Sprintf("%d/%d files readable", readable, len(m.tailedFiles)),
-		})
+	if m.config.APIKey == "" {
+		return
 	}
 
-	// Test 4: API Connectivity
+	// Send test events end to end, as --test does
 	opts := forwarder.Options{
 		BatchSize:     m.config.Delivery.BatchSize,
 		Compress:      m.config.Delivery.Compress,
 		MaxBatchBytes: m.config.Delivery.MaxBatchBytes,
+		ClockSkewWarn: m.config.Delivery.ClockSkewWarnDuration,
 	}
 	fwd := forwarder.NewWithOptions(m.config.APIEndpoint, m.config.APIKey, opts)
 	report, err := fwd.Test(m.config.ServiceName, m.config.Environment, m.config.Tags)
@@ -850,19 +954,22 @@ func (m *Dashboard) runTests() {
 
 	if err != nil {
 		m.testResults = append(m.testResults, TestResult{
-			Name:   "API Connectivity",
+			Name:   "Test Events",
 			Status: "fail",
 			Detail: fmt.Sprintf("Connection failed: %v", err),
 		})
 	} else {
 		m.testResults = append(m.testResults, TestResult{
-			Name:   "API Connectivity",
+			Name:   "Test Events",
 			Status: "pass",
 			Detail: fmt.Sprintf("Sent %d test events in %v", len(events), latency.Truncate(time.Millisecond)),
 		})
 	}
 
 	latest := state.NewTestResult(m.config.APIEndpoint, m.config.ServiceName, m.config.Environment, events, latency, err)
+	if report != nil && report.ClockSkewMeasured {
+		latest.SetClockSkew(report.ClockSkew)
+	}
 
 	if recordErr := state.RecordTest(latest); recordErr != nil {
 		m.testResults = append(m.testResults, TestResult{
@@ -872,6 +979,10 @@ func (m *Dashboard) runTests() {
 		})
 	} else {
 		m.lastTest = latest
+		if st, loadErr := state.Load(); loadErr == nil {
+			m.testHistory = st.TestHistory
+			m.incidents = st.Incidents
+		}
 	}
 }
 
@@ -910,6 +1021,53 @@ func formatLogFileStatus(file TailedFile) (string, string) {
 	}
 }
 
+// formatDrops renders limit drops as "85/min (min_level 1000, rate_limit 204)".
+func formatDrops(dropped map[string]int64, perMin float64) string {
+	reasons := make([]string, 0, len(dropped))
+	for reason := range dropped {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	parts := make([]string, len(reasons))
+	for i, reason := range reasons {
+		parts[i] = fmt.Sprintf("%s %d", reason, dropped[reason])
+	}
+	return fmt.Sprintf("%.0f/min (%s)", perMin, strings.Join(parts, ", "))
+}
+
+// formatBufferFill renders the buffer as "412 / 1000 (peak 980)", or just
+// the count when the capacity is unknown.
+func formatBufferFill(current, capacity, peak int) string {
+	if capacity <= 0 {
+		return fmt.Sprintf("%d", current)
+	}
+	return fmt.Sprintf("%d / %d (peak %d)", current, capacity, peak)
+}
+
+// formatDiskQueue renders an on-disk queue as "N events in M batches (oldest 5m ago)".
+func formatDiskQueue(events, batches int, oldestSeconds float64) string {
+	if batches == 0 {
+		return "empty"
+	}
+	text := fmt.Sprintf("%d events in %d batches", events, batches)
+	if oldestSeconds > 0 {
+		oldest := time.Now().Add(-time.Duration(oldestSeconds * float64(time.Second)))
+		text += fmt.Sprintf(" (oldest %s)", formatRelativeTime(oldest))
+	}
+	return text
+}
+
+// formatBytes renders a size as B, KB or MB.
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1024*1024:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1024*1024))
+	case n >= 1024:
+		return fmt.Sprintf("%.1f KB", float64(n)/1024)
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
 func formatRelativeTime(t time.Time) string {
 	if t.IsZero() {
 		return ""
@@ -2,8 +2,14 @@ package tui
 
 import (
 	"fmt"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
@@ -21,10 +27,40 @@ const (
 	stepEnvironment
 	stepLogFiles
 	stepScrubbing
+	stepProxy
+	stepMetrics
 	stepReview
 	stepDone
 )
 
+// Fields of the proxy step, in navigation order.
+const (
+	proxyFieldEnabled = iota
+	proxyFieldPort
+	proxyFieldUpstream
+)
+
+// Fields of the metrics step, in navigation order.
+const (
+	metricsFieldEnabled = iota
+	metricsFieldInterval
+	metricsFieldStatsD
+	metricsFieldStatsDAddr
+)
+
+// metricsIntervals are the host metrics intervals offered by the wizard.
+var metricsIntervals = []string{"10s", "30s", "60s", "5m"}
+
+// upstreamCheckTimeout bounds the request that checks the proxy upstream responds.
+const upstreamCheckTimeout = 3 * time.Second
+
+// upstreamCheckMsg reports whether the proxy upstream responded.
+type upstreamCheckMsg struct {
+	url    string
+	ok     bool
+	result string
+}
+
 type SetupWizard struct {
 	step           setupStep
 	organizationID textinput.Model
@@ -42,6 +78,21 @@ type SetupWizard struct {
 	scrubSelected map[int]bool
 	scrubCursor   int
 
+	// HTTP proxy
+	proxyEnabled     bool
+	proxyPort        textinput.Model
+	proxyUpstream    textinput.Model
+	proxyField       int
+	upstreamCheck    *upstreamCheckMsg // nil while the check runs
+	upstreamChecking bool
+
+	// Host metrics and StatsD
+	metricsEnabled  bool
+	metricsInterval int // Index into metricsIntervals
+	statsdEnabled   bool
+	statsdAddr      textinput.Model
+	metricsField    int
+
 	// Detected environment
 	cloudMetadata *detection.CloudProvider
 	k8sMetadata   *detection.KubernetesMetadata
@@ -53,6 +104,14 @@ type SetupWizard struct {
 }
 
 func NewSetupWizard() *SetupWizard {
+	// Auto-detect environment (logs, cloud, k8s)
+	env := detection.DetectEnvironment()
+	cloud := detection.DetectCloudProvider()
+	k8s := detection.DetectKubernetesMetadata()
+	return newSetupWizard(env.LogFiles, cloud, k8s)
+}
+
+func newSetupWizard(logFiles []detection.LogFile, cloud *detection.CloudProvider, k8s *detection.KubernetesMetadata) *SetupWizard {
 	// Create text inputs
 	organizationID := textinput.New()
 	organizationID.Placeholder = "org_xxxxxxxxxxxxxxxxxxxxx"
@@ -69,10 +128,20 @@ func NewSetupWizard() *SetupWizard {
 	serviceName.Placeholder = "my-service"
 	serviceName.Width = 50
 
-	// Auto-detect environment (logs, cloud, k8s)
-	env := detection.DetectEnvironment()
-	cloud := detection.DetectCloudProvider()
-	k8s := detection.DetectKubernetesMetadata()
+	proxyPort := textinput.New()
+	proxyPort.Placeholder = "19000"
+	proxyPort.SetValue("19000")
+	proxyPort.Width = 12
+
+	proxyUpstream := textinput.New()
+	proxyUpstream.Placeholder = "http://127.0.0.1:8000"
+	proxyUpstream.SetValue("http://127.0.0.1:8000")
+	proxyUpstream.Width = 50
+
+	statsdAddr := textinput.New()
+	statsdAddr.Placeholder = ":8125"
+	statsdAddr.SetValue(":8125")
+	statsdAddr.Width = 20
 
 	// Auto-detect service name: prefer k8s pod name > hostname
 	if k8s.InCluster && k8s.PodName != "" {
@@ -86,30 +155,43 @@ func NewSetupWizard() *SetupWizard {
 		selectedScrub[idx] = true
 	}
 
-	selectedLogs := make(map[int]bool, len(env.LogFiles))
-	for idx := range env.LogFiles {
-		if env.LogFiles[idx].Readable {
+	selectedLogs := make(map[int]bool, len(logFiles))
+	for idx := range logFiles {
+		if logFiles[idx].Readable {
 			selectedLogs[idx] = true
 		}
 	}
 
 	return &SetupWizard{
-		step:           stepOrganizationID,
-		organizationID: organizationID,
-		apiKey:         apiKey,
-		serviceName:    serviceName,
-		environment:    0, // Default to production
-		detectedLogs:   env.LogFiles,
-		selectedLogs:   selectedLogs,
-		scrubOptions:   recommended,
-		scrubSelected:  selectedScrub,
-		cloudMetadata:  cloud,
-		k8sMetadata:    k8s,
-		configPath:     os.ExpandEnv("$HOME/.yaat/yaat.yaml"),
+		step:            stepOrganizationID,
+		organizationID:  organizationID,
+		apiKey:          apiKey,
+		serviceName:     serviceName,
+		environment:     0, // Default to production
+		detectedLogs:    logFiles,
+		selectedLogs:    selectedLogs,
+		scrubOptions:    recommended,
+		scrubSelected:   selectedScrub,
+		proxyPort:       proxyPort,
+		proxyUpstream:   proxyUpstream,
+		metricsInterval: 1, // 30s, the config default
+		statsdAddr:      statsdAddr,
+		cloudMetadata:   cloud,
+		k8sMetadata:     k8s,
+		configPath:      os.ExpandEnv("$HOME/.yaat/yaat.yaml"),
 	}
 }
 
 func (s *SetupWizard) Update(msg tea.Msg) (*SetupWizard, tea.Cmd) {
+	if check, ok := msg.(upstreamCheckMsg); ok {
+		// Ignore results for an upstream the user has since changed
+		if check.url == strings.TrimSpace(s.proxyUpstream.Value()) {
+			s.upstreamCheck = &check
+			s.upstreamChecking = false
+		}
+		return s, nil
+	}
+
 	// Handle text input steps FIRST (before global shortcuts)
 	var cmd tea.Cmd
 	if s.step == stepOrganizationID {
@@ -133,6 +215,12 @@ func (s *SetupWizard) Update(msg tea.Msg) (*SetupWizard, tea.Cmd) {
 		}
 		s.serviceName, cmd = s.serviceName.Update(msg)
 		return s, cmd
+	} else if input := s.focusedInput(); input != nil {
+		// Text fields of the proxy and metrics steps leave ↑↓ for navigation
+		if keyMsg, ok := msg.(tea.KeyMsg); !ok || (keyMsg.String() != "enter" && keyMsg.String() != "up" && keyMsg.String() != "down") {
+			*input, cmd = input.Update(msg)
+			return s, cmd
+		}
 	}
 
 	// Handle global shortcuts for non-text-input steps
@@ -159,6 +247,12 @@ func (s *SetupWizard) Update(msg tea.Msg) (*SetupWizard, tea.Cmd) {
 				if s.scrubCursor > 0 {
 					s.scrubCursor--
 				}
+			} else if s.step == stepProxy {
+				s.proxyField = moveField(s.proxyFields(), s.proxyField, -1)
+				s.focusFields()
+			} else if s.step == stepMetrics {
+				s.metricsField = moveField(s.metricsFields(), s.metricsField, -1)
+				s.focusFields()
 			}
 
 		case "down":
@@ -174,6 +268,21 @@ func (s *SetupWizard) Update(msg tea.Msg) (*SetupWizard, tea.Cmd) {
 				if s.scrubCursor < len(s.scrubOptions)-1 {
 					s.scrubCursor++
 				}
+			} else if s.step == stepProxy {
+				s.proxyField = moveField(s.proxyFields(), s.proxyField, 1)
+				s.focusFields()
+			} else if s.step == stepMetrics {
+				s.metricsField = moveField(s.metricsFields(), s.metricsField, 1)
+				s.focusFields()
+			}
+
+		case "left", "right":
+			if s.step == stepMetrics && s.metricsField == metricsFieldInterval {
+				delta := 1
+				if msg.String() == "left" {
+					delta = -1
+				}
+				s.metricsInterval = (s.metricsInterval + delta + len(metricsIntervals)) % len(metricsIntervals)
 			}
 
 		case " ": // Space to toggle log file selection
@@ -181,6 +290,10 @@ func (s *SetupWizard) Update(msg tea.Msg) (*SetupWizard, tea.Cmd) {
 				s.selectedLogs[s.logCursor] = !s.selectedLogs[s.logCursor]
 			} else if s.step == stepScrubbing {
 				s.scrubSelected[s.scrubCursor] = !s.scrubSelected[s.scrubCursor]
+			} else if s.step == stepProxy && s.proxyField == proxyFieldEnabled {
+				s.proxyEnabled = !s.proxyEnabled
+			} else if s.step == stepMetrics {
+				s.toggleMetricsField()
 			}
 		}
 	}
@@ -229,8 +342,42 @@ func (s *SetupWizard) nextStep() (*SetupWizard, tea.Cmd) {
 		s.step = stepScrubbing
 
 	case stepScrubbing:
+		s.err = nil
+		s.step = stepProxy
+
+	case stepProxy:
+		if !s.proxyEnabled {
+			s.err = nil
+			s.step = stepMetrics
+			s.focusFields()
+			return s, nil
+		}
+		if _, err := parseListenPort(s.proxyPort.Value()); err != nil {
+			s.err = err
+			return s, nil
+		}
+		upstream, err := parseUpstream(s.proxyUpstream.Value())
+		if err != nil {
+			s.err = err
+			return s, nil
+		}
+		s.err = nil
+		s.step = stepMetrics
+		s.focusFields()
+		s.upstreamCheck = nil
+		s.upstreamChecking = true
+		return s, checkUpstream(upstream)
+
+	case stepMetrics:
+		if s.statsdEnabled {
+			if _, _, err := net.SplitHostPort(strings.TrimSpace(s.statsdAddr.Value())); err != nil {
+				s.err = fmt.Errorf("StatsD address must be host:port, e.g. :8125")
+				return s, nil
+			}
+		}
 		s.err = nil
 		s.step = stepReview
+		s.focusFields()
 
 	case stepReview:
 		// Save configuration
@@ -286,18 +433,34 @@ func (s *SetupWizard) saveConfig() error {
 		ServiceName:    s.serviceName.Value(),
 		Environment:    envName,
 		Tags:           tags,
-		APIEndpoint:   "https://yaat.io/api/v1/ingest",
-		BufferSize:    1000,
-		FlushInterval: "10s",
-		Logs:          logs,
+		APIEndpoint:    "https://yaat.io/api/v1/ingest",
+		BufferSize:     1000,
+		FlushInterval:  "10s",
+		Logs:           logs,
 		Scrubbing: config.ScrubbingConfig{
 			Enabled: len(rules) > 0,
 			Rules:   rules,
 		},
 		Proxy: config.ProxyConfig{
-			Enabled: false,
+			Enabled: s.proxyEnabled,
 		},
 	}
+	if s.proxyEnabled {
+		cfg.Proxy.ListenPort, _ = parseListenPort(s.proxyPort.Value())
+		cfg.Proxy.UpstreamURL = strings.TrimSpace(s.proxyUpstream.Value())
+	}
+	if s.metricsEnabled {
+		cfg.Metrics = config.MetricsConfig{
+			Enabled:  true,
+			Interval: metricsIntervals[s.metricsInterval],
+			StatsD: config.StatsDConfig{
+				Enabled: s.statsdEnabled,
+			},
+		}
+		if s.statsdEnabled {
+			cfg.Metrics.StatsD.ListenAddr = strings.TrimSpace(s.statsdAddr.Value())
+		}
+	}
 
 	// Create directory if needed
 	dir := filepath.Dir(s.configPath)
@@ -335,6 +498,10 @@ func (s *SetupWizard) View() string {
 		content = s.renderLogFilesStep()
 	case stepScrubbing:
 		content = s.renderScrubbingStep()
+	case stepProxy:
+		content = s.renderProxyStep()
+	case stepMetrics:
+		content = s.renderMetricsStep()
 	case stepReview:
 		content = s.renderReviewStep()
 	case stepDone:
@@ -353,7 +520,7 @@ func (s *SetupWizard) View() string {
 }
 
 func (s *SetupWizard) renderOrganizationIDStep() string {
-	title := SectionHeaderStyle.Render("Step 1 of 7: Organization ID") + "\n\n"
+	title := SectionHeaderStyle.Render(s.stepTitle("Organization ID")) + "\n\n"
 	desc := MutedStyle.Render("Enter your YAAT organization ID") + "\n"
 	desc += MutedStyle.Render("Get it from: https://yaat.io/settings") + "\n\n"
 
@@ -361,7 +528,7 @@ func (s *SetupWizard) renderOrganizationIDStep() string {
 }
 
 func (s *SetupWizard) renderAPIKeyStep() string {
-	title := SectionHeaderStyle.Render("Step 2 of 7: API Key") + "\n\n"
+	title := SectionHeaderStyle.Render(s.stepTitle("API Key")) + "\n\n"
 	desc := MutedStyle.Render("Enter your YAAT API key") + "\n"
 	desc += MutedStyle.Render("Get it from: https://yaat.io/settings") + "\n\n"
 
@@ -369,14 +536,14 @@ func (s *SetupWizard) renderAPIKeyStep() string {
 }
 
 func (s *SetupWizard) renderServiceNameStep() string {
-	title := SectionHeaderStyle.Render("Step 3 of 7: Service Name") + "\n\n"
+	title := SectionHeaderStyle.Render(s.stepTitle("Service Name")) + "\n\n"
 	desc := MutedStyle.Render("Name for this service") + "\n\n"
 
 	return title + desc + s.serviceName.View() + "\n"
 }
 
 func (s *SetupWizard) renderEnvironmentStep() string {
-	title := SectionHeaderStyle.Render("Step 4 of 7: Environment") + "\n\n"
+	title := SectionHeaderStyle.Render(s.stepTitle("Environment")) + "\n\n"
 	desc := MutedStyle.Render("Select environment type") + "\n\n"
 
 	options := []string{"Production", "Staging", "Development"}
@@ -396,7 +563,7 @@ func (s *SetupWizard) renderEnvironmentStep() string {
 }
 
 func (s *SetupWizard) renderLogFilesStep() string {
-	title := SectionHeaderStyle.Render("Step 5 of 7: Log Files") + "\n\n"
+	title := SectionHeaderStyle.Render(s.stepTitle("Log Files")) + "\n\n"
 	desc := MutedStyle.Render(fmt.Sprintf("Found %d log files - select which to monitor", len(s.detectedLogs))) + "\n"
 	if len(s.detectedLogs) > 0 {
 		desc += MutedStyle.Render("Readable sources are pre-selected for you. Press space to adjust.") + "\n\n"
@@ -441,7 +608,7 @@ func (s *SetupWizard) renderLogFilesStep() string {
 }
 
 func (s *SetupWizard) renderScrubbingStep() string {
-	title := SectionHeaderStyle.Render("Step 6 of 7: Data Scrubbing") + "\n\n"
+	title := SectionHeaderStyle.Render(s.stepTitle("Data Scrubbing")) + "\n\n"
 	desc := MutedStyle.Render("Select scrubbing rules to prevent sensitive data from leaving this machine") + "\n\n"
 
 	if len(s.scrubOptions) == 0 {
@@ -471,8 +638,49 @@ func (s *SetupWizard) renderScrubbingStep() string {
 	return title + desc + help + "\n"
 }
 
+func (s *SetupWizard) renderProxyStep() string {
+	title := SectionHeaderStyle.Render(s.stepTitle("HTTP Proxy")) + "\n\n"
+	desc := MutedStyle.Render("Put the sidecar in front of your app to record a span for every request") + "\n\n"
+
+	desc += fieldCursor(s.proxyField == proxyFieldEnabled) + checkbox(s.proxyEnabled) + " " + ValueStyle.Render("Enable HTTP proxy") + "\n"
+	if s.proxyEnabled {
+		desc += fieldCursor(s.proxyField == proxyFieldPort) + LabelStyle.Render("Listen port:  ") + s.proxyPort.View() + "\n"
+		desc += fieldCursor(s.proxyField == proxyFieldUpstream) + LabelStyle.Render("Upstream URL: ") + s.proxyUpstream.View() + "\n"
+		desc += "\n" + MutedStyle.Render("Point clients at the listen port; requests are forwarded to the upstream URL") + "\n"
+	}
+
+	help := MutedStyle.Render("\n[↑↓] Navigate  [Space] Toggle  [Enter] Continue")
+	return title + desc + help + "\n"
+}
+
+func (s *SetupWizard) renderMetricsStep() string {
+	title := SectionHeaderStyle.Render(s.stepTitle("Metrics")) + "\n\n"
+	desc := MutedStyle.Render("Collect host CPU, memory, disk and network metrics, and accept StatsD metrics from your app") + "\n\n"
+
+	desc += fieldCursor(s.metricsField == metricsFieldEnabled) + checkbox(s.metricsEnabled) + " " + ValueStyle.Render("Enable host metrics") + "\n"
+	if s.metricsEnabled {
+		desc += fieldCursor(s.metricsField == metricsFieldInterval) + LabelStyle.Render("Interval: ")
+		for i, interval := range metricsIntervals {
+			if i == s.metricsInterval {
+				desc += SuccessStyle.Render("["+interval+"]") + " "
+			} else {
+				desc += MutedStyle.Render(" "+interval+" ") + " "
+			}
+		}
+		desc += "\n"
+	}
+	desc += fieldCursor(s.metricsField == metricsFieldStatsD) + checkbox(s.statsdEnabled) + " " + ValueStyle.Render("Enable StatsD listener") + "\n"
+	if s.statsdEnabled {
+		desc += fieldCursor(s.metricsField == metricsFieldStatsDAddr) + LabelStyle.Render("Address: ") + s.statsdAddr.View() + "\n"
+	}
+	desc += "\n" + MutedStyle.Render("The StatsD listener runs with the metrics collector, so enabling it also enables host metrics") + "\n"
+
+	help := MutedStyle.Render("\n[↑↓] Navigate  [Space] Toggle  [←→] Interval  [Enter] Continue")
+	return title + desc + help + "\n"
+}
+
 func (s *SetupWizard) renderReviewStep() string {
-	title := SectionHeaderStyle.Render("Step 7 of 7: Review") + "\n\n"
+	title := SectionHeaderStyle.Render(s.stepTitle("Review")) + "\n\n"
 
 	content := LabelStyle.Render("Organization:  ") + ValueStyle.Render(s.organizationID.Value()) + "\n"
 	content += LabelStyle.Render("Service Name:  ") + ValueStyle.Render(s.serviceName.Value()) + "\n"
@@ -499,6 +707,30 @@ func (s *SetupWizard) renderReviewStep() string {
 		content += LabelStyle.Render("Scrubbing:     ") + WarningStyle.Render("disabled") + "\n"
 	}
 
+	if s.proxyEnabled {
+		content += LabelStyle.Render("HTTP Proxy:    ") + ValueStyle.Render(fmt.Sprintf(":%s → %s", strings.TrimSpace(s.proxyPort.Value()), strings.TrimSpace(s.proxyUpstream.Value()))) + "\n"
+		switch {
+		case s.upstreamChecking:
+			content += "               " + MutedStyle.Render("checking upstream...") + "\n"
+		case s.upstreamCheck != nil && s.upstreamCheck.ok:
+			content += "               " + SuccessStyle.Render("upstream "+s.upstreamCheck.result) + "\n"
+		case s.upstreamCheck != nil:
+			content += "               " + WarningStyle.Render("upstream "+s.upstreamCheck.result) + "\n"
+		}
+	} else {
+		content += LabelStyle.Render("HTTP Proxy:    ") + MutedStyle.Render("disabled") + "\n"
+	}
+	if s.metricsEnabled {
+		content += LabelStyle.Render("Host Metrics:  ") + ValueStyle.Render("every "+metricsIntervals[s.metricsInterval]) + "\n"
+	} else {
+		content += LabelStyle.Render("Host Metrics:  ") + MutedStyle.Render("disabled") + "\n"
+	}
+	if s.statsdEnabled {
+		content += LabelStyle.Render("StatsD:        ") + ValueStyle.Render("listening on "+strings.TrimSpace(s.statsdAddr.Value())) + "\n"
+	} else {
+		content += LabelStyle.Render("StatsD:        ") + MutedStyle.Render("disabled") + "\n"
+	}
+
 	content += "\n" + MutedStyle.Render("Configuration will be saved to:") + "\n"
 	content += MutedStyle.Render(s.configPath) + "\n"
 
@@ -518,6 +750,158 @@ func (s *SetupWizard) renderDoneStep() string {
 	return title + content + "\n"
 }
 
+// steps lists the steps the wizard shows, in order; the log file step is
+// skipped when no log files were detected.
+func (s *SetupWizard) steps() []setupStep {
+	steps := []setupStep{stepOrganizationID, stepAPIKey, stepServiceName, stepEnvironment}
+	if len(s.detectedLogs) > 0 {
+		steps = append(steps, stepLogFiles)
+	}
+	return append(steps, stepScrubbing, stepProxy, stepMetrics, stepReview)
+}
+
+// stepTitle renders "Step X of N: name" for the current step.
+func (s *SetupWizard) stepTitle(name string) string {
+	steps := s.steps()
+	for i, step := range steps {
+		if step == s.step {
+			return fmt.Sprintf("Step %d of %d: %s", i+1, len(steps), name)
+		}
+	}
+	return name
+}
+
+// capturesKeys reports whether a text input has focus, so the dashboard
+// passes keys through instead of treating them as shortcuts.
+func (s *SetupWizard) capturesKeys() bool {
+	switch s.step {
+	case stepOrganizationID, stepAPIKey, stepServiceName:
+		return true
+	}
+	return s.focusedInput() != nil
+}
+
+// focusedInput returns the text input under the cursor on the proxy or
+// metrics step, or nil when the cursor is on a toggle.
+func (s *SetupWizard) focusedInput() *textinput.Model {
+	switch {
+	case s.step == stepProxy && s.proxyField == proxyFieldPort:
+		return &s.proxyPort
+	case s.step == stepProxy && s.proxyField == proxyFieldUpstream:
+		return &s.proxyUpstream
+	case s.step == stepMetrics && s.metricsField == metricsFieldStatsDAddr:
+		return &s.statsdAddr
+	}
+	return nil
+}
+
+// focusFields moves text input focus to the field under the cursor.
+func (s *SetupWizard) focusFields() {
+	s.proxyPort.Blur()
+	s.proxyUpstream.Blur()
+	s.statsdAddr.Blur()
+	if input := s.focusedInput(); input != nil {
+		input.Focus()
+	}
+}
+
+func (s *SetupWizard) proxyFields() []int {
+	if !s.proxyEnabled {
+		return []int{proxyFieldEnabled}
+	}
+	return []int{proxyFieldEnabled, proxyFieldPort, proxyFieldUpstream}
+}
+
+func (s *SetupWizard) metricsFields() []int {
+	fields := []int{metricsFieldEnabled}
+	if s.metricsEnabled {
+		fields = append(fields, metricsFieldInterval)
+	}
+	fields = append(fields, metricsFieldStatsD)
+	if s.statsdEnabled {
+		fields = append(fields, metricsFieldStatsDAddr)
+	}
+	return fields
+}
+
+// toggleMetricsField flips the toggle under the cursor. StatsD only runs
+// with host metrics enabled, so the two are switched together.
+func (s *SetupWizard) toggleMetricsField() {
+	switch s.metricsField {
+	case metricsFieldEnabled:
+		s.metricsEnabled = !s.metricsEnabled
+		if !s.metricsEnabled {
+			s.statsdEnabled = false
+		}
+	case metricsFieldInterval:
+		s.metricsInterval = (s.metricsInterval + 1) % len(metricsIntervals)
+	case metricsFieldStatsD:
+		s.statsdEnabled = !s.statsdEnabled
+		if s.statsdEnabled {
+			s.metricsEnabled = true
+		}
+	}
+}
+
+// moveField returns the field delta places from cursor in fields, staying
+// on the first or last field at either end.
+func moveField(fields []int, cursor, delta int) int {
+	for i, field := range fields {
+		if field == cursor {
+			if next := i + delta; next >= 0 && next < len(fields) {
+				return fields[next]
+			}
+			return cursor
+		}
+	}
+	return fields[0]
+}
+
+func fieldCursor(active bool) string {
+	if active {
+		return "▸ "
+	}
+	return "  "
+}
+
+func checkbox(checked bool) string {
+	if checked {
+		return "[✓]"
+	}
+	return "[ ]"
+}
+
+func parseListenPort(value string) (int, error) {
+	port, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("Listen port must be a number between 1 and 65535")
+	}
+	return port, nil
+}
+
+func parseUpstream(value string) (string, error) {
+	upstream := strings.TrimSpace(value)
+	u, err := url.Parse(upstream)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("Upstream URL must be an http:// or https:// URL, e.g. http://127.0.0.1:8000")
+	}
+	return upstream, nil
+}
+
+// checkUpstream requests the upstream once in the background. Any HTTP
+// response counts: the proxy only needs something listening there.
+func checkUpstream(upstream string) tea.Cmd {
+	return func() tea.Msg {
+		client := &http.Client{Timeout: upstreamCheckTimeout}
+		resp, err := client.Get(upstream)
+		if err != nil {
+			return upstreamCheckMsg{url: upstream, result: fmt.Sprintf("not responding: %v", err)}
+		}
+		resp.Body.Close()
+		return upstreamCheckMsg{url: upstream, ok: true, result: "responded " + resp.Status}
+	}
+}
+
 func maskKey(key string) string {
 	if len(key) < 10 {
 		return "***"
@@ -0,0 +1,128 @@
+package tui
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/yaat-app/sidecar/internal/config"
+	"github.com/yaat-app/sidecar/internal/detection"
+)
+
+func newTestWizard(t *testing.T, logFiles []detection.LogFile) *SetupWizard {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	s := newSetupWizard(logFiles, nil, &detection.KubernetesMetadata{})
+	s.configPath = filepath.Join(t.TempDir(), "yaat.yaml")
+	s.organizationID.SetValue("org_test")
+	s.apiKey.SetValue("yaat_secret")
+	s.serviceName.SetValue("svc")
+	return s
+}
+
+func press(s *SetupWizard, keys ...string) tea.Cmd {
+	var cmd tea.Cmd
+	for _, key := range keys {
+		var msg tea.KeyMsg
+		switch key {
+		case "enter":
+			msg = tea.KeyMsg{Type: tea.KeyEnter}
+		case "up":
+			msg = tea.KeyMsg{Type: tea.KeyUp}
+		case "down":
+			msg = tea.KeyMsg{Type: tea.KeyDown}
+		case " ":
+			msg = tea.KeyMsg{Type: tea.KeySpace, Runes: []rune{' '}}
+		default:
+			msg = tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(key)}
+		}
+		_, cmd = s.Update(msg)
+	}
+	return cmd
+}
+
+func TestSetupWizardStepCount(t *testing.T) {
+	s := newTestWizard(t, nil)
+	if title := s.stepTitle("Organization ID"); title != "Step 1 of 8: Organization ID" {
+		t.Errorf("Expected 8 steps without log files, got %q", title)
+	}
+	s.step = stepReview
+	if title := s.stepTitle("Review"); title != "Step 8 of 8: Review" {
+		t.Errorf("Expected review to be the last step, got %q", title)
+	}
+
+	s = newTestWizard(t, []detection.LogFile{{Path: "/var/log/app.log", SuggestedFormat: "json", Readable: true}})
+	s.step = stepProxy
+	if title := s.stepTitle("HTTP Proxy"); title != "Step 7 of 9: HTTP Proxy" {
+		t.Errorf("Expected 9 steps with log files, got %q", title)
+	}
+}
+
+func TestSetupWizardProxyAndMetrics(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	s := newTestWizard(t, nil)
+	s.step = stepProxy
+	press(s, " ", "down", "down")
+	s.proxyUpstream.SetValue("ftp://example.com")
+	press(s, "enter")
+	if s.err == nil || s.step != stepProxy {
+		t.Fatalf("Expected a non-http upstream to be rejected, got step %v err %v", s.step, s.err)
+	}
+
+	s.proxyUpstream.SetValue(upstream.URL)
+	cmd := press(s, "enter")
+	if s.err != nil || s.step != stepMetrics {
+		t.Fatalf("Expected to reach the metrics step, got step %v err %v", s.step, s.err)
+	}
+	if cmd == nil {
+		t.Fatal("Expected an upstream check command")
+	}
+	s.Update(cmd())
+	if s.upstreamCheck == nil || !s.upstreamCheck.ok {
+		t.Errorf("Expected the upstream to respond, got %+v", s.upstreamCheck)
+	}
+
+	// Enabling StatsD switches host metrics on with it
+	press(s, "down", " ", "down")
+	if !s.statsdEnabled || !s.metricsEnabled {
+		t.Fatalf("Expected StatsD and host metrics enabled, got statsd %v metrics %v", s.statsdEnabled, s.metricsEnabled)
+	}
+	if !s.capturesKeys() {
+		t.Fatal("Expected the StatsD address field to capture keys")
+	}
+	s.statsdAddr.SetValue("127.0.0.1:9125")
+	press(s, "up", "up", " ", "enter")
+	if s.step != stepReview {
+		t.Fatalf("Expected the review step, got %v (err %v)", s.step, s.err)
+	}
+	review := s.View()
+	for _, want := range []string{"HTTP Proxy:", upstream.URL, "every 60s", "127.0.0.1:9125"} {
+		if !strings.Contains(review, want) {
+			t.Errorf("Expected review to contain %q", want)
+		}
+	}
+
+	press(s, "enter")
+	if !s.IsDone() {
+		t.Fatalf("Expected setup to finish, got err %v", s.err)
+	}
+	cfg, err := config.LoadConfig(s.configPath)
+	if err != nil {
+		t.Fatalf("Saved config does not load: %v", err)
+	}
+	if !cfg.Proxy.Enabled || cfg.Proxy.ListenPort != 19000 || cfg.Proxy.UpstreamURL != upstream.URL {
+		t.Errorf("Expected proxy on 19000 to %s, got %+v", upstream.URL, cfg.Proxy)
+	}
+	if !cfg.Metrics.Enabled || cfg.Metrics.Interval != "60s" {
+		t.Errorf("Expected host metrics every 60s, got enabled %v interval %q", cfg.Metrics.Enabled, cfg.Metrics.Interval)
+	}
+	if !cfg.Metrics.StatsD.Enabled || cfg.Metrics.StatsD.ListenAddr != "127.0.0.1:9125" {
+		t.Errorf("Expected StatsD on 127.0.0.1:9125, got %+v", cfg.Metrics.StatsD)
+	}
+}
@@ -1,7 +1,10 @@
 package tui
 
 import (
+	"strings"
+
 	"github.com/charmbracelet/lipgloss"
+	"github.com/yaat-app/sidecar/internal/diag"
 )
 
 var (
@@ -99,6 +102,21 @@ func StatusBadge(status string, running bool) string {
 	return style.Render(symbol) + " " + style.Render(status)
 }
 
+// ErrorCategoryBadge labels a delivery error category. Categories that need
+// someone to act (auth, payload, client) are red; those that usually pass
+// on their own (network, server, rate_limited) are amber.
+func ErrorCategoryBadge(category string) string {
+	if category == "" {
+		return ""
+	}
+	style := WarningStyle.Bold(true)
+	switch category {
+	case diag.ErrorAuth, diag.ErrorPayload, diag.ErrorClient:
+		style = StatusErrorStyle
+	}
+	return style.Render("[" + strings.ToUpper(category) + "]")
+}
+
 // MetricRow formats a metric row with label and value
 func MetricRow(label, value string, highlight bool) string {
 	labelText := LabelStyle.Render(padRight(label, 20))
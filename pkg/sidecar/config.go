@@ -0,0 +1,126 @@
+package sidecar
+
+import (
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/config"
+	"github.com/yaat-app/sidecar/internal/queue"
+)
+
+// Defaults used when Config leaves a field at zero.
+const (
+	DefaultAPIEndpoint   = "https://yaat.io/api/v1/ingest"
+	DefaultBufferSize    = 1000
+	DefaultFlushInterval = 10 * time.Second
+)
+
+// Config is the subset of the sidecar's configuration an Emitter needs.
+type Config struct {
+	APIEndpoint    string
+	APIKey         string
+	OrganizationID string
+	ServiceName    string // Required
+	Environment    string // "production" when empty
+	Tags           map[string]string
+
+	BufferSize    int
+	FlushInterval time.Duration
+	BatchSize     int // Events per request; 0 uses the forwarder's default
+	Compress      bool
+
+	// QueueDir persists batches that fail to send so they are retried, across
+	// restarts too. Empty drops them once the forwarder's retries run out.
+	QueueDir string
+	// QueueEncryptionKey, 32 bytes, encrypts the batches in QueueDir with
+	// AES-GCM. Batches written without it stay readable.
+	QueueEncryptionKey []byte
+
+	Scrubbing ScrubbingConfig
+}
+
+// ScrubbingConfig mirrors the scrubbing section of yaat.yaml.
+type ScrubbingConfig struct {
+	Enabled      bool
+	Rules        []ScrubRule
+	TagAllowlist []string // When set, every other tag is removed; "http.*" allows a prefix
+
+	// RuleBudget is the longest one rule may take on one event before it is
+	// disabled (0 uses 100ms)
+	RuleBudget time.Duration
+}
+
+// ScrubRule replaces, or drops events on, matches of Pattern in Fields.
+type ScrubRule struct {
+	Name        string
+	Pattern     string
+	Replacement string
+	Fields      []string // message, tags.user_id, tags.*, or * for every top-level string; empty is message and stacktrace
+	Drop        bool
+}
+
+// LoadConfig reads a yaat.yaml the way the sidecar does, defaults and
+// validation included, and keeps the fields Config covers.
+func LoadConfig(path string) (Config, error) {
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		return Config{}, err
+	}
+	var key []byte
+	if cfg.Delivery.QueueEncryptionKeyFile != "" {
+		if key, err = queue.LoadKeyFile(cfg.Delivery.QueueEncryptionKeyFile); err != nil {
+			return Config{}, err
+		}
+	}
+	return Config{
+		APIEndpoint:    cfg.APIEndpoint,
+		APIKey:         cfg.APIKey,
+		OrganizationID: cfg.OrganizationID,
+		ServiceName:    cfg.ServiceName,
+		Environment:    cfg.Environment,
+		Tags:           cfg.Tags,
+		BufferSize:     cfg.BufferSize,
+		FlushInterval:  cfg.FlushIntervalDuration,
+		BatchSize:      cfg.Delivery.BatchSize,
+		Compress:       cfg.Delivery.Compress,
+		Scrubbing:      scrubbingFromConfig(cfg.Scrubbing),
+
+		QueueEncryptionKey: key,
+	}, nil
+}
+
+func scrubbingFromConfig(cfg config.ScrubbingConfig) ScrubbingConfig {
+	out := ScrubbingConfig{
+		Enabled:      cfg.Enabled,
+		TagAllowlist: cfg.TagAllowlist,
+		RuleBudget:   cfg.RuleBudgetDuration,
+	}
+	for _, rule := range cfg.Rules {
+		out.Rules = append(out.Rules, ScrubRule{
+			Name:        rule.Name,
+			Pattern:     rule.Pattern,
+			Replacement: rule.Replacement,
+			Fields:      rule.Fields,
+			Drop:        rule.Drop,
+		})
+	}
+	return out
+}
+
+// internal converts c to the form the scrubber compiles.
+func (c ScrubbingConfig) internal() config.ScrubbingConfig {
+	out := config.ScrubbingConfig{
+		Enabled:            c.Enabled,
+		TagAllowlist:       c.TagAllowlist,
+		RuleBudgetDuration: c.RuleBudget,
+	}
+	for _, rule := range c.Rules {
+		out.Rules = append(out.Rules, config.ScrubRule{
+			Name:        rule.Name,
+			Pattern:     rule.Pattern,
+			Replacement: rule.Replacement,
+			Fields:      rule.Fields,
+			Drop:        rule.Drop,
+		})
+	}
+	return out
+}
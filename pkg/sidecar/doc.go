@@ -0,0 +1,13 @@
+// Package sidecar embeds the YAAT sidecar's delivery pipeline in a Go
+// program, for agents that would rather link it than run the binary beside
+// them.
+//
+// An Emitter takes typed Log, Span and Metric values, scrubs them with the
+// same rules the sidecar applies, buffers them and delivers them to the YAAT
+// API with the sidecar's retries and persistent queue. The yaat-sidecar
+// binary is built on the same Emitter.
+//
+// The exported API of this package follows semantic versioning: fields and
+// methods are only added, never changed or removed, within a major version.
+// Packages under internal/ carry no such promise.
+package sidecar
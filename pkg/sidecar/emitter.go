@@ -0,0 +1,197 @@
+package sidecar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+	"github.com/yaat-app/sidecar/internal/embedding"
+	"github.com/yaat-app/sidecar/internal/forwarder"
+	"github.com/yaat-app/sidecar/internal/logging"
+	"github.com/yaat-app/sidecar/internal/pipeline"
+	"github.com/yaat-app/sidecar/internal/queue"
+	"github.com/yaat-app/sidecar/internal/scrubber"
+)
+
+var logger = logging.New("Sidecar")
+
+// ErrClosed is returned by an Emitter after Close.
+var ErrClosed = errors.New("sidecar: emitter is closed")
+
+// Emitter buffers events and delivers them in the background on every
+// Config.FlushInterval, and early whenever Config.BufferSize events are
+// waiting. Batches that fail to send are retried, then persisted to
+// Config.QueueDir and retried from there. An Emitter is safe for concurrent
+// use.
+type Emitter struct {
+	cfg      Config
+	scrubber *scrubber.Scrubber
+	buf      *buffer.Buffer
+	pipe     *pipeline.Pipeline
+	now      func() time.Time
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// Option adjusts an Emitter. There are no public options yet; the
+// yaat-sidecar binary uses one to wire in its own inputs and outputs.
+type Option func(*embedding.Options)
+
+// New validates cfg, compiles its scrubbing rules and starts delivery. A
+// queue directory that cannot be opened is logged and delivery goes on
+// without it, as in the sidecar.
+func New(cfg Config, opts ...Option) (*Emitter, error) {
+	var o embedding.Options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if strings.TrimSpace(cfg.ServiceName) == "" {
+		return nil, fmt.Errorf("sidecar: ServiceName is required")
+	}
+	// The binary runs local-only without a key; an embedder has nowhere else to deliver
+	if cfg.APIKey == "" && o.Hooks == nil {
+		return nil, fmt.Errorf("sidecar: APIKey is required")
+	}
+	if cfg.APIEndpoint == "" {
+		cfg.APIEndpoint = DefaultAPIEndpoint
+	}
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = DefaultBufferSize
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = DefaultFlushInterval
+	}
+
+	scrub, err := scrubber.New(cfg.Scrubbing.internal())
+	if err != nil {
+		return nil, fmt.Errorf("sidecar: %w", err)
+	}
+
+	hooks := embedding.Hooks{HighWatermark: cfg.BufferSize}
+	if o.Hooks != nil {
+		hooks = *o.Hooks
+	}
+
+	buf := buffer.New(cfg.BufferSize)
+	buf.SetHighWatermark(hooks.HighWatermark)
+	if hooks.Filter != nil {
+		buf.SetFilter(hooks.Filter)
+	}
+
+	var store *queue.Storage
+	if cfg.QueueDir != "" {
+		if store, err = queue.New(cfg.QueueDir); err != nil {
+			logger.Warnf("Failed to initialize persistent queue: %v", err)
+			store = nil
+		} else if cfg.QueueEncryptionKey != nil {
+			if err := store.SetEncryptionKey(cfg.QueueEncryptionKey); err != nil {
+				return nil, fmt.Errorf("sidecar: %w", err)
+			}
+		}
+	}
+
+	// The forwarder pins global tags after scrubbing; pin only what scrubbing keeps
+	fwdOpts := forwarder.Options{BatchSize: cfg.BatchSize, Compress: cfg.Compress, GlobalTags: scrub.Tags(cfg.Tags)}
+	if hooks.Forwarder != nil {
+		fwdOpts = *hooks.Forwarder
+	}
+	fwd := forwarder.NewWithOptions(cfg.APIEndpoint, cfg.APIKey, fwdOpts)
+
+	pipe := pipeline.New(pipeline.Options{
+		Buffer:              buf,
+		Forwarder:           fwd,
+		Queue:               store,
+		Analytics:           hooks.Analytics,
+		Failures:            hooks.Failures,
+		OTLP:                hooks.OTLP,
+		APIKey:              cfg.APIKey,
+		FlushInterval:       cfg.FlushInterval,
+		QueueRetention:      hooks.QueueRetention,
+		DeadLetterRetention: hooks.DeadLetterRetention,
+
+		DeadLetterMaxRetries:    hooks.DeadLetterMaxRetries,
+		DeadLetterRetryInterval: hooks.DeadLetterRetryInterval,
+	})
+	if hooks.Built != nil {
+		if err := hooks.Built(embedding.Parts{Buffer: buf, Forwarder: fwd, Queue: store, Pipeline: pipe}); err != nil {
+			return nil, err
+		}
+	}
+	pipe.Start(context.Background())
+
+	return &Emitter{
+		cfg:      cfg,
+		scrubber: scrub,
+		buf:      buf,
+		pipe:     pipe,
+		now:      time.Now,
+	}, nil
+}
+
+// Log buffers a log line.
+func (e *Emitter) Log(l Log) error {
+	return e.emit(l.event())
+}
+
+// Span buffers a span.
+func (e *Emitter) Span(s Span) error {
+	return e.emit(s.event())
+}
+
+// Metric buffers a measurement.
+func (e *Emitter) Metric(m Metric) error {
+	return e.emit(m.event())
+}
+
+// emit fills in the emitter's identity and tags, then scrubs and buffers
+// evt. Events a scrubbing rule drops are discarded without an error.
+func (e *Emitter) emit(evt buffer.Event) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.closed {
+		return ErrClosed
+	}
+
+	evt["organization_id"] = e.cfg.OrganizationID
+	evt["service_name"] = e.cfg.ServiceName
+	evt["environment"] = e.cfg.Environment
+	tags := evt["tags"].(map[string]string)
+	for k, v := range e.cfg.Tags {
+		if _, exists := tags[k]; !exists {
+			tags[k] = v
+		}
+	}
+	if err := forwarder.NormalizeEvent(evt, e.now()); err != nil {
+		return fmt.Errorf("sidecar: %w", err)
+	}
+	if !e.scrubber.Apply(evt) {
+		return nil
+	}
+	e.buf.Add(evt)
+	return nil
+}
+
+// Flush delivers the buffered events and retries queued batches now rather
+// than on the next interval.
+func (e *Emitter) Flush(ctx context.Context) error {
+	result := e.pipe.FlushNow(ctx)
+	if result.Error != "" {
+		return errors.New("sidecar: " + result.Error)
+	}
+	return nil
+}
+
+// Close stops background delivery and sends what is left, persisting it to
+// the queue when ctx ends first. Events passed after Close return ErrClosed.
+func (e *Emitter) Close(ctx context.Context) error {
+	e.mu.Lock()
+	e.closed = true
+	e.mu.Unlock()
+	return e.pipe.Drain(ctx)
+}
@@ -0,0 +1,220 @@
+package sidecar
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAPI is an ingest endpoint that records the events it receives.
+type fakeAPI struct {
+	*httptest.Server
+	mu     sync.Mutex
+	events []map[string]any
+	status int
+}
+
+func newFakeAPI(t *testing.T) *fakeAPI {
+	t.Helper()
+	// Delivery incidents are recorded in ~/.yaat/state.json
+	t.Setenv("HOME", t.TempDir())
+
+	api := &fakeAPI{status: http.StatusOK}
+	api.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Events []map[string]any `json:"events"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		api.mu.Lock()
+		defer api.mu.Unlock()
+		if api.status != http.StatusOK {
+			w.WriteHeader(api.status)
+			return
+		}
+		api.events = append(api.events, payload.Events...)
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	t.Cleanup(api.Close)
+	return api
+}
+
+func (a *fakeAPI) received() []map[string]any {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]map[string]any(nil), a.events...)
+}
+
+func (a *fakeAPI) setStatus(status int) {
+	a.mu.Lock()
+	a.status = status
+	a.mu.Unlock()
+}
+
+func TestEmitterDeliversTypedEvents(t *testing.T) {
+	api := newFakeAPI(t)
+	emitter, err := New(Config{
+		APIEndpoint:   api.URL,
+		APIKey:        "test-key",
+		ServiceName:   "agent",
+		Environment:   "staging",
+		Tags:          map[string]string{"host": "web-1", "team": "core"},
+		FlushInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer emitter.Close(context.Background())
+
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := emitter.Log(Log{Level: "error", Message: "disk full", Tags: map[string]string{"team": "infra"}}); err != nil {
+		t.Fatalf("Log failed: %v", err)
+	}
+	if err := emitter.Span(Span{Timestamp: start, TraceID: "t1", SpanID: "s1", Operation: "GET /", Duration: 1500 * time.Microsecond, StatusCode: 200}); err != nil {
+		t.Fatalf("Span failed: %v", err)
+	}
+	if err := emitter.Metric(Metric{Name: "queue.depth", Value: 12}); err != nil {
+		t.Fatalf("Metric failed: %v", err)
+	}
+	if err := emitter.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	events := api.received()
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 events, got %d", len(events))
+	}
+	log, span, metric := events[0], events[1], events[2]
+	if log["event_type"] != "log" || log["message"] != "disk full" || log["environment"] != "staging" {
+		t.Errorf("Expected the log with the emitter's environment, got %v", log)
+	}
+	tags, _ := log["tags"].(map[string]any)
+	if tags["team"] != "infra" || tags["host"] != "web-1" {
+		t.Errorf("Expected event tags over config tags, got %v", tags)
+	}
+	if span["duration_ms"] != 1.5 || span["timestamp"] != "2026-01-02T03:04:05Z" || span["operation"] != "GET /" {
+		t.Errorf("Expected the span's duration and start, got %v", span)
+	}
+	if metric["metric_name"] != "queue.depth" || metric["metric_value"] != 12.0 {
+		t.Errorf("Expected the metric, got %v", metric)
+	}
+}
+
+func TestEmitterScrubs(t *testing.T) {
+	api := newFakeAPI(t)
+	emitter, err := New(Config{
+		APIEndpoint:   api.URL,
+		APIKey:        "test-key",
+		ServiceName:   "agent",
+		FlushInterval: time.Hour,
+		Scrubbing: ScrubbingConfig{
+			Enabled: true,
+			Rules: []ScrubRule{
+				{Name: "email", Pattern: `[a-z]+@example\.com`, Replacement: "[EMAIL]"},
+				{Name: "health", Pattern: "^healthcheck", Drop: true},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer emitter.Close(context.Background())
+	emitter.Log(Log{Message: "login by jane@example.com"})
+	emitter.Log(Log{Message: "healthcheck ok"})
+	if err := emitter.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	events := api.received()
+	if len(events) != 1 || events[0]["message"] != "login by [EMAIL]" {
+		t.Errorf("Expected one scrubbed event, got %v", events)
+	}
+}
+
+func TestEmitterTagAllowlistCoversConfigTags(t *testing.T) {
+	api := newFakeAPI(t)
+	emitter, err := New(Config{
+		APIEndpoint:   api.URL,
+		APIKey:        "test-key",
+		ServiceName:   "agent",
+		FlushInterval: time.Hour,
+		Tags:          map[string]string{"region": "eu", "owner": "jane@example.com"},
+		Scrubbing: ScrubbingConfig{
+			Enabled:      true,
+			TagAllowlist: []string{"region"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer emitter.Close(context.Background())
+	emitter.Log(Log{Message: "hello"})
+	if err := emitter.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	events := api.received()
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(events))
+	}
+	tags, _ := events[0]["tags"].(map[string]any)
+	if tags["region"] != "eu" {
+		t.Errorf("Expected allowlisted tag region=eu, got %v", tags)
+	}
+	if _, ok := tags["owner"]; ok {
+		t.Errorf("Expected tag owner to be removed by the allowlist, got %v", tags)
+	}
+}
+
+func TestEmitterQueuesFailedBatches(t *testing.T) {
+	api := newFakeAPI(t)
+	// Not retried, so the failure is immediate
+	api.setStatus(http.StatusUnauthorized)
+	emitter, err := New(Config{
+		APIEndpoint:   api.URL,
+		APIKey:        "test-key",
+		ServiceName:   "agent",
+		FlushInterval: time.Hour,
+		QueueDir:      t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	emitter.Log(Log{Message: "kept"})
+	if err := emitter.Flush(context.Background()); err == nil {
+		t.Fatal("Expected the flush to report the failed send")
+	}
+
+	api.setStatus(http.StatusOK)
+	if err := emitter.Close(context.Background()); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if events := api.received(); len(events) != 1 || events[0]["message"] != "kept" {
+		t.Errorf("Expected the queued event delivered on Close, got %v", events)
+	}
+	if err := emitter.Log(Log{Message: "late"}); err != ErrClosed {
+		t.Errorf("Expected ErrClosed after Close, got %v", err)
+	}
+}
+
+func TestNewValidates(t *testing.T) {
+	if _, err := New(Config{APIKey: "test-key"}); err == nil {
+		t.Error("Expected an error without ServiceName")
+	}
+	if _, err := New(Config{ServiceName: "agent"}); err == nil {
+		t.Error("Expected an error without APIKey")
+	}
+	_, err := New(Config{APIKey: "test-key", ServiceName: "agent", Scrubbing: ScrubbingConfig{
+		Enabled: true,
+		Rules:   []ScrubRule{{Name: "bad", Pattern: `(\w+)\s\1`}},
+	}})
+	if err == nil {
+		t.Error("Expected an error for an invalid scrubbing pattern")
+	}
+}
@@ -0,0 +1,97 @@
+package sidecar
+
+import (
+	"time"
+
+	"github.com/yaat-app/sidecar/internal/buffer"
+)
+
+// Log is one log line.
+type Log struct {
+	Timestamp  time.Time // The emit time when zero
+	Level      string    // debug, info, warning, error or critical
+	Message    string
+	Logger     string // Name of the logger that wrote the line, if any
+	Stacktrace string
+	Tags       map[string]string // Take priority over Config.Tags
+}
+
+// Span is one timed operation of a trace.
+type Span struct {
+	Timestamp    time.Time // Start of the operation; the emit time when zero
+	TraceID      string
+	SpanID       string
+	ParentSpanID string
+	Operation    string // e.g. "GET /orders"
+	Duration     time.Duration
+	StatusCode   int // HTTP status, 0 when not applicable
+	Tags         map[string]string
+}
+
+// Metric is one measurement.
+type Metric struct {
+	Timestamp time.Time // The emit time when zero
+	Name      string
+	Value     float64
+	Tags      map[string]string
+}
+
+func (l Log) event() buffer.Event {
+	evt := buffer.Event{
+		"event_type": "log",
+		"level":      l.Level,
+		"message":    l.Message,
+		"tags":       copyTags(l.Tags),
+	}
+	if l.Logger != "" {
+		evt["logger"] = l.Logger
+	}
+	if l.Stacktrace != "" {
+		evt["stacktrace"] = l.Stacktrace
+	}
+	setTimestamp(evt, l.Timestamp)
+	return evt
+}
+
+func (s Span) event() buffer.Event {
+	evt := buffer.Event{
+		"event_type":     "span",
+		"trace_id":       s.TraceID,
+		"span_id":        s.SpanID,
+		"parent_span_id": s.ParentSpanID,
+		"operation":      s.Operation,
+		"duration_ms":    float64(s.Duration) / float64(time.Millisecond),
+		"tags":           copyTags(s.Tags),
+	}
+	if s.StatusCode != 0 {
+		evt["status_code"] = s.StatusCode
+	}
+	setTimestamp(evt, s.Timestamp)
+	return evt
+}
+
+func (m Metric) event() buffer.Event {
+	evt := buffer.Event{
+		"event_type":   "metric",
+		"metric_name":  m.Name,
+		"metric_value": m.Value,
+		"tags":         copyTags(m.Tags),
+	}
+	setTimestamp(evt, m.Timestamp)
+	return evt
+}
+
+func setTimestamp(evt buffer.Event, ts time.Time) {
+	if !ts.IsZero() {
+		evt["timestamp"] = ts.UTC().Format(time.RFC3339Nano)
+	}
+}
+
+// copyTags copies tags so scrubbing never edits the caller's map.
+func copyTags(tags map[string]string) map[string]string {
+	out := make(map[string]string, len(tags))
+	for k, v := range tags {
+		out[k] = v
+	}
+	return out
+}
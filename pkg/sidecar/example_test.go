@@ -0,0 +1,76 @@
+package sidecar_test
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/yaat-app/sidecar/pkg/sidecar"
+)
+
+func Example() {
+	emitter, err := sidecar.New(sidecar.Config{
+		APIKey:      "yaat_...",
+		ServiceName: "billing-agent",
+		Environment: "production",
+		Tags:        map[string]string{"region": "eu-west-1"},
+		QueueDir:    "/var/lib/billing-agent/queue",
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		emitter.Close(ctx)
+	}()
+
+	emitter.Log(sidecar.Log{Level: "info", Message: "invoice run started"})
+	emitter.Metric(sidecar.Metric{Name: "invoices.pending", Value: 42})
+}
+
+func ExampleLoadConfig() {
+	// Reuse an existing yaat.yaml, scrubbing rules included
+	cfg, err := sidecar.LoadConfig("/etc/yaat/yaat.yaml")
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg.QueueDir = "/var/lib/billing-agent/queue"
+
+	emitter, err := sidecar.New(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer emitter.Close(context.Background())
+}
+
+func ExampleEmitter_Span() {
+	var emitter *sidecar.Emitter // From sidecar.New
+
+	start := time.Now()
+	// ... handle the request ...
+	emitter.Span(sidecar.Span{
+		Timestamp:  start,
+		TraceID:    "4bf92f3577b34da6a3ce929d0e0e4736",
+		SpanID:     "00f067aa0ba902b7",
+		Operation:  "POST /invoices",
+		Duration:   time.Since(start),
+		StatusCode: 201,
+	})
+}
+
+func ExampleScrubbingConfig() {
+	cfg := sidecar.Config{
+		APIKey:      "yaat_...",
+		ServiceName: "billing-agent",
+		Scrubbing: sidecar.ScrubbingConfig{
+			Enabled: true,
+			Rules: []sidecar.ScrubRule{
+				{Name: "card", Pattern: `\b\d{13,16}\b`, Replacement: "[CARD]"},
+				{Name: "health", Pattern: "^GET /healthz", Drop: true},
+			},
+			TagAllowlist: []string{"region", "http.*"},
+		},
+	}
+	_ = cfg
+}